@@ -0,0 +1,384 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package backupcmd exports and imports the encrypted backups of wallets through the API of a wallet server.
+package backupcmd
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/trustbloc/edge-core/pkg/log"
+	cmdutils "github.com/trustbloc/edge-core/pkg/utils/cmd"
+	tlsutils "github.com/trustbloc/edge-core/pkg/utils/tls"
+
+	"github.com/trustbloc/wallet/pkg/restapi/common"
+)
+
+const (
+	serverURLFlagName  = "server-url"
+	serverURLEnvKey    = "WALLET_SERVER_URL"
+	serverURLFlagUsage = "URL of the wallet server." +
+		" Alternatively, this can be set with the following environment variable: " + serverURLEnvKey
+
+	apiTokenFlagName  = "api-token"
+	apiTokenEnvKey    = "WALLET_SERVER_API_TOKEN"
+	apiTokenFlagUsage = "API token of the wallet server with the wallet scope, if its API is authenticated." +
+		" Alternatively, this can be set with the following environment variable: " + apiTokenEnvKey
+
+	userIDFlagName  = "user-id"
+	userIDEnvKey    = "WALLET_BACKUP_USER_ID"
+	userIDFlagUsage = "ID of the user of the wallet." +
+		" Alternatively, this can be set with the following environment variable: " + userIDEnvKey
+
+	authFlagName  = "auth"
+	authEnvKey    = "WALLET_BACKUP_AUTH"
+	authFlagUsage = "Auth token of the unlocked wallet. Either this or the local KMS passphrase is required." +
+		" Alternatively, this can be set with the following environment variable: " + authEnvKey
+
+	localKMSPassphraseFlagName  = "local-kms-passphrase"
+	localKMSPassphraseEnvKey    = "WALLET_BACKUP_LOCAL_KMS_PASSPHRASE"
+	localKMSPassphraseFlagUsage = "Passphrase of the local KMS of the wallet, with which the wallet is unlocked" +
+		" for the backup and locked afterwards." +
+		" Alternatively, this can be set with the following environment variable: " + localKMSPassphraseEnvKey
+
+	passphraseFlagName  = "passphrase"
+	passphraseEnvKey    = "WALLET_BACKUP_PASSPHRASE"
+	passphraseFlagUsage = "Passphrase sealing the backup." +
+		" Alternatively, this can be set with the following environment variable: " + passphraseEnvKey
+
+	keyURLFlagName  = "key-url"
+	keyURLEnvKey    = "WALLET_BACKUP_KEY_URL"
+	keyURLFlagUsage = "URL of the key of the ops keystore of the user sealing the backup, instead of a passphrase." +
+		" Alternatively, this can be set with the following environment variable: " + keyURLEnvKey
+
+	kmsAuthTokenFlagName  = "kms-auth-token"
+	kmsAuthTokenEnvKey    = "WALLET_BACKUP_KMS_AUTH_TOKEN"
+	kmsAuthTokenFlagUsage = "Bearer token authorizing the requests to the ops keystore of the user." +
+		" Alternatively, this can be set with the following environment variable: " + kmsAuthTokenEnvKey
+
+	fileFlagName  = "file"
+	fileEnvKey    = "WALLET_BACKUP_FILE"
+	fileFlagUsage = "Path of the archive file written by export and read by import." +
+		" Alternatively, this can be set with the following environment variable: " + fileEnvKey
+
+	modeFlagName  = "mode"
+	modeEnvKey    = "WALLET_BACKUP_MODE"
+	modeFlagUsage = "Import mode: merge keeps the contents already in the wallet, overwrite replaces them." +
+		" Default: merge." +
+		" Alternatively, this can be set with the following environment variable: " + modeEnvKey
+
+	tlsCACertsFlagName  = "tls-cacerts"
+	tlsCACertsEnvKey    = "TLS_CACERTS"
+	tlsCACertsFlagUsage = "Comma-Separated list of ca certs path." +
+		" Alternatively, this can be set with the following environment variable: " + tlsCACertsEnvKey
+)
+
+// paths of the wallet API of the wallet server.
+const (
+	openPath         = "/wallet/open"
+	closePath        = "/wallet/close"
+	exportBackupPath = "/wallet/backup/export"
+	importBackupPath = "/wallet/backup/import"
+)
+
+const archiveFileMode = 0o600
+
+var logger = log.New("wallet-server/backup")
+
+type backupParameters struct {
+	serverURL          string
+	apiToken           string
+	userID             string
+	auth               string
+	localKMSPassphrase string
+	passphrase         string
+	kmsAuthToken       string
+	file               string
+	httpClient         *http.Client
+}
+
+type kmsAuth struct {
+	AuthToken string `json:"authToken,omitempty"`
+}
+
+type exportBackupReq struct {
+	UserID     string   `json:"userID"`
+	Auth       string   `json:"auth"`
+	Passphrase string   `json:"passphrase,omitempty"`
+	KeyURL     string   `json:"keyURL,omitempty"`
+	KMSAuth    *kmsAuth `json:"kmsAuth,omitempty"`
+}
+
+type importBackupReq struct {
+	UserID     string          `json:"userID"`
+	Auth       string          `json:"auth"`
+	Archive    json.RawMessage `json:"archive"`
+	Passphrase string          `json:"passphrase,omitempty"`
+	KMSAuth    *kmsAuth        `json:"kmsAuth,omitempty"`
+	Mode       string          `json:"mode,omitempty"`
+}
+
+type importBackupResp struct {
+	Added    []string `json:"added"`
+	Replaced []string `json:"replaced"`
+	Skipped  []string `json:"skipped"`
+	Excluded []string `json:"excluded,omitempty"`
+}
+
+// GetBackupCmd returns the Cobra backup command, exporting and importing the encrypted backups of wallets.
+func GetBackupCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "backup",
+		Short: "Export and import encrypted wallet backups",
+		Long: "Export the contents of a wallet as an archive sealed with a passphrase or with a key of the ops" +
+			" keystore of the user, or import such an archive into a wallet, through the API of a wallet server.",
+	}
+
+	cmd.AddCommand(getExportCmd(), getImportCmd())
+
+	return cmd
+}
+
+func getExportCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Export an encrypted wallet backup",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			params, err := getBackupParameters(cmd)
+			if err != nil {
+				return err
+			}
+
+			keyURL, err := cmdutils.GetUserSetVarFromString(cmd, keyURLFlagName, keyURLEnvKey, true)
+			if err != nil {
+				return err
+			}
+
+			if (params.passphrase == "") == (keyURL == "") {
+				return errors.New("either a passphrase or a key URL is required")
+			}
+
+			return params.withUnlockedWallet(func(auth string) error {
+				req := &exportBackupReq{
+					UserID: params.userID, Auth: auth, Passphrase: params.passphrase, KeyURL: keyURL,
+					KMSAuth: params.kmsAuth(),
+				}
+
+				archive, err := params.post(exportBackupPath, req)
+				if err != nil {
+					return fmt.Errorf("failed to export backup: %w", err)
+				}
+
+				err = ioutil.WriteFile(params.file, archive, archiveFileMode)
+				if err != nil {
+					return fmt.Errorf("failed to write backup: %w", err)
+				}
+
+				fmt.Fprintf(cmd.OutOrStdout(), "exported wallet of user %s to %s\n", params.userID, params.file)
+
+				return nil
+			})
+		},
+	}
+
+	createBackupFlags(cmd)
+	cmd.Flags().StringP(keyURLFlagName, "", "", keyURLFlagUsage)
+
+	return cmd
+}
+
+func getImportCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "import",
+		Short: "Import an encrypted wallet backup",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			params, err := getBackupParameters(cmd)
+			if err != nil {
+				return err
+			}
+
+			mode, err := cmdutils.GetUserSetVarFromString(cmd, modeFlagName, modeEnvKey, true)
+			if err != nil {
+				return err
+			}
+
+			if params.passphrase == "" && params.kmsAuthToken == "" {
+				return errors.New("either a passphrase or a KMS auth token is required")
+			}
+
+			archive, err := ioutil.ReadFile(params.file)
+			if err != nil {
+				return fmt.Errorf("failed to read backup: %w", err)
+			}
+
+			return params.withUnlockedWallet(func(auth string) error {
+				req := &importBackupReq{
+					UserID: params.userID, Auth: auth, Archive: archive, Passphrase: params.passphrase,
+					KMSAuth: params.kmsAuth(), Mode: mode,
+				}
+
+				respBody, err := params.post(importBackupPath, req)
+				if err != nil {
+					return fmt.Errorf("failed to import backup: %w", err)
+				}
+
+				resp := &importBackupResp{}
+
+				err = json.Unmarshal(respBody, resp)
+				if err != nil {
+					return fmt.Errorf("invalid import response: %w", err)
+				}
+
+				fmt.Fprintf(cmd.OutOrStdout(), "imported %s into wallet of user %s: %d added, %d replaced, %d skipped\n",
+					params.file, params.userID, len(resp.Added), len(resp.Replaced), len(resp.Skipped))
+
+				if len(resp.Excluded) > 0 {
+					fmt.Fprintf(cmd.OutOrStdout(), "excluded from the backup: %s\n", strings.Join(resp.Excluded, ", "))
+				}
+
+				return nil
+			})
+		},
+	}
+
+	createBackupFlags(cmd)
+	cmd.Flags().StringP(modeFlagName, "", "", modeFlagUsage)
+
+	return cmd
+}
+
+func createBackupFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP(serverURLFlagName, "", "", serverURLFlagUsage)
+	cmd.Flags().StringP(apiTokenFlagName, "", "", apiTokenFlagUsage)
+	cmd.Flags().StringP(userIDFlagName, "", "", userIDFlagUsage)
+	cmd.Flags().StringP(authFlagName, "", "", authFlagUsage)
+	cmd.Flags().StringP(localKMSPassphraseFlagName, "", "", localKMSPassphraseFlagUsage)
+	cmd.Flags().StringP(passphraseFlagName, "", "", passphraseFlagUsage)
+	cmd.Flags().StringP(kmsAuthTokenFlagName, "", "", kmsAuthTokenFlagUsage)
+	cmd.Flags().StringP(fileFlagName, "", "", fileFlagUsage)
+	cmd.Flags().StringArrayP(tlsCACertsFlagName, "", []string{}, tlsCACertsFlagUsage)
+}
+
+func getBackupParameters(cmd *cobra.Command) (*backupParameters, error) {
+	params := &backupParameters{}
+
+	for _, v := range []struct {
+		value    *string
+		flagName string
+		envKey   string
+		optional bool
+	}{
+		{&params.serverURL, serverURLFlagName, serverURLEnvKey, false},
+		{&params.apiToken, apiTokenFlagName, apiTokenEnvKey, true},
+		{&params.userID, userIDFlagName, userIDEnvKey, false},
+		{&params.auth, authFlagName, authEnvKey, true},
+		{&params.localKMSPassphrase, localKMSPassphraseFlagName, localKMSPassphraseEnvKey, true},
+		{&params.passphrase, passphraseFlagName, passphraseEnvKey, true},
+		{&params.kmsAuthToken, kmsAuthTokenFlagName, kmsAuthTokenEnvKey, true},
+		{&params.file, fileFlagName, fileEnvKey, false},
+	} {
+		value, err := cmdutils.GetUserSetVarFromString(cmd, v.flagName, v.envKey, v.optional)
+		if err != nil {
+			return nil, err
+		}
+
+		*v.value = value
+	}
+
+	if (params.auth == "") == (params.localKMSPassphrase == "") {
+		return nil, errors.New("either an auth token or a local KMS passphrase is required")
+	}
+
+	params.serverURL = strings.TrimSuffix(params.serverURL, "/")
+	params.httpClient = &http.Client{}
+
+	rootCAs, err := cmdutils.GetUserSetVarFromArrayString(cmd, tlsCACertsFlagName, tlsCACertsEnvKey, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure root CAs: %w", err)
+	}
+
+	if len(rootCAs) > 0 {
+		certPool, err := tlsutils.GetCertPool(false, rootCAs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to init tls cert pool: %w", err)
+		}
+
+		params.httpClient.Transport = &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:    certPool,
+			MinVersion: tls.VersionTLS12,
+		}}
+	}
+
+	return params, nil
+}
+
+// withUnlockedWallet calls f with the auth token of the wallet of the user. The wallet is unlocked with the local
+// KMS passphrase and locked afterwards if no auth token is given.
+func (p *backupParameters) withUnlockedWallet(f func(auth string) error) error {
+	if p.auth != "" {
+		return f(p.auth)
+	}
+
+	respBody, err := p.post(openPath, map[string]string{"userID": p.userID, "localKMSPassphrase": p.localKMSPassphrase})
+	if err != nil {
+		return fmt.Errorf("failed to unlock wallet: %w", err)
+	}
+
+	unlocked := &struct {
+		Token string `json:"token"`
+	}{}
+
+	err = json.Unmarshal(respBody, unlocked)
+	if err != nil {
+		return fmt.Errorf("invalid unlock response: %w", err)
+	}
+
+	defer func() {
+		if _, e := p.post(closePath, map[string]string{"userID": p.userID}); e != nil {
+			logger.Warnf("failed to lock wallet: %s", e.Error())
+		}
+	}()
+
+	return f(unlocked.Token)
+}
+
+func (p *backupParameters) kmsAuth() *kmsAuth {
+	if p.kmsAuthToken == "" {
+		return nil
+	}
+
+	return &kmsAuth{AuthToken: p.kmsAuthToken}
+}
+
+func (p *backupParameters) post(path string, body interface{}) ([]byte, error) {
+	reqBytes, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, p.serverURL+path, bytes.NewReader(reqBytes))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	if p.apiToken != "" {
+		req.Header.Set("Authorization", "Bearer "+p.apiToken)
+	}
+
+	respBody, _, err := common.SendHTTPRequest(req, p.httpClient, http.StatusOK, logger)
+
+	return respBody, err
+}
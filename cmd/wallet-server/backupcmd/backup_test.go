@@ -0,0 +1,190 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package backupcmd // nolint:testpackage // using private types in tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	sampleUserID   = "user"
+	sampleAPIToken = "api-token"
+	sampleArchive  = `{"version":1,"sealing":{"method":"passphrase"}}`
+)
+
+func TestBackupCmd(t *testing.T) {
+	t.Run("export and import a backup unlocking the wallet", func(t *testing.T) {
+		server := newWalletServer(t)
+		defer server.Close()
+
+		file := filepath.Join(t.TempDir(), "backup.json")
+
+		out, err := execute("export", "--server-url", server.URL+"/", "--api-token", sampleAPIToken,
+			"--user-id", sampleUserID, "--local-kms-passphrase", "local", "--passphrase", "secret", "--file", file)
+		require.NoError(t, err)
+		require.Contains(t, out, "exported wallet of user user")
+
+		archive, err := ioutil.ReadFile(file) // nolint:gosec // path of the test file
+		require.NoError(t, err)
+		require.JSONEq(t, sampleArchive, string(archive))
+
+		out, err = execute("import", "--server-url", server.URL, "--api-token", sampleAPIToken,
+			"--user-id", sampleUserID, "--local-kms-passphrase", "local", "--passphrase", "secret", "--file", file,
+			"--mode", "overwrite")
+		require.NoError(t, err)
+		require.Contains(t, out, "1 added, 2 replaced, 0 skipped")
+		require.Contains(t, out, "excluded from the backup: d")
+
+		require.Equal(t, []string{openPath, exportBackupPath, closePath, openPath, importBackupPath, closePath},
+			server.paths)
+		require.Equal(t, "overwrite", server.importReq.Mode)
+		require.JSONEq(t, sampleArchive, string(server.importReq.Archive))
+	})
+
+	t.Run("export and import a backup with a key of the ops keystore", func(t *testing.T) {
+		server := newWalletServer(t)
+		defer server.Close()
+
+		file := filepath.Join(t.TempDir(), "backup.json")
+
+		_, err := execute("export", "--server-url", server.URL, "--api-token", sampleAPIToken,
+			"--user-id", sampleUserID, "--auth", "token", "--key-url", "https://kms.example.com/keys/1",
+			"--kms-auth-token", "kms-token", "--file", file)
+		require.NoError(t, err)
+		require.Equal(t, "https://kms.example.com/keys/1", server.exportReq.KeyURL)
+		require.Equal(t, "kms-token", server.exportReq.KMSAuth.AuthToken)
+
+		_, err = execute("import", "--server-url", server.URL, "--api-token", sampleAPIToken,
+			"--user-id", sampleUserID, "--auth", "token", "--kms-auth-token", "kms-token", "--file", file)
+		require.NoError(t, err)
+		require.Equal(t, "kms-token", server.importReq.KMSAuth.AuthToken)
+
+		require.Equal(t, []string{exportBackupPath, importBackupPath}, server.paths)
+	})
+
+	t.Run("error if the server fails", func(t *testing.T) {
+		server := newWalletServer(t)
+		defer server.Close()
+
+		file := filepath.Join(t.TempDir(), "backup.json")
+
+		_, err := execute("export", "--server-url", server.URL, "--api-token", "invalid",
+			"--user-id", sampleUserID, "--auth", "token", "--passphrase", "secret", "--file", file)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to export backup")
+
+		_, err = execute("export", "--server-url", server.URL, "--api-token", "invalid",
+			"--user-id", sampleUserID, "--local-kms-passphrase", "local", "--passphrase", "secret", "--file", file)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to unlock wallet")
+
+		_, err = execute("import", "--server-url", server.URL, "--api-token", sampleAPIToken,
+			"--user-id", sampleUserID, "--auth", "token", "--passphrase", "secret", "--file", file)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to read backup")
+	})
+
+	t.Run("error if arguments are missing", func(t *testing.T) {
+		_, err := execute("export", "--user-id", sampleUserID)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "Neither server-url (command line flag) nor WALLET_SERVER_URL")
+
+		_, err = execute("export", "--server-url", "https://wallet.example.com", "--user-id", sampleUserID,
+			"--file", "backup.json", "--passphrase", "secret")
+		require.EqualError(t, err, "either an auth token or a local KMS passphrase is required")
+
+		_, err = execute("export", "--server-url", "https://wallet.example.com", "--user-id", sampleUserID,
+			"--file", "backup.json", "--auth", "token")
+		require.EqualError(t, err, "either a passphrase or a key URL is required")
+
+		_, err = execute("import", "--server-url", "https://wallet.example.com", "--user-id", sampleUserID,
+			"--file", "backup.json", "--auth", "token")
+		require.EqualError(t, err, "either a passphrase or a KMS auth token is required")
+
+		_, err = execute("export", "--server-url", "https://wallet.example.com", "--user-id", sampleUserID,
+			"--file", "backup.json", "--auth", "token", "--passphrase", "secret", "--tls-cacerts", "invalid")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to init tls cert pool")
+	})
+}
+
+func execute(args ...string) (string, error) {
+	out := &bytes.Buffer{}
+
+	cmd := GetBackupCmd()
+	cmd.SetArgs(args)
+	cmd.SetOut(out)
+	cmd.SetErr(out)
+
+	err := cmd.Execute()
+
+	return out.String(), err
+}
+
+type walletServer struct {
+	*httptest.Server
+	paths     []string
+	exportReq *exportBackupReq
+	importReq *importBackupReq
+}
+
+// newWalletServer returns a server of the wallet API recording the requests authorized with the sample API token.
+func newWalletServer(t *testing.T) *walletServer {
+	t.Helper()
+
+	s := &walletServer{}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+sampleAPIToken {
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		s.paths = append(s.paths, r.URL.Path)
+
+		var resp interface{}
+
+		switch r.URL.Path {
+		case openPath:
+			resp = map[string]string{"token": "token"}
+		case closePath:
+			resp = map[string]bool{"closed": true}
+		case exportBackupPath:
+			s.exportReq = &exportBackupReq{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(s.exportReq))
+			require.Equal(t, "token", s.exportReq.Auth)
+
+			resp = json.RawMessage(sampleArchive)
+		case importBackupPath:
+			s.importReq = &importBackupReq{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(s.importReq))
+			require.Equal(t, "token", s.importReq.Auth)
+
+			resp = &importBackupResp{
+				Added: []string{"a"}, Replaced: []string{"b", "c"}, Skipped: []string{},
+				Excluded: []string{"d"},
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		require.NoError(t, json.NewEncoder(w).Encode(resp))
+	}))
+
+	return s
+}
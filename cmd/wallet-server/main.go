@@ -27,6 +27,7 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/trustbloc/edge-core/pkg/log"
 
+	"github.com/trustbloc/wallet/cmd/wallet-server/backupcmd"
 	"github.com/trustbloc/wallet/cmd/wallet-server/startcmd"
 )
 
@@ -41,6 +42,7 @@ func main() {
 	}
 
 	rootCmd.AddCommand(startcmd.GetStartCmd(&startcmd.HTTPServer{}))
+	rootCmd.AddCommand(backupcmd.GetBackupCmd())
 
 	if err := rootCmd.Execute(); err != nil {
 		logger.Fatalf("Failed to run http server: %s", err.Error())
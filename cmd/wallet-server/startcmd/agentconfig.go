@@ -27,6 +27,8 @@ import (
 	ariesstorage "github.com/hyperledger/aries-framework-go/spi/storage"
 	"github.com/spf13/cobra"
 	cmdutils "github.com/trustbloc/edge-core/pkg/utils/cmd"
+
+	"github.com/trustbloc/wallet/pkg/restapi/wallet/operation"
 )
 
 const (
@@ -37,6 +39,14 @@ const (
 	agentTokenFlagUsage     = "Check for bearer token in the authorization header (optional)." +
 		" Alternatively, this can be set with the following environment variable: " + agentTokenEnvKey
 
+	agentTokensFileFlagName  = "api-tokens-file"
+	agentTokensFileEnvKey    = "ARIESD_API_TOKENS_FILE" // nolint:gosec // this is just an ENV variable name
+	agentTokensFileFlagUsage = "Path to a JSON file with named bearer tokens that authorize calls to the wallet and" +
+		" admin APIs: a list of objects with a name, a token and scopes ([wallet] and/or [admin])." +
+		" Wallet apps in browsers call the wallet API with the session of the logged-in user instead." +
+		" Changes to the file are picked up without a restart." +
+		" Alternatively, this can be set with the following environment variable: " + agentTokensFileEnvKey
+
 	databaseTypeFlagName      = "database-type"
 	databaseTypeEnvKey        = "ARIESD_DATABASE_TYPE"
 	databaseTypeFlagShorthand = "q"
@@ -154,6 +164,59 @@ const (
 		" Alternatively, this can be set with the following environment variable (in CSV format): " +
 		agentContextProviderEnvKey
 
+	// credential status sweep interval flag.
+	agentStatusSweepIntervalFlagName  = "credential-status-sweep-interval"
+	agentStatusSweepIntervalEnvKey    = "ARIESD_CREDENTIAL_STATUS_SWEEP_INTERVAL"
+	agentStatusSweepIntervalFlagUsage = "Interval in seconds at which the statuses of the credentials stored in" +
+		" wallets are checked for revocation and suspension. Default: " + agentStatusSweepIntervalDefault +
+		" seconds. Set to 0 to disable." +
+		" Alternatively, this can be set with the following environment variable: " + agentStatusSweepIntervalEnvKey
+	agentStatusSweepIntervalDefault = "3600"
+
+	// credential expiry sweep interval flag.
+	agentExpirySweepIntervalFlagName  = "credential-expiry-sweep-interval"
+	agentExpirySweepIntervalEnvKey    = "ARIESD_CREDENTIAL_EXPIRY_SWEEP_INTERVAL"
+	agentExpirySweepIntervalFlagUsage = "Interval in seconds at which the expiration dates of the credentials stored" +
+		" in wallets are checked, to notify the credentials expiring within a lead time. Default: " +
+		agentExpirySweepIntervalDefault + " seconds. Set to 0 to disable." +
+		" Alternatively, this can be set with the following environment variable: " + agentExpirySweepIntervalEnvKey
+	agentExpirySweepIntervalDefault = "3600"
+
+	// credential expiry lead times flag.
+	agentExpiryLeadTimesFlagName  = "credential-expiry-lead-times"
+	agentExpiryLeadTimesEnvKey    = "ARIESD_CREDENTIAL_EXPIRY_LEAD_TIMES"
+	agentExpiryLeadTimesFlagUsage = "Lead times in days before their expiry at which the credentials stored in" +
+		" wallets are notified, comma-separated. This flag can be repeated. Default: 30,7,1." +
+		" Alternatively, this can be set with the following environment variable (in CSV format): " +
+		agentExpiryLeadTimesEnvKey
+
+	// mediator invitation url flag.
+	agentMediatorInvitationURLFlagName  = "mediator-invitation-url"
+	agentMediatorInvitationURLEnvKey    = "ARIESD_MEDIATOR_INVITATION_URL"
+	agentMediatorInvitationURLFlagUsage = "URL of the out-of-band invitation of the mediator the agent registers" +
+		" with through route coordination, so that it receives DIDComm messages without a reachable inbound host." +
+		" The websocket outbound transport is then enabled, and the transport return route defaults to all." +
+		" Alternatively, this can be set with the following environment variable: " + agentMediatorInvitationURLEnvKey
+
+	// mediator message pickup interval flag.
+	agentMediatorPickupIntervalFlagName  = "mediator-pickup-interval"
+	agentMediatorPickupIntervalEnvKey    = "ARIESD_MEDIATOR_PICKUP_INTERVAL"
+	agentMediatorPickupIntervalFlagUsage = "Interval in seconds at which the messages queued by the mediator are" +
+		" picked up. Default: " + agentMediatorPickupIntervalDefault + " seconds. Set to 0 to disable." +
+		" Alternatively, this can be set with the following environment variable: " + agentMediatorPickupIntervalEnvKey
+	agentMediatorPickupIntervalDefault = "10"
+
+	// act as mediator flag.
+	agentActAsMediatorFlagName  = "act-as-mediator"
+	agentActAsMediatorEnvKey    = "ARIESD_ACT_AS_MEDIATOR"
+	agentActAsMediatorFlagUsage = "Set to true to act as a mediator for other agents, granting their route" +
+		" coordination requests. Default: false." +
+		" Alternatively, this can be set with the following environment variable: " + agentActAsMediatorEnvKey
+
+	transportReturnRouteAll = "all"
+
+	hoursPerDay = 24
+
 	httpProtocol      = "http"
 	websocketProtocol = "ws"
 
@@ -169,6 +232,7 @@ type agentParameters struct {
 	defaultLabel         string
 	transportReturnRoute string
 	token                string
+	tokensFile           string
 	trustblocDomain      string
 	trustblocResolver    string
 	webhookURLs          []string
@@ -180,6 +244,13 @@ type agentParameters struct {
 	msgHandler           command.MessageHandler
 	dbParam              *dbParam
 	websocketReadLimit   int64
+	statusSweepInterval  time.Duration
+	expirySweepInterval  time.Duration
+	expiryLeadTimes      []time.Duration
+	mediatorURL          string
+	mediatorPickup       time.Duration
+	actAsMediator        bool
+	didKeys              *operation.DIDKeys
 }
 
 type dbParam struct {
@@ -196,6 +267,11 @@ func getAgentParams(cmd *cobra.Command) (*agentParameters, error) {
 		return nil, err
 	}
 
+	tokensFile, err := cmdutils.GetUserSetVarFromString(cmd, agentTokensFileFlagName, agentTokensFileEnvKey, true)
+	if err != nil {
+		return nil, err
+	}
+
 	inboundHosts, err := cmdutils.GetUserSetVarFromArrayString(cmd, agentInboundHostFlagName, agentInboundHostEnvKey, true)
 	if err != nil {
 		return nil, err
@@ -265,8 +341,48 @@ func getAgentParams(cmd *cobra.Command) (*agentParameters, error) {
 		return nil, err
 	}
 
+	statusSweepInterval, err := getStatusSweepInterval(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	expirySweepInterval, err := getExpirySweepInterval(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	expiryLeadTimes, err := getExpiryLeadTimes(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	mediatorURL, err := cmdutils.GetUserSetVarFromString(cmd, agentMediatorInvitationURLFlagName,
+		agentMediatorInvitationURLEnvKey, true)
+	if err != nil {
+		return nil, err
+	}
+
+	mediatorPickup, err := getMediatorPickupInterval(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	actAsMediator, err := getActAsMediator(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	if mediatorURL != "" {
+		outboundTransports = appendMissing(outboundTransports, websocketProtocol)
+
+		if transportReturnRoute == "" {
+			transportReturnRoute = transportReturnRouteAll
+		}
+	}
+
 	return &agentParameters{
 		token:                token,
+		tokensFile:           tokensFile,
 		inboundHostInternals: inboundHosts,
 		inboundHostExternals: inboundHostExternals,
 		dbParam:              dbParam,
@@ -279,6 +395,12 @@ func getAgentParams(cmd *cobra.Command) (*agentParameters, error) {
 		transportReturnRoute: transportReturnRoute,
 		contextProviderURLs:  contextProviderURLs,
 		websocketReadLimit:   websocketReadLimit,
+		statusSweepInterval:  statusSweepInterval,
+		expirySweepInterval:  expirySweepInterval,
+		expiryLeadTimes:      expiryLeadTimes,
+		mediatorURL:          mediatorURL,
+		mediatorPickup:       mediatorPickup,
+		actAsMediator:        actAsMediator,
 	}, nil
 }
 
@@ -340,9 +462,119 @@ func getWebSocketReadLimit(cmd *cobra.Command) (int64, error) {
 	return readLimit, nil
 }
 
+func getStatusSweepInterval(cmd *cobra.Command) (time.Duration, error) {
+	intervalVal, err := cmdutils.GetUserSetVarFromString(cmd, agentStatusSweepIntervalFlagName,
+		agentStatusSweepIntervalEnvKey, true)
+	if err != nil {
+		return 0, err
+	}
+
+	if intervalVal == "" {
+		intervalVal = agentStatusSweepIntervalDefault
+	}
+
+	interval, err := strconv.ParseUint(intervalVal, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse credential status sweep interval %s: %w", intervalVal, err)
+	}
+
+	return time.Duration(interval) * time.Second, nil
+}
+
+func getExpirySweepInterval(cmd *cobra.Command) (time.Duration, error) {
+	intervalVal, err := cmdutils.GetUserSetVarFromString(cmd, agentExpirySweepIntervalFlagName,
+		agentExpirySweepIntervalEnvKey, true)
+	if err != nil {
+		return 0, err
+	}
+
+	if intervalVal == "" {
+		intervalVal = agentExpirySweepIntervalDefault
+	}
+
+	interval, err := strconv.ParseUint(intervalVal, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse credential expiry sweep interval %s: %w", intervalVal, err)
+	}
+
+	return time.Duration(interval) * time.Second, nil
+}
+
+func getExpiryLeadTimes(cmd *cobra.Command) ([]time.Duration, error) {
+	leadTimeVals, err := cmdutils.GetUserSetVarFromArrayString(cmd, agentExpiryLeadTimesFlagName,
+		agentExpiryLeadTimesEnvKey, true)
+	if err != nil {
+		return nil, err
+	}
+
+	var leadTimes []time.Duration
+
+	for _, leadTimeVal := range strings.Split(strings.Join(leadTimeVals, ","), ",") {
+		if strings.TrimSpace(leadTimeVal) == "" {
+			continue
+		}
+
+		days, err := strconv.ParseUint(strings.TrimSpace(leadTimeVal), 10, 16)
+		if err != nil || days == 0 {
+			return nil, fmt.Errorf("invalid credential expiry lead time %s: must be a positive number of days",
+				leadTimeVal)
+		}
+
+		leadTimes = append(leadTimes, time.Duration(days)*hoursPerDay*time.Hour)
+	}
+
+	return leadTimes, nil
+}
+
+func getMediatorPickupInterval(cmd *cobra.Command) (time.Duration, error) {
+	intervalVal, err := cmdutils.GetUserSetVarFromString(cmd, agentMediatorPickupIntervalFlagName,
+		agentMediatorPickupIntervalEnvKey, true)
+	if err != nil {
+		return 0, err
+	}
+
+	if intervalVal == "" {
+		intervalVal = agentMediatorPickupIntervalDefault
+	}
+
+	interval, err := strconv.ParseUint(intervalVal, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse mediator pickup interval %s: %w", intervalVal, err)
+	}
+
+	return time.Duration(interval) * time.Second, nil
+}
+
+func getActAsMediator(cmd *cobra.Command) (bool, error) {
+	actAsMediatorVal, err := cmdutils.GetUserSetVarFromString(cmd, agentActAsMediatorFlagName,
+		agentActAsMediatorEnvKey, true)
+	if err != nil || actAsMediatorVal == "" {
+		return false, err
+	}
+
+	actAsMediator, err := strconv.ParseBool(actAsMediatorVal)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse act as mediator %s: %w", actAsMediatorVal, err)
+	}
+
+	return actAsMediator, nil
+}
+
+// appendMissing appends a value to a list of values if it is not already in it.
+func appendMissing(values []string, value string) []string {
+	for _, v := range values {
+		if v == value {
+			return values
+		}
+	}
+
+	return append(values, value)
+}
+
 func createAgentFlags(cmd *cobra.Command) {
 	// agent token flag
 	cmd.Flags().StringP(agentTokenFlagName, agentTokenFlagShorthand, "", agentTokenFlagUsage)
+	cmd.Flags().StringP(agentTokensFileFlagName, "", "", agentTokensFileFlagUsage)
 
 	// inbound host flag
 	cmd.Flags().StringSliceP(agentInboundHostFlagName, agentInboundHostFlagShorthand, []string{},
@@ -394,6 +626,18 @@ func createAgentFlags(cmd *cobra.Command) {
 
 	// websocket read limit flag
 	cmd.Flags().StringP(agentWebSocketReadLimitFlagName, "", "", agentWebSocketReadLimitFlagUsage)
+
+	// credential status sweep interval flag
+	cmd.Flags().StringP(agentStatusSweepIntervalFlagName, "", "", agentStatusSweepIntervalFlagUsage)
+
+	// credential expiry flags
+	cmd.Flags().StringP(agentExpirySweepIntervalFlagName, "", "", agentExpirySweepIntervalFlagUsage)
+	cmd.Flags().StringArrayP(agentExpiryLeadTimesFlagName, "", []string{}, agentExpiryLeadTimesFlagUsage)
+
+	// mediator flags
+	cmd.Flags().StringP(agentMediatorInvitationURLFlagName, "", "", agentMediatorInvitationURLFlagUsage)
+	cmd.Flags().StringP(agentMediatorPickupIntervalFlagName, "", "", agentMediatorPickupIntervalFlagUsage)
+	cmd.Flags().StringP(agentActAsMediatorFlagName, "", "", agentActAsMediatorFlagUsage)
 }
 
 func createStoreProviders(params *dbParam) (ariesstorage.Provider, error) {
@@ -454,7 +698,7 @@ func createAriesAgent(parameters *httpServerParameters) (*context.Provider, erro
 
 	opts = append(opts, inboundTransportOpt...)
 
-	VDRs, err := createVDRs(agentParams.httpResolvers, agentParams.trustblocDomain)
+	VDRs, err := createVDRs(agentParams.httpResolvers, agentParams.trustblocDomain, agentParams.didKeys)
 	if err != nil {
 		return nil, err
 	}
@@ -538,7 +782,9 @@ func getInboundSchemeToURLMap(schemeHostStr []string) (map[string]string, error)
 	return schemeHostMap, nil
 }
 
-func createVDRs(resolvers []string, trustblocDomain string) ([]vdr.VDR, error) {
+// createVDRs returns the VDRs of the resolvers, and the orb VDR, which signs the operations of the did:orb DIDs of
+// wallets with their keys.
+func createVDRs(resolvers []string, trustblocDomain string, didKeys *operation.DIDKeys) ([]vdr.VDR, error) {
 	const numPartsResolverOption = 2
 	// set maps resolver to its methods
 	// e.g the set of ["trustbloc@http://resolver.com", "v1@http://resolver.com"] will be
@@ -582,7 +828,7 @@ func createVDRs(resolvers []string, trustblocDomain string) ([]vdr.VDR, error) {
 		VDRs[order[url]] = resolverVDR
 	}
 
-	blocVDR, err := orb.New(nil,
+	blocVDR, err := orb.New(&orbKeyRetriever{keys: didKeys},
 		orb.WithDomain(trustblocDomain))
 	if err != nil {
 		return nil, err
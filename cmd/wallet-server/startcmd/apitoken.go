@@ -0,0 +1,266 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/trustbloc/wallet/pkg/restapi/common"
+)
+
+// API token scopes. The wallet scope authorizes every wallet route; the wallet app routes also accept the session of
+// a logged-in user instead.
+const (
+	apiScopeWallet = "wallet"
+	apiScopeAdmin  = "admin"
+)
+
+const (
+	// defaultAPITokenName is the name of the token set with --api-token.
+	defaultAPITokenName = "default"
+	// apiTokensReloadInterval is how often the tokens file is checked for changes.
+	apiTokensReloadInterval = 10 * time.Second
+)
+
+type apiToken struct {
+	Name   string   `json:"name"`
+	Token  string   `json:"token"`
+	Scopes []string `json:"scopes"`
+}
+
+func (t *apiToken) hasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// apiTokens are the bearer tokens authorizing API calls. Tokens from the tokens file are reloaded when the file
+// changes, so that they can be rotated without a restart.
+type apiTokens struct {
+	mu       sync.RWMutex
+	static   []*apiToken
+	file     string
+	fromFile []*apiToken
+	modTime  time.Time
+	size     int64
+	checked  time.Time
+	interval time.Duration
+}
+
+// newAPITokens returns the tokens set with --api-token, which has all scopes, and those in the tokens file.
+func newAPITokens(token, file string) (*apiTokens, error) {
+	a := &apiTokens{file: file, interval: apiTokensReloadInterval}
+
+	if token != "" {
+		a.static = append(a.static, &apiToken{
+			Name:   defaultAPITokenName,
+			Token:  token,
+			Scopes: []string{apiScopeWallet, apiScopeAdmin},
+		})
+	}
+
+	if file != "" {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read API tokens file: %w", err)
+		}
+
+		a.fromFile, err = parseAPITokens(file)
+		if err != nil {
+			return nil, err
+		}
+
+		a.modTime, a.size, a.checked = info.ModTime(), info.Size(), time.Now()
+	}
+
+	return a, nil
+}
+
+// enabled returns true if API calls must be authorized.
+func (a *apiTokens) enabled() bool {
+	return len(a.static) > 0 || a.file != ""
+}
+
+// authorize rejects requests that do not present a token with the given scope in their authorization header.
+func (a *apiTokens) authorize(scope string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := a.lookup(r.Header.Get("Authorization"))
+			if token == nil {
+				w.WriteHeader(http.StatusUnauthorized)
+
+				return
+			}
+
+			if !token.hasScope(scope) {
+				logger.Warnf("API token %s is not authorized for %s", token.Name, r.URL.Path)
+				w.WriteHeader(http.StatusForbidden)
+
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(common.WithAnyUser(r.Context())))
+		})
+	}
+}
+
+// authorizeOrSession is authorize, except that requests without an authorization header are also accepted with the
+// session of a logged-in user. The user is set in the context of these requests, see common.SessionUser. If API
+// tokens are not enabled, requests without a session act for any user, see common.AnyUser.
+func (a *apiTokens) authorizeOrSession(scope string,
+	sessionUser func(*http.Request) (string, bool)) mux.MiddlewareFunc {
+	authorize := a.authorize(scope)
+
+	return func(next http.Handler) http.Handler {
+		authorized := authorize(next)
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") == "" {
+				if userID, found := sessionUser(r); found {
+					next.ServeHTTP(w, r.WithContext(common.WithSessionUser(r.Context(), userID)))
+
+					return
+				}
+			}
+
+			if !a.enabled() {
+				next.ServeHTTP(w, r.WithContext(common.WithAnyUser(r.Context())))
+
+				return
+			}
+
+			authorized.ServeHTTP(w, r)
+		})
+	}
+}
+
+// lookup returns the token presented in the authorization header, or nil. Every token is compared so that the
+// time taken does not tell which token matched.
+func (a *apiTokens) lookup(header string) *apiToken {
+	const prefix = "Bearer "
+
+	if !strings.HasPrefix(header, prefix) {
+		return nil
+	}
+
+	presented := []byte(strings.TrimPrefix(header, prefix))
+
+	a.reload()
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	var match *apiToken
+
+	for _, tokens := range [][]*apiToken{a.static, a.fromFile} {
+		for _, t := range tokens {
+			if subtle.ConstantTimeCompare(presented, []byte(t.Token)) == 1 {
+				match = t
+			}
+		}
+	}
+
+	return match
+}
+
+// reload the tokens file if it changed since it was last checked. The current tokens are kept if the file cannot be
+// loaded.
+func (a *apiTokens) reload() {
+	if a.file == "" {
+		return
+	}
+
+	a.mu.RLock()
+	fresh := time.Since(a.checked) < a.interval
+	a.mu.RUnlock()
+
+	if fresh {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if time.Since(a.checked) < a.interval {
+		return
+	}
+
+	a.checked = time.Now()
+
+	info, err := os.Stat(a.file)
+	if err != nil {
+		logger.Errorf("failed to check API tokens file: %s", err.Error())
+
+		return
+	}
+
+	if info.ModTime().Equal(a.modTime) && info.Size() == a.size {
+		return
+	}
+
+	tokens, err := parseAPITokens(a.file)
+	if err != nil {
+		logger.Errorf("failed to reload API tokens: %s", err.Error())
+
+		return
+	}
+
+	a.fromFile, a.modTime, a.size = tokens, info.ModTime(), info.Size()
+
+	logger.Infof("reloaded %d API tokens from %s", len(tokens), a.file)
+}
+
+func parseAPITokens(file string) ([]*apiToken, error) {
+	bits, err := ioutil.ReadFile(filepath.Clean(file))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", file, err)
+	}
+
+	var tokens []*apiToken
+
+	err = json.Unmarshal(bits, &tokens)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", file, err)
+	}
+
+	names := make(map[string]bool, len(tokens))
+
+	for _, t := range tokens {
+		if t.Name == "" || t.Token == "" || len(t.Scopes) == 0 {
+			return nil, fmt.Errorf("invalid API token [%s]: name, token and scopes are required", t.Name)
+		}
+
+		if names[t.Name] {
+			return nil, fmt.Errorf("invalid API token [%s]: duplicate name", t.Name)
+		}
+
+		names[t.Name] = true
+
+		for _, scope := range t.Scopes {
+			if scope != apiScopeWallet && scope != apiScopeAdmin {
+				return nil, fmt.Errorf("invalid API token [%s]: unknown scope %s", t.Name, scope)
+			}
+		}
+	}
+
+	return tokens, nil
+}
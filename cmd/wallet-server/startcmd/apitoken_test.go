@@ -0,0 +1,170 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/wallet/pkg/restapi/common"
+)
+
+func TestAPITokens(t *testing.T) {
+	t.Run("the api-token has all scopes", func(t *testing.T) {
+		tokens, err := newAPITokens("secret", "")
+		require.NoError(t, err)
+		require.True(t, tokens.enabled())
+
+		for _, scope := range []string{apiScopeWallet, apiScopeAdmin} {
+			require.Equal(t, http.StatusNoContent, call(tokens, scope, "Bearer secret"))
+		}
+	})
+
+	t.Run("rejects other tokens", func(t *testing.T) {
+		tokens, err := newAPITokens("secret", "")
+		require.NoError(t, err)
+
+		for _, header := range []string{"", "Bearer other", "secret", "Bearer "} {
+			require.Equal(t, http.StatusUnauthorized, call(tokens, apiScopeAdmin, header), header)
+		}
+	})
+
+	t.Run("tokens from the file are limited to their scopes", func(t *testing.T) {
+		tokens, err := newAPITokens("", jsonFile(t, `[
+			{"name":"ui","token":"ui-secret","scopes":["wallet"]},
+			{"name":"ops","token":"ops-secret","scopes":["wallet","admin"]}
+		]`))
+		require.NoError(t, err)
+		require.True(t, tokens.enabled())
+
+		require.Equal(t, http.StatusNoContent, call(tokens, apiScopeWallet, "Bearer ui-secret"))
+		require.Equal(t, http.StatusForbidden, call(tokens, apiScopeAdmin, "Bearer ui-secret"))
+		require.Equal(t, http.StatusNoContent, call(tokens, apiScopeAdmin, "Bearer ops-secret"))
+	})
+
+	t.Run("tokens are reloaded when the file changes", func(t *testing.T) {
+		file := jsonFile(t, `[{"name":"ops","token":"old","scopes":["admin"]}]`)
+
+		tokens, err := newAPITokens("", file)
+		require.NoError(t, err)
+
+		tokens.interval = 0
+
+		require.NoError(t, ioutil.WriteFile(file, []byte(`[{"name":"ops","token":"rotated","scopes":["admin"]}]`), 0o600))
+		require.Equal(t, http.StatusNoContent, call(tokens, apiScopeAdmin, "Bearer rotated"))
+		require.Equal(t, http.StatusUnauthorized, call(tokens, apiScopeAdmin, "Bearer old"))
+
+		// an invalid file does not revoke the current tokens
+		require.NoError(t, ioutil.WriteFile(file, []byte(`invalid`), 0o600))
+		require.Equal(t, http.StatusNoContent, call(tokens, apiScopeAdmin, "Bearer rotated"))
+	})
+
+	t.Run("wallet app routes also accept user sessions", func(t *testing.T) {
+		tokens, err := newAPITokens("secret", "")
+		require.NoError(t, err)
+
+		sessionUser := func(r *http.Request) (string, bool) {
+			if r.Header.Get("Cookie") == "session" {
+				return "user", true
+			}
+
+			return "", false
+		}
+
+		var (
+			userID  string
+			anyUser bool
+		)
+
+		handler := tokens.authorizeOrSession(apiScopeWallet, sessionUser)(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				userID, _ = common.SessionUser(r.Context())
+				anyUser = common.AnyUser(r.Context())
+				w.WriteHeader(http.StatusNoContent)
+			}))
+
+		serve := func(header, cookie string) int {
+			req := httptest.NewRequest(http.MethodPost, "/wallet/profile/create", nil)
+			req.Header.Set("Authorization", header)
+			req.Header.Set("Cookie", cookie)
+
+			result := httptest.NewRecorder()
+			handler.ServeHTTP(result, req)
+
+			return result.Code
+		}
+
+		require.Equal(t, http.StatusNoContent, serve("", "session"))
+		require.Equal(t, "user", userID)
+		require.False(t, anyUser)
+
+		userID = ""
+		require.Equal(t, http.StatusNoContent, serve("Bearer secret", ""))
+		require.Empty(t, userID)
+		require.True(t, anyUser)
+
+		require.Equal(t, http.StatusUnauthorized, serve("", ""))
+		require.Equal(t, http.StatusUnauthorized, serve("", "other"))
+		require.Equal(t, http.StatusUnauthorized, serve("Bearer other", "session"))
+	})
+
+	t.Run("disabled without tokens", func(t *testing.T) {
+		tokens, err := newAPITokens("", "")
+		require.NoError(t, err)
+		require.False(t, tokens.enabled())
+
+		var anyUser bool
+
+		handler := tokens.authorizeOrSession(apiScopeWallet, func(*http.Request) (string, bool) { return "", false })(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				anyUser = common.AnyUser(r.Context())
+				w.WriteHeader(http.StatusNoContent)
+			}))
+
+		result := httptest.NewRecorder()
+		handler.ServeHTTP(result, httptest.NewRequest(http.MethodPost, "/wallet/profile/create", nil))
+		require.Equal(t, http.StatusNoContent, result.Code)
+		require.True(t, anyUser)
+	})
+
+	t.Run("error if the tokens file is invalid", func(t *testing.T) {
+		for _, test := range []struct{ contents, msg string }{
+			{contents: `invalid`, msg: "failed to parse"},
+			{contents: `[{"token":"secret","scopes":["admin"]}]`, msg: "name, token and scopes are required"},
+			{contents: `[{"name":"ops","token":"secret","scopes":["root"]}]`, msg: "unknown scope root"},
+			{
+				contents: `[{"name":"ops","token":"a","scopes":["admin"]},{"name":"ops","token":"b","scopes":["admin"]}]`,
+				msg:      "duplicate",
+			},
+		} {
+			_, err := newAPITokens("", jsonFile(t, test.contents))
+			require.Error(t, err)
+			require.Contains(t, err.Error(), test.msg)
+		}
+
+		_, err := newAPITokens("", "INVALID")
+		require.Error(t, err)
+	})
+}
+
+func call(tokens *apiTokens, scope, header string) int {
+	handler := tokens.authorize(scope)(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/wallet/profile", nil)
+	req.Header.Set("Authorization", header)
+
+	result := httptest.NewRecorder()
+	handler.ServeHTTP(result, req)
+
+	return result.Code
+}
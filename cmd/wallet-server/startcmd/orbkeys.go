@@ -0,0 +1,75 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"crypto"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/hyperledger/aries-framework-go-ext/component/vdr/orb"
+	"github.com/hyperledger/aries-framework-go-ext/component/vdr/sidetree/api"
+	"github.com/trustbloc/sidetree-core-go/pkg/jws"
+
+	"github.com/trustbloc/wallet/pkg/restapi/wallet/operation"
+)
+
+// orbKeyRetriever gives the orb VDR the keys with which wallets update and deactivate their did:orb DIDs.
+type orbKeyRetriever struct {
+	keys *operation.DIDKeys
+}
+
+func (r *orbKeyRetriever) GetNextRecoveryPublicKey(didID, _ string) (crypto.PublicKey, error) {
+	return r.keys.NextRecoveryPublicKey(didID)
+}
+
+func (r *orbKeyRetriever) GetNextUpdatePublicKey(didID, _ string) (crypto.PublicKey, error) {
+	return r.keys.NextUpdatePublicKey(didID)
+}
+
+func (r *orbKeyRetriever) GetSigner(didID string, ot orb.OperationType, _ string) (api.Signer, error) {
+	var (
+		signer *operation.DIDKeySigner
+		err    error
+	)
+
+	switch ot {
+	case orb.Update:
+		signer, err = r.keys.UpdateSigner(didID)
+	case orb.Recover:
+		signer, err = r.keys.RecoverySigner(didID)
+	default:
+		return nil, fmt.Errorf("unsupported operation type %d", ot)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &orbSigner{signer: signer}, nil
+}
+
+// orbSigner signs sidetree operations with an Ed25519 key.
+type orbSigner struct {
+	signer *operation.DIDKeySigner
+}
+
+func (s *orbSigner) Sign(data []byte) ([]byte, error) {
+	return s.signer.Sign(data)
+}
+
+func (s *orbSigner) Headers() jws.Headers {
+	return jws.Headers{jws.HeaderAlgorithm: "EdDSA"}
+}
+
+func (s *orbSigner) PublicKeyJWK() *jws.JWK {
+	return &jws.JWK{
+		Kty: "OKP",
+		Crv: "Ed25519",
+		X:   base64.RawURLEncoding.EncodeToString(s.signer.PublicKey()),
+	}
+}
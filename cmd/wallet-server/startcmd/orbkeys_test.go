@@ -0,0 +1,36 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd // nolint:testpackage // using private types in tests
+
+import (
+	"testing"
+
+	"github.com/hyperledger/aries-framework-go-ext/component/vdr/orb"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/wallet/pkg/restapi/wallet/operation"
+)
+
+func TestOrbKeyRetriever(t *testing.T) {
+	r := &orbKeyRetriever{keys: operation.NewDIDKeys()}
+
+	t.Run("no keys outside wallet operations", func(t *testing.T) {
+		_, err := r.GetNextUpdatePublicKey("did:orb:123", "")
+		require.EqualError(t, err, "no update of DID did:orb:123 in progress")
+
+		_, err = r.GetSigner("did:orb:123", orb.Update, "")
+		require.EqualError(t, err, "no update of DID did:orb:123 in progress")
+
+		_, err = r.GetSigner("did:orb:123", orb.Recover, "")
+		require.EqualError(t, err, "no deactivation of DID did:orb:123 in progress")
+	})
+
+	t.Run("no recovery", func(t *testing.T) {
+		_, err := r.GetNextRecoveryPublicKey("did:orb:123", "")
+		require.EqualError(t, err, "recovery of DID did:orb:123 is not supported")
+	})
+}
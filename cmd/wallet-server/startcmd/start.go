@@ -15,6 +15,7 @@ import (
 	"net/http"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/cenkalti/backoff/v4"
@@ -37,11 +38,15 @@ import (
 	"github.com/trustbloc/edge-core/pkg/log"
 	cmdutils "github.com/trustbloc/edge-core/pkg/utils/cmd"
 	tlsutils "github.com/trustbloc/edge-core/pkg/utils/tls"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
 
 	oidc2 "github.com/trustbloc/wallet/pkg/restapi/common/oidc"
 	"github.com/trustbloc/wallet/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/wallet/pkg/restapi/common/store/encrypted"
 	"github.com/trustbloc/wallet/pkg/restapi/oidc"
 	"github.com/trustbloc/wallet/pkg/restapi/wallet"
+	"github.com/trustbloc/wallet/pkg/restapi/wallet/operation"
 )
 
 const (
@@ -89,6 +94,7 @@ const (
 	oidcBasePath    = "/oidc/"
 	healthCheckPath = "/healthcheck"
 	walletBasePath  = "/wallet/"
+	adminBasePath   = "/admin/"
 )
 
 // Key management config.
@@ -118,6 +124,29 @@ const (
 	hubAuthURLFlagName  = "hub-auth-url"
 	hubAuthURLFlagUsage = "Hub Auth Servr URL"
 	hubAuthURLEnvKey    = "HTTP_SERVER_HUB_AUTH_URL"
+
+	hubAuthAPITokenFlagName  = "hub-auth-api-token"
+	hubAuthAPITokenFlagUsage = "API token used to fetch the hub-auth secret share of users recovering their keys," +
+		" and to remove the hub-auth data of users whose accounts are deleted through the admin API." +
+		" Alternatively, this can be set with the following environment variable: " + hubAuthAPITokenEnvKey
+	hubAuthAPITokenEnvKey = "HTTP_SERVER_HUB_AUTH_API_TOKEN"
+)
+
+// Secret sharing config.
+const (
+	secretSharesFlagName  = "secret-shares"
+	secretSharesFlagUsage = "Number of shares the secret protecting the keys of users is split into: 2, or 3 with a" +
+		" recovery code. Any two shares rebuild the secret: one is kept by the wallet server, one by hub-auth, and" +
+		" the third is the recovery code of the user. The recovery code must never be escrowed with the operators of" +
+		" the wallet server or hub-auth. Default is 2 (no recovery code)." +
+		" Alternatively, this can be set with the following environment variable: " + secretSharesEnvKey
+	secretSharesEnvKey = "HTTP_SERVER_SECRET_SHARES"
+
+	recoveryEscrowURLFlagName  = "recovery-escrow-url"
+	recoveryEscrowURLFlagUsage = "URL the recovery codes of new users are posted to. If not set, users fetch their" +
+		" recovery codes once from /oidc/recovery-codes." +
+		" Alternatively, this can be set with the following environment variable: " + recoveryEscrowURLEnvKey
+	recoveryEscrowURLEnvKey = "HTTP_SERVER_RECOVERY_ESCROW_URL"
 )
 
 // OIDC config.
@@ -127,6 +156,18 @@ const (
 		" Alternatively, this can be set with the following environment variable: " + oidcProviderURLEnvKey
 	oidcProviderURLEnvKey = "HTTP_SERVER_OIDC_OPURL"
 
+	oidcServiceClientIDFlagName  = "oidc-service-clientid"
+	oidcServiceClientIDFlagUsage = "OAuth2 client_id of the wallet server itself at the oidc-opurl provider." +
+		" Access tokens issued to it with the client credentials grant authorize the removal of the remote" +
+		" resources of users without valid tokens, when accounts are deleted through the admin API." +
+		" Alternatively, this can be set with the following environment variable: " + oidcServiceClientIDEnvKey
+	oidcServiceClientIDEnvKey = "HTTP_SERVER_OIDC_SERVICE_CLIENTID"
+
+	oidcServiceClientSecretFlagName  = "oidc-service-clientsecret" // nolint:gosec // false positive on 'secret'
+	oidcServiceClientSecretFlagUsage = "OAuth2 client secret of the oidc-service-clientid client." +
+		" Alternatively, this can be set with the following environment variable: " + oidcServiceClientSecretEnvKey
+	oidcServiceClientSecretEnvKey = "HTTP_SERVER_OIDC_SERVICE_CLIENTSECRET" // nolint:gosec // false positive
+
 	oidcClientIDFlagName  = "oidc-clientid"
 	oidcClientIDFlagUsage = "OAuth2 client_id for OIDC." +
 		" Alternatively, this can be set with the following environment variable: " + oidcClientIDEnvKey
@@ -142,6 +183,13 @@ const (
 	oidcCallbackURLFlagUsage = "Base URL for the OIDC callback endpoint." +
 		" Alternatively, this can be set with the following environment variable: " + oidcCallbackURLEnvKey
 	oidcCallbackURLEnvKey = "HTTP_SERVER_OIDC_CALLBACK"
+
+	oidcProvidersFlagName  = "oidc-providers"
+	oidcProvidersFlagUsage = "Path to a JSON file with additional OIDC providers users can log in with, in addition" +
+		" to the oidc-opurl provider. The file maps provider IDs to objects with the provider's name, url," +
+		" clientID, clientSecret and scopes. Users select a provider with /oidc/login?provider=<ID>." +
+		" Alternatively, this can be set with the following environment variable: " + oidcProvidersEnvKey
+	oidcProvidersEnvKey = "HTTP_SERVER_OIDC_PROVIDERS"
 )
 
 // Keys.
@@ -161,6 +209,31 @@ const (
 		" Default is 900 (15 minutes)." +
 		" Alternatively, this can be set with the following environment variable: " + sessionCookieMaxAgeEnvKey
 	sessionCookieMaxAgeEnvKey = "HTTP_SERVER_COOKIE_MAXAGE"
+
+	storageKEKFlagName  = "storage-kek"
+	storageKEKFlagUsage = "Key-encryption key used to encrypt user data at rest, in `keyID@path` format where" +
+		" path is a file holding the 32-byte key. This flag can be repeated to rotate keys: the first key encrypts" +
+		" new data and the others are only used to decrypt data written with older keys." +
+		" Required unless " + storagePlaintextFlagName + " is set." +
+		" Alternatively, this can be set with the following environment variable (in CSV format): " + storageKEKEnvKey
+	storageKEKEnvKey = "HTTP_SERVER_STORAGE_KEK"
+
+	storagePlaintextFlagName  = "storage-plaintext"
+	storagePlaintextFlagUsage = "Set to true to store user tokens and secret shares in plaintext when no " +
+		storageKEKFlagName + " is set. Only meant for deployments upgrading from plaintext storage: existing rows" +
+		" are encrypted as they are read once a key is set. Defaults to false." +
+		" Alternatively, this can be set with the following environment variable: " + storagePlaintextEnvKey
+	storagePlaintextEnvKey = "HTTP_SERVER_STORAGE_PLAINTEXT"
+
+	sessionStoreFlagName  = "session-store"
+	sessionStoreFlagUsage = "Where user sessions are kept. Possible values [cookie] [server]. Defaults to cookie." +
+		" Server-side sessions are kept in the database and only their ID is sent in cookies; users can list and" +
+		" revoke them with /oidc/sessions." +
+		" Alternatively, this can be set with the following environment variable: " + sessionStoreEnvKey
+	sessionStoreEnvKey = "HTTP_SERVER_SESSION_STORE"
+
+	sessionStoreCookie = "cookie"
+	sessionStoreServer = "server"
 )
 
 var logger = log.New("wallet/wallet-server")
@@ -209,9 +282,13 @@ type httpServerParameters struct {
 	tls                  *tlsParameters
 	oidc                 *oidcParameters
 	cookie               *cookie.Config
+	serverSessions       bool
+	storageKEKs          []*encrypted.Key
+	storagePlaintext     bool
 	keyServer            *keyServerParameters
 	userEDVURL           string
 	hubAuthURL           string
+	secretShares         *oidc.SecretSharesConfig
 	agentUIURL           string
 	logLevel             string
 	agent                *agentParameters
@@ -228,6 +305,18 @@ type oidcParameters struct {
 	clientID     string
 	clientSecret string
 	callbackURL  string
+	providers    map[string]*oidcProviderParameters
+	// serviceClientID and serviceClientSecret are the client credentials of the wallet server itself.
+	serviceClientID     string
+	serviceClientSecret string
+}
+
+type oidcProviderParameters struct {
+	Name         string   `json:"name"`
+	URL          string   `json:"url"`
+	ClientID     string   `json:"clientID"`
+	ClientSecret string   `json:"clientSecret"`
+	Scopes       []string `json:"scopes"`
 }
 
 type keyServerParameters struct {
@@ -286,6 +375,21 @@ func createStartCmd(srv server) *cobra.Command { //nolint:funlen,gocyclo // no r
 				return err
 			}
 
+			serverSessions, err := getServerSessions(cmd)
+			if err != nil {
+				return err
+			}
+
+			storagePlaintext, err := getStoragePlaintext(cmd)
+			if err != nil {
+				return err
+			}
+
+			storageKEKs, err := getStorageKEKs(cmd, storagePlaintext)
+			if err != nil {
+				return err
+			}
+
 			keyServer, err := getKeyServerParams(cmd)
 			if err != nil {
 				return err
@@ -301,6 +405,11 @@ func createStartCmd(srv server) *cobra.Command { //nolint:funlen,gocyclo // no r
 				return fmt.Errorf("hub-auth url : %w", err)
 			}
 
+			secretShares, err := getSecretSharesParams(cmd)
+			if err != nil {
+				return err
+			}
+
 			agentParams, err := getAgentParams(cmd)
 			if err != nil {
 				return err
@@ -313,9 +422,13 @@ func createStartCmd(srv server) *cobra.Command { //nolint:funlen,gocyclo // no r
 				tls:                  tlsParams,
 				oidc:                 oidcParams,
 				cookie:               cookies,
+				serverSessions:       serverSessions,
+				storageKEKs:          storageKEKs,
+				storagePlaintext:     storagePlaintext,
 				keyServer:            keyServer,
 				userEDVURL:           userEDVURL,
 				hubAuthURL:           hubAuthURL,
+				secretShares:         secretShares,
 				agentUIURL:           agentUIURL,
 				logLevel:             logLevel,
 				agent:                agentParams,
@@ -339,6 +452,9 @@ func createFlags(startCmd *cobra.Command) {
 	startCmd.Flags().StringP(keyEDVURLFlagName, "", "", keyEDVURLFlagUsage)
 	startCmd.Flags().StringP(userEDVURLFlagName, "", "", userEDVURLFlagUsage)
 	startCmd.Flags().StringP(hubAuthURLFlagName, "", "", hubAuthURLFlagUsage)
+	startCmd.Flags().StringP(hubAuthAPITokenFlagName, "", "", hubAuthAPITokenFlagUsage)
+	startCmd.Flags().StringP(secretSharesFlagName, "", "", secretSharesFlagUsage)
+	startCmd.Flags().StringP(recoveryEscrowURLFlagName, "", "", recoveryEscrowURLFlagUsage)
 
 	createOIDCFlags(startCmd)
 	createTLSFlags(startCmd)
@@ -356,13 +472,19 @@ func createOIDCFlags(cmd *cobra.Command) {
 	cmd.Flags().StringP(oidcProviderURLFlagName, "", "", oidcProviderURLFlagUsage)
 	cmd.Flags().StringP(oidcClientIDFlagName, "", "", oidcClientIDFlagUsage)
 	cmd.Flags().StringP(oidcClientSecretFlagName, "", "", oidcClientSecretFlagUsage)
+	cmd.Flags().StringP(oidcServiceClientIDFlagName, "", "", oidcServiceClientIDFlagUsage)
+	cmd.Flags().StringP(oidcServiceClientSecretFlagName, "", "", oidcServiceClientSecretFlagUsage)
 	cmd.Flags().StringP(oidcCallbackURLFlagName, "", "", oidcCallbackURLFlagUsage)
+	cmd.Flags().StringP(oidcProvidersFlagName, "", "", oidcProvidersFlagUsage)
 }
 
 func createCookieFlags(cmd *cobra.Command) {
 	cmd.Flags().StringP(sessionCookieAuthKeyFlagName, "", "", sessionCookieAuthKeyFlagUsage)
 	cmd.Flags().StringP(sessionCookieEncKeyFlagName, "", "", sessionCookieEncKeyFlagUsage)
 	cmd.Flags().StringP(sessionCookieMaxAgeFlagName, "", "", sessionCookieMaxAgeFlagUsage)
+	cmd.Flags().StringArrayP(storageKEKFlagName, "", []string{}, storageKEKFlagUsage)
+	cmd.Flags().StringP(storagePlaintextFlagName, "", "", storagePlaintextFlagUsage)
+	cmd.Flags().StringP(sessionStoreFlagName, "", "", sessionStoreFlagUsage)
 }
 
 func getDependencyMaxRetries(cmd *cobra.Command) (uint64, error) {
@@ -451,9 +573,59 @@ func getOIDCParams(cmd *cobra.Command) (*oidcParameters, error) {
 		return nil, fmt.Errorf("failed to configure OIDC provider URL: %w", err)
 	}
 
+	params.serviceClientID, err = cmdutils.GetUserSetVarFromString(
+		cmd, oidcServiceClientIDFlagName, oidcServiceClientIDEnvKey, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure OIDC service client ID: %w", err)
+	}
+
+	params.serviceClientSecret, err = cmdutils.GetUserSetVarFromString(
+		cmd, oidcServiceClientSecretFlagName, oidcServiceClientSecretEnvKey, params.serviceClientID == "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure OIDC service client secret: %w", err)
+	}
+
+	providersFile, err := cmdutils.GetUserSetVarFromString(cmd, oidcProvidersFlagName, oidcProvidersEnvKey, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure OIDC providers: %w", err)
+	}
+
+	if providersFile != "" {
+		params.providers, err = parseOIDCProviders(providersFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure OIDC providers: %w", err)
+		}
+	}
+
 	return params, nil
 }
 
+func parseOIDCProviders(file string) (map[string]*oidcProviderParameters, error) {
+	bits, err := ioutil.ReadFile(filepath.Clean(file))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", file, err)
+	}
+
+	providers := make(map[string]*oidcProviderParameters)
+
+	err = json.Unmarshal(bits, &providers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", file, err)
+	}
+
+	for id, p := range providers {
+		if id == "" || p.URL == "" || p.ClientID == "" {
+			return nil, fmt.Errorf("invalid OIDC provider [%s]: id, url and clientID are required", id)
+		}
+
+		if len(p.Scopes) == 0 {
+			p.Scopes = []string{oidcp.ScopeOpenID, "profile", "email"}
+		}
+	}
+
+	return providers, nil
+}
+
 func getCookieParams(cmd *cobra.Command) (*cookie.Config, error) {
 	const defaultMaxAge = 900
 
@@ -497,6 +669,68 @@ func getCookieParams(cmd *cobra.Command) (*cookie.Config, error) {
 	return params, nil
 }
 
+func getServerSessions(cmd *cobra.Command) (bool, error) {
+	mode, err := cmdutils.GetUserSetVarFromString(cmd, sessionStoreFlagName, sessionStoreEnvKey, true)
+	if err != nil {
+		return false, fmt.Errorf("failed to configure session store: %w", err)
+	}
+
+	switch mode {
+	case "", sessionStoreCookie:
+		return false, nil
+	case sessionStoreServer:
+		return true, nil
+	default:
+		return false, fmt.Errorf("invalid session store [%s]: use %s or %s", mode, sessionStoreCookie, sessionStoreServer)
+	}
+}
+
+func getStoragePlaintext(cmd *cobra.Command) (bool, error) {
+	plaintextVal, err := cmdutils.GetUserSetVarFromString(cmd, storagePlaintextFlagName, storagePlaintextEnvKey, true)
+	if err != nil || plaintextVal == "" {
+		return false, err
+	}
+
+	plaintext, err := strconv.ParseBool(plaintextVal)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse storage plaintext %s: %w", plaintextVal, err)
+	}
+
+	return plaintext, nil
+}
+
+func getStorageKEKs(cmd *cobra.Command, plaintext bool) ([]*encrypted.Key, error) {
+	const numPartsKEKOption = 2
+
+	keyOptions, err := cmdutils.GetUserSetVarFromArrayString(cmd, storageKEKFlagName, storageKEKEnvKey, plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure storage key-encryption keys: %w", err)
+	}
+
+	if len(keyOptions) == 0 {
+		logger.Warnf("!!! %s is set without %s: user tokens and secret shares are stored in PLAINTEXT !!!",
+			storagePlaintextFlagName, storageKEKFlagName)
+	}
+
+	keys := make([]*encrypted.Key, len(keyOptions))
+
+	for i, option := range keyOptions {
+		parts := strings.Split(option, "@")
+		if len(parts) != numPartsKEKOption || parts[0] == "" {
+			return nil, fmt.Errorf("invalid storage key-encryption key option [%s]: use keyID@path", option)
+		}
+
+		secret, err := parseKey(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure storage key-encryption key %s: %w", parts[0], err)
+		}
+
+		keys[i] = &encrypted.Key{ID: parts[0], Secret: secret}
+	}
+
+	return keys, nil
+}
+
 func getKeyServerParams(cmd *cobra.Command) (*keyServerParameters, error) {
 	authzKMSURL, err := cmdutils.GetUserSetVarFromString(
 		cmd, authzKMSURLFlagName, authzKMSURLEnvKey, false)
@@ -523,6 +757,36 @@ func getKeyServerParams(cmd *cobra.Command) (*keyServerParameters, error) {
 	}, nil
 }
 
+func getSecretSharesParams(cmd *cobra.Command) (*oidc.SecretSharesConfig, error) {
+	params := &oidc.SecretSharesConfig{}
+
+	shares, err := cmdutils.GetUserSetVarFromString(cmd, secretSharesFlagName, secretSharesEnvKey, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure secret shares: %w", err)
+	}
+
+	if shares != "" {
+		params.Shares, err = strconv.Atoi(shares)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse secret shares [%s]: %w", shares, err)
+		}
+	}
+
+	params.EscrowURL, err = cmdutils.GetUserSetVarFromString(cmd,
+		recoveryEscrowURLFlagName, recoveryEscrowURLEnvKey, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure recovery escrow url: %w", err)
+	}
+
+	params.HubAuthAPIToken, err = cmdutils.GetUserSetVarFromString(cmd,
+		hubAuthAPITokenFlagName, hubAuthAPITokenEnvKey, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure hub-auth api token: %w", err)
+	}
+
+	return params, nil
+}
+
 func parseKey(file string) ([]byte, error) {
 	const (
 		keyLen = 32
@@ -541,6 +805,23 @@ func parseKey(file string) ([]byte, error) {
 	return bits, nil
 }
 
+// serviceTokenSource returns the source of the access tokens of the wallet server itself, or nil if it has no client
+// credentials.
+func serviceTokenSource(config *httpServerParameters, tokenURL string) oauth2.TokenSource {
+	if config.oidc.serviceClientID == "" {
+		return nil
+	}
+
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient,
+		&http.Client{Transport: &http.Transport{TLSClientConfig: config.tls.config}})
+
+	return (&clientcredentials.Config{
+		ClientID:     config.oidc.serviceClientID,
+		ClientSecret: config.oidc.serviceClientSecret,
+		TokenURL:     tokenURL,
+	}).TokenSource(ctx)
+}
+
 func initOIDCProvider(providerURL string, retries uint64, tlsConfig *tls.Config) (*oidcp.Provider, error) {
 	var provider *oidcp.Provider
 
@@ -578,11 +859,13 @@ func startHTTPServer(parameters *httpServerParameters) error {
 		return fmt.Errorf("failed to set log level: %w", err)
 	}
 
-	router, err := router(parameters)
+	router, closeRouter, err := router(parameters)
 	if err != nil {
 		return fmt.Errorf("failed to configure router: %w", err)
 	}
 
+	defer closeRouter()
+
 	handler := cors.New(
 		cors.Options{
 			AllowedMethods:   []string{http.MethodGet, http.MethodPost},
@@ -604,7 +887,8 @@ func startHTTPServer(parameters *httpServerParameters) error {
 	return err
 }
 
-func router(config *httpServerParameters) (http.Handler, error) {
+// router returns the router of the server, and a function stopping the background work of its handlers.
+func router(config *httpServerParameters) (http.Handler, func(), error) {
 	root := mux.NewRouter()
 
 	root.HandleFunc(healthCheckPath, healthCheckHandler).Methods(http.MethodGet)
@@ -612,55 +896,120 @@ func router(config *httpServerParameters) (http.Handler, error) {
 	// set message handler
 	config.agent.msgHandler = msghandler.NewRegistrar()
 
+	// keys of the did:orb DIDs of wallets, shared by the orb VDR and the wallet
+	config.agent.didKeys = operation.NewDIDKeys()
+
 	// start agent and get context
 	ctx, err := createAriesAgent(config)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create aries agent: %w", err)
+		return nil, nil, fmt.Errorf("failed to create aries agent: %w", err)
+	}
+
+	tokens, err := newAPITokens(config.agent.token, config.agent.tokensFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load API tokens: %w", err)
 	}
 
 	// OIDC router
 	oidcRouter := root.PathPrefix(oidcBasePath).Subrouter()
 
-	err = addOIDCHandlers(oidcRouter, config, ctx.StorageProvider())
+	// admin routes are only exposed to holders of API tokens
+	var adminRouter *mux.Router
+
+	if tokens.enabled() {
+		adminRouter = root.PathPrefix(adminBasePath).Subrouter()
+		adminRouter.Use(tokens.authorize(apiScopeAdmin))
+	} else {
+		logger.Warnf("admin API is disabled and wallet API is not authenticated: neither %s nor %s is set",
+			agentTokenFlagName, agentTokensFileFlagName)
+	}
+
+	oidcOps, err := addOIDCHandlers(oidcRouter, adminRouter, config, ctx.StorageProvider())
 	if err != nil {
-		return nil, fmt.Errorf("failed to add OIDC handlers: %w", err)
+		return nil, nil, fmt.Errorf("failed to add OIDC handlers: %w", err)
 	}
 
 	// wallet agent router
-	walletHandlers, err := wallet.GetRESTHandlers(ctx, wallet.WithWebhookURLs(config.agent.webhookURLs...),
-		wallet.WithDefaultLabel(config.agent.defaultLabel), wallet.WithMessageHandler(config.agent.msgHandler))
+	walletController, err := wallet.New(ctx, wallet.WithWebhookURLs(config.agent.webhookURLs...),
+		wallet.WithDefaultLabel(config.agent.defaultLabel), wallet.WithMessageHandler(config.agent.msgHandler),
+		wallet.WithTLSConfig(config.tls.config), wallet.WithWalletAppURL(config.agentUIURL),
+		wallet.WithStatusSweepInterval(config.agent.statusSweepInterval),
+		wallet.WithExpiryNotifications(config.agent.expirySweepInterval, config.agent.expiryLeadTimes...),
+		wallet.WithMediator(config.agent.mediatorURL, config.agent.mediatorPickup),
+		wallet.WithRouting(config.agent.actAsMediator), wallet.WithDIDKeys(config.agent.didKeys))
 	if err != nil {
-		return nil, fmt.Errorf("failed to load wallet handlers: %w", err)
+		oidcOps.Close()
+
+		return nil, nil, fmt.Errorf("failed to load wallet handlers: %w", err)
 	}
 
 	walletRouter := root.PathPrefix(walletBasePath).Subrouter()
 
-	for _, handler := range walletHandlers {
-		walletRouter.HandleFunc(handler.Path(), handler.Handle()).Methods(handler.Method())
+	// wallet apps in browsers are authenticated by the session of the logged-in user instead of an API token
+	for _, handler := range walletController.WalletHandlers() {
+		h := tokens.authorizeOrSession(apiScopeWallet, oidcOps.SessionUser)(handler.Handle())
+
+		walletRouter.Handle(handler.Path(), h).Methods(handler.Method())
 	}
 
-	// JSON-LD context operations
-	for _, handler := range ldrest.New(ldsvc.New(ctx)).GetRESTHandlers() {
-		walletRouter.HandleFunc(handler.Path(), handler.Handle()).Methods(handler.Method())
+	// service routes configure the agent for all users, and JSON-LD context routes the contexts of all users
+	serviceHandlers := walletController.ServiceHandlers()
+	serviceHandlers = append(serviceHandlers, ldrest.New(ldsvc.New(ctx)).GetRESTHandlers()...)
+
+	for _, handler := range serviceHandlers {
+		var h http.Handler = handler.Handle()
+
+		if tokens.enabled() {
+			h = tokens.authorize(apiScopeWallet)(h)
+		}
+
+		walletRouter.Handle(handler.Path(), h).Methods(handler.Method())
 	}
 
-	return root, nil
+	return root, func() {
+		walletController.Close()
+		oidcOps.Close()
+	}, nil
 }
 
-func addOIDCHandlers(router *mux.Router, config *httpServerParameters, store ariesstorage.Provider) error {
+// addOIDCHandlers adds the OIDC handlers to the routers, and returns the OIDC operations.
+func addOIDCHandlers(router, adminRouter *mux.Router, config *httpServerParameters,
+	store ariesstorage.Provider) (*oidc.Operation, error) {
 	provider, err := initOIDCProvider(config.oidc.providerURL, config.dependencyMaxRetries, config.tls.config)
 	if err != nil {
-		return fmt.Errorf("failed to init OIDC provider: %w", err)
+		return nil, fmt.Errorf("failed to init OIDC provider: %w", err)
 	}
 
 	loader, err := createJSONLDDocumentLoader(store)
 	if err != nil {
-		return fmt.Errorf("create document loader: %w", err)
+		return nil, fmt.Errorf("create document loader: %w", err)
+	}
+
+	providers := make(map[string]*oidc.Provider, len(config.oidc.providers))
+
+	for id, p := range config.oidc.providers {
+		op, initErr := initOIDCProvider(p.URL, config.dependencyMaxRetries, config.tls.config)
+		if initErr != nil {
+			return nil, fmt.Errorf("failed to init OIDC provider %s: %w", id, initErr)
+		}
+
+		providers[id] = &oidc.Provider{
+			Name: p.Name,
+			Client: oidc2.NewClient(&oidc2.Config{
+				TLSConfig:    config.tls.config,
+				Provider:     &oidc2.ProviderAdapter{OP: op, TLSConfig: config.tls.config},
+				CallbackURL:  config.oidc.callbackURL,
+				ClientID:     p.ClientID,
+				ClientSecret: p.ClientSecret,
+				Scopes:       p.Scopes,
+			}),
+		}
 	}
 
 	oidcOps, err := oidc.New(&oidc.Config{
-		WalletDashboard: config.agentUIURL + "/loginhandle",
-		TLSConfig:       config.tls.config,
+		WalletDashboard:       config.agentUIURL + "/loginhandle",
+		PostLogoutRedirectURL: config.agentUIURL,
+		TLSConfig:             config.tls.config,
 		OIDCClient: oidc2.NewClient(&oidc2.Config{
 			TLSConfig:    config.tls.config,
 			Provider:     &oidc2.ProviderAdapter{OP: provider, TLSConfig: config.tls.config},
@@ -669,9 +1018,12 @@ func addOIDCHandlers(router *mux.Router, config *httpServerParameters, store ari
 			ClientSecret: config.oidc.clientSecret,
 			Scopes:       []string{oidcp.ScopeOpenID, "profile", "email"},
 		}),
+		Providers: providers,
 		Storage: &oidc.StorageConfig{
-			Storage:          store,
-			TransientStorage: ariesmem.NewProvider(),
+			Storage:           store,
+			TransientStorage:  ariesmem.NewProvider(),
+			KeyEncryptionKeys: config.storageKEKs,
+			Plaintext:         config.storagePlaintext,
 		},
 		Cookie: config.cookie,
 		KeyServer: &oidc.KeyServerConfig{
@@ -679,19 +1031,28 @@ func addOIDCHandlers(router *mux.Router, config *httpServerParameters, store ari
 			OpsKMSURL:   config.keyServer.opsKMSURL,
 			KeyEDVURL:   config.keyServer.keyEDVURL,
 		},
-		UserEDVURL:   config.userEDVURL,
-		HubAuthURL:   config.hubAuthURL,
-		JSONLDLoader: loader,
+		UserEDVURL:     config.userEDVURL,
+		HubAuthURL:     config.hubAuthURL,
+		SecretShares:   config.secretShares,
+		ServerSessions: config.serverSessions,
+		JSONLDLoader:   loader,
+		ServiceTokens:  serviceTokenSource(config, provider.Endpoint().TokenURL),
 	})
 	if err != nil {
-		return fmt.Errorf("failed to init oidc ops: %w", err)
+		return nil, fmt.Errorf("failed to init oidc ops: %w", err)
 	}
 
 	for _, handler := range oidcOps.GetRESTHandlers() {
 		router.HandleFunc(handler.Path(), handler.Handle()).Methods(handler.Method())
 	}
 
-	return nil
+	if adminRouter != nil {
+		for _, handler := range oidcOps.GetAdminRESTHandlers() {
+			adminRouter.HandleFunc(handler.Path(), handler.Handle()).Methods(handler.Method())
+		}
+	}
+
+	return oidcOps, nil
 }
 
 type healthCheckResp struct {
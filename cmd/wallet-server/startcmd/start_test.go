@@ -31,6 +31,8 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/trustbloc/wallet/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/wallet/pkg/restapi/common/store/encrypted"
+	"github.com/trustbloc/wallet/pkg/restapi/wallet/operation"
 )
 
 type mockServer struct {
@@ -42,10 +44,14 @@ func (s *mockServer) ListenAndServe(host, certFile, keyFile string, handler http
 }
 
 func TestListenAndServe(t *testing.T) {
-	router, err := router(&httpServerParameters{
+	router, closeRouter, err := router(&httpServerParameters{
 		oidc:   &oidcParameters{providerURL: mockOIDCProvider(t)},
 		tls:    &tlsParameters{},
 		cookie: &cookie.Config{},
+		storageKEKs: []*encrypted.Key{{
+			ID:     uuid.New().String(),
+			Secret: []byte("0123456789abcdef0123456789abcdef"),
+		}},
 		keyServer: &keyServerParameters{
 			authzKMSURL: "http://localhost",
 		},
@@ -59,6 +65,8 @@ func TestListenAndServe(t *testing.T) {
 	})
 	require.NoError(t, err)
 
+	defer closeRouter()
+
 	h := HTTPServer{}
 
 	err = h.ListenAndServe("localhost:8080", "test.key", "test.cert", router)
@@ -141,7 +149,9 @@ func validArgs(t *testing.T) map[string]string {
 		databaseTypeFlagName:              "mem",
 		agentTransportReturnRouteFlagName: "all",
 		agentWebSocketReadLimitFlagName:   "65536",
+		agentExpiryLeadTimesFlagName:      "30, 7,1",
 		sessionCookieMaxAgeFlagName:       "100",
+		storageKEKFlagName:                "v1@" + key(t),
 	}
 }
 
@@ -298,6 +308,78 @@ func TestStartCmdWithInvalidAgentArgs(t *testing.T) {
 		require.Contains(t, err.Error(), "failed to parse web socket read limit")
 	})
 
+	t.Run("test invalid credential status sweep interval", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+
+		argMap := validArgs(t)
+		argMap[agentStatusSweepIntervalFlagName] = "-1"
+		args := argArray(argMap)
+
+		startCmd.SetArgs(args)
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to parse credential status sweep interval")
+	})
+
+	t.Run("test invalid credential expiry sweep interval", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+
+		argMap := validArgs(t)
+		argMap[agentExpirySweepIntervalFlagName] = "-1"
+		args := argArray(argMap)
+
+		startCmd.SetArgs(args)
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to parse credential expiry sweep interval")
+	})
+
+	t.Run("test invalid credential expiry lead times", func(t *testing.T) {
+		for _, leadTimes := range []string{"30,invalid", "0"} {
+			startCmd := GetStartCmd(&mockServer{})
+
+			argMap := validArgs(t)
+			argMap[agentExpiryLeadTimesFlagName] = leadTimes
+			args := argArray(argMap)
+
+			startCmd.SetArgs(args)
+
+			err := startCmd.Execute()
+			require.Error(t, err)
+			require.Contains(t, err.Error(), "invalid credential expiry lead time")
+		}
+	})
+
+	t.Run("test invalid mediator pickup interval", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+
+		argMap := validArgs(t)
+		argMap[agentMediatorPickupIntervalFlagName] = "-1"
+		args := argArray(argMap)
+
+		startCmd.SetArgs(args)
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to parse mediator pickup interval")
+	})
+
+	t.Run("test invalid act as mediator", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+
+		argMap := validArgs(t)
+		argMap[agentActAsMediatorFlagName] = "invalid"
+		args := argArray(argMap)
+
+		startCmd.SetArgs(args)
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to parse act as mediator")
+	})
+
 	t.Run("test invalid webhook URL", func(t *testing.T) {
 		startCmd := GetStartCmd(&mockServer{})
 
@@ -340,6 +422,58 @@ func TestStartCmdWithInvalidAgentArgs(t *testing.T) {
 		require.Contains(t, err.Error(), "failed to parse session cookie max age")
 	})
 
+	t.Run("invalid session store", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+
+		argMap := validArgs(t)
+		argMap[sessionStoreFlagName] = "INVALID"
+
+		startCmd.SetArgs(argArray(argMap))
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid session store")
+	})
+
+	t.Run("invalid secret shares value", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+
+		argMap := validArgs(t)
+		argMap[secretSharesFlagName] = "INVALID"
+
+		startCmd.SetArgs(argArray(argMap))
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to parse secret shares")
+	})
+
+	t.Run("too few secret shares", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+
+		argMap := validArgs(t)
+		argMap[secretSharesFlagName] = "1"
+
+		startCmd.SetArgs(argArray(argMap))
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid number of secret shares")
+	})
+
+	t.Run("too many secret shares", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+
+		argMap := validArgs(t)
+		argMap[secretSharesFlagName] = "4"
+
+		startCmd.SetArgs(argArray(argMap))
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid number of secret shares")
+	})
+
 	t.Run("test invalid context provider URL", func(t *testing.T) {
 		startCmd := GetStartCmd(&mockServer{})
 
@@ -491,6 +625,63 @@ func TestStartCmdWithMissingArg(t *testing.T) {
 		require.Contains(t, err.Error(), "failed to init OIDC provider")
 	})
 
+	t.Run("invalid oidc providers file", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+
+		argMap := validArgs(t)
+		argMap[oidcProvidersFlagName] = invalidArgString
+		args := argArray(argMap)
+
+		startCmd.SetArgs(args)
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to configure OIDC providers: failed to read INVALID")
+	})
+
+	t.Run("malformed oidc providers file", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+
+		argMap := validArgs(t)
+		argMap[oidcProvidersFlagName] = jsonFile(t, "{")
+		args := argArray(argMap)
+
+		startCmd.SetArgs(args)
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to configure OIDC providers: failed to parse")
+	})
+
+	t.Run("oidc provider without client ID", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+
+		argMap := validArgs(t)
+		argMap[oidcProvidersFlagName] = jsonFile(t, `{"other":{"url":"http://localhost"}}`)
+		args := argArray(argMap)
+
+		startCmd.SetArgs(args)
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid OIDC provider [other]: id, url and clientID are required")
+	})
+
+	t.Run("invalid additional oidc provider URL", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+
+		argMap := validArgs(t)
+		argMap[oidcProvidersFlagName] = jsonFile(t, `{"other":{"url":"INVALID","clientID":"client"}}`)
+		argMap[dependencyMaxRetriesFlagName] = "1"
+		args := argArray(argMap)
+
+		startCmd.SetArgs(args)
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to init OIDC provider other")
+	})
+
 	t.Run("missing oidc client ID", func(t *testing.T) {
 		startCmd := GetStartCmd(&mockServer{})
 
@@ -521,6 +712,21 @@ func TestStartCmdWithMissingArg(t *testing.T) {
 				" HTTP_SERVER_OIDC_CLIENTSECRET (environment variable) have been set.")
 	})
 
+	t.Run("missing oidc service client secret", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+
+		argMap := validArgs(t)
+		argMap[oidcServiceClientIDFlagName] = uuid.New().String()
+		args := argArray(argMap)
+
+		startCmd.SetArgs(args)
+
+		err := startCmd.Execute()
+		require.EqualError(t, err,
+			"failed to configure OIDC service client secret: Neither oidc-service-clientsecret (command line flag)"+
+				" nor HTTP_SERVER_OIDC_SERVICE_CLIENTSECRET (environment variable) have been set.")
+	})
+
 	t.Run("missing oidc callback", func(t *testing.T) {
 		startCmd := GetStartCmd(&mockServer{})
 
@@ -612,6 +818,78 @@ func TestStartCmdWithMissingArg(t *testing.T) {
 				" HTTP_SERVER_COOKIE_ENC_KEY (environment variable) have been set.")
 	})
 
+	t.Run("missing storage key-encryption key", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+
+		argMap := validArgs(t)
+		delete(argMap, storageKEKFlagName)
+		args := argArray(argMap)
+
+		startCmd.SetArgs(args)
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(),
+			"failed to configure storage key-encryption keys: Neither storage-kek (command line flag) nor"+
+				" HTTP_SERVER_STORAGE_KEK (environment variable) have been set.")
+	})
+
+	t.Run("missing storage key-encryption key with plaintext storage", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+
+		argMap := validArgs(t)
+		delete(argMap, storageKEKFlagName)
+		argMap[storagePlaintextFlagName] = "true"
+		args := argArray(argMap)
+
+		startCmd.SetArgs(args)
+
+		err := startCmd.Execute()
+		require.NoError(t, err)
+	})
+
+	t.Run("invalid storage plaintext", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+
+		argMap := validArgs(t)
+		argMap[storagePlaintextFlagName] = "maybe"
+		args := argArray(argMap)
+
+		startCmd.SetArgs(args)
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to parse storage plaintext maybe")
+	})
+
+	t.Run("invalid storage key-encryption key format", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+
+		argMap := validArgs(t)
+		argMap[storageKEKFlagName] = key(t)
+		args := argArray(argMap)
+
+		startCmd.SetArgs(args)
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid storage key-encryption key option")
+	})
+
+	t.Run("invalid storage key-encryption key length", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+
+		argMap := validArgs(t)
+		argMap[storageKEKFlagName] = "v1@" + invalidKey(t)
+		args := argArray(argMap)
+
+		startCmd.SetArgs(args)
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to configure storage key-encryption key v1")
+	})
+
 	t.Run("invalid log level", func(t *testing.T) {
 		startCmd := GetStartCmd(&mockServer{})
 
@@ -705,6 +983,97 @@ func TestStartCmdValidArgs(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestStartCmdWithOIDCProviders(t *testing.T) {
+	startCmd := GetStartCmd(&mockServer{})
+
+	argMap := validArgs(t)
+	argMap[oidcProvidersFlagName] = jsonFile(t, fmt.Sprintf(
+		`{"other":{"name":"Other","url":"%s","clientID":"client","clientSecret":"secret"}}`, mockOIDCProvider(t)))
+
+	startCmd.SetArgs(argArray(argMap))
+
+	err := startCmd.Execute()
+	require.NoError(t, err)
+}
+
+func TestStartCmdWithServiceClient(t *testing.T) {
+	startCmd := GetStartCmd(&mockServer{})
+
+	argMap := validArgs(t)
+	argMap[oidcServiceClientIDFlagName] = uuid.New().String()
+	argMap[oidcServiceClientSecretFlagName] = uuid.New().String()
+
+	startCmd.SetArgs(argArray(argMap))
+
+	err := startCmd.Execute()
+	require.NoError(t, err)
+}
+
+func TestStartCmdWithAdminAPI(t *testing.T) {
+	startCmd := GetStartCmd(&mockServer{})
+
+	argMap := validArgs(t)
+	argMap[agentTokenFlagName] = uuid.New().String()
+
+	startCmd.SetArgs(argArray(argMap))
+
+	err := startCmd.Execute()
+	require.NoError(t, err)
+}
+
+func TestStartCmdWithRecoveryCodes(t *testing.T) {
+	startCmd := GetStartCmd(&mockServer{})
+
+	argMap := validArgs(t)
+	argMap[secretSharesFlagName] = "3"
+	argMap[recoveryEscrowURLFlagName] = "https://escrow.example.com/recovery"
+	argMap[hubAuthAPITokenFlagName] = uuid.New().String()
+
+	startCmd.SetArgs(argArray(argMap))
+
+	err := startCmd.Execute()
+	require.NoError(t, err)
+}
+
+func TestStartCmdWithServerSessions(t *testing.T) {
+	startCmd := GetStartCmd(&mockServer{})
+
+	argMap := validArgs(t)
+	argMap[sessionStoreFlagName] = sessionStoreServer
+
+	startCmd.SetArgs(argArray(argMap))
+
+	err := startCmd.Execute()
+	require.NoError(t, err)
+}
+
+func TestStartCmdWithAPITokensFile(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+
+		argMap := validArgs(t)
+		argMap[agentTokensFileFlagName] = jsonFile(t, `[{"name":"ops","token":"secret","scopes":["admin"]}]`)
+
+		startCmd.SetArgs(argArray(argMap))
+
+		err := startCmd.Execute()
+		require.NoError(t, err)
+	})
+
+	t.Run("invalid tokens file", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+
+		argMap := validArgs(t)
+		argMap[agentTokensFileFlagName] = "INVALID"
+
+		startCmd.SetArgs(argArray(argMap))
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to load API tokens")
+	})
+}
+
 func TestStartCmdValidArgsEnvVar(t *testing.T) {
 	startCmd := GetStartCmd(&mockServer{})
 
@@ -753,6 +1122,9 @@ func TestStartCmdValidArgsEnvVar(t *testing.T) {
 	err = os.Setenv(databaseTypeEnvKey, "mem")
 	require.NoError(t, err)
 
+	err = os.Setenv(storageKEKEnvKey, "v2@"+key(t)+",v1@"+key(t))
+	require.NoError(t, err)
+
 	err = startCmd.Execute()
 
 	require.NoError(t, err)
@@ -811,7 +1183,7 @@ func TestCreateVDRs(t *testing.T) {
 	}}
 
 	for _, test := range tests {
-		res, err := createVDRs(test.resolvers, test.blocDomain)
+		res, err := createVDRs(test.resolvers, test.blocDomain, operation.NewDIDKeys())
 
 		for i, methods := range test.accept {
 			for _, method := range methods {
@@ -973,6 +1345,24 @@ func key(t *testing.T) string {
 	return file.Name()
 }
 
+func jsonFile(t *testing.T, contents string) string {
+	t.Helper()
+
+	file, err := ioutil.TempFile("", "test_*.json")
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		delErr := os.Remove(file.Name())
+		require.NoError(t, delErr)
+	})
+
+	_, err = file.WriteString(contents)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	return file.Name()
+}
+
 func invalidKey(t *testing.T) string {
 	t.Helper()
 
@@ -0,0 +1,61 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package common
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+const dialTimeout = 30 * time.Second
+
+// GuardDial returns a copy of an HTTP client connecting only to the allowed addresses. The addresses are checked when
+// the client connects, as the host of a checked URL may resolve to other addresses by then. Clients without an
+// *http.Transport are returned unchanged.
+func GuardDial(client *http.Client, allow func(net.IP) bool) *http.Client {
+	transport, ok := client.Transport.(*http.Transport)
+	if client.Transport == nil {
+		transport, ok = http.DefaultTransport.(*http.Transport)
+	}
+
+	if !ok {
+		return client
+	}
+
+	dialer := &net.Dialer{
+		Timeout: dialTimeout,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+
+			if ip := net.ParseIP(host); ip == nil || !allow(ip) {
+				return fmt.Errorf("address %s is not allowed", host)
+			}
+
+			return nil
+		},
+	}
+
+	guardedTransport := transport.Clone()
+	guardedTransport.DialContext = dialer.DialContext
+
+	guarded := *client
+	guarded.Transport = guardedTransport
+
+	return &guarded
+}
+
+// IsPublic returns whether an IP address is a public unicast address.
+func IsPublic(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() && !ip.IsMulticast() && !ip.IsUnspecified()
+}
@@ -0,0 +1,53 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package common_test
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/wallet/pkg/restapi/common"
+)
+
+func TestGuardDial(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(server.Close)
+
+	t.Run("only allowed addresses are dialed", func(t *testing.T) {
+		resp, err := common.GuardDial(server.Client(), func(net.IP) bool { return true }).Get(server.URL)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+		_, err = common.GuardDial(server.Client(), common.IsPublic).Get(server.URL) // nolint:bodyclose // no response
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "address 127.0.0.1 is not allowed")
+
+		_, err = common.GuardDial(&http.Client{}, common.IsPublic).Get(server.URL) // nolint:bodyclose // no response
+		require.Error(t, err)
+	})
+
+	t.Run("clients without an HTTP transport are unchanged", func(t *testing.T) {
+		client := &http.Client{Transport: http.NewFileTransport(http.Dir("."))}
+
+		require.Same(t, client, common.GuardDial(client, common.IsPublic))
+	})
+}
+
+func TestIsPublic(t *testing.T) {
+	require.True(t, common.IsPublic(net.ParseIP("93.184.216.34")))
+
+	for _, ip := range []string{"127.0.0.1", "10.0.0.1", "192.168.1.1", "169.254.169.254", "::1", "0.0.0.0", "fe80::1"} {
+		require.False(t, common.IsPublic(net.ParseIP(ip)), ip)
+	}
+}
@@ -13,12 +13,27 @@ import (
 )
 
 // Client is capable of formatting authorization requests, exchanging the token grant for an access_token
-// and id_token, and verifying id_tokens.
+// and id_token, verifying id_tokens, refreshing expired access_tokens, and logging users out of the provider.
+//
+// Authorization requests are bound to the login session with a nonce and a PKCE (S256) code verifier: both are
+// generated by the caller (see NewNonce and NewCodeVerifier), kept in the session, and handed back to Exchange and
+// VerifyIDToken when the user returns.
 type Client interface {
-	FormatRequest(state string, opts ...oauth2.AuthCodeOption) string
-	Exchange(c context.Context, code string) (*oauth2.Token, error)
-	VerifyIDToken(c context.Context, oauthToken OAuth2Token) (Claimer, error)
+	FormatRequest(state, nonce, codeVerifier string, opts ...oauth2.AuthCodeOption) string
+	Exchange(c context.Context, code, codeVerifier string) (*oauth2.Token, error)
+	VerifyIDToken(c context.Context, oauthToken OAuth2Token, nonce string) (Claimer, error)
 	UserInfo(ctx context.Context, token *oauth2.Token) (Claimer, error)
+	TokenSource(ctx context.Context, token *oauth2.Token) oauth2.TokenSource
+	RevokeToken(ctx context.Context, token, tokenTypeHint string) error
+	EndSessionURL(idTokenHint, postLogoutRedirectURI string) (string, error)
+	VerifyLogoutToken(ctx context.Context, rawLogoutToken string) (*LogoutToken, error)
+}
+
+// LogoutToken is a verified OIDC back-channel logout token.
+type LogoutToken struct {
+	Issuer    string
+	Subject   string
+	SessionID string
 }
 
 // OAuth2Token is the oauth2.Token.
@@ -8,19 +8,33 @@ package oidc
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/coreos/go-oidc"
+	"github.com/trustbloc/edge-core/pkg/log"
 	"golang.org/x/oauth2"
 )
 
+var logger = log.New("wallet/oidc/client")
+
 // Provider provides discovery of OIDC provider endpoints and also verifies id_tokens.
 type Provider interface {
 	Endpoint() oauth2.Endpoint
 	Verifier(*oidc.Config) Verifier
 	UserInfo(context.Context, oauth2.TokenSource) (*oidc.UserInfo, error)
+	Claims(v interface{}) error
 }
 
 // ProviderAdapter adapts an *oidc.Provider into an OIDCProvider.
@@ -34,6 +48,11 @@ func (o *ProviderAdapter) Endpoint() oauth2.Endpoint {
 	return o.OP.Endpoint()
 }
 
+// Claims unmarshals the provider's discovery document into v.
+func (o *ProviderAdapter) Claims(v interface{}) error {
+	return o.OP.Claims(v)
+}
+
 // Verifier returns an OIDC verifier.
 func (o *ProviderAdapter) Verifier(config *oidc.Config) Verifier {
 	return &verifierAdapter{v: o.OP.Verifier(config)}
@@ -67,6 +86,7 @@ func (v *verifierAdapter) Verify(ctx context.Context, token string) (*oidc.IDTok
 type oauth2Config interface {
 	AuthCodeURL(string, ...oauth2.AuthCodeOption) string
 	Exchange(context.Context, string, ...oauth2.AuthCodeOption) (*oauth2.Token, error)
+	TokenSource(context.Context, *oauth2.Token) oauth2.TokenSource
 }
 
 type oauth2ConfigImpl struct {
@@ -82,12 +102,77 @@ func (o *oauth2ConfigImpl) Exchange(
 	return o.oc.Exchange(ctx, code, options...)
 }
 
+func (o *oauth2ConfigImpl) TokenSource(ctx context.Context, token *oauth2.Token) oauth2.TokenSource {
+	return o.oc.TokenSource(ctx, token)
+}
+
+const (
+	codeChallengeParam       = "code_challenge"
+	codeChallengeMethodParam = "code_challenge_method"
+	codeVerifierParam        = "code_verifier"
+	codeChallengeMethodS256  = "S256"
+	// RFC7636 requires at least 256 bits of entropy for the code verifier.
+	randomValueSize = 32
+
+	backChannelLogoutEvent = "http://schemas.openid.net/event/backchannel-logout"
+	// logoutTokenMaxAge is how long after it was issued a logout token is accepted, and how long its jti is
+	// remembered to reject replays.
+	logoutTokenMaxAge = 5 * time.Minute
+)
+
+// ErrNotSupported is returned when the provider does not advertise the endpoint needed for an operation.
+var ErrNotSupported = errors.New("not supported by the OIDC provider")
+
+// discovery holds the provider metadata not exposed by the oidc library.
+type discovery struct {
+	RevocationEndpoint string `json:"revocation_endpoint"`
+	EndSessionEndpoint string `json:"end_session_endpoint"`
+}
+
+type logoutClaims struct {
+	ID        string                     `json:"jti"`
+	SessionID string                     `json:"sid"`
+	Nonce     string                     `json:"nonce"`
+	Events    map[string]json.RawMessage `json:"events"`
+}
+
+// NewCodeVerifier returns a new random PKCE code verifier.
+func NewCodeVerifier() (string, error) {
+	return randomValue()
+}
+
+// NewNonce returns a new random nonce to bind an id_token to the login session.
+func NewNonce() (string, error) {
+	return randomValue()
+}
+
+func randomValue() (string, error) {
+	b := make([]byte, randomValueSize)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate random value: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// CodeChallenge returns the S256 PKCE code challenge derived from a code verifier.
+func CodeChallenge(codeVerifier string) string {
+	sum := sha256.Sum256([]byte(codeVerifier))
+
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
 // BasicClient for OIDC.
 type BasicClient struct {
 	provider             Provider
 	oauth2ConfigSupplier func() oauth2Config
 	clientID             string
+	clientSecret         string
 	tlsConfig            *tls.Config
+	httpClient           *http.Client
+	logoutTokens         *replayCache
 }
 
 // Config defines configuration for oidc client.
@@ -113,18 +198,28 @@ func NewClient(config *Config) *BasicClient {
 				Scopes:       config.Scopes,
 			}}
 		},
-		clientID:  config.ClientID,
-		tlsConfig: config.TLSConfig,
+		clientID:     config.ClientID,
+		clientSecret: config.ClientSecret,
+		tlsConfig:    config.TLSConfig,
+		httpClient:   &http.Client{Transport: &http.Transport{TLSClientConfig: config.TLSConfig}},
+		logoutTokens: &replayCache{seen: map[string]time.Time{}},
 	}
 }
 
-// FormatRequest returns a correctly-formatted OIDC request.
-func (c *BasicClient) FormatRequest(state string, opts ...oauth2.AuthCodeOption) string {
+// FormatRequest returns a correctly-formatted OIDC request carrying the nonce and the S256 PKCE code challenge
+// derived from codeVerifier.
+func (c *BasicClient) FormatRequest(state, nonce, codeVerifier string, opts ...oauth2.AuthCodeOption) string {
+	opts = append(opts,
+		oidc.Nonce(nonce),
+		oauth2.SetAuthURLParam(codeChallengeParam, CodeChallenge(codeVerifier)),
+		oauth2.SetAuthURLParam(codeChallengeMethodParam, codeChallengeMethodS256),
+	)
+
 	return c.oauth2ConfigSupplier().AuthCodeURL(state, opts...)
 }
 
-// Exchange the auth code for the OAuth2 token.
-func (c *BasicClient) Exchange(ctx context.Context, code string) (*oauth2.Token, error) {
+// Exchange the auth code and the PKCE code verifier for the OAuth2 token.
+func (c *BasicClient) Exchange(ctx context.Context, code, codeVerifier string) (*oauth2.Token, error) {
 	token, err := c.oauth2ConfigSupplier().Exchange(
 		context.WithValue(
 			ctx,
@@ -132,6 +227,7 @@ func (c *BasicClient) Exchange(ctx context.Context, code string) (*oauth2.Token,
 			&http.Client{Transport: &http.Transport{TLSClientConfig: c.tlsConfig}},
 		),
 		code,
+		oauth2.SetAuthURLParam(codeVerifierParam, codeVerifier),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to exchange code for token: %w", err)
@@ -144,8 +240,13 @@ func (c *BasicClient) Exchange(ctx context.Context, code string) (*oauth2.Token,
 	return token, nil
 }
 
-// VerifyIDToken parses the id_token within the OAuth2 token and verifies it.
-func (c *BasicClient) VerifyIDToken(ctx context.Context, oauthToken OAuth2Token) (Claimer, error) {
+// VerifyIDToken parses the id_token within the OAuth2 token and verifies it, including that it was issued for
+// the given nonce.
+func (c *BasicClient) VerifyIDToken(ctx context.Context, oauthToken OAuth2Token, nonce string) (Claimer, error) {
+	if nonce == "" {
+		return nil, errors.New("missing nonce")
+	}
+
 	rawIDToken, found := oauthToken.Extra("id_token").(string)
 	if !found {
 		return nil, fmt.Errorf("missing id_token")
@@ -156,6 +257,10 @@ func (c *BasicClient) VerifyIDToken(ctx context.Context, oauthToken OAuth2Token)
 		return nil, fmt.Errorf("failed to verify id_token: %w", err)
 	}
 
+	if subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(nonce)) != 1 {
+		return nil, errors.New("id_token nonce does not match")
+	}
+
 	return idToken, nil
 }
 
@@ -168,3 +273,186 @@ func (c *BasicClient) UserInfo(ctx context.Context, token *oauth2.Token) (Claime
 
 	return info, nil
 }
+
+// TokenSource returns a TokenSource that returns token until it expires, then refreshes it at the provider's
+// token endpoint. The refresh token is rotated if the provider issues a new one.
+func (c *BasicClient) TokenSource(ctx context.Context, token *oauth2.Token) oauth2.TokenSource {
+	return c.oauth2ConfigSupplier().TokenSource(
+		context.WithValue(
+			ctx,
+			oauth2.HTTPClient,
+			&http.Client{Transport: &http.Transport{TLSClientConfig: c.tlsConfig}},
+		),
+		token,
+	)
+}
+
+// RevokeToken revokes the access or refresh token at the provider's revocation endpoint (RFC7009).
+func (c *BasicClient) RevokeToken(ctx context.Context, token, tokenTypeHint string) error {
+	d, err := c.discovery()
+	if err != nil {
+		return err
+	}
+
+	if d.RevocationEndpoint == "" {
+		return fmt.Errorf("token revocation: %w", ErrNotSupported)
+	}
+
+	form := url.Values{"token": {token}}
+
+	if tokenTypeHint != "" {
+		form.Set("token_type_hint", tokenTypeHint)
+	}
+
+	if c.clientSecret == "" {
+		form.Set("client_id", c.clientID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.RevocationEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("failed to create token revocation request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	if c.clientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(c.clientID), url.QueryEscape(c.clientSecret))
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send token revocation request: %w", err)
+	}
+
+	defer func() {
+		closeErr := resp.Body.Close()
+		if closeErr != nil {
+			logger.Errorf("failed to close response body: %s", closeErr.Error())
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("provider failed to revoke token: status code %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// EndSessionURL returns the URL of the provider's end_session_endpoint to which the user agent is redirected
+// to end the user's session at the provider (OIDC RP-Initiated Logout).
+func (c *BasicClient) EndSessionURL(idTokenHint, postLogoutRedirectURI string) (string, error) {
+	d, err := c.discovery()
+	if err != nil {
+		return "", err
+	}
+
+	if d.EndSessionEndpoint == "" {
+		return "", fmt.Errorf("end session: %w", ErrNotSupported)
+	}
+
+	endpoint, err := url.Parse(d.EndSessionEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid end_session_endpoint: %w", err)
+	}
+
+	query := endpoint.Query()
+	query.Set("client_id", c.clientID)
+
+	if idTokenHint != "" {
+		query.Set("id_token_hint", idTokenHint)
+	}
+
+	if postLogoutRedirectURI != "" {
+		query.Set("post_logout_redirect_uri", postLogoutRedirectURI)
+	}
+
+	endpoint.RawQuery = query.Encode()
+
+	return endpoint.String(), nil
+}
+
+// VerifyLogoutToken parses and verifies a back-channel logout token (OIDC Back-Channel Logout 1.0). Tokens issued more
+// than a few minutes ago and tokens already verified are rejected, so that captured tokens cannot be replayed.
+func (c *BasicClient) VerifyLogoutToken(ctx context.Context, rawLogoutToken string) (*LogoutToken, error) {
+	token, err := c.provider.Verifier(&oidc.Config{ClientID: c.clientID}).Verify(ctx, rawLogoutToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify logout_token: %w", err)
+	}
+
+	claims := &logoutClaims{}
+
+	err = token.Claims(claims)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse logout_token claims: %w", err)
+	}
+
+	if _, found := claims.Events[backChannelLogoutEvent]; !found {
+		return nil, errors.New("logout_token is missing the back-channel logout event")
+	}
+
+	if claims.Nonce != "" {
+		return nil, errors.New("logout_token must not contain a nonce")
+	}
+
+	if token.Subject == "" && claims.SessionID == "" {
+		return nil, errors.New("logout_token is missing both the sub and sid claims")
+	}
+
+	if claims.ID == "" {
+		return nil, errors.New("logout_token is missing the jti claim")
+	}
+
+	age := time.Since(token.IssuedAt)
+	if token.IssuedAt.IsZero() || age > logoutTokenMaxAge || age < -logoutTokenMaxAge {
+		return nil, errors.New("logout_token is missing the iat claim or was not issued recently")
+	}
+
+	if !c.logoutTokens.add(token.Issuer+" "+claims.ID, token.IssuedAt.Add(logoutTokenMaxAge)) {
+		return nil, errors.New("logout_token was already used")
+	}
+
+	return &LogoutToken{
+		Issuer:    token.Issuer,
+		Subject:   token.Subject,
+		SessionID: claims.SessionID,
+	}, nil
+}
+
+// replayCache remembers the IDs of tokens until they expire.
+type replayCache struct {
+	mutex sync.Mutex
+	seen  map[string]time.Time
+}
+
+// add records a token ID until its expiry, and returns false if the ID was already recorded.
+func (r *replayCache) add(id string, expiry time.Time) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := time.Now()
+
+	for seenID, seenExpiry := range r.seen {
+		if now.After(seenExpiry) {
+			delete(r.seen, seenID)
+		}
+	}
+
+	if _, found := r.seen[id]; found {
+		return false
+	}
+
+	r.seen[id] = expiry
+
+	return true
+}
+
+func (c *BasicClient) discovery() (*discovery, error) {
+	d := &discovery{}
+
+	err := c.provider.Claims(d)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read provider metadata: %w", err)
+	}
+
+	return d, nil
+}
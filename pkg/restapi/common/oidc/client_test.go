@@ -8,7 +8,14 @@ package oidc // nolint:testpackage // changing to different package requires exp
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
 	"time"
 
@@ -28,22 +35,50 @@ func TestClient_FormatRequest(t *testing.T) {
 			AuthURL:  "http://test.com/oauth2/authorize",
 			TokenURL: "http://test.com/oauth2/token",
 		}
+		nonce := uuid.New().String()
+		verifier, err := NewCodeVerifier()
+		require.NoError(t, err)
 		expected := (&oauth2.Config{
 			ClientID:    clientID,
 			Endpoint:    endpoint,
 			RedirectURL: callbackURL,
 			Scopes:      scopes,
-		}).AuthCodeURL(state)
+		}).AuthCodeURL(state,
+			oidc.Nonce(nonce),
+			oauth2.SetAuthURLParam("code_challenge", CodeChallenge(verifier)),
+			oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+		)
 		result := NewClient(&Config{
 			Provider:    &mockOIDCProvider{endpoint: endpoint},
 			ClientID:    clientID,
 			CallbackURL: callbackURL,
 			Scopes:      scopes,
-		}).FormatRequest(state)
+		}).FormatRequest(state, nonce, verifier)
 		require.Equal(t, expected, result)
 	})
 }
 
+func TestNewCodeVerifier(t *testing.T) {
+	t.Run("returns unique verifiers", func(t *testing.T) {
+		first, err := NewCodeVerifier()
+		require.NoError(t, err)
+		second, err := NewCodeVerifier()
+		require.NoError(t, err)
+		require.NotEqual(t, first, second)
+		// RFC7636: 43 to 128 characters
+		require.Len(t, first, 43)
+	})
+}
+
+func TestCodeChallenge(t *testing.T) {
+	t.Run("computes the S256 challenge", func(t *testing.T) {
+		verifier, err := NewCodeVerifier()
+		require.NoError(t, err)
+		sum := sha256.Sum256([]byte(verifier))
+		require.Equal(t, base64.RawURLEncoding.EncodeToString(sum[:]), CodeChallenge(verifier))
+	})
+}
+
 func TestClient_Exchange(t *testing.T) {
 	t.Run("exchanges code for token", func(t *testing.T) {
 		expected := &oauth2.Token{
@@ -57,14 +92,16 @@ func TestClient_Exchange(t *testing.T) {
 			ClientSecret: uuid.New().String(),
 			Scopes:       []string{"scope1", "scope2"},
 		})
+		config := &mockOAuth2Config{
+			token: expected,
+		}
 		c.oauth2ConfigSupplier = func() oauth2Config {
-			return &mockOAuth2Config{
-				token: expected,
-			}
+			return config
 		}
-		result, err := c.Exchange(context.Background(), "code")
+		result, err := c.Exchange(context.Background(), "code", "verifier")
 		require.NoError(t, err)
 		require.Equal(t, expected, result)
+		require.Equal(t, []oauth2.AuthCodeOption{oauth2.SetAuthURLParam("code_verifier", "verifier")}, config.options)
 	})
 
 	t.Run("error if cannot exchange code for token", func(t *testing.T) {
@@ -81,7 +118,7 @@ func TestClient_Exchange(t *testing.T) {
 				tokenErr: expected,
 			}
 		}
-		_, err := c.Exchange(context.Background(), "code")
+		_, err := c.Exchange(context.Background(), "code", "verifier")
 		require.True(t, errors.Is(err, expected))
 	})
 
@@ -96,7 +133,7 @@ func TestClient_Exchange(t *testing.T) {
 		c.oauth2ConfigSupplier = func() oauth2Config {
 			return &mockOAuth2Config{}
 		}
-		_, err := c.Exchange(context.Background(), "code")
+		_, err := c.Exchange(context.Background(), "code", "verifier")
 		require.Error(t, err)
 	})
 }
@@ -120,11 +157,47 @@ func TestClient_VerifyIDToken(t *testing.T) {
 			ClientSecret: uuid.New().String(),
 			Scopes:       []string{"scope1", "scope2"},
 		})
-		result, err := c.VerifyIDToken(context.Background(), &mockOAuthToken{extra: uuid.New().String()})
+		result, err := c.VerifyIDToken(
+			context.Background(), &mockOAuthToken{extra: uuid.New().String()}, expected.Nonce)
 		require.NoError(t, err)
 		require.Equal(t, expected, result)
 	})
 
+	t.Run("error if nonce does not match", func(t *testing.T) {
+		c := NewClient(&Config{
+			Provider: &mockOIDCProvider{
+				verifier: &mockOIDCVerifier{
+					token: &oidc.IDToken{Nonce: uuid.New().String()},
+				},
+			},
+			CallbackURL:  "http://test.com/callback",
+			ClientID:     uuid.New().String(),
+			ClientSecret: uuid.New().String(),
+			Scopes:       []string{"scope1", "scope2"},
+		})
+		_, err := c.VerifyIDToken(
+			context.Background(), &mockOAuthToken{extra: uuid.New().String()}, uuid.New().String())
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "nonce does not match")
+	})
+
+	t.Run("error if nonce is missing", func(t *testing.T) {
+		c := NewClient(&Config{
+			Provider: &mockOIDCProvider{
+				verifier: &mockOIDCVerifier{
+					token: &oidc.IDToken{},
+				},
+			},
+			CallbackURL:  "http://test.com/callback",
+			ClientID:     uuid.New().String(),
+			ClientSecret: uuid.New().String(),
+			Scopes:       []string{"scope1", "scope2"},
+		})
+		_, err := c.VerifyIDToken(context.Background(), &mockOAuthToken{extra: uuid.New().String()}, "")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "missing nonce")
+	})
+
 	t.Run("error if id_token is missing", func(t *testing.T) {
 		c := NewClient(&Config{
 			Provider: &mockOIDCProvider{
@@ -135,7 +208,7 @@ func TestClient_VerifyIDToken(t *testing.T) {
 			ClientSecret: uuid.New().String(),
 			Scopes:       []string{"scope1", "scope2"},
 		})
-		_, err := c.VerifyIDToken(context.Background(), &mockOAuthToken{})
+		_, err := c.VerifyIDToken(context.Background(), &mockOAuthToken{}, uuid.New().String())
 		require.Error(t, err)
 	})
 
@@ -150,7 +223,8 @@ func TestClient_VerifyIDToken(t *testing.T) {
 			ClientSecret: uuid.New().String(),
 			Scopes:       []string{"scope1", "scope2"},
 		})
-		_, err := c.VerifyIDToken(context.Background(), &mockOAuthToken{extra: uuid.New().String()})
+		_, err := c.VerifyIDToken(
+			context.Background(), &mockOAuthToken{extra: uuid.New().String()}, uuid.New().String())
 		require.True(t, errors.Is(err, expected))
 	})
 }
@@ -194,11 +268,342 @@ func TestClient_UserInfo(t *testing.T) {
 	})
 }
 
+func TestClient_TokenSource(t *testing.T) {
+	t.Run("refreshes expired token", func(t *testing.T) {
+		refreshToken := uuid.New().String()
+		newAccessToken := uuid.New().String()
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.NoError(t, r.ParseForm())
+			require.Equal(t, "refresh_token", r.Form.Get("grant_type"))
+			require.Equal(t, refreshToken, r.Form.Get("refresh_token"))
+
+			w.Header().Set("Content-Type", "application/json")
+			_, err := w.Write([]byte(`{"access_token":"` + newAccessToken + `","token_type":"Bearer","expires_in":300}`))
+			require.NoError(t, err)
+		}))
+		defer server.Close()
+
+		c := NewClient(&Config{
+			Provider: &mockOIDCProvider{endpoint: oauth2.Endpoint{
+				AuthURL:  server.URL + "/authorize",
+				TokenURL: server.URL + "/token",
+			}},
+			CallbackURL:  "http://test.com/callback",
+			ClientID:     uuid.New().String(),
+			ClientSecret: uuid.New().String(),
+		})
+
+		result, err := c.TokenSource(context.Background(), &oauth2.Token{
+			AccessToken:  uuid.New().String(),
+			RefreshToken: refreshToken,
+			Expiry:       time.Now().Add(-time.Minute),
+		}).Token()
+		require.NoError(t, err)
+		require.Equal(t, newAccessToken, result.AccessToken)
+		// the provider did not rotate the refresh token
+		require.Equal(t, refreshToken, result.RefreshToken)
+		require.True(t, result.Expiry.After(time.Now()))
+	})
+
+	t.Run("returns valid token without refreshing", func(t *testing.T) {
+		expected := &oauth2.Token{
+			AccessToken: uuid.New().String(),
+			Expiry:      time.Now().Add(time.Hour),
+		}
+		c := NewClient(&Config{
+			Provider:    &mockOIDCProvider{},
+			CallbackURL: "http://test.com/callback",
+			ClientID:    uuid.New().String(),
+		})
+		result, err := c.TokenSource(context.Background(), expected).Token()
+		require.NoError(t, err)
+		require.Equal(t, expected.AccessToken, result.AccessToken)
+	})
+}
+
+func TestClient_RevokeToken(t *testing.T) {
+	t.Run("revokes token", func(t *testing.T) {
+		clientID := uuid.New().String()
+		clientSecret := uuid.New().String()
+		token := uuid.New().String()
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, http.MethodPost, r.Method)
+			require.NoError(t, r.ParseForm())
+			require.Equal(t, token, r.PostForm.Get("token"))
+			require.Equal(t, "refresh_token", r.PostForm.Get("token_type_hint"))
+
+			id, secret, ok := r.BasicAuth()
+			require.True(t, ok)
+			require.Equal(t, clientID, id)
+			require.Equal(t, clientSecret, secret)
+		}))
+		defer server.Close()
+
+		c := NewClient(&Config{
+			Provider:     &mockOIDCProvider{discovery: &discovery{RevocationEndpoint: server.URL}},
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+		})
+		require.NoError(t, c.RevokeToken(context.Background(), token, "refresh_token"))
+	})
+
+	t.Run("public client identifies itself in the request body", func(t *testing.T) {
+		clientID := uuid.New().String()
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.NoError(t, r.ParseForm())
+			require.Equal(t, clientID, r.PostForm.Get("client_id"))
+			require.Empty(t, r.PostForm.Get("token_type_hint"))
+
+			_, _, ok := r.BasicAuth()
+			require.False(t, ok)
+		}))
+		defer server.Close()
+
+		c := NewClient(&Config{
+			Provider: &mockOIDCProvider{discovery: &discovery{RevocationEndpoint: server.URL}},
+			ClientID: clientID,
+		})
+		require.NoError(t, c.RevokeToken(context.Background(), uuid.New().String(), ""))
+	})
+
+	t.Run("error if provider does not support revocation", func(t *testing.T) {
+		c := NewClient(&Config{Provider: &mockOIDCProvider{}})
+		err := c.RevokeToken(context.Background(), uuid.New().String(), "")
+		require.ErrorIs(t, err, ErrNotSupported)
+	})
+
+	t.Run("error if cannot read provider metadata", func(t *testing.T) {
+		expected := errors.New("test")
+		c := NewClient(&Config{Provider: &mockOIDCProvider{claimsErr: expected}})
+		err := c.RevokeToken(context.Background(), uuid.New().String(), "")
+		require.ErrorIs(t, err, expected)
+	})
+
+	t.Run("error if provider fails to revoke token", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer server.Close()
+
+		c := NewClient(&Config{
+			Provider: &mockOIDCProvider{discovery: &discovery{RevocationEndpoint: server.URL}},
+		})
+		err := c.RevokeToken(context.Background(), uuid.New().String(), "")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "status code 503")
+	})
+}
+
+func TestClient_EndSessionURL(t *testing.T) {
+	t.Run("formats end session request", func(t *testing.T) {
+		clientID := uuid.New().String()
+		idToken := uuid.New().String()
+		c := NewClient(&Config{
+			Provider: &mockOIDCProvider{discovery: &discovery{
+				EndSessionEndpoint: "http://test.com/oauth2/sessions/logout?existing=param",
+			}},
+			ClientID: clientID,
+		})
+		result, err := c.EndSessionURL(idToken, "http://wallet.com")
+		require.NoError(t, err)
+
+		u, err := url.Parse(result)
+		require.NoError(t, err)
+		require.Equal(t, "test.com", u.Host)
+		require.Equal(t, "/oauth2/sessions/logout", u.Path)
+		require.Equal(t, url.Values{
+			"existing":                 {"param"},
+			"client_id":                {clientID},
+			"id_token_hint":            {idToken},
+			"post_logout_redirect_uri": {"http://wallet.com"},
+		}, u.Query())
+	})
+
+	t.Run("error if provider does not support end session", func(t *testing.T) {
+		c := NewClient(&Config{Provider: &mockOIDCProvider{}})
+		_, err := c.EndSessionURL("", "")
+		require.ErrorIs(t, err, ErrNotSupported)
+	})
+
+	t.Run("error if end_session_endpoint is invalid", func(t *testing.T) {
+		c := NewClient(&Config{
+			Provider: &mockOIDCProvider{discovery: &discovery{EndSessionEndpoint: "http://test.com/%zz"}},
+		})
+		_, err := c.EndSessionURL("", "")
+		require.Error(t, err)
+	})
+}
+
+func TestClient_VerifyLogoutToken(t *testing.T) {
+	const issuer = "http://test.issuer.com"
+
+	clientID := uuid.New().String()
+
+	newClient := func() *BasicClient {
+		return NewClient(&Config{
+			Provider: &mockOIDCProvider{
+				verifier: &verifierAdapter{
+					v: oidc.NewVerifier(issuer, &mockKeySet{}, &oidc.Config{ClientID: clientID}),
+				},
+			},
+			ClientID: clientID,
+		})
+	}
+
+	claims := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss": issuer,
+			"aud": clientID,
+			"iat": time.Now().Unix(),
+			"exp": time.Now().Add(time.Minute).Unix(),
+			"jti": uuid.New().String(),
+			"sub": uuid.New().String(),
+			"sid": uuid.New().String(),
+			"events": map[string]interface{}{
+				"http://schemas.openid.net/event/backchannel-logout": map[string]interface{}{},
+			},
+		}
+	}
+
+	t.Run("verifies logout token", func(t *testing.T) {
+		c := claims()
+		result, err := newClient().VerifyLogoutToken(context.Background(), unsignedJWT(t, c))
+		require.NoError(t, err)
+		require.Equal(t, &LogoutToken{
+			Issuer:    issuer,
+			Subject:   c["sub"].(string),
+			SessionID: c["sid"].(string),
+		}, result)
+	})
+
+	t.Run("error if token cannot be verified", func(t *testing.T) {
+		c := claims()
+		c["aud"] = uuid.New().String()
+		_, err := newClient().VerifyLogoutToken(context.Background(), unsignedJWT(t, c))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to verify logout_token")
+	})
+
+	t.Run("error if logout event is missing", func(t *testing.T) {
+		c := claims()
+		delete(c, "events")
+		_, err := newClient().VerifyLogoutToken(context.Background(), unsignedJWT(t, c))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "back-channel logout event")
+	})
+
+	t.Run("error if token has a nonce", func(t *testing.T) {
+		c := claims()
+		c["nonce"] = uuid.New().String()
+		_, err := newClient().VerifyLogoutToken(context.Background(), unsignedJWT(t, c))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "must not contain a nonce")
+	})
+
+	t.Run("verifies logout token identifying a session only", func(t *testing.T) {
+		c := claims()
+		delete(c, "sub")
+		result, err := newClient().VerifyLogoutToken(context.Background(), unsignedJWT(t, c))
+		require.NoError(t, err)
+		require.Equal(t, &LogoutToken{
+			Issuer:    issuer,
+			SessionID: c["sid"].(string),
+		}, result)
+	})
+
+	t.Run("error if sub and sid are missing", func(t *testing.T) {
+		c := claims()
+		delete(c, "sub")
+		delete(c, "sid")
+		_, err := newClient().VerifyLogoutToken(context.Background(), unsignedJWT(t, c))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "missing both the sub and sid claims")
+	})
+
+	t.Run("error if jti is missing", func(t *testing.T) {
+		c := claims()
+		delete(c, "jti")
+		_, err := newClient().VerifyLogoutToken(context.Background(), unsignedJWT(t, c))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "missing the jti claim")
+	})
+
+	t.Run("error if token was not issued recently", func(t *testing.T) {
+		c := claims()
+		c["iat"] = time.Now().Add(-time.Hour).Unix()
+		_, err := newClient().VerifyLogoutToken(context.Background(), unsignedJWT(t, c))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "not issued recently")
+
+		delete(c, "iat")
+		_, err = newClient().VerifyLogoutToken(context.Background(), unsignedJWT(t, c))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "missing the iat claim")
+	})
+
+	t.Run("error if token is replayed", func(t *testing.T) {
+		client := newClient()
+		token := unsignedJWT(t, claims())
+
+		_, err := client.VerifyLogoutToken(context.Background(), token)
+		require.NoError(t, err)
+
+		_, err = client.VerifyLogoutToken(context.Background(), token)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "already used")
+
+		_, err = client.VerifyLogoutToken(context.Background(), unsignedJWT(t, claims()))
+		require.NoError(t, err)
+	})
+}
+
+// unsignedJWT returns a JWT with a dummy signature, to be verified with mockKeySet.
+func unsignedJWT(t *testing.T, claims map[string]interface{}) string {
+	t.Helper()
+
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+
+	return strings.Join([]string{
+		base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256"}`)),
+		base64.RawURLEncoding.EncodeToString(payload),
+		base64.RawURLEncoding.EncodeToString([]byte("signature")),
+	}, ".")
+}
+
+type mockKeySet struct{}
+
+func (m *mockKeySet) VerifySignature(_ context.Context, jwt string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.Split(jwt, ".")[1])
+}
+
 type mockOIDCProvider struct {
 	endpoint    oauth2.Endpoint
 	verifier    Verifier
 	userInfo    *oidc.UserInfo
 	userInfoErr error
+	discovery   *discovery
+	claimsErr   error
+}
+
+func (m *mockOIDCProvider) Claims(v interface{}) error {
+	if m.claimsErr != nil {
+		return m.claimsErr
+	}
+
+	d, ok := v.(*discovery)
+	if !ok {
+		return errors.New("unexpected claims type")
+	}
+
+	if m.discovery != nil {
+		*d = *m.discovery
+	}
+
+	return nil
 }
 
 func (m *mockOIDCProvider) Endpoint() oauth2.Endpoint {
@@ -216,16 +621,24 @@ func (m *mockOIDCProvider) UserInfo(_ context.Context, _ oauth2.TokenSource) (*o
 type mockOAuth2Config struct {
 	token    *oauth2.Token
 	tokenErr error
+	options  []oauth2.AuthCodeOption
 }
 
 func (m *mockOAuth2Config) AuthCodeURL(_ string, _ ...oauth2.AuthCodeOption) string {
 	panic("implement me")
 }
 
-func (m *mockOAuth2Config) Exchange(_ context.Context, _ string, _ ...oauth2.AuthCodeOption) (*oauth2.Token, error) {
+func (m *mockOAuth2Config) Exchange(
+	_ context.Context, _ string, options ...oauth2.AuthCodeOption) (*oauth2.Token, error) {
+	m.options = options
+
 	return m.token, m.tokenErr
 }
 
+func (m *mockOAuth2Config) TokenSource(_ context.Context, t *oauth2.Token) oauth2.TokenSource {
+	return oauth2.StaticTokenSource(t)
+}
+
 type mockOAuthToken struct {
 	extra interface{}
 	valid bool
@@ -21,20 +21,26 @@ type MockClient struct {
 	IDTokenErr  error
 	UserInfoVal Claimer
 	UserInfoErr error
+	RefreshFunc func(*oauth2.Token) (*oauth2.Token, error)
+	RevokeFunc  func(token, tokenTypeHint string) error
+	EndSession  string
+	EndSessErr  error
+	LogoutToken *LogoutToken
+	LogoutErr   error
 }
 
 // FormatRequest formats the OIDC authorization request.
-func (m *MockClient) FormatRequest(_ string, options ...oauth2.AuthCodeOption) string {
+func (m *MockClient) FormatRequest(_, _, _ string, _ ...oauth2.AuthCodeOption) string {
 	return m.AuthRequest
 }
 
 // Exchange exchanges the code for an oauth token.
-func (m *MockClient) Exchange(_ context.Context, _ string) (*oauth2.Token, error) {
+func (m *MockClient) Exchange(_ context.Context, _, _ string) (*oauth2.Token, error) {
 	return m.OAuthToken, m.OAuthErr
 }
 
 // VerifyIDToken verifies the id_token inside the OAuth2 token.
-func (m *MockClient) VerifyIDToken(_ context.Context, _ OAuth2Token) (Claimer, error) {
+func (m *MockClient) VerifyIDToken(_ context.Context, _ OAuth2Token, _ string) (Claimer, error) {
 	return m.IDToken, m.IDTokenErr
 }
 
@@ -43,6 +49,43 @@ func (m *MockClient) UserInfo(_ context.Context, _ *oauth2.Token) (Claimer, erro
 	return m.UserInfoVal, m.UserInfoErr
 }
 
+// TokenSource returns a TokenSource that returns token while it is valid, and calls RefreshFunc otherwise.
+func (m *MockClient) TokenSource(_ context.Context, token *oauth2.Token) oauth2.TokenSource {
+	return &mockTokenSource{token: token, refresh: m.RefreshFunc}
+}
+
+// RevokeToken calls RevokeFunc, if set.
+func (m *MockClient) RevokeToken(_ context.Context, token, tokenTypeHint string) error {
+	if m.RevokeFunc != nil {
+		return m.RevokeFunc(token, tokenTypeHint)
+	}
+
+	return nil
+}
+
+// EndSessionURL returns the mock end session URL.
+func (m *MockClient) EndSessionURL(_, _ string) (string, error) {
+	return m.EndSession, m.EndSessErr
+}
+
+// VerifyLogoutToken returns the mock logout token.
+func (m *MockClient) VerifyLogoutToken(_ context.Context, _ string) (*LogoutToken, error) {
+	return m.LogoutToken, m.LogoutErr
+}
+
+type mockTokenSource struct {
+	token   *oauth2.Token
+	refresh func(*oauth2.Token) (*oauth2.Token, error)
+}
+
+func (m *mockTokenSource) Token() (*oauth2.Token, error) {
+	if m.token.Valid() || m.refresh == nil {
+		return m.token, nil
+	}
+
+	return m.refresh(m.token)
+}
+
 // MockClaimer can be a mock id_token or a mock UserInfo.
 type MockClaimer struct {
 	ClaimsErr  error
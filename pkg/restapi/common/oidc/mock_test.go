@@ -22,7 +22,7 @@ func TestMockClient_FormatRequest(t *testing.T) {
 	t.Run("returns mock request", func(t *testing.T) {
 		expected := uuid.New().String()
 		m := &oidc.MockClient{AuthRequest: expected}
-		require.Equal(t, expected, m.FormatRequest(""))
+		require.Equal(t, expected, m.FormatRequest("", "", ""))
 	})
 }
 
@@ -33,7 +33,7 @@ func TestMockClient_Exchange(t *testing.T) {
 			RefreshToken: uuid.New().String(),
 		}
 		m := &oidc.MockClient{OAuthToken: expected}
-		result, err := m.Exchange(context.TODO(), "")
+		result, err := m.Exchange(context.TODO(), "", "")
 		require.NoError(t, err)
 		require.Equal(t, expected, result)
 	})
@@ -41,7 +41,7 @@ func TestMockClient_Exchange(t *testing.T) {
 	t.Run("returns error", func(t *testing.T) {
 		expected := errors.New("test")
 		m := &oidc.MockClient{OAuthErr: expected}
-		_, err := m.Exchange(context.TODO(), "")
+		_, err := m.Exchange(context.TODO(), "", "")
 		require.Equal(t, expected, err)
 	})
 }
@@ -50,7 +50,7 @@ func TestMockClient_VerifyIDToken(t *testing.T) {
 	t.Run("returns id_token", func(t *testing.T) {
 		expected := &oidc.MockClaimer{}
 		m := &oidc.MockClient{IDToken: expected}
-		result, err := m.VerifyIDToken(context.TODO(), nil)
+		result, err := m.VerifyIDToken(context.TODO(), nil, "")
 		require.NoError(t, err)
 		require.Equal(t, expected, result)
 	})
@@ -58,7 +58,7 @@ func TestMockClient_VerifyIDToken(t *testing.T) {
 	t.Run("returns error", func(t *testing.T) {
 		expected := errors.New("test")
 		m := &oidc.MockClient{IDTokenErr: expected}
-		_, err := m.VerifyIDToken(context.TODO(), nil)
+		_, err := m.VerifyIDToken(context.TODO(), nil, "")
 		require.Equal(t, expected, err)
 	})
 }
@@ -83,6 +83,58 @@ func TestMockClient_UserInfo(t *testing.T) {
 	})
 }
 
+func TestMockClient_TokenSource(t *testing.T) {
+	t.Run("returns valid token", func(t *testing.T) {
+		expected := &oauth2.Token{AccessToken: uuid.New().String()}
+		m := &oidc.MockClient{}
+		result, err := m.TokenSource(context.TODO(), expected).Token()
+		require.NoError(t, err)
+		require.Equal(t, expected, result)
+	})
+
+	t.Run("runs RefreshFunc if token is invalid", func(t *testing.T) {
+		expected := &oauth2.Token{AccessToken: uuid.New().String()}
+		m := &oidc.MockClient{RefreshFunc: func(*oauth2.Token) (*oauth2.Token, error) {
+			return expected, nil
+		}}
+		result, err := m.TokenSource(context.TODO(), &oauth2.Token{}).Token()
+		require.NoError(t, err)
+		require.Equal(t, expected, result)
+	})
+}
+
+func TestMockClient_RevokeToken(t *testing.T) {
+	t.Run("no-op by default", func(t *testing.T) {
+		require.NoError(t, (&oidc.MockClient{}).RevokeToken(context.TODO(), "", ""))
+	})
+
+	t.Run("runs RevokeFunc", func(t *testing.T) {
+		expected := errors.New("test")
+		m := &oidc.MockClient{RevokeFunc: func(string, string) error {
+			return expected
+		}}
+		require.Equal(t, expected, m.RevokeToken(context.TODO(), "", ""))
+	})
+}
+
+func TestMockClient_EndSessionURL(t *testing.T) {
+	t.Run("returns mock URL", func(t *testing.T) {
+		expected := uuid.New().String()
+		result, err := (&oidc.MockClient{EndSession: expected}).EndSessionURL("", "")
+		require.NoError(t, err)
+		require.Equal(t, expected, result)
+	})
+}
+
+func TestMockClient_VerifyLogoutToken(t *testing.T) {
+	t.Run("returns logout token", func(t *testing.T) {
+		expected := &oidc.LogoutToken{Subject: uuid.New().String()}
+		result, err := (&oidc.MockClient{LogoutToken: expected}).VerifyLogoutToken(context.TODO(), "")
+		require.NoError(t, err)
+		require.Equal(t, expected, result)
+	})
+}
+
 func TestMockClaimer_Claims(t *testing.T) {
 	t.Run("runs ClaimsFunc", func(t *testing.T) {
 		executed := false
@@ -0,0 +1,41 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package common
+
+import (
+	"context"
+)
+
+type sessionUserKey struct{}
+
+// WithSessionUser returns a copy of the context of a request authenticated with the session of the given user.
+func WithSessionUser(ctx context.Context, userID string) context.Context {
+	return context.WithValue(ctx, sessionUserKey{}, userID)
+}
+
+// SessionUser returns the user whose session authenticated a request. Requests acting for any user have no session
+// user, see AnyUser.
+func SessionUser(ctx context.Context) (string, bool) {
+	userID, ok := ctx.Value(sessionUserKey{}).(string)
+
+	return userID, ok
+}
+
+type anyUserKey struct{}
+
+// WithAnyUser returns a copy of the context of a request authenticated with an API token, or received while the API
+// is not authenticated. These requests act for any user.
+func WithAnyUser(ctx context.Context) context.Context {
+	return context.WithValue(ctx, anyUserKey{}, true)
+}
+
+// AnyUser returns true if a request acts for any user, see WithAnyUser.
+func AnyUser(ctx context.Context) bool {
+	anyUser, ok := ctx.Value(anyUserKey{}).(bool)
+
+	return ok && anyUser
+}
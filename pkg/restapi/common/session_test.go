@@ -0,0 +1,30 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package common_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/wallet/pkg/restapi/common"
+)
+
+func TestSessionUser(t *testing.T) {
+	_, found := common.SessionUser(context.Background())
+	require.False(t, found)
+
+	userID, found := common.SessionUser(common.WithSessionUser(context.Background(), "user"))
+	require.True(t, found)
+	require.Equal(t, "user", userID)
+}
+
+func TestAnyUser(t *testing.T) {
+	require.False(t, common.AnyUser(context.Background()))
+	require.True(t, common.AnyUser(common.WithAnyUser(context.Background())))
+}
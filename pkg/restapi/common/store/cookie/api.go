@@ -13,6 +13,14 @@ type Store interface {
 	Open(*http.Request) (Jar, error)
 }
 
+// SessionStore is a Store that keeps sessions on the server, so that they can be listed and revoked by their owner.
+type SessionStore interface {
+	Store
+	List(r *http.Request, owner string) ([]*SessionInfo, error)
+	Revoke(owner, id string) error
+	Sweep() error
+}
+
 // Jar is a container of cookies from a Jars.
 type Jar interface {
 	Set(k interface{}, v interface{})
@@ -0,0 +1,391 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package cookie
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	ariesstorage "github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/trustbloc/edge-core/pkg/log"
+)
+
+const (
+	// SessionsStoreName is the name of the store holding server-side sessions.
+	SessionsStoreName = "edgeagent_sessions"
+
+	ownerTagName   = "owner"
+	sessionTagName = "session"
+	sessionIDLen   = 32
+)
+
+var logger = log.New("wallet/store/cookie")
+
+// SessionInfo describes a server-side session.
+type SessionInfo struct {
+	// ID identifies the session. It is not the secret held by the session cookie.
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	UserAgent string    `json:"userAgent,omitempty"`
+	// Current is true for the session of the request that listed the sessions.
+	Current bool `json:"current"`
+}
+
+type sessionRecord struct {
+	SessionInfo
+	// Owner is the owner tag of the session, empty until the owner is set.
+	Owner  string `json:"owner,omitempty"`
+	Values []byte `json:"values"`
+}
+
+// NewServerStore returns Jars whose cookies only hold an opaque session ID: the session values are kept in the
+// given storage and expire after config.MaxAge seconds without being saved. Sessions holding a string under
+// ownerKey can be listed and revoked by their owner. The session ID is replaced whenever the owner changes, so that
+// a session ID obtained before logging in is useless after it.
+func NewServerStore(config *Config, p ariesstorage.Provider, ownerKey string) (*ServerJars, error) {
+	s, err := p.OpenStore(SessionsStoreName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sessions store: %w", err)
+	}
+
+	err = p.SetStoreConfig(SessionsStoreName,
+		ariesstorage.StoreConfiguration{TagNames: []string{ownerTagName, sessionTagName}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to set sessions store config: %w", err)
+	}
+
+	codecs := securecookie.CodecsFromPairs(config.AuthKey, config.EncKey)
+
+	for _, codec := range codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(config.MaxAge)
+		}
+	}
+
+	ss := &serverStore{
+		s:        s,
+		codecs:   codecs,
+		maxAge:   time.Duration(config.MaxAge) * time.Second,
+		ownerKey: ownerKey,
+	}
+
+	return &ServerJars{Jars: Jars{cs: ss}, ss: ss}, nil
+}
+
+// ServerJars is a collection of cookie Jars kept on the server.
+type ServerJars struct {
+	Jars
+	ss *serverStore
+}
+
+// List the active sessions of the owner. Expired sessions are deleted.
+func (j *ServerJars) List(r *http.Request, owner string) ([]*SessionInfo, error) {
+	current := j.ss.requestSessionKey(r)
+	infos := make([]*SessionInfo, 0)
+
+	err := j.ss.each(ownerTagName+":"+ownerTag(owner), func(key string, record *sessionRecord) {
+		record.ID = key
+		record.Current = key == current
+		infos = append(infos, &record.SessionInfo)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(infos, func(a, b int) bool {
+		return infos[a].CreatedAt.Before(infos[b].CreatedAt)
+	})
+
+	return infos, nil
+}
+
+// Sweep deletes the expired sessions. Expired sessions are otherwise only deleted when they are read.
+func (j *ServerJars) Sweep() error {
+	return j.ss.each(sessionTagName, func(string, *sessionRecord) {})
+}
+
+// Revoke deletes the owner's session with the given ID. Returns ariesstorage.ErrDataNotFound if the owner has no
+// such session.
+func (j *ServerJars) Revoke(owner, id string) error {
+	tags, err := j.ss.s.GetTags(id)
+	if err != nil {
+		return fmt.Errorf("failed to fetch session: %w", err)
+	}
+
+	for _, tag := range tags {
+		if tag.Name == ownerTagName && tag.Value == ownerTag(owner) {
+			err = j.ss.s.Delete(id)
+			if err != nil {
+				return fmt.Errorf("failed to delete session: %w", err)
+			}
+
+			return nil
+		}
+	}
+
+	return fmt.Errorf("failed to fetch session: %w", ariesstorage.ErrDataNotFound)
+}
+
+// serverStore is a sessions.Store keeping the values of sessions in an ariesstorage.Store. Records are keyed by a
+// hash of the session ID so that they cannot be used to forge cookies.
+type serverStore struct {
+	s        ariesstorage.Store
+	codecs   []securecookie.Codec
+	maxAge   time.Duration
+	ownerKey string
+}
+
+// Get returns the session for the given name after adding it to the registry.
+func (s *serverStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New returns the session for the given name without adding it to the registry. A new session is returned if the
+// session expired or was revoked.
+func (s *serverStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	session.Options = &sessions.Options{Path: "/", MaxAge: int(s.maxAge.Seconds())}
+	session.IsNew = true
+
+	c, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+
+	var id string
+
+	err = securecookie.DecodeMulti(name, c.Value, &id, s.codecs...)
+	if err != nil {
+		return session, err
+	}
+
+	record, err := s.load(sessionKey(id))
+	if errors.Is(err, ariesstorage.ErrDataNotFound) {
+		return session, nil
+	}
+
+	if err != nil {
+		return session, err
+	}
+
+	err = gob.NewDecoder(bytes.NewReader(record.Values)).Decode(&session.Values)
+	if err != nil {
+		return session, fmt.Errorf("failed to decode session values: %w", err)
+	}
+
+	session.ID = id
+	session.IsNew = false
+
+	return session, nil
+}
+
+// Save the session's values and set the session cookie. The record of a session without values is deleted.
+func (s *serverStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if len(session.Values) == 0 {
+		if session.ID != "" {
+			s.delete(sessionKey(session.ID))
+		}
+
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", &sessions.Options{Path: "/", MaxAge: -1}))
+
+		return nil
+	}
+
+	owner := ""
+	if o, ok := session.Values[s.ownerKey].(string); ok {
+		owner = ownerTag(o)
+	}
+
+	now := time.Now()
+	record := &sessionRecord{SessionInfo: SessionInfo{CreatedAt: now, UserAgent: r.UserAgent()}, Owner: owner}
+
+	if session.ID != "" {
+		existing, err := s.load(sessionKey(session.ID))
+		if err == nil && existing.Owner == owner {
+			record.CreatedAt, record.UserAgent = existing.CreatedAt, existing.UserAgent
+		} else {
+			// the owner changed: the session gets a new ID so that the old one cannot be used for the new owner
+			s.delete(sessionKey(session.ID))
+			session.ID = ""
+		}
+	}
+
+	if session.ID == "" {
+		id := make([]byte, sessionIDLen)
+
+		_, err := rand.Read(id)
+		if err != nil {
+			return fmt.Errorf("failed to create session ID: %w", err)
+		}
+
+		session.ID = base64.RawURLEncoding.EncodeToString(id)
+	}
+
+	record.UpdatedAt = now
+	record.ExpiresAt = now.Add(s.maxAge)
+
+	values := &bytes.Buffer{}
+
+	err := gob.NewEncoder(values).Encode(session.Values)
+	if err != nil {
+		return fmt.Errorf("failed to encode session values: %w", err)
+	}
+
+	record.Values = values.Bytes()
+
+	bits, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal session: %w", err)
+	}
+
+	tags := []ariesstorage.Tag{{Name: sessionTagName}}
+
+	if owner != "" {
+		tags = append(tags, ariesstorage.Tag{Name: ownerTagName, Value: owner})
+	}
+
+	err = s.s.Put(sessionKey(session.ID), bits, tags...)
+	if err != nil {
+		return fmt.Errorf("failed to save session: %w", err)
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.codecs...)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+
+	return nil
+}
+
+func (s *serverStore) load(key string) (*sessionRecord, error) {
+	bits, err := s.s.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	record := &sessionRecord{}
+
+	err = json.Unmarshal(bits, record)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse session: %w", err)
+	}
+
+	if record.expired() {
+		s.delete(key)
+
+		return nil, ariesstorage.ErrDataNotFound
+	}
+
+	return record, nil
+}
+
+// each calls fn with the unexpired sessions matching the query. Expired sessions are deleted.
+func (s *serverStore) each(query string, fn func(key string, record *sessionRecord)) error {
+	iter, err := s.s.Query(query)
+	if err != nil {
+		return fmt.Errorf("failed to query sessions: %w", err)
+	}
+
+	defer func() {
+		if closeErr := iter.Close(); closeErr != nil {
+			logger.Warnf("failed to close sessions iterator: %s", closeErr.Error())
+		}
+	}()
+
+	for {
+		more, err := iter.Next()
+		if err != nil {
+			return fmt.Errorf("failed to iterate sessions: %w", err)
+		}
+
+		if !more {
+			return nil
+		}
+
+		key, err := iter.Key()
+		if err != nil {
+			return fmt.Errorf("failed to read session key: %w", err)
+		}
+
+		value, err := iter.Value()
+		if err != nil {
+			return fmt.Errorf("failed to read session: %w", err)
+		}
+
+		record := &sessionRecord{}
+
+		err = json.Unmarshal(value, record)
+		if err != nil {
+			return fmt.Errorf("failed to parse session: %w", err)
+		}
+
+		if record.expired() {
+			s.delete(key)
+
+			continue
+		}
+
+		fn(key, record)
+	}
+}
+
+func (s *serverStore) delete(key string) {
+	err := s.s.Delete(key)
+	if err != nil {
+		logger.Warnf("failed to delete session: %s", err.Error())
+	}
+}
+
+// requestSessionKey returns the key of the session of the request, or an empty string.
+func (s *serverStore) requestSessionKey(r *http.Request) string {
+	c, err := r.Cookie(StoreName)
+	if err != nil {
+		return ""
+	}
+
+	var id string
+
+	err = securecookie.DecodeMulti(StoreName, c.Value, &id, s.codecs...)
+	if err != nil {
+		return ""
+	}
+
+	return sessionKey(id)
+}
+
+func (r *sessionRecord) expired() bool {
+	return time.Now().After(r.ExpiresAt)
+}
+
+func sessionKey(id string) string {
+	sum := sha256.Sum256([]byte(id))
+
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// ownerTag hashes the owner so that it can be used in tag queries whatever its format.
+func ownerTag(owner string) string {
+	sum := sha256.Sum256([]byte(owner))
+
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,264 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package cookie_test
+
+import (
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	ariesmem "github.com/hyperledger/aries-framework-go/component/storageutil/mem"
+	mockstore "github.com/hyperledger/aries-framework-go/pkg/mock/storage"
+	ariesstorage "github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/wallet/pkg/restapi/common/store/cookie"
+)
+
+const ownerKey = "owner"
+
+func TestNewServerStore(t *testing.T) {
+	t.Run("error if the store cannot be opened", func(t *testing.T) {
+		_, err := cookie.NewServerStore(config(t, 900), &mockstore.MockStoreProvider{
+			ErrOpenStoreHandle: errors.New("test"),
+		}, ownerKey)
+		require.Error(t, err)
+	})
+}
+
+func TestServerJars(t *testing.T) {
+	t.Run("cookies only hold the session ID", func(t *testing.T) {
+		jars := newServerStore(t, 900)
+		owner := uuid.New().String()
+
+		c := save(t, jars, nil, map[string]string{ownerKey: owner})
+		require.NotContains(t, c.Value, owner)
+
+		jar, err := jars.Open(request(c))
+		require.NoError(t, err)
+
+		value, found := jar.Get(ownerKey)
+		require.True(t, found)
+		require.Equal(t, owner, value)
+	})
+
+	t.Run("lists and revokes the owner's sessions", func(t *testing.T) {
+		jars := newServerStore(t, 900)
+		owner := uuid.New().String()
+
+		first := save(t, jars, nil, map[string]string{ownerKey: owner})
+		second := save(t, jars, nil, map[string]string{ownerKey: owner})
+		save(t, jars, nil, map[string]string{ownerKey: uuid.New().String()})
+
+		sessions, err := jars.List(request(second), owner)
+		require.NoError(t, err)
+		require.Len(t, sessions, 2)
+		require.False(t, sessions[0].Current)
+		require.True(t, sessions[1].Current)
+
+		err = jars.Revoke(uuid.New().String(), sessions[0].ID)
+		require.ErrorIs(t, err, ariesstorage.ErrDataNotFound)
+
+		require.NoError(t, jars.Revoke(owner, sessions[0].ID))
+
+		jar, err := jars.Open(request(first))
+		require.NoError(t, err)
+
+		_, found := jar.Get(ownerKey)
+		require.False(t, found)
+
+		sessions, err = jars.List(request(second), owner)
+		require.NoError(t, err)
+		require.Len(t, sessions, 1)
+	})
+
+	t.Run("sessions are updated in place", func(t *testing.T) {
+		jars := newServerStore(t, 900)
+		owner := uuid.New().String()
+
+		c := save(t, jars, nil, map[string]string{ownerKey: owner})
+		c = save(t, jars, c, map[string]string{"other": "value"})
+
+		jar, err := jars.Open(request(c))
+		require.NoError(t, err)
+
+		_, found := jar.Get("other")
+		require.True(t, found)
+
+		sessions, err := jars.List(request(c), owner)
+		require.NoError(t, err)
+		require.Len(t, sessions, 1)
+	})
+
+	t.Run("sessions without values are deleted", func(t *testing.T) {
+		jars := newServerStore(t, 900)
+		owner := uuid.New().String()
+
+		c := save(t, jars, nil, map[string]string{ownerKey: owner})
+
+		jar, err := jars.Open(request(c))
+		require.NoError(t, err)
+
+		jar.Delete(ownerKey)
+
+		w := httptest.NewRecorder()
+		require.NoError(t, jar.Save(request(c), w))
+
+		sessions, err := jars.List(request(c), owner)
+		require.NoError(t, err)
+		require.Empty(t, sessions)
+	})
+
+	t.Run("expired sessions are not restored", func(t *testing.T) {
+		jars := newServerStore(t, 0)
+		owner := uuid.New().String()
+
+		c := save(t, jars, nil, map[string]string{ownerKey: owner})
+
+		jar, err := jars.Open(request(c))
+		require.NoError(t, err)
+
+		_, found := jar.Get(ownerKey)
+		require.False(t, found)
+	})
+
+	t.Run("the session ID is replaced when the owner changes", func(t *testing.T) {
+		jars := newServerStore(t, 900)
+		owner := uuid.New().String()
+
+		anonymous := save(t, jars, nil, map[string]string{"state": uuid.New().String()})
+		loggedIn := save(t, jars, anonymous, map[string]string{ownerKey: owner})
+		require.NotEqual(t, anonymous.Value, loggedIn.Value)
+
+		jar, err := jars.Open(request(anonymous))
+		require.NoError(t, err)
+
+		_, found := jar.Get(ownerKey)
+		require.False(t, found)
+
+		jar, err = jars.Open(request(loggedIn))
+		require.NoError(t, err)
+
+		value, found := jar.Get("state")
+		require.True(t, found)
+		require.NotEmpty(t, value)
+
+		sessions, err := jars.List(request(loggedIn), owner)
+		require.NoError(t, err)
+		require.Len(t, sessions, 1)
+		require.True(t, sessions[0].Current)
+	})
+
+	t.Run("sweep deletes expired sessions", func(t *testing.T) {
+		p := ariesmem.NewProvider()
+
+		jars, err := cookie.NewServerStore(config(t, 0), p, ownerKey)
+		require.NoError(t, err)
+
+		save(t, jars, nil, map[string]string{ownerKey: uuid.New().String()})
+		save(t, jars, nil, map[string]string{"state": uuid.New().String()})
+
+		s, err := p.OpenStore(cookie.SessionsStoreName)
+		require.NoError(t, err)
+		require.Equal(t, 2, count(t, s))
+
+		require.NoError(t, jars.Sweep())
+		require.Equal(t, 0, count(t, s))
+	})
+
+	t.Run("error if the cookie is invalid", func(t *testing.T) {
+		jars := newServerStore(t, 900)
+
+		_, err := jars.Open(request(&http.Cookie{Name: cookie.StoreName, Value: "invalid"}))
+		require.Error(t, err)
+	})
+}
+
+func newServerStore(t *testing.T, maxAge int) *cookie.ServerJars {
+	t.Helper()
+
+	jars, err := cookie.NewServerStore(config(t, maxAge), ariesmem.NewProvider(), ownerKey)
+	require.NoError(t, err)
+
+	return jars
+}
+
+// save sets the values in the session of the cookie, or in a new session, and returns the session cookie.
+func save(t *testing.T, jars *cookie.ServerJars, c *http.Cookie, values map[string]string) *http.Cookie {
+	t.Helper()
+
+	r := request(c)
+
+	jar, err := jars.Open(r)
+	require.NoError(t, err)
+
+	for k, v := range values {
+		jar.Set(k, v)
+	}
+
+	w := httptest.NewRecorder()
+	require.NoError(t, jar.Save(r, w))
+
+	cookies := w.Result().Cookies() // nolint:bodyclose // no body
+	require.Len(t, cookies, 1)
+
+	return cookies[0]
+}
+
+func count(t *testing.T, s ariesstorage.Store) int {
+	t.Helper()
+
+	iter, err := s.Query("session")
+	require.NoError(t, err)
+
+	defer func() {
+		require.NoError(t, iter.Close())
+	}()
+
+	n := 0
+
+	for {
+		more, err := iter.Next()
+		require.NoError(t, err)
+
+		if !more {
+			return n
+		}
+
+		n++
+	}
+}
+
+func request(c *http.Cookie) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	if c != nil {
+		r.AddCookie(c)
+	}
+
+	return r
+}
+
+func config(t *testing.T, maxAge int) *cookie.Config {
+	t.Helper()
+
+	return &cookie.Config{AuthKey: key(t), EncKey: key(t), MaxAge: maxAge}
+}
+
+func key(t *testing.T) []byte {
+	t.Helper()
+
+	key := make([]byte, 32)
+
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+
+	return key
+}
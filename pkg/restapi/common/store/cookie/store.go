@@ -34,7 +34,7 @@ func NewStore(config *Config) *Jars {
 
 // Jars is a collection of cookie Jars.
 type Jars struct {
-	cs *sessions.CookieStore
+	cs sessions.Store
 }
 
 // Open the Jar.
@@ -0,0 +1,391 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package encrypted provides an aries storage provider that encrypts values at rest.
+//
+// Every value is sealed with a random AES-256-GCM data key, and the data key is wrapped with a server-held
+// key-encryption key (KEK). The KEK's ID is stored alongside the ciphertext so that KEKs can be rotated: the first
+// key given to NewProvider encrypts new values, the remaining keys are only used to decrypt values written under
+// older keys. Values that are still in plaintext, or that were encrypted with an older key, are re-encrypted with
+// the current key when they are read. Tags are not encrypted since the underlying store needs them for queries.
+package encrypted
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	ariesstorage "github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/trustbloc/edge-core/pkg/log"
+)
+
+const (
+	algorithm = "A256GCMKW+A256GCM"
+	keySize   = 32
+)
+
+var logger = log.New("wallet/store/encrypted")
+
+// Key is a versioned key-encryption key.
+type Key struct {
+	ID     string
+	Secret []byte
+}
+
+type envelope struct {
+	Algorithm  string `json:"alg"`
+	KeyID      string `json:"kid"`
+	WrappedKey []byte `json:"wrappedKey"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+type keyRing struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+func newKeyRing(keys []*Key) (*keyRing, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("at least one key-encryption key is required")
+	}
+
+	kr := &keyRing{
+		current: keys[0].ID,
+		keys:    make(map[string]cipher.AEAD, len(keys)),
+	}
+
+	for _, k := range keys {
+		if k.ID == "" {
+			return nil, errors.New("key-encryption key ID cannot be empty")
+		}
+
+		if _, duplicate := kr.keys[k.ID]; duplicate {
+			return nil, fmt.Errorf("duplicate key-encryption key ID: %s", k.ID)
+		}
+
+		if len(k.Secret) != keySize {
+			return nil, fmt.Errorf("key-encryption key %s: need key of %d bytes but got %d", k.ID, keySize, len(k.Secret))
+		}
+
+		aead, err := newAEAD(k.Secret)
+		if err != nil {
+			return nil, fmt.Errorf("key-encryption key %s: %w", k.ID, err)
+		}
+
+		kr.keys[k.ID] = aead
+	}
+
+	return kr, nil
+}
+
+// NewProvider returns a Provider that encrypts the values of every store it opens from p.
+// The first key is used to encrypt; all keys can be used to decrypt.
+func NewProvider(p ariesstorage.Provider, keys ...*Key) (*Provider, error) {
+	kr, err := newKeyRing(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Provider{p: p, keys: kr}, nil
+}
+
+// Provider is an ariesstorage.Provider that encrypts values at rest.
+type Provider struct {
+	p    ariesstorage.Provider
+	keys *keyRing
+}
+
+// OpenStore opens the store with the given name.
+func (p *Provider) OpenStore(name string) (ariesstorage.Store, error) {
+	s, err := p.p.OpenStore(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Store{s: s, keys: p.keys}, nil
+}
+
+// SetStoreConfig sets the configuration on the underlying store.
+func (p *Provider) SetStoreConfig(name string, config ariesstorage.StoreConfiguration) error {
+	return p.p.SetStoreConfig(name, config)
+}
+
+// GetStoreConfig returns the configuration of the underlying store.
+func (p *Provider) GetStoreConfig(name string) (ariesstorage.StoreConfiguration, error) {
+	return p.p.GetStoreConfig(name)
+}
+
+// GetOpenStores returns all currently open stores.
+func (p *Provider) GetOpenStores() []ariesstorage.Store {
+	open := p.p.GetOpenStores()
+	stores := make([]ariesstorage.Store, len(open))
+
+	for i := range open {
+		stores[i] = &Store{s: open[i], keys: p.keys}
+	}
+
+	return stores
+}
+
+// Close closes the underlying provider.
+func (p *Provider) Close() error {
+	return p.p.Close()
+}
+
+// Store is an ariesstorage.Store that encrypts values at rest.
+type Store struct {
+	s    ariesstorage.Store
+	keys *keyRing
+}
+
+// Put encrypts the value and stores it under the given key.
+func (s *Store) Put(key string, value []byte, tags ...ariesstorage.Tag) error {
+	if value == nil {
+		return errors.New("value cannot be nil")
+	}
+
+	sealed, err := s.seal(key, value)
+	if err != nil {
+		return err
+	}
+
+	return s.s.Put(key, sealed, tags...)
+}
+
+// Get fetches and decrypts the value associated with the given key.
+func (s *Store) Get(key string) ([]byte, error) {
+	raw, err := s.s.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.openAndMigrate(key, raw)
+}
+
+// GetTags fetches all tags associated with the given key.
+func (s *Store) GetTags(key string) ([]ariesstorage.Tag, error) {
+	return s.s.GetTags(key)
+}
+
+// GetBulk fetches and decrypts the values associated with the given keys.
+func (s *Store) GetBulk(keys ...string) ([][]byte, error) {
+	raw, err := s.s.GetBulk(keys...)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([][]byte, len(raw))
+
+	for i := range raw {
+		if raw[i] == nil {
+			continue
+		}
+
+		values[i], err = s.openAndMigrate(keys[i], raw[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return values, nil
+}
+
+// Query returns an iterator over the decrypted values matching the given tag expression.
+func (s *Store) Query(expression string, options ...ariesstorage.QueryOption) (ariesstorage.Iterator, error) {
+	iter, err := s.s.Query(expression, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &iterator{Iterator: iter, s: s}, nil
+}
+
+// Delete deletes the value associated with the given key.
+func (s *Store) Delete(key string) error {
+	return s.s.Delete(key)
+}
+
+// Batch encrypts the values of all put operations and performs the batch on the underlying store.
+func (s *Store) Batch(operations []ariesstorage.Operation) error {
+	ops := make([]ariesstorage.Operation, len(operations))
+
+	for i := range operations {
+		ops[i] = operations[i]
+
+		if ops[i].Value == nil {
+			continue
+		}
+
+		sealed, err := s.seal(ops[i].Key, ops[i].Value)
+		if err != nil {
+			return err
+		}
+
+		ops[i].Value = sealed
+	}
+
+	return s.s.Batch(ops)
+}
+
+// Flush flushes the underlying store.
+func (s *Store) Flush() error {
+	return s.s.Flush()
+}
+
+// Close closes the underlying store.
+func (s *Store) Close() error {
+	return s.s.Close()
+}
+
+func (s *Store) seal(key string, value []byte) ([]byte, error) {
+	dek := make([]byte, keySize)
+
+	_, err := rand.Read(dek)
+	if err != nil {
+		return nil, fmt.Errorf("create data encryption key: %w", err)
+	}
+
+	content, err := newAEAD(dek)
+	if err != nil {
+		return nil, fmt.Errorf("init data encryption key: %w", err)
+	}
+
+	ciphertext, err := encrypt(content, value, []byte(key))
+	if err != nil {
+		return nil, fmt.Errorf("encrypt value: %w", err)
+	}
+
+	wrappedKey, err := encrypt(s.keys.keys[s.keys.current], dek, []byte(s.keys.current))
+	if err != nil {
+		return nil, fmt.Errorf("wrap data encryption key: %w", err)
+	}
+
+	bits, err := json.Marshal(&envelope{
+		Algorithm:  algorithm,
+		KeyID:      s.keys.current,
+		WrappedKey: wrappedKey,
+		Ciphertext: ciphertext,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("marshal encrypted value: %w", err)
+	}
+
+	return bits, nil
+}
+
+// open returns the plaintext and whether the stored value needs to be re-encrypted with the current key.
+func (s *Store) open(key string, raw []byte) ([]byte, bool, error) {
+	env := &envelope{}
+
+	err := json.Unmarshal(raw, env)
+	if err != nil || env.Algorithm != algorithm {
+		// not written by this store: a plaintext row from before encryption was enabled
+		return raw, true, nil
+	}
+
+	kek, found := s.keys.keys[env.KeyID]
+	if !found {
+		return nil, false, fmt.Errorf("unknown key-encryption key: %s", env.KeyID)
+	}
+
+	dek, err := decrypt(kek, env.WrappedKey, []byte(env.KeyID))
+	if err != nil {
+		return nil, false, fmt.Errorf("unwrap data encryption key: %w", err)
+	}
+
+	content, err := newAEAD(dek)
+	if err != nil {
+		return nil, false, fmt.Errorf("init data encryption key: %w", err)
+	}
+
+	value, err := decrypt(content, env.Ciphertext, []byte(key))
+	if err != nil {
+		return nil, false, fmt.Errorf("decrypt value: %w", err)
+	}
+
+	return value, env.KeyID != s.keys.current, nil
+}
+
+func (s *Store) openAndMigrate(key string, raw []byte) ([]byte, error) {
+	value, stale, err := s.open(key, raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt value for key %s: %w", key, err)
+	}
+
+	if stale {
+		s.migrate(key, value)
+	}
+
+	return value, nil
+}
+
+// migrate re-encrypts the value with the current key. Failures are logged and retried on the next read.
+func (s *Store) migrate(key string, value []byte) {
+	tags, err := s.s.GetTags(key)
+	if err != nil {
+		logger.Warnf("failed to fetch tags to re-encrypt value for key %s: %s", key, err.Error())
+
+		return
+	}
+
+	err = s.Put(key, value, tags...)
+	if err != nil {
+		logger.Warnf("failed to re-encrypt value for key %s: %s", key, err.Error())
+	}
+}
+
+type iterator struct {
+	ariesstorage.Iterator
+	s *Store
+}
+
+// Value returns the decrypted value of the current entry.
+func (i *iterator) Value() ([]byte, error) {
+	key, err := i.Iterator.Key()
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := i.Iterator.Value()
+	if err != nil {
+		return nil, err
+	}
+
+	return i.s.openAndMigrate(key, raw)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func encrypt(aead cipher.AEAD, plaintext, aad []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func decrypt(aead cipher.AEAD, ciphertext, aad []byte) ([]byte, error) {
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+
+	nonce, sealed := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+
+	return aead.Open(nil, nonce, sealed, aad)
+}
@@ -0,0 +1,215 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package encrypted_test
+
+import (
+	"crypto/rand"
+	"testing"
+
+	"github.com/google/uuid"
+	ariesmem "github.com/hyperledger/aries-framework-go/component/storageutil/mem"
+	ariesstorage "github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/wallet/pkg/restapi/common/store/encrypted"
+)
+
+const storeName = "test"
+
+func TestNewProvider(t *testing.T) {
+	t.Run("error if no keys", func(t *testing.T) {
+		_, err := encrypted.NewProvider(ariesmem.NewProvider())
+		require.Error(t, err)
+	})
+
+	t.Run("error if key has no ID", func(t *testing.T) {
+		_, err := encrypted.NewProvider(ariesmem.NewProvider(), &encrypted.Key{Secret: secret(t)})
+		require.Error(t, err)
+	})
+
+	t.Run("error if key IDs are duplicated", func(t *testing.T) {
+		_, err := encrypted.NewProvider(ariesmem.NewProvider(),
+			&encrypted.Key{ID: "v1", Secret: secret(t)}, &encrypted.Key{ID: "v1", Secret: secret(t)})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "duplicate")
+	})
+
+	t.Run("error if key has the wrong size", func(t *testing.T) {
+		_, err := encrypted.NewProvider(ariesmem.NewProvider(), &encrypted.Key{ID: "v1", Secret: []byte("short")})
+		require.Error(t, err)
+	})
+}
+
+func TestStore(t *testing.T) {
+	t.Run("encrypts values at rest", func(t *testing.T) {
+		raw := ariesmem.NewProvider()
+		s := openStore(t, raw, &encrypted.Key{ID: "v1", Secret: secret(t)})
+
+		value := []byte(`{"secret":"` + uuid.New().String() + `"}`)
+
+		require.NoError(t, s.Put("key", value, ariesstorage.Tag{Name: "tag"}))
+
+		stored := get(t, raw, "key")
+		require.NotEqual(t, value, stored)
+		require.NotContains(t, string(stored), string(value))
+
+		result, err := s.Get("key")
+		require.NoError(t, err)
+		require.Equal(t, value, result)
+
+		iter, err := s.Query("tag")
+		require.NoError(t, err)
+		next, err := iter.Next()
+		require.NoError(t, err)
+		require.True(t, next)
+		result, err = iter.Value()
+		require.NoError(t, err)
+		require.Equal(t, value, result)
+	})
+
+	t.Run("migrates plaintext values when read", func(t *testing.T) {
+		raw := ariesmem.NewProvider()
+		value := []byte(`{"plain":"text"}`)
+		put(t, raw, "key", value)
+
+		s := openStore(t, raw, &encrypted.Key{ID: "v1", Secret: secret(t)})
+
+		result, err := s.Get("key")
+		require.NoError(t, err)
+		require.Equal(t, value, result)
+		require.NotEqual(t, value, get(t, raw, "key"))
+
+		result, err = s.Get("key")
+		require.NoError(t, err)
+		require.Equal(t, value, result)
+	})
+
+	t.Run("migrates plaintext values when iterated", func(t *testing.T) {
+		raw := ariesmem.NewProvider()
+		value := []byte(`{"plain":"text"}`)
+
+		plain, err := raw.OpenStore(storeName)
+		require.NoError(t, err)
+		require.NoError(t, plain.Put("key", value, ariesstorage.Tag{Name: "tag"}))
+
+		s := openStore(t, raw, &encrypted.Key{ID: "v1", Secret: secret(t)})
+
+		iter, err := s.Query("tag")
+		require.NoError(t, err)
+
+		more, err := iter.Next()
+		require.NoError(t, err)
+		require.True(t, more)
+
+		result, err := iter.Value()
+		require.NoError(t, err)
+		require.Equal(t, value, result)
+		require.NoError(t, iter.Close())
+		require.NotEqual(t, value, get(t, raw, "key"))
+	})
+
+	t.Run("re-encrypts values written with an older key", func(t *testing.T) {
+		raw := ariesmem.NewProvider()
+		oldKey := &encrypted.Key{ID: "v1", Secret: secret(t)}
+		newKey := &encrypted.Key{ID: "v2", Secret: secret(t)}
+		value := []byte("value")
+
+		require.NoError(t, openStore(t, raw, oldKey).Put("key", value))
+
+		result, err := openStore(t, raw, newKey, oldKey).Get("key")
+		require.NoError(t, err)
+		require.Equal(t, value, result)
+
+		// the old key is no longer needed
+		result, err = openStore(t, raw, newKey).Get("key")
+		require.NoError(t, err)
+		require.Equal(t, value, result)
+	})
+
+	t.Run("error if key is unknown", func(t *testing.T) {
+		raw := ariesmem.NewProvider()
+
+		require.NoError(t, openStore(t, raw, &encrypted.Key{ID: "v1", Secret: secret(t)}).Put("key", []byte("value")))
+
+		_, err := openStore(t, raw, &encrypted.Key{ID: "v2", Secret: secret(t)}).Get("key")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "unknown key-encryption key")
+	})
+
+	t.Run("error if value was moved to another key", func(t *testing.T) {
+		raw := ariesmem.NewProvider()
+		s := openStore(t, raw, &encrypted.Key{ID: "v1", Secret: secret(t)})
+
+		require.NoError(t, s.Put("key1", []byte("value")))
+		put(t, raw, "key2", get(t, raw, "key1"))
+
+		_, err := s.Get("key2")
+		require.Error(t, err)
+	})
+
+	t.Run("bulk and batch operations", func(t *testing.T) {
+		raw := ariesmem.NewProvider()
+		s := openStore(t, raw, &encrypted.Key{ID: "v1", Secret: secret(t)})
+
+		require.NoError(t, s.Batch([]ariesstorage.Operation{
+			{Key: "key1", Value: []byte("value1")},
+			{Key: "key2", Value: []byte("value2")},
+		}))
+		require.NotEqual(t, []byte("value1"), get(t, raw, "key1"))
+
+		values, err := s.GetBulk("key1", "key2", "key3")
+		require.NoError(t, err)
+		require.Equal(t, [][]byte{[]byte("value1"), []byte("value2"), nil}, values)
+
+		require.NoError(t, s.Delete("key1"))
+		_, err = s.Get("key1")
+		require.ErrorIs(t, err, ariesstorage.ErrDataNotFound)
+	})
+}
+
+func openStore(t *testing.T, p ariesstorage.Provider, keys ...*encrypted.Key) ariesstorage.Store {
+	t.Helper()
+
+	provider, err := encrypted.NewProvider(p, keys...)
+	require.NoError(t, err)
+
+	s, err := provider.OpenStore(storeName)
+	require.NoError(t, err)
+
+	return s
+}
+
+func put(t *testing.T, p ariesstorage.Provider, k string, v []byte) {
+	t.Helper()
+
+	s, err := p.OpenStore(storeName)
+	require.NoError(t, err)
+	require.NoError(t, s.Put(k, v))
+}
+
+func get(t *testing.T, p ariesstorage.Provider, k string) []byte {
+	t.Helper()
+
+	s, err := p.OpenStore(storeName)
+	require.NoError(t, err)
+
+	v, err := s.Get(k)
+	require.NoError(t, err)
+
+	return v
+}
+
+func secret(t *testing.T) []byte {
+	t.Helper()
+
+	key := make([]byte, 32)
+
+	_, err := rand.Reader.Read(key)
+	require.NoError(t, err)
+
+	return key
+}
@@ -0,0 +1,188 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package onboarding
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	ariesstorage "github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/trustbloc/edge-core/pkg/log"
+
+	"github.com/trustbloc/wallet/pkg/restapi/common/store"
+)
+
+var logger = log.New("wallet/store/onboarding")
+
+const (
+	// StoreName is the name of the onboarding store.
+	StoreName = "edgeagent_onboarding"
+
+	stateTagName = "onboarding"
+)
+
+// Resource is a remote resource created while onboarding a user.
+type Resource struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+// State is the progress of the onboarding of a user. It holds the output of every completed step so that an
+// interrupted onboarding can be resumed where it stopped. A State is only saved while it holds no secret share other
+// than the wallet share: see HoldsOtherShares.
+type State struct {
+	UserID    string    `json:"userID"`
+	Completed []string  `json:"completed"`
+	StartedAt time.Time `json:"startedAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	// Resources are removed if the onboarding is abandoned.
+	Resources []*Resource `json:"resources"`
+
+	WalletSecretShare []byte `json:"walletSecretShare"`
+	// AuthSecretShare is cleared once it is posted to the auth server.
+	AuthSecretShare []byte `json:"authSecretShare,omitempty"`
+	// RecoveryCodes are cleared once they are escrowed or held for the user.
+	RecoveryCodes         []string `json:"recoveryCodes,omitempty"`
+	AuthzKeyStoreURL      string   `json:"authzKeyStoreURL"`
+	AuthzKeyID            string   `json:"authzKeyID"`
+	Controller            string   `json:"controller"`
+	KMSVaultURL           string   `json:"kmsVaultURL"`
+	KMSEDVCapability      []byte   `json:"kmsEDVCapability"`
+	EDVController         string   `json:"edvController"`
+	EDVZCAPs              []byte   `json:"edvZCAPs"`
+	OpsKeyStoreURL        string   `json:"opsKeyStoreURL"`
+	OpsKeyStoreCapability []byte   `json:"opsKeyStoreCapability"`
+	UserEDVVaultURL       string   `json:"userEDVVaultURL"`
+	UserEDVCapability     []byte   `json:"userEDVCapability"`
+	EDVOpsKID             string   `json:"edvOpsKID"`
+	EDVHMACKID            string   `json:"edvHMACKID"`
+}
+
+// Done returns true if the step was completed.
+func (s *State) Done(step string) bool {
+	for _, completed := range s.Completed {
+		if completed == step {
+			return true
+		}
+	}
+
+	return false
+}
+
+// HoldsOtherShares returns true while the State holds secret shares other than the wallet share. Any two shares
+// recover the user's secret, so they must never be saved together.
+func (s *State) HoldsOtherShares() bool {
+	return len(s.AuthSecretShare) > 0 || len(s.RecoveryCodes) > 0
+}
+
+// Complete marks the step as completed.
+func (s *State) Complete(step string) {
+	if !s.Done(step) {
+		s.Completed = append(s.Completed, step)
+	}
+}
+
+// AddResource records a remote resource created by the onboarding. Empty URLs are ignored.
+func (s *State) AddResource(resourceType, url string) {
+	if url != "" {
+		s.Resources = append(s.Resources, &Resource{Type: resourceType, URL: url})
+	}
+}
+
+// NewStore returns a new onboarding Store.
+func NewStore(p ariesstorage.Provider) (*Store, error) {
+	s, err := store.Open(p, StoreName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open onboarding store: %w", err)
+	}
+
+	err = p.SetStoreConfig(StoreName, ariesstorage.StoreConfiguration{TagNames: []string{stateTagName}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to set onboarding store config: %w", err)
+	}
+
+	return &Store{s: s}, nil
+}
+
+// Store holds the onboarding State of users.
+type Store struct {
+	s ariesstorage.Store
+}
+
+// Save the State with the user's ID as the key.
+func (s *Store) Save(state *State) error {
+	bits, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to marshal onboarding state: %w", err)
+	}
+
+	return s.s.Put(state.UserID, bits, ariesstorage.Tag{Name: stateTagName})
+}
+
+// Get the onboarding State of the user with the given ID.
+func (s *Store) Get(userID string) (*State, error) {
+	bits, err := s.s.Get(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch onboarding state from store: %w", err)
+	}
+
+	state := &State{}
+
+	return state, json.Unmarshal(bits, state)
+}
+
+// Delete the onboarding State of the user with the given ID.
+func (s *Store) Delete(userID string) error {
+	err := s.s.Delete(userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete onboarding state from store: %w", err)
+	}
+
+	return nil
+}
+
+// List the onboarding States of all users.
+func (s *Store) List() ([]*State, error) {
+	iter, err := s.s.Query(stateTagName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query onboarding states: %w", err)
+	}
+
+	defer func() {
+		if closeErr := iter.Close(); closeErr != nil {
+			logger.Warnf("failed to close onboarding states iterator: %s", closeErr.Error())
+		}
+	}()
+
+	var states []*State
+
+	for {
+		more, err := iter.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate onboarding states: %w", err)
+		}
+
+		if !more {
+			return states, nil
+		}
+
+		bits, err := iter.Value()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read onboarding state: %w", err)
+		}
+
+		state := &State{}
+
+		err = json.Unmarshal(bits, state)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse onboarding state: %w", err)
+		}
+
+		states = append(states, state)
+	}
+}
@@ -7,24 +7,42 @@ SPDX-License-Identifier: Apache-2.0
 package tokens
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"time"
 
 	ariesstorage "github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/trustbloc/edge-core/pkg/log"
 
 	"github.com/trustbloc/wallet/pkg/restapi/common/store"
 )
 
+var logger = log.New("wallet/store/tokens")
+
 const (
 	// StoreName is the name of the token store.
 	StoreName = "edgeagent_tks"
+
+	sessionTagName = "session"
 )
 
 // UserTokens are the tokens associated to a User.
 type UserTokens struct {
+	// UserSub is the ID of the user, see user.ID.
 	UserSub string
-	Access  string
-	Refresh string
+	// Provider is the ID of the OIDC provider that issued the tokens. Empty for the default provider.
+	Provider string
+	Access   string
+	Refresh  string
+	// Expiry of the access token. The zero value means the token does not expire.
+	Expiry time.Time
+	// IDToken is the raw id_token, sent as a hint when ending the user's session at the provider.
+	IDToken string
+	// SessionID is the sid claim of the id_token: the session of the user at the provider, if the provider
+	// identifies sessions. Back-channel logout tokens may identify users by their session only.
+	SessionID string
 }
 
 // NewStore returns a new token Store.
@@ -34,6 +52,11 @@ func NewStore(p ariesstorage.Provider) (*Store, error) {
 		return nil, fmt.Errorf("failed to open tokens store: %w", err)
 	}
 
+	err = p.SetStoreConfig(StoreName, ariesstorage.StoreConfiguration{TagNames: []string{sessionTagName}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to set tokens store config: %w", err)
+	}
+
 	return &Store{s: s}, nil
 }
 
@@ -44,7 +67,16 @@ type Store struct {
 
 // Save the UserTokens to the store.
 func (s *Store) Save(ut *UserTokens) error {
-	return store.Save(s.s, ut.UserSub, ut)
+	if ut.SessionID == "" {
+		return store.Save(s.s, ut.UserSub, ut)
+	}
+
+	bits, err := json.Marshal(ut)
+	if err != nil {
+		return fmt.Errorf("failed to marshal user tokens: %w", err)
+	}
+
+	return s.s.Put(ut.UserSub, bits, ariesstorage.Tag{Name: sessionTagName, Value: sessionTag(ut.Provider, ut.SessionID)})
 }
 
 // Get fetches a UserTokens from the underlying storage.
@@ -58,3 +90,55 @@ func (s *Store) Get(sub string) (*UserTokens, error) {
 
 	return tokens, json.Unmarshal(raw, tokens)
 }
+
+// Delete removes the UserTokens of the given user.
+func (s *Store) Delete(sub string) error {
+	err := s.s.Delete(sub)
+	if err != nil {
+		return fmt.Errorf("failed to delete user tokens from store: %w", err)
+	}
+
+	return nil
+}
+
+// UsersOfSession returns the IDs of the users whose tokens were issued in the given session at the given provider.
+func (s *Store) UsersOfSession(provider, sessionID string) ([]string, error) {
+	iter, err := s.s.Query(sessionTagName + ":" + sessionTag(provider, sessionID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query user tokens: %w", err)
+	}
+
+	defer func() {
+		if closeErr := iter.Close(); closeErr != nil {
+			logger.Warnf("failed to close user tokens iterator: %s", closeErr.Error())
+		}
+	}()
+
+	var userIDs []string
+
+	for {
+		more, err := iter.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate user tokens: %w", err)
+		}
+
+		if !more {
+			return userIDs, nil
+		}
+
+		key, err := iter.Key()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read user tokens key: %w", err)
+		}
+
+		userIDs = append(userIDs, key)
+	}
+}
+
+// sessionTag returns the value of the tag of the tokens issued in a session. Tags are not encrypted at rest, so
+// session IDs are only kept hashed in them.
+func sessionTag(provider, sessionID string) string {
+	sum := sha256.Sum256([]byte(provider + " " + sessionID))
+
+	return hex.EncodeToString(sum[:])
+}
@@ -9,6 +9,7 @@ package user
 import (
 	"encoding/json"
 	"fmt"
+	"time"
 
 	ariesstorage "github.com/hyperledger/aries-framework-go/spi/storage"
 
@@ -25,12 +26,47 @@ const (
 // The user attributes are based on standard OIDC claims:
 // https://openid.net/specs/openid-connect-core-1_0.html#StandardClaims.
 type User struct {
+	Issuer      string `json:"iss"`
 	Sub         string `json:"sub"`
 	Name        string `json:"name"`
 	GivenName   string `json:"given_name"`
 	FamilyName  string `json:"family_name"`
 	Email       string `json:"email"`
 	SecretShare string `json:"secretShare"`
+	// SessionID is the session of the user at the provider when the id_token was issued, if the provider
+	// identifies sessions.
+	SessionID string `json:"sid,omitempty"`
+	// RecoveryCodes are the recovery shares that earlier versions kept for users until they fetched them.
+	RecoveryCodes []string `json:"recoveryCodes,omitempty"`
+	// Deletion is set while the user's account is being deleted.
+	Deletion *Deletion `json:"deletion,omitempty"`
+}
+
+// Deletion is the progress of the deletion of a user's account. The user is kept until all the user's remote
+// resources are removed, so that a deletion that failed can be retried.
+type Deletion struct {
+	StartedAt time.Time `json:"startedAt"`
+	// Resources are the URLs of the user's remote resources by deletion step, once they are known.
+	Resources map[string]string `json:"resources,omitempty"`
+	Completed []string          `json:"completed"`
+}
+
+// Done returns true if the step was completed.
+func (d *Deletion) Done(step string) bool {
+	for _, completed := range d.Completed {
+		if completed == step {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Complete marks the step as completed.
+func (d *Deletion) Complete(step string) {
+	if !d.Done(step) {
+		d.Completed = append(d.Completed, step)
+	}
 }
 
 // ParseIDToken parses a User from an IDToken.
@@ -59,6 +95,21 @@ func evaluateClaims(u *User) error {
 	return nil
 }
 
+// ID returns the key of the records of the user with the given 'sub' at the OIDC provider 'issuer'.
+// Users of different providers may share the same 'sub'.
+func ID(issuer, sub string) string {
+	if issuer == "" {
+		return sub
+	}
+
+	return issuer + "#" + sub
+}
+
+// ID returns the key of the user's records.
+func (u *User) ID() string {
+	return ID(u.Issuer, u.Sub)
+}
+
 // NewStore returns a new user Store.
 func NewStore(p ariesstorage.Provider) (*Store, error) {
 	s, err := store.Open(p, StoreName)
@@ -74,14 +125,14 @@ type Store struct {
 	s ariesstorage.Store
 }
 
-// Save this user with the user's 'sub' as the key.
+// Save this user with the user's ID as the key.
 func (s *Store) Save(u *User) error {
-	return store.Save(s.s, u.Sub, u)
+	return store.Save(s.s, u.ID(), u)
 }
 
-// Get the User with the given 'sub'.
-func (s *Store) Get(sub string) (*User, error) {
-	bits, err := s.s.Get(sub)
+// Get the User with the given ID.
+func (s *Store) Get(id string) (*User, error) {
+	bits, err := s.s.Get(id)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch user from store: %w", err)
 	}
@@ -90,3 +141,13 @@ func (s *Store) Get(sub string) (*User, error) {
 
 	return user, json.Unmarshal(bits, user)
 }
+
+// Delete the User with the given ID.
+func (s *Store) Delete(id string) error {
+	err := s.s.Delete(id)
+	if err != nil {
+		return fmt.Errorf("failed to delete user from store: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,438 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	ariesstorage "github.com/hyperledger/aries-framework-go/spi/storage"
+
+	"github.com/trustbloc/wallet/pkg/restapi/common"
+	"github.com/trustbloc/wallet/pkg/restapi/common/store/user"
+)
+
+const (
+	userQueryParam = "user"
+	subQueryParam  = "sub"
+	// deactivateKeyStorePath is appended to the URL of a keystore to deactivate it. The KMS keeps deactivated
+	// keystores but refuses to use their keys.
+	deactivateKeyStorePath = "/deactivate"
+)
+
+// Account deletion steps, in the order they run.
+const (
+	stepDeleteUserVault         = "user-vault"
+	stepDeleteOpsVault          = "ops-vault"
+	stepDeactivateOpsKeyStore   = "ops-keystore"
+	stepDeactivateAuthzKeyStore = "authz-keystore"
+	stepDeleteBootstrapData     = "bootstrap-data"
+	stepDeleteSecret            = "secret-share"
+	stepDeleteTokens            = "tokens"
+	stepDeleteOnboarding        = "onboarding-state"
+	stepDeleteUser              = "user"
+)
+
+// Outcomes of account deletion steps.
+const (
+	statusDeleted     = "deleted"
+	statusDeactivated = "deactivated"
+	statusFailed      = "failed"
+	statusSkipped     = "skipped"
+)
+
+// remoteDeletionSteps are the account deletion steps removing the user's remote resources.
+var remoteDeletionSteps = []string{ // nolint:gochecknoglobals // constant list
+	stepDeleteUserVault, stepDeleteOpsVault, stepDeactivateOpsKeyStore, stepDeactivateAuthzKeyStore,
+	stepDeleteBootstrapData, stepDeleteSecret,
+}
+
+// errNoResource is returned for the remote resources the user does not have.
+var errNoResource = errors.New("not found")
+
+// remoteAuth authorizes the requests removing the remote resources of a user.
+type remoteAuth struct {
+	// accessToken is presented to the KMS and EDV servers.
+	accessToken string
+	// operator is true if the wallet server acts with its service credentials instead of the user's tokens. Hub-auth
+	// is then called with its API token.
+	operator bool
+}
+
+// GetAdminRESTHandlers returns the handlers of the administrative API. They must only be exposed to operators.
+func (o *Operation) GetAdminRESTHandlers() []common.Handler {
+	return []common.Handler{
+		common.NewHTTPHandler(accountPath, http.MethodDelete, o.adminDeleteAccountHandler),
+	}
+}
+
+// deleteAccountHandler deletes the account of the logged in user, and ends the user's session once the deletion is
+// complete.
+func (o *Operation) deleteAccountHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("handling delete account request")
+
+	jar, err := o.store.cookies.Open(r)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger,
+			http.StatusBadRequest, "cannot open cookies: %s", err.Error())
+
+		return
+	}
+
+	userIDCookie, found := jar.Get(userSubCookieName)
+	if !found || !o.hasActiveSession(userIDCookie) {
+		common.WriteErrorResponsef(w, logger, http.StatusForbidden, "not logged in")
+
+		return
+	}
+
+	userID, _ := userIDCookie.(string) // nolint:errcheck // checked by hasActiveSession
+
+	resp, err := o.deleteAccount(r.Context(), userID, false)
+	if err != nil {
+		writeDeleteAccountError(w, err)
+
+		return
+	}
+
+	if resp.Complete {
+		jar.Delete(userSubCookieName)
+
+		err = jar.Save(r, w)
+		if err != nil {
+			common.WriteErrorResponsef(w, logger, http.StatusInternalServerError,
+				"failed to delete user sub cookie: %s", err.Error())
+
+			return
+		}
+	}
+
+	common.WriteResponse(w, logger, resp)
+	logger.Debugf("finished handling delete account request")
+}
+
+// adminDeleteAccountHandler deletes the account of the user with the given ID with the service credentials of the
+// wallet server, so that the accounts of users without valid tokens can be deleted.
+func (o *Operation) adminDeleteAccountHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("handling admin delete account request")
+
+	userID := r.URL.Query().Get(userQueryParam)
+	if userID == "" {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing %s query parameter", userQueryParam)
+
+		return
+	}
+
+	resp, err := o.deleteAccount(r.Context(), userID, true)
+	if err != nil {
+		writeDeleteAccountError(w, err)
+
+		return
+	}
+
+	common.WriteResponse(w, logger, resp)
+	logger.Debugf("finished handling admin delete account request")
+}
+
+func writeDeleteAccountError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if errors.Is(err, ariesstorage.ErrDataNotFound) {
+		status = http.StatusNotFound
+	}
+
+	common.WriteErrorResponsef(w, logger, status, "failed to delete account: %s", err.Error())
+}
+
+// deleteAccount removes the user's remote resources and then the user's local records. The progress of the deletion
+// is kept in the user's record, which is only removed once every remote resource is removed: a deletion that failed
+// is retried by deleting the account again, and the user cannot log in meanwhile. Operators delete accounts with the
+// service credentials of the wallet server if they are configured, users with their own tokens.
+func (o *Operation) deleteAccount(ctx context.Context, userID string, operator bool) (*accountDeletionResp, error) {
+	usr, err := o.store.users.Get(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Infof("deleting account of user %s", userID)
+
+	if usr.Deletion == nil {
+		usr.Deletion = &user.Deletion{StartedAt: time.Now()}
+
+		err = o.store.users.Save(usr)
+		if err != nil {
+			return nil, fmt.Errorf("save account deletion: %w", err)
+		}
+	}
+
+	resp := &accountDeletionResp{UserID: userID, Complete: true}
+
+	auth, err := o.remoteAuth(ctx, userID, operator)
+	if err != nil {
+		skipRemoteDeletion(resp, usr.Deletion, fmt.Sprintf("no valid access token: %s", err.Error()))
+	} else {
+		o.deleteRemoteResources(resp, usr, auth)
+	}
+
+	if !resp.Complete {
+		err = o.store.users.Save(usr)
+		if err != nil {
+			return nil, fmt.Errorf("save account deletion: %w", err)
+		}
+
+		for _, step := range []string{stepDeleteTokens, stepDeleteOnboarding, stepDeleteUser} {
+			resp.skip(step, "kept until the remote resources are removed: delete the account again to retry")
+		}
+
+		logDeletion(resp)
+
+		return resp, nil
+	}
+
+	// best effort: revokes the user's tokens at the provider
+	o.endUserSession(ctx, userID)
+
+	resp.record(stepDeleteTokens, statusDeleted, ignoreNotFound(o.store.tokens.Delete(userID)))
+	resp.record(stepDeleteOnboarding, statusDeleted, ignoreNotFound(o.store.onboarding.Delete(userID)))
+	resp.record(stepDeleteUser, statusDeleted, o.store.users.Delete(userID))
+
+	logDeletion(resp)
+
+	return resp, nil
+}
+
+// remoteAuth returns the credentials authorizing the removal of the user's remote resources: the service
+// credentials of the wallet server for operators if they are configured, or the user's tokens.
+func (o *Operation) remoteAuth(ctx context.Context, userID string, operator bool) (*remoteAuth, error) {
+	if operator && o.serviceTokens != nil {
+		token, err := o.serviceTokens.Token()
+		if err != nil {
+			return nil, fmt.Errorf("fetch service token: %w", err)
+		}
+
+		return &remoteAuth{accessToken: token.AccessToken, operator: true}, nil
+	}
+
+	tokns, _, err := o.validTokens(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &remoteAuth{accessToken: tokns.AccessToken}, nil
+}
+
+func (o *Operation) deleteRemoteResources(resp *accountDeletionResp, usr *user.User, auth *remoteAuth) {
+	deletion := usr.Deletion
+
+	if deletion.Resources == nil {
+		resources, err := o.accountResources(usr, auth)
+		if err != nil {
+			// the bootstrap data is the only record of the URLs of the user's resources: nothing is removed
+			for _, step := range remoteDeletionSteps {
+				resp.record(step, remoteStepStatus(step), err)
+			}
+
+			return
+		}
+
+		deletion.Resources = resources
+	}
+
+	bearer := func(req *http.Request) {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", auth.accessToken))
+	}
+
+	o.removeRemote(resp, deletion, stepDeleteUserVault, func() (*http.Request, error) {
+		return newRemoteRequest(http.MethodDelete, deletion.Resources[stepDeleteUserVault], bearer)
+	})
+	o.removeRemote(resp, deletion, stepDeleteOpsVault, func() (*http.Request, error) {
+		return newRemoteRequest(http.MethodDelete, deletion.Resources[stepDeleteOpsVault], bearer)
+	})
+	o.removeRemote(resp, deletion, stepDeactivateOpsKeyStore, func() (*http.Request, error) {
+		return newRemoteRequest(http.MethodPost, keyStoreDeactivation(deletion.Resources[stepDeactivateOpsKeyStore]),
+			bearer)
+	})
+	o.removeRemote(resp, deletion, stepDeactivateAuthzKeyStore, func() (*http.Request, error) {
+		return newRemoteRequest(http.MethodPost,
+			keyStoreDeactivation(deletion.Resources[stepDeactivateAuthzKeyStore]), func(req *http.Request) {
+				bearer(req)
+				req.Header.Set("Secret-Share", usr.SecretShare)
+			})
+	})
+	o.removeRemote(resp, deletion, stepDeleteBootstrapData, func() (*http.Request, error) {
+		return o.hubAuthRequest(http.MethodDelete, authBootstrapDataPath, usr, auth)
+	})
+	o.removeRemote(resp, deletion, stepDeleteSecret, func() (*http.Request, error) {
+		return o.hubAuthRequest(http.MethodDelete, authSecretPath, usr, auth)
+	})
+}
+
+// accountResources returns the URLs of the user's vaults and keystores listed in the user's bootstrap data, by
+// deletion step.
+func (o *Operation) accountResources(usr *user.User, auth *remoteAuth) (map[string]string, error) {
+	req, err := o.hubAuthRequest(http.MethodGet, authBootstrapDataPath, usr, auth)
+	if err != nil {
+		return nil, fmt.Errorf("get bootstrap data : %w", err)
+	}
+
+	respBody, _, err := common.SendHTTPRequest(req, o.httpClient, http.StatusOK, logger)
+	if err != nil {
+		return nil, fmt.Errorf("get bootstrap data : %w", err)
+	}
+
+	bootstrap := &userBootstrapData{}
+
+	err = json.Unmarshal(respBody, bootstrap)
+	if err != nil {
+		return nil, fmt.Errorf("get bootstrap data : %w", err)
+	}
+
+	data := bootstrap.Data
+	if data == nil {
+		data = &BootstrapData{}
+	}
+
+	return map[string]string{
+		stepDeleteUserVault:         vaultLocation(data.UserEDVServer, data.UserEDVVaultURL),
+		stepDeleteOpsVault:          vaultLocation(o.keyServer.KeyEDVURL, data.OpsEDVVaultURL),
+		stepDeactivateOpsKeyStore:   data.OpsKeyStoreURL,
+		stepDeactivateAuthzKeyStore: data.AuthzKeyStoreURL,
+	}, nil
+}
+
+// hubAuthRequest returns a request to hub-auth about the user's data, authorized by the user's access token or by
+// the hub-auth API token for operators.
+func (o *Operation) hubAuthRequest(method, path string, usr *user.User, auth *remoteAuth) (*http.Request, error) {
+	if !auth.operator {
+		return newRemoteRequest(method, o.hubAuthURL+path, func(req *http.Request) {
+			addAccessToken(req, auth.accessToken)
+		})
+	}
+
+	if o.secretShares.HubAuthAPIToken == "" {
+		return nil, errors.New("hub-auth API token is not configured")
+	}
+
+	return newRemoteRequest(method, o.hubAuthURL+path+"?"+subQueryParam+"="+url.QueryEscape(usr.Sub),
+		func(req *http.Request) {
+			req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", o.secretShares.HubAuthAPIToken))
+		})
+}
+
+// removeRemote runs a remote deletion step that was not completed yet. Steps of resources the user does not have are
+// skipped.
+func (o *Operation) removeRemote(resp *accountDeletionResp, deletion *user.Deletion, step string,
+	request func() (*http.Request, error)) {
+	if deletion.Done(step) {
+		resp.record(step, remoteStepStatus(step), nil)
+
+		return
+	}
+
+	req, err := request()
+	if errors.Is(err, errNoResource) {
+		resp.skip(step, err.Error())
+		deletion.Complete(step)
+
+		return
+	} else if err != nil {
+		resp.record(step, remoteStepStatus(step), err)
+
+		return
+	}
+
+	err = deleteResource(req, o.httpClient)
+	if err == nil {
+		deletion.Complete(step)
+	}
+
+	resp.record(step, remoteStepStatus(step), err)
+}
+
+// skipRemoteDeletion reports the remote deletion steps that were not completed yet as skipped.
+func skipRemoteDeletion(resp *accountDeletionResp, deletion *user.Deletion, reason string) {
+	for _, step := range remoteDeletionSteps {
+		if deletion.Done(step) {
+			resp.record(step, remoteStepStatus(step), nil)
+
+			continue
+		}
+
+		resp.skip(step, reason)
+		resp.Complete = false
+	}
+}
+
+// newRemoteRequest returns a request to a remote resource, or errNoResource if the resource has no URL.
+func newRemoteRequest(method, resourceURL string, setHeaders func(*http.Request)) (*http.Request, error) {
+	if resourceURL == "" {
+		return nil, errNoResource
+	}
+
+	req, err := http.NewRequestWithContext(context.TODO(), method, resourceURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	setHeaders(req)
+
+	return req, nil
+}
+
+func keyStoreDeactivation(keyStoreURL string) string {
+	if keyStoreURL == "" {
+		return ""
+	}
+
+	return keyStoreURL + deactivateKeyStorePath
+}
+
+// remoteStepStatus returns the outcome of a remote deletion step that succeeded: keystores are deactivated, the other
+// resources deleted.
+func remoteStepStatus(step string) string {
+	if step == stepDeactivateOpsKeyStore || step == stepDeactivateAuthzKeyStore {
+		return statusDeactivated
+	}
+
+	return statusDeleted
+}
+
+func logDeletion(resp *accountDeletionResp) {
+	for _, step := range resp.Steps {
+		if step.Status == statusFailed || step.Status == statusSkipped {
+			logger.Warnf("account deletion of user %s: %s %s: %s", resp.UserID, step.Name, step.Status, step.Detail)
+		}
+	}
+}
+
+func ignoreNotFound(err error) error {
+	if errors.Is(err, ariesstorage.ErrDataNotFound) {
+		return nil
+	}
+
+	return err
+}
+
+func (r *accountDeletionResp) record(step, status string, err error) {
+	s := &accountDeletionStep{Name: step, Status: status}
+
+	if err != nil {
+		s.Status = statusFailed
+		s.Detail = err.Error()
+		r.Complete = false
+	}
+
+	r.Steps = append(r.Steps, s)
+}
+
+func (r *accountDeletionResp) skip(step, reason string) {
+	r.Steps = append(r.Steps, &accountDeletionStep{Name: step, Status: statusSkipped, Detail: reason})
+}
@@ -0,0 +1,269 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/uuid"
+	ariesstorage "github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+
+	"github.com/trustbloc/wallet/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/wallet/pkg/restapi/common/store/tokens"
+	"github.com/trustbloc/wallet/pkg/restapi/common/store/user"
+)
+
+func TestOperation_DeleteAccountHandler(t *testing.T) {
+	t.Run("deletes the user's resources and records", func(t *testing.T) {
+		sub := uuid.New().String()
+		o, remote := newAccountTestOperation(t, sub)
+
+		jar := &cookie.MockJar{Cookies: map[interface{}]interface{}{userSubCookieName: sub}}
+		o.store.cookies = &cookie.MockStore{Jar: jar}
+
+		resp := deleteAccount(t, o)
+		require.True(t, resp.Complete)
+		require.Len(t, resp.Steps, 9)
+
+		for _, step := range resp.Steps {
+			require.Equal(t, remoteStepStatus(step.Name), step.Status, step.Name)
+		}
+
+		require.Equal(t, 1, remote.count(http.MethodDelete, "/user-vaults/uv1"))
+		require.Equal(t, 1, remote.count(http.MethodDelete, "/encrypted-data-vaults/ov1"))
+		require.Equal(t, 1, remote.count(http.MethodPost, "/v1/keystores/ops"+deactivateKeyStorePath))
+		require.Equal(t, 1, remote.count(http.MethodPost, "/v1/keystores/authz"+deactivateKeyStorePath))
+		require.Equal(t, 0, remote.count(http.MethodDelete, "/v1/keystores/ops"))
+		require.Equal(t, 1, remote.count(http.MethodDelete, authBootstrapDataPath))
+		require.Equal(t, 1, remote.count(http.MethodDelete, authSecretPath))
+
+		_, err := o.store.users.Get(sub)
+		require.ErrorIs(t, err, ariesstorage.ErrDataNotFound)
+		_, err = o.store.tokens.Get(sub)
+		require.ErrorIs(t, err, ariesstorage.ErrDataNotFound)
+		_, found := jar.Get(userSubCookieName)
+		require.False(t, found)
+	})
+
+	t.Run("keeps the user until a failed deletion is retried", func(t *testing.T) {
+		sub := uuid.New().String()
+		o, remote := newAccountTestOperation(t, sub)
+		remote.status["/v1/keystores/authz"+deactivateKeyStorePath] = http.StatusForbidden
+
+		jar := &cookie.MockJar{Cookies: map[interface{}]interface{}{userSubCookieName: sub}}
+		o.store.cookies = &cookie.MockStore{Jar: jar}
+
+		resp := deleteAccount(t, o)
+		require.False(t, resp.Complete)
+		require.Equal(t, stepDeactivateAuthzKeyStore, resp.Steps[3].Name)
+		require.Equal(t, statusFailed, resp.Steps[3].Status)
+		require.Contains(t, resp.Steps[3].Detail, "403")
+		require.Equal(t, statusSkipped, resp.Steps[8].Status)
+
+		usr, err := o.store.users.Get(sub)
+		require.NoError(t, err)
+		require.NotNil(t, usr.Deletion)
+		require.True(t, usr.Deletion.Done(stepDeleteUserVault))
+		require.False(t, usr.Deletion.Done(stepDeactivateAuthzKeyStore))
+		_, err = o.store.tokens.Get(sub)
+		require.NoError(t, err)
+		_, found := jar.Get(userSubCookieName)
+		require.True(t, found)
+
+		w := callback(o)
+		require.Equal(t, http.StatusForbidden, w.Code)
+		require.Contains(t, w.Body.String(), "being deleted")
+
+		delete(remote.status, "/v1/keystores/authz"+deactivateKeyStorePath)
+		o.store.cookies = &cookie.MockStore{Jar: jar}
+
+		resp = deleteAccount(t, o)
+		require.True(t, resp.Complete)
+		require.Equal(t, 1, remote.count(http.MethodDelete, "/user-vaults/uv1"))
+		require.Equal(t, 2, remote.count(http.MethodPost, "/v1/keystores/authz"+deactivateKeyStorePath))
+		require.Equal(t, 1, remote.count(http.MethodGet, authBootstrapDataPath))
+
+		_, err = o.store.users.Get(sub)
+		require.ErrorIs(t, err, ariesstorage.ErrDataNotFound)
+	})
+
+	t.Run("removes nothing if the bootstrap data cannot be fetched", func(t *testing.T) {
+		sub := uuid.New().String()
+		o, remote := newAccountTestOperation(t, sub)
+		remote.status[authBootstrapDataPath] = http.StatusInternalServerError
+		o.store.cookies = &cookie.MockStore{
+			Jar: &cookie.MockJar{Cookies: map[interface{}]interface{}{userSubCookieName: sub}},
+		}
+
+		resp := deleteAccount(t, o)
+		require.False(t, resp.Complete)
+
+		for _, step := range resp.Steps[:len(remoteDeletionSteps)] {
+			require.Equal(t, statusFailed, step.Status, step.Name)
+		}
+
+		require.Equal(t, 0, remote.count(http.MethodDelete, authBootstrapDataPath))
+		require.Equal(t, 0, remote.count(http.MethodDelete, authSecretPath))
+	})
+
+	t.Run("err forbidden if the user is not logged in", func(t *testing.T) {
+		sub := uuid.New().String()
+		o, _ := newAccountTestOperation(t, sub)
+		o.store.cookies = &cookie.MockStore{Jar: &cookie.MockJar{Cookies: map[interface{}]interface{}{}}}
+
+		w := httptest.NewRecorder()
+		o.deleteAccountHandler(w, newDeleteAccountRequest())
+		require.Equal(t, http.StatusForbidden, w.Code)
+
+		_, err := o.store.users.Get(sub)
+		require.NoError(t, err)
+	})
+
+	t.Run("err badrequest if cannot open cookies", func(t *testing.T) {
+		o, _ := newAccountTestOperation(t, uuid.New().String())
+		o.store.cookies = &cookie.MockStore{OpenErr: errors.New("test")}
+
+		w := httptest.NewRecorder()
+		o.deleteAccountHandler(w, newDeleteAccountRequest())
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestOperation_AdminDeleteAccountHandler(t *testing.T) {
+	t.Run("deletes the resources of users without tokens with the service credentials", func(t *testing.T) {
+		sub := uuid.New().String()
+		o, remote := newAccountTestOperation(t, sub)
+		require.NoError(t, o.store.tokens.Delete(sub))
+		o.serviceTokens = oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "service-token"})
+		o.secretShares.HubAuthAPIToken = "hub-auth-token"
+
+		resp := adminDeleteAccount(t, o, sub)
+		require.True(t, resp.Complete)
+
+		kms := remote.last[http.MethodPost+" /v1/keystores/ops"+deactivateKeyStorePath]
+		require.Equal(t, "Bearer service-token", kms.Header.Get("Authorization"))
+
+		for _, method := range []string{http.MethodGet, http.MethodDelete} {
+			hubAuth := remote.last[method+" "+authBootstrapDataPath]
+			require.Equal(t, "Bearer hub-auth-token", hubAuth.Header.Get("Authorization"))
+			require.Equal(t, sub, hubAuth.URL.Query().Get(subQueryParam))
+		}
+
+		_, err := o.store.users.Get(sub)
+		require.ErrorIs(t, err, ariesstorage.ErrDataNotFound)
+	})
+
+	t.Run("keeps users without tokens if there are no service credentials", func(t *testing.T) {
+		sub := uuid.New().String()
+		o, remote := newAccountTestOperation(t, sub)
+		require.NoError(t, o.store.tokens.Delete(sub))
+
+		resp := adminDeleteAccount(t, o, sub)
+		require.False(t, resp.Complete)
+		require.Equal(t, statusSkipped, resp.Steps[0].Status)
+		require.Contains(t, resp.Steps[0].Detail, "no valid access token")
+		require.Equal(t, 0, remote.count(http.MethodDelete, authSecretPath))
+
+		usr, err := o.store.users.Get(sub)
+		require.NoError(t, err)
+		require.NotNil(t, usr.Deletion)
+	})
+
+	t.Run("fails the hub-auth steps without the hub-auth API token", func(t *testing.T) {
+		sub := uuid.New().String()
+		o, remote := newAccountTestOperation(t, sub)
+		o.serviceTokens = oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "service-token"})
+
+		resp := adminDeleteAccount(t, o, sub)
+		require.False(t, resp.Complete)
+		require.Equal(t, statusFailed, resp.Steps[0].Status)
+		require.Contains(t, resp.Steps[0].Detail, "hub-auth API token is not configured")
+		require.Equal(t, 0, remote.count(http.MethodDelete, "/user-vaults/uv1"))
+	})
+
+	t.Run("err badrequest if user is missing", func(t *testing.T) {
+		o, _ := newAccountTestOperation(t, uuid.New().String())
+
+		w := httptest.NewRecorder()
+		o.adminDeleteAccountHandler(w, newAdminDeleteAccountRequest(""))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("err notfound if user does not exist", func(t *testing.T) {
+		o, _ := newAccountTestOperation(t, uuid.New().String())
+
+		w := httptest.NewRecorder()
+		o.adminDeleteAccountHandler(w, newAdminDeleteAccountRequest(uuid.New().String()))
+		require.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("handlers", func(t *testing.T) {
+		o, _ := newAccountTestOperation(t, uuid.New().String())
+		require.NotEmpty(t, o.GetAdminRESTHandlers())
+	})
+}
+
+func newAccountTestOperation(t *testing.T, sub string) (*Operation, *mockOnboardingServer) {
+	t.Helper()
+
+	o, remote := newOnboardingTestOperation(t, sub)
+	o.keyServer.KeyEDVURL = "https://edv.example.com/encrypted-data-vaults"
+
+	require.NoError(t, o.store.users.Save(&user.User{Sub: sub, SecretShare: "c2hhcmU="}))
+	require.NoError(t, o.store.tokens.Save(&tokens.UserTokens{UserSub: sub, Access: uuid.New().String()}))
+
+	remote.bodies[http.MethodGet+" "+authBootstrapDataPath] = marshal(t, &userBootstrapData{Data: &BootstrapData{
+		UserEDVServer:    "https://edv.example.com/user-vaults",
+		UserEDVVaultURL:  "edv.example.com/user-vaults/uv1",
+		OpsEDVVaultURL:   "edv.example.com/encrypted-data-vaults/ov1",
+		OpsKeyStoreURL:   "https://kms.example.com/v1/keystores/ops",
+		AuthzKeyStoreURL: "https://authz.example.com/v1/keystores/authz",
+	}})
+
+	return o, remote
+}
+
+func deleteAccount(t *testing.T, o *Operation) *accountDeletionResp {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	o.deleteAccountHandler(w, newDeleteAccountRequest())
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	resp := &accountDeletionResp{}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(resp))
+
+	return resp
+}
+
+func adminDeleteAccount(t *testing.T, o *Operation, userID string) *accountDeletionResp {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	o.adminDeleteAccountHandler(w, newAdminDeleteAccountRequest(userID))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	resp := &accountDeletionResp{}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(resp))
+
+	return resp
+}
+
+func newDeleteAccountRequest() *http.Request {
+	return httptest.NewRequest(http.MethodDelete, accountPath, nil)
+}
+
+func newAdminDeleteAccountRequest(userID string) *http.Request {
+	return httptest.NewRequest(http.MethodDelete, accountPath+"?"+userQueryParam+"="+url.QueryEscape(userID), nil)
+}
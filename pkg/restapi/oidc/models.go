@@ -5,6 +5,8 @@ SPDX-License-Identifier: Apache-2.0
 
 package oidc
 
+import "github.com/trustbloc/wallet/pkg/restapi/common/store/cookie"
+
 type createKeyStoreReq struct {
 	Controller string      `json:"controller"`
 	EDV        *edvOptions `json:"edv"`
@@ -78,3 +80,42 @@ type userConfig struct {
 	AccessToken string `json:"accessToken,omitempty"`
 	SecretShare string `json:"walletSecretShare,omitempty"`
 }
+
+type providersResp struct {
+	Providers []*providerInfo `json:"providers"`
+}
+
+type providerInfo struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type sessionsResp struct {
+	Sessions []*cookie.SessionInfo `json:"sessions"`
+}
+
+type escrowRequest struct {
+	User          string   `json:"user"`
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+type recoverReq struct {
+	RecoveryCode string `json:"recoveryCode"`
+}
+
+type recoveryCodesResp struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+type accountDeletionResp struct {
+	UserID string `json:"userID"`
+	// Complete is false if some of the user's resources could not be deleted.
+	Complete bool                   `json:"complete"`
+	Steps    []*accountDeletionStep `json:"steps"`
+}
+
+type accountDeletionStep struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
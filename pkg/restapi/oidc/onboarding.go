@@ -0,0 +1,510 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+	"github.com/hyperledger/aries-framework-go/pkg/vdr/fingerprint"
+	ariesstorage "github.com/hyperledger/aries-framework-go/spi/storage"
+
+	"github.com/trustbloc/wallet/pkg/restapi/common"
+	"github.com/trustbloc/wallet/pkg/restapi/common/store/onboarding"
+	"github.com/trustbloc/wallet/pkg/restapi/common/store/user"
+)
+
+// Onboarding steps, in the order they run.
+const (
+	stepPostSecret    = "post-secret"
+	stepEscrow        = "escrow-recovery-codes"
+	stepAuthzKeyStore = "authz-keystore"
+	stepAuthzKey      = "authz-key"
+	stepKMSVault      = "kms-vault"
+	stepEDVController = "edv-controller"
+	stepEDVZCAPs      = "edv-zcaps"
+	stepOpsKeyStore   = "ops-keystore"
+	stepUserVault     = "user-vault"
+	stepEDVOpsKey     = "edv-ops-key"
+	stepEDVHMACKey    = "edv-hmac-key"
+	stepBootstrap     = "bootstrap"
+)
+
+// Types of the remote resources created while onboarding.
+const (
+	resourceKeyStore = "keystore"
+	resourceVault    = "edv-vault"
+)
+
+const (
+	defaultOnboardingTTL           = 24 * time.Hour
+	defaultOnboardingSweepInterval = time.Hour
+)
+
+type onboardingStep struct {
+	name string
+	run  func() error
+}
+
+// userOnboarding runs the onboarding steps of a user. The output of each step is kept in the state, which is
+// checkpointed after every step once it only holds the wallet secret share.
+type userOnboarding struct {
+	o           *Operation
+	state       *onboarding.State
+	h           *kmsHeader
+	accessToken string
+}
+
+// onboardUser creates the user's keystores, vaults and keys, and returns the user's wallet secret share. The recovery
+// codes that are not escrowed are held for the user.
+// An onboarding that failed is resumed from its last completed step. An onboarding that has not progressed for
+// longer than the onboarding TTL is abandoned: the resources it created are removed and it starts over.
+func (o *Operation) onboardUser(usr *user.User, accessToken string) (string, error) {
+	unlock := o.onboardingLocks.lock(usr.ID())
+	defer unlock()
+
+	state, err := o.onboardingState(usr.ID(), accessToken)
+	if err != nil {
+		return "", err
+	}
+
+	u := &userOnboarding{
+		o:     o,
+		state: state,
+		h: &kmsHeader{
+			userSub:     usr.Sub,
+			accessToken: accessToken,
+			secretShare: state.WalletSecretShare,
+		},
+		accessToken: accessToken,
+	}
+
+	for _, step := range u.steps() {
+		if state.Done(step.name) {
+			continue
+		}
+
+		err = step.run()
+		if err != nil {
+			return "", err
+		}
+
+		state.Complete(step.name)
+		state.UpdatedAt = time.Now()
+
+		if state.HoldsOtherShares() {
+			// an onboarding interrupted before the other shares are handed out starts over
+			continue
+		}
+
+		err = o.store.onboarding.Save(state)
+		if err != nil {
+			return "", fmt.Errorf("checkpoint onboarding step %s: %w", step.name, err)
+		}
+	}
+
+	return base64.StdEncoding.EncodeToString(state.WalletSecretShare), nil
+}
+
+// finishOnboarding discards the onboarding state once the user is saved.
+func (o *Operation) finishOnboarding(userID string) {
+	err := o.store.onboarding.Delete(userID)
+	if err != nil && !errors.Is(err, ariesstorage.ErrDataNotFound) {
+		logger.Warnf("failed to delete onboarding state of user %s: %s", userID, err.Error())
+	}
+}
+
+func (o *Operation) onboardingState(userID, accessToken string) (*onboarding.State, error) {
+	state, err := o.store.onboarding.Get(userID)
+	if err != nil && !errors.Is(err, ariesstorage.ErrDataNotFound) {
+		return nil, fmt.Errorf("fetch onboarding state: %w", err)
+	}
+
+	if err == nil {
+		if time.Since(state.UpdatedAt) < o.onboardingTTL {
+			logger.Infof("resuming onboarding of user %s after steps %v", userID, state.Completed)
+
+			return state, nil
+		}
+
+		o.cleanupOnboarding(state, accessToken)
+	}
+
+	b := make([]byte, 32)
+
+	_, err = rand.Read(b)
+	if err != nil {
+		return nil, fmt.Errorf("create user secret key : %w", err)
+	}
+
+	secrets, err := o.splitSecret(b)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	return &onboarding.State{
+		UserID:            userID,
+		StartedAt:         now,
+		UpdatedAt:         now,
+		WalletSecretShare: secrets.wallet,
+		AuthSecretShare:   secrets.auth,
+		RecoveryCodes:     secrets.recoveryCodes,
+	}, nil
+}
+
+// cleanupOnboarding removes the remote resources created by an abandoned onboarding. Resources that cannot be
+// removed are logged so that they can be reclaimed by an operator.
+func (o *Operation) cleanupOnboarding(state *onboarding.State, accessToken string) {
+	logger.Infof("cleaning up abandoned onboarding of user %s started at %s", state.UserID, state.StartedAt)
+
+	for _, r := range state.Resources {
+		req, err := http.NewRequestWithContext(context.TODO(), http.MethodDelete, r.URL, nil)
+		if err != nil {
+			logger.Warnf("failed to remove %s %s: %s", r.Type, r.URL, err.Error())
+
+			continue
+		}
+
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+		if r.Type == resourceKeyStore {
+			req.Header.Set("Secret-Share", base64.StdEncoding.EncodeToString(state.WalletSecretShare))
+		}
+
+		err = deleteResource(req, o.httpClient)
+		if err != nil {
+			logger.Warnf("failed to remove %s %s left by the abandoned onboarding of user %s: %s",
+				r.Type, r.URL, state.UserID, err.Error())
+		}
+	}
+
+	err := o.store.onboarding.Delete(state.UserID)
+	if err != nil {
+		logger.Warnf("failed to delete abandoned onboarding state of user %s: %s", state.UserID, err.Error())
+	}
+}
+
+// sweepOnboardings cleans up the onboardings that have not progressed for longer than the onboarding TTL. Users who
+// abandon their onboarding have no tokens yet, so their resources are removed with the service credentials of the
+// wallet server.
+func (o *Operation) sweepOnboardings() {
+	states, err := o.store.onboarding.List()
+	if err != nil {
+		logger.Errorf("onboarding sweep: failed to list onboardings: %s", err.Error())
+
+		return
+	}
+
+	var accessToken string
+
+	for _, state := range states {
+		if time.Since(state.UpdatedAt) < o.onboardingTTL {
+			continue
+		}
+
+		if accessToken == "" {
+			token, err := o.serviceTokens.Token()
+			if err != nil {
+				logger.Errorf("onboarding sweep: failed to fetch service token: %s", err.Error())
+
+				return
+			}
+
+			accessToken = token.AccessToken
+		}
+
+		o.cleanupAbandonedOnboarding(state.UserID, accessToken)
+	}
+}
+
+// cleanupAbandonedOnboarding cleans up the onboarding of a user unless it progressed since it was found abandoned.
+func (o *Operation) cleanupAbandonedOnboarding(userID, accessToken string) {
+	unlock := o.onboardingLocks.lock(userID)
+	defer unlock()
+
+	state, err := o.store.onboarding.Get(userID)
+	if err != nil {
+		if !errors.Is(err, ariesstorage.ErrDataNotFound) {
+			logger.Warnf("onboarding sweep: failed to fetch onboarding state of user %s: %s", userID, err.Error())
+		}
+
+		return
+	}
+
+	if time.Since(state.UpdatedAt) >= o.onboardingTTL {
+		o.cleanupOnboarding(state, accessToken)
+	}
+}
+
+// sweepOnboardingsEvery runs the onboarding sweep periodically until the operation is closed.
+func (o *Operation) sweepOnboardingsEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-o.done:
+			return
+		case <-ticker.C:
+			o.sweepOnboardings()
+		}
+	}
+}
+
+func deleteResource(req *http.Request, httpClient common.HTTPClient) error {
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("http request : %w", err)
+	}
+
+	err = resp.Body.Close()
+	if err != nil {
+		logger.Warnf("failed to close response body: %s", err.Error())
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		return nil
+	default:
+		return fmt.Errorf("http request: unexpected status code %d", resp.StatusCode)
+	}
+}
+
+func (u *userOnboarding) steps() []onboardingStep {
+	return []onboardingStep{
+		{name: stepPostSecret, run: u.postSecret},
+		{name: stepEscrow, run: u.escrowRecoveryCodes},
+		{name: stepAuthzKeyStore, run: u.createAuthzKeyStore},
+		{name: stepAuthzKey, run: u.createAuthzKey},
+		{name: stepKMSVault, run: u.createKMSVault},
+		{name: stepEDVController, run: u.createEDVController},
+		{name: stepEDVZCAPs, run: u.createEDVZCAPs},
+		{name: stepOpsKeyStore, run: u.createOpsKeyStore},
+		{name: stepUserVault, run: u.createUserVault},
+		{name: stepEDVOpsKey, run: u.createEDVOpsKey},
+		{name: stepEDVHMACKey, run: u.createEDVHMACKey},
+		{name: stepBootstrap, run: u.postBootstrapData},
+	}
+}
+
+func (u *userOnboarding) postSecret() error {
+	err := postSecret(u.o.hubAuthURL, u.accessToken, u.state.AuthSecretShare, u.o.httpClient)
+	if err != nil {
+		return fmt.Errorf("post secret share to auth server: %w", err)
+	}
+
+	u.state.AuthSecretShare = nil
+
+	return nil
+}
+
+func (u *userOnboarding) escrowRecoveryCodes() error {
+	escrowed, err := u.o.escrowRecoveryCodes(u.state.UserID, u.accessToken, u.state.RecoveryCodes)
+	if err != nil {
+		return err
+	}
+
+	if !escrowed && len(u.state.RecoveryCodes) > 0 {
+		err = u.o.holdRecoveryCodes(u.state.UserID, u.state.RecoveryCodes)
+		if err != nil {
+			return err
+		}
+	}
+
+	u.state.RecoveryCodes = nil
+
+	return nil
+}
+
+func (u *userOnboarding) createAuthzKeyStore() error {
+	keyStoreURL, err := createAuthzKeyStore(u.o.keyServer.AuthzKMSURL, u.h.userSub, u.h, u.o.httpClient)
+	if err != nil {
+		return fmt.Errorf("create authz keystore: %w", err)
+	}
+
+	u.state.AuthzKeyStoreURL = keyStoreURL
+	u.state.AddResource(resourceKeyStore, keyStoreURL)
+
+	return nil
+}
+
+func (u *userOnboarding) createAuthzKey() error {
+	keyID, pubKey, err := createKey(u.state.AuthzKeyStoreURL, kms.ED25519, u.h, u.o.httpClient)
+	if err != nil {
+		return fmt.Errorf("create authz key: %w", err)
+	}
+
+	_, controller := fingerprint.CreateDIDKey(pubKey)
+
+	u.state.AuthzKeyID = keyID
+	u.state.Controller = controller
+
+	return nil
+}
+
+// EDV vault for storing user's keys.
+func (u *userOnboarding) createKMSVault() error {
+	vaultURL, capability, err := createEDVDataVault(u.o.keyEDVClient, u.state.Controller, u.accessToken)
+	if err != nil {
+		return fmt.Errorf("create edv vault for kms: %w", err)
+	}
+
+	u.state.KMSVaultURL = vaultURL
+	u.state.KMSEDVCapability = capability
+	u.state.AddResource(resourceVault, vaultLocation(u.o.keyServer.KeyEDVURL, vaultURL))
+
+	return nil
+}
+
+// EDV controller on operational KMS.
+func (u *userOnboarding) createEDVController() error {
+	edvController, err := createEDVController(u.o.keyServer.OpsKMSURL, u.accessToken, u.o.httpClient)
+	if err != nil {
+		return fmt.Errorf("create edv controller: %w", err)
+	}
+
+	u.state.EDVController = edvController
+
+	return nil
+}
+
+// chain capabilities for KMS to use EDV storage.
+func (u *userOnboarding) createEDVZCAPs() error {
+	edvZCAPs, err := createChainCapability(u.state.Controller, getVaultID(u.state.KMSVaultURL),
+		u.state.KMSEDVCapability, u.state.EDVController, u.signer(), u.o.jsonLDLoader)
+	if err != nil {
+		return fmt.Errorf("create chain capability: %w", err)
+	}
+
+	u.state.EDVZCAPs = edvZCAPs
+
+	return nil
+}
+
+func (u *userOnboarding) createOpsKeyStore() error {
+	keyStoreURL, capability, err := createOpKeyStore(u.o.keyServer.OpsKMSURL, u.state.Controller,
+		u.state.KMSVaultURL, u.state.EDVZCAPs, u.accessToken, u.o.httpClient)
+	if err != nil {
+		return fmt.Errorf("create operational key store: %w", err)
+	}
+
+	u.state.OpsKeyStoreURL = keyStoreURL
+	u.state.OpsKeyStoreCapability = capability
+	u.state.AddResource(resourceKeyStore, keyStoreURL)
+
+	return nil
+}
+
+func (u *userOnboarding) createUserVault() error {
+	if u.o.userEDVClient == nil {
+		return nil
+	}
+
+	vaultURL, capability, err := createEDVDataVault(u.o.userEDVClient, u.state.Controller, u.accessToken)
+	if err != nil {
+		return fmt.Errorf("create user edv vault : %w", err)
+	}
+
+	u.state.UserEDVVaultURL = vaultURL
+	u.state.UserEDVCapability = capability
+	u.state.AddResource(resourceVault, vaultLocation(u.o.userEDVURL, vaultURL))
+
+	return nil
+}
+
+func (u *userOnboarding) createEDVOpsKey() error {
+	kid, err := createOpKey(
+		u.o.keyServer.OpsKMSURL,
+		getKeystoreID(u.state.OpsKeyStoreURL),
+		kms.NISTP256ECDHKW, // TODO make default key type configurable.
+		u.state.Controller,
+		u.opsKMSCapability(),
+		u.signer(),
+		u.h,
+		u.o.httpClient)
+	if err != nil {
+		return fmt.Errorf("create edv operational key: %w", err)
+	}
+
+	u.state.EDVOpsKID = kid
+
+	return nil
+}
+
+func (u *userOnboarding) createEDVHMACKey() error {
+	kid, err := createOpKey(
+		u.o.keyServer.OpsKMSURL,
+		getKeystoreID(u.state.OpsKeyStoreURL),
+		kms.HMACSHA256Tag256,
+		u.state.Controller,
+		u.opsKMSCapability(),
+		u.signer(),
+		u.h,
+		u.o.httpClient,
+	)
+	if err != nil {
+		return fmt.Errorf("create edv hmac key: %w", err)
+	}
+
+	u.state.EDVHMACKID = kid
+
+	return nil
+}
+
+func (u *userOnboarding) postBootstrapData() error {
+	// TODO remove OPSKMSCapability: https://github.com/trustbloc/wallet/issues/583.
+	data := &BootstrapData{
+		User:              uuid.NewString(),
+		UserEDVVaultURL:   u.state.UserEDVVaultURL, // TODO to be removed after universal wallet migration
+		OpsEDVVaultURL:    u.state.KMSVaultURL,     // TODO to be removed after universal wallet migration
+		AuthzKeyStoreURL:  u.state.AuthzKeyStoreURL,
+		OpsKeyStoreURL:    u.state.OpsKeyStoreURL,
+		EDVOpsKIDURL:      fmt.Sprintf("%s/keys/%s", u.state.OpsKeyStoreURL, u.state.EDVOpsKID),
+		EDVHMACKIDURL:     fmt.Sprintf("%s/keys/%s", u.state.OpsKeyStoreURL, u.state.EDVHMACKID),
+		UserEDVCapability: string(u.state.UserEDVCapability),
+		OPSKMSCapability:  u.opsKMSCapability(),
+		UserEDVVaultID:    getVaultID(u.state.UserEDVVaultURL),
+		UserEDVServer:     u.o.userEDVURL,
+		UserEDVEncKID:     u.state.EDVOpsKID,
+		UserEDVMACKID:     u.state.EDVHMACKID,
+		TokenExpiry:       walletTokenExpiryMins,
+	}
+
+	err := postUserBootstrapData(u.o.hubAuthURL, u.accessToken, data, u.o.httpClient)
+	if err != nil {
+		return fmt.Errorf("update user bootstrap data: %w", err)
+	}
+
+	return nil
+}
+
+func (u *userOnboarding) signer() signer {
+	return newKMSSigner(u.o.keyServer.AuthzKMSURL, getKeystoreID(u.state.AuthzKeyStoreURL), u.state.AuthzKeyID,
+		u.h, u.o.httpClient)
+}
+
+func (u *userOnboarding) opsKMSCapability() string {
+	return base64.URLEncoding.EncodeToString(u.state.OpsKeyStoreCapability)
+}
+
+// vaultLocation returns the URL of the vault on the EDV server at edvURL.
+func vaultLocation(edvURL, vaultURL string) string {
+	if edvURL == "" || vaultURL == "" {
+		return ""
+	}
+
+	return edvURL + "/" + getVaultID(vaultURL)
+}
@@ -0,0 +1,298 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc // nolint:testpackage // testing package-private types
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	mockstore "github.com/hyperledger/aries-framework-go/pkg/mock/storage"
+	ariesstorage "github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+
+	"github.com/trustbloc/wallet/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/wallet/pkg/restapi/common/store/onboarding"
+)
+
+func TestOperation_Onboarding(t *testing.T) {
+	t.Run("resumes an interrupted onboarding from the last completed step", func(t *testing.T) {
+		sub := uuid.New().String()
+		o, remote := newOnboardingTestOperation(t, sub)
+		remote.status[authBootstrapDataPath] = http.StatusInternalServerError
+
+		w := callback(o)
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "update user bootstrap data")
+
+		state, err := o.store.onboarding.Get(sub)
+		require.NoError(t, err)
+		require.True(t, state.Done(stepEDVHMACKey))
+		require.False(t, state.Done(stepBootstrap))
+		require.NotEmpty(t, state.WalletSecretShare)
+		require.Empty(t, state.AuthSecretShare)
+		require.Empty(t, state.RecoveryCodes)
+
+		_, err = o.store.users.Get(sub)
+		require.ErrorIs(t, err, ariesstorage.ErrDataNotFound)
+
+		delete(remote.status, authBootstrapDataPath)
+
+		w = callback(o)
+		require.Equal(t, http.StatusFound, w.Code)
+
+		// every step ran exactly once
+		require.Equal(t, 1, remote.count(http.MethodPost, authSecretPath))
+		require.Equal(t, 2, remote.count(http.MethodPost, createKeyStorePath))
+		require.Equal(t, 2, remote.count(http.MethodPost, authBootstrapDataPath))
+		require.Equal(t, 1, o.keyEDVClient.(*mockEDVClient).count)
+
+		usr, err := o.store.users.Get(sub)
+		require.NoError(t, err)
+		require.NotEmpty(t, usr.SecretShare)
+
+		_, err = o.store.onboarding.Get(sub)
+		require.ErrorIs(t, err, ariesstorage.ErrDataNotFound)
+	})
+
+	t.Run("restarts an abandoned onboarding after removing its resources", func(t *testing.T) {
+		sub := uuid.New().String()
+		o, remote := newOnboardingTestOperation(t, sub)
+		remote.status["/keystores/abandoned"] = http.StatusInternalServerError
+
+		abandoned := &onboarding.State{
+			UserID:            sub,
+			Completed:         []string{stepPostSecret, stepAuthzKeyStore, stepAuthzKey, stepKMSVault},
+			StartedAt:         time.Now().Add(-2 * defaultOnboardingTTL),
+			UpdatedAt:         time.Now().Add(-2 * defaultOnboardingTTL),
+			WalletSecretShare: []byte("old share"),
+			Resources: []*onboarding.Resource{
+				{Type: resourceKeyStore, URL: "https://kms.example.com/keystores/abandoned"},
+				{Type: resourceVault, URL: "https://edv.example.com/encrypted-data-vaults/abandoned"},
+			},
+		}
+		require.NoError(t, o.store.onboarding.Save(abandoned))
+
+		w := callback(o)
+		require.Equal(t, http.StatusFound, w.Code)
+
+		require.Equal(t, 1, remote.count(http.MethodDelete, "/keystores/abandoned"))
+		require.Equal(t, 1, remote.count(http.MethodDelete, "/encrypted-data-vaults/abandoned"))
+		require.Equal(t, 1, remote.count(http.MethodPost, authSecretPath))
+
+		usr, err := o.store.users.Get(sub)
+		require.NoError(t, err)
+		require.NotEqual(t, "b2xkIHNoYXJl", usr.SecretShare)
+	})
+
+	t.Run("sweeps onboardings abandoned by users who do not log in again", func(t *testing.T) {
+		o, remote := newOnboardingTestOperation(t, uuid.New().String())
+		o.serviceTokens = oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "service-token"})
+
+		abandoned := &onboarding.State{
+			UserID:    uuid.New().String(),
+			UpdatedAt: time.Now().Add(-2 * defaultOnboardingTTL),
+			Resources: []*onboarding.Resource{
+				{Type: resourceKeyStore, URL: "https://kms.example.com/keystores/abandoned"},
+			},
+		}
+		require.NoError(t, o.store.onboarding.Save(abandoned))
+
+		inProgress := &onboarding.State{UserID: uuid.New().String(), UpdatedAt: time.Now()}
+		require.NoError(t, o.store.onboarding.Save(inProgress))
+
+		o.startOnboardingSweep(time.Millisecond)
+		defer o.Close()
+
+		require.Eventually(t, func() bool {
+			return remote.count(http.MethodDelete, "/keystores/abandoned") == 1
+		}, time.Second, time.Millisecond)
+
+		o.Close()
+
+		require.Eventually(t, func() bool {
+			_, err := o.store.onboarding.Get(abandoned.UserID)
+
+			return errors.Is(err, ariesstorage.ErrDataNotFound)
+		}, time.Second, time.Millisecond)
+
+		_, err := o.store.onboarding.Get(inProgress.UserID)
+		require.NoError(t, err)
+
+		remote.mu.Lock()
+		defer remote.mu.Unlock()
+
+		require.Equal(t, "Bearer service-token",
+			remote.last[http.MethodDelete+" /keystores/abandoned"].Header.Get("Authorization"))
+	})
+
+	t.Run("other secret shares are never saved with the wallet share", func(t *testing.T) {
+		sub := uuid.New().String()
+		o, remote := newOnboardingTestOperation(t, sub)
+		o.secretShares.Shares = 3
+		o.secretShares.EscrowURL = "https://escrow.example.com/recovery"
+		remote.status["/recovery"] = http.StatusInternalServerError
+
+		w := callback(o)
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Equal(t, 1, remote.count(http.MethodPost, authSecretPath))
+
+		// the auth share was posted but the recovery codes are not handed out yet
+		_, err := o.store.onboarding.Get(sub)
+		require.ErrorIs(t, err, ariesstorage.ErrDataNotFound)
+	})
+
+	t.Run("error if onboarding state cannot be fetched", func(t *testing.T) {
+		o, _ := newOnboardingTestOperation(t, uuid.New().String())
+		o.store.onboarding = failingOnboardingStore(t, &mockstore.MockStore{ErrGet: errors.New("test")})
+
+		w := callback(o)
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "fetch onboarding state")
+	})
+
+	t.Run("error if a step cannot be checkpointed", func(t *testing.T) {
+		o, _ := newOnboardingTestOperation(t, uuid.New().String())
+		o.store.onboarding = failingOnboardingStore(t, &mockstore.MockStore{
+			ErrGet: ariesstorage.ErrDataNotFound,
+			ErrPut: errors.New("test"),
+		})
+
+		w := callback(o)
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "checkpoint onboarding step post-secret")
+	})
+}
+
+func TestDeleteResource(t *testing.T) {
+	t.Run("resources that no longer exist are removed", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		}))
+		defer srv.Close()
+
+		req, err := http.NewRequest(http.MethodDelete, srv.URL, nil) // nolint:noctx // test
+		require.NoError(t, err)
+		require.NoError(t, deleteResource(req, http.DefaultClient))
+	})
+
+	t.Run("error if the request fails", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodDelete, "https://example.com", nil) // nolint:noctx // test
+		require.NoError(t, err)
+
+		err = deleteResource(req, &mockHTTPClient{DoFunc: func(*http.Request) (*http.Response, error) {
+			return nil, errors.New("test")
+		}})
+		require.Error(t, err)
+	})
+}
+
+type mockOnboardingServer struct {
+	t        *testing.T
+	mu       sync.Mutex
+	requests map[string]int
+	status   map[string]int
+	// received are the bodies of the last requests, by method and path.
+	received map[string][]byte
+	// bodies are the responses to requests, by method and path.
+	bodies map[string][]byte
+	// last are the last requests, by method and path.
+	last map[string]*http.Request
+}
+
+func (m *mockOnboardingServer) Do(req *http.Request) (*http.Response, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.requests[req.Method+" "+req.URL.Path]++
+	m.last[req.Method+" "+req.URL.Path] = req
+
+	if req.Body != nil {
+		received, err := ioutil.ReadAll(req.Body)
+		require.NoError(m.t, err)
+
+		m.received[req.Method+" "+req.URL.Path] = received
+	}
+
+	status := http.StatusOK
+
+	if s, found := m.status[req.URL.Path]; found {
+		status = s
+	}
+
+	body := []byte("{}")
+
+	switch {
+	case m.bodies[req.Method+" "+req.URL.Path] != nil:
+		body = m.bodies[req.Method+" "+req.URL.Path]
+	case req.URL.Path == authSecretPath, req.URL.Path == authBootstrapDataPath:
+		body = []byte("")
+	case req.URL.Path == "/keys" && req.Method == http.MethodPost:
+		body = marshal(m.t, createKeyResp{PublicKey: pubEd25519Key(m.t)})
+	}
+
+	return &http.Response{StatusCode: status, Body: ioutil.NopCloser(bytes.NewReader(body))}, nil
+}
+
+func (m *mockOnboardingServer) count(method, path string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.requests[method+" "+path]
+}
+
+func newOnboardingTestOperation(t *testing.T, sub string) (*Operation, *mockOnboardingServer) {
+	t.Helper()
+
+	config := config(t)
+	config.OIDCClient = mockProviderClient(t, "", sub)
+	config.JSONLDLoader = createTestDocumentLoader(t)
+
+	o, err := New(config)
+	require.NoError(t, err)
+
+	remote := &mockOnboardingServer{
+		t:        t,
+		requests: map[string]int{},
+		status:   map[string]int{},
+		received: map[string][]byte{},
+		bodies:   map[string][]byte{},
+		last:     map[string]*http.Request{},
+	}
+
+	o.httpClient = remote
+	o.keyEDVClient = &mockEDVClient{}
+	o.userEDVClient = &mockEDVClient{}
+
+	return o, remote
+}
+
+func failingOnboardingStore(t *testing.T, s *mockstore.MockStore) *onboarding.Store {
+	t.Helper()
+
+	store, err := onboarding.NewStore(&mockstore.MockStoreProvider{Store: s})
+	require.NoError(t, err)
+
+	return store
+}
+
+func callback(o *Operation) *httptest.ResponseRecorder {
+	state := uuid.New().String()
+	o.store.cookies = &cookie.MockStore{Jar: loginSessionJar(state, defaultProviderID)}
+
+	w := httptest.NewRecorder()
+	o.oidcCallbackHandler(w, newOIDCCallbackRequest(uuid.New().String(), state))
+
+	return w
+}
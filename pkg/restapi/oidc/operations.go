@@ -9,24 +9,24 @@ package oidc
 import (
 	"bytes"
 	"context"
-	"crypto/rand"
 	"crypto/tls"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/hyperledger/aries-framework-go/pkg/doc/signature/jsonld"
 	"github.com/hyperledger/aries-framework-go/pkg/doc/signature/suite"
 	"github.com/hyperledger/aries-framework-go/pkg/doc/signature/suite/ed25519signature2018"
-	"github.com/hyperledger/aries-framework-go/pkg/kms"
-	"github.com/hyperledger/aries-framework-go/pkg/vdr/fingerprint"
 	ariesstorage "github.com/hyperledger/aries-framework-go/spi/storage"
 	"github.com/igor-pavlenko/httpsignatures-go"
-	"github.com/lafriks/go-shamir"
 	"github.com/piprate/json-gold/ld"
 	"github.com/trustbloc/edge-core/pkg/log"
 	"github.com/trustbloc/edge-core/pkg/zcapld"
@@ -38,23 +38,34 @@ import (
 	"github.com/trustbloc/wallet/pkg/restapi/common/oidc"
 	"github.com/trustbloc/wallet/pkg/restapi/common/store"
 	"github.com/trustbloc/wallet/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/wallet/pkg/restapi/common/store/encrypted"
+	"github.com/trustbloc/wallet/pkg/restapi/common/store/onboarding"
 	"github.com/trustbloc/wallet/pkg/restapi/common/store/tokens"
 	"github.com/trustbloc/wallet/pkg/restapi/common/store/user"
 )
 
 // Endpoints.
 const (
-	oidcLoginPath    = "/login"
-	oidcCallbackPath = "/callback"
-	oidcUserInfoPath = "/userinfo"
-	logoutPath       = "/logout"
+	oidcLoginPath         = "/login"
+	oidcCallbackPath      = "/callback"
+	oidcUserInfoPath      = "/userinfo"
+	oidcProvidersPath     = "/providers"
+	logoutPath            = "/logout"
+	backChannelLogoutPath = "/backchannel-logout"
+	accountPath           = "/account"
+	recoveryCodesPath     = "/recovery-codes"
+	recoverPath           = "/recover"
+	sessionsPath          = "/sessions"
 )
 
 // Stores.
 const (
-	transientStoreName = "edgeagent_oidc_trx"
-	stateCookieName    = "oauth2_state"
-	userSubCookieName  = "user_sub"
+	transientStoreName     = "edgeagent_oidc_trx"
+	stateCookieName        = "oauth2_state"
+	nonceCookieName        = "oidc_nonce"
+	pkceVerifierCookieName = "oauth2_pkce_verifier"
+	providerCookieName     = "oidc_provider"
+	userSubCookieName      = "user_sub"
 )
 
 // external url paths.
@@ -68,6 +79,8 @@ const (
 )
 
 const (
+	// defaultProviderID identifies the default OIDC provider in the provider registry.
+	defaultProviderID     = ""
 	edvResource           = "urn:edv:vault"
 	providerQueryParam    = "provider"
 	walletTokenExpiryMins = "20"
@@ -78,7 +91,10 @@ var logger = log.New("hub-auth/oidc")
 
 // Config holds all configuration for an Operation.
 type Config struct {
-	OIDCClient      oidc.Client
+	// OIDCClient is the client of the default OIDC provider.
+	OIDCClient oidc.Client
+	// Providers are additional OIDC providers users can log in with, by ID.
+	Providers       map[string]*Provider
 	Storage         *StorageConfig
 	WalletDashboard string
 	TLSConfig       *tls.Config
@@ -87,12 +103,37 @@ type Config struct {
 	HubAuthURL      string
 	JSONLDLoader    ld.DocumentLoader
 	Cookie          *cookie.Config
+	// PostLogoutRedirectURL is where the provider sends the user back to after logout.
+	PostLogoutRedirectURL string
+	// OnboardingTTL is how long an interrupted onboarding is resumed before it is considered abandoned.
+	// Defaults to 24 hours.
+	OnboardingTTL time.Duration
+	// OnboardingSweepInterval is how often the onboardings abandoned by users who do not log in again are cleaned up
+	// with the ServiceTokens. Defaults to 1 hour.
+	OnboardingSweepInterval time.Duration
+	SecretShares            *SecretSharesConfig
+	// ServerSessions keeps user sessions in Storage instead of cookies, so that users can list and revoke them.
+	ServerSessions bool
+	// ServiceTokens issue the access tokens of the wallet server itself. They authorize the removal of the remote
+	// resources of users who have no valid tokens, when operators delete their accounts.
+	ServiceTokens oauth2.TokenSource
+}
+
+// Provider is an OIDC provider users can log in with.
+type Provider struct {
+	Name   string
+	Client oidc.Client
 }
 
 // StorageConfig holds storage config.
 type StorageConfig struct {
 	Storage          ariesstorage.Provider
 	TransientStorage ariesstorage.Provider
+	// KeyEncryptionKeys protect user data in Storage. The first key encrypts new data.
+	KeyEncryptionKeys []*encrypted.Key
+	// Plaintext allows storing user data in plaintext when no key-encryption keys are set, for deployments
+	// upgrading from plaintext storage. Existing rows are encrypted as they are read once keys are set.
+	Plaintext bool
 }
 
 // KeyServerConfig holds configuration for key management server.
@@ -107,16 +148,19 @@ type edvClient interface {
 }
 
 type stores struct {
-	users     *user.Store
-	tokens    *tokens.Store
-	transient ariesstorage.Store
-	cookies   cookie.Store
+	users      *user.Store
+	tokens     *tokens.Store
+	onboarding *onboarding.Store
+	transient  ariesstorage.Store
+	cookies    cookie.Store
+	// sessions is nil unless sessions are kept on the server.
+	sessions cookie.SessionStore
 }
 
 // Operation implements OIDC operations.
 type Operation struct {
 	store           *stores
-	oidcClient      oidc.Client
+	providers       map[string]*Provider
 	walletDashboard string
 	tlsConfig       *tls.Config
 	httpClient      common.HTTPClient
@@ -126,12 +170,20 @@ type Operation struct {
 	hubAuthURL      string
 	jsonLDLoader    ld.DocumentLoader
 	userEDVURL      string
+	refreshLocks    *keyedMutex
+	postLogoutURL   string
+	onboardingLocks *keyedMutex
+	onboardingTTL   time.Duration
+	secretShares    *SecretSharesConfig
+	serviceTokens   oauth2.TokenSource
+	done            chan struct{}
+	closeOnce       sync.Once
 }
 
 // New returns a new Operation.
 func New(config *Config) (*Operation, error) {
 	op := &Operation{
-		oidcClient: config.OIDCClient,
+		providers: map[string]*Provider{defaultProviderID: {Client: config.OIDCClient}},
 		store: &stores{
 			cookies: cookie.NewStore(config.Cookie),
 		},
@@ -142,9 +194,43 @@ func New(config *Config) (*Operation, error) {
 			config.KeyServer.KeyEDVURL,
 			client.WithTLSConfig(config.TLSConfig),
 		),
-		keyServer:    config.KeyServer,
-		hubAuthURL:   config.HubAuthURL,
-		jsonLDLoader: config.JSONLDLoader,
+		keyServer:       config.KeyServer,
+		hubAuthURL:      config.HubAuthURL,
+		jsonLDLoader:    config.JSONLDLoader,
+		refreshLocks:    newKeyedMutex(),
+		postLogoutURL:   config.PostLogoutRedirectURL,
+		onboardingLocks: newKeyedMutex(),
+		onboardingTTL:   config.OnboardingTTL,
+		serviceTokens:   config.ServiceTokens,
+		done:            make(chan struct{}),
+	}
+
+	if op.onboardingTTL == 0 {
+		op.onboardingTTL = defaultOnboardingTTL
+	}
+
+	op.secretShares = &SecretSharesConfig{}
+
+	if config.SecretShares != nil {
+		shares := *config.SecretShares
+		op.secretShares = &shares
+	}
+
+	if op.secretShares.Shares == 0 {
+		op.secretShares.Shares = defaultSecretShares
+	}
+
+	if op.secretShares.Shares < secretSharesThreshold || op.secretShares.Shares > maxSecretShares {
+		return nil, fmt.Errorf("invalid number of secret shares %d: must be between %d and %d",
+			op.secretShares.Shares, secretSharesThreshold, maxSecretShares)
+	}
+
+	for id, p := range config.Providers {
+		if id == defaultProviderID {
+			return nil, errors.New("OIDC provider ID cannot be empty")
+		}
+
+		op.providers[id] = p
 	}
 
 	var err error
@@ -154,16 +240,42 @@ func New(config *Config) (*Operation, error) {
 		return nil, fmt.Errorf("failed to open transient store: %w", err)
 	}
 
-	op.store.users, err = user.NewStore(config.Storage.Storage)
+	secureStorage := config.Storage.Storage
+
+	if len(config.Storage.KeyEncryptionKeys) > 0 || !config.Storage.Plaintext {
+		secureStorage, err = encrypted.NewProvider(config.Storage.Storage, config.Storage.KeyEncryptionKeys...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to init encrypted storage: %w", err)
+		}
+	}
+
+	op.store.users, err = user.NewStore(secureStorage)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open users store: %w", err)
 	}
 
-	op.store.tokens, err = tokens.NewStore(config.Storage.Storage)
+	op.store.tokens, err = tokens.NewStore(secureStorage)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open tokens store: %w", err)
 	}
 
+	op.store.onboarding, err = onboarding.NewStore(secureStorage)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open onboarding store: %w", err)
+	}
+
+	if config.ServerSessions {
+		sessions, err := cookie.NewServerStore(config.Cookie, secureStorage, userSubCookieName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to init server-side sessions: %w", err)
+		}
+
+		op.store.cookies = sessions
+		op.store.sessions = sessions
+
+		go op.sweepSessionsEvery(sessionSweepInterval)
+	}
+
 	if config.UserEDVURL != "" {
 		op.userEDVClient = client.New(
 			config.UserEDVURL,
@@ -172,16 +284,46 @@ func New(config *Config) (*Operation, error) {
 		op.userEDVURL = config.UserEDVURL
 	}
 
+	op.startOnboardingSweep(config.OnboardingSweepInterval)
+
 	return op, nil
 }
 
+// Close stops the background cleanup of abandoned onboardings and expired sessions.
+func (o *Operation) Close() {
+	o.closeOnce.Do(func() {
+		close(o.done)
+	})
+}
+
+func (o *Operation) startOnboardingSweep(interval time.Duration) {
+	if o.serviceTokens == nil {
+		logger.Warnf("no service credentials: abandoned onboardings are only cleaned up when their users log in again")
+
+		return
+	}
+
+	if interval == 0 {
+		interval = defaultOnboardingSweepInterval
+	}
+
+	go o.sweepOnboardingsEvery(interval)
+}
+
 // GetRESTHandlers get all controller API handler available for this service.
 func (o *Operation) GetRESTHandlers() []common.Handler {
 	return []common.Handler{
 		common.NewHTTPHandler(oidcLoginPath, http.MethodGet, o.oidcLoginHandler),
 		common.NewHTTPHandler(oidcCallbackPath, http.MethodGet, o.oidcCallbackHandler),
 		common.NewHTTPHandler(oidcUserInfoPath, http.MethodGet, o.userProfileHandler),
-		common.NewHTTPHandler(logoutPath, http.MethodGet, o.userLogoutHandler),
+		common.NewHTTPHandler(oidcProvidersPath, http.MethodGet, o.providersHandler),
+		common.NewHTTPHandler(logoutPath, http.MethodPost, o.userLogoutHandler),
+		common.NewHTTPHandler(backChannelLogoutPath, http.MethodPost, o.backChannelLogoutHandler),
+		common.NewHTTPHandler(accountPath, http.MethodDelete, o.deleteAccountHandler),
+		common.NewHTTPHandler(recoveryCodesPath, http.MethodGet, o.recoveryCodesHandler),
+		common.NewHTTPHandler(recoverPath, http.MethodPost, o.recoverHandler),
+		common.NewHTTPHandler(sessionsPath, http.MethodGet, o.listSessionsHandler),
+		common.NewHTTPHandler(sessionsPath, http.MethodDelete, o.revokeSessionHandler),
 	}
 }
 
@@ -189,23 +331,52 @@ func (o *Operation) oidcLoginHandler(w http.ResponseWriter, r *http.Request) {
 	logger.Debugf("handling login request: %s", r.URL.String())
 
 	// It is not mandatory parameter as we have to support login and signup flow for now Issue-785.
+	// Providers that are not registered with the wallet are passed on to the default OIDC provider.
 	providerID := r.URL.Query().Get(providerQueryParam)
 
+	var authOptions []oauth2.AuthCodeOption
+
+	if _, registered := o.providers[providerID]; !registered {
+		authOptions = append(authOptions, oauth2.SetAuthURLParam(providerQueryParam, providerID))
+		providerID = defaultProviderID
+	}
+
 	session, err := o.store.cookies.Open(r)
 	if err != nil {
 		// log the error and continue
 		logger.Warnf("failed to read user session cookie: %s", err.Error())
-	} else {
-		_, found := session.Get(userSubCookieName)
-		if found {
+	} else if userID, found := session.Get(userSubCookieName); found {
+		if o.hasActiveSession(userID) {
 			http.Redirect(w, r, o.walletDashboard, http.StatusMovedPermanently)
 
 			return
 		}
+
+		// the session was ended by a back-channel logout
+		session.Delete(userSubCookieName)
+	}
+
+	nonce, err := oidc.NewNonce()
+	if err != nil {
+		common.WriteErrorResponsef(w, logger,
+			http.StatusInternalServerError, "failed to create nonce: %s", err.Error())
+
+		return
+	}
+
+	codeVerifier, err := oidc.NewCodeVerifier()
+	if err != nil {
+		common.WriteErrorResponsef(w, logger,
+			http.StatusInternalServerError, "failed to create PKCE code verifier: %s", err.Error())
+
+		return
 	}
 
 	state := uuid.New().String()
 	session.Set(stateCookieName, state)
+	session.Set(nonceCookieName, nonce)
+	session.Set(pkceVerifierCookieName, codeVerifier)
+	session.Set(providerCookieName, providerID)
 
 	err = session.Save(r, w)
 	if err != nil {
@@ -215,19 +386,16 @@ func (o *Operation) oidcLoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	authOption := oauth2.SetAuthURLParam(providerQueryParam, providerID)
-
-	redirectURL := o.oidcClient.FormatRequest(state, authOption)
+	redirectURL := o.providers[providerID].Client.FormatRequest(state, nonce, codeVerifier, authOptions...)
 
 	http.Redirect(w, r, redirectURL, http.StatusFound)
 	logger.Debugf("redirected to login url: %s", redirectURL)
 }
 
-// TODO encrypt data before storing: https://github.com/trustbloc/wallet/issues/380
 func (o *Operation) oidcCallbackHandler(w http.ResponseWriter, r *http.Request) { // nolint:funlen,gocyclo,lll // cannot reduce
 	logger.Debugf("handling oidc callback: %s", r.URL.String())
 
-	oauthToken, oidcToken, canProceed := o.fetchTokens(w, r)
+	providerID, oauthToken, oidcToken, canProceed := o.fetchTokens(w, r)
 	if !canProceed {
 		return
 	}
@@ -240,7 +408,7 @@ func (o *Operation) oidcCallbackHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	_, err = o.store.users.Get(usr.Sub)
+	found, err := o.findUser(providerID, usr)
 	if err != nil && !errors.Is(err, ariesstorage.ErrDataNotFound) {
 		common.WriteErrorResponsef(w, logger,
 			http.StatusInternalServerError, "failed to query user data: %s", err.Error())
@@ -248,8 +416,14 @@ func (o *Operation) oidcCallbackHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if found != nil && found.Deletion != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusForbidden, "the account of the user is being deleted")
+
+		return
+	}
+
 	if errors.Is(err, ariesstorage.ErrDataNotFound) {
-		walletSecretShare, onboardErr := o.onboardUser(usr.Sub, oauthToken.AccessToken)
+		walletSecretShare, onboardErr := o.onboardUser(usr, oauthToken.AccessToken)
 		if onboardErr != nil {
 			common.WriteErrorResponsef(w, logger,
 				http.StatusInternalServerError, "failed to onboard the user: %s", onboardErr.Error())
@@ -266,12 +440,20 @@ func (o *Operation) oidcCallbackHandler(w http.ResponseWriter, r *http.Request)
 
 			return
 		}
+
+		o.finishOnboarding(usr.ID())
 	}
 
+	rawIDToken, _ := oauthToken.Extra("id_token").(string) // nolint:errcheck // verified by fetchTokens
+
 	err = o.store.tokens.Save(&tokens.UserTokens{
-		UserSub: usr.Sub,
-		Access:  oauthToken.AccessToken,
-		Refresh: oauthToken.RefreshToken,
+		UserSub:   usr.ID(),
+		Provider:  providerID,
+		Access:    oauthToken.AccessToken,
+		Refresh:   oauthToken.RefreshToken,
+		Expiry:    oauthToken.Expiry,
+		IDToken:   rawIDToken,
+		SessionID: usr.SessionID,
 	})
 	if err != nil {
 		common.WriteErrorResponsef(w, logger,
@@ -288,7 +470,7 @@ func (o *Operation) oidcCallbackHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	session.Set(userSubCookieName, usr.Sub)
+	session.Set(userSubCookieName, usr.ID())
 
 	err = session.Save(r, w)
 	if err != nil {
@@ -302,43 +484,91 @@ func (o *Operation) oidcCallbackHandler(w http.ResponseWriter, r *http.Request)
 	logger.Debugf("redirected user to: %s", o.walletDashboard)
 }
 
-func (o *Operation) fetchTokens(
-	w http.ResponseWriter, r *http.Request) (oauthToken *oauth2.Token, oidcToken oidc.Claimer, valid bool) {
+// findUser returns the user's wallet data. Users of the default provider that were saved before users were keyed
+// by issuer are moved to their new key.
+func (o *Operation) findUser(providerID string, usr *user.User) (*user.User, error) {
+	found, err := o.store.users.Get(usr.ID())
+	if !errors.Is(err, ariesstorage.ErrDataNotFound) || providerID != defaultProviderID || usr.ID() == usr.Sub {
+		return found, err
+	}
+
+	found, err = o.store.users.Get(usr.Sub)
+	if err != nil {
+		return nil, err
+	}
+
+	found.Issuer = usr.Issuer
+
+	err = o.store.users.Save(found)
+	if err != nil {
+		return nil, fmt.Errorf("failed to migrate user data: %w", err)
+	}
+
+	err = o.store.users.Delete(usr.Sub)
+	if err != nil {
+		logger.Warnf("failed to delete migrated user data: %s", err.Error())
+	}
+
+	return found, nil
+}
+
+func (o *Operation) fetchTokens(w http.ResponseWriter, r *http.Request) (
+	providerID string, oauthToken *oauth2.Token, oidcToken oidc.Claimer, valid bool) {
 	session, valid := o.getAndVerifyUserSession(w, r)
 	if !valid {
 		return
 	}
 
+	nonce, codeVerifier, valid := getLoginSecrets(w, session)
+	if !valid {
+		return "", nil, nil, false
+	}
+
+	// sessions started before multiple providers were supported do not have a provider
+	providerCookie, _ := session.Get(providerCookieName)
+	providerID, _ = providerCookie.(string) // nolint:errcheck // default provider
+
+	provider, found := o.providers[providerID]
+	if !found {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "unknown OIDC provider: %s", providerID)
+
+		return "", nil, nil, false
+	}
+
 	session.Delete(stateCookieName)
+	session.Delete(nonceCookieName)
+	session.Delete(pkceVerifierCookieName)
+	session.Delete(providerCookieName)
 
 	code := r.URL.Query().Get("code")
 	if code == "" {
 		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing code parameter")
 
-		return nil, nil, false
+		return "", nil, nil, false
 	}
 
-	oauthToken, err := o.oidcClient.Exchange(
+	oauthToken, err := provider.Client.Exchange(
 		context.WithValue(
 			r.Context(),
 			oauth2.HTTPClient,
 			&http.Client{Transport: &http.Transport{TLSClientConfig: o.tlsConfig}},
 		),
 		code,
+		codeVerifier,
 	)
 	if err != nil {
 		common.WriteErrorResponsef(w, logger,
 			http.StatusBadGateway, "unable to exchange code for token: %s", err.Error())
 
-		return nil, nil, false
+		return "", nil, nil, false
 	}
 
-	oidcToken, err = o.oidcClient.VerifyIDToken(r.Context(), oauthToken)
+	oidcToken, err = provider.Client.VerifyIDToken(r.Context(), oauthToken, nonce)
 	if err != nil {
 		common.WriteErrorResponsef(w, logger,
 			http.StatusBadGateway, "cannot verify id_token: %s", err.Error())
 
-		return nil, nil, false
+		return "", nil, nil, false
 	}
 
 	err = session.Save(r, w)
@@ -346,10 +576,10 @@ func (o *Operation) fetchTokens(
 		common.WriteErrorResponsef(w, logger,
 			http.StatusInternalServerError, "failed to save session cookies: %s", err.Error())
 
-		return nil, nil, false
+		return "", nil, nil, false
 	}
 
-	return oauthToken, oidcToken, true
+	return providerID, oauthToken, oidcToken, true
 }
 
 func (o *Operation) getAndVerifyUserSession(w http.ResponseWriter, r *http.Request) (cookie.Jar, bool) {
@@ -384,6 +614,40 @@ func (o *Operation) getAndVerifyUserSession(w http.ResponseWriter, r *http.Reque
 	return session, true
 }
 
+// getLoginSecrets returns the nonce and PKCE code verifier saved in the session by the login handler.
+func getLoginSecrets(w http.ResponseWriter, session cookie.Jar) (nonce, codeVerifier string, valid bool) {
+	nonceCookie, found := session.Get(nonceCookieName)
+	if !found {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing nonce session cookie")
+
+		return "", "", false
+	}
+
+	verifierCookie, found := session.Get(pkceVerifierCookieName)
+	if !found {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing PKCE code verifier session cookie")
+
+		return "", "", false
+	}
+
+	nonce, ok := nonceCookie.(string)
+	if !ok {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "invalid nonce cookie format")
+
+		return "", "", false
+	}
+
+	codeVerifier, ok = verifierCookie.(string)
+	if !ok {
+		common.WriteErrorResponsef(w, logger,
+			http.StatusInternalServerError, "invalid PKCE code verifier cookie format")
+
+		return "", "", false
+	}
+
+	return nonce, codeVerifier, true
+}
+
 func (o *Operation) userProfileHandler(w http.ResponseWriter, r *http.Request) {
 	logger.Debugf("handling userprofile request")
 
@@ -420,20 +684,26 @@ func (o *Operation) userProfileHandler(w http.ResponseWriter, r *http.Request) {
 	logger.Debugf("finished handling userprofile request")
 }
 
-func (o *Operation) fetchUserData(w http.ResponseWriter, r *http.Request, sub string) (map[string]interface{}, bool) {
-	tokns, err := o.store.tokens.Get(sub)
+func (o *Operation) fetchUserData(
+	w http.ResponseWriter, r *http.Request, userID string) (map[string]interface{}, bool) {
+	tokns, client, err := o.validTokens(r.Context(), userID)
 	if err != nil {
-		common.WriteErrorResponsef(w, logger,
-			http.StatusInternalServerError, "failed to fetch user tokens from store: %s", err.Error())
+		status := http.StatusInternalServerError
+
+		switch {
+		case errors.Is(err, ariesstorage.ErrDataNotFound):
+			// the user logged out from another session or at the provider
+			status = http.StatusForbidden
+		case errors.As(err, new(*oauth2.RetrieveError)):
+			status = http.StatusBadGateway
+		}
+
+		common.WriteErrorResponsef(w, logger, status, "failed to fetch user tokens: %s", err.Error())
 
 		return nil, false
 	}
 
-	userInfo, err := o.oidcClient.UserInfo(r.Context(), &oauth2.Token{
-		AccessToken:  tokns.Access,
-		TokenType:    "Bearer",
-		RefreshToken: tokns.Refresh,
-	})
+	userInfo, err := client.UserInfo(r.Context(), tokns)
 	if err != nil {
 		common.WriteErrorResponsef(w, logger,
 			http.StatusBadGateway, "failed to fetch user info: %s", err.Error())
@@ -451,7 +721,7 @@ func (o *Operation) fetchUserData(w http.ResponseWriter, r *http.Request, sub st
 		return nil, false
 	}
 
-	walletUserData, err := o.store.users.Get(sub)
+	walletUserData, err := o.store.users.Get(userID)
 	if err != nil {
 		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError,
 			"failed to fetch bootstrap data: %s", err.Error())
@@ -459,7 +729,7 @@ func (o *Operation) fetchUserData(w http.ResponseWriter, r *http.Request, sub st
 		return nil, false
 	}
 
-	userBootStrapData, err := o.fetchBootstrapData(tokns.Access)
+	userBootStrapData, err := o.fetchBootstrapData(tokns.AccessToken)
 	if err != nil {
 		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError,
 			"failed to fetch bootstrap data: %s", err.Error())
@@ -469,7 +739,7 @@ func (o *Operation) fetchUserData(w http.ResponseWriter, r *http.Request, sub st
 
 	data["bootstrap"] = userBootStrapData.Data
 	data["userConfig"] = &userConfig{
-		AccessToken: tokns.Access,
+		AccessToken: tokns.AccessToken,
 		SecretShare: walletUserData.SecretShare,
 	}
 
@@ -494,9 +764,17 @@ func (o *Operation) fetchBootstrapData(accessToken string) (*userBootstrapData,
 	return bootstrapData, json.Unmarshal(data, bootstrapData)
 }
 
+// userLogoutHandler ends the session of the user. Session cookies are sent with cross-site requests, so logout is only
+// accepted from pages of the wallet: browsers send the Origin header with every POST.
 func (o *Operation) userLogoutHandler(w http.ResponseWriter, r *http.Request) {
 	logger.Debugf("handling logout request")
 
+	if !o.fromWallet(r) {
+		common.WriteErrorResponsef(w, logger, http.StatusForbidden, "logout must be requested from the wallet")
+
+		return
+	}
+
 	jar, err := o.store.cookies.Open(r)
 	if err != nil {
 		common.WriteErrorResponsef(w, logger,
@@ -505,159 +783,224 @@ func (o *Operation) userLogoutHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, found := jar.Get(userSubCookieName)
-	if !found {
-		logger.Infof("missing user cookie - this is a no-op")
+	client, idTokenHint := o.providers[defaultProviderID].Client, ""
 
-		return
-	}
+	userID, found := jar.Get(userSubCookieName)
+	if found {
+		client, idTokenHint = o.endUserSession(r.Context(), userID)
+
+		jar.Delete(userSubCookieName)
+
+		err = jar.Save(r, w)
+		if err != nil {
+			common.WriteErrorResponsef(w, logger, http.StatusInternalServerError,
+				"failed to delete user sub cookie: %s", err.Error())
 
-	jar.Delete(userSubCookieName)
+			return
+		}
+	} else {
+		logger.Infof("missing user cookie - ending the provider session only")
+	}
 
-	err = jar.Save(r, w)
+	redirectURL, err := client.EndSessionURL(idTokenHint, o.postLogoutURL)
 	if err != nil {
-		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError,
-			"failed to delete user sub cookie: %s", err.Error())
+		logger.Warnf("cannot end the user's session at the OIDC provider: %s", err.Error())
+
+		redirectURL = o.postLogoutURL
 	}
 
+	http.Redirect(w, r, redirectURL, http.StatusFound)
 	logger.Debugf("finished handling logout request")
 }
 
-func (o *Operation) onboardUser(sub, accessToken string) (string, error) { // nolint:funlen,gocyclo // not much logic
-	b := make([]byte, 32)
-
-	_, err := rand.Read(b)
-	if err != nil {
-		return "", fmt.Errorf("create user secret key : %w", err)
+// fromWallet returns true if the request was sent by a page with the origin of the wallet dashboard.
+func (o *Operation) fromWallet(r *http.Request) bool {
+	dashboard, err := url.Parse(o.walletDashboard)
+	if err != nil || dashboard.Host == "" {
+		return false
 	}
 
-	secrets, err := shamir.Split(b, 2, 2)
-	if err != nil {
-		return "", fmt.Errorf("split user secret: %w", err)
+	return r.Header.Get("Origin") == dashboard.Scheme+"://"+dashboard.Host
+}
+
+func (o *Operation) providersHandler(w http.ResponseWriter, _ *http.Request) {
+	resp := &providersResp{Providers: make([]*providerInfo, 0, len(o.providers)-1)}
+
+	for id, p := range o.providers {
+		if id == defaultProviderID {
+			continue
+		}
+
+		resp.Providers = append(resp.Providers, &providerInfo{ID: id, Name: p.Name})
 	}
 
-	walletSecretShare := secrets[0]
-	authSecretShare := secrets[1]
+	sort.Slice(resp.Providers, func(i, j int) bool {
+		return resp.Providers[i].ID < resp.Providers[j].ID
+	})
+
+	common.WriteResponse(w, logger, resp)
+}
+
+// endUserSession revokes and deletes the user's tokens, and returns the client of the provider the user logged in
+// with and the user's id_token, if any. Failures are logged: logout must not be blocked by the provider.
+func (o *Operation) endUserSession(ctx context.Context, userIDCookie interface{}) (oidc.Client, string) {
+	client := o.providers[defaultProviderID].Client
+
+	userID, ok := userIDCookie.(string)
+	if !ok {
+		logger.Warnf("invalid user sub cookie format")
+
+		return client, ""
+	}
 
-	err = postSecret(o.hubAuthURL, accessToken, authSecretShare, o.httpClient)
+	tokns, err := o.store.tokens.Get(userID)
 	if err != nil {
-		return "", fmt.Errorf("post secret share to auth server: %w", err)
+		logger.Warnf("failed to fetch user tokens to revoke: %s", err.Error())
+
+		return client, ""
+	}
+
+	if provider, found := o.providers[tokns.Provider]; found {
+		client = provider.Client
 	}
 
-	h := &kmsHeader{
-		userSub:     sub,
-		accessToken: accessToken,
-		secretShare: walletSecretShare,
+	// the refresh token goes first: revoking it usually also revokes the access tokens issued with it
+	for _, t := range []struct{ hint, token string }{
+		{hint: "refresh_token", token: tokns.Refresh},
+		{hint: "access_token", token: tokns.Access},
+	} {
+		if t.token == "" {
+			continue
+		}
+
+		err = client.RevokeToken(ctx, t.token, t.hint)
+		if err != nil {
+			logger.Warnf("failed to revoke %s: %s", t.hint, err.Error())
+		}
 	}
 
-	authzKeyStoreURL, err := createAuthzKeyStore(o.keyServer.AuthzKMSURL, sub, h, o.httpClient)
+	err = o.store.tokens.Delete(userID)
 	if err != nil {
-		return "", fmt.Errorf("create authz keystore: %w", err)
+		logger.Errorf("failed to delete user tokens: %s", err.Error())
 	}
 
-	authzKeyStoreID := getKeystoreID(authzKeyStoreURL)
+	return client, tokns.IDToken
+}
 
-	keyID, pubKey, err := createKey(authzKeyStoreURL, kms.ED25519, h, o.httpClient)
-	if err != nil {
-		return "", fmt.Errorf("create authz key: %w", err)
+// backChannelLogoutHandler ends every session of a user when notified by the provider
+// (OIDC Back-Channel Logout 1.0). Providers other than the default one are identified by the provider query param
+// in the back-channel logout URI registered with them.
+func (o *Operation) backChannelLogoutHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("handling back-channel logout request")
+
+	w.Header().Set("Cache-Control", "no-store")
+
+	providerID := r.URL.Query().Get(providerQueryParam)
+
+	provider, found := o.providers[providerID]
+	if !found {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "unknown OIDC provider: %s", providerID)
+
+		return
 	}
 
-	_, controller := fingerprint.CreateDIDKey(pubKey)
+	rawToken := r.PostFormValue("logout_token")
+	if rawToken == "" {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing logout_token")
 
-	// EDV vault for storing user's keys
-	kmsVaultURL, kmsEDVCapability, err := createEDVDataVault(o.keyEDVClient, controller, accessToken)
-	if err != nil {
-		return "", fmt.Errorf("create edv vault for kms: %w", err)
+		return
 	}
 
-	// create EDV controller on operational KMS
-	edvController, err := createEDVController(o.keyServer.OpsKMSURL, accessToken, o.httpClient)
+	logoutToken, err := provider.Client.VerifyLogoutToken(r.Context(), rawToken)
 	if err != nil {
-		return "", fmt.Errorf("create edv controller: %w", err)
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "invalid logout_token: %s", err.Error())
+
+		return
 	}
 
-	// create chain capabilities for KMS to use EDV storage
-	edvZCAPs, err := createChainCapability(controller, getVaultID(kmsVaultURL), kmsEDVCapability, edvController,
-		newKMSSigner(o.keyServer.AuthzKMSURL, authzKeyStoreID, keyID, h, o.httpClient), o.jsonLDLoader)
+	userIDs, err := o.logoutUsers(providerID, logoutToken)
 	if err != nil {
-		return "", fmt.Errorf("create chain capability: %w", err)
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError,
+			"failed to end user sessions: %s", err.Error())
+
+		return
 	}
 
-	opKeyStoreURL, opKeyStoreCapability, err := createOpKeyStore(o.keyServer.OpsKMSURL, controller, kmsVaultURL,
-		edvZCAPs, accessToken, o.httpClient)
+	// sessions are only valid while the user's tokens exist
+	err = o.deleteTokens(userIDs...)
 	if err != nil {
-		return "", fmt.Errorf("create operational key store: %w", err)
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError,
+			"failed to end user sessions: %s", err.Error())
+
+		return
 	}
 
-	compressedOPSKMSCapability := base64.URLEncoding.EncodeToString(opKeyStoreCapability)
+	w.WriteHeader(http.StatusOK)
+	logger.Debugf("finished handling back-channel logout request")
+}
+
+// logoutUsers returns the IDs of the users a logout token ends the sessions of: the user it identifies by sub, or
+// else the users logged in during the session it identifies by sid.
+func (o *Operation) logoutUsers(providerID string, logoutToken *oidc.LogoutToken) ([]string, error) {
+	if logoutToken.Subject == "" {
+		return o.store.tokens.UsersOfSession(providerID, logoutToken.SessionID)
+	}
 
-	var (
-		userEDVVaultURL   string
-		userEDVCapability []byte
-	)
+	userIDs := []string{user.ID(logoutToken.Issuer, logoutToken.Subject)}
 
-	if o.userEDVClient != nil {
-		userEDVVaultURL, userEDVCapability, err = createEDVDataVault(o.userEDVClient, controller, accessToken)
+	if providerID == defaultProviderID {
+		// tokens saved before users were keyed by issuer
+		userIDs = append(userIDs, logoutToken.Subject)
+	}
+
+	return userIDs, nil
+}
+
+func (o *Operation) deleteTokens(userIDs ...string) error {
+	for _, id := range userIDs {
+		err := o.store.tokens.Delete(id)
 		if err != nil {
-			return "", fmt.Errorf("create user edv vault : %w", err)
+			return err
 		}
 	}
 
-	edvOpsKID, err := createOpKey(
-		o.keyServer.OpsKMSURL,
-		getKeystoreID(opKeyStoreURL),
-		kms.NISTP256ECDHKW, // TODO make default key type configurable.
-		controller,
-		compressedOPSKMSCapability,
-		newKMSSigner(o.keyServer.AuthzKMSURL, authzKeyStoreID, keyID, h, o.httpClient),
-		h,
-		o.httpClient)
-	if err != nil {
-		return "", fmt.Errorf("create edv operational key: %w", err)
-	}
+	return nil
+}
 
-	edvOpsKIDURL := fmt.Sprintf("%s/keys/%s", opKeyStoreURL, edvOpsKID)
+// SessionUser returns the ID of the user logged in with the session of a request. Session cookies are sent with
+// cross-site requests, so requests changing state are only accepted from pages of the wallet.
+func (o *Operation) SessionUser(r *http.Request) (string, bool) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead && !o.fromWallet(r) {
+		return "", false
+	}
 
-	hmacEDVKID, err := createOpKey(
-		o.keyServer.OpsKMSURL,
-		getKeystoreID(opKeyStoreURL),
-		kms.HMACSHA256Tag256,
-		controller,
-		compressedOPSKMSCapability,
-		newKMSSigner(o.keyServer.AuthzKMSURL, authzKeyStoreID, keyID, h, o.httpClient),
-		h,
-		o.httpClient,
-	)
+	jar, err := o.store.cookies.Open(r)
 	if err != nil {
-		return "", fmt.Errorf("create edv hmac key: %w", err)
+		return "", false
+	}
+
+	userIDCookie, found := jar.Get(userSubCookieName)
+	if !found || !o.hasActiveSession(userIDCookie) {
+		return "", false
 	}
 
-	hmacEDVKIDURL := fmt.Sprintf("%s/keys/%s", opKeyStoreURL, hmacEDVKID)
+	userID, ok := userIDCookie.(string)
+
+	return userID, ok
+}
 
-	// TODO remove OPSKMSCapability: https://github.com/trustbloc/wallet/issues/583.
-	data := &BootstrapData{
-		User:              uuid.NewString(),
-		UserEDVVaultURL:   userEDVVaultURL, // TODO to be removed after universal wallet migration
-		OpsEDVVaultURL:    kmsVaultURL,     // TODO to be removed after universal wallet migration
-		AuthzKeyStoreURL:  authzKeyStoreURL,
-		OpsKeyStoreURL:    opKeyStoreURL,
-		EDVOpsKIDURL:      edvOpsKIDURL,
-		EDVHMACKIDURL:     hmacEDVKIDURL,
-		UserEDVCapability: string(userEDVCapability),
-		OPSKMSCapability:  compressedOPSKMSCapability,
-		UserEDVVaultID:    getVaultID(userEDVVaultURL),
-		UserEDVServer:     o.userEDVURL,
-		UserEDVEncKID:     edvOpsKID,
-		UserEDVMACKID:     hmacEDVKID,
-		TokenExpiry:       walletTokenExpiryMins,
+func (o *Operation) hasActiveSession(userIDCookie interface{}) bool {
+	userID, ok := userIDCookie.(string)
+	if !ok {
+		return false
 	}
 
-	err = postUserBootstrapData(o.hubAuthURL, accessToken, data, o.httpClient)
-	if err != nil {
-		return "", fmt.Errorf("update user bootstrap data: %w", err)
+	_, err := o.store.tokens.Get(userID)
+	if err != nil && !errors.Is(err, ariesstorage.ErrDataNotFound) {
+		logger.Warnf("failed to check user session: %s", err.Error())
 	}
 
-	return base64.StdEncoding.EncodeToString(walletSecretShare), nil
+	return err == nil
 }
 
 func postSecret(baseURL, accessToken string, secret []byte, httpClient common.HTTPClient) error {
@@ -16,7 +16,10 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	ariesmem "github.com/hyperledger/aries-framework-go/component/storageutil/mem"
@@ -28,6 +31,7 @@ import (
 	mockldstore "github.com/hyperledger/aries-framework-go/pkg/mock/ld"
 	mockstore "github.com/hyperledger/aries-framework-go/pkg/mock/storage"
 	ldstore "github.com/hyperledger/aries-framework-go/pkg/store/ld"
+	ariesstorage "github.com/hyperledger/aries-framework-go/spi/storage"
 	"github.com/stretchr/testify/require"
 	"github.com/trustbloc/edge-core/pkg/zcapld"
 	"github.com/trustbloc/edv/pkg/client"
@@ -36,6 +40,8 @@ import (
 
 	oidc2 "github.com/trustbloc/wallet/pkg/restapi/common/oidc"
 	"github.com/trustbloc/wallet/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/wallet/pkg/restapi/common/store/encrypted"
+	"github.com/trustbloc/wallet/pkg/restapi/common/store/onboarding"
 	"github.com/trustbloc/wallet/pkg/restapi/common/store/tokens"
 	"github.com/trustbloc/wallet/pkg/restapi/common/store/user"
 )
@@ -86,6 +92,71 @@ func TestNew(t *testing.T) {
 		_, err := New(config)
 		require.Error(t, err)
 	})
+
+	t.Run("error if cannot open onboarding store", func(t *testing.T) {
+		config := config(t)
+		config.Storage.Storage = &mockstore.MockStoreProvider{
+			FailNamespace: onboarding.StoreName,
+		}
+		_, err := New(config)
+		require.Error(t, err)
+	})
+
+	t.Run("error if cannot open sessions store", func(t *testing.T) {
+		config := config(t)
+		config.ServerSessions = true
+		config.Storage.Storage = &mockstore.MockStoreProvider{
+			FailNamespace: cookie.SessionsStoreName,
+		}
+		_, err := New(config)
+		require.Error(t, err)
+	})
+
+	t.Run("error if the secret is split into less than two shares", func(t *testing.T) {
+		config := config(t)
+		config.SecretShares = &SecretSharesConfig{Shares: 1}
+		_, err := New(config)
+		require.EqualError(t, err, "invalid number of secret shares 1: must be between 2 and 3")
+	})
+
+	t.Run("error if the secret is split into more than one recovery share", func(t *testing.T) {
+		config := config(t)
+		config.SecretShares = &SecretSharesConfig{Shares: 4}
+		_, err := New(config)
+		require.EqualError(t, err, "invalid number of secret shares 4: must be between 2 and 3")
+	})
+
+	t.Run("error if a provider has an empty ID", func(t *testing.T) {
+		config := config(t)
+		config.Providers = map[string]*Provider{"": {Client: &oidc2.MockClient{}}}
+		_, err := New(config)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "provider ID cannot be empty")
+	})
+
+	t.Run("error if key-encryption keys are missing", func(t *testing.T) {
+		config := config(t)
+		config.Storage.KeyEncryptionKeys = nil
+		_, err := New(config)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to init encrypted storage")
+	})
+
+	t.Run("stores user data in plaintext without key-encryption keys if allowed", func(t *testing.T) {
+		config := config(t)
+		config.Storage.KeyEncryptionKeys = nil
+		config.Storage.Plaintext = true
+		_, err := New(config)
+		require.NoError(t, err)
+	})
+
+	t.Run("error if key-encryption keys are invalid", func(t *testing.T) {
+		config := config(t)
+		config.Storage.KeyEncryptionKeys = []*encrypted.Key{{ID: "v1", Secret: []byte("short")}}
+		_, err := New(config)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to init encrypted storage")
+	})
 }
 
 func TestOperation_GetRESTHandlers(t *testing.T) {
@@ -105,6 +176,22 @@ func TestOperation_OIDCLoginHandler(t *testing.T) {
 		require.NotEmpty(t, w.Header().Get("Location"))
 	})
 
+	t.Run("saves the state, nonce and PKCE code verifier in the session", func(t *testing.T) {
+		o, err := New(config(t))
+		require.NoError(t, err)
+		jar := &cookie.MockJar{}
+		o.store.cookies = &cookie.MockStore{Jar: jar}
+		w := httptest.NewRecorder()
+		o.oidcLoginHandler(w, newOIDCLoginRequest())
+		require.Equal(t, http.StatusFound, w.Code)
+
+		for _, name := range []string{stateCookieName, nonceCookieName, pkceVerifierCookieName} {
+			value, found := jar.Get(name)
+			require.True(t, found)
+			require.NotEmpty(t, value)
+		}
+	})
+
 	t.Run("internal server error if cannot save to cookie store", func(t *testing.T) {
 		config := config(t)
 		o, err := New(config)
@@ -120,19 +207,232 @@ func TestOperation_OIDCLoginHandler(t *testing.T) {
 	})
 
 	t.Run("user already logged in", func(t *testing.T) {
+		sub := uuid.New().String()
 		o, err := New(config(t))
 		require.NoError(t, err)
+		require.NoError(t, o.store.tokens.Save(&tokens.UserTokens{UserSub: sub, Access: uuid.New().String()}))
 		result := httptest.NewRecorder()
 		o.store.cookies = &cookie.MockStore{
 			Jar: &cookie.MockJar{
 				Cookies: map[interface{}]interface{}{
-					userSubCookieName: uuid.New().String(),
+					userSubCookieName: sub,
 				},
 			},
 		}
 		o.oidcLoginHandler(result, newOIDCLoginRequest())
 		require.Equal(t, http.StatusMovedPermanently, result.Code)
 	})
+
+	t.Run("logs in again if the user's session was ended", func(t *testing.T) {
+		o, err := New(config(t))
+		require.NoError(t, err)
+		jar := &cookie.MockJar{
+			Cookies: map[interface{}]interface{}{
+				userSubCookieName: uuid.New().String(),
+			},
+		}
+		o.store.cookies = &cookie.MockStore{Jar: jar}
+		result := httptest.NewRecorder()
+		o.oidcLoginHandler(result, newOIDCLoginRequest())
+		require.Equal(t, http.StatusFound, result.Code)
+		_, found := jar.Get(userSubCookieName)
+		require.False(t, found)
+	})
+}
+
+func TestOperation_MultipleProviders(t *testing.T) {
+	const (
+		defaultIssuer = "https://default.example.com"
+		otherIssuer   = "https://other.example.com"
+	)
+
+	t.Run("login redirects to the requested provider", func(t *testing.T) {
+		config := config(t)
+		config.OIDCClient = &oidc2.MockClient{AuthRequest: "http://default.example.com/auth"}
+		config.Providers = map[string]*Provider{
+			"other": {Name: "Other", Client: &oidc2.MockClient{AuthRequest: "http://other.example.com/auth"}},
+		}
+		o, err := New(config)
+		require.NoError(t, err)
+
+		jar := &cookie.MockJar{}
+		o.store.cookies = &cookie.MockStore{Jar: jar}
+		w := httptest.NewRecorder()
+		o.oidcLoginHandler(w, httptest.NewRequest(http.MethodGet, "/oidc/login?provider=other", nil))
+		require.Equal(t, http.StatusFound, w.Code)
+		require.Equal(t, "http://other.example.com/auth", w.Header().Get("Location"))
+		providerID, found := jar.Get(providerCookieName)
+		require.True(t, found)
+		require.Equal(t, "other", providerID)
+
+		// providers unknown to the wallet are left to the default provider
+		jar = &cookie.MockJar{}
+		o.store.cookies = &cookie.MockStore{Jar: jar}
+		w = httptest.NewRecorder()
+		o.oidcLoginHandler(w, httptest.NewRequest(http.MethodGet, "/oidc/login?provider=upstream", nil))
+		require.Equal(t, http.StatusFound, w.Code)
+		require.Equal(t, "http://default.example.com/auth", w.Header().Get("Location"))
+		providerID, found = jar.Get(providerCookieName)
+		require.True(t, found)
+		require.Equal(t, defaultProviderID, providerID)
+	})
+
+	t.Run("users of different providers with the same sub are distinct", func(t *testing.T) {
+		sub := uuid.New().String()
+		config := config(t)
+		config.OIDCClient = mockProviderClient(t, defaultIssuer, sub)
+		config.Providers = map[string]*Provider{
+			"other": {Name: "Other", Client: mockProviderClient(t, otherIssuer, sub)},
+		}
+		o, err := New(config)
+		require.NoError(t, err)
+
+		defaultUser := &user.User{Issuer: defaultIssuer, Sub: sub, SecretShare: uuid.New().String()}
+		otherUser := &user.User{Issuer: otherIssuer, Sub: sub, SecretShare: uuid.New().String()}
+		require.NoError(t, o.store.users.Save(defaultUser))
+		require.NoError(t, o.store.users.Save(otherUser))
+
+		for providerID, expected := range map[string]*user.User{defaultProviderID: defaultUser, "other": otherUser} {
+			state := uuid.New().String()
+			jar := loginSessionJar(state, providerID)
+			o.store.cookies = &cookie.MockStore{Jar: jar}
+
+			w := httptest.NewRecorder()
+			o.oidcCallbackHandler(w, newOIDCCallbackRequest(uuid.New().String(), state))
+			require.Equal(t, http.StatusFound, w.Code)
+
+			userID, found := jar.Get(userSubCookieName)
+			require.True(t, found)
+			require.Equal(t, expected.ID(), userID)
+
+			saved, err := o.store.tokens.Get(expected.ID())
+			require.NoError(t, err)
+			require.Equal(t, providerID, saved.Provider)
+
+			savedUser, err := o.store.users.Get(expected.ID())
+			require.NoError(t, err)
+			require.Equal(t, expected.SecretShare, savedUser.SecretShare)
+		}
+
+		require.NotEqual(t, defaultUser.ID(), otherUser.ID())
+	})
+
+	t.Run("migrates users of the default provider keyed by sub", func(t *testing.T) {
+		sub := uuid.New().String()
+		config := config(t)
+		config.OIDCClient = mockProviderClient(t, defaultIssuer, sub)
+		o, err := New(config)
+		require.NoError(t, err)
+
+		legacy := &user.User{Sub: sub, SecretShare: uuid.New().String()}
+		require.NoError(t, o.store.users.Save(legacy))
+
+		state := uuid.New().String()
+		o.store.cookies = &cookie.MockStore{Jar: loginSessionJar(state, defaultProviderID)}
+		w := httptest.NewRecorder()
+		o.oidcCallbackHandler(w, newOIDCCallbackRequest(uuid.New().String(), state))
+		require.Equal(t, http.StatusFound, w.Code)
+
+		migrated, err := o.store.users.Get(user.ID(defaultIssuer, sub))
+		require.NoError(t, err)
+		require.Equal(t, legacy.SecretShare, migrated.SecretShare)
+		require.Equal(t, defaultIssuer, migrated.Issuer)
+
+		_, err = o.store.users.Get(sub)
+		require.ErrorIs(t, err, ariesstorage.ErrDataNotFound)
+	})
+
+	t.Run("error bad request if the session's provider is unknown", func(t *testing.T) {
+		o, err := New(config(t))
+		require.NoError(t, err)
+
+		state := uuid.New().String()
+		o.store.cookies = &cookie.MockStore{Jar: loginSessionJar(state, "unknown")}
+		w := httptest.NewRecorder()
+		o.oidcCallbackHandler(w, newOIDCCallbackRequest(uuid.New().String(), state))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Contains(t, w.Body.String(), "unknown OIDC provider")
+	})
+
+	t.Run("user info is fetched from the user's provider", func(t *testing.T) {
+		userID := user.ID(otherIssuer, uuid.New().String())
+		config := config(t)
+		config.OIDCClient = &oidc2.MockClient{UserInfoErr: errors.New("wrong provider")}
+		config.Providers = map[string]*Provider{
+			"other": {Client: &oidc2.MockClient{UserInfoErr: errors.New("other provider")}},
+		}
+		o, err := New(config)
+		require.NoError(t, err)
+		require.NoError(t, o.store.tokens.Save(&tokens.UserTokens{
+			UserSub:  userID,
+			Provider: "other",
+			Access:   uuid.New().String(),
+		}))
+		o.store.cookies = &cookie.MockStore{
+			Jar: &cookie.MockJar{
+				Cookies: map[interface{}]interface{}{
+					userSubCookieName: userID,
+				},
+			},
+		}
+
+		w := httptest.NewRecorder()
+		o.userProfileHandler(w, newUserProfileRequest())
+		require.Equal(t, http.StatusBadGateway, w.Code)
+		require.Contains(t, w.Body.String(), "other provider")
+	})
+
+	t.Run("back-channel logout of another provider", func(t *testing.T) {
+		sub := uuid.New().String()
+		config := config(t)
+		config.Providers = map[string]*Provider{
+			"other": {Client: &oidc2.MockClient{
+				LogoutToken: &oidc2.LogoutToken{Issuer: otherIssuer, Subject: sub},
+			}},
+		}
+		o, err := New(config)
+		require.NoError(t, err)
+		require.NoError(t, o.store.tokens.Save(&tokens.UserTokens{
+			UserSub:  user.ID(otherIssuer, sub),
+			Provider: "other",
+			Access:   uuid.New().String(),
+		}))
+
+		r := newBackChannelLogoutRequest("token")
+		r.URL.RawQuery = "provider=other"
+		w := httptest.NewRecorder()
+		o.backChannelLogoutHandler(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		_, err = o.store.tokens.Get(user.ID(otherIssuer, sub))
+		require.ErrorIs(t, err, ariesstorage.ErrDataNotFound)
+
+		r.URL.RawQuery = "provider=unknown"
+		w = httptest.NewRecorder()
+		o.backChannelLogoutHandler(w, r)
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("lists providers", func(t *testing.T) {
+		config := config(t)
+		config.Providers = map[string]*Provider{
+			"b": {Name: "Provider B", Client: &oidc2.MockClient{}},
+			"a": {Name: "Provider A", Client: &oidc2.MockClient{}},
+		}
+		o, err := New(config)
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		o.providersHandler(w, httptest.NewRequest(http.MethodGet, "/oidc/providers", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		resp := &providersResp{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(resp))
+		require.Equal(t, []*providerInfo{
+			{ID: "a", Name: "Provider A"},
+			{ID: "b", Name: "Provider B"},
+		}, resp.Providers)
+	})
 }
 
 func TestKmsSigner_Sign(t *testing.T) {
@@ -184,12 +484,17 @@ func TestOperation_OIDCCallbackHandler(t *testing.T) { //nolint: gocritic,gocogn
 	t.Run("fetches OIDC tokens and redirects to the UI", func(t *testing.T) {
 		code := uuid.New().String()
 		state := uuid.New().String()
+		sub := uuid.New().String()
+		sessionID := uuid.New().String()
+		accessToken := uuid.New().String()
+		storage := ariesmem.NewProvider()
 
 		config := config(t)
 		config.WalletDashboard = uiEndpoint
+		config.Storage.Storage = storage
 		config.OIDCClient = &oidc2.MockClient{
 			OAuthToken: &oauth2.Token{
-				AccessToken:  uuid.New().String(),
+				AccessToken:  accessToken,
 				RefreshToken: uuid.New().String(),
 				TokenType:    "Bearer",
 			},
@@ -197,7 +502,8 @@ func TestOperation_OIDCCallbackHandler(t *testing.T) { //nolint: gocritic,gocogn
 				ClaimsFunc: func(i interface{}) error {
 					user, ok := i.(*user.User)
 					require.True(t, ok)
-					user.Sub = uuid.New().String()
+					user.Sub = sub
+					user.SessionID = sessionID
 
 					return nil
 				},
@@ -239,7 +545,9 @@ func TestOperation_OIDCCallbackHandler(t *testing.T) { //nolint: gocritic,gocogn
 		o.store.cookies = &cookie.MockStore{
 			Jar: &cookie.MockJar{
 				Cookies: map[interface{}]interface{}{
-					stateCookieName: state,
+					stateCookieName:        state,
+					nonceCookieName:        uuid.New().String(),
+					pkceVerifierCookieName: uuid.New().String(),
 				},
 			},
 		}
@@ -248,6 +556,21 @@ func TestOperation_OIDCCallbackHandler(t *testing.T) { //nolint: gocritic,gocogn
 		o.oidcCallbackHandler(w, newOIDCCallbackRequest(code, state))
 		require.Equal(t, http.StatusFound, w.Code)
 		require.Equal(t, uiEndpoint, w.Header().Get("Location"))
+
+		// tokens and secret shares are encrypted at rest
+		rawTokens, err := storage.OpenStore(tokens.StoreName)
+		require.NoError(t, err)
+		raw, err := rawTokens.Get(sub)
+		require.NoError(t, err)
+		require.NotContains(t, string(raw), accessToken)
+
+		savedTokens, err := o.store.tokens.Get(sub)
+		require.NoError(t, err)
+		require.Equal(t, accessToken, savedTokens.Access)
+
+		usersOfSession, err := o.store.tokens.UsersOfSession("", sessionID)
+		require.NoError(t, err)
+		require.Equal(t, []string{sub}, usersOfSession)
 	})
 
 	t.Run("error internal server error if cannot fetch the user's session", func(t *testing.T) {
@@ -299,7 +622,25 @@ func TestOperation_OIDCCallbackHandler(t *testing.T) { //nolint: gocritic,gocogn
 		require.Equal(t, http.StatusBadRequest, w.Code)
 	})
 
-	t.Run("error bad request if code query param is missing", func(t *testing.T) {
+	t.Run("error bad request if nonce cookie is not present", func(t *testing.T) {
+		state := uuid.New().String()
+		o, err := New(config(t))
+		require.NoError(t, err)
+		o.store.cookies = &cookie.MockStore{
+			Jar: &cookie.MockJar{
+				Cookies: map[interface{}]interface{}{
+					stateCookieName:        state,
+					pkceVerifierCookieName: uuid.New().String(),
+				},
+			},
+		}
+		w := httptest.NewRecorder()
+		o.oidcCallbackHandler(w, newOIDCCallbackRequest("code", state))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Contains(t, w.Body.String(), "missing nonce")
+	})
+
+	t.Run("error bad request if PKCE code verifier cookie is not present", func(t *testing.T) {
 		state := uuid.New().String()
 		o, err := New(config(t))
 		require.NoError(t, err)
@@ -307,6 +648,26 @@ func TestOperation_OIDCCallbackHandler(t *testing.T) { //nolint: gocritic,gocogn
 			Jar: &cookie.MockJar{
 				Cookies: map[interface{}]interface{}{
 					stateCookieName: state,
+					nonceCookieName: uuid.New().String(),
+				},
+			},
+		}
+		w := httptest.NewRecorder()
+		o.oidcCallbackHandler(w, newOIDCCallbackRequest("code", state))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Contains(t, w.Body.String(), "missing PKCE code verifier")
+	})
+
+	t.Run("error bad request if code query param is missing", func(t *testing.T) {
+		state := uuid.New().String()
+		o, err := New(config(t))
+		require.NoError(t, err)
+		o.store.cookies = &cookie.MockStore{
+			Jar: &cookie.MockJar{
+				Cookies: map[interface{}]interface{}{
+					stateCookieName:        state,
+					nonceCookieName:        uuid.New().String(),
+					pkceVerifierCookieName: uuid.New().String(),
 				},
 			},
 		}
@@ -336,7 +697,9 @@ func TestOperation_OIDCCallbackHandler(t *testing.T) { //nolint: gocritic,gocogn
 		o.store.cookies = &cookie.MockStore{
 			Jar: &cookie.MockJar{
 				Cookies: map[interface{}]interface{}{
-					stateCookieName: state,
+					stateCookieName:        state,
+					nonceCookieName:        uuid.New().String(),
+					pkceVerifierCookieName: uuid.New().String(),
 				},
 				SaveErr: errors.New("test"),
 			},
@@ -357,7 +720,9 @@ func TestOperation_OIDCCallbackHandler(t *testing.T) { //nolint: gocritic,gocogn
 		o.store.cookies = &cookie.MockStore{
 			Jar: &cookie.MockJar{
 				Cookies: map[interface{}]interface{}{
-					stateCookieName: state,
+					stateCookieName:        state,
+					nonceCookieName:        uuid.New().String(),
+					pkceVerifierCookieName: uuid.New().String(),
 				},
 			},
 		}
@@ -377,7 +742,9 @@ func TestOperation_OIDCCallbackHandler(t *testing.T) { //nolint: gocritic,gocogn
 		o.store.cookies = &cookie.MockStore{
 			Jar: &cookie.MockJar{
 				Cookies: map[interface{}]interface{}{
-					stateCookieName: state,
+					stateCookieName:        state,
+					nonceCookieName:        uuid.New().String(),
+					pkceVerifierCookieName: uuid.New().String(),
 				},
 			},
 		}
@@ -399,7 +766,9 @@ func TestOperation_OIDCCallbackHandler(t *testing.T) { //nolint: gocritic,gocogn
 		o.store.cookies = &cookie.MockStore{
 			Jar: &cookie.MockJar{
 				Cookies: map[interface{}]interface{}{
-					stateCookieName: state,
+					stateCookieName:        state,
+					nonceCookieName:        uuid.New().String(),
+					pkceVerifierCookieName: uuid.New().String(),
 				},
 			},
 		}
@@ -440,7 +809,9 @@ func TestOperation_OIDCCallbackHandler(t *testing.T) { //nolint: gocritic,gocogn
 		o.store.cookies = &cookie.MockStore{
 			Jar: &cookie.MockJar{
 				Cookies: map[interface{}]interface{}{
-					stateCookieName: state,
+					stateCookieName:        state,
+					nonceCookieName:        uuid.New().String(),
+					pkceVerifierCookieName: uuid.New().String(),
 				},
 			},
 		}
@@ -479,7 +850,9 @@ func TestOperation_OIDCCallbackHandler(t *testing.T) { //nolint: gocritic,gocogn
 		o.store.cookies = &cookie.MockStore{
 			Jar: &cookie.MockJar{
 				Cookies: map[interface{}]interface{}{
-					stateCookieName: state,
+					stateCookieName:        state,
+					nonceCookieName:        uuid.New().String(),
+					pkceVerifierCookieName: uuid.New().String(),
 				},
 			},
 		}
@@ -854,13 +1227,7 @@ func TestOperation_UserProfileHandler(t *testing.T) {
 	t.Run("returns the user profile", func(t *testing.T) {
 		sub := uuid.New().String()
 		config := config(t)
-		config.Storage.Storage = &mockstore.MockStoreProvider{
-			Store: &mockstore.MockStore{
-				Store: map[string]mockstore.DBEntry{
-					sub: {Value: marshal(t, &tokens.UserTokens{})},
-				},
-			},
-		}
+		config.Storage.Storage = plaintextStorage(t, sub)
 		config.OIDCClient = &oidc2.MockClient{
 			UserInfoVal: &oidc2.MockClaimer{
 				ClaimsFunc: func(v interface{}) error {
@@ -964,6 +1331,35 @@ func TestOperation_UserProfileHandler(t *testing.T) {
 		require.Contains(t, result.Body.String(), "cannot open cookies")
 	})
 
+	t.Run("err badgateway if provider refuses to refresh expired token", func(t *testing.T) {
+		sub := uuid.New().String()
+		config := config(t)
+		config.OIDCClient = &oidc2.MockClient{
+			RefreshFunc: func(*oauth2.Token) (*oauth2.Token, error) {
+				return nil, &oauth2.RetrieveError{Response: &http.Response{StatusCode: http.StatusBadRequest}}
+			},
+		}
+		o, err := New(config)
+		require.NoError(t, err)
+		require.NoError(t, o.store.tokens.Save(&tokens.UserTokens{
+			UserSub: sub,
+			Access:  uuid.New().String(),
+			Refresh: uuid.New().String(),
+			Expiry:  time.Now().Add(-time.Minute),
+		}))
+		o.store.cookies = &cookie.MockStore{
+			Jar: &cookie.MockJar{
+				Cookies: map[interface{}]interface{}{
+					userSubCookieName: sub,
+				},
+			},
+		}
+		result := httptest.NewRecorder()
+		o.userProfileHandler(result, newUserProfileRequest())
+		require.Equal(t, http.StatusBadGateway, result.Code)
+		require.Contains(t, result.Body.String(), "failed to refresh access token")
+	})
+
 	t.Run("err forbidden if user cookie is not set", func(t *testing.T) {
 		o, err := New(config(t))
 		require.NoError(t, err)
@@ -1015,13 +1411,7 @@ func TestOperation_UserProfileHandler(t *testing.T) {
 		sub := uuid.New().String()
 		config := config(t)
 		config.OIDCClient = &oidc2.MockClient{UserInfoErr: errors.New("test")}
-		config.Storage.Storage = &mockstore.MockStoreProvider{
-			Store: &mockstore.MockStore{
-				Store: map[string]mockstore.DBEntry{
-					sub: {Value: marshal(t, &tokens.UserTokens{})},
-				},
-			},
-		}
+		config.Storage.Storage = plaintextStorage(t, sub)
 		o, err := New(config)
 		require.NoError(t, err)
 		o.store.cookies = &cookie.MockStore{
@@ -1043,13 +1433,7 @@ func TestOperation_UserProfileHandler(t *testing.T) {
 		config.OIDCClient = &oidc2.MockClient{UserInfoVal: &oidc2.MockClaimer{
 			ClaimsErr: errors.New("test"),
 		}}
-		config.Storage.Storage = &mockstore.MockStoreProvider{
-			Store: &mockstore.MockStore{
-				Store: map[string]mockstore.DBEntry{
-					sub: {Value: marshal(t, &tokens.UserTokens{})},
-				},
-			},
-		}
+		config.Storage.Storage = plaintextStorage(t, sub)
 		o, err := New(config)
 		require.NoError(t, err)
 		o.store.cookies = &cookie.MockStore{
@@ -1068,13 +1452,7 @@ func TestOperation_UserProfileHandler(t *testing.T) {
 	t.Run("err internalserver error if cannot fetch temporary bootstrap data", func(t *testing.T) {
 		sub := uuid.New().String()
 		config := config(t)
-		config.Storage.Storage = &mockstore.MockStoreProvider{
-			Store: &mockstore.MockStore{
-				Store: map[string]mockstore.DBEntry{
-					sub: {Value: marshal(t, &tokens.UserTokens{})},
-				},
-			},
-		}
+		config.Storage.Storage = plaintextStorage(t, sub)
 		config.OIDCClient = &oidc2.MockClient{
 			UserInfoVal: &oidc2.MockClaimer{
 				ClaimsFunc: func(v interface{}) error {
@@ -1111,11 +1489,153 @@ func TestOperation_UserProfileHandler(t *testing.T) {
 	})
 }
 
+func TestOperation_SessionUser(t *testing.T) {
+	sub := uuid.New().String()
+
+	newOperation := func(t *testing.T, cookies map[interface{}]interface{}) *Operation {
+		t.Helper()
+
+		config := config(t)
+		config.WalletDashboard = "http://test.com/dashboard"
+		o, err := New(config)
+		require.NoError(t, err)
+		require.NoError(t, o.store.tokens.Save(&tokens.UserTokens{UserSub: sub, Access: uuid.New().String()}))
+		o.store.cookies = &cookie.MockStore{Jar: &cookie.MockJar{Cookies: cookies}}
+
+		return o
+	}
+
+	newRequest := func(method, origin string) *http.Request {
+		request := httptest.NewRequest(method, "/wallet/profile", nil)
+		request.Header.Set("Origin", origin)
+
+		return request
+	}
+
+	t.Run("returns the user logged in with the session", func(t *testing.T) {
+		o := newOperation(t, map[interface{}]interface{}{userSubCookieName: sub})
+
+		userID, found := o.SessionUser(newRequest(http.MethodPost, "http://test.com"))
+		require.True(t, found)
+		require.Equal(t, sub, userID)
+
+		userID, found = o.SessionUser(newRequest(http.MethodGet, ""))
+		require.True(t, found)
+		require.Equal(t, sub, userID)
+	})
+
+	t.Run("no user if the request changing state is not sent by the wallet", func(t *testing.T) {
+		o := newOperation(t, map[interface{}]interface{}{userSubCookieName: sub})
+
+		for _, origin := range []string{"", "http://evil.com"} {
+			_, found := o.SessionUser(newRequest(http.MethodPost, origin))
+			require.False(t, found)
+		}
+	})
+
+	t.Run("no user without an active session", func(t *testing.T) {
+		o := newOperation(t, map[interface{}]interface{}{})
+
+		_, found := o.SessionUser(newRequest(http.MethodPost, "http://test.com"))
+		require.False(t, found)
+
+		o = newOperation(t, map[interface{}]interface{}{userSubCookieName: uuid.New().String()})
+
+		_, found = o.SessionUser(newRequest(http.MethodPost, "http://test.com"))
+		require.False(t, found)
+	})
+}
+
 func TestOperation_UserLogoutHandler(t *testing.T) {
-	o, err := New(config(t))
-	require.NoError(t, err)
-	t.Run("logs out user", func(t *testing.T) {
-		o, err = New(config(t))
+	const (
+		endSessionURL = "http://test.com/end_session"
+		postLogoutURL = "http://test.com/wallet"
+	)
+
+	config := func(t *testing.T) *Config {
+		t.Helper()
+
+		c := config(t)
+		c.WalletDashboard = "http://test.com/dashboard"
+
+		return c
+	}
+
+	t.Run("revokes tokens and redirects to the provider's end session endpoint", func(t *testing.T) {
+		sub := uuid.New().String()
+		stored := &tokens.UserTokens{
+			UserSub: sub,
+			Access:  uuid.New().String(),
+			Refresh: uuid.New().String(),
+			IDToken: uuid.New().String(),
+		}
+		revoked := make(map[string]string)
+		config := config(t)
+		config.PostLogoutRedirectURL = postLogoutURL
+		config.OIDCClient = &oidc2.MockClient{
+			EndSession: endSessionURL,
+			RevokeFunc: func(token, hint string) error {
+				revoked[hint] = token
+
+				return nil
+			},
+		}
+		o, err := New(config)
+		require.NoError(t, err)
+		require.NoError(t, o.store.tokens.Save(stored))
+		jar := &cookie.MockJar{
+			Cookies: map[interface{}]interface{}{
+				userSubCookieName: sub,
+			},
+		}
+		o.store.cookies = &cookie.MockStore{Jar: jar}
+		result := httptest.NewRecorder()
+		o.userLogoutHandler(result, newUserLogoutRequest())
+		require.Equal(t, http.StatusFound, result.Code)
+		require.Equal(t, endSessionURL, result.Header().Get("Location"))
+		require.Equal(t, map[string]string{
+			"refresh_token": stored.Refresh,
+			"access_token":  stored.Access,
+		}, revoked)
+		_, found := jar.Get(userSubCookieName)
+		require.False(t, found)
+		_, err = o.store.tokens.Get(sub)
+		require.ErrorIs(t, err, ariesstorage.ErrDataNotFound)
+	})
+
+	t.Run("logs out even if tokens cannot be revoked", func(t *testing.T) {
+		sub := uuid.New().String()
+		config := config(t)
+		config.OIDCClient = &oidc2.MockClient{
+			EndSession: endSessionURL,
+			RevokeFunc: func(string, string) error {
+				return errors.New("test")
+			},
+		}
+		o, err := New(config)
+		require.NoError(t, err)
+		require.NoError(t, o.store.tokens.Save(&tokens.UserTokens{UserSub: sub, Access: uuid.New().String()}))
+		o.store.cookies = &cookie.MockStore{
+			Jar: &cookie.MockJar{
+				Cookies: map[interface{}]interface{}{
+					userSubCookieName: sub,
+				},
+			},
+		}
+		result := httptest.NewRecorder()
+		o.userLogoutHandler(result, newUserLogoutRequest())
+		require.Equal(t, http.StatusFound, result.Code)
+		_, err = o.store.tokens.Get(sub)
+		require.ErrorIs(t, err, ariesstorage.ErrDataNotFound)
+	})
+
+	t.Run("redirects to the wallet if the provider does not support end session", func(t *testing.T) {
+		config := config(t)
+		config.PostLogoutRedirectURL = postLogoutURL
+		config.OIDCClient = &oidc2.MockClient{
+			EndSessErr: oidc2.ErrNotSupported,
+		}
+		o, err := New(config)
 		require.NoError(t, err)
 		o.store.cookies = &cookie.MockStore{
 			Jar: &cookie.MockJar{
@@ -1126,10 +1646,13 @@ func TestOperation_UserLogoutHandler(t *testing.T) {
 		}
 		result := httptest.NewRecorder()
 		o.userLogoutHandler(result, newUserLogoutRequest())
-		require.Equal(t, http.StatusOK, result.Code)
+		require.Equal(t, http.StatusFound, result.Code)
+		require.Equal(t, postLogoutURL, result.Header().Get("Location"))
 	})
 
 	t.Run("err badrequest if cannot open cookies", func(t *testing.T) {
+		o, err := New(config(t))
+		require.NoError(t, err)
 		o.store.cookies = &cookie.MockStore{
 			OpenErr: errors.New("test"),
 		}
@@ -1138,7 +1661,10 @@ func TestOperation_UserLogoutHandler(t *testing.T) {
 		require.Equal(t, http.StatusBadRequest, result.Code)
 		require.Contains(t, result.Body.String(), "cannot open cookies")
 	})
+
 	t.Run("err internal server error if cannot delete cookie", func(t *testing.T) {
+		o, err := New(config(t))
+		require.NoError(t, err)
 		o.store.cookies = &cookie.MockStore{
 			Jar: &cookie.MockJar{
 				Cookies: map[interface{}]interface{}{
@@ -1152,10 +1678,138 @@ func TestOperation_UserLogoutHandler(t *testing.T) {
 		require.Equal(t, http.StatusInternalServerError, result.Code)
 		require.Contains(t, result.Body.String(), "failed to delete user sub cookie")
 	})
-	t.Run("no-op if user sub cookie is not found", func(t *testing.T) {
+
+	t.Run("err forbidden if not requested from the wallet", func(t *testing.T) {
+		sub := uuid.New().String()
+		o, err := New(config(t))
+		require.NoError(t, err)
+		require.NoError(t, o.store.tokens.Save(&tokens.UserTokens{UserSub: sub, Access: uuid.New().String()}))
+		o.store.cookies = &cookie.MockStore{
+			Jar: &cookie.MockJar{
+				Cookies: map[interface{}]interface{}{
+					userSubCookieName: sub,
+				},
+			},
+		}
+
+		for _, origin := range []string{"", "http://evil.com", "https://test.com"} {
+			request := newUserLogoutRequest()
+			request.Header.Set("Origin", origin)
+			result := httptest.NewRecorder()
+			o.userLogoutHandler(result, request)
+			require.Equal(t, http.StatusForbidden, result.Code)
+			require.Contains(t, result.Body.String(), "logout must be requested from the wallet")
+		}
+
+		_, err = o.store.tokens.Get(sub)
+		require.NoError(t, err)
+	})
+
+	t.Run("ends the provider session if user sub cookie is not found", func(t *testing.T) {
+		config := config(t)
+		config.OIDCClient = &oidc2.MockClient{EndSession: endSessionURL}
+		o, err := New(config)
+		require.NoError(t, err)
 		result := httptest.NewRecorder()
 		o.userLogoutHandler(result, newUserLogoutRequest())
+		require.Equal(t, http.StatusFound, result.Code)
+		require.Equal(t, endSessionURL, result.Header().Get("Location"))
+	})
+}
+
+func TestOperation_BackChannelLogoutHandler(t *testing.T) {
+	t.Run("ends all sessions of the user", func(t *testing.T) {
+		sub := uuid.New().String()
+		config := config(t)
+		config.OIDCClient = &oidc2.MockClient{
+			LogoutToken: &oidc2.LogoutToken{Subject: sub},
+		}
+		o, err := New(config)
+		require.NoError(t, err)
+		require.NoError(t, o.store.tokens.Save(&tokens.UserTokens{UserSub: sub, Access: uuid.New().String()}))
+		o.store.cookies = &cookie.MockStore{
+			Jar: &cookie.MockJar{
+				Cookies: map[interface{}]interface{}{
+					userSubCookieName: sub,
+				},
+			},
+		}
+
+		result := httptest.NewRecorder()
+		o.backChannelLogoutHandler(result, newBackChannelLogoutRequest("token"))
 		require.Equal(t, http.StatusOK, result.Code)
+		require.Equal(t, "no-store", result.Header().Get("Cache-Control"))
+
+		_, err = o.store.tokens.Get(sub)
+		require.ErrorIs(t, err, ariesstorage.ErrDataNotFound)
+
+		// the user's existing session cookie is no longer accepted
+		result = httptest.NewRecorder()
+		o.userProfileHandler(result, newUserProfileRequest())
+		require.Equal(t, http.StatusForbidden, result.Code)
+	})
+
+	t.Run("ends the sessions of the users logged in during the provider session", func(t *testing.T) {
+		sub, other := uuid.New().String(), uuid.New().String()
+		sessionID := uuid.New().String()
+		config := config(t)
+		config.OIDCClient = &oidc2.MockClient{
+			LogoutToken: &oidc2.LogoutToken{SessionID: sessionID},
+		}
+		o, err := New(config)
+		require.NoError(t, err)
+		require.NoError(t, o.store.tokens.Save(&tokens.UserTokens{UserSub: sub, SessionID: sessionID}))
+		require.NoError(t, o.store.tokens.Save(&tokens.UserTokens{UserSub: other, SessionID: uuid.New().String()}))
+
+		result := httptest.NewRecorder()
+		o.backChannelLogoutHandler(result, newBackChannelLogoutRequest("token"))
+		require.Equal(t, http.StatusOK, result.Code)
+
+		_, err = o.store.tokens.Get(sub)
+		require.ErrorIs(t, err, ariesstorage.ErrDataNotFound)
+		_, err = o.store.tokens.Get(other)
+		require.NoError(t, err)
+	})
+
+	t.Run("err badrequest if logout_token is missing", func(t *testing.T) {
+		o, err := New(config(t))
+		require.NoError(t, err)
+		result := httptest.NewRecorder()
+		o.backChannelLogoutHandler(result, newBackChannelLogoutRequest(""))
+		require.Equal(t, http.StatusBadRequest, result.Code)
+		require.Contains(t, result.Body.String(), "missing logout_token")
+	})
+
+	t.Run("err badrequest if logout_token is invalid", func(t *testing.T) {
+		config := config(t)
+		config.OIDCClient = &oidc2.MockClient{
+			LogoutErr: errors.New("test"),
+		}
+		o, err := New(config)
+		require.NoError(t, err)
+		result := httptest.NewRecorder()
+		o.backChannelLogoutHandler(result, newBackChannelLogoutRequest("token"))
+		require.Equal(t, http.StatusBadRequest, result.Code)
+		require.Contains(t, result.Body.String(), "invalid logout_token")
+	})
+
+	t.Run("err internal server error if tokens cannot be deleted", func(t *testing.T) {
+		config := config(t)
+		config.OIDCClient = &oidc2.MockClient{
+			LogoutToken: &oidc2.LogoutToken{Subject: uuid.New().String()},
+		}
+		config.Storage.Storage = &mockstore.MockStoreProvider{
+			Store: &mockstore.MockStore{
+				Store:     make(map[string]mockstore.DBEntry),
+				ErrDelete: errors.New("test"),
+			},
+		}
+		o, err := New(config)
+		require.NoError(t, err)
+		result := httptest.NewRecorder()
+		o.backChannelLogoutHandler(result, newBackChannelLogoutRequest("token"))
+		require.Equal(t, http.StatusInternalServerError, result.Code)
+		require.Contains(t, result.Body.String(), "failed to end user sessions")
 	})
 }
 
@@ -1172,7 +1826,23 @@ func newUserProfileRequest() *http.Request {
 }
 
 func newUserLogoutRequest() *http.Request {
-	return httptest.NewRequest(http.MethodGet, "/oidc/logout", nil)
+	request := httptest.NewRequest(http.MethodPost, "/oidc/logout", nil)
+	request.Header.Set("Origin", "http://test.com")
+
+	return request
+}
+
+func newBackChannelLogoutRequest(logoutToken string) *http.Request {
+	form := url.Values{}
+
+	if logoutToken != "" {
+		form.Set("logout_token", logoutToken)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/oidc/backchannel-logout", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	return r
 }
 
 func config(t *testing.T) *Config {
@@ -1181,8 +1851,9 @@ func config(t *testing.T) *Config {
 	return &Config{
 		OIDCClient: &oidc2.MockClient{},
 		Storage: &StorageConfig{
-			Storage:          ariesmem.NewProvider(),
-			TransientStorage: ariesmem.NewProvider(),
+			Storage:           ariesmem.NewProvider(),
+			TransientStorage:  ariesmem.NewProvider(),
+			KeyEncryptionKeys: []*encrypted.Key{{ID: uuid.New().String(), Secret: key(t)}},
 		},
 		Cookie: &cookie.Config{
 			AuthKey: key(t),
@@ -1227,6 +1898,55 @@ func marshal(t *testing.T, v interface{}) []byte {
 	return bits
 }
 
+// plaintextStorage returns storage holding user data written before encryption at rest was enabled.
+func plaintextStorage(t *testing.T, sub string) ariesstorage.Provider {
+	t.Helper()
+
+	p := ariesmem.NewProvider()
+
+	tokenStore, err := p.OpenStore(tokens.StoreName)
+	require.NoError(t, err)
+	require.NoError(t, tokenStore.Put(sub, marshal(t, &tokens.UserTokens{UserSub: sub, Access: uuid.New().String()})))
+
+	userStore, err := p.OpenStore(user.StoreName)
+	require.NoError(t, err)
+	require.NoError(t, userStore.Put(sub, marshal(t, &user.User{Sub: sub})))
+
+	return p
+}
+
+func mockProviderClient(t *testing.T, issuer, sub string) *oidc2.MockClient {
+	t.Helper()
+
+	return &oidc2.MockClient{
+		OAuthToken: &oauth2.Token{
+			AccessToken: uuid.New().String(),
+			TokenType:   "Bearer",
+		},
+		IDToken: &oidc2.MockClaimer{
+			ClaimsFunc: func(i interface{}) error {
+				u, ok := i.(*user.User)
+				require.True(t, ok)
+				u.Issuer = issuer
+				u.Sub = sub
+
+				return nil
+			},
+		},
+	}
+}
+
+func loginSessionJar(state, providerID string) *cookie.MockJar {
+	return &cookie.MockJar{
+		Cookies: map[interface{}]interface{}{
+			stateCookieName:        state,
+			nonceCookieName:        uuid.New().String(),
+			pkceVerifierCookieName: uuid.New().String(),
+			providerCookieName:     providerID,
+		},
+	}
+}
+
 func setupOnboardingTest(t *testing.T, state string) *Operation {
 	t.Helper()
 
@@ -1256,7 +1976,9 @@ func setupOnboardingTest(t *testing.T, state string) *Operation {
 	ops.store.cookies = &cookie.MockStore{
 		Jar: &cookie.MockJar{
 			Cookies: map[interface{}]interface{}{
-				stateCookieName: state,
+				stateCookieName:        state,
+				nonceCookieName:        uuid.New().String(),
+				pkceVerifierCookieName: uuid.New().String(),
 			},
 		},
 	}
@@ -1302,10 +2024,13 @@ func (m *mockHTTPClient) Do(req *http.Request) (*http.Response, error) {
 type mockEDVClient struct {
 	CreateErr  error
 	Capability []byte
+	count      int
 }
 
 func (m *mockEDVClient) CreateDataVault(_ *models.DataVaultConfiguration,
 	_ ...client.ReqOption) (string, []byte, error) {
+	m.count++
+
 	if m.CreateErr != nil {
 		return "", nil, m.CreateErr
 	}
@@ -0,0 +1,385 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	ariesstorage "github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/lafriks/go-shamir"
+
+	"github.com/trustbloc/wallet/pkg/restapi/common"
+	"github.com/trustbloc/wallet/pkg/restapi/common/store/user"
+)
+
+const (
+	// secretSharesThreshold is fixed: the KMS rebuilds the user's secret from the wallet and hub-auth shares.
+	secretSharesThreshold = 2
+	defaultSecretShares   = 2
+	// maxSecretShares allows a single recovery share: any two recovery shares would rebuild the secret without
+	// the wallet or hub-auth.
+	maxSecretShares = 3
+	// recoveryCheckSize is the size of the digest of the user's secret appended to recovery codes, used to tell
+	// whether a rebuilt secret is the right one.
+	recoveryCheckSize = 8
+	// recoveryCodesTTL is how long the recovery codes of a new user are held in memory for the user to fetch them.
+	recoveryCodesTTL = time.Hour
+	// recoveryCodesKeyPrefix prefixes the transient store keys of the recovery codes held for new users.
+	recoveryCodesKeyPrefix = "recoverycodes_"
+)
+
+// SecretSharesConfig configures how the secret protecting the user's keys is split.
+type SecretSharesConfig struct {
+	// Shares is the number of shares the secret is split into: 2, or 3 with a recovery share. Any two shares rebuild
+	// the secret. The first share is kept by the wallet, the second by hub-auth and the third is the recovery share.
+	// The recovery share must never be given to the operators of the wallet server or hub-auth. Defaults to 2.
+	Shares int
+	// EscrowURL receives the recovery shares of new users. If not set, users fetch them once as recovery codes.
+	EscrowURL string
+	// HubAuthAPIToken authorizes fetching the hub-auth share of a user during recovery, and removing the hub-auth data
+	// of users whose accounts are deleted by operators.
+	HubAuthAPIToken string
+}
+
+type heldRecoveryCodes struct {
+	Codes  []string  `json:"codes"`
+	Expiry time.Time `json:"expiry"`
+}
+
+type secretShares struct {
+	wallet        []byte
+	auth          []byte
+	recoveryCodes []string
+}
+
+func (o *Operation) splitSecret(secret []byte) (*secretShares, error) {
+	shares, err := shamir.Split(secret, o.secretShares.Shares, secretSharesThreshold)
+	if err != nil {
+		return nil, fmt.Errorf("split user secret: %w", err)
+	}
+
+	check := sha256.Sum256(secret)
+
+	split := &secretShares{
+		wallet: shares[0],
+		auth:   shares[1],
+	}
+
+	for _, share := range shares[secretSharesThreshold:] {
+		code := make([]byte, 0, len(share)+recoveryCheckSize)
+		code = append(code, share...)
+		code = append(code, check[:recoveryCheckSize]...)
+
+		split.recoveryCodes = append(split.recoveryCodes, base64.RawURLEncoding.EncodeToString(code))
+	}
+
+	return split, nil
+}
+
+// escrowRecoveryCodes sends the recovery codes to the escrow. Returns false if there is no escrow.
+func (o *Operation) escrowRecoveryCodes(userID, accessToken string, codes []string) (bool, error) {
+	if o.secretShares.EscrowURL == "" || len(codes) == 0 {
+		return false, nil
+	}
+
+	reqBytes, err := json.Marshal(&escrowRequest{User: userID, RecoveryCodes: codes})
+	if err != nil {
+		return false, fmt.Errorf("marshal escrow request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(context.TODO(),
+		http.MethodPost, o.secretShares.EscrowURL, bytes.NewBuffer(reqBytes))
+	if err != nil {
+		return false, err
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+	_, _, err = common.SendHTTPRequest(req, o.httpClient, http.StatusOK, logger)
+	if err != nil {
+		return false, fmt.Errorf("escrow recovery codes: %w", err)
+	}
+
+	return true, nil
+}
+
+// holdRecoveryCodes keeps the recovery codes of a new user in the transient store until the user fetches them.
+// They are never saved with the user's wallet share, and are dropped if not fetched within recoveryCodesTTL.
+func (o *Operation) holdRecoveryCodes(userID string, codes []string) error {
+	bits, err := json.Marshal(&heldRecoveryCodes{Codes: codes, Expiry: time.Now().Add(recoveryCodesTTL)})
+	if err != nil {
+		return fmt.Errorf("marshal recovery codes: %w", err)
+	}
+
+	err = o.store.transient.Put(recoveryCodesKeyPrefix+userID, bits)
+	if err != nil {
+		return fmt.Errorf("hold recovery codes: %w", err)
+	}
+
+	time.AfterFunc(recoveryCodesTTL, func() {
+		_, err := o.takeRecoveryCodes(userID)
+		if err != nil {
+			logger.Warnf("failed to drop the unfetched recovery codes of user %s: %s", userID, err.Error())
+		}
+	})
+
+	return nil
+}
+
+// takeRecoveryCodes removes the recovery codes held for the user and returns them if they have not expired.
+func (o *Operation) takeRecoveryCodes(userID string) ([]string, error) {
+	bits, err := o.store.transient.Get(recoveryCodesKeyPrefix + userID)
+	if errors.Is(err, ariesstorage.ErrDataNotFound) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("get recovery codes: %w", err)
+	}
+
+	err = o.store.transient.Delete(recoveryCodesKeyPrefix + userID)
+	if err != nil {
+		return nil, fmt.Errorf("delete recovery codes: %w", err)
+	}
+
+	held := &heldRecoveryCodes{}
+
+	err = json.Unmarshal(bits, held)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal recovery codes: %w", err)
+	}
+
+	if time.Now().After(held.Expiry) {
+		return nil, nil
+	}
+
+	return held.Codes, nil
+}
+
+// recoveryCodesHandler returns the recovery codes of the logged in user once. They are deleted after they are sent.
+func (o *Operation) recoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	usr, _, ok := o.loggedInUser(w, r)
+	if !ok {
+		return
+	}
+
+	codes, err := o.takeRecoveryCodes(usr.ID())
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError,
+			"failed to fetch recovery codes: %s", err.Error())
+
+		return
+	}
+
+	// users onboarded by earlier versions have their recovery codes saved with their data
+	if len(usr.RecoveryCodes) > 0 {
+		codes = append(codes, usr.RecoveryCodes...)
+		usr.RecoveryCodes = nil
+
+		err = o.store.users.Save(usr)
+		if err != nil {
+			common.WriteErrorResponsef(w, logger, http.StatusInternalServerError,
+				"failed to delete recovery codes: %s", err.Error())
+
+			return
+		}
+	}
+
+	resp := &recoveryCodesResp{RecoveryCodes: codes}
+	if resp.RecoveryCodes == nil {
+		resp.RecoveryCodes = []string{}
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	common.WriteResponse(w, logger, resp)
+}
+
+// recoverHandler rebuilds the user's secret from a recovery code and the hub-auth share, and splits it again.
+// All shares are replaced, so the recovery code cannot be used again.
+func (o *Operation) recoverHandler(w http.ResponseWriter, r *http.Request) { // nolint:funlen // not much logic
+	usr, accessToken, ok := o.loggedInUser(w, r)
+	if !ok {
+		return
+	}
+
+	req := &recoverReq{}
+
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "invalid request: %s", err.Error())
+
+		return
+	}
+
+	recoveryShare, check, err := parseRecoveryCode(req.RecoveryCode)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "invalid recovery code: %s", err.Error())
+
+		return
+	}
+
+	authShare, err := o.fetchAuthSecretShare(usr.Sub)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadGateway,
+			"failed to fetch secret share from auth server: %s", err.Error())
+
+		return
+	}
+
+	secret, err := shamir.Combine(recoveryShare, authShare)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "invalid recovery code: %s", err.Error())
+
+		return
+	}
+
+	digest := sha256.Sum256(secret)
+	if subtle.ConstantTimeCompare(digest[:recoveryCheckSize], check) != 1 {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest,
+			"invalid recovery code: does not match the user's secret")
+
+		return
+	}
+
+	split, err := o.splitSecret(secret)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "failed to rotate secret: %s", err.Error())
+
+		return
+	}
+
+	escrowed, err := o.escrowRecoveryCodes(usr.ID(), accessToken, split.recoveryCodes)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadGateway, "failed to rotate secret: %s", err.Error())
+
+		return
+	}
+
+	// hub-auth gets its new share first: if the user cannot be saved afterwards, hub-auth gets its old share back,
+	// so the wallet and hub-auth shares always rebuild the same secret
+	err = postSecret(o.hubAuthURL, accessToken, split.auth, o.httpClient)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadGateway,
+			"failed to post secret share to auth server: %s", err.Error())
+
+		return
+	}
+
+	usr.SecretShare = base64.StdEncoding.EncodeToString(split.wallet)
+	usr.RecoveryCodes = nil
+
+	err = o.store.users.Save(usr)
+	if err != nil {
+		restoreErr := postSecret(o.hubAuthURL, accessToken, authShare, o.httpClient)
+		if restoreErr != nil {
+			logger.Errorf("failed to restore the hub-auth secret share of user %s after a failed rotation: %s",
+				usr.ID(), restoreErr.Error())
+		}
+
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError,
+			"failed to persist user data: %s", err.Error())
+
+		return
+	}
+
+	resp := &recoveryCodesResp{RecoveryCodes: split.recoveryCodes}
+	if escrowed || resp.RecoveryCodes == nil {
+		resp.RecoveryCodes = []string{}
+	}
+
+	logger.Infof("rotated the secret shares of user %s", usr.ID())
+
+	w.Header().Set("Cache-Control", "no-store")
+	common.WriteResponse(w, logger, resp)
+}
+
+// loggedInUser returns the user of the session and the user's access token.
+func (o *Operation) loggedInUser(w http.ResponseWriter, r *http.Request) (*user.User, string, bool) {
+	jar, err := o.store.cookies.Open(r)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "cannot open cookies: %s", err.Error())
+
+		return nil, "", false
+	}
+
+	userIDCookie, found := jar.Get(userSubCookieName)
+	if !found || !o.hasActiveSession(userIDCookie) {
+		common.WriteErrorResponsef(w, logger, http.StatusForbidden, "not logged in")
+
+		return nil, "", false
+	}
+
+	userID, _ := userIDCookie.(string) // nolint:errcheck // checked by hasActiveSession
+
+	tokns, _, err := o.validTokens(r.Context(), userID)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusForbidden, "failed to fetch user tokens: %s", err.Error())
+
+		return nil, "", false
+	}
+
+	usr, err := o.store.users.Get(userID)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError,
+			"failed to query user data: %s", err.Error())
+
+		return nil, "", false
+	}
+
+	return usr, tokns.AccessToken, true
+}
+
+func (o *Operation) fetchAuthSecretShare(sub string) ([]byte, error) {
+	if o.secretShares.HubAuthAPIToken == "" {
+		return nil, errors.New("hub-auth API token is not configured")
+	}
+
+	req, err := http.NewRequestWithContext(context.TODO(), http.MethodGet,
+		o.hubAuthURL+authSecretPath+"?sub="+url.QueryEscape(sub), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", o.secretShares.HubAuthAPIToken))
+
+	respBody, _, err := common.SendHTTPRequest(req, o.httpClient, http.StatusOK, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &secretRequest{}
+
+	err = json.Unmarshal(respBody, resp)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal secret resp: %w", err)
+	}
+
+	return resp.Secret, nil
+}
+
+func parseRecoveryCode(code string) (share, check []byte, err error) {
+	raw, err := base64.RawURLEncoding.DecodeString(code)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(raw) <= recoveryCheckSize {
+		return nil, nil, errors.New("too short")
+	}
+
+	return raw[:len(raw)-recoveryCheckSize], raw[len(raw)-recoveryCheckSize:], nil
+}
@@ -0,0 +1,329 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc // nolint:testpackage // testing package-private types
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	mockstore "github.com/hyperledger/aries-framework-go/pkg/mock/storage"
+	"github.com/lafriks/go-shamir"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/wallet/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/wallet/pkg/restapi/common/store/user"
+)
+
+func TestOperation_SplitSecret(t *testing.T) {
+	t.Run("recovery code rebuilds the secret with either share", func(t *testing.T) {
+		o, _ := newOnboardingTestOperation(t, uuid.New().String())
+		o.secretShares.Shares = 3
+
+		secret := []byte(uuid.New().String())
+
+		split, err := o.splitSecret(secret)
+		require.NoError(t, err)
+		require.Len(t, split.recoveryCodes, 1)
+
+		for _, code := range split.recoveryCodes {
+			share, check, err := parseRecoveryCode(code)
+			require.NoError(t, err)
+			require.Len(t, check, recoveryCheckSize)
+
+			for _, other := range [][]byte{split.wallet, split.auth} {
+				result, err := shamir.Combine(share, other)
+				require.NoError(t, err)
+				require.Equal(t, secret, result)
+			}
+		}
+	})
+
+	t.Run("no recovery codes by default", func(t *testing.T) {
+		o, _ := newOnboardingTestOperation(t, uuid.New().String())
+
+		split, err := o.splitSecret([]byte(uuid.New().String()))
+		require.NoError(t, err)
+		require.Empty(t, split.recoveryCodes)
+	})
+}
+
+func TestOperation_OnboardingRecoveryCodes(t *testing.T) {
+	t.Run("recovery codes are held for the user but not saved with the user", func(t *testing.T) {
+		sub := uuid.New().String()
+		o, _ := newOnboardingTestOperation(t, sub)
+		o.secretShares.Shares = 3
+
+		w := callback(o)
+		require.Equal(t, http.StatusFound, w.Code)
+
+		usr, err := o.store.users.Get(sub)
+		require.NoError(t, err)
+		require.Empty(t, usr.RecoveryCodes)
+
+		codes, err := o.takeRecoveryCodes(sub)
+		require.NoError(t, err)
+		require.Len(t, codes, 1)
+	})
+
+	t.Run("recovery codes are escrowed", func(t *testing.T) {
+		sub := uuid.New().String()
+		o, remote := newOnboardingTestOperation(t, sub)
+		o.secretShares.Shares = 3
+		o.secretShares.EscrowURL = "https://escrow.example.com/recovery"
+
+		w := callback(o)
+		require.Equal(t, http.StatusFound, w.Code)
+		require.Equal(t, 1, remote.count(http.MethodPost, "/recovery"))
+
+		escrowed := &escrowRequest{}
+		require.NoError(t, json.Unmarshal(remote.received[http.MethodPost+" /recovery"], escrowed))
+		require.Equal(t, sub, escrowed.User)
+		require.Len(t, escrowed.RecoveryCodes, 1)
+
+		usr, err := o.store.users.Get(sub)
+		require.NoError(t, err)
+		require.Empty(t, usr.RecoveryCodes)
+
+		codes, err := o.takeRecoveryCodes(sub)
+		require.NoError(t, err)
+		require.Empty(t, codes)
+	})
+
+	t.Run("error if recovery codes cannot be escrowed", func(t *testing.T) {
+		o, remote := newOnboardingTestOperation(t, uuid.New().String())
+		o.secretShares.Shares = 3
+		o.secretShares.EscrowURL = "https://escrow.example.com/recovery"
+		remote.status["/recovery"] = http.StatusInternalServerError
+
+		w := callback(o)
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "escrow recovery codes")
+	})
+}
+
+func TestOperation_RecoveryCodesHandler(t *testing.T) {
+	t.Run("recovery codes are returned once", func(t *testing.T) {
+		sub := uuid.New().String()
+		o, _ := newAccountTestOperation(t, sub)
+		o.store.cookies = &cookie.MockStore{
+			Jar: &cookie.MockJar{Cookies: map[interface{}]interface{}{userSubCookieName: sub}},
+		}
+
+		require.NoError(t, o.holdRecoveryCodes(sub, []string{"code"}))
+
+		w := httptest.NewRecorder()
+		o.recoveryCodesHandler(w, httptest.NewRequest(http.MethodGet, recoveryCodesPath, nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+
+		resp := &recoveryCodesResp{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(resp))
+		require.Equal(t, []string{"code"}, resp.RecoveryCodes)
+
+		w = httptest.NewRecorder()
+		o.recoveryCodesHandler(w, httptest.NewRequest(http.MethodGet, recoveryCodesPath, nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.NoError(t, json.NewDecoder(w.Body).Decode(resp))
+		require.Empty(t, resp.RecoveryCodes)
+	})
+
+	t.Run("recovery codes saved by earlier versions are returned once", func(t *testing.T) {
+		sub := uuid.New().String()
+		o, _ := newAccountTestOperation(t, sub)
+		o.store.cookies = &cookie.MockStore{
+			Jar: &cookie.MockJar{Cookies: map[interface{}]interface{}{userSubCookieName: sub}},
+		}
+
+		usr, err := o.store.users.Get(sub)
+		require.NoError(t, err)
+		usr.RecoveryCodes = []string{"code"}
+		require.NoError(t, o.store.users.Save(usr))
+
+		w := httptest.NewRecorder()
+		o.recoveryCodesHandler(w, httptest.NewRequest(http.MethodGet, recoveryCodesPath, nil))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		resp := &recoveryCodesResp{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(resp))
+		require.Equal(t, []string{"code"}, resp.RecoveryCodes)
+
+		usr, err = o.store.users.Get(sub)
+		require.NoError(t, err)
+		require.Empty(t, usr.RecoveryCodes)
+	})
+
+	t.Run("expired recovery codes are not returned", func(t *testing.T) {
+		sub := uuid.New().String()
+		o, _ := newAccountTestOperation(t, sub)
+
+		require.NoError(t, o.store.transient.Put(recoveryCodesKeyPrefix+sub,
+			marshal(t, &heldRecoveryCodes{Codes: []string{"code"}, Expiry: time.Now().Add(-time.Second)})))
+
+		codes, err := o.takeRecoveryCodes(sub)
+		require.NoError(t, err)
+		require.Empty(t, codes)
+	})
+
+	t.Run("err forbidden if the user is not logged in", func(t *testing.T) {
+		o, _ := newAccountTestOperation(t, uuid.New().String())
+		o.store.cookies = &cookie.MockStore{Jar: &cookie.MockJar{Cookies: map[interface{}]interface{}{}}}
+
+		w := httptest.NewRecorder()
+		o.recoveryCodesHandler(w, httptest.NewRequest(http.MethodGet, recoveryCodesPath, nil))
+		require.Equal(t, http.StatusForbidden, w.Code)
+	})
+}
+
+func TestOperation_RecoverHandler(t *testing.T) {
+	t.Run("rotates the secret shares", func(t *testing.T) {
+		secret := []byte(uuid.New().String())
+		o, remote, code := newRecoveryTestOperation(t, secret)
+
+		w := httptest.NewRecorder()
+		o.recoverHandler(w, newRecoverRequest(t, code))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		resp := &recoveryCodesResp{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(resp))
+		require.Len(t, resp.RecoveryCodes, 1)
+		require.NotEqual(t, code, resp.RecoveryCodes[0])
+
+		posted := &secretRequest{}
+		require.NoError(t, json.Unmarshal(remote.received[http.MethodPost+" "+authSecretPath], posted))
+
+		sub, found := o.store.cookies.(*cookie.MockStore).Jar.Get(userSubCookieName)
+		require.True(t, found)
+
+		usr, err := o.store.users.Get(sub.(string))
+		require.NoError(t, err)
+
+		walletShare, err := base64.StdEncoding.DecodeString(usr.SecretShare)
+		require.NoError(t, err)
+
+		result, err := shamir.Combine(walletShare, posted.Secret)
+		require.NoError(t, err)
+		require.Equal(t, secret, result)
+	})
+
+	t.Run("err badrequest if the recovery code is for another secret", func(t *testing.T) {
+		o, remote, _ := newRecoveryTestOperation(t, []byte(uuid.New().String()))
+
+		split, err := o.splitSecret([]byte(uuid.New().String()))
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		o.recoverHandler(w, newRecoverRequest(t, split.recoveryCodes[0]))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Contains(t, w.Body.String(), "does not match")
+		require.Equal(t, 0, remote.count(http.MethodPost, authSecretPath))
+	})
+
+	t.Run("err badrequest if the recovery code is malformed", func(t *testing.T) {
+		o, _, _ := newRecoveryTestOperation(t, []byte(uuid.New().String()))
+
+		w := httptest.NewRecorder()
+		o.recoverHandler(w, newRecoverRequest(t, "c2hvcnQ"))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Contains(t, w.Body.String(), "invalid recovery code")
+	})
+
+	t.Run("err badrequest if the request is malformed", func(t *testing.T) {
+		o, _, _ := newRecoveryTestOperation(t, []byte(uuid.New().String()))
+
+		w := httptest.NewRecorder()
+		o.recoverHandler(w, httptest.NewRequest(http.MethodPost, recoverPath, bytes.NewReader([]byte("{"))))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("err badgateway if the hub-auth API token is not configured", func(t *testing.T) {
+		o, _, code := newRecoveryTestOperation(t, []byte(uuid.New().String()))
+		o.secretShares.HubAuthAPIToken = ""
+
+		w := httptest.NewRecorder()
+		o.recoverHandler(w, newRecoverRequest(t, code))
+		require.Equal(t, http.StatusBadGateway, w.Code)
+		require.Contains(t, w.Body.String(), "API token is not configured")
+	})
+
+	t.Run("err badgateway if the new secret share cannot be posted", func(t *testing.T) {
+		o, remote, code := newRecoveryTestOperation(t, []byte(uuid.New().String()))
+		remote.status[authSecretPath] = http.StatusInternalServerError
+
+		sub, _ := o.store.cookies.(*cookie.MockStore).Jar.Get(userSubCookieName)
+
+		before, err := o.store.users.Get(sub.(string))
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		o.recoverHandler(w, newRecoverRequest(t, code))
+		require.Equal(t, http.StatusBadGateway, w.Code)
+
+		after, err := o.store.users.Get(sub.(string))
+		require.NoError(t, err)
+		require.Equal(t, before.SecretShare, after.SecretShare)
+	})
+
+	t.Run("restores the hub-auth share if the user cannot be saved", func(t *testing.T) {
+		secret := []byte(uuid.New().String())
+		o, remote, code := newRecoveryTestOperation(t, secret)
+
+		sub, _ := o.store.cookies.(*cookie.MockStore).Jar.Get(userSubCookieName)
+
+		usr, err := o.store.users.Get(sub.(string))
+		require.NoError(t, err)
+
+		o.store.users, err = user.NewStore(&mockstore.MockStoreProvider{Store: &mockstore.MockStore{
+			Store:  map[string]mockstore.DBEntry{usr.ID(): {Value: marshal(t, usr)}},
+			ErrPut: errors.New("test"),
+		}})
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		o.recoverHandler(w, newRecoverRequest(t, code))
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Equal(t, 2, remote.count(http.MethodPost, authSecretPath))
+
+		restored := &secretRequest{}
+		require.NoError(t, json.Unmarshal(remote.received[http.MethodPost+" "+authSecretPath], restored))
+
+		fetched := &secretRequest{}
+		require.NoError(t, json.Unmarshal(remote.bodies[http.MethodGet+" "+authSecretPath], fetched))
+		require.Equal(t, fetched.Secret, restored.Secret)
+	})
+}
+
+func newRecoveryTestOperation(t *testing.T, secret []byte) (*Operation, *mockOnboardingServer, string) {
+	t.Helper()
+
+	sub := uuid.New().String()
+	o, remote := newAccountTestOperation(t, sub)
+	o.secretShares.Shares = 3
+	o.secretShares.HubAuthAPIToken = uuid.New().String()
+	o.store.cookies = &cookie.MockStore{
+		Jar: &cookie.MockJar{Cookies: map[interface{}]interface{}{userSubCookieName: sub}},
+	}
+
+	split, err := o.splitSecret(secret)
+	require.NoError(t, err)
+
+	remote.bodies[http.MethodGet+" "+authSecretPath] = marshal(t, &secretRequest{Secret: split.auth})
+
+	return o, remote, split.recoveryCodes[0]
+}
+
+func newRecoverRequest(t *testing.T, code string) *http.Request {
+	t.Helper()
+
+	return httptest.NewRequest(http.MethodPost, recoverPath, bytes.NewReader(marshal(t, &recoverReq{RecoveryCode: code})))
+}
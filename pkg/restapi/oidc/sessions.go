@@ -0,0 +1,123 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	ariesstorage "github.com/hyperledger/aries-framework-go/spi/storage"
+
+	"github.com/trustbloc/wallet/pkg/restapi/common"
+)
+
+const (
+	sessionIDQueryParam = "id"
+	// sessionSweepInterval is how often expired server-side sessions are deleted.
+	sessionSweepInterval = time.Hour
+)
+
+// listSessionsHandler returns the active sessions of the logged in user.
+func (o *Operation) listSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := o.sessionOwner(w, r)
+	if !ok {
+		return
+	}
+
+	o.writeSessions(w, r, userID)
+}
+
+// revokeSessionHandler ends one of the sessions of the logged in user and returns the remaining sessions.
+func (o *Operation) revokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := o.sessionOwner(w, r)
+	if !ok {
+		return
+	}
+
+	id := r.URL.Query().Get(sessionIDQueryParam)
+	if id == "" {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing %s query parameter", sessionIDQueryParam)
+
+		return
+	}
+
+	err := o.store.sessions.Revoke(userID, id)
+	if errors.Is(err, ariesstorage.ErrDataNotFound) {
+		common.WriteErrorResponsef(w, logger, http.StatusNotFound, "session not found")
+
+		return
+	}
+
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError,
+			"failed to revoke session: %s", err.Error())
+
+		return
+	}
+
+	logger.Infof("revoked a session of user %s", userID)
+
+	o.writeSessions(w, r, userID)
+}
+
+func (o *Operation) writeSessions(w http.ResponseWriter, r *http.Request, userID string) {
+	sessions, err := o.store.sessions.List(r, userID)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError,
+			"failed to list sessions: %s", err.Error())
+
+		return
+	}
+
+	common.WriteResponse(w, logger, &sessionsResp{Sessions: sessions})
+}
+
+// sessionOwner returns the ID of the logged in user if sessions are kept on the server.
+func (o *Operation) sessionOwner(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if o.store.sessions == nil {
+		common.WriteErrorResponsef(w, logger, http.StatusNotFound, "server-side sessions are not enabled")
+
+		return "", false
+	}
+
+	jar, err := o.store.cookies.Open(r)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "cannot open cookies: %s", err.Error())
+
+		return "", false
+	}
+
+	userIDCookie, found := jar.Get(userSubCookieName)
+	if !found || !o.hasActiveSession(userIDCookie) {
+		common.WriteErrorResponsef(w, logger, http.StatusForbidden, "not logged in")
+
+		return "", false
+	}
+
+	userID, _ := userIDCookie.(string) // nolint:errcheck // checked by hasActiveSession
+
+	return userID, true
+}
+
+// sweepSessionsEvery deletes the expired server-side sessions until the operation is closed.
+func (o *Operation) sweepSessionsEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-o.done:
+			return
+		case <-ticker.C:
+			err := o.store.sessions.Sweep()
+			if err != nil {
+				logger.Errorf("session sweep: %s", err.Error())
+			}
+		}
+	}
+}
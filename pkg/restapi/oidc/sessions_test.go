@@ -0,0 +1,133 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/wallet/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/wallet/pkg/restapi/common/store/tokens"
+)
+
+func TestOperation_Sessions(t *testing.T) {
+	t.Run("lists and revokes the user's sessions", func(t *testing.T) {
+		o, sub := newSessionsTestOperation(t)
+		first := login(t, o, sub)
+		second := login(t, o, sub)
+
+		w := httptest.NewRecorder()
+		o.listSessionsHandler(w, sessionsRequest(http.MethodGet, "", second))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		resp := &sessionsResp{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(resp))
+		require.Len(t, resp.Sessions, 2)
+		require.True(t, resp.Sessions[1].Current)
+
+		w = httptest.NewRecorder()
+		o.revokeSessionHandler(w, sessionsRequest(http.MethodDelete, resp.Sessions[0].ID, second))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.NoError(t, json.NewDecoder(w.Body).Decode(resp))
+		require.Len(t, resp.Sessions, 1)
+
+		w = httptest.NewRecorder()
+		o.listSessionsHandler(w, sessionsRequest(http.MethodGet, "", first))
+		require.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("err notfound if the session does not exist", func(t *testing.T) {
+		o, sub := newSessionsTestOperation(t)
+		c := login(t, o, sub)
+
+		w := httptest.NewRecorder()
+		o.revokeSessionHandler(w, sessionsRequest(http.MethodDelete, uuid.New().String(), c))
+		require.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("err badrequest if the session ID is missing", func(t *testing.T) {
+		o, sub := newSessionsTestOperation(t)
+		c := login(t, o, sub)
+
+		w := httptest.NewRecorder()
+		o.revokeSessionHandler(w, sessionsRequest(http.MethodDelete, "", c))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("err forbidden if the user is not logged in", func(t *testing.T) {
+		o, _ := newSessionsTestOperation(t)
+
+		w := httptest.NewRecorder()
+		o.listSessionsHandler(w, sessionsRequest(http.MethodGet, "", nil))
+		require.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("err notfound if sessions are kept in cookies", func(t *testing.T) {
+		o, err := New(config(t))
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		o.listSessionsHandler(w, sessionsRequest(http.MethodGet, "", nil))
+		require.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
+func newSessionsTestOperation(t *testing.T) (*Operation, string) {
+	t.Helper()
+
+	config := config(t)
+	config.ServerSessions = true
+
+	o, err := New(config)
+	require.NoError(t, err)
+
+	sub := uuid.New().String()
+	require.NoError(t, o.store.tokens.Save(&tokens.UserTokens{UserSub: sub, Access: uuid.New().String()}))
+
+	return o, sub
+}
+
+// login starts a new session of the user and returns its cookie.
+func login(t *testing.T, o *Operation, sub string) *http.Cookie {
+	t.Helper()
+
+	r := httptest.NewRequest(http.MethodGet, oidcCallbackPath, nil)
+
+	jar, err := o.store.cookies.Open(r)
+	require.NoError(t, err)
+
+	jar.Set(userSubCookieName, sub)
+
+	w := httptest.NewRecorder()
+	require.NoError(t, jar.Save(r, w))
+
+	cookies := w.Result().Cookies() // nolint:bodyclose // no body
+	require.Len(t, cookies, 1)
+	require.Equal(t, cookie.StoreName, cookies[0].Name)
+
+	return cookies[0]
+}
+
+func sessionsRequest(method, id string, c *http.Cookie) *http.Request {
+	target := sessionsPath
+	if id != "" {
+		target += "?" + sessionIDQueryParam + "=" + id
+	}
+
+	r := httptest.NewRequest(method, target, nil)
+
+	if c != nil {
+		r.AddCookie(c)
+	}
+
+	return r
+}
@@ -0,0 +1,141 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"golang.org/x/oauth2"
+
+	"github.com/trustbloc/wallet/pkg/restapi/common/oidc"
+	"github.com/trustbloc/wallet/pkg/restapi/common/store/tokens"
+)
+
+// userTokenSource is an oauth2.TokenSource over a user's tokens in the tokens store. Expired access tokens are
+// refreshed at the provider and the rotated tokens are written back to the store.
+type userTokenSource struct {
+	ctx    context.Context
+	userID string
+	op     *Operation
+}
+
+func (o *Operation) tokenSource(ctx context.Context, userID string) oauth2.TokenSource {
+	return &userTokenSource{ctx: ctx, userID: userID, op: o}
+}
+
+// Token returns a valid access token for the user, refreshing it if needed.
+func (u *userTokenSource) Token() (*oauth2.Token, error) {
+	token, _, err := u.op.validTokens(u.ctx, u.userID)
+
+	return token, err
+}
+
+// validTokens returns a valid access token for the user, refreshing it if needed, and the client of the provider
+// that issued it.
+// Concurrent refreshes for the same user are collapsed: callers that wait on the lock find the rotated token
+// in the store and do not refresh again.
+func (o *Operation) validTokens(ctx context.Context, userID string) (*oauth2.Token, oidc.Client, error) {
+	unlock := o.refreshLocks.lock(userID)
+	defer unlock()
+
+	stored, err := o.store.tokens.Get(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	provider, found := o.providers[stored.Provider]
+	if !found {
+		return nil, nil, fmt.Errorf("unknown OIDC provider: %s", stored.Provider)
+	}
+
+	current := &oauth2.Token{
+		AccessToken:  stored.Access,
+		TokenType:    "Bearer",
+		RefreshToken: stored.Refresh,
+		Expiry:       stored.Expiry,
+	}
+
+	if current.Valid() {
+		return current, provider.Client, nil
+	}
+
+	if current.RefreshToken == "" {
+		return nil, nil, fmt.Errorf("access token expired and no refresh token is available")
+	}
+
+	logger.Debugf("refreshing access token for user %s", userID)
+
+	refreshed, err := provider.Client.TokenSource(ctx, current).Token()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to refresh access token: %w", err)
+	}
+
+	idToken := stored.IDToken
+
+	if rotated, ok := refreshed.Extra("id_token").(string); ok && rotated != "" {
+		idToken = rotated
+	}
+
+	err = o.store.tokens.Save(&tokens.UserTokens{
+		UserSub:   userID,
+		Provider:  stored.Provider,
+		Access:    refreshed.AccessToken,
+		Refresh:   refreshed.RefreshToken,
+		Expiry:    refreshed.Expiry,
+		IDToken:   idToken,
+		SessionID: stored.SessionID,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to persist refreshed tokens: %w", err)
+	}
+
+	return refreshed, provider.Client, nil
+}
+
+// keyedMutex hands out one lock per key, and forgets keys nobody is waiting on.
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*refCountedMutex
+}
+
+type refCountedMutex struct {
+	sync.Mutex
+	refs int
+}
+
+func newKeyedMutex() *keyedMutex {
+	return &keyedMutex{locks: make(map[string]*refCountedMutex)}
+}
+
+func (k *keyedMutex) lock(key string) (unlock func()) {
+	k.mu.Lock()
+
+	l, found := k.locks[key]
+	if !found {
+		l = &refCountedMutex{}
+		k.locks[key] = l
+	}
+
+	l.refs++
+	k.mu.Unlock()
+
+	l.Lock()
+
+	return func() {
+		l.Unlock()
+
+		k.mu.Lock()
+		defer k.mu.Unlock()
+
+		l.refs--
+		if l.refs == 0 {
+			delete(k.locks, key)
+		}
+	}
+}
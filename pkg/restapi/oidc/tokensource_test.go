@@ -0,0 +1,174 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc // nolint:testpackage // testing package-private types
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+
+	oidc2 "github.com/trustbloc/wallet/pkg/restapi/common/oidc"
+	"github.com/trustbloc/wallet/pkg/restapi/common/store/tokens"
+)
+
+func TestUserTokenSource(t *testing.T) {
+	t.Run("returns valid token without refreshing", func(t *testing.T) {
+		sub := uuid.New().String()
+		o := newTokenSourceTestOperation(t, func(*oauth2.Token) (*oauth2.Token, error) {
+			require.Fail(t, "token should not be refreshed")
+
+			return nil, nil
+		})
+		stored := &tokens.UserTokens{
+			UserSub: sub,
+			Access:  uuid.New().String(),
+			Refresh: uuid.New().String(),
+			Expiry:  time.Now().Add(time.Hour),
+		}
+		require.NoError(t, o.store.tokens.Save(stored))
+
+		result, err := o.tokenSource(context.Background(), sub).Token()
+		require.NoError(t, err)
+		require.Equal(t, stored.Access, result.AccessToken)
+	})
+
+	t.Run("refreshes expired token and saves the rotated tokens", func(t *testing.T) {
+		sub := uuid.New().String()
+		refreshed := &oauth2.Token{
+			AccessToken:  uuid.New().String(),
+			RefreshToken: uuid.New().String(),
+			Expiry:       time.Now().Add(time.Hour),
+		}
+		oldRefresh := uuid.New().String()
+		o := newTokenSourceTestOperation(t, func(current *oauth2.Token) (*oauth2.Token, error) {
+			require.Equal(t, oldRefresh, current.RefreshToken)
+
+			return refreshed, nil
+		})
+		require.NoError(t, o.store.tokens.Save(&tokens.UserTokens{
+			UserSub: sub,
+			Access:  uuid.New().String(),
+			Refresh: oldRefresh,
+			Expiry:  time.Now().Add(-time.Minute),
+		}))
+
+		result, err := o.tokenSource(context.Background(), sub).Token()
+		require.NoError(t, err)
+		require.Equal(t, refreshed.AccessToken, result.AccessToken)
+
+		saved, err := o.store.tokens.Get(sub)
+		require.NoError(t, err)
+		require.Equal(t, refreshed.AccessToken, saved.Access)
+		require.Equal(t, refreshed.RefreshToken, saved.Refresh)
+		require.True(t, refreshed.Expiry.Equal(saved.Expiry))
+	})
+
+	t.Run("collapses concurrent refreshes for the same user", func(t *testing.T) {
+		sub := uuid.New().String()
+		var calls int32
+		o := newTokenSourceTestOperation(t, func(*oauth2.Token) (*oauth2.Token, error) {
+			atomic.AddInt32(&calls, 1)
+			time.Sleep(50 * time.Millisecond)
+
+			return &oauth2.Token{
+				AccessToken:  uuid.New().String(),
+				RefreshToken: uuid.New().String(),
+				Expiry:       time.Now().Add(time.Hour),
+			}, nil
+		})
+		require.NoError(t, o.store.tokens.Save(&tokens.UserTokens{
+			UserSub: sub,
+			Access:  uuid.New().String(),
+			Refresh: uuid.New().String(),
+			Expiry:  time.Now().Add(-time.Minute),
+		}))
+
+		const callers = 10
+
+		results := make([]string, callers)
+		wg := sync.WaitGroup{}
+
+		for i := 0; i < callers; i++ {
+			wg.Add(1)
+
+			go func(i int) {
+				defer wg.Done()
+
+				result, err := o.tokenSource(context.Background(), sub).Token()
+				require.NoError(t, err)
+
+				results[i] = result.AccessToken
+			}(i)
+		}
+
+		wg.Wait()
+
+		require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+		for i := range results {
+			require.Equal(t, results[0], results[i])
+		}
+
+		require.Empty(t, o.refreshLocks.locks)
+	})
+
+	t.Run("error if tokens are not found", func(t *testing.T) {
+		o := newTokenSourceTestOperation(t, nil)
+		_, err := o.tokenSource(context.Background(), uuid.New().String()).Token()
+		require.Error(t, err)
+	})
+
+	t.Run("error if token expired and there is no refresh token", func(t *testing.T) {
+		sub := uuid.New().String()
+		o := newTokenSourceTestOperation(t, nil)
+		require.NoError(t, o.store.tokens.Save(&tokens.UserTokens{
+			UserSub: sub,
+			Access:  uuid.New().String(),
+			Expiry:  time.Now().Add(-time.Minute),
+		}))
+
+		_, err := o.tokenSource(context.Background(), sub).Token()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "no refresh token")
+	})
+
+	t.Run("error if refresh fails", func(t *testing.T) {
+		sub := uuid.New().String()
+		expected := errors.New("test")
+		o := newTokenSourceTestOperation(t, func(*oauth2.Token) (*oauth2.Token, error) {
+			return nil, expected
+		})
+		require.NoError(t, o.store.tokens.Save(&tokens.UserTokens{
+			UserSub: sub,
+			Access:  uuid.New().String(),
+			Refresh: uuid.New().String(),
+			Expiry:  time.Now().Add(-time.Minute),
+		}))
+
+		_, err := o.tokenSource(context.Background(), sub).Token()
+		require.ErrorIs(t, err, expected)
+	})
+}
+
+func newTokenSourceTestOperation(t *testing.T, refresh func(*oauth2.Token) (*oauth2.Token, error)) *Operation {
+	t.Helper()
+
+	config := config(t)
+	config.OIDCClient = &oidc2.MockClient{RefreshFunc: refresh}
+
+	o, err := New(config)
+	require.NoError(t, err)
+
+	return o
+}
@@ -0,0 +1,366 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package backup seals the contents of wallets into encrypted archives, and opens them. The contents are encrypted
+// with a random data key, itself encrypted with a key derived from a passphrase or with a key of a remote keystore.
+package backup
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Version is the version of the archives and manifests created.
+const Version = 1
+
+// methods sealing the data keys of archives.
+const (
+	SealedByPassphrase = "passphrase"
+	SealedByKey        = "key"
+)
+
+const (
+	dataKeySize = 32
+	saltSize    = 16
+
+	// scrypt parameters recommended for interactive logins.
+	scryptN = 32768
+	scryptR = 8
+	scryptP = 1
+)
+
+var (
+	// ErrUnsupportedVersion is returned for archives or manifests of versions this package cannot open.
+	ErrUnsupportedVersion = errors.New("unsupported backup version")
+	// ErrSealing is returned when an archive cannot be opened with the given sealer: the sealing method differs, the
+	// passphrase or key is wrong, or the archive was tampered with.
+	ErrSealing = errors.New("failed to unseal backup")
+	// ErrIntegrity is returned when the contents of an archive do not match its manifest.
+	ErrIntegrity = errors.New("backup integrity check failed")
+	// ErrKeystore is returned when the remote keystore fails to encrypt or decrypt the data key of an archive.
+	ErrKeystore = errors.New("keystore error")
+)
+
+// Backup is the plaintext of an archive: the contents of a wallet, described by a manifest. Contents[i] is the
+// content described by Manifest.Entries[i].
+type Backup struct {
+	Manifest *Manifest         `json:"manifest"`
+	Contents []json.RawMessage `json:"contents"`
+}
+
+// Manifest describes the contents of a backup. Excluded lists the IDs of the contents of the wallet that were not
+// backed up.
+type Manifest struct {
+	Version  int       `json:"version"`
+	Created  time.Time `json:"created"`
+	Entries  []*Entry  `json:"entries"`
+	Excluded []string  `json:"excluded,omitempty"`
+}
+
+// Entry describes a content of a backup. Digest is the base64url encoded SHA-256 digest of the compacted content.
+type Entry struct {
+	ContentType  string `json:"contentType"`
+	ContentID    string `json:"contentID"`
+	CollectionID string `json:"collectionID,omitempty"`
+	Digest       string `json:"digest"`
+}
+
+// Archive is a sealed backup.
+type Archive struct {
+	Version    int      `json:"version"`
+	Sealing    *Sealing `json:"sealing"`
+	Nonce      []byte   `json:"nonce"`
+	Ciphertext []byte   `json:"ciphertext"`
+}
+
+// Sealing describes how the data key of an archive is sealed. Salt is the scrypt salt of the key derived from the
+// passphrase, and KeyURL the URL of the key of the remote keystore.
+type Sealing struct {
+	Method     string `json:"method"`
+	Salt       []byte `json:"salt,omitempty"`
+	KeyURL     string `json:"keyURL,omitempty"`
+	WrappedKey []byte `json:"wrappedKey"`
+	KeyNonce   []byte `json:"keyNonce"`
+}
+
+// New returns a backup of contents, with a manifest of their entries. Digests of the entries are computed.
+func New(entries []*Entry, contents []json.RawMessage) *Backup {
+	for i := range entries {
+		entries[i].Digest = digest(contents[i])
+	}
+
+	return &Backup{
+		Manifest: &Manifest{Version: Version, Created: time.Now().UTC(), Entries: entries},
+		Contents: contents,
+	}
+}
+
+// Verify checks the version of the manifest of a backup, and that its contents match their entries.
+func (b *Backup) Verify() error {
+	if b.Manifest == nil {
+		return fmt.Errorf("%w: missing manifest", ErrIntegrity)
+	}
+
+	if b.Manifest.Version != Version {
+		return fmt.Errorf("%w: manifest version %d", ErrUnsupportedVersion, b.Manifest.Version)
+	}
+
+	if len(b.Manifest.Entries) != len(b.Contents) {
+		return fmt.Errorf("%w: %d entries for %d contents", ErrIntegrity, len(b.Manifest.Entries), len(b.Contents))
+	}
+
+	for i, entry := range b.Manifest.Entries {
+		if entry.ContentType == "" || entry.ContentID == "" {
+			return fmt.Errorf("%w: entry %d is missing a content type or ID", ErrIntegrity, i)
+		}
+
+		if entry.Digest != digest(b.Contents[i]) {
+			return fmt.Errorf("%w: digest mismatch of %s %s", ErrIntegrity, entry.ContentType, entry.ContentID)
+		}
+	}
+
+	return nil
+}
+
+// KeyCrypto encrypts and decrypts with the keys of a remote keystore, identified by their URLs. It is implemented
+// by the remote crypto of webkms.
+type KeyCrypto interface {
+	Encrypt(msg, aad []byte, keyURL interface{}) ([]byte, []byte, error)
+	Decrypt(cipher, aad, nonce []byte, keyURL interface{}) ([]byte, error)
+}
+
+// Sealer seals and unseals the data keys of archives.
+type Sealer interface {
+	seal(dataKey []byte) (*Sealing, error)
+	unseal(sealing *Sealing) ([]byte, error)
+}
+
+// WithPassphrase returns a sealer of data keys with keys derived from a passphrase.
+func WithPassphrase(passphrase string) Sealer {
+	return &passphraseSealer{passphrase: passphrase}
+}
+
+// WithKey returns a sealer of data keys with a key of a remote keystore. The key URL is only used to seal archives:
+// archives are unsealed with the key that sealed them.
+func WithKey(keyCrypto KeyCrypto, keyURL string) Sealer {
+	return &keySealer{crypto: keyCrypto, keyURL: keyURL}
+}
+
+// Seal encrypts a backup with a new data key, sealed by the sealer.
+func Seal(b *Backup, sealer Sealer) (*Archive, error) {
+	plaintext, err := json.Marshal(b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal backup: %w", err)
+	}
+
+	dataKey := make([]byte, dataKeySize)
+
+	_, err = rand.Read(dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create data key: %w", err)
+	}
+
+	sealing, err := sealer.seal(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	archive := &Archive{Version: Version, Sealing: sealing}
+
+	aad, err := archive.aad()
+	if err != nil {
+		return nil, err
+	}
+
+	archive.Nonce, archive.Ciphertext, err = encrypt(dataKey, plaintext, aad)
+	if err != nil {
+		return nil, err
+	}
+
+	return archive, nil
+}
+
+// Open decrypts an archive with the data key unsealed by the sealer, and verifies the backup.
+func Open(archive *Archive, sealer Sealer) (*Backup, error) {
+	if archive.Version != Version {
+		return nil, fmt.Errorf("%w: archive version %d", ErrUnsupportedVersion, archive.Version)
+	}
+
+	if archive.Sealing == nil {
+		return nil, fmt.Errorf("%w: missing sealing", ErrSealing)
+	}
+
+	dataKey, err := sealer.unseal(archive.Sealing)
+	if err != nil {
+		return nil, err
+	}
+
+	aad, err := archive.aad()
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := decrypt(dataKey, archive.Nonce, archive.Ciphertext, aad)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &Backup{}
+
+	err = json.Unmarshal(plaintext, b)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid backup: %s", ErrIntegrity, err.Error())
+	}
+
+	err = b.Verify()
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// aad returns the associated data of the encryption of the backup: the version and sealing of the archive, so that
+// they cannot be altered.
+func (a *Archive) aad() ([]byte, error) {
+	aad, err := json.Marshal(&Archive{Version: a.Version, Sealing: a.Sealing})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal archive header: %w", err)
+	}
+
+	return aad, nil
+}
+
+type passphraseSealer struct {
+	passphrase string
+}
+
+func (s *passphraseSealer) seal(dataKey []byte) (*Sealing, error) {
+	salt := make([]byte, saltSize)
+
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create salt: %w", err)
+	}
+
+	key, err := scrypt.Key([]byte(s.passphrase), salt, scryptN, scryptR, scryptP, dataKeySize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+
+	nonce, wrapped, err := encrypt(key, dataKey, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Sealing{Method: SealedByPassphrase, Salt: salt, WrappedKey: wrapped, KeyNonce: nonce}, nil
+}
+
+func (s *passphraseSealer) unseal(sealing *Sealing) ([]byte, error) {
+	if sealing.Method != SealedByPassphrase {
+		return nil, fmt.Errorf("%w: archive is sealed by %s", ErrSealing, sealing.Method)
+	}
+
+	key, err := scrypt.Key([]byte(s.passphrase), sealing.Salt, scryptN, scryptR, scryptP, dataKeySize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+
+	return decrypt(key, sealing.KeyNonce, sealing.WrappedKey, nil)
+}
+
+type keySealer struct {
+	crypto KeyCrypto
+	keyURL string
+}
+
+func (s *keySealer) seal(dataKey []byte) (*Sealing, error) {
+	wrapped, nonce, err := s.crypto.Encrypt(dataKey, nil, s.keyURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to encrypt data key: %s", ErrKeystore, err.Error())
+	}
+
+	return &Sealing{Method: SealedByKey, KeyURL: s.keyURL, WrappedKey: wrapped, KeyNonce: nonce}, nil
+}
+
+func (s *keySealer) unseal(sealing *Sealing) ([]byte, error) {
+	if sealing.Method != SealedByKey {
+		return nil, fmt.Errorf("%w: archive is sealed by %s", ErrSealing, sealing.Method)
+	}
+
+	dataKey, err := s.crypto.Decrypt(sealing.WrappedKey, nil, sealing.KeyNonce, sealing.KeyURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to decrypt data key: %s", ErrKeystore, err.Error())
+	}
+
+	return dataKey, nil
+}
+
+func encrypt(key, plaintext, aad []byte) ([]byte, []byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create nonce: %w", err)
+	}
+
+	return nonce, aead.Seal(nil, nonce, plaintext, aad), nil
+}
+
+func decrypt(key, nonce, ciphertext, aad []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("%w: invalid nonce", ErrSealing)
+	}
+
+	plaintext, err := aead.Open(nil, nonce, ciphertext, aad)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrSealing, err.Error())
+	}
+
+	return plaintext, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid key: %s", ErrSealing, err.Error())
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// digest returns the digest of a content, compacted as when marshaled into an archive.
+func digest(content []byte) string {
+	compacted := &bytes.Buffer{}
+
+	if json.Compact(compacted, content) == nil {
+		content = compacted.Bytes()
+	}
+
+	hash := sha256.Sum256(content)
+
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
@@ -0,0 +1,170 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package backup // nolint:testpackage // changing to different package requires exposing internal features.
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const sampleKeyURL = "https://kms.example.com/v1/keystores/123/keys/456"
+
+func TestSealOpen(t *testing.T) {
+	t.Run("seal and open a backup with a passphrase", func(t *testing.T) {
+		b := sampleBackup()
+
+		archive, err := Seal(b, WithPassphrase("passphrase"))
+		require.NoError(t, err)
+		require.Equal(t, Version, archive.Version)
+		require.Equal(t, SealedByPassphrase, archive.Sealing.Method)
+		require.NotContains(t, string(archive.Ciphertext), "urn:uuid:credential")
+
+		opened, err := Open(roundTrip(t, archive), WithPassphrase("passphrase"))
+		require.NoError(t, err)
+		require.Equal(t, b.Manifest.Entries, opened.Manifest.Entries)
+		require.Len(t, opened.Contents, len(b.Contents))
+
+		for i := range b.Contents {
+			require.JSONEq(t, string(b.Contents[i]), string(opened.Contents[i]))
+		}
+
+		_, err = Open(archive, WithPassphrase("wrong"))
+		require.ErrorIs(t, err, ErrSealing)
+
+		_, err = Open(archive, WithKey(&keyCrypto{}, ""))
+		require.ErrorIs(t, err, ErrSealing)
+	})
+
+	t.Run("seal and open a backup with a key of a keystore", func(t *testing.T) {
+		crypto := &keyCrypto{}
+
+		archive, err := Seal(sampleBackup(), WithKey(crypto, sampleKeyURL))
+		require.NoError(t, err)
+		require.Equal(t, SealedByKey, archive.Sealing.Method)
+		require.Equal(t, sampleKeyURL, archive.Sealing.KeyURL)
+
+		_, err = Open(roundTrip(t, archive), WithKey(crypto, ""))
+		require.NoError(t, err)
+		require.Equal(t, []string{sampleKeyURL, sampleKeyURL}, crypto.keyURLs)
+
+		_, err = Open(archive, WithPassphrase("passphrase"))
+		require.ErrorIs(t, err, ErrSealing)
+
+		_, err = Open(archive, WithKey(&keyCrypto{err: errors.New("forbidden")}, ""))
+		require.ErrorIs(t, err, ErrKeystore)
+
+		_, err = Seal(sampleBackup(), WithKey(&keyCrypto{err: errors.New("forbidden")}, sampleKeyURL))
+		require.ErrorIs(t, err, ErrKeystore)
+	})
+
+	t.Run("error if the archive is tampered with", func(t *testing.T) {
+		archive, err := Seal(sampleBackup(), WithPassphrase("passphrase"))
+		require.NoError(t, err)
+
+		tampered := roundTrip(t, archive)
+		tampered.Ciphertext[0] ^= 1
+
+		_, err = Open(tampered, WithPassphrase("passphrase"))
+		require.ErrorIs(t, err, ErrSealing)
+
+		tampered = roundTrip(t, archive)
+		tampered.Sealing.KeyURL = sampleKeyURL
+
+		_, err = Open(tampered, WithPassphrase("passphrase"))
+		require.ErrorIs(t, err, ErrSealing)
+
+		tampered = roundTrip(t, archive)
+		tampered.Version = Version + 1
+
+		_, err = Open(tampered, WithPassphrase("passphrase"))
+		require.ErrorIs(t, err, ErrUnsupportedVersion)
+
+		tampered = roundTrip(t, archive)
+		tampered.Sealing = nil
+
+		_, err = Open(tampered, WithPassphrase("passphrase"))
+		require.ErrorIs(t, err, ErrSealing)
+	})
+
+	t.Run("error if the contents do not match the manifest", func(t *testing.T) {
+		b := sampleBackup()
+		b.Contents[0] = json.RawMessage(`{"id": "urn:uuid:other"}`)
+
+		archive, err := Seal(b, WithPassphrase("passphrase"))
+		require.NoError(t, err)
+
+		_, err = Open(archive, WithPassphrase("passphrase"))
+		require.ErrorIs(t, err, ErrIntegrity)
+
+		b = sampleBackup()
+		b.Contents = b.Contents[:1]
+		require.ErrorIs(t, b.Verify(), ErrIntegrity)
+
+		b = sampleBackup()
+		b.Manifest.Entries[0].ContentID = ""
+		require.ErrorIs(t, b.Verify(), ErrIntegrity)
+
+		b = sampleBackup()
+		b.Manifest.Version = Version + 1
+		require.ErrorIs(t, b.Verify(), ErrUnsupportedVersion)
+
+		require.ErrorIs(t, (&Backup{}).Verify(), ErrIntegrity)
+	})
+}
+
+func sampleBackup() *Backup {
+	return New([]*Entry{
+		{ContentType: "collection", ContentID: "urn:uuid:collection"},
+		{ContentType: "credential", ContentID: "urn:uuid:credential", CollectionID: "urn:uuid:collection"},
+	}, []json.RawMessage{
+		json.RawMessage(`{"id": "urn:uuid:collection", "type": "collection"}`),
+		json.RawMessage(`{"id": "urn:uuid:credential", "type": ["VerifiableCredential"]}`),
+	})
+}
+
+// roundTrip returns a copy of an archive, marshaled and unmarshaled as when exported and imported.
+func roundTrip(t *testing.T, archive *Archive) *Archive {
+	t.Helper()
+
+	raw, err := json.Marshal(archive)
+	require.NoError(t, err)
+
+	copied := &Archive{}
+	require.NoError(t, json.Unmarshal(raw, copied))
+
+	return copied
+}
+
+// keyCrypto "encrypts" by reversing messages, and records the key URLs it is called with.
+type keyCrypto struct {
+	err     error
+	keyURLs []string
+}
+
+func (c *keyCrypto) Encrypt(msg, _ []byte, keyURL interface{}) ([]byte, []byte, error) {
+	c.keyURLs = append(c.keyURLs, keyURL.(string))
+
+	return reverse(msg), []byte("nonce"), c.err
+}
+
+func (c *keyCrypto) Decrypt(cipher, _, _ []byte, keyURL interface{}) ([]byte, error) {
+	c.keyURLs = append(c.keyURLs, keyURL.(string))
+
+	return reverse(cipher), c.err
+}
+
+func reverse(b []byte) []byte {
+	reversed := make([]byte, len(b))
+
+	for i := range b {
+		reversed[len(b)-1-i] = b[i]
+	}
+
+	return reversed
+}
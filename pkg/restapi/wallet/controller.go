@@ -8,6 +8,10 @@ SPDX-License-Identifier: Apache-2.0
 package wallet
 
 import (
+	"crypto/tls"
+	"net/http"
+	"time"
+
 	"github.com/hyperledger/aries-framework-go/pkg/controller/command"
 	"github.com/hyperledger/aries-framework-go/pkg/controller/rest"
 	"github.com/hyperledger/aries-framework-go/pkg/controller/webnotifier"
@@ -24,6 +28,18 @@ type allOpts struct {
 	msgHandler   command.MessageHandler
 	notifier     command.Notifier
 	walletAppURL string
+	tlsConfig    *tls.Config
+
+	statusSweepInterval time.Duration
+
+	expirySweepInterval time.Duration
+	expiryLeadTimes     []time.Duration
+
+	mediatorURL            string
+	mediatorPickupInterval time.Duration
+	routing                bool
+
+	didKeys *operation.DIDKeys
 }
 
 // Opt represents a controller option.
@@ -64,8 +80,62 @@ func WithWalletAppURL(walletApp string) Opt {
 	}
 }
 
-// GetRESTHandlers gets all REST handlers provided by wallet controller.
-func GetRESTHandlers(ctx *context.Provider, opts ...Opt) ([]rest.Handler, error) { //nolint:interfacer,gocritic
+// WithTLSConfig is an option for setting up the TLS configuration of the clients of remote KMS servers.
+func WithTLSConfig(tlsConfig *tls.Config) Opt {
+	return func(opts *allOpts) {
+		opts.tlsConfig = tlsConfig
+	}
+}
+
+// WithStatusSweepInterval is an option for setting the interval at which the statuses of the credentials stored in
+// wallets are checked. Stored credentials are not checked if it is not set.
+func WithStatusSweepInterval(interval time.Duration) Opt {
+	return func(opts *allOpts) {
+		opts.statusSweepInterval = interval
+	}
+}
+
+// WithExpiryNotifications is an option for setting the interval at which the expiration dates of the credentials
+// stored in wallets are checked, and the lead times before their expiry at which credentials are notified. Stored
+// credentials are not checked if the interval is not set.
+func WithExpiryNotifications(sweepInterval time.Duration, leadTimes ...time.Duration) Opt {
+	return func(opts *allOpts) {
+		opts.expirySweepInterval = sweepInterval
+		opts.expiryLeadTimes = leadTimes
+	}
+}
+
+// WithMediator is an option for registering the agent with the mediator whose out-of-band invitation is published at
+// the given URL, and for setting the interval at which the messages queued by the mediator are picked up.
+func WithMediator(invitationURL string, pickupInterval time.Duration) Opt {
+	return func(opts *allOpts) {
+		opts.mediatorURL = invitationURL
+		opts.mediatorPickupInterval = pickupInterval
+	}
+}
+
+// WithRouting is an option for making the agent act as a mediator for other agents.
+func WithRouting(routing bool) Opt {
+	return func(opts *allOpts) {
+		opts.routing = routing
+	}
+}
+
+// WithDIDKeys is an option for setting the keys with which the orb VDR of the agent signs the updates and
+// deactivations of the did:orb DIDs of wallets.
+func WithDIDKeys(keys *operation.DIDKeys) Opt {
+	return func(opts *allOpts) {
+		opts.didKeys = keys
+	}
+}
+
+// Controller provides the REST handlers of the wallet.
+type Controller struct {
+	op *operation.Operation
+}
+
+// New returns a wallet controller.
+func New(ctx *context.Provider, opts ...Opt) (*Controller, error) { //nolint:interfacer,gocritic
 	restAPIOpts := &allOpts{}
 	// Apply options
 	for _, opt := range opts {
@@ -78,14 +148,49 @@ func GetRESTHandlers(ctx *context.Provider, opts ...Opt) ([]rest.Handler, error)
 	}
 
 	// VC wallet REST controller operations,
-	walletOpts, err := operation.New(ctx, notifier, restAPIOpts.msgHandler)
+	walletOpts, err := operation.New(ctx, notifier, restAPIOpts.msgHandler, operation.WithHTTPClient(&http.Client{
+		Transport: &http.Transport{TLSClientConfig: restAPIOpts.tlsConfig},
+	}), operation.WithWalletAppURL(restAPIOpts.walletAppURL),
+		operation.WithStatusSweepInterval(restAPIOpts.statusSweepInterval),
+		operation.WithExpiryNotifications(restAPIOpts.expirySweepInterval, restAPIOpts.expiryLeadTimes...),
+		operation.WithMediator(restAPIOpts.mediatorURL, restAPIOpts.mediatorPickupInterval),
+		operation.WithRouting(restAPIOpts.routing),
+		operation.WithDIDKeys(restAPIOpts.didKeys))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Controller{op: walletOpts}, nil
+}
+
+// WalletHandlers returns the handlers called by wallet apps. Besides the authentication of the server, wallet
+// contents are only accessed with the token returned when the user's wallet is unlocked.
+func (c *Controller) WalletHandlers() []rest.Handler {
+	return c.op.GetRESTHandlers()
+}
+
+// ServiceHandlers returns the handlers configuring the agent for all users, which are meant to be called by other
+// services only.
+func (c *Controller) ServiceHandlers() []rest.Handler {
+	return c.op.GetServiceRESTHandlers()
+}
+
+// Close stops the background work of the wallet operations.
+func (c *Controller) Close() {
+	c.op.Close()
+}
+
+// GetRESTHandlers gets all REST handlers provided by wallet controller.
+func GetRESTHandlers(ctx *context.Provider, opts ...Opt) ([]rest.Handler, error) { //nolint:interfacer,gocritic
+	controller, err := New(ctx, opts...)
 	if err != nil {
 		return nil, err
 	}
 
 	// create handlers from all REST operations.
 	var allHandlers []rest.Handler
-	allHandlers = append(allHandlers, walletOpts.GetRESTHandlers()...)
+	allHandlers = append(allHandlers, controller.WalletHandlers()...)
+	allHandlers = append(allHandlers, controller.ServiceHandlers()...)
 
 	return allHandlers, nil
 }
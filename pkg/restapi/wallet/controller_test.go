@@ -6,8 +6,11 @@ SPDX-License-Identifier: Apache-2.0
 package wallet_test
 
 import (
+	"crypto/tls"
 	"testing"
+	"time"
 
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/messaging/msghandler"
 	"github.com/hyperledger/aries-framework-go/pkg/framework/aries"
 	"github.com/hyperledger/aries-framework-go/pkg/framework/aries/defaults"
 	"github.com/stretchr/testify/require"
@@ -29,7 +32,7 @@ func TestGetRESTHandlers(t *testing.T) {
 
 		handlers, err := wallet.GetRESTHandlers(ctx)
 		require.NoError(t, err)
-		require.Empty(t, handlers)
+		require.NotEmpty(t, handlers)
 	})
 
 	t.Run("with options", func(t *testing.T) {
@@ -45,8 +48,38 @@ func TestGetRESTHandlers(t *testing.T) {
 
 		handlers, err := wallet.GetRESTHandlers(ctx, wallet.WithWalletAppURL("demoapp"),
 			wallet.WithWebhookURLs("demoURL"), wallet.WithNotifier(nil),
-			wallet.WithMessageHandler(nil), wallet.WithDefaultLabel("test"))
+			wallet.WithMessageHandler(nil), wallet.WithDefaultLabel("test"), wallet.WithTLSConfig(&tls.Config{
+				MinVersion: tls.VersionTLS12,
+			}))
 		require.NoError(t, err)
-		require.Empty(t, handlers)
+		require.NotEmpty(t, handlers)
+	})
+
+	t.Run("service handlers are kept apart", func(t *testing.T) {
+		framework, err := aries.New(defaults.WithInboundHTTPAddr(":26508", "", "", ""))
+		require.NoError(t, err)
+		require.NotNil(t, framework)
+
+		defer func() { require.NoError(t, framework.Close()) }()
+
+		ctx, err := framework.Context()
+		require.NoError(t, err)
+
+		controller, err := wallet.New(ctx, wallet.WithMessageHandler(msghandler.NewRegistrar()),
+			wallet.WithStatusSweepInterval(time.Hour))
+		require.NoError(t, err)
+
+		defer controller.Close()
+
+		require.NotEmpty(t, controller.WalletHandlers())
+		require.NotEmpty(t, controller.ServiceHandlers())
+
+		for _, handler := range controller.WalletHandlers() {
+			require.NotContains(t, handler.Path(), "/message/")
+		}
+
+		for _, handler := range controller.ServiceHandlers() {
+			require.Contains(t, handler.Path(), "/message/")
+		}
 	})
 }
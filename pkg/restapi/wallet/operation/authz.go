@@ -0,0 +1,154 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/hyperledger/aries-framework-go/pkg/controller/command/vcwallet"
+	"github.com/hyperledger/aries-framework-go/pkg/crypto"
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+	"github.com/igor-pavlenko/httpsignatures-go"
+	"github.com/trustbloc/edge-core/pkg/zcapld"
+
+	"github.com/trustbloc/wallet/pkg/restapi/common"
+)
+
+const (
+	signPath = "/keys/%s/sign"
+
+	readAction  = "read"
+	writeAction = "write"
+)
+
+// authzProvider signs the requests of a wallet to its remote KMS and EDV with the capabilities handed to its user
+// when onboarded. Requests are signed with the user's authorization key, which the authorization KMS only uses when
+// presented with the user's access token and secret share.
+type authzProvider struct {
+	httpClient common.HTTPClient
+}
+
+// GetHeaderSigner returns a signer using the key of the invoker of the capabilities in the given key store.
+func (p *authzProvider) GetHeaderSigner(authzKeyStoreURL, accessToken, secretShare string) vcwallet.HTTPHeaderSigner {
+	return &headerSigner{
+		keyStoreURL: authzKeyStoreURL,
+		accessToken: accessToken,
+		secretShare: secretShare,
+		httpClient:  p.httpClient,
+	}
+}
+
+type headerSigner struct {
+	keyStoreURL string
+	accessToken string
+	secretShare string
+	httpClient  common.HTTPClient
+}
+
+// SignHeader invokes the capability with an HTTP signature from the capability's invoker.
+func (s *headerSigner) SignHeader(req *http.Request, capabilityBytes []byte) (*http.Header, error) {
+	capability, err := zcapld.ParseCapability(capabilityBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse capability: %w", err)
+	}
+
+	compressed, err := zcapld.CompressZCAP(capability)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compress capability: %w", err)
+	}
+
+	action := writeAction
+	if req.Method == http.MethodGet {
+		action = readAction
+	}
+
+	req.Header.Set(
+		zcapld.CapabilityInvocationHTTPHeader,
+		fmt.Sprintf(`zcap capability="%s",action="%s"`, compressed, action),
+	)
+
+	hs := httpsignatures.NewHTTPSignatures(&zcapld.AriesDIDKeySecrets{})
+	hs.SetSignatureHashAlgorithm(&zcapld.AriesDIDKeySignatureHashAlgorithm{
+		KMS:    &remoteKMS{},
+		Crypto: &remoteCrypto{signer: s},
+	})
+
+	err = hs.Sign(capability.Invoker, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign http request: %w", err)
+	}
+
+	return &req.Header, nil
+}
+
+// sign the data with the key with the given ID in the authorization key store.
+func (s *headerSigner) sign(keyID string, data []byte) ([]byte, error) {
+	reqBytes, err := json.Marshal(signReq{Message: data})
+	if err != nil {
+		return nil, fmt.Errorf("marshal sign req: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(context.TODO(),
+		http.MethodPost, s.keyStoreURL+fmt.Sprintf(signPath, keyID), bytes.NewBuffer(reqBytes))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.accessToken))
+	req.Header.Set("Secret-Share", s.secretShare)
+
+	respBody, _, err := common.SendHTTPRequest(req, s.httpClient, http.StatusOK, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign from kms: %w", err)
+	}
+
+	var resp signResp
+
+	err = json.Unmarshal(respBody, &resp)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal sign resp: %w", err)
+	}
+
+	return resp.Signature, nil
+}
+
+type signReq struct {
+	Message []byte `json:"message"`
+}
+
+type signResp struct {
+	Signature []byte `json:"signature"`
+}
+
+// remoteKMS returns key IDs as key handles: the keys never leave the remote KMS. Only Get is used to sign requests.
+type remoteKMS struct {
+	kms.KeyManager
+}
+
+func (r *remoteKMS) Get(keyID string) (interface{}, error) {
+	return keyID, nil
+}
+
+// remoteCrypto signs with the key handles of remoteKMS. Only Sign is used to sign requests.
+type remoteCrypto struct {
+	crypto.Crypto
+	signer *headerSigner
+}
+
+func (r *remoteCrypto) Sign(msg []byte, kh interface{}) ([]byte, error) {
+	keyID, ok := kh.(string)
+	if !ok {
+		return nil, errors.New("remoteCrypto: invalid key handle")
+	}
+
+	return r.signer.sign(keyID, msg)
+}
@@ -0,0 +1,143 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation // nolint:testpackage // changing to different package requires exposing internal features.
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+	"github.com/hyperledger/aries-framework-go/pkg/kms/localkms"
+	"github.com/hyperledger/aries-framework-go/pkg/vdr/fingerprint"
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-core/pkg/zcapld"
+)
+
+const (
+	sampleAccessToken = "access-token"
+	sampleSecretShare = "c2VjcmV0LXNoYXJl"
+)
+
+func TestHeaderSigner_SignHeader(t *testing.T) {
+	t.Run("signs with the invoker's key in the authorization key store", func(t *testing.T) {
+		authzKMS := newMockAuthzKMS(t)
+		signer := (&authzProvider{httpClient: &http.Client{}}).GetHeaderSigner(authzKMS.url, sampleAccessToken,
+			sampleSecretShare)
+
+		req := httptest.NewRequest(http.MethodPost, "https://edv.example.com/encrypted-data-vaults/vault", nil)
+
+		header, err := signer.SignHeader(req, authzKMS.capability(t))
+		require.NoError(t, err)
+		require.Contains(t, header.Get(zcapld.CapabilityInvocationHTTPHeader), `action="write"`)
+		require.NotEmpty(t, header.Get("Signature"))
+		require.Equal(t, []string{authzKMS.keyID}, authzKMS.signed)
+
+		req = httptest.NewRequest(http.MethodGet, "https://edv.example.com/encrypted-data-vaults/vault", nil)
+
+		header, err = signer.SignHeader(req, authzKMS.capability(t))
+		require.NoError(t, err)
+		require.Contains(t, header.Get(zcapld.CapabilityInvocationHTTPHeader), `action="read"`)
+	})
+
+	t.Run("error if the capability is invalid", func(t *testing.T) {
+		authzKMS := newMockAuthzKMS(t)
+		signer := (&authzProvider{httpClient: &http.Client{}}).GetHeaderSigner(authzKMS.url, sampleAccessToken,
+			sampleSecretShare)
+
+		_, err := signer.SignHeader(httptest.NewRequest(http.MethodGet, "/", nil), []byte("invalid"))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to parse capability")
+	})
+
+	t.Run("error if the KMS does not authorize the secret share", func(t *testing.T) {
+		authzKMS := newMockAuthzKMS(t)
+		signer := (&authzProvider{httpClient: &http.Client{}}).GetHeaderSigner(authzKMS.url, sampleAccessToken,
+			"invalid")
+
+		_, err := signer.SignHeader(httptest.NewRequest(http.MethodGet, "/", nil), authzKMS.capability(t))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to sign from kms")
+	})
+}
+
+type mockAuthzKMS struct {
+	url        string
+	keyID      string
+	controller string
+	privateKey ed25519.PrivateKey
+	signed     []string
+}
+
+func newMockAuthzKMS(t *testing.T) *mockAuthzKMS {
+	t.Helper()
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	keyID, err := localkms.CreateKID(pub, kms.ED25519Type)
+	require.NoError(t, err)
+
+	_, controller := fingerprint.CreateDIDKey(pub)
+
+	m := &mockAuthzKMS{keyID: keyID, controller: controller, privateKey: priv}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/keys/{kid}/sign", m.sign).Methods(http.MethodPost)
+
+	srv := httptest.NewServer(router)
+	t.Cleanup(srv.Close)
+
+	m.url = srv.URL
+
+	return m
+}
+
+func (m *mockAuthzKMS) capability(t *testing.T) []byte {
+	t.Helper()
+
+	bits, err := json.Marshal(&zcapld.Capability{
+		Context: zcapld.SecurityContextV2,
+		ID:      uuid.New().URN(),
+		Invoker: m.controller,
+	})
+	require.NoError(t, err)
+
+	return bits
+}
+
+func (m *mockAuthzKMS) sign(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer "+sampleAccessToken ||
+		r.Header.Get("Secret-Share") != sampleSecretShare {
+		w.WriteHeader(http.StatusUnauthorized)
+
+		return
+	}
+
+	kid := mux.Vars(r)["kid"]
+	m.signed = append(m.signed, kid)
+
+	if kid != m.keyID {
+		w.WriteHeader(http.StatusNotFound)
+
+		return
+	}
+
+	req := &signReq{}
+
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(&signResp{Signature: ed25519.Sign(m.privateKey, req.Message)}) // nolint:errcheck
+}
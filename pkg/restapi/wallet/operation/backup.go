@@ -0,0 +1,444 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/aries-framework-go/pkg/controller/command/vcwallet"
+	webcrypto "github.com/hyperledger/aries-framework-go/pkg/crypto/webkms"
+	"github.com/hyperledger/aries-framework-go/pkg/kms/webkms"
+	"github.com/hyperledger/aries-framework-go/pkg/wallet"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+
+	"github.com/trustbloc/wallet/pkg/restapi/common"
+	"github.com/trustbloc/wallet/pkg/restapi/wallet/backup"
+)
+
+// modes of import of backups.
+const (
+	importMerge     = "merge"
+	importOverwrite = "overwrite"
+)
+
+const (
+	// keyStoreURLStoreName is the name of the store recording the URLs of the ops keystores of the wallet profiles of
+	// users, as they were created.
+	keyStoreURLStoreName = "wallet_key_store_urls"
+
+	keyStoreKeysPath = "/keys/"
+)
+
+var errInvalidBackupKey = errors.New("invalid backup key")
+
+// backupContentTypes are the types of the contents of wallets backed up, in the order they are imported so that
+// collections exist before the contents in them. Keys are not backed up: they never leave the keystore of the wallet.
+// Neither are the keys of the agent KMS that the server keeps for users, nor the metadata with the reserved IDs in
+// which they were once recorded: such metadata is listed as excluded in the manifest of the backup.
+// nolint:gochecknoglobals
+var backupContentTypes = []wallet.ContentType{
+	wallet.Collection, wallet.Credential, wallet.DIDResolutionResponse, wallet.Metadata, wallet.Connection,
+}
+
+// exportBackup exports the credentials, DIDs, metadata, collections and connections of an unlocked wallet as an
+// archive sealed with a passphrase or with a key of the ops keystore of the user.
+func (o *Operation) exportBackup(w http.ResponseWriter, r *http.Request) {
+	req := &exportBackupReq{}
+
+	if !decodeWalletRequest(w, r, req, &req.walletAuth) {
+		return
+	}
+
+	if (req.Passphrase == "") == (req.KeyURL == "") {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "either a passphrase or a key URL is required")
+
+		return
+	}
+
+	vcWallet, ok := o.openUnlockedWallet(w, req.UserID, req.Auth)
+	if !ok {
+		return
+	}
+
+	if req.KeyURL != "" {
+		if err := o.checkBackupKey(req.UserID, req.KeyURL); err != nil {
+			writeWalletError(w, err, "failed to seal backup")
+
+			return
+		}
+	}
+
+	b, err := walletBackup(vcWallet, req.Auth)
+	if err != nil {
+		writeWalletError(w, err, "failed to export wallet contents")
+
+		return
+	}
+
+	if len(b.Manifest.Excluded) > 0 {
+		logger.Warnf("metadata %s of user %s excluded from backup", strings.Join(b.Manifest.Excluded, ", "),
+			req.UserID)
+	}
+
+	sealer := backup.WithPassphrase(req.Passphrase)
+	if req.KeyURL != "" {
+		sealer = backup.WithKey(o.keyCrypto(req.KMSAuth), req.KeyURL)
+	}
+
+	archive, err := backup.Seal(b, sealer)
+	if err != nil {
+		writeBackupError(w, err, "failed to seal backup")
+
+		return
+	}
+
+	common.WriteResponse(w, logger, archive)
+}
+
+// importBackup imports the contents of an archive into an unlocked wallet. Contents are matched by ID with the
+// contents of the wallet, which are kept in merge mode and replaced in overwrite mode.
+func (o *Operation) importBackup(w http.ResponseWriter, r *http.Request) {
+	req := &importBackupReq{}
+
+	if !decodeWalletRequest(w, r, req, &req.walletAuth) {
+		return
+	}
+
+	if req.Archive == nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing archive")
+
+		return
+	}
+
+	if req.Passphrase == "" && req.KMSAuth == nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "either a passphrase or a KMS auth is required")
+
+		return
+	}
+
+	switch req.Mode {
+	case "":
+		req.Mode = importMerge
+	case importMerge, importOverwrite:
+	default:
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "unsupported import mode [%s]", req.Mode)
+
+		return
+	}
+
+	vcWallet, ok := o.openUnlockedWallet(w, req.UserID, req.Auth)
+	if !ok {
+		return
+	}
+
+	if req.Archive.Sealing != nil && req.Archive.Sealing.Method == backup.SealedByKey {
+		if err := o.checkBackupKey(req.UserID, req.Archive.Sealing.KeyURL); err != nil {
+			writeWalletError(w, err, "failed to open backup")
+
+			return
+		}
+	}
+
+	sealer := backup.WithPassphrase(req.Passphrase)
+	if req.Passphrase == "" {
+		sealer = backup.WithKey(o.keyCrypto(req.KMSAuth), "")
+	}
+
+	b, err := backup.Open(req.Archive, sealer)
+	if err != nil {
+		writeBackupError(w, err, "failed to open backup")
+
+		return
+	}
+
+	for _, entry := range b.Manifest.Entries {
+		if !isBackupContentType(wallet.ContentType(entry.ContentType)) {
+			common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "unsupported content type [%s] in backup",
+				entry.ContentType)
+
+			return
+		}
+	}
+
+	resp, err := o.importContents(vcWallet, req.UserID, req.Auth, b, req.Mode == importOverwrite)
+	if err != nil {
+		writeWalletError(w, err, "failed to import wallet contents")
+
+		return
+	}
+
+	common.WriteResponse(w, logger, resp)
+}
+
+// importContents adds the contents of a backup to a wallet, skipping or replacing the contents already in the wallet.
+// The wallet does not overwrite contents, so a content replaced is removed first, and restored if the content of the
+// backup cannot be added.
+func (o *Operation) importContents(vcWallet *wallet.Wallet, userID, auth string, b *backup.Backup,
+	overwrite bool) (*importBackupResp, error) {
+	// backups are rejected before any content is added.
+	for i, entry := range b.Manifest.Entries {
+		err := checkReservedMetadata(wallet.ContentType(entry.ContentType), b.Contents[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	resp := &importBackupResp{
+		Added: []string{}, Replaced: []string{}, Skipped: []string{}, Excluded: b.Manifest.Excluded,
+	}
+
+	// collections of the contents of the wallet, by content type, looked up once contents of the type are replaced.
+	collectionIDs := map[wallet.ContentType]map[string]string{}
+
+	for i, entry := range b.Manifest.Entries {
+		contentType := wallet.ContentType(entry.ContentType)
+
+		existing, err := vcWallet.Get(auth, contentType, entry.ContentID)
+
+		switch {
+		case err == nil && !overwrite:
+			resp.Skipped = append(resp.Skipped, entry.ContentID)
+
+			continue
+		case err == nil:
+			if _, ok := collectionIDs[contentType]; !ok {
+				collectionIDs[contentType], err = walletCollectionIDs(vcWallet, auth, contentType)
+				if err != nil {
+					return nil, fmt.Errorf("failed to get collections of %s contents: %w", contentType, err)
+				}
+			}
+
+			err = vcWallet.Remove(auth, contentType, entry.ContentID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to replace %s %s: %w", contentType, entry.ContentID, err)
+			}
+		case errors.Is(err, storage.ErrDataNotFound):
+			existing = nil
+		default:
+			return nil, fmt.Errorf("failed to get %s %s: %w", contentType, entry.ContentID, err)
+		}
+
+		err = addContent(vcWallet, auth, contentType, b.Contents[i], entry.CollectionID)
+		if err != nil && existing != nil {
+			if restoreErr := addContent(vcWallet, auth, contentType, existing,
+				collectionIDs[contentType][entry.ContentID]); restoreErr != nil {
+				logger.Errorf("failed to restore %s %s of user %s: %s", contentType, entry.ContentID, userID,
+					restoreErr.Error())
+			}
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to add %s %s: %w", contentType, entry.ContentID, err)
+		}
+
+		if existing != nil {
+			resp.Replaced = append(resp.Replaced, entry.ContentID)
+		} else {
+			resp.Added = append(resp.Added, entry.ContentID)
+		}
+
+		if contentType == wallet.Credential {
+			o.trackCredential(userID, b.Contents[i], nil)
+		}
+	}
+
+	return resp, nil
+}
+
+// checkBackupKey checks that a key URL is the URL of a key of the ops keystore of a user, the remote keystore the
+// wallet profile of the user was created with, so that requests authorized for the keystore are not sent elsewhere.
+// The wallet must have been opened with the auth token of the user.
+func (o *Operation) checkBackupKey(userID, keyURL string) error {
+	keyServerURL, err := o.walletKeyStoreURL(userID)
+	if err != nil {
+		return err
+	}
+
+	if keyServerURL == "" {
+		return fmt.Errorf("%w: the wallet has no ops keystore", errInvalidBackupKey)
+	}
+
+	keyID := strings.TrimPrefix(keyURL, strings.TrimSuffix(keyServerURL, "/")+keyStoreKeysPath)
+	if keyID == keyURL || keyID == "" || strings.ContainsAny(keyID, "/?#%") {
+		return fmt.Errorf("%w: %s is not a key of the ops keystore of the wallet", errInvalidBackupKey, keyURL)
+	}
+
+	return nil
+}
+
+// walletKeyStoreURL returns the URL of the ops keystore the wallet profile of a user was created with, or an empty
+// URL if the profile uses a local KMS. The URL of a profile is recorded by the server as update-profile cannot change
+// it, while the VC wallet does not expose the profiles it saves.
+func (o *Operation) walletKeyStoreURL(userID string) (string, error) {
+	raw, err := o.keyStoreURLStore.Get(userID)
+	if errors.Is(err, storage.ErrDataNotFound) {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("failed to get ops keystore of wallet: %w", err)
+	}
+
+	keyStore := &walletKeyStore{}
+
+	err = json.Unmarshal(raw, keyStore)
+	if err != nil {
+		return "", fmt.Errorf("invalid ops keystore of wallet: %w", err)
+	}
+
+	return keyStore.URL, nil
+}
+
+// keyCrypto returns the remote crypto of the ops keystore of a user, authorizing its requests as the wallet does when
+// unlocked with the same KMS auth.
+func (o *Operation) keyCrypto(auth *vcwallet.UnlockAuth) backup.KeyCrypto {
+	var opts []webkms.Opt
+
+	switch {
+	case auth == nil:
+	case auth.Capability != "":
+		signer := o.authz.GetHeaderSigner(auth.AuthZKeyStoreURL, auth.AuthToken, auth.SecretShare)
+
+		opts = append(opts, webkms.WithHeaders(func(req *http.Request) (*http.Header, error) {
+			return signer.SignHeader(req, []byte(auth.Capability))
+		}))
+	case auth.AuthToken != "":
+		opts = append(opts, webkms.WithHeaders(func(req *http.Request) (*http.Header, error) {
+			req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", auth.AuthToken))
+
+			return &req.Header, nil
+		}))
+	}
+
+	// the URL of the keystore is only used to wrap keys: keys are otherwise addressed by their URLs.
+	return webcrypto.New("", o.httpClient, opts...)
+}
+
+// walletBackup returns a backup of the contents of a wallet, sorted by type and ID.
+func walletBackup(vcWallet *wallet.Wallet, auth string) (*backup.Backup, error) {
+	collections, err := vcWallet.GetAll(auth, wallet.Collection)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		entries  []*backup.Entry
+		contents []json.RawMessage
+		excluded []string
+	)
+
+	for _, contentType := range backupContentTypes {
+		all, err := vcWallet.GetAll(auth, contentType)
+		if err != nil {
+			return nil, err
+		}
+
+		collectionIDs, err := collectionIDsOf(vcWallet, auth, contentType, collections)
+		if err != nil {
+			return nil, err
+		}
+
+		ids := make([]string, 0, len(all))
+
+		for id := range all {
+			ids = append(ids, id)
+		}
+
+		sort.Strings(ids)
+
+		for _, id := range ids {
+			if checkReservedMetadata(contentType, all[id]) != nil {
+				excluded = append(excluded, id)
+
+				continue
+			}
+
+			entries = append(entries, &backup.Entry{
+				ContentType:  string(contentType),
+				ContentID:    id,
+				CollectionID: collectionIDs[id],
+			})
+			contents = append(contents, all[id])
+		}
+	}
+
+	b := backup.New(entries, contents)
+	b.Manifest.Excluded = excluded
+
+	return b, nil
+}
+
+// walletCollectionIDs returns the IDs of the collections of the contents of a type of a wallet, by content ID.
+func walletCollectionIDs(vcWallet *wallet.Wallet, auth string, contentType wallet.ContentType) (map[string]string,
+	error) {
+	collections, err := vcWallet.GetAll(auth, wallet.Collection)
+	if err != nil {
+		return nil, err
+	}
+
+	return collectionIDsOf(vcWallet, auth, contentType, collections)
+}
+
+func collectionIDsOf(vcWallet *wallet.Wallet, auth string, contentType wallet.ContentType,
+	collections map[string]json.RawMessage) (map[string]string, error) {
+	collectionIDs := map[string]string{}
+
+	if contentType == wallet.Collection {
+		return collectionIDs, nil
+	}
+
+	for collectionID := range collections {
+		inCollection, err := vcWallet.GetAll(auth, contentType, wallet.FilterByCollection(collectionID))
+		if err != nil {
+			return nil, err
+		}
+
+		for id := range inCollection {
+			collectionIDs[id] = collectionID
+		}
+	}
+
+	return collectionIDs, nil
+}
+
+func addContent(vcWallet *wallet.Wallet, auth string, contentType wallet.ContentType, content json.RawMessage,
+	collectionID string) error {
+	var opts []wallet.AddContentOptions
+
+	if collectionID != "" {
+		opts = append(opts, wallet.AddByCollection(collectionID))
+	}
+
+	return vcWallet.Add(auth, contentType, content, opts...)
+}
+
+func isBackupContentType(contentType wallet.ContentType) bool {
+	for _, t := range backupContentTypes {
+		if t == contentType {
+			return true
+		}
+	}
+
+	return false
+}
+
+func writeBackupError(w http.ResponseWriter, err error, msg string) {
+	status := http.StatusInternalServerError
+
+	switch {
+	case errors.Is(err, backup.ErrKeystore):
+		status = http.StatusBadGateway
+	case errors.Is(err, backup.ErrSealing), errors.Is(err, backup.ErrIntegrity),
+		errors.Is(err, backup.ErrUnsupportedVersion):
+		status = http.StatusBadRequest
+	}
+
+	common.WriteErrorResponsef(w, logger, status, "%s: %s", msg, err.Error())
+}
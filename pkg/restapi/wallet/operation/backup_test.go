@@ -0,0 +1,452 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation // nolint:testpackage // changing to different package requires exposing internal features.
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/hyperledger/aries-framework-go/pkg/controller/command/vcwallet"
+	"github.com/hyperledger/aries-framework-go/pkg/wallet"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/wallet/pkg/restapi/wallet/backup"
+)
+
+const sampleKMSToken = "kms-token"
+
+func TestOperation_Backup(t *testing.T) {
+	t.Run("export and import a wallet sealed with a passphrase", func(t *testing.T) {
+		router := newRouter(t, newProvider(t))
+		auth := unlock(t, router)
+		ids := addBackupContents(t, router, auth)
+
+		archive := exportBackup(t, router, &exportBackupReq{walletAuth: auth, Passphrase: samplePassphrase})
+		require.Equal(t, backup.SealedByPassphrase, archive.Sealing.Method)
+
+		other := unlock(t, router)
+
+		resp := importBackup(t, router, &importBackupReq{
+			walletAuth: other, Archive: archive, Passphrase: samplePassphrase,
+		})
+		require.ElementsMatch(t, ids, resp.Added)
+		require.Empty(t, resp.Replaced)
+		require.Empty(t, resp.Skipped)
+
+		rr := serve(router, http.MethodPost, "/collections/"+ids[0]+getAllPath, &getAllContentReq{
+			contentAuth: contentAuth{walletAuth: other, ContentType: wallet.Credential},
+		})
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		all := &getAllContentResp{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), all))
+		require.Len(t, all.Contents, 1)
+		require.Contains(t, all.Contents, ids[1])
+
+		resp = importBackup(t, router, &importBackupReq{
+			walletAuth: other, Archive: archive, Passphrase: samplePassphrase, Mode: importMerge,
+		})
+		require.Empty(t, resp.Added)
+		require.Empty(t, resp.Replaced)
+		require.ElementsMatch(t, ids, resp.Skipped)
+
+		resp = importBackup(t, router, &importBackupReq{
+			walletAuth: other, Archive: archive, Passphrase: samplePassphrase, Mode: importOverwrite,
+		})
+		require.Empty(t, resp.Added)
+		require.ElementsMatch(t, ids, resp.Replaced)
+		require.Empty(t, resp.Skipped)
+
+		rr = serve(router, http.MethodPost, importBackupPath, &importBackupReq{
+			walletAuth: other, Archive: archive, Passphrase: "wrong",
+		})
+		require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+		requireErrorResponse(t, rr.Body.Bytes(), "failed to open backup")
+	})
+
+	t.Run("export and import a wallet sealed with a key of the ops keystore", func(t *testing.T) {
+		keystore := newKeystore(t)
+		defer keystore.Close()
+
+		router := newRouter(t, newProvider(t))
+		keyStoreURL := keystore.URL + "/v1/keystores/123"
+		auth := unlockWithKeyStore(t, router, keyStoreURL)
+		ids := addBackupContents(t, router, auth)
+		kmsAuth := &vcwallet.UnlockAuth{AuthToken: sampleKMSToken}
+
+		archive := exportBackup(t, router, &exportBackupReq{
+			walletAuth: auth, KeyURL: keyStoreURL + "/keys/456", KMSAuth: kmsAuth,
+		})
+		require.Equal(t, backup.SealedByKey, archive.Sealing.Method)
+
+		other := unlockWithKeyStore(t, router, keyStoreURL)
+
+		resp := importBackup(t, router, &importBackupReq{walletAuth: other, Archive: archive, KMSAuth: kmsAuth})
+		require.ElementsMatch(t, ids, resp.Added)
+
+		rr := serve(router, http.MethodPost, importBackupPath, &importBackupReq{
+			walletAuth: other, Archive: archive, KMSAuth: &vcwallet.UnlockAuth{AuthToken: "invalid"},
+		})
+		require.Equal(t, http.StatusBadGateway, rr.Code, rr.Body.String())
+
+		rr = serve(router, http.MethodPost, exportBackupPath, &exportBackupReq{
+			walletAuth: auth, KeyURL: keyStoreURL + "/keys/456",
+		})
+		require.Equal(t, http.StatusBadGateway, rr.Code, rr.Body.String())
+	})
+
+	t.Run("only seal and open archives with keys of the ops keystore of the user", func(t *testing.T) {
+		keystore := newKeystore(t)
+		defer keystore.Close()
+
+		router := newRouter(t, newProvider(t))
+		keyStoreURL := keystore.URL + "/v1/keystores/123"
+		auth := unlockWithKeyStore(t, router, keyStoreURL)
+		kmsAuth := &vcwallet.UnlockAuth{AuthToken: sampleKMSToken}
+
+		for _, keyURL := range []string{
+			keystore.URL + "/v1/keystores/789/keys/456",
+			keyStoreURL + "/keys/",
+			keyStoreURL + "/keys/456/export",
+			keyStoreURL + "/keys/456?keyID=789",
+			keyStoreURL + "/keys/..%2F..%2F789%2Fkeys%2F456",
+			"https://attacker.example.com/v1/keystores/123/keys/456",
+		} {
+			rr := serve(router, http.MethodPost, exportBackupPath, &exportBackupReq{
+				walletAuth: auth, KeyURL: keyURL, KMSAuth: kmsAuth,
+			})
+			require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+			requireErrorResponse(t, rr.Body.Bytes(), "is not a key of the ops keystore of the wallet")
+		}
+
+		archive := exportBackup(t, router, &exportBackupReq{
+			walletAuth: auth, KeyURL: keyStoreURL + "/keys/456", KMSAuth: kmsAuth,
+		})
+
+		other := unlockWithKeyStore(t, router, keystore.URL+"/v1/keystores/789")
+
+		rr := serve(router, http.MethodPost, importBackupPath, &importBackupReq{
+			walletAuth: other, Archive: archive, KMSAuth: kmsAuth,
+		})
+		require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+		requireErrorResponse(t, rr.Body.Bytes(), "is not a key of the ops keystore of the wallet")
+
+		local := unlock(t, router)
+
+		rr = serve(router, http.MethodPost, importBackupPath, &importBackupReq{
+			walletAuth: local, Archive: archive, KMSAuth: kmsAuth,
+		})
+		require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+		requireErrorResponse(t, rr.Body.Bytes(), "the wallet has no ops keystore")
+
+		rr = serve(router, http.MethodPost, exportBackupPath, &exportBackupReq{
+			walletAuth: local, KeyURL: keyStoreURL + "/keys/456", KMSAuth: kmsAuth,
+		})
+		require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+		requireErrorResponse(t, rr.Body.Bytes(), "the wallet has no ops keystore")
+	})
+
+	t.Run("seal archives with keys of the ops keystore the wallet was created with", func(t *testing.T) {
+		keystore := newKeystore(t)
+		defer keystore.Close()
+
+		router := newRouter(t, newProvider(t))
+		keyStoreURL := keystore.URL + "/v1/keystores/123"
+		auth := unlockWithKeyStore(t, router, keyStoreURL)
+
+		rr := serve(router, http.MethodPost, updateProfilePath, &vcwallet.CreateOrUpdateProfileRequest{
+			UserID:      auth.UserID,
+			KeyStoreURL: "https://attacker.example.com/v1/keystores/123",
+		})
+		require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+		requireErrorResponse(t, rr.Body.Bytes(), "the ops keystore of a wallet cannot be changed")
+
+		rr = serve(router, http.MethodPost, updateProfilePath, &vcwallet.CreateOrUpdateProfileRequest{
+			UserID:      auth.UserID,
+			KeyStoreURL: keyStoreURL,
+		})
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		rr = serve(router, http.MethodPost, exportBackupPath, &exportBackupReq{
+			walletAuth: auth, KeyURL: "https://attacker.example.com/v1/keystores/123/keys/456",
+			KMSAuth: &vcwallet.UnlockAuth{AuthToken: sampleKMSToken},
+		})
+		require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+		requireErrorResponse(t, rr.Body.Bytes(), "is not a key of the ops keystore of the wallet")
+	})
+
+	t.Run("exclude metadata with reserved IDs", func(t *testing.T) {
+		provider := newProvider(t)
+		router := newRouter(t, provider)
+		auth := unlock(t, router)
+		ids := addBackupContents(t, router, auth)
+
+		vcWallet, err := wallet.New(auth.UserID, provider)
+		require.NoError(t, err)
+
+		reserved := []string{selfIssuedKeyMetadataID, didKeysMetadataPrefix + "did:orb:123"}
+
+		for _, id := range reserved {
+			require.NoError(t, vcWallet.Add(auth.Auth, wallet.Metadata,
+				json.RawMessage(fmt.Sprintf(`{"id":%q,"keyID":"key"}`, id))))
+		}
+
+		archive := exportBackup(t, router, &exportBackupReq{walletAuth: auth, Passphrase: samplePassphrase})
+
+		resp := importBackup(t, router, &importBackupReq{
+			walletAuth: unlock(t, router), Archive: archive, Passphrase: samplePassphrase,
+		})
+		require.ElementsMatch(t, ids, resp.Added)
+		require.ElementsMatch(t, reserved, resp.Excluded)
+	})
+
+	t.Run("restore contents that cannot be overwritten", func(t *testing.T) {
+		router := newRouter(t, newProvider(t))
+		auth := unlock(t, router)
+		ids := addBackupContents(t, router, auth)
+
+		rr := serve(router, http.MethodPost, getPath, &getContentReq{
+			contentAuth: contentAuth{walletAuth: auth, ContentType: wallet.Credential},
+			ContentID:   ids[1],
+		})
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		existing := &getContentResp{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), existing))
+
+		// the credential of the archive is in a collection the wallet does not have.
+		archive, err := backup.Seal(backup.New([]*backup.Entry{{
+			ContentType: string(wallet.Credential), ContentID: ids[1], CollectionID: uuid.New().URN(),
+		}}, []json.RawMessage{json.RawMessage(fmt.Sprintf(sampleCredential, ids[1]))}),
+			backup.WithPassphrase(samplePassphrase))
+		require.NoError(t, err)
+
+		rr = serve(router, http.MethodPost, importBackupPath, &importBackupReq{
+			walletAuth: auth, Archive: archive, Passphrase: samplePassphrase, Mode: importOverwrite,
+		})
+		require.Equal(t, http.StatusNotFound, rr.Code, rr.Body.String())
+		requireErrorResponse(t, rr.Body.Bytes(), "failed to add credential "+ids[1])
+
+		rr = serve(router, http.MethodPost, "/collections/"+ids[0]+getAllPath, &getAllContentReq{
+			contentAuth: contentAuth{walletAuth: auth, ContentType: wallet.Credential},
+		})
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		all := &getAllContentResp{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), all))
+		require.Len(t, all.Contents, 1)
+		require.JSONEq(t, string(existing.Content), string(all.Contents[ids[1]]))
+	})
+
+	t.Run("invalid requests", func(t *testing.T) {
+		router := newRouter(t, newProvider(t))
+		auth := unlock(t, router)
+
+		for _, path := range []string{exportBackupPath, importBackupPath} {
+			rr := serve(router, http.MethodPost, path, "invalid")
+			require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+
+			rr = serve(router, http.MethodPost, path, &walletAuth{UserID: auth.UserID})
+			require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+			requireErrorResponse(t, rr.Body.Bytes(), "missing user ID or auth token")
+		}
+
+		rr := serve(router, http.MethodPost, exportBackupPath, &exportBackupReq{walletAuth: auth})
+		require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+		requireErrorResponse(t, rr.Body.Bytes(), "either a passphrase or a key URL is required")
+
+		rr = serve(router, http.MethodPost, exportBackupPath, &exportBackupReq{
+			walletAuth: auth, Passphrase: samplePassphrase, KeyURL: "https://kms.example.com/keys/1",
+		})
+		require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+
+		rr = serve(router, http.MethodPost, exportBackupPath, &exportBackupReq{
+			walletAuth: walletAuth{UserID: auth.UserID, Auth: "invalid"}, Passphrase: samplePassphrase,
+		})
+		require.Equal(t, http.StatusUnauthorized, rr.Code, rr.Body.String())
+
+		archive := exportBackup(t, router, &exportBackupReq{walletAuth: auth, Passphrase: samplePassphrase})
+
+		rr = serve(router, http.MethodPost, importBackupPath, &importBackupReq{
+			walletAuth: auth, Passphrase: samplePassphrase,
+		})
+		require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+		requireErrorResponse(t, rr.Body.Bytes(), "missing archive")
+
+		rr = serve(router, http.MethodPost, importBackupPath, &importBackupReq{walletAuth: auth, Archive: archive})
+		require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+		requireErrorResponse(t, rr.Body.Bytes(), "either a passphrase or a KMS auth is required")
+
+		rr = serve(router, http.MethodPost, importBackupPath, &importBackupReq{
+			walletAuth: auth, Archive: archive, Passphrase: samplePassphrase, Mode: "replace",
+		})
+		require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+		requireErrorResponse(t, rr.Body.Bytes(), "unsupported import mode")
+
+		archive, err := backup.Seal(backup.New([]*backup.Entry{{ContentType: string(wallet.Key), ContentID: "key"}},
+			[]json.RawMessage{json.RawMessage(`{}`)}), backup.WithPassphrase(samplePassphrase))
+		require.NoError(t, err)
+
+		rr = serve(router, http.MethodPost, importBackupPath, &importBackupReq{
+			walletAuth: auth, Archive: archive, Passphrase: samplePassphrase,
+		})
+		require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+		requireErrorResponse(t, rr.Body.Bytes(), "unsupported content type [key] in backup")
+
+		archive, err = backup.Seal(backup.New([]*backup.Entry{
+			{ContentType: string(wallet.Metadata), ContentID: "metadata"},
+		}, []json.RawMessage{json.RawMessage(fmt.Sprintf(`{"id":%q}`, selfIssuedKeyMetadataID))}),
+			backup.WithPassphrase(samplePassphrase))
+		require.NoError(t, err)
+
+		rr = serve(router, http.MethodPost, importBackupPath, &importBackupReq{
+			walletAuth: auth, Archive: archive, Passphrase: samplePassphrase,
+		})
+		require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+		requireErrorResponse(t, rr.Body.Bytes(), "reserved metadata ID")
+	})
+}
+
+// addBackupContents adds a collection, a credential in the collection, a DID, metadata and a connection to a wallet,
+// and returns their IDs.
+func addBackupContents(t *testing.T, router *mux.Router, auth walletAuth) []string {
+	t.Helper()
+
+	ids := []string{
+		uuid.New().URN(), uuid.New().URN(), "did:example:" + uuid.New().String(), uuid.New().URN(), uuid.New().URN(),
+	}
+
+	for i, c := range []struct {
+		contentType wallet.ContentType
+		template    string
+		path        string
+	}{
+		{wallet.Collection, sampleCollection, addPath},
+		{wallet.Credential, sampleCredential, "/collections/" + ids[0] + addPath},
+		{wallet.DIDResolutionResponse, sampleDIDResolution, addPath},
+		{wallet.Metadata, sampleMetadata, addPath},
+		{wallet.Connection, sampleConnection, addPath},
+	} {
+		rr := serve(router, http.MethodPost, c.path, &addContentReq{
+			contentAuth: contentAuth{walletAuth: auth, ContentType: c.contentType},
+			Content:     json.RawMessage(fmt.Sprintf(c.template, ids[i])),
+		})
+		require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	}
+
+	return ids
+}
+
+// unlockWithKeyStore creates the wallet profile of a new user with a remote keystore, unlocks the wallet and returns
+// its auth.
+func unlockWithKeyStore(t *testing.T, router *mux.Router, keyStoreURL string) walletAuth {
+	t.Helper()
+
+	userID := uuid.New().String()
+
+	rr := serve(router, http.MethodPost, createProfilePath, &vcwallet.CreateOrUpdateProfileRequest{
+		UserID:      userID,
+		KeyStoreURL: keyStoreURL,
+	})
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	rr = serve(router, http.MethodPost, openPath, &vcwallet.UnlockWalletRequest{
+		UserID:     userID,
+		WebKMSAuth: &vcwallet.UnlockAuth{AuthToken: sampleKMSToken},
+	})
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	unlocked := &vcwallet.UnlockWalletResponse{}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), unlocked))
+
+	return walletAuth{UserID: userID, Auth: unlocked.Token}
+}
+
+func exportBackup(t *testing.T, router *mux.Router, req *exportBackupReq) *backup.Archive {
+	t.Helper()
+
+	rr := serve(router, http.MethodPost, exportBackupPath, req)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	archive := &backup.Archive{}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), archive))
+
+	return archive
+}
+
+func importBackup(t *testing.T, router *mux.Router, req *importBackupReq) *importBackupResp {
+	t.Helper()
+
+	rr := serve(router, http.MethodPost, importBackupPath, req)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	resp := &importBackupResp{}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), resp))
+
+	return resp
+}
+
+// newKeystore returns a keystore server encrypting and decrypting with a single AES-GCM key the requests authorized
+// with the sample KMS token.
+func newKeystore(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+
+	block, err := aes.NewCipher(key)
+	require.NoError(t, err)
+
+	aead, err := cipher.NewGCM(block)
+	require.NoError(t, err)
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+sampleKMSToken {
+			w.WriteHeader(http.StatusForbidden)
+
+			return
+		}
+
+		req := &struct {
+			Message    []byte `json:"message"`
+			Ciphertext []byte `json:"ciphertext"`
+			Nonce      []byte `json:"nonce"`
+		}{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(req))
+
+		var resp interface{}
+
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/encrypt"):
+			nonce := make([]byte, aead.NonceSize())
+			_, err := rand.Read(nonce)
+			require.NoError(t, err)
+
+			resp = map[string][]byte{"ciphertext": aead.Seal(nil, nonce, req.Message, nil), "nonce": nonce}
+		case strings.HasSuffix(r.URL.Path, "/decrypt"):
+			plaintext, err := aead.Open(nil, req.Nonce, req.Ciphertext, nil)
+			require.NoError(t, err)
+
+			resp = map[string][]byte{"plaintext": plaintext}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		require.NoError(t, json.NewEncoder(w).Encode(resp))
+	}))
+}
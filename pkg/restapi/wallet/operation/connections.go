@@ -0,0 +1,425 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/hyperledger/aries-framework-go/pkg/client/didexchange"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/common/service"
+	didexchangesvc "github.com/hyperledger/aries-framework-go/pkg/didcomm/protocol/didexchange"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+
+	"github.com/trustbloc/wallet/pkg/restapi/common"
+)
+
+const (
+	// connectionStoreName is the name of the store recording the users owning the DIDComm connections of the agent.
+	connectionStoreName = "wallet_connections"
+	connectionUserTag   = "user"
+
+	// connectionTopic is the notifier topic of the state changes of the DIDComm connections of users.
+	connectionTopic = "connections"
+)
+
+// acceptInvitation accepts a DIDComm V1 or V2 out-of-band invitation for a user. Returns the connection, whose state
+// changes are then published on the notifier until the DID exchange completes.
+func (o *Operation) acceptInvitation(w http.ResponseWriter, r *http.Request) {
+	req := &acceptInvitationReq{}
+
+	if !decodeWalletRequest(w, r, req, &req.walletAuth) {
+		return
+	}
+
+	if req.Invitation == nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing invitation")
+
+		return
+	}
+
+	_, ok := o.openUnlockedWallet(w, req.UserID, req.Auth)
+	if !ok {
+		return
+	}
+
+	connID, err := o.acceptOOBInvitation(req.Invitation, req.Label)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "failed to accept invitation: %s", err.Error())
+
+		return
+	}
+
+	record := &connectionRecord{UserID: req.UserID, ConnectionID: connID}
+
+	err = o.saveConnection(record)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "failed to save connection: %s",
+			err.Error())
+
+		return
+	}
+
+	conn, err := o.connection(record)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "failed to get connection: %s",
+			err.Error())
+
+		return
+	}
+
+	o.notifyConnection(record.UserID, conn)
+
+	common.WriteResponse(w, logger, conn)
+}
+
+// listConnections returns the DIDComm connections of a user, sorted by label.
+func (o *Operation) listConnections(w http.ResponseWriter, r *http.Request) {
+	req := &connectionReq{}
+
+	if !decodeWalletRequest(w, r, req, &req.walletAuth) {
+		return
+	}
+
+	_, ok := o.openUnlockedWallet(w, req.UserID, req.Auth)
+	if !ok {
+		return
+	}
+
+	records, err := o.connectionRecords(connectionUserTag + ":" + userTag(req.UserID))
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "failed to list connections: %s",
+			err.Error())
+
+		return
+	}
+
+	resp := &listConnectionsResp{Connections: []*connection{}}
+
+	for _, record := range records {
+		conn, err := o.connection(record)
+		if errors.Is(err, didexchange.ErrConnectionNotFound) {
+			logger.Warnf("connection %s of a user no longer exists", record.ConnectionID)
+
+			continue
+		} else if err != nil {
+			common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "failed to get connection: %s",
+				err.Error())
+
+			return
+		}
+
+		resp.Connections = append(resp.Connections, conn)
+	}
+
+	sort.Slice(resp.Connections, func(i, j int) bool {
+		if resp.Connections[i].Label != resp.Connections[j].Label {
+			return resp.Connections[i].Label < resp.Connections[j].Label
+		}
+
+		return resp.Connections[i].ConnectionID < resp.Connections[j].ConnectionID
+	})
+
+	common.WriteResponse(w, logger, resp)
+}
+
+// getConnection returns a DIDComm connection of a user.
+func (o *Operation) getConnection(w http.ResponseWriter, r *http.Request) {
+	req := &connectionReq{}
+
+	if !decodeWalletRequest(w, r, req, &req.walletAuth) {
+		return
+	}
+
+	record, ok := o.ownedConnection(w, &req.walletAuth, req.ConnectionID)
+	if !ok {
+		return
+	}
+
+	conn, err := o.connection(record)
+	if err != nil {
+		writeConnectionError(w, err, "failed to get connection")
+
+		return
+	}
+
+	common.WriteResponse(w, logger, conn)
+}
+
+// renameConnection sets the label of a DIDComm connection of a user.
+func (o *Operation) renameConnection(w http.ResponseWriter, r *http.Request) {
+	req := &renameConnectionReq{}
+
+	if !decodeWalletRequest(w, r, req, &req.walletAuth) {
+		return
+	}
+
+	if req.Label == "" {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing label")
+
+		return
+	}
+
+	record, ok := o.ownedConnection(w, &req.walletAuth, req.ConnectionID)
+	if !ok {
+		return
+	}
+
+	record.Label = req.Label
+
+	err := o.saveConnection(record)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "failed to save connection: %s",
+			err.Error())
+
+		return
+	}
+
+	conn, err := o.connection(record)
+	if err != nil {
+		writeConnectionError(w, err, "failed to get connection")
+
+		return
+	}
+
+	common.WriteResponse(w, logger, conn)
+}
+
+// removeConnection removes a DIDComm connection of a user from the agent.
+func (o *Operation) removeConnection(w http.ResponseWriter, r *http.Request) {
+	req := &connectionReq{}
+
+	if !decodeWalletRequest(w, r, req, &req.walletAuth) {
+		return
+	}
+
+	record, ok := o.ownedConnection(w, &req.walletAuth, req.ConnectionID)
+	if !ok {
+		return
+	}
+
+	err := o.didexchangeClient.RemoveConnection(record.ConnectionID)
+	if err != nil && !errors.Is(err, storage.ErrDataNotFound) {
+		// the record of a connection is deleted before its thread mappings, which DIDComm V2 connections do not have
+		_, getErr := o.didexchangeClient.GetConnection(record.ConnectionID)
+		if !errors.Is(getErr, didexchange.ErrConnectionNotFound) {
+			common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "failed to remove connection: %s",
+				err.Error())
+
+			return
+		}
+
+		logger.Debugf("removed connection %s: %s", record.ConnectionID, err.Error())
+	}
+
+	err = o.connectionStore.Delete(record.ConnectionID)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "failed to remove connection: %s",
+			err.Error())
+
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// ownedConnection validates the wallet authorization of a request, and returns the record of a connection of the
+// user of the wallet.
+func (o *Operation) ownedConnection(w http.ResponseWriter, auth *walletAuth, connID string) (*connectionRecord, bool) {
+	if connID == "" {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing connection ID")
+
+		return nil, false
+	}
+
+	_, ok := o.openUnlockedWallet(w, auth.UserID, auth.Auth)
+	if !ok {
+		return nil, false
+	}
+
+	record, err := o.connectionRecord(connID)
+	if err == nil && record.UserID != auth.UserID {
+		err = storage.ErrDataNotFound
+	}
+
+	if err != nil {
+		writeConnectionError(w, err, "failed to get connection")
+
+		return nil, false
+	}
+
+	return record, true
+}
+
+// observeConnections publishes the state changes of the DID exchanges of the connections of users on the notifier.
+func (o *Operation) observeConnections() error {
+	states := make(chan service.StateMsg)
+
+	err := o.didexchangeClient.RegisterMsgEvent(states)
+	if err != nil {
+		return fmt.Errorf("failed to register connection state events: %w", err)
+	}
+
+	go func() {
+		for msg := range states {
+			if msg.Type != service.PostState {
+				continue
+			}
+
+			props, ok := msg.Properties.(didexchangesvc.Event)
+			if !ok {
+				continue
+			}
+
+			o.connectionChanged(props.ConnectionID())
+		}
+	}()
+
+	return nil
+}
+
+// connectionChanged publishes the current state of a connection on the notifier, if a user owns it.
+func (o *Operation) connectionChanged(connID string) {
+	record, err := o.connectionRecord(connID)
+	if errors.Is(err, storage.ErrDataNotFound) {
+		return
+	} else if err != nil {
+		logger.Warnf("failed to get record of connection %s: %s", connID, err.Error())
+
+		return
+	}
+
+	conn, err := o.connection(record)
+	if err != nil {
+		logger.Warnf("failed to get connection %s: %s", connID, err.Error())
+
+		return
+	}
+
+	o.notifyConnection(record.UserID, conn)
+}
+
+func (o *Operation) notifyConnection(userID string, conn *connection) {
+	if o.notifier == nil {
+		return
+	}
+
+	msg, err := json.Marshal(&connectionEvent{UserID: userID, Connection: conn})
+	if err != nil {
+		logger.Errorf("failed to marshal connection event: %s", err.Error())
+
+		return
+	}
+
+	err = o.notifier.Notify(connectionTopic, msg)
+	if err != nil {
+		logger.Warnf("failed to publish state of connection %s: %s", conn.ConnectionID, err.Error())
+	}
+}
+
+// connection returns the current state of a connection, labelled with the label given by its user if any.
+func (o *Operation) connection(record *connectionRecord) (*connection, error) {
+	conn, err := o.didexchangeClient.GetConnection(record.ConnectionID)
+	if err != nil {
+		return nil, err
+	}
+
+	label := record.Label
+	if label == "" {
+		label = conn.TheirLabel
+	}
+
+	return &connection{
+		ConnectionID:   conn.ConnectionID,
+		State:          conn.State,
+		Label:          label,
+		TheirLabel:     conn.TheirLabel,
+		TheirDID:       conn.TheirDID,
+		MyDID:          conn.MyDID,
+		InvitationID:   conn.InvitationID,
+		DIDCommVersion: string(conn.DIDCommVersion),
+	}, nil
+}
+
+func (o *Operation) connectionRecord(connID string) (*connectionRecord, error) {
+	bits, err := o.connectionStore.Get(connID)
+	if err != nil {
+		return nil, err
+	}
+
+	record := &connectionRecord{}
+
+	err = json.Unmarshal(bits, record)
+	if err != nil {
+		return nil, fmt.Errorf("invalid connection record: %w", err)
+	}
+
+	return record, nil
+}
+
+func (o *Operation) saveConnection(record *connectionRecord) error {
+	bits, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	return o.connectionStore.Put(record.ConnectionID, bits,
+		storage.Tag{Name: connectionUserTag, Value: userTag(record.UserID)})
+}
+
+func (o *Operation) connectionRecords(query string) ([]*connectionRecord, error) {
+	iter, err := o.connectionStore.Query(query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if closeErr := iter.Close(); closeErr != nil {
+			logger.Warnf("failed to close connections iterator: %s", closeErr.Error())
+		}
+	}()
+
+	var records []*connectionRecord
+
+	for {
+		more, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		if !more {
+			return records, nil
+		}
+
+		bits, err := iter.Value()
+		if err != nil {
+			return nil, err
+		}
+
+		record := &connectionRecord{}
+
+		err = json.Unmarshal(bits, record)
+		if err != nil {
+			return nil, fmt.Errorf("invalid connection record: %w", err)
+		}
+
+		records = append(records, record)
+	}
+}
+
+func writeConnectionError(w http.ResponseWriter, err error, msg string) {
+	status := http.StatusInternalServerError
+
+	if errors.Is(err, storage.ErrDataNotFound) || errors.Is(err, didexchange.ErrConnectionNotFound) {
+		status = http.StatusNotFound
+	}
+
+	common.WriteErrorResponsef(w, logger, status, "%s: %s", msg, err.Error())
+}
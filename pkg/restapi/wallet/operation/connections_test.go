@@ -0,0 +1,201 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation // nolint:testpackage // changing to different package requires exposing internal features.
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/common/service"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/protocol/didexchange"
+	"github.com/hyperledger/aries-framework-go/pkg/framework/context"
+	"github.com/hyperledger/aries-framework-go/pkg/wallet"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/wallet/pkg/restapi/internal/mocks"
+)
+
+const connectionTimeout = 10 * time.Second
+
+func TestOperation_Connections(t *testing.T) {
+	for _, version := range []service.Version{service.V1, service.V2} {
+		t.Run(fmt.Sprintf("accept an out-of-band %s invitation and manage the connection", version), func(t *testing.T) {
+			events := make(chan *connectionEvent, 10)
+
+			op, err := New(newAgent(t), &mocks.Notifier{NotifyFunc: func(topic string, msg []byte) error {
+				if topic == connectionTopic {
+					event := &connectionEvent{}
+					require.NoError(t, json.Unmarshal(msg, event))
+					events <- event
+				}
+
+				return nil
+			}}, nil)
+			require.NoError(t, err)
+
+			router := routerOf(op)
+			auth := unlock(t, router)
+
+			conn := acceptInvitation(t, router, &acceptInvitationReq{
+				walletAuth: auth,
+				Invitation: newInvitation(t, newInviter(t), version, "", ""),
+				Label:      "Alice",
+			})
+			require.NotEmpty(t, conn.ConnectionID)
+
+			requireConnectionCompleted(t, events, auth.UserID, conn.ConnectionID)
+
+			conn = requireConnection(t, router, getConnectionPath, &connectionReq{
+				walletAuth: auth, ConnectionID: conn.ConnectionID,
+			})
+			require.Equal(t, didexchange.StateIDCompleted, conn.State)
+			require.NotEmpty(t, conn.MyDID)
+			require.NotEmpty(t, conn.TheirDID)
+
+			conn = requireConnection(t, router, renameConnectionPath, &renameConnectionReq{
+				walletAuth: auth, ConnectionID: conn.ConnectionID, Label: "University",
+			})
+			require.Equal(t, "University", conn.Label)
+
+			rr := serve(router, http.MethodPost, listConnectionsPath, &connectionReq{walletAuth: auth})
+			require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+			resp := &listConnectionsResp{}
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), resp))
+			require.Equal(t, []*connection{conn}, resp.Connections)
+
+			other := unlock(t, router)
+
+			rr = serve(router, http.MethodPost, listConnectionsPath, &connectionReq{walletAuth: other})
+			require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+			require.JSONEq(t, `{"connections": []}`, rr.Body.String())
+
+			rr = serve(router, http.MethodPost, getConnectionPath, &connectionReq{
+				walletAuth: other, ConnectionID: conn.ConnectionID,
+			})
+			require.Equal(t, http.StatusNotFound, rr.Code, rr.Body.String())
+
+			rr = serve(router, http.MethodPost, removeConnectionPath, &connectionReq{
+				walletAuth: auth, ConnectionID: conn.ConnectionID,
+			})
+			require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+			rr = serve(router, http.MethodPost, getConnectionPath, &connectionReq{
+				walletAuth: auth, ConnectionID: conn.ConnectionID,
+			})
+			require.Equal(t, http.StatusNotFound, rr.Code, rr.Body.String())
+		})
+	}
+
+	t.Run("invalid requests", func(t *testing.T) {
+		router := newRouter(t, newProvider(t))
+		auth := unlock(t, router)
+
+		for _, path := range []string{
+			acceptInvitationPath, listConnectionsPath, getConnectionPath, renameConnectionPath, removeConnectionPath,
+		} {
+			rr := serve(router, http.MethodPost, path, "invalid")
+			require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+
+			rr = serve(router, http.MethodPost, path, &walletAuth{UserID: auth.UserID})
+			require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+			requireErrorResponse(t, rr.Body.Bytes(), "missing user ID or auth token")
+		}
+
+		for _, path := range []string{getConnectionPath, removeConnectionPath} {
+			rr := serve(router, http.MethodPost, path, &connectionReq{walletAuth: auth})
+			require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+			requireErrorResponse(t, rr.Body.Bytes(), "missing connection ID")
+
+			rr = serve(router, http.MethodPost, path, &connectionReq{
+				walletAuth: auth, ConnectionID: uuid.New().String(),
+			})
+			require.Equal(t, http.StatusNotFound, rr.Code, rr.Body.String())
+		}
+
+		rr := serve(router, http.MethodPost, acceptInvitationPath, &acceptInvitationReq{walletAuth: auth})
+		require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+		requireErrorResponse(t, rr.Body.Bytes(), "missing invitation")
+
+		rr = serve(router, http.MethodPost, acceptInvitationPath, &acceptInvitationReq{
+			walletAuth: auth, Invitation: &wallet.GenericInvitation{},
+		})
+		require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+		requireErrorResponse(t, rr.Body.Bytes(), "failed to accept invitation")
+
+		rr = serve(router, http.MethodPost, renameConnectionPath, &renameConnectionReq{
+			walletAuth: auth, ConnectionID: uuid.New().String(),
+		})
+		require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+		requireErrorResponse(t, rr.Body.Bytes(), "missing label")
+
+		rr = serve(router, http.MethodPost, listConnectionsPath, &connectionReq{
+			walletAuth: walletAuth{UserID: auth.UserID, Auth: "invalid"},
+		})
+		require.Equal(t, http.StatusUnauthorized, rr.Code, rr.Body.String())
+	})
+}
+
+// newInviter returns the context of a new agent accepting the DID exchange requests it receives.
+func newInviter(t *testing.T) *context.Provider {
+	t.Helper()
+
+	ctx := newAgent(t)
+
+	svc, err := ctx.Service(didexchange.DIDExchange)
+	require.NoError(t, err)
+
+	actions := make(chan service.DIDCommAction)
+	require.NoError(t, svc.(service.Event).RegisterActionEvent(actions))
+
+	go service.AutoExecuteActionEvent(actions)
+
+	return ctx
+}
+
+func acceptInvitation(t *testing.T, router *mux.Router, req *acceptInvitationReq) *connection {
+	t.Helper()
+
+	return requireConnection(t, router, acceptInvitationPath, req)
+}
+
+func requireConnection(t *testing.T, router *mux.Router, path string, req interface{}) *connection {
+	t.Helper()
+
+	rr := serve(router, http.MethodPost, path, req)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	conn := &connection{}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), conn))
+
+	return conn
+}
+
+// requireConnectionCompleted waits for the notifier to publish the completion of the DID exchange of a connection.
+func requireConnectionCompleted(t *testing.T, events <-chan *connectionEvent, userID, connID string) {
+	t.Helper()
+
+	timeout := time.After(connectionTimeout)
+
+	for {
+		select {
+		case event := <-events:
+			require.Equal(t, userID, event.UserID)
+			require.Equal(t, connID, event.Connection.ConnectionID)
+
+			if event.Connection.State == didexchange.StateIDCompleted {
+				return
+			}
+		case <-timeout:
+			require.Fail(t, "connection not completed")
+		}
+	}
+}
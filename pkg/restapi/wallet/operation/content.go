@@ -0,0 +1,332 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/hyperledger/aries-framework-go/pkg/wallet"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+
+	"github.com/trustbloc/wallet/pkg/restapi/common"
+)
+
+// errReservedMetadata is returned when a user adds metadata with an ID reserved for the wallet operations.
+var errReservedMetadata = errors.New("reserved metadata ID")
+
+// addContent saves content of any supported type in an unlocked wallet, optionally in a collection.
+func (o *Operation) addContent(w http.ResponseWriter, r *http.Request) {
+	req := &addContentReq{}
+
+	if !decodeContentRequest(w, r, req, &req.contentAuth) {
+		return
+	}
+
+	if len(req.Content) == 0 {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing content")
+
+		return
+	}
+
+	if id, ok := mux.Vars(r)[collectionIDPathParam]; ok {
+		req.CollectionID = id
+	}
+
+	err := checkReservedMetadata(req.ContentType, req.Content)
+	if err != nil {
+		writeWalletError(w, err, "failed to add %s", req.ContentType)
+
+		return
+	}
+
+	vcWallet, ok := o.openWallet(w, req.UserID)
+	if !ok {
+		return
+	}
+
+	var opts []wallet.AddContentOptions
+
+	if req.CollectionID != "" {
+		opts = append(opts, wallet.AddByCollection(req.CollectionID))
+	}
+
+	err = vcWallet.Add(req.Auth, req.ContentType, req.Content, opts...)
+	if err != nil {
+		writeWalletError(w, err, "failed to add %s", req.ContentType)
+
+		return
+	}
+
+	if req.ContentType == wallet.Credential {
+		o.trackCredential(req.UserID, req.Content, nil)
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+// removeContent removes content from an unlocked wallet, optionally only if it is in a collection.
+func (o *Operation) removeContent(w http.ResponseWriter, r *http.Request) {
+	req := &removeContentReq{}
+
+	if !decodeContentRequest(w, r, req, &req.contentAuth) {
+		return
+	}
+
+	if req.ContentID == "" {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing content ID")
+
+		return
+	}
+
+	vcWallet, ok := o.openWallet(w, req.UserID)
+	if !ok {
+		return
+	}
+
+	if !inRequestCollection(w, r, vcWallet, &req.contentAuth, req.ContentID) {
+		return
+	}
+
+	err := vcWallet.Remove(req.Auth, req.ContentType, req.ContentID)
+	if err != nil {
+		writeWalletError(w, err, "failed to remove %s", req.ContentType)
+
+		return
+	}
+
+	if req.ContentType == wallet.Credential {
+		o.untrackCredential(req.UserID, req.ContentID)
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// getContent fetches content from an unlocked wallet, optionally only if it is in a collection.
+func (o *Operation) getContent(w http.ResponseWriter, r *http.Request) {
+	req := &getContentReq{}
+
+	if !decodeContentRequest(w, r, req, &req.contentAuth) {
+		return
+	}
+
+	if req.ContentID == "" {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing content ID")
+
+		return
+	}
+
+	vcWallet, ok := o.openWallet(w, req.UserID)
+	if !ok {
+		return
+	}
+
+	if !inRequestCollection(w, r, vcWallet, &req.contentAuth, req.ContentID) {
+		return
+	}
+
+	content, err := vcWallet.Get(req.Auth, req.ContentType, req.ContentID)
+	if err != nil {
+		writeWalletError(w, err, "failed to get %s", req.ContentType)
+
+		return
+	}
+
+	common.WriteResponse(w, logger, &getContentResp{Content: content})
+}
+
+// getAllContent fetches all content of a type from an unlocked wallet, optionally only from a collection.
+func (o *Operation) getAllContent(w http.ResponseWriter, r *http.Request) {
+	req := &getAllContentReq{}
+
+	if !decodeContentRequest(w, r, req, &req.contentAuth) {
+		return
+	}
+
+	if id, ok := mux.Vars(r)[collectionIDPathParam]; ok {
+		req.CollectionID = id
+	}
+
+	vcWallet, ok := o.openWallet(w, req.UserID)
+	if !ok {
+		return
+	}
+
+	var opts []wallet.GetAllContentsOptions
+
+	if req.CollectionID != "" {
+		opts = append(opts, wallet.FilterByCollection(req.CollectionID))
+	}
+
+	contents, err := vcWallet.GetAll(req.Auth, req.ContentType, opts...)
+	if err != nil {
+		writeWalletError(w, err, "failed to get all %s", req.ContentType)
+
+		return
+	}
+
+	common.WriteResponse(w, logger, &getAllContentResp{Contents: contents})
+}
+
+// inRequestCollection fails with a not found error if the request path names a collection that does not hold the
+// content. Returns true if the request path has no collection.
+func inRequestCollection(w http.ResponseWriter, r *http.Request, vcWallet *wallet.Wallet, auth *contentAuth,
+	contentID string) bool {
+	collectionID, ok := mux.Vars(r)[collectionIDPathParam]
+	if !ok {
+		return true
+	}
+
+	contents, err := vcWallet.GetAll(auth.Auth, auth.ContentType, wallet.FilterByCollection(collectionID))
+	if err != nil {
+		writeWalletError(w, err, "failed to get %s of collection [%s]", auth.ContentType, collectionID)
+
+		return false
+	}
+
+	if _, found := contents[contentID]; !found {
+		common.WriteErrorResponsef(w, logger, http.StatusNotFound, "%s [%s] is not in collection [%s]",
+			auth.ContentType, contentID, collectionID)
+
+		return false
+	}
+
+	return true
+}
+
+// checkReservedMetadata returns an error if the content is metadata whose ID is reserved for the wallet operations.
+// The wallet saves contents by the ID in their content, so that ID is checked.
+func checkReservedMetadata(contentType wallet.ContentType, content json.RawMessage) error {
+	if contentType != wallet.Metadata {
+		return nil
+	}
+
+	metadata := &struct {
+		ID string `json:"id"`
+	}{}
+
+	// contents the wallet cannot parse are rejected by the wallet.
+	if json.Unmarshal(content, metadata) != nil {
+		return nil
+	}
+
+	if metadata.ID == selfIssuedKeyMetadataID || strings.HasPrefix(metadata.ID, didKeysMetadataPrefix) {
+		return fmt.Errorf("%w: %s", errReservedMetadata, metadata.ID)
+	}
+
+	return nil
+}
+
+// openWallet returns the wallet of the user. The wallet is locked until it is unlocked with open.
+func (o *Operation) openWallet(w http.ResponseWriter, userID string) (*wallet.Wallet, bool) {
+	vcWallet, err := wallet.New(userID, o.ctx)
+	if err != nil {
+		writeWalletError(w, err, "failed to open wallet")
+
+		return nil, false
+	}
+
+	return vcWallet, true
+}
+
+// openUnlockedWallet returns the wallet of the user if the auth token unlocks it. The DIDComm flows of the wallet do
+// not check the token themselves.
+func (o *Operation) openUnlockedWallet(w http.ResponseWriter, userID, auth string) (*wallet.Wallet, bool) {
+	vcWallet, ok := o.openWallet(w, userID)
+	if !ok {
+		return nil, false
+	}
+
+	_, err := vcWallet.GetAll(auth, wallet.Collection)
+	if err != nil {
+		writeWalletError(w, err, "failed to open wallet")
+
+		return nil, false
+	}
+
+	return vcWallet, true
+}
+
+// checkWalletToken returns an error unless the auth token is the token of the wallet the user unlocked last, and it
+// still unlocks the wallet. The keys the server keeps in the agent KMS for a user are only used with that token, as the
+// tokens of the VC wallet are not bound to users.
+func (o *Operation) checkWalletToken(vcWallet *wallet.Wallet, auth *walletAuth) error {
+	if !o.isWalletToken(auth.UserID, auth.Auth) {
+		return fmt.Errorf("token of the wallet of user %s: %w", auth.UserID, wallet.ErrInvalidAuthToken)
+	}
+
+	_, err := vcWallet.GetAll(auth.Auth, wallet.Collection)
+
+	return err
+}
+
+// decodeWalletRequest decodes the request body into req, and validates the wallet authorization decoded into auth.
+func decodeWalletRequest(w http.ResponseWriter, r *http.Request, req interface{}, auth *walletAuth) bool {
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "invalid request: %s", err.Error())
+
+		return false
+	}
+
+	if auth.UserID == "" || auth.Auth == "" {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing user ID or auth token")
+
+		return false
+	}
+
+	return true
+}
+
+// decodeContentRequest decodes the request body into req, and validates the wallet authorization and content type
+// decoded into its contentAuth.
+func decodeContentRequest(w http.ResponseWriter, r *http.Request, req interface{}, auth *contentAuth) bool {
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "invalid request: %s", err.Error())
+
+		return false
+	}
+
+	if auth.UserID == "" || auth.Auth == "" {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing user ID or auth token")
+
+		return false
+	}
+
+	switch auth.ContentType {
+	case wallet.Collection, wallet.Credential, wallet.DIDResolutionResponse, wallet.Metadata, wallet.Connection,
+		wallet.Key:
+		return true
+	default:
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "unsupported content type [%s]",
+			auth.ContentType)
+
+		return false
+	}
+}
+
+func writeWalletError(w http.ResponseWriter, err error, msg string, args ...interface{}) {
+	status := http.StatusInternalServerError
+
+	switch {
+	case errors.Is(err, errInvalidQuery), errors.Is(err, errInvalidSelection), errors.Is(err, errUnknownIssuance),
+		errors.Is(err, errInvalidDIDOperation), errors.Is(err, errInvalidProofRequest), errors.Is(err, errInvalidBackupKey),
+		errors.Is(err, errReservedMetadata):
+		status = http.StatusBadRequest
+	case errors.Is(err, wallet.ErrInvalidAuthToken), errors.Is(err, wallet.ErrWalletLocked):
+		status = http.StatusUnauthorized
+	case errors.Is(err, wallet.ErrProfileNotFound), errors.Is(err, storage.ErrDataNotFound):
+		status = http.StatusNotFound
+	}
+
+	common.WriteErrorResponsef(w, logger, status, "%s: %s", fmt.Sprintf(msg, args...), err.Error())
+}
@@ -0,0 +1,339 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation // nolint:testpackage // changing to different package requires exposing internal features.
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/hyperledger/aries-framework-go/pkg/controller/command/vcwallet"
+	"github.com/hyperledger/aries-framework-go/pkg/wallet"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/wallet/pkg/restapi/common"
+)
+
+const (
+	sampleCredential = `{
+		"@context": ["https://www.w3.org/2018/credentials/v1"],
+		"id": "%s",
+		"type": ["VerifiableCredential"],
+		"issuer": "did:example:76e12ec712ebc6f1c221ebfeb1f",
+		"issuanceDate": "2010-01-01T19:23:24Z",
+		"credentialSubject": {"id": "did:example:ebfeb1f712ebc6f1c276e12ec21"}
+	}`
+	sampleCollection = `{
+		"@context": ["https://w3id.org/wallet/v1"],
+		"id": "%s",
+		"type": "collection",
+		"name": "My Credentials"
+	}`
+	sampleMetadata = `{
+		"@context": ["https://w3id.org/wallet/v1"],
+		"id": "%s",
+		"type": "Metadata",
+		"name": "Wallet metadata"
+	}`
+	sampleConnection = `{
+		"@context": ["https://w3id.org/wallet/v1"],
+		"id": "%s",
+		"type": "Connection",
+		"name": "My Connection"
+	}`
+	sampleDIDResolution = `{
+		"@context": "https://w3id.org/wallet/v1",
+		"id": "%[1]s",
+		"type": "DIDResolutionResponse",
+		"didDocument": {
+			"@context": ["https://www.w3.org/ns/did/v1"],
+			"id": "%[1]s"
+		}
+	}`
+)
+
+func TestOperation_Content(t *testing.T) {
+	contents := []struct {
+		contentType wallet.ContentType
+		template    string
+		id          string
+	}{
+		{wallet.Credential, sampleCredential, uuid.New().URN()},
+		{wallet.Collection, sampleCollection, uuid.New().URN()},
+		{wallet.Metadata, sampleMetadata, uuid.New().URN()},
+		{wallet.Connection, sampleConnection, uuid.New().URN()},
+		{wallet.DIDResolutionResponse, sampleDIDResolution, "did:example:" + uuid.New().String()},
+	}
+
+	for _, c := range contents {
+		c := c
+
+		t.Run(fmt.Sprintf("add, get and remove %s", c.contentType), func(t *testing.T) {
+			router := newRouter(t, newProvider(t))
+			auth := unlock(t, router)
+			content := json.RawMessage(fmt.Sprintf(c.template, c.id))
+
+			rr := serve(router, http.MethodPost, addPath, &addContentReq{
+				contentAuth: contentAuth{walletAuth: auth, ContentType: c.contentType},
+				Content:     content,
+			})
+			require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+
+			rr = serve(router, http.MethodPost, getPath, &getContentReq{
+				contentAuth: contentAuth{walletAuth: auth, ContentType: c.contentType},
+				ContentID:   c.id,
+			})
+			require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+			got := &getContentResp{}
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), got))
+			require.JSONEq(t, string(content), string(got.Content))
+
+			rr = serve(router, http.MethodPost, getAllPath, &getAllContentReq{
+				contentAuth: contentAuth{walletAuth: auth, ContentType: c.contentType},
+			})
+			require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+			all := &getAllContentResp{}
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), all))
+			require.Len(t, all.Contents, 1)
+			require.Contains(t, all.Contents, c.id)
+
+			rr = serve(router, http.MethodPost, removePath, &removeContentReq{
+				contentAuth: contentAuth{walletAuth: auth, ContentType: c.contentType},
+				ContentID:   c.id,
+			})
+			require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+			rr = serve(router, http.MethodPost, getPath, &getContentReq{
+				contentAuth: contentAuth{walletAuth: auth, ContentType: c.contentType},
+				ContentID:   c.id,
+			})
+			require.Equal(t, http.StatusNotFound, rr.Code, rr.Body.String())
+		})
+	}
+
+	t.Run("add, get and remove content of a collection", func(t *testing.T) {
+		router := newRouter(t, newProvider(t))
+		auth := unlock(t, router)
+		collectionID := uuid.New().URN()
+		collectionPath := "/collections/" + collectionID
+
+		rr := serve(router, http.MethodPost, addPath, &addContentReq{
+			contentAuth: contentAuth{walletAuth: auth, ContentType: wallet.Collection},
+			Content:     json.RawMessage(fmt.Sprintf(sampleCollection, collectionID)),
+		})
+		require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+
+		inCollection := uuid.New().URN()
+
+		rr = serve(router, http.MethodPost, collectionPath+addPath, &addContentReq{
+			contentAuth: contentAuth{walletAuth: auth, ContentType: wallet.Credential},
+			Content:     json.RawMessage(fmt.Sprintf(sampleCredential, inCollection)),
+		})
+		require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+
+		rr = serve(router, http.MethodPost, addPath, &addContentReq{
+			contentAuth: contentAuth{walletAuth: auth, ContentType: wallet.Credential},
+			Content:     json.RawMessage(fmt.Sprintf(sampleCredential, uuid.New().URN())),
+		})
+		require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+
+		rr = serve(router, http.MethodPost, collectionPath+getAllPath, &getAllContentReq{
+			contentAuth: contentAuth{walletAuth: auth, ContentType: wallet.Credential},
+		})
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		all := &getAllContentResp{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), all))
+		require.Len(t, all.Contents, 1)
+		require.Contains(t, all.Contents, inCollection)
+
+		rr = serve(router, http.MethodPost, getAllPath, &getAllContentReq{
+			contentAuth:  contentAuth{walletAuth: auth, ContentType: wallet.Credential},
+			CollectionID: collectionID,
+		})
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		all = &getAllContentResp{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), all))
+		require.Len(t, all.Contents, 1)
+
+		rr = serve(router, http.MethodPost, getAllPath, &getAllContentReq{
+			contentAuth: contentAuth{walletAuth: auth, ContentType: wallet.Credential},
+		})
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		all = &getAllContentResp{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), all))
+		require.Len(t, all.Contents, 2)
+
+		var outside string
+
+		for id := range all.Contents {
+			if id != inCollection {
+				outside = id
+			}
+		}
+
+		rr = serve(router, http.MethodPost, collectionPath+getPath, &getContentReq{
+			contentAuth: contentAuth{walletAuth: auth, ContentType: wallet.Credential},
+			ContentID:   inCollection,
+		})
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		for _, path := range []string{getPath, removePath} {
+			rr = serve(router, http.MethodPost, collectionPath+path, &getContentReq{
+				contentAuth: contentAuth{walletAuth: auth, ContentType: wallet.Credential},
+				ContentID:   outside,
+			})
+			require.Equal(t, http.StatusNotFound, rr.Code, rr.Body.String())
+			requireErrorResponse(t, rr.Body.Bytes(), "is not in collection")
+		}
+
+		rr = serve(router, http.MethodPost, collectionPath+removePath, &removeContentReq{
+			contentAuth: contentAuth{walletAuth: auth, ContentType: wallet.Credential},
+			ContentID:   inCollection,
+		})
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		rr = serve(router, http.MethodPost, getPath, &getContentReq{
+			contentAuth: contentAuth{walletAuth: auth, ContentType: wallet.Credential},
+			ContentID:   outside,
+		})
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	})
+
+	t.Run("error if the request is invalid", func(t *testing.T) {
+		router := newRouter(t, newProvider(t))
+		auth := unlock(t, router)
+
+		for _, path := range []string{addPath, removePath, getPath, getAllPath} {
+			rr := serve(router, http.MethodPost, path, "invalid")
+			require.Equal(t, http.StatusBadRequest, rr.Code)
+			requireErrorResponse(t, rr.Body.Bytes(), "invalid request")
+
+			rr = serve(router, http.MethodPost, path, &contentAuth{
+				walletAuth:  walletAuth{UserID: auth.UserID},
+				ContentType: wallet.Credential,
+			})
+			require.Equal(t, http.StatusBadRequest, rr.Code)
+			requireErrorResponse(t, rr.Body.Bytes(), "missing user ID or auth token")
+
+			rr = serve(router, http.MethodPost, path, &contentAuth{walletAuth: auth, ContentType: "invalid"})
+			require.Equal(t, http.StatusBadRequest, rr.Code)
+			requireErrorResponse(t, rr.Body.Bytes(), "unsupported content type [invalid]")
+		}
+
+		rr := serve(router, http.MethodPost, addPath, &contentAuth{walletAuth: auth, ContentType: wallet.Credential})
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		requireErrorResponse(t, rr.Body.Bytes(), "missing content")
+
+		for _, path := range []string{removePath, getPath} {
+			rr = serve(router, http.MethodPost, path, &contentAuth{walletAuth: auth, ContentType: wallet.Credential})
+			require.Equal(t, http.StatusBadRequest, rr.Code)
+			requireErrorResponse(t, rr.Body.Bytes(), "missing content ID")
+		}
+	})
+
+	t.Run("error if metadata has an ID reserved for the wallet operations", func(t *testing.T) {
+		router := newRouter(t, newProvider(t))
+		auth := unlock(t, router)
+
+		for _, id := range []string{selfIssuedKeyMetadataID, didKeysMetadataPrefix + "did:orb:123"} {
+			rr := serve(router, http.MethodPost, addPath, &addContentReq{
+				contentAuth: contentAuth{walletAuth: auth, ContentType: wallet.Metadata},
+				Content:     json.RawMessage(fmt.Sprintf(`{"id":%q,"keyID":"key"}`, id)),
+			})
+			require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+			requireErrorResponse(t, rr.Body.Bytes(), "reserved metadata ID")
+		}
+	})
+
+	t.Run("error if the auth token is invalid", func(t *testing.T) {
+		router := newRouter(t, newProvider(t))
+		auth := unlock(t, router)
+		auth.Auth = "invalid"
+
+		rr := serve(router, http.MethodPost, addPath, &addContentReq{
+			contentAuth: contentAuth{walletAuth: auth, ContentType: wallet.Credential},
+			Content:     json.RawMessage(fmt.Sprintf(sampleCredential, uuid.New().URN())),
+		})
+		require.Equal(t, http.StatusUnauthorized, rr.Code, rr.Body.String())
+
+		rr = serve(router, http.MethodPost, getAllPath, &getAllContentReq{
+			contentAuth: contentAuth{walletAuth: auth, ContentType: wallet.Credential},
+		})
+		require.Equal(t, http.StatusUnauthorized, rr.Code, rr.Body.String())
+	})
+
+	t.Run("error if the wallet has no profile", func(t *testing.T) {
+		router := newRouter(t, newProvider(t))
+
+		rr := serve(router, http.MethodPost, getAllPath, &getAllContentReq{
+			contentAuth: contentAuth{
+				walletAuth:  walletAuth{UserID: uuid.New().String(), Auth: uuid.New().String()},
+				ContentType: wallet.Credential,
+			},
+		})
+		require.Equal(t, http.StatusNotFound, rr.Code, rr.Body.String())
+		requireErrorResponse(t, rr.Body.Bytes(), "failed to open wallet")
+	})
+
+	t.Run("error if the content already exists", func(t *testing.T) {
+		router := newRouter(t, newProvider(t))
+		auth := unlock(t, router)
+		content := json.RawMessage(fmt.Sprintf(sampleCredential, uuid.New().URN()))
+
+		rr := serve(router, http.MethodPost, addPath, &addContentReq{
+			contentAuth: contentAuth{walletAuth: auth, ContentType: wallet.Credential},
+			Content:     content,
+		})
+		require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+
+		rr = serve(router, http.MethodPost, addPath, &addContentReq{
+			contentAuth: contentAuth{walletAuth: auth, ContentType: wallet.Credential},
+			Content:     content,
+		})
+		require.Equal(t, http.StatusInternalServerError, rr.Code, rr.Body.String())
+		requireErrorResponse(t, rr.Body.Bytes(), "failed to add credential")
+	})
+}
+
+// unlock creates a wallet profile for a new user and unlocks the wallet.
+func unlock(t *testing.T, router *mux.Router) walletAuth {
+	t.Helper()
+
+	userID := uuid.New().String()
+
+	rr := serve(router, http.MethodPost, createProfilePath, &vcwallet.CreateOrUpdateProfileRequest{
+		UserID:             userID,
+		LocalKMSPassphrase: samplePassphrase,
+	})
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	rr = serve(router, http.MethodPost, openPath, &vcwallet.UnlockWalletRequest{
+		UserID:             userID,
+		LocalKMSPassphrase: samplePassphrase,
+	})
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	unlocked := &vcwallet.UnlockWalletResponse{}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), unlocked))
+
+	return walletAuth{UserID: userID, Auth: unlocked.Token}
+}
+
+func requireErrorResponse(t *testing.T, body []byte, msg string) {
+	t.Helper()
+
+	errResp := &common.ErrorResponse{}
+	require.NoError(t, json.Unmarshal(body, errResp))
+	require.Contains(t, errResp.Message, msg)
+}
@@ -0,0 +1,654 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/btcsuite/btcutil/base58"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/did"
+	vdrapi "github.com/hyperledger/aries-framework-go/pkg/framework/aries/api/vdr"
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+	"github.com/hyperledger/aries-framework-go/pkg/vdr/fingerprint"
+	"github.com/hyperledger/aries-framework-go/pkg/wallet"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+
+	"github.com/trustbloc/wallet/pkg/restapi/common"
+	"github.com/trustbloc/wallet/pkg/restapi/common/store"
+)
+
+// DID methods supported.
+const (
+	didKeyMethod = "key"
+	didOrbMethod = "orb"
+	didWebMethod = "web"
+)
+
+const (
+	// names of the DID method options of the orb VDR.
+	orbUpdatePublicKeyOpt   = "updatePublicKey"
+	orbRecoveryPublicKeyOpt = "recoveryPublicKey"
+	orbAnchorOriginOpt      = "anchorOrigin"
+
+	// didKeyStoreName is the name of the store recording the keys of the did:orb DIDs of users.
+	didKeyStoreName = "wallet_did_keys"
+
+	// didKeysMetadataPrefix prefixes the DIDs in the IDs of the wallet metadata in which the keys of did:orb DIDs
+	// were recorded before the keys were recorded by the server. Users cannot add metadata with these IDs.
+	didKeysMetadataPrefix = "urn:trustbloc:wallet:did-keys:"
+
+	walletContext          = "https://w3id.org/wallet/v1"
+	ed25519VerificationKey = "Ed25519VerificationKey2018"
+)
+
+var (
+	errInvalidDIDOperation = errors.New("invalid DID operation")
+	errVDR                 = errors.New("VDR error")
+)
+
+// createDID creates a did:key, did:orb or did:web DID with a new Ed25519 key of the keystore of an unlocked wallet,
+// and saves its document in the wallet. did:orb DIDs are published with the orb VDR, while the documents of did:web
+// DIDs are to be hosted by the user at the domain of the DID.
+//
+// The update and recovery keys of did:orb DIDs are not keys of the wallet: they are created in the agent KMS, shared
+// by all users, and the server records which user owns them. Only the token of the wallet the user unlocked last
+// creates, updates or deactivates did:orb DIDs.
+func (o *Operation) createDID(w http.ResponseWriter, r *http.Request) {
+	req := &createDIDReq{}
+
+	if !decodeWalletRequest(w, r, req, &req.walletAuth) {
+		return
+	}
+
+	switch req.Method {
+	case didKeyMethod:
+		if len(req.Services) > 0 {
+			common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "did:key DIDs cannot have services")
+
+			return
+		}
+	case didOrbMethod:
+	case didWebMethod:
+		if req.Domain == "" {
+			common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing domain of did:web DID")
+
+			return
+		}
+	default:
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "unsupported DID method [%s]", req.Method)
+
+		return
+	}
+
+	if err := validateServices(req.Services); err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	vcWallet, ok := o.openUnlockedWallet(w, req.UserID, req.Auth)
+	if !ok {
+		return
+	}
+
+	var (
+		resolution *did.DocResolution
+		err        error
+	)
+
+	switch req.Method {
+	case didKeyMethod:
+		resolution, err = o.createDIDKey(vcWallet, req.Auth)
+	case didOrbMethod:
+		resolution, err = o.createDIDOrb(vcWallet, req)
+	default:
+		resolution, err = createDIDWeb(vcWallet, req)
+	}
+
+	if errors.Is(err, errVDR) {
+		common.WriteErrorResponsef(w, logger, http.StatusBadGateway, "failed to create did:%s DID: %s", req.Method,
+			err.Error())
+
+		return
+	} else if err != nil {
+		writeWalletError(w, err, "failed to create did:%s DID", req.Method)
+
+		return
+	}
+
+	raw, err := saveDID(vcWallet, req.Auth, resolution)
+	if err != nil {
+		writeWalletError(w, err, "failed to save DID")
+
+		return
+	}
+
+	common.WriteResponse(w, logger, &didResp{DID: resolution.DIDDocument.ID, Resolution: raw})
+}
+
+// listDIDs returns the resolutions of the DIDs saved in an unlocked wallet.
+func (o *Operation) listDIDs(w http.ResponseWriter, r *http.Request) {
+	req := &didReq{}
+
+	if !decodeWalletRequest(w, r, req, &req.walletAuth) {
+		return
+	}
+
+	vcWallet, ok := o.openWallet(w, req.UserID)
+	if !ok {
+		return
+	}
+
+	contents, err := vcWallet.GetAll(req.Auth, wallet.DIDResolutionResponse)
+	if err != nil {
+		writeWalletError(w, err, "failed to get DIDs")
+
+		return
+	}
+
+	ids := make([]string, 0, len(contents))
+
+	for id := range contents {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+
+	resp := &listDIDsResp{DIDs: make([]json.RawMessage, len(ids))}
+
+	for i, id := range ids {
+		resp.DIDs[i] = contents[id]
+	}
+
+	common.WriteResponse(w, logger, resp)
+}
+
+// resolveDID resolves a DID from the DIDs saved in an unlocked wallet, or with the VDRs of the server.
+func (o *Operation) resolveDID(w http.ResponseWriter, r *http.Request) {
+	req := &didReq{}
+
+	if !decodeWalletRequest(w, r, req, &req.walletAuth) {
+		return
+	}
+
+	if req.DID == "" {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing DID")
+
+		return
+	}
+
+	vcWallet, ok := o.openWallet(w, req.UserID)
+	if !ok {
+		return
+	}
+
+	raw, err := vcWallet.Get(req.Auth, wallet.DIDResolutionResponse, req.DID)
+	if err == nil {
+		common.WriteResponse(w, logger, &didResp{DID: req.DID, Resolution: raw})
+
+		return
+	} else if !errors.Is(err, storage.ErrDataNotFound) {
+		writeWalletError(w, err, "failed to get DID")
+
+		return
+	}
+
+	resolution, err := o.ctx.VDRegistry().Resolve(req.DID)
+	if errors.Is(err, vdrapi.ErrNotFound) {
+		common.WriteErrorResponsef(w, logger, http.StatusNotFound, "failed to resolve DID: %s", err.Error())
+
+		return
+	} else if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadGateway, "failed to resolve DID: %s", err.Error())
+
+		return
+	}
+
+	raw, err = resolution.JSONBytes()
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "failed to marshal DID: %s",
+			err.Error())
+
+		return
+	}
+
+	common.WriteResponse(w, logger, &didResp{DID: req.DID, Resolution: raw})
+}
+
+// updateDID adds a verification method with a new key to a did:orb or did:web DID saved in an unlocked wallet,
+// rotates the key of one of its verification methods, or adds, replaces or removes its services. Updates of did:orb
+// DIDs of the user are published with the orb VDR, signed with their update key in the agent KMS.
+func (o *Operation) updateDID(w http.ResponseWriter, r *http.Request) {
+	req := &updateDIDReq{}
+
+	if !decodeWalletRequest(w, r, req, &req.walletAuth) {
+		return
+	}
+
+	if req.DID == "" {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing DID")
+
+		return
+	}
+
+	if !req.AddVerificationMethod && req.RotateVerificationMethod == "" && len(req.Services) == 0 &&
+		len(req.RemoveServices) == 0 {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing DID update")
+
+		return
+	}
+
+	vcWallet, ok := o.openWallet(w, req.UserID)
+	if !ok {
+		return
+	}
+
+	resolution, err := savedDID(vcWallet, req.Auth, req.DID)
+	if err != nil {
+		writeWalletError(w, err, "failed to get DID")
+
+		return
+	}
+
+	err = applyDIDUpdate(vcWallet, req, resolution.DIDDocument)
+	if err != nil {
+		writeWalletError(w, err, "failed to update DID")
+
+		return
+	}
+
+	if didMethod(req.DID) == didOrbMethod {
+		err = o.updateDIDOrb(vcWallet, &req.walletAuth, resolution.DIDDocument)
+		if errors.Is(err, errVDR) {
+			common.WriteErrorResponsef(w, logger, http.StatusBadGateway, "failed to update DID: %s", err.Error())
+
+			return
+		} else if err != nil {
+			writeWalletError(w, err, "failed to update DID")
+
+			return
+		}
+	}
+
+	raw, err := replaceDID(vcWallet, req.Auth, resolution)
+	if err != nil {
+		writeWalletError(w, err, "failed to save DID")
+
+		return
+	}
+
+	common.WriteResponse(w, logger, &didResp{DID: req.DID, Resolution: raw})
+}
+
+// deactivateDID deactivates a DID saved in an unlocked wallet. did:orb DIDs of the user are deactivated with the orb
+// VDR, signed with their recovery key in the agent KMS. The document of the DID is kept in the wallet, without
+// verification relationships so that the wallet no longer signs with its keys.
+func (o *Operation) deactivateDID(w http.ResponseWriter, r *http.Request) {
+	req := &didReq{}
+
+	if !decodeWalletRequest(w, r, req, &req.walletAuth) {
+		return
+	}
+
+	if req.DID == "" {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing DID")
+
+		return
+	}
+
+	vcWallet, ok := o.openWallet(w, req.UserID)
+	if !ok {
+		return
+	}
+
+	resolution, err := savedDID(vcWallet, req.Auth, req.DID)
+	if err != nil {
+		writeWalletError(w, err, "failed to get DID")
+
+		return
+	}
+
+	if didMethod(req.DID) == didOrbMethod {
+		err = o.deactivateDIDOrb(vcWallet, &req.walletAuth, req.DID)
+		if errors.Is(err, errVDR) {
+			common.WriteErrorResponsef(w, logger, http.StatusBadGateway, "failed to deactivate DID: %s", err.Error())
+
+			return
+		} else if err != nil {
+			writeWalletError(w, err, "failed to deactivate DID")
+
+			return
+		}
+	}
+
+	doc := resolution.DIDDocument
+	doc.Authentication, doc.AssertionMethod, doc.KeyAgreement = nil, nil, nil
+	doc.CapabilityDelegation, doc.CapabilityInvocation = nil, nil
+	resolution.DocumentMetadata.Deactivated = true
+
+	raw, err := replaceDID(vcWallet, req.Auth, resolution)
+	if err != nil {
+		writeWalletError(w, err, "failed to save DID")
+
+		return
+	}
+
+	common.WriteResponse(w, logger, &didResp{DID: req.DID, Resolution: raw})
+}
+
+// createDIDKey imports a new Ed25519 key into the keystore of the wallet, with the ID of the verification method of
+// the did:key DID of the key, and resolves the DID.
+func (o *Operation) createDIDKey(vcWallet *wallet.Wallet, auth string) (*did.DocResolution, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	didKey, keyID := fingerprint.CreateDIDKey(pub)
+
+	key, err := json.Marshal(&keyContent{
+		Context:          []string{walletContext},
+		ID:               keyID,
+		Controller:       didKey,
+		Type:             ed25519VerificationKey,
+		PrivateKeyBase58: base58.Encode(priv),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = vcWallet.Add(auth, wallet.Key, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to import key: %w", err)
+	}
+
+	return o.ctx.VDRegistry().Resolve(didKey)
+}
+
+// createDIDOrb creates and publishes a did:orb DID with the orb VDR. The update and recovery keys of the DID are
+// created in the agent KMS, and their IDs recorded by the server for the user.
+func (o *Operation) createDIDOrb(vcWallet *wallet.Wallet, req *createDIDReq) (*did.DocResolution, error) {
+	err := o.checkWalletToken(vcWallet, &req.walletAuth)
+	if err != nil {
+		return nil, err
+	}
+
+	vm, err := newVerificationMethod(vcWallet, req.Auth, "")
+	if err != nil {
+		return nil, err
+	}
+
+	updateKeyID, updateKey, err := o.createDIDKeyPair()
+	if err != nil {
+		return nil, err
+	}
+
+	recoveryKeyID, recoveryKey, err := o.createDIDKeyPair()
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []vdrapi.DIDMethodOption{
+		vdrapi.WithOption(orbUpdatePublicKeyOpt, updateKey),
+		vdrapi.WithOption(orbRecoveryPublicKeyOpt, recoveryKey),
+	}
+
+	if req.AnchorOrigin != "" {
+		opts = append(opts, vdrapi.WithOption(orbAnchorOriginOpt, req.AnchorOrigin))
+	}
+
+	resolution, err := o.ctx.VDRegistry().Create(didOrbMethod, newDoc("", vm, req.Services), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errVDR, err.Error())
+	}
+
+	err = store.Save(o.didKeyStore, resolution.DIDDocument.ID, &didKeys{
+		UserID:        req.UserID,
+		DID:           resolution.DIDDocument.ID,
+		UpdateKeyID:   updateKeyID,
+		RecoveryKeyID: recoveryKeyID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to save DID keys: %w", err)
+	}
+
+	return resolution, nil
+}
+
+// createDIDWeb returns the document of a did:web DID of a domain, with an optional path.
+func createDIDWeb(vcWallet *wallet.Wallet, req *createDIDReq) (*did.DocResolution, error) {
+	u, err := url.Parse("https://" + req.Domain + "/" + strings.Trim(req.Path, "/"))
+	if err != nil || u.Host != req.Domain || u.RawQuery != "" || u.Fragment != "" {
+		return nil, fmt.Errorf("%w: invalid did:web domain or path", errInvalidDIDOperation)
+	}
+
+	didWeb := "did:web:" + strings.ReplaceAll(u.Host, ":", "%3A")
+
+	if path := strings.Trim(u.Path, "/"); path != "" {
+		didWeb += ":" + strings.ReplaceAll(path, "/", ":")
+	}
+
+	vm, err := newVerificationMethod(vcWallet, req.Auth, didWeb)
+	if err != nil {
+		return nil, err
+	}
+
+	return &did.DocResolution{
+		DIDDocument:      newDoc(didWeb, vm, req.Services),
+		DocumentMetadata: &did.DocumentMetadata{},
+	}, nil
+}
+
+// applyDIDUpdate applies the changes of an update to a DID document.
+func applyDIDUpdate(vcWallet *wallet.Wallet, req *updateDIDReq, doc *did.Doc) error {
+	switch didMethod(doc.ID) {
+	case didOrbMethod, didWebMethod:
+	default:
+		return fmt.Errorf("%w: did:%s DIDs cannot be updated", errInvalidDIDOperation, didMethod(doc.ID))
+	}
+
+	if req.AddVerificationMethod {
+		vm, err := newVerificationMethod(vcWallet, req.Auth, doc.ID)
+		if err != nil {
+			return err
+		}
+
+		doc.VerificationMethod = append(doc.VerificationMethod, *vm)
+		doc.Authentication = append(doc.Authentication, *did.NewReferencedVerification(vm, did.Authentication))
+		doc.AssertionMethod = append(doc.AssertionMethod, *did.NewReferencedVerification(vm, did.AssertionMethod))
+	}
+
+	if req.RotateVerificationMethod != "" {
+		err := rotateVerificationMethod(vcWallet, req.Auth, doc, req.RotateVerificationMethod)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := validateServices(req.Services); err != nil {
+		return fmt.Errorf("%w: %s", errInvalidDIDOperation, err.Error())
+	}
+
+	for _, service := range req.Services {
+		if j := serviceIndex(doc, service.ID); j >= 0 {
+			doc.Service[j] = service
+		} else {
+			doc.Service = append(doc.Service, service)
+		}
+	}
+
+	for _, id := range req.RemoveServices {
+		j := serviceIndex(doc, id)
+		if j < 0 {
+			return fmt.Errorf("%w: unknown service %s", errInvalidDIDOperation, id)
+		}
+
+		doc.Service = append(doc.Service[:j], doc.Service[j+1:]...)
+	}
+
+	return nil
+}
+
+// rotateVerificationMethod replaces a verification method of a DID document with a verification method of a new
+// key, with the same verification relationships.
+func rotateVerificationMethod(vcWallet *wallet.Wallet, auth string, doc *did.Doc, id string) error {
+	for i := range doc.VerificationMethod {
+		if doc.VerificationMethod[i].ID != id {
+			continue
+		}
+
+		vm, err := newVerificationMethod(vcWallet, auth, doc.ID)
+		if err != nil {
+			return err
+		}
+
+		doc.VerificationMethod[i] = *vm
+
+		for _, relationship := range []*[]did.Verification{
+			&doc.Authentication, &doc.AssertionMethod, &doc.CapabilityDelegation, &doc.CapabilityInvocation,
+			&doc.KeyAgreement,
+		} {
+			for j := range *relationship {
+				if (*relationship)[j].VerificationMethod.ID == id {
+					(*relationship)[j].VerificationMethod = *vm
+				}
+			}
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("%w: unknown verification method %s", errInvalidDIDOperation, id)
+}
+
+// newVerificationMethod returns an Ed25519 verification method of a DID with a new key of the keystore of the
+// wallet. The fragment of the ID of the verification method is the ID of the key, with which the wallet signs.
+func newVerificationMethod(vcWallet *wallet.Wallet, auth, didID string) (*did.VerificationMethod, error) {
+	keyID, pub, err := createKey(vcWallet, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	return did.NewVerificationMethodFromBytes(didID+"#"+keyID, ed25519VerificationKey, didID, pub), nil
+}
+
+// createKey creates an Ed25519 key in the keystore of the wallet, and returns its ID and public key.
+func createKey(vcWallet *wallet.Wallet, auth string) (string, ed25519.PublicKey, error) {
+	key, err := vcWallet.CreateKeyPair(auth, kms.ED25519Type)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create key: %w", err)
+	}
+
+	pub, err := base64.RawURLEncoding.DecodeString(key.PublicKey)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid public key: %w", err)
+	}
+
+	return key.KeyID, pub, nil
+}
+
+// validateServices checks that services have an ID, a type and a service endpoint.
+func validateServices(services []did.Service) error {
+	for i := range services {
+		if services[i].ID == "" || services[i].Type == "" {
+			return fmt.Errorf("service %d is missing an ID or type", i)
+		}
+
+		if _, err := services[i].ServiceEndpoint.URI(); err != nil {
+			return fmt.Errorf("service %d is missing an endpoint", i)
+		}
+	}
+
+	return nil
+}
+
+func newDoc(id string, vm *did.VerificationMethod, services []did.Service) *did.Doc {
+	return &did.Doc{
+		Context:            []string{did.ContextV1},
+		ID:                 id,
+		VerificationMethod: []did.VerificationMethod{*vm},
+		Authentication:     []did.Verification{*did.NewReferencedVerification(vm, did.Authentication)},
+		AssertionMethod:    []did.Verification{*did.NewReferencedVerification(vm, did.AssertionMethod)},
+		Service:            services,
+	}
+}
+
+// savedDID returns the resolution of a DID saved in the wallet.
+func savedDID(vcWallet *wallet.Wallet, auth, didID string) (*did.DocResolution, error) {
+	raw, err := vcWallet.Get(auth, wallet.DIDResolutionResponse, didID)
+	if err != nil {
+		return nil, err
+	}
+
+	resolution, err := did.ParseDocumentResolution(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid saved DID: %w", err)
+	}
+
+	if resolution.DocumentMetadata == nil {
+		resolution.DocumentMetadata = &did.DocumentMetadata{}
+	}
+
+	if resolution.DocumentMetadata.Deactivated {
+		return nil, fmt.Errorf("%w: DID is deactivated", errInvalidDIDOperation)
+	}
+
+	return resolution, nil
+}
+
+func saveDID(vcWallet *wallet.Wallet, auth string, resolution *did.DocResolution) (json.RawMessage, error) {
+	raw, err := resolution.JSONBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	err = vcWallet.Add(auth, wallet.DIDResolutionResponse, raw)
+	if err != nil {
+		return nil, err
+	}
+
+	return raw, nil
+}
+
+// replaceDID replaces the saved resolution of a DID, which the wallet does not overwrite.
+func replaceDID(vcWallet *wallet.Wallet, auth string, resolution *did.DocResolution) (json.RawMessage, error) {
+	err := vcWallet.Remove(auth, wallet.DIDResolutionResponse, resolution.DIDDocument.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return saveDID(vcWallet, auth, resolution)
+}
+
+func serviceIndex(doc *did.Doc, id string) int {
+	for i := range doc.Service {
+		if doc.Service[i].ID == id {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// didMethod returns the method of a DID.
+func didMethod(didID string) string {
+	parts := strings.SplitN(didID, ":", 3) // nolint:gomnd // did:<method>:<id>
+	if len(parts) < 3 || parts[0] != "did" {
+		return ""
+	}
+
+	return parts[1]
+}
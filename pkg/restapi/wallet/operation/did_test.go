@@ -0,0 +1,492 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation // nolint:testpackage // changing to different package requires exposing internal features.
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/hyperledger/aries-framework-go/pkg/common/model"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/did"
+	"github.com/hyperledger/aries-framework-go/pkg/framework/aries"
+	vdrapi "github.com/hyperledger/aries-framework-go/pkg/framework/aries/api/vdr"
+	mockvdr "github.com/hyperledger/aries-framework-go/pkg/mock/vdr"
+	"github.com/hyperledger/aries-framework-go/pkg/wallet"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOperation_DIDs(t *testing.T) {
+	t.Run("create, use and deactivate a did:key DID", func(t *testing.T) {
+		router := newRouter(t, newProvider(t))
+		auth := unlock(t, router)
+
+		resolution := createDID(t, router, &createDIDReq{walletAuth: auth, Method: didKeyMethod})
+		didKey := resolution.DIDDocument.ID
+		require.True(t, strings.HasPrefix(didKey, "did:key:"))
+
+		issueCredential(t, router, auth, didKey, wallet.Ed25519Signature2018,
+			fmt.Sprintf(sampleCredential, uuid.New().URN()))
+
+		rr := serve(router, http.MethodPost, listDIDsPath, &didReq{walletAuth: auth})
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		require.Contains(t, rr.Body.String(), didKey)
+
+		rr = serve(router, http.MethodPost, updateDIDPath, &updateDIDReq{
+			walletAuth: auth, DID: didKey, AddVerificationMethod: true,
+		})
+		require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+		requireErrorResponse(t, rr.Body.Bytes(), "did:key DIDs cannot be updated")
+
+		resolution = requireDID(t, router, deactivateDIDPath, &didReq{walletAuth: auth, DID: didKey})
+		require.True(t, resolution.DocumentMetadata.Deactivated)
+		require.Empty(t, resolution.DIDDocument.AssertionMethod)
+
+		resolution = requireDID(t, router, resolveDIDPath, &didReq{walletAuth: auth, DID: didKey})
+		require.True(t, resolution.DocumentMetadata.Deactivated)
+
+		rr = serve(router, http.MethodPost, issuePath, &issueReq{
+			proofReq: proofReq{
+				walletAuth:   auth,
+				ProofOptions: &wallet.ProofOptions{Controller: didKey},
+			},
+			Credential: json.RawMessage(fmt.Sprintf(sampleCredential, uuid.New().URN())),
+		})
+		require.NotEqual(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		rr = serve(router, http.MethodPost, deactivateDIDPath, &didReq{walletAuth: auth, DID: didKey})
+		require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+		requireErrorResponse(t, rr.Body.Bytes(), "DID is deactivated")
+	})
+
+	t.Run("create and update a did:web DID", func(t *testing.T) {
+		router := newRouter(t, newProvider(t))
+		auth := unlock(t, router)
+
+		resolution := createDID(t, router, &createDIDReq{
+			walletAuth: auth, Method: didWebMethod, Domain: "example.com:8443", Path: "/users/alice/",
+		})
+		didWeb := resolution.DIDDocument.ID
+		require.Equal(t, "did:web:example.com%3A8443:users:alice", didWeb)
+		require.Len(t, resolution.DIDDocument.VerificationMethod, 1)
+
+		issueCredential(t, router, auth, didWeb, wallet.Ed25519Signature2018,
+			fmt.Sprintf(sampleCredential, uuid.New().URN()))
+
+		resolution = requireDID(t, router, updateDIDPath, &updateDIDReq{
+			walletAuth: auth, DID: didWeb, AddVerificationMethod: true,
+			Services: []did.Service{{
+				ID: didWeb + "#hub", Type: "IdentityHub", ServiceEndpoint: model.NewDIDCommV1Endpoint("https://hub.example.com"),
+			}},
+		})
+		require.Len(t, resolution.DIDDocument.VerificationMethod, 2)
+		require.Len(t, resolution.DIDDocument.AssertionMethod, 2)
+		require.Len(t, resolution.DIDDocument.Service, 1)
+
+		rotated := resolution.DIDDocument.VerificationMethod[0].ID
+
+		resolution = requireDID(t, router, updateDIDPath, &updateDIDReq{
+			walletAuth: auth, DID: didWeb, RotateVerificationMethod: rotated,
+			Services: []did.Service{{
+				ID: didWeb + "#hub", Type: "LinkedDomains", ServiceEndpoint: model.NewDIDCommV1Endpoint("https://example.com"),
+			}},
+		})
+		require.Len(t, resolution.DIDDocument.VerificationMethod, 2)
+		require.NotEqual(t, rotated, resolution.DIDDocument.VerificationMethod[0].ID)
+		require.Equal(t, resolution.DIDDocument.VerificationMethod[0].ID,
+			resolution.DIDDocument.Authentication[0].VerificationMethod.ID)
+		require.Equal(t, "LinkedDomains", resolution.DIDDocument.Service[0].Type)
+
+		rr := serve(router, http.MethodPost, issuePath, &issueReq{
+			proofReq: proofReq{
+				walletAuth: auth,
+				ProofOptions: &wallet.ProofOptions{
+					Controller: didWeb, VerificationMethod: resolution.DIDDocument.VerificationMethod[0].ID,
+				},
+			},
+			Credential: json.RawMessage(fmt.Sprintf(sampleCredential, uuid.New().URN())),
+		})
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		resolution = requireDID(t, router, updateDIDPath, &updateDIDReq{
+			walletAuth: auth, DID: didWeb, RemoveServices: []string{didWeb + "#hub"},
+		})
+		require.Empty(t, resolution.DIDDocument.Service)
+
+		rr = serve(router, http.MethodPost, updateDIDPath, &updateDIDReq{
+			walletAuth: auth, DID: didWeb, RemoveServices: []string{didWeb + "#hub"},
+		})
+		require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+		requireErrorResponse(t, rr.Body.Bytes(), "unknown service")
+
+		rr = serve(router, http.MethodPost, updateDIDPath, &updateDIDReq{
+			walletAuth: auth, DID: didWeb, RotateVerificationMethod: rotated,
+		})
+		require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+		requireErrorResponse(t, rr.Body.Bytes(), "unknown verification method")
+
+		rr = serve(router, http.MethodPost, updateDIDPath, &updateDIDReq{
+			walletAuth: auth, DID: didWeb, Services: []did.Service{{ID: didWeb + "#other"}},
+		})
+		require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+		requireErrorResponse(t, rr.Body.Bytes(), "service 0 is missing an ID or type")
+
+		rr = serve(router, http.MethodPost, updateDIDPath, &updateDIDReq{
+			walletAuth: auth, DID: didWeb, Services: []did.Service{{ID: didWeb + "#other", Type: "LinkedDomains"}},
+		})
+		require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+		requireErrorResponse(t, rr.Body.Bytes(), "service 0 is missing an endpoint")
+	})
+
+	t.Run("create, update and deactivate a did:orb DID", func(t *testing.T) {
+		keys := NewDIDKeys()
+		orb := newOrbVDR(keys)
+		router := newRouter(t, newProvider(t, aries.WithVDR(orb)), WithDIDKeys(keys))
+		auth := unlock(t, router)
+
+		resolution := createDID(t, router, &createDIDReq{
+			walletAuth: auth, Method: didOrbMethod, AnchorOrigin: "https://orb.example.com",
+		})
+		didOrb := resolution.DIDDocument.ID
+		require.True(t, strings.HasPrefix(didOrb, "did:orb:"))
+		require.IsType(t, ed25519.PublicKey{}, orb.createOpts[orbUpdatePublicKeyOpt])
+		require.IsType(t, ed25519.PublicKey{}, orb.createOpts[orbRecoveryPublicKeyOpt])
+		require.Equal(t, "https://orb.example.com", orb.createOpts[orbAnchorOriginOpt])
+
+		rr := serve(router, http.MethodPost, getPath, &getContentReq{
+			contentAuth: contentAuth{walletAuth: auth, ContentType: wallet.Metadata},
+			ContentID:   didKeysMetadataPrefix + didOrb,
+		})
+		require.Equal(t, http.StatusNotFound, rr.Code, "DID keys recorded in the wallet")
+
+		issueCredential(t, router, auth, didOrb, wallet.Ed25519Signature2018,
+			fmt.Sprintf(sampleCredential, uuid.New().URN()))
+
+		resolution = requireDID(t, router, updateDIDPath, &updateDIDReq{
+			walletAuth: auth, DID: didOrb, AddVerificationMethod: true,
+		})
+		require.Len(t, resolution.DIDDocument.VerificationMethod, 2)
+		require.Equal(t, []string{didOrb}, orb.updated)
+
+		// the second update is signed with the key the first one committed to.
+		resolution = requireDID(t, router, updateDIDPath, &updateDIDReq{
+			walletAuth: auth, DID: didOrb, RotateVerificationMethod: resolution.DIDDocument.VerificationMethod[0].ID,
+		})
+		require.Len(t, resolution.DIDDocument.VerificationMethod, 2)
+		require.Equal(t, []string{didOrb, didOrb}, orb.updated)
+
+		resolution = requireDID(t, router, deactivateDIDPath, &didReq{walletAuth: auth, DID: didOrb})
+		require.True(t, resolution.DocumentMetadata.Deactivated)
+		require.Equal(t, []string{didOrb}, orb.deactivated)
+	})
+
+	t.Run("only update or deactivate the did:orb DIDs of the user", func(t *testing.T) {
+		keys := NewDIDKeys()
+		orb := newOrbVDR(keys)
+		router := newRouter(t, newProvider(t, aries.WithVDR(orb)), WithDIDKeys(keys))
+		owner, other := unlock(t, router), unlock(t, router)
+
+		resolution := createDID(t, router, &createDIDReq{walletAuth: owner, Method: didOrbMethod})
+		didOrb := resolution.DIDDocument.ID
+
+		raw, err := resolution.JSONBytes()
+		require.NoError(t, err)
+
+		rr := serve(router, http.MethodPost, addPath, &addContentReq{
+			contentAuth: contentAuth{walletAuth: other, ContentType: wallet.DIDResolutionResponse},
+			Content:     raw,
+		})
+		require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+
+		rr = serve(router, http.MethodPost, updateDIDPath, &updateDIDReq{
+			walletAuth: other, DID: didOrb, AddVerificationMethod: true,
+		})
+		require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+		requireErrorResponse(t, rr.Body.Bytes(), "is not a DID of user "+other.UserID)
+
+		rr = serve(router, http.MethodPost, deactivateDIDPath, &didReq{walletAuth: other, DID: didOrb})
+		require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+		requireErrorResponse(t, rr.Body.Bytes(), "is not a DID of user "+other.UserID)
+
+		rr = serve(router, http.MethodPost, deactivateDIDPath, &didReq{
+			walletAuth: walletAuth{UserID: owner.UserID, Auth: other.Auth}, DID: didOrb,
+		})
+		require.Equal(t, http.StatusUnauthorized, rr.Code, rr.Body.String())
+		require.Empty(t, orb.updated)
+		require.Empty(t, orb.deactivated)
+	})
+
+	t.Run("report VDR errors", func(t *testing.T) {
+		keys := NewDIDKeys()
+		orb := newOrbVDR(keys)
+		router := newRouter(t, newProvider(t, aries.WithVDR(orb)), WithDIDKeys(keys))
+		auth := unlock(t, router)
+
+		didOrb := createDID(t, router, &createDIDReq{walletAuth: auth, Method: didOrbMethod}).DIDDocument.ID
+
+		orb.CreateFunc = func(*did.Doc, ...vdrapi.DIDMethodOption) (*did.DocResolution, error) {
+			return nil, errors.New("anchor failed")
+		}
+		orb.UpdateFunc = func(*did.Doc, ...vdrapi.DIDMethodOption) error {
+			return errors.New("update failed")
+		}
+		orb.DeactivateFunc = func(string, ...vdrapi.DIDMethodOption) error {
+			return errors.New("deactivate failed")
+		}
+
+		rr := serve(router, http.MethodPost, createDIDPath, &createDIDReq{walletAuth: auth, Method: didOrbMethod})
+		require.Equal(t, http.StatusBadGateway, rr.Code, rr.Body.String())
+		requireErrorResponse(t, rr.Body.Bytes(), "anchor failed")
+
+		rr = serve(router, http.MethodPost, updateDIDPath, &updateDIDReq{
+			walletAuth: auth, DID: didOrb, AddVerificationMethod: true,
+		})
+		require.Equal(t, http.StatusBadGateway, rr.Code, rr.Body.String())
+		requireErrorResponse(t, rr.Body.Bytes(), "update failed")
+
+		rr = serve(router, http.MethodPost, deactivateDIDPath, &didReq{walletAuth: auth, DID: didOrb})
+		require.Equal(t, http.StatusBadGateway, rr.Code, rr.Body.String())
+		requireErrorResponse(t, rr.Body.Bytes(), "deactivate failed")
+
+		rr = serve(router, http.MethodPost, resolveDIDPath, &didReq{walletAuth: auth, DID: "did:orb:unknown"})
+		require.Equal(t, http.StatusNotFound, rr.Code, rr.Body.String())
+	})
+
+	t.Run("fail to update or deactivate a did:orb DID without its keys", func(t *testing.T) {
+		orb := newOrbVDR(NewDIDKeys())
+		router := newRouter(t, newProvider(t, aries.WithVDR(orb)))
+		auth := unlock(t, router)
+
+		didOrb := createDID(t, router, &createDIDReq{walletAuth: auth, Method: didOrbMethod}).DIDDocument.ID
+
+		rr := serve(router, http.MethodPost, updateDIDPath, &updateDIDReq{
+			walletAuth: auth, DID: didOrb, AddVerificationMethod: true,
+		})
+		require.Equal(t, http.StatusBadGateway, rr.Code, rr.Body.String())
+		requireErrorResponse(t, rr.Body.Bytes(), "no update of DID "+didOrb+" in progress")
+
+		rr = serve(router, http.MethodPost, deactivateDIDPath, &didReq{walletAuth: auth, DID: didOrb})
+		require.Equal(t, http.StatusBadGateway, rr.Code, rr.Body.String())
+		requireErrorResponse(t, rr.Body.Bytes(), "no deactivation of DID "+didOrb+" in progress")
+		require.Empty(t, orb.updated)
+		require.Empty(t, orb.deactivated)
+	})
+
+	t.Run("invalid requests", func(t *testing.T) {
+		router := newRouter(t, newProvider(t))
+		auth := unlock(t, router)
+
+		for _, path := range []string{createDIDPath, listDIDsPath, resolveDIDPath, updateDIDPath, deactivateDIDPath} {
+			rr := serve(router, http.MethodPost, path, "invalid")
+			require.Equal(t, http.StatusBadRequest, rr.Code)
+
+			rr = serve(router, http.MethodPost, path, &didReq{})
+			require.Equal(t, http.StatusBadRequest, rr.Code)
+			requireErrorResponse(t, rr.Body.Bytes(), "missing user ID or auth token")
+		}
+
+		for _, path := range []string{resolveDIDPath, updateDIDPath, deactivateDIDPath} {
+			rr := serve(router, http.MethodPost, path, &didReq{walletAuth: auth})
+			require.Equal(t, http.StatusBadRequest, rr.Code)
+			requireErrorResponse(t, rr.Body.Bytes(), "missing DID")
+		}
+
+		rr := serve(router, http.MethodPost, createDIDPath, &createDIDReq{walletAuth: auth, Method: "peer"})
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		requireErrorResponse(t, rr.Body.Bytes(), "unsupported DID method [peer]")
+
+		rr = serve(router, http.MethodPost, createDIDPath, &createDIDReq{walletAuth: auth, Method: didWebMethod})
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		requireErrorResponse(t, rr.Body.Bytes(), "missing domain of did:web DID")
+
+		rr = serve(router, http.MethodPost, createDIDPath, &createDIDReq{
+			walletAuth: auth, Method: didWebMethod, Domain: "example.com/users",
+		})
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		requireErrorResponse(t, rr.Body.Bytes(), "invalid did:web domain or path")
+
+		rr = serve(router, http.MethodPost, createDIDPath, &createDIDReq{
+			walletAuth: auth, Method: didKeyMethod, Services: []did.Service{{ID: "#hub", Type: "IdentityHub"}},
+		})
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		requireErrorResponse(t, rr.Body.Bytes(), "did:key DIDs cannot have services")
+
+		rr = serve(router, http.MethodPost, createDIDPath, &createDIDReq{
+			walletAuth: walletAuth{UserID: auth.UserID, Auth: "invalid"}, Method: didKeyMethod,
+		})
+		require.Equal(t, http.StatusUnauthorized, rr.Code, rr.Body.String())
+
+		rr = serve(router, http.MethodPost, updateDIDPath, &updateDIDReq{walletAuth: auth, DID: "did:web:example.com"})
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		requireErrorResponse(t, rr.Body.Bytes(), "missing DID update")
+
+		rr = serve(router, http.MethodPost, updateDIDPath, &updateDIDReq{
+			walletAuth: auth, DID: "did:web:example.com", AddVerificationMethod: true,
+		})
+		require.Equal(t, http.StatusNotFound, rr.Code, rr.Body.String())
+
+		rr = serve(router, http.MethodPost, deactivateDIDPath, &didReq{walletAuth: auth, DID: "did:web:example.com"})
+		require.Equal(t, http.StatusNotFound, rr.Code, rr.Body.String())
+	})
+}
+
+func createDID(t *testing.T, router *mux.Router, req *createDIDReq) *did.DocResolution {
+	t.Helper()
+
+	return requireDID(t, router, createDIDPath, req)
+}
+
+// requireDID serves a DID request, and returns the resolution of the DID in the response.
+func requireDID(t *testing.T, router *mux.Router, path string, req interface{}) *did.DocResolution {
+	t.Helper()
+
+	rr := serve(router, http.MethodPost, path, req)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	resp := &didResp{}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), resp))
+
+	resolution, err := did.ParseDocumentResolution(resp.Resolution)
+	require.NoError(t, err)
+	require.Equal(t, resp.DID, resolution.DIDDocument.ID)
+
+	return resolution
+}
+
+// orbVDR creates did:orb DIDs in memory, recording the options of the last creation, and the updated and
+// deactivated DIDs. Like the orb VDR, it signs updates and deactivations with the signers of the DID keys, and checks
+// the signatures against the update and recovery keys committed to.
+type orbVDR struct {
+	*mockvdr.MockVDR
+	keys         *DIDKeys
+	docs         map[string]*did.Doc
+	updateKeys   map[string]ed25519.PublicKey
+	recoveryKeys map[string]ed25519.PublicKey
+	createOpts   map[string]interface{}
+	updated      []string
+	deactivated  []string
+}
+
+func newOrbVDR(keys *DIDKeys) *orbVDR {
+	v := &orbVDR{
+		MockVDR:      &mockvdr.MockVDR{},
+		keys:         keys,
+		docs:         map[string]*did.Doc{},
+		updateKeys:   map[string]ed25519.PublicKey{},
+		recoveryKeys: map[string]ed25519.PublicKey{},
+	}
+
+	v.CreateFunc = v.create
+	v.ReadFunc = v.read
+	v.UpdateFunc = v.update
+	v.DeactivateFunc = v.deactivate
+
+	return v
+}
+
+func (v *orbVDR) Accept(method string) bool {
+	return method == didOrbMethod
+}
+
+func (v *orbVDR) create(doc *did.Doc, opts ...vdrapi.DIDMethodOption) (*did.DocResolution, error) {
+	methodOpts := &vdrapi.DIDMethodOpts{Values: map[string]interface{}{}}
+
+	for _, opt := range opts {
+		opt(methodOpts)
+	}
+
+	v.createOpts = methodOpts.Values
+
+	doc.ID = "did:orb:" + uuid.New().String()
+
+	v.updateKeys[doc.ID], _ = methodOpts.Values[orbUpdatePublicKeyOpt].(ed25519.PublicKey)
+	v.recoveryKeys[doc.ID], _ = methodOpts.Values[orbRecoveryPublicKeyOpt].(ed25519.PublicKey)
+
+	for i := range doc.VerificationMethod {
+		doc.VerificationMethod[i].ID = doc.ID + doc.VerificationMethod[i].ID
+		doc.VerificationMethod[i].Controller = doc.ID
+	}
+
+	doc.Authentication = []did.Verification{
+		*did.NewReferencedVerification(&doc.VerificationMethod[0], did.Authentication),
+	}
+	doc.AssertionMethod = []did.Verification{
+		*did.NewReferencedVerification(&doc.VerificationMethod[0], did.AssertionMethod),
+	}
+
+	v.docs[doc.ID] = doc
+
+	return &did.DocResolution{DIDDocument: doc, DocumentMetadata: &did.DocumentMetadata{}}, nil
+}
+
+func (v *orbVDR) read(id string, _ ...vdrapi.DIDMethodOption) (*did.DocResolution, error) {
+	doc, ok := v.docs[id]
+	if !ok {
+		return nil, vdrapi.ErrNotFound
+	}
+
+	return &did.DocResolution{DIDDocument: doc}, nil
+}
+
+func (v *orbVDR) update(doc *did.Doc, _ ...vdrapi.DIDMethodOption) error {
+	signer, err := v.keys.UpdateSigner(doc.ID)
+	if err != nil {
+		return err
+	}
+
+	next, err := v.keys.NextUpdatePublicKey(doc.ID)
+	if err != nil {
+		return err
+	}
+
+	err = verifyOperation(signer, v.updateKeys[doc.ID], doc.ID)
+	if err != nil {
+		return err
+	}
+
+	v.updateKeys[doc.ID] = next
+	v.updated = append(v.updated, doc.ID)
+
+	return nil
+}
+
+func (v *orbVDR) deactivate(id string, _ ...vdrapi.DIDMethodOption) error {
+	signer, err := v.keys.RecoverySigner(id)
+	if err != nil {
+		return err
+	}
+
+	err = verifyOperation(signer, v.recoveryKeys[id], id)
+	if err != nil {
+		return err
+	}
+
+	v.deactivated = append(v.deactivated, id)
+
+	return nil
+}
+
+// verifyOperation checks that the signer signs the operation of a DID with the key committed to.
+func verifyOperation(signer *DIDKeySigner, committed ed25519.PublicKey, id string) error {
+	data := []byte("operation of " + id)
+
+	sig, err := signer.Sign(data)
+	if err != nil {
+		return err
+	}
+
+	if !ed25519.Verify(committed, data, sig) {
+		return errors.New("invalid signature")
+	}
+
+	return nil
+}
@@ -0,0 +1,261 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/hyperledger/aries-framework-go/pkg/crypto"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/did"
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+	"github.com/hyperledger/aries-framework-go/pkg/wallet"
+
+	"github.com/trustbloc/wallet/pkg/restapi/common/store"
+)
+
+// DIDKeys gives the orb VDR the keys of the did:orb DID of a wallet while the wallet updates or deactivates the DID.
+// The orb VDR of the agent is created with a key retriever calling DIDKeys, which is given to the wallet operations
+// with WithDIDKeys.
+//
+// The update and recovery keys of did:orb DIDs are kept in the agent KMS, since the keystores of wallets do not expose
+// signers.
+type DIDKeys struct {
+	mutex      sync.Mutex
+	operations map[string]*didKeysOperation
+}
+
+// didKeysOperation holds the keys of a DID operation: the signer of the update key and the next update key of an
+// update, or the signer of the recovery key of a deactivation.
+type didKeysOperation struct {
+	update     *DIDKeySigner
+	nextUpdate ed25519.PublicKey
+	recovery   *DIDKeySigner
+}
+
+// NewDIDKeys returns the keys of the did:orb DIDs of wallets, for the orb VDR.
+func NewDIDKeys() *DIDKeys {
+	return &DIDKeys{operations: map[string]*didKeysOperation{}}
+}
+
+// NextUpdatePublicKey returns the public key committed to for the next update of a DID being updated.
+func (k *DIDKeys) NextUpdatePublicKey(didID string) (ed25519.PublicKey, error) {
+	op, err := k.operation(didID)
+	if err != nil || op.nextUpdate == nil {
+		return nil, fmt.Errorf("no update of DID %s in progress", didID)
+	}
+
+	return op.nextUpdate, nil
+}
+
+// NextRecoveryPublicKey returns the public key committed to for the next recovery of a DID being recovered. Wallets
+// do not recover DIDs.
+func (k *DIDKeys) NextRecoveryPublicKey(didID string) (ed25519.PublicKey, error) {
+	return nil, fmt.Errorf("recovery of DID %s is not supported", didID)
+}
+
+// UpdateSigner returns the signer of the update key of a DID being updated.
+func (k *DIDKeys) UpdateSigner(didID string) (*DIDKeySigner, error) {
+	op, err := k.operation(didID)
+	if err != nil || op.update == nil {
+		return nil, fmt.Errorf("no update of DID %s in progress", didID)
+	}
+
+	return op.update, nil
+}
+
+// RecoverySigner returns the signer of the recovery key of a DID being deactivated.
+func (k *DIDKeys) RecoverySigner(didID string) (*DIDKeySigner, error) {
+	op, err := k.operation(didID)
+	if err != nil || op.recovery == nil {
+		return nil, fmt.Errorf("no deactivation of DID %s in progress", didID)
+	}
+
+	return op.recovery, nil
+}
+
+func (k *DIDKeys) operation(didID string) (*didKeysOperation, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	op, ok := k.operations[didID]
+	if !ok {
+		return nil, fmt.Errorf("no operation of DID %s in progress", didID)
+	}
+
+	return op, nil
+}
+
+// hold gives the keys of an operation of a DID to the orb VDR until release is called. Only one operation of a DID is
+// held at a time.
+func (k *DIDKeys) hold(didID string, op *didKeysOperation) (func(), error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	if _, ok := k.operations[didID]; ok {
+		return nil, fmt.Errorf("%w: another operation of DID %s is in progress", errInvalidDIDOperation, didID)
+	}
+
+	k.operations[didID] = op
+
+	return func() {
+		k.mutex.Lock()
+		defer k.mutex.Unlock()
+
+		delete(k.operations, didID)
+	}, nil
+}
+
+// DIDKeySigner signs DID operations with an Ed25519 key of the agent KMS.
+type DIDKeySigner struct {
+	crypto    crypto.Crypto
+	kh        interface{}
+	publicKey ed25519.PublicKey
+}
+
+// Sign returns the Ed25519 signature of data.
+func (s *DIDKeySigner) Sign(data []byte) ([]byte, error) {
+	return s.crypto.Sign(data, s.kh)
+}
+
+// PublicKey returns the public key of the signer.
+func (s *DIDKeySigner) PublicKey() ed25519.PublicKey {
+	return s.publicKey
+}
+
+// updateDIDOrb publishes the update of a did:orb DID of the user with the orb VDR, signed with the update key of the
+// DID. The next update of the DID is committed to a new key, which replaces the update key recorded for the DID.
+func (o *Operation) updateDIDOrb(vcWallet *wallet.Wallet, auth *walletAuth, doc *did.Doc) error {
+	keys, err := o.savedDIDKeys(vcWallet, auth, doc.ID)
+	if err != nil {
+		return err
+	}
+
+	signer, err := o.didKeySigner(keys.UpdateKeyID)
+	if err != nil {
+		return fmt.Errorf("update key: %w", err)
+	}
+
+	nextKeyID, nextKey, err := o.createDIDKeyPair()
+	if err != nil {
+		return err
+	}
+
+	release, err := o.didKeys.hold(doc.ID, &didKeysOperation{update: signer, nextUpdate: nextKey})
+	if err != nil {
+		return err
+	}
+
+	err = o.ctx.VDRegistry().Update(doc)
+
+	release()
+
+	if err != nil {
+		return fmt.Errorf("%w: %s", errVDR, err.Error())
+	}
+
+	keys.UpdateKeyID = nextKeyID
+
+	err = store.Save(o.didKeyStore, doc.ID, keys)
+	if err != nil {
+		// the DID can no longer be updated without the ID of the key its next update is committed to.
+		logger.Errorf("failed to save next update key %s of DID %s: %s", nextKeyID, doc.ID, err.Error())
+
+		return fmt.Errorf("failed to save DID keys: %w", err)
+	}
+
+	return nil
+}
+
+// deactivateDIDOrb publishes the deactivation of a did:orb DID of the user with the orb VDR, signed with the recovery
+// key of the DID.
+func (o *Operation) deactivateDIDOrb(vcWallet *wallet.Wallet, auth *walletAuth, didID string) error {
+	keys, err := o.savedDIDKeys(vcWallet, auth, didID)
+	if err != nil {
+		return err
+	}
+
+	signer, err := o.didKeySigner(keys.RecoveryKeyID)
+	if err != nil {
+		return fmt.Errorf("recovery key: %w", err)
+	}
+
+	release, err := o.didKeys.hold(didID, &didKeysOperation{recovery: signer})
+	if err != nil {
+		return err
+	}
+
+	err = o.ctx.VDRegistry().Deactivate(didID)
+
+	release()
+
+	if err != nil {
+		return fmt.Errorf("%w: %s", errVDR, err.Error())
+	}
+
+	return nil
+}
+
+// createDIDKeyPair creates an Ed25519 key in the agent KMS for the operations of a did:orb DID, and returns its ID
+// and public key.
+func (o *Operation) createDIDKeyPair() (string, ed25519.PublicKey, error) {
+	keyID, pub, err := o.ctx.KMS().CreateAndExportPubKeyBytes(kms.ED25519Type)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create DID key: %w", err)
+	}
+
+	return keyID, pub, nil
+}
+
+// didKeySigner returns the signer of a key of the agent KMS.
+func (o *Operation) didKeySigner(keyID string) (*DIDKeySigner, error) {
+	pub, _, err := o.ctx.KMS().ExportPubKeyBytes(keyID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export key %s: %w", keyID, err)
+	}
+
+	kh, err := o.ctx.KMS().Get(keyID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get key %s: %w", keyID, err)
+	}
+
+	return &DIDKeySigner{crypto: o.ctx.Crypto(), kh: kh, publicKey: pub}, nil
+}
+
+// savedDIDKeys returns the keys recorded for a did:orb DID, if the DID is owned by the user and the auth token is the
+// token of the wallet of the user.
+func (o *Operation) savedDIDKeys(vcWallet *wallet.Wallet, auth *walletAuth, didID string) (*didKeys, error) {
+	err := o.checkWalletToken(vcWallet, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := o.didKeyStore.Get(didID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get DID keys: %w", err)
+	}
+
+	keys := &didKeys{}
+
+	err = json.Unmarshal(raw, keys)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DID keys: %w", err)
+	}
+
+	if keys.UserID != auth.UserID {
+		return nil, fmt.Errorf("%w: DID %s is not a DID of user %s", errInvalidDIDOperation, didID, auth.UserID)
+	}
+
+	if keys.UpdateKeyID == "" || keys.RecoveryKeyID == "" {
+		return nil, errors.New("invalid DID keys: missing update or recovery key")
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,112 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/hyperledger/aries-framework-go/pkg/controller/rest"
+	"github.com/hyperledger/aries-framework-go/pkg/controller/webnotifier"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/common/service"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/protocol/didexchange"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/protocol/issuecredential"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/protocol/outofband"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/protocol/presentproof"
+)
+
+// suffixes of the notifier topics of the DIDComm events of a protocol.
+const (
+	actionsTopicSuffix = "_actions"
+	statesTopicSuffix  = "_states"
+)
+
+// eventProtocols are the DIDComm protocols whose events are published on the notifier.
+// nolint:gochecknoglobals
+var eventProtocols = []string{didexchange.DIDExchange, presentproof.Name, issuecredential.Name, outofband.Name}
+
+// observeEvents publishes the action and state events of the DIDComm protocols on the notifier, with the topics
+// <protocol>_actions and <protocol>_states. Actions wait for the wallet flows to continue or stop them, except DID
+// exchange actions, which are accepted as they come.
+func (o *Operation) observeEvents() error {
+	observer := webnotifier.NewObserver(o.notifier)
+
+	for _, name := range eventProtocols {
+		svc, err := o.ctx.Service(name)
+		if err != nil {
+			return fmt.Errorf("failed to look up %s service: %w", name, err)
+		}
+
+		events, ok := svc.(service.Event)
+		if !ok {
+			return fmt.Errorf("%s service does not publish events", name)
+		}
+
+		actions := make(chan service.DIDCommAction)
+
+		err = events.RegisterActionEvent(actions)
+		if err != nil {
+			return fmt.Errorf("failed to register %s action events: %w", name, err)
+		}
+
+		states := make(chan service.StateMsg)
+
+		err = events.RegisterMsgEvent(states)
+		if err != nil {
+			return fmt.Errorf("failed to register %s state events: %w", name, err)
+		}
+
+		if name == didexchange.DIDExchange {
+			observer.RegisterAction(name+actionsTopicSuffix, autoExecute(actions))
+		} else {
+			observer.RegisterAction(name+actionsTopicSuffix, actions)
+		}
+
+		observer.RegisterStateMsg(name+statesTopicSuffix, states)
+	}
+
+	return nil
+}
+
+// autoExecute continues every action, and returns a channel of the continued actions.
+func autoExecute(actions <-chan service.DIDCommAction) <-chan service.DIDCommAction {
+	executed := make(chan service.DIDCommAction)
+	continued := make(chan service.DIDCommAction)
+
+	go service.AutoExecuteActionEvent(continued)
+
+	go func() {
+		for action := range actions {
+			published := action
+
+			if action.Message != nil {
+				published.Message = action.Message.Clone()
+			}
+
+			continued <- action
+			executed <- published
+		}
+	}()
+
+	return executed
+}
+
+// registerService registers a message service on the message handler. Messages received by the service are published
+// on the notifier with the name of the service as topic.
+func (o *Operation) registerService(w http.ResponseWriter, r *http.Request) {
+	rest.Execute(o.messaging.RegisterService, w, r.Body)
+}
+
+// unregisterService unregisters a message service from the message handler.
+func (o *Operation) unregisterService(w http.ResponseWriter, r *http.Request) {
+	rest.Execute(o.messaging.UnregisterService, w, r.Body)
+}
+
+// services returns the names of the message services registered on the message handler.
+func (o *Operation) services(w http.ResponseWriter, r *http.Request) {
+	rest.Execute(o.messaging.Services, w, r.Body)
+}
@@ -0,0 +1,149 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation // nolint:testpackage // changing to different package requires exposing internal features.
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/pkg/controller/command/messaging"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/common/service"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/messaging/msghandler"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/protocol/didexchange"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/wallet/pkg/restapi/internal/mocks"
+)
+
+func TestOperation_Events(t *testing.T) {
+	t.Run("publish DIDComm events on the notifier", func(t *testing.T) {
+		p := newProvider(t)
+		notifier, topics := newTopicNotifier()
+
+		_, err := New(p, notifier, nil)
+		require.NoError(t, err)
+
+		for _, name := range eventProtocols {
+			svc, err := p.Service(name)
+			require.NoError(t, err)
+
+			events, ok := svc.(interface {
+				ActionEvent() chan<- service.DIDCommAction
+				MsgEvents() []chan<- service.StateMsg
+			})
+			require.True(t, ok)
+
+			continued := make(chan struct{})
+
+			events.ActionEvent() <- service.DIDCommAction{
+				ProtocolName: name,
+				Message:      service.NewDIDCommMsgMap(struct{}{}),
+				Continue:     func(interface{}) { close(continued) },
+				Stop:         func(error) {},
+			}
+			require.Equal(t, name+actionsTopicSuffix, receive(t, topics))
+
+			if name == didexchange.DIDExchange {
+				select {
+				case <-continued:
+				case <-time.After(time.Second):
+					require.Fail(t, "DID exchange action was not continued")
+				}
+			}
+
+			msgEvents := events.MsgEvents()
+			msgEvents[len(msgEvents)-1] <- service.StateMsg{ProtocolName: name, Type: service.PostState, StateID: "done"}
+			require.Equal(t, name+statesTopicSuffix, receive(t, topics))
+		}
+	})
+
+	t.Run("error if events are already observed", func(t *testing.T) {
+		p := newProvider(t)
+		notifier, _ := newTopicNotifier()
+
+		_, err := New(p, notifier, nil)
+		require.NoError(t, err)
+
+		_, err = New(p, notifier, nil)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to register didexchange action events")
+	})
+}
+
+func TestOperation_MessageServices(t *testing.T) {
+	t.Run("register and unregister message services", func(t *testing.T) {
+		notifier, _ := newTopicNotifier()
+		registrar := msghandler.NewRegistrar()
+
+		op, err := New(newProvider(t), notifier, registrar)
+		require.NoError(t, err)
+		require.Len(t, op.GetRESTHandlers(), 43)
+		require.Len(t, op.GetServiceRESTHandlers(), 3)
+
+		router := routerOf(op)
+
+		rr := serve(router, http.MethodPost, registerServicePath, &messaging.RegisterMsgSvcArgs{
+			Name: "generic-invite",
+			Type: "https://didcomm.org/generic/1.0/message",
+		})
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		require.Len(t, registrar.Services(), 1)
+
+		rr = serve(router, http.MethodGet, servicesPath, nil)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		services := &messaging.RegisteredServicesResponse{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), services))
+		require.Equal(t, []string{"generic-invite"}, services.Names)
+
+		rr = serve(router, http.MethodPost, unregisterServicePath, &messaging.UnregisterMsgSvcArgs{
+			Name: "generic-invite",
+		})
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		require.Empty(t, registrar.Services())
+	})
+
+	t.Run("error if the service is invalid", func(t *testing.T) {
+		notifier, _ := newTopicNotifier()
+
+		op, err := New(newProvider(t), notifier, msghandler.NewRegistrar())
+		require.NoError(t, err)
+
+		router := routerOf(op)
+
+		rr := serve(router, http.MethodPost, registerServicePath, "invalid")
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+
+		rr = serve(router, http.MethodPost, unregisterServicePath, &messaging.UnregisterMsgSvcArgs{Name: "unknown"})
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+	})
+}
+
+// newTopicNotifier returns a notifier sending the topic of every notification to the returned channel.
+func newTopicNotifier() (*mocks.Notifier, chan string) {
+	topics := make(chan string, len(eventProtocols))
+
+	return &mocks.Notifier{NotifyFunc: func(topic string, _ []byte) error {
+		topics <- topic
+
+		return nil
+	}}, topics
+}
+
+func receive(t *testing.T, topics chan string) string {
+	t.Helper()
+
+	select {
+	case topic := <-topics:
+		return topic
+	case <-time.After(time.Second):
+		require.Fail(t, "no notification")
+
+		return ""
+	}
+}
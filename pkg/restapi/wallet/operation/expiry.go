@@ -0,0 +1,447 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/pkg/doc/verifiable"
+	"github.com/hyperledger/aries-framework-go/pkg/wallet"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+
+	"github.com/trustbloc/wallet/pkg/restapi/common"
+)
+
+const (
+	// expiryStoreName is the name of the store tracking the validity windows of the credentials stored in wallets.
+	expiryStoreName = "wallet_credential_expiries"
+	expiryUserTag   = "user"
+
+	// expiryTopic is the notifier topic of the expiries found by the expiry sweep.
+	expiryTopic = "credential_expiry"
+
+	// protocols of the issuances through which credentials are renewed.
+	issuedByOIDC = "oidc4ci"
+	issuedByWACI = "waci"
+
+	day = 24 * time.Hour
+)
+
+// default lead times of the expiry notifications.
+const (
+	firstExpiryNotice  = 30 * day
+	secondExpiryNotice = 7 * day
+	lastExpiryNotice   = day
+)
+
+// expiringCredentials lists the credentials of an unlocked wallet expiring within a number of days, or already
+// expired, in the order they expire.
+func (o *Operation) expiringCredentials(w http.ResponseWriter, r *http.Request) {
+	req := &expiringCredentialsReq{}
+
+	if !decodeWalletRequest(w, r, req, &req.walletAuth) {
+		return
+	}
+
+	if req.Days < 0 {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "invalid number of days [%d]", req.Days)
+
+		return
+	}
+
+	if _, ok := o.openUnlockedWallet(w, req.UserID, req.Auth); !ok {
+		return
+	}
+
+	within := o.expiryLeadTimes[0]
+	if req.Days > 0 {
+		within = time.Duration(req.Days) * day
+	}
+
+	records, err := o.expiryRecords(expiryUserTag + ":" + userTag(req.UserID))
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "failed to list expiries: %s",
+			err.Error())
+
+		return
+	}
+
+	deadline := time.Now().Add(within)
+	credentials := []*credentialExpiry{}
+
+	for _, record := range records {
+		if record.ExpirationDate.Before(deadline) {
+			credentials = append(credentials, &record.credentialExpiry)
+		}
+	}
+
+	sort.Slice(credentials, func(i, j int) bool {
+		return credentials[i].ExpirationDate.Before(credentials[j].ExpirationDate)
+	})
+
+	common.WriteResponse(w, logger, &expiringCredentialsResp{Credentials: credentials})
+}
+
+// renewCredential restarts the OIDC or WACI issuance a credential stored in an unlocked wallet comes from, with its
+// original issuer. OIDC issuances continue with the authorization of the user at the issuer and the issuance
+// callback, and WACI issuances with the request of the credential offered. The renewed credential replaces the
+// credential it renews if issued with the same ID, and is saved alongside it otherwise.
+func (o *Operation) renewCredential(w http.ResponseWriter, r *http.Request) {
+	req := &renewCredentialReq{}
+
+	if !decodeWalletRequest(w, r, req, &req.walletAuth) {
+		return
+	}
+
+	if req.CredentialID == "" {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing credential ID")
+
+		return
+	}
+
+	vcWallet, ok := o.openUnlockedWallet(w, req.UserID, req.Auth)
+	if !ok {
+		return
+	}
+
+	record, err := o.expiryRecord(req.UserID, req.CredentialID)
+	if errors.Is(err, storage.ErrDataNotFound) {
+		common.WriteErrorResponsef(w, logger, http.StatusNotFound, "no tracked expiry for credential [%s]",
+			req.CredentialID)
+
+		return
+	} else if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "failed to get expiry: %s",
+			err.Error())
+
+		return
+	}
+
+	origin := record.Origin
+
+	switch {
+	case origin != nil && origin.Protocol == issuedByOIDC:
+		authURL, ok := o.startIssuance(w, &initiateIssuanceReq{
+			walletAuth:  req.walletAuth,
+			ClientID:    origin.ClientID,
+			RedirectURI: origin.RedirectURI,
+		}, url.Values{
+			"issuer":          {origin.Issuer},
+			"credential_type": origin.CredentialTypes,
+			"manifest_id":     origin.ManifestIDs,
+		}, req.CredentialID)
+		if !ok {
+			return
+		}
+
+		common.WriteResponse(w, logger, &renewCredentialResp{Protocol: issuedByOIDC, AuthorizationURL: authURL})
+	case origin != nil && origin.Protocol == issuedByWACI:
+		offer, ok := o.offerCredential(w, vcWallet, &proposeCredentialReq{
+			walletAuth: req.walletAuth,
+			Invitation: origin.Invitation,
+			Timeout:    req.Timeout,
+		}, req.CredentialID)
+		if !ok {
+			return
+		}
+
+		common.WriteResponse(w, logger, &renewCredentialResp{Protocol: issuedByWACI, Offer: offer})
+	default:
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest,
+			"credential [%s] was not issued through OIDC or WACI", req.CredentialID)
+	}
+}
+
+// trackCredential starts tracking the status and expiry of a credential stored in the wallet of a user. Origin is the
+// issuance the credential comes from, if known.
+func (o *Operation) trackCredential(userID string, raw json.RawMessage, origin *credentialOrigin) {
+	vc, err := verifiable.ParseCredential(raw, verifiable.WithDisabledProofCheck(),
+		verifiable.WithNoCustomSchemaCheck(), verifiable.WithJSONLDDocumentLoader(o.ctx.JSONLDDocumentLoader()))
+	if err != nil {
+		return
+	}
+
+	o.trackStatus(userID, vc)
+	o.trackExpiry(userID, vc, origin)
+}
+
+// untrackCredential stops tracking the status and expiry of a credential removed from the wallet of a user.
+func (o *Operation) untrackCredential(userID, credentialID string) {
+	o.untrackStatus(userID, credentialID)
+
+	err := o.expiryStore.Delete(credentialKey(userID, credentialID))
+	if err != nil && !errors.Is(err, storage.ErrDataNotFound) {
+		logger.Warnf("failed to untrack expiry of credential %s: %s", credentialID, err.Error())
+	}
+}
+
+// trackExpiry starts tracking the expiry of a credential stored in the wallet of a user, if it has an expiration
+// date. A credential stored again keeps its known origin, and its notifications if its expiration date is unchanged.
+// Failing to track an expiry does not fail storing the credential.
+func (o *Operation) trackExpiry(userID string, vc *verifiable.Credential, origin *credentialOrigin) {
+	if vc.Expired == nil {
+		return
+	}
+
+	record := &expiryRecord{
+		UserID: userID,
+		credentialExpiry: credentialExpiry{
+			CredentialID:   vc.ID,
+			Issuer:         vc.Issuer.ID,
+			ExpirationDate: vc.Expired.Time.UTC(),
+		},
+		Origin: origin,
+	}
+
+	if vc.Issued != nil {
+		issued := vc.Issued.Time.UTC()
+		record.IssuanceDate = &issued
+	}
+
+	if previous, err := o.expiryRecord(userID, vc.ID); err == nil {
+		if record.Origin == nil {
+			record.Origin = previous.Origin
+		}
+
+		if record.ExpirationDate.Equal(previous.ExpirationDate) {
+			record.NotifiedLeadTime = previous.NotifiedLeadTime
+			record.NotifiedExpired = previous.NotifiedExpired
+		}
+	}
+
+	if record.Origin != nil {
+		record.Renewal = record.Origin.Protocol
+	}
+
+	err := o.saveExpiry(record)
+	if err != nil {
+		logger.Warnf("failed to track expiry of credential %s: %s", vc.ID, err.Error())
+	}
+}
+
+// sweepExpiries publishes on the notifier the tracked credentials expiring within a lead time they were not notified
+// of yet. Credentials are only notified of the shortest lead time they expire within. Credentials found expired are
+// notified once as expired, whichever lead times they were notified of.
+func (o *Operation) sweepExpiries() {
+	records, err := o.expiryRecords(expiryUserTag)
+	if err != nil {
+		logger.Errorf("expiry sweep: failed to list tracked credentials: %s", err.Error())
+
+		return
+	}
+
+	now := time.Now()
+
+	for _, record := range records {
+		left := record.ExpirationDate.Sub(now)
+
+		switch {
+		case left <= 0:
+			if record.NotifiedExpired {
+				continue
+			}
+
+			o.notifyExpiry(record, 0)
+
+			record.NotifiedExpired = true
+		default:
+			leadTime := o.expiryLeadTime(left)
+			if leadTime == 0 || (record.NotifiedLeadTime != 0 && record.NotifiedLeadTime <= leadTime) {
+				continue
+			}
+
+			o.notifyExpiry(record, leadTime)
+
+			record.NotifiedLeadTime = leadTime
+		}
+
+		err = o.saveExpiry(record)
+		if err != nil {
+			logger.Errorf("expiry sweep: failed to save expiry of credential %s: %s", record.CredentialID,
+				err.Error())
+		}
+	}
+}
+
+// backfillExpiries tracks the expiries of the credentials of a wallet unlocked with the auth token that are not
+// tracked yet: credentials stored before expiries were tracked, or imported by the VC wallet without the wallet
+// operations.
+func (o *Operation) backfillExpiries(userID, auth string) {
+	vcWallet, err := wallet.New(userID, o.ctx)
+	if err != nil {
+		logger.Warnf("failed to backfill expiries of user %s: %s", userID, err.Error())
+
+		return
+	}
+
+	credentials, err := vcWallet.GetAll(auth, wallet.Credential)
+	if err != nil {
+		logger.Warnf("failed to backfill expiries of user %s: %s", userID, err.Error())
+
+		return
+	}
+
+	for id, raw := range credentials {
+		if _, err = o.expiryRecord(userID, id); !errors.Is(err, storage.ErrDataNotFound) {
+			continue
+		}
+
+		vc, parseErr := verifiable.ParseCredential(raw, verifiable.WithDisabledProofCheck(),
+			verifiable.WithNoCustomSchemaCheck(), verifiable.WithJSONLDDocumentLoader(o.ctx.JSONLDDocumentLoader()))
+		if parseErr != nil {
+			continue
+		}
+
+		o.trackExpiry(userID, vc, nil)
+	}
+}
+
+// sweepExpiriesEvery runs the expiry sweep periodically, until the operations are closed.
+func (o *Operation) sweepExpiriesEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-o.done:
+			return
+		case <-ticker.C:
+			o.sweepExpiries()
+		}
+	}
+}
+
+// expiryLeadTime returns the shortest lead time within which a credential expiring after a duration expires, or zero
+// if it expires after the longest lead time.
+func (o *Operation) expiryLeadTime(left time.Duration) time.Duration {
+	var leadTime time.Duration
+
+	for _, l := range o.expiryLeadTimes {
+		if left <= l {
+			leadTime = l
+		}
+	}
+
+	return leadTime
+}
+
+// notifyExpiry publishes the expiry of a credential within a lead time, or its expiry if the lead time is zero.
+func (o *Operation) notifyExpiry(record *expiryRecord, leadTime time.Duration) {
+	if o.notifier == nil {
+		return
+	}
+
+	msg, err := json.Marshal(&expiryEvent{
+		UserID:         record.UserID,
+		CredentialID:   record.CredentialID,
+		ExpirationDate: record.ExpirationDate,
+		LeadDays:       int(leadTime / day),
+		Expired:        leadTime == 0,
+		Renewal:        record.Renewal,
+	})
+	if err != nil {
+		logger.Errorf("failed to marshal expiry event: %s", err.Error())
+
+		return
+	}
+
+	err = o.notifier.Notify(expiryTopic, msg)
+	if err != nil {
+		logger.Warnf("failed to publish expiry of credential %s: %s", record.CredentialID, err.Error())
+	}
+}
+
+func (o *Operation) saveExpiry(record *expiryRecord) error {
+	bits, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	return o.expiryStore.Put(credentialKey(record.UserID, record.CredentialID), bits,
+		storage.Tag{Name: expiryUserTag, Value: userTag(record.UserID)})
+}
+
+func (o *Operation) expiryRecord(userID, credentialID string) (*expiryRecord, error) {
+	bits, err := o.expiryStore.Get(credentialKey(userID, credentialID))
+	if err != nil {
+		return nil, err
+	}
+
+	record := &expiryRecord{}
+
+	err = json.Unmarshal(bits, record)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expiry record: %w", err)
+	}
+
+	return record, nil
+}
+
+func (o *Operation) expiryRecords(query string) ([]*expiryRecord, error) {
+	iter, err := o.expiryStore.Query(query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if closeErr := iter.Close(); closeErr != nil {
+			logger.Warnf("failed to close expiries iterator: %s", closeErr.Error())
+		}
+	}()
+
+	var records []*expiryRecord
+
+	for {
+		more, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		if !more {
+			return records, nil
+		}
+
+		bits, err := iter.Value()
+		if err != nil {
+			return nil, err
+		}
+
+		record := &expiryRecord{}
+
+		err = json.Unmarshal(bits, record)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expiry record: %w", err)
+		}
+
+		records = append(records, record)
+	}
+}
+
+// expiryLeadTimes returns the positive lead times, longest first, or the default lead times if there is none.
+func expiryLeadTimes(leadTimes []time.Duration) []time.Duration {
+	var sorted []time.Duration
+
+	for _, l := range leadTimes {
+		if l > 0 {
+			sorted = append(sorted, l)
+		}
+	}
+
+	if len(sorted) == 0 {
+		return []time.Duration{firstExpiryNotice, secondExpiryNotice, lastExpiryNotice}
+	}
+
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] > sorted[j] })
+
+	return sorted
+}
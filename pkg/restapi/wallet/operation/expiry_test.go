@@ -0,0 +1,339 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation // nolint:testpackage // changing to different package requires exposing internal features.
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/common/service"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/verifiable"
+	"github.com/hyperledger/aries-framework-go/pkg/wallet"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/wallet/pkg/restapi/internal/mocks"
+)
+
+const sampleExpiringCredential = `{
+	"@context": ["https://www.w3.org/2018/credentials/v1", {"@vocab": "https://example.com/vocab#"}],
+	"id": "%s",
+	"type": ["VerifiableCredential", "UniversityDegreeCredential"],
+	"issuer": "did:example:76e12ec712ebc6f1c221ebfeb1f",
+	"issuanceDate": "2010-01-01T19:23:24Z",
+	"expirationDate": "%s",
+	"credentialSubject": {
+		"id": "did:example:ebfeb1f712ebc6f1c276e12ec21",
+		"degree": {"type": "BachelorDegree", "name": "Bachelor of Science and Arts"}
+	}
+}`
+
+func TestOperation_CredentialExpiry(t *testing.T) {
+	t.Run("track, list and notify expiring credentials", func(t *testing.T) {
+		events := make(chan *expiryEvent, 3)
+		notifier := &mocks.Notifier{NotifyFunc: func(topic string, msg []byte) error {
+			require.Equal(t, expiryTopic, topic)
+
+			event := &expiryEvent{}
+			require.NoError(t, json.Unmarshal(msg, event))
+			events <- event
+
+			return nil
+		}}
+
+		op, err := New(newProvider(t), notifier, nil)
+		require.NoError(t, err)
+
+		router := routerOf(op)
+		auth := unlock(t, router)
+
+		soonID, laterID, expiredID := uuid.New().URN(), uuid.New().URN(), uuid.New().URN()
+
+		for _, credential := range []string{
+			fmt.Sprintf(sampleExpiringCredential, soonID, time.Now().Add(5*day).Format(time.RFC3339)),
+			fmt.Sprintf(sampleExpiringCredential, laterID, time.Now().Add(60*day).Format(time.RFC3339)),
+			fmt.Sprintf(sampleExpiredCredential, expiredID),
+			fmt.Sprintf(sampleCredential, uuid.New().URN()),
+		} {
+			rr := serve(router, http.MethodPost, addPath, &addContentReq{
+				contentAuth: contentAuth{walletAuth: auth, ContentType: wallet.Credential},
+				Content:     json.RawMessage(credential),
+			})
+			require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+		}
+
+		expiring := requireExpiring(t, router, &expiringCredentialsReq{walletAuth: auth})
+		require.Len(t, expiring, 2)
+		require.Equal(t, expiredID, expiring[0].CredentialID)
+		require.Equal(t, soonID, expiring[1].CredentialID)
+		require.Equal(t, "did:example:76e12ec712ebc6f1c221ebfeb1f", expiring[1].Issuer)
+		require.NotNil(t, expiring[1].IssuanceDate)
+		require.Empty(t, expiring[1].Renewal)
+
+		require.Len(t, requireExpiring(t, router, &expiringCredentialsReq{walletAuth: auth, Days: 90}), 3)
+
+		op.sweepExpiries()
+		require.Len(t, events, 2)
+
+		leadDays := map[string]int{}
+		expired := map[string]bool{}
+
+		for i := 0; i < 2; i++ {
+			event := <-events
+			require.Equal(t, auth.UserID, event.UserID)
+			leadDays[event.CredentialID] = event.LeadDays
+			expired[event.CredentialID] = event.Expired
+		}
+
+		require.Equal(t, map[string]int{soonID: 7, expiredID: 0}, leadDays)
+		require.Equal(t, map[string]bool{soonID: false, expiredID: true}, expired)
+
+		op.sweepExpiries()
+		require.Empty(t, events)
+
+		rr := serve(router, http.MethodPost, removePath, &removeContentReq{
+			contentAuth: contentAuth{walletAuth: auth, ContentType: wallet.Credential},
+			ContentID:   soonID,
+		})
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		expiring = requireExpiring(t, router, &expiringCredentialsReq{walletAuth: auth, Days: 90})
+		require.Len(t, expiring, 2)
+		require.Equal(t, laterID, expiring[1].CredentialID)
+
+		require.Empty(t, requireExpiring(t, router, &expiringCredentialsReq{walletAuth: unlock(t, router)}))
+	})
+
+	t.Run("track the expiries of untracked credentials when a wallet is unlocked", func(t *testing.T) {
+		provider := newProvider(t)
+
+		op, err := New(provider, nil, nil)
+		require.NoError(t, err)
+
+		router := routerOf(op)
+		auth := unlock(t, router)
+		credentialID := uuid.New().URN()
+
+		vcWallet, err := wallet.New(auth.UserID, provider)
+		require.NoError(t, err)
+
+		// the VC wallet stores the credential without the wallet operations tracking it.
+		require.NoError(t, vcWallet.Add(auth.Auth, wallet.Credential, json.RawMessage(fmt.Sprintf(
+			sampleExpiringCredential, credentialID, time.Now().Add(5*day).Format(time.RFC3339)))))
+		require.Empty(t, requireExpiring(t, router, &expiringCredentialsReq{walletAuth: auth}))
+
+		op.backfillExpiries(auth.UserID, auth.Auth)
+
+		expiring := requireExpiring(t, router, &expiringCredentialsReq{walletAuth: auth})
+		require.Len(t, expiring, 1)
+		require.Equal(t, credentialID, expiring[0].CredentialID)
+
+		op.backfillExpiries(auth.UserID, "invalid")
+		require.Len(t, requireExpiring(t, router, &expiringCredentialsReq{walletAuth: auth}), 1)
+	})
+
+	t.Run("configure the lead times of the notifications", func(t *testing.T) {
+		op, err := New(newProvider(t), nil, nil, WithExpiryNotifications(time.Hour, 2*day, 0, 10*day))
+		require.NoError(t, err)
+		require.Equal(t, []time.Duration{10 * day, 2 * day}, op.expiryLeadTimes)
+		require.Equal(t, 2*day, op.expiryLeadTime(-time.Hour))
+		require.Equal(t, 10*day, op.expiryLeadTime(5*day))
+		require.Zero(t, op.expiryLeadTime(11*day))
+
+		op, err = New(newProvider(t), nil, nil)
+		require.NoError(t, err)
+		require.Equal(t, []time.Duration{30 * day, 7 * day, day}, op.expiryLeadTimes)
+	})
+
+	t.Run("renew a credential issued through OIDC", func(t *testing.T) {
+		router := newIssuerRouter(t, WithWalletAppURL(sampleWalletAppURL))
+		credential := issueExpiringCredential(t, router, unlock(t, router))
+		issuer := newOIDCIssuer(t, credential)
+		auth := unlock(t, router)
+
+		authURL := initiateIssuance(t, router, auth, issuer.initiateRequest(nil))
+
+		rr := serve(router, http.MethodPost, issuanceCallbackPath, &issuanceCallbackReq{
+			walletAuth: auth,
+			Response:   issuer.authorize(t, authURL),
+		})
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		expiring := requireExpiring(t, router, &expiringCredentialsReq{walletAuth: auth})
+		require.Len(t, expiring, 1)
+		require.Equal(t, issuedByOIDC, expiring[0].Renewal)
+
+		resp := renewCredential(t, router, &renewCredentialReq{walletAuth: auth, CredentialID: credential.ID})
+		require.Equal(t, issuedByOIDC, resp.Protocol)
+		require.Nil(t, resp.Offer)
+
+		authURL, err := url.Parse(resp.AuthorizationURL)
+		require.NoError(t, err)
+		require.True(t, strings.HasPrefix(authURL.String(), issuer.URL+"/authorize?"))
+		require.Equal(t, sampleClientID, authURL.Query().Get("client_id"))
+		require.Equal(t, sampleWalletAppURL+"/oidc/save", authURL.Query().Get("redirect_uri"))
+		require.Contains(t, authURL.Query().Get("claims"), sampleCredentialType)
+		require.Empty(t, authURL.Query().Get("op_state"))
+
+		rr = serve(router, http.MethodPost, issuanceCallbackPath, &issuanceCallbackReq{
+			walletAuth: auth,
+			Response:   issuer.authorize(t, authURL),
+		})
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	})
+
+	t.Run("renew a credential issued through WACI", func(t *testing.T) {
+		router := newWACIHolder(t)
+		auth := unlock(t, router)
+		issuer := newWACIIssuer(t, issueExpiringCredential(t, router, auth))
+
+		offer := proposeCredential(t, router, auth, issuer.invitation(t, service.V1))
+
+		rr := serve(router, http.MethodPost, requestCredentialPath, &requestCredentialReq{
+			walletAuth: auth,
+			ThreadID:   offer.ThreadID,
+		})
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		resp := renewCredential(t, router, &renewCredentialReq{
+			walletAuth:   auth,
+			CredentialID: issuer.credential.ID,
+			Timeout:      10 * time.Second,
+		})
+		require.Equal(t, issuedByWACI, resp.Protocol)
+		require.NotNil(t, resp.Offer)
+		require.NotEmpty(t, resp.Offer.ThreadID)
+		require.NotEqual(t, offer.ThreadID, resp.Offer.ThreadID)
+		require.Len(t, resp.Offer.Descriptors, 1)
+
+		rr = serve(router, http.MethodPost, requestCredentialPath, &requestCredentialReq{
+			walletAuth: auth,
+			ThreadID:   resp.Offer.ThreadID,
+		})
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		expiring := requireExpiring(t, router, &expiringCredentialsReq{walletAuth: auth})
+		require.Len(t, expiring, 1)
+		require.Equal(t, issuedByWACI, expiring[0].Renewal)
+	})
+
+	t.Run("stop sweeping expiries once closed", func(t *testing.T) {
+		op, err := New(newProvider(t), nil, nil)
+		require.NoError(t, err)
+
+		stopped := make(chan struct{})
+
+		go func() {
+			op.sweepExpiriesEvery(time.Millisecond)
+			close(stopped)
+		}()
+
+		op.Close()
+
+		select {
+		case <-stopped:
+		case <-time.After(time.Second):
+			require.Fail(t, "expiry sweep not stopped")
+		}
+	})
+
+	t.Run("invalid requests", func(t *testing.T) {
+		router := newRouter(t, newProvider(t))
+		auth := unlock(t, router)
+
+		for _, path := range []string{expiringCredentialsPath, renewCredentialPath} {
+			rr := serve(router, http.MethodPost, path, "invalid")
+			require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+
+			rr = serve(router, http.MethodPost, path, &walletAuth{UserID: auth.UserID})
+			require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+			requireErrorResponse(t, rr.Body.Bytes(), "missing user ID or auth token")
+		}
+
+		rr := serve(router, http.MethodPost, expiringCredentialsPath, &expiringCredentialsReq{
+			walletAuth: auth, Days: -1,
+		})
+		require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+		requireErrorResponse(t, rr.Body.Bytes(), "invalid number of days [-1]")
+
+		rr = serve(router, http.MethodPost, expiringCredentialsPath, &expiringCredentialsReq{
+			walletAuth: walletAuth{UserID: auth.UserID, Auth: "invalid"},
+		})
+		require.Equal(t, http.StatusUnauthorized, rr.Code, rr.Body.String())
+
+		rr = serve(router, http.MethodPost, renewCredentialPath, &renewCredentialReq{walletAuth: auth})
+		require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+		requireErrorResponse(t, rr.Body.Bytes(), "missing credential ID")
+
+		rr = serve(router, http.MethodPost, renewCredentialPath, &renewCredentialReq{
+			walletAuth: walletAuth{UserID: auth.UserID, Auth: "invalid"}, CredentialID: "urn:uuid:unknown",
+		})
+		require.Equal(t, http.StatusUnauthorized, rr.Code, rr.Body.String())
+
+		rr = serve(router, http.MethodPost, renewCredentialPath, &renewCredentialReq{
+			walletAuth: auth, CredentialID: "urn:uuid:unknown",
+		})
+		require.Equal(t, http.StatusNotFound, rr.Code, rr.Body.String())
+		requireErrorResponse(t, rr.Body.Bytes(), "no tracked expiry for credential [urn:uuid:unknown]")
+
+		id := uuid.New().URN()
+
+		rr = serve(router, http.MethodPost, addPath, &addContentReq{
+			contentAuth: contentAuth{walletAuth: auth, ContentType: wallet.Credential},
+			Content:     json.RawMessage(fmt.Sprintf(sampleExpiredCredential, id)),
+		})
+		require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+
+		rr = serve(router, http.MethodPost, renewCredentialPath, &renewCredentialReq{walletAuth: auth, CredentialID: id})
+		require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+		requireErrorResponse(t, rr.Body.Bytes(), "was not issued through OIDC or WACI")
+	})
+}
+
+// issueExpiringCredential issues a degree credential expiring in five days.
+func issueExpiringCredential(t *testing.T, router *mux.Router, auth walletAuth) *verifiable.Credential {
+	t.Helper()
+
+	raw := issueCredential(t, router, auth, addEd25519Key(t, router, auth), wallet.Ed25519Signature2018,
+		fmt.Sprintf(sampleExpiringCredential, "http://example.gov/credentials/"+uuid.New().String(),
+			time.Now().Add(5*day).UTC().Format(time.RFC3339)))
+
+	vc, err := verifiable.ParseCredential(raw, verifiable.WithDisabledProofCheck(),
+		verifiable.WithJSONLDDocumentLoader(newProvider(t).JSONLDDocumentLoader()))
+	require.NoError(t, err)
+
+	return vc
+}
+
+func requireExpiring(t *testing.T, router *mux.Router, req *expiringCredentialsReq) []*credentialExpiry {
+	t.Helper()
+
+	rr := serve(router, http.MethodPost, expiringCredentialsPath, req)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	resp := &expiringCredentialsResp{}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), resp))
+
+	return resp.Credentials
+}
+
+func renewCredential(t *testing.T, router *mux.Router, req *renewCredentialReq) *renewCredentialResp {
+	t.Helper()
+
+	rr := serve(router, http.MethodPost, renewCredentialPath, req)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	resp := &renewCredentialResp{}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), resp))
+
+	return resp
+}
@@ -0,0 +1,203 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/pkg/client/outofband"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/common/service"
+	didexchangesvc "github.com/hyperledger/aries-framework-go/pkg/didcomm/protocol/didexchange"
+	outofbandv2svc "github.com/hyperledger/aries-framework-go/pkg/didcomm/protocol/outofbandv2"
+	"github.com/hyperledger/aries-framework-go/pkg/wallet"
+
+	"github.com/trustbloc/wallet/pkg/restapi/common"
+)
+
+const (
+	// mediatorTimeout bounds the DID exchange with the mediator the agent registers with.
+	mediatorTimeout = 30 * time.Second
+	// pickupBatchSize is the maximum number of messages queued by a mediator picked up at once.
+	pickupBatchSize = 100
+	// stateEventBuffer is the size of the buffer of the state events awaited for a DID exchange.
+	stateEventBuffer = 10
+)
+
+// acceptOOBInvitation accepts a DIDComm V1 or V2 out-of-band invitation. The keys of the DID created for the
+// connection are registered with the mediators of the agent, so that messages sent to the DID are routed through them.
+func (o *Operation) acceptOOBInvitation(invitation *wallet.GenericInvitation, label string) (string, error) {
+	if invitation.Version() == service.V2 {
+		return o.oobV2Client.AcceptInvitation(invitation.AsV2(),
+			outofbandv2svc.WithRouterConnections(o.routerConnections))
+	}
+
+	return o.oobClient.AcceptInvitation((*outofband.Invitation)(invitation.AsV1()), label,
+		outofband.WithRouterConnections(o.routerConnections...))
+}
+
+// registerMediator registers the agent through route coordination with the mediator whose out-of-band invitation is
+// published at invitationURL, unless the agent is already registered with a mediator.
+func (o *Operation) registerMediator(invitationURL string) error {
+	connIDs, err := o.mediatorClient.GetConnections()
+	if err != nil {
+		return fmt.Errorf("failed to get mediator connections: %w", err)
+	}
+
+	if len(connIDs) > 0 {
+		o.routerConnections = connIDs
+
+		return nil
+	}
+
+	invitation, err := o.mediatorInvitation(invitationURL)
+	if err != nil {
+		return err
+	}
+
+	connID, err := o.connectMediator(invitation)
+	if err != nil {
+		return err
+	}
+
+	err = o.mediatorClient.Register(connID)
+	if err != nil {
+		return fmt.Errorf("failed to register with mediator: %w", err)
+	}
+
+	o.routerConnections = []string{connID}
+
+	return nil
+}
+
+// mediatorInvitation fetches the out-of-band invitation of a mediator, either bare or as the invitation field of a
+// JSON object.
+func (o *Operation) mediatorInvitation(invitationURL string) (*wallet.GenericInvitation, error) {
+	req, err := http.NewRequest(http.MethodGet, invitationURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mediator invitation URL: %w", err)
+	}
+
+	body, _, err := common.SendHTTPRequest(req, o.httpClient, http.StatusOK, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get mediator invitation: %w", err)
+	}
+
+	wrapped := &struct {
+		Invitation *wallet.GenericInvitation `json:"invitation"`
+	}{}
+
+	if err = json.Unmarshal(body, wrapped); err == nil && wrapped.Invitation != nil {
+		return wrapped.Invitation, nil
+	}
+
+	invitation := &wallet.GenericInvitation{}
+
+	err = json.Unmarshal(body, invitation)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mediator invitation: %w", err)
+	}
+
+	return invitation, nil
+}
+
+// connectMediator accepts the invitation of a mediator, and waits for the DID exchange to complete.
+func (o *Operation) connectMediator(invitation *wallet.GenericInvitation) (string, error) {
+	states := make(chan service.StateMsg, stateEventBuffer)
+
+	err := o.didexchangeClient.RegisterMsgEvent(states)
+	if err != nil {
+		return "", fmt.Errorf("failed to register connection state events: %w", err)
+	}
+
+	defer func() {
+		if e := o.didexchangeClient.UnregisterMsgEvent(states); e != nil {
+			logger.Warnf("failed to unregister connection state events: %s", e.Error())
+		}
+	}()
+
+	connID, err := o.acceptOOBInvitation(invitation, "")
+	if err != nil {
+		return "", fmt.Errorf("failed to accept mediator invitation: %w", err)
+	}
+
+	conn, err := o.didexchangeClient.GetConnection(connID)
+	if err == nil && conn.State == didexchangesvc.StateIDCompleted {
+		return connID, nil
+	}
+
+	timeout := time.After(mediatorTimeout)
+
+	for {
+		select {
+		case msg := <-states:
+			props, ok := msg.Properties.(didexchangesvc.Event)
+			if msg.Type == service.PostState && ok && props.ConnectionID() == connID &&
+				msg.StateID == didexchangesvc.StateIDCompleted {
+				return connID, nil
+			}
+		case <-timeout:
+			return "", errors.New("timeout waiting for the DID exchange with the mediator to complete")
+		}
+	}
+}
+
+// grantRouteRequests grants the route coordination requests of other agents, so that the agent acts as their
+// mediator.
+func (o *Operation) grantRouteRequests() error {
+	actions := make(chan service.DIDCommAction)
+
+	err := o.mediatorClient.RegisterActionEvent(actions)
+	if err != nil {
+		return fmt.Errorf("failed to register route coordination action events: %w", err)
+	}
+
+	go func() {
+		for action := range actions {
+			action.Continue(nil)
+		}
+	}()
+
+	return nil
+}
+
+// pickupMessages picks up the messages queued by the mediators of the agent. With the websocket outbound transport
+// and the all transport return route, mediators return the messages over the websocket connection of the pickup.
+func (o *Operation) pickupMessages() {
+	for _, connID := range o.routerConnections {
+		count, err := o.pickupClient.BatchPickup(connID, pickupBatchSize)
+		if err != nil {
+			logger.Warnf("failed to pick up messages from mediator connection %s: %s", connID, err.Error())
+
+			continue
+		}
+
+		if count > 0 {
+			logger.Debugf("picked up %d messages from mediator connection %s", count, connID)
+		}
+	}
+}
+
+// pickupMessagesEvery picks up the messages queued by the mediators periodically. A pickup from a mediator that has
+// never queued messages for the agent only ends when the message pickup protocol times out, so ticks are skipped
+// while a pickup is in progress. Pickups stop once the operations are closed.
+func (o *Operation) pickupMessagesEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-o.done:
+			return
+		case <-ticker.C:
+			o.pickupMessages()
+		}
+	}
+}
@@ -0,0 +1,133 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation // nolint:testpackage // changing to different package requires exposing internal features.
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/common/service"
+	"github.com/hyperledger/aries-framework-go/pkg/framework/context"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/wallet/pkg/restapi/internal/mocks"
+)
+
+func TestOperation_Mediator(t *testing.T) {
+	t.Run("register with a mediator and route connections through it", func(t *testing.T) {
+		mediator := newMediator(t)
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.NoError(t, json.NewEncoder(w).Encode(map[string]interface{}{
+				"invitation": newInvitation(t, mediator, service.V1, "", ""),
+			}))
+		}))
+		defer srv.Close()
+
+		edge := newAgent(t)
+		events := make(chan *connectionEvent, 10)
+
+		op, err := New(edge, &mocks.Notifier{NotifyFunc: func(topic string, msg []byte) error {
+			if topic == connectionTopic {
+				event := &connectionEvent{}
+				require.NoError(t, json.Unmarshal(msg, event))
+				events <- event
+			}
+
+			return nil
+		}}, nil, WithMediator(srv.URL, 0))
+		require.NoError(t, err)
+		require.Len(t, op.routerConnections, 1)
+
+		config, err := op.mediatorClient.GetConfig(op.routerConnections[0])
+		require.NoError(t, err)
+		require.Equal(t, mediator.ServiceEndpoint(), config.Endpoint())
+
+		router := routerOf(op)
+		auth := unlock(t, router)
+
+		conn := acceptInvitation(t, router, &acceptInvitationReq{
+			walletAuth: auth,
+			Invitation: newInvitation(t, newInviter(t), service.V1, "", ""),
+		})
+
+		requireConnectionCompleted(t, events, auth.UserID, conn.ConnectionID)
+
+		conn = requireConnection(t, router, getConnectionPath, &connectionReq{
+			walletAuth: auth, ConnectionID: conn.ConnectionID,
+		})
+
+		resolution, err := edge.VDRegistry().Resolve(conn.MyDID)
+		require.NoError(t, err)
+		require.NotEmpty(t, resolution.DIDDocument.Service)
+
+		endpoint, err := resolution.DIDDocument.Service[0].ServiceEndpoint.URI()
+		require.NoError(t, err)
+		require.Equal(t, mediator.ServiceEndpoint(), endpoint)
+
+		// the agent is already registered, so the mediator invitation is not fetched again
+		srv.Close()
+
+		op, err = New(edge, nil, nil, WithMediator(srv.URL, 0))
+		require.NoError(t, err)
+		require.Len(t, op.routerConnections, 1)
+	})
+
+	t.Run("error if the mediator invitation cannot be fetched", func(t *testing.T) {
+		_, err := New(newAgent(t), nil, nil, WithMediator("http://127.0.0.1:0/invitation", 0))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to get mediator invitation")
+
+		_, err = New(newAgent(t), nil, nil, WithMediator("http://%zz", 0))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid mediator invitation URL")
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, err := w.Write([]byte("invalid"))
+			require.NoError(t, err)
+		}))
+		defer srv.Close()
+
+		_, err = New(newAgent(t), nil, nil, WithMediator(srv.URL, 0))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid mediator invitation")
+	})
+
+	t.Run("stop picking up messages once closed", func(t *testing.T) {
+		op, err := New(newProvider(t), nil, nil)
+		require.NoError(t, err)
+
+		stopped := make(chan struct{})
+
+		go func() {
+			op.pickupMessagesEvery(time.Millisecond)
+			close(stopped)
+		}()
+
+		op.Close()
+
+		select {
+		case <-stopped:
+		case <-time.After(time.Second):
+			require.Fail(t, "message pickup not stopped")
+		}
+	})
+}
+
+// newMediator returns the context of a new agent acting as a mediator.
+func newMediator(t *testing.T) *context.Provider {
+	t.Helper()
+
+	ctx := newInviter(t)
+
+	_, err := New(ctx, nil, nil, WithRouting(true))
+	require.NoError(t, err)
+
+	return ctx
+}
@@ -0,0 +1,579 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/pkg/controller/command/vcwallet"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/cm"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/did"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/verifiable"
+	"github.com/hyperledger/aries-framework-go/pkg/wallet"
+
+	"github.com/trustbloc/wallet/pkg/restapi/wallet/backup"
+	"github.com/trustbloc/wallet/pkg/restapi/wallet/status"
+)
+
+// walletAuth identifies an unlocked wallet. Auth is the token returned when the wallet was unlocked.
+type walletAuth struct {
+	UserID string `json:"userID"`
+	Auth   string `json:"auth"`
+}
+
+// contentAuth identifies an unlocked wallet and the type of the content of a request.
+type contentAuth struct {
+	walletAuth
+	ContentType wallet.ContentType `json:"contentType"`
+}
+
+type addContentReq struct {
+	contentAuth
+	Content      json.RawMessage `json:"content"`
+	CollectionID string          `json:"collectionID,omitempty"`
+}
+
+type removeContentReq struct {
+	contentAuth
+	ContentID string `json:"contentID"`
+}
+
+type getContentReq struct {
+	contentAuth
+	ContentID string `json:"contentID"`
+}
+
+type getContentResp struct {
+	Content json.RawMessage `json:"content"`
+}
+
+type getAllContentReq struct {
+	contentAuth
+	CollectionID string `json:"collectionID,omitempty"`
+}
+
+type getAllContentResp struct {
+	Contents map[string]json.RawMessage `json:"contents"`
+}
+
+type queryReq struct {
+	walletAuth
+	Query []*wallet.QueryParams `json:"query"`
+}
+
+type queryResp struct {
+	Results     []*verifiable.Presentation `json:"results"`
+	Diagnostics []*queryDiagnostic         `json:"diagnostics"`
+}
+
+// queryDiagnostic tells which credentials of the wallet match the query at Index in the request, and why the others
+// do not. Queries that do not select credentials, like DIDAuth, match none.
+type queryDiagnostic struct {
+	Index    int               `json:"index"`
+	Type     string            `json:"type"`
+	Matched  []string          `json:"matched"`
+	Excluded []*queryExclusion `json:"excluded,omitempty"`
+}
+
+type queryExclusion struct {
+	CredentialID string `json:"credentialID"`
+	Reason       string `json:"reason"`
+}
+
+// proofReq identifies an unlocked wallet and how to sign the proof of a request.
+type proofReq struct {
+	walletAuth
+	ProofOptions *wallet.ProofOptions `json:"proofOptions"`
+	Format       string               `json:"format,omitempty"`
+}
+
+type issueReq struct {
+	proofReq
+	Credential json.RawMessage `json:"credential"`
+}
+
+// issueResp holds the issued credential, or the JWT of the credential in the JWT format.
+type issueResp struct {
+	Credential interface{} `json:"credential"`
+}
+
+type proveReq struct {
+	proofReq
+	StoredCredentials []string          `json:"storedCredentials,omitempty"`
+	RawCredentials    []json.RawMessage `json:"rawCredentials,omitempty"`
+	Presentation      json.RawMessage   `json:"presentation,omitempty"`
+}
+
+// proveResp holds the presentation, or the JWT of the presentation in the JWT format.
+type proveResp struct {
+	Presentation interface{} `json:"presentation"`
+}
+
+type verifyReq struct {
+	walletAuth
+	StoredCredentialID string          `json:"storedCredentialID,omitempty"`
+	RawCredential      json.RawMessage `json:"rawCredential,omitempty"`
+	Presentation       json.RawMessage `json:"presentation,omitempty"`
+}
+
+type verifyResp struct {
+	Verified bool                 `json:"verified"`
+	Checks   []*verificationCheck `json:"checks"`
+}
+
+// verificationCheck is the result of a check of a credential, or of the proof of the presentation if it has no
+// CredentialID.
+type verificationCheck struct {
+	CredentialID string `json:"credentialID,omitempty"`
+	Check        string `json:"check"`
+	Status       string `json:"status"`
+	Error        string `json:"error,omitempty"`
+}
+
+type deriveReq struct {
+	walletAuth
+	wallet.DeriveOptions
+	StoredCredentialID string          `json:"storedCredentialID,omitempty"`
+	RawCredential      json.RawMessage `json:"rawCredential,omitempty"`
+}
+
+type deriveResp struct {
+	Credential *verifiable.Credential `json:"credential"`
+}
+
+type proposeCredentialReq struct {
+	walletAuth
+	Invitation *wallet.GenericInvitation `json:"invitation"`
+	From       string                    `json:"from,omitempty"`
+	Timeout    time.Duration             `json:"timeout,omitempty"`
+}
+
+// proposeCredentialResp is the credential offer of an issuer, and its output descriptors resolved with the
+// fulfillment preview for display.
+type proposeCredentialResp struct {
+	ThreadID    string                   `json:"threadID"`
+	Manifest    json.RawMessage          `json:"manifest"`
+	Fulfillment json.RawMessage          `json:"fulfillment,omitempty"`
+	Descriptors []*cm.ResolvedDescriptor `json:"descriptors,omitempty"`
+}
+
+type requestCredentialReq struct {
+	walletAuth
+	ThreadID     string          `json:"threadID"`
+	Presentation json.RawMessage `json:"presentation,omitempty"`
+	Timeout      time.Duration   `json:"timeout,omitempty"`
+}
+
+type requestCredentialResp struct {
+	Status        string   `json:"status"`
+	RedirectURL   string   `json:"redirectURL,omitempty"`
+	CredentialIDs []string `json:"credentialIDs"`
+}
+
+type declineCredentialReq struct {
+	walletAuth
+	ThreadID string `json:"threadID"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+type proposePresentationReq struct {
+	walletAuth
+	Invitation *wallet.GenericInvitation `json:"invitation"`
+	From       string                    `json:"from,omitempty"`
+	Timeout    time.Duration             `json:"timeout,omitempty"`
+}
+
+// proposePresentationResp is the presentation request of a verifier, and the credentials of the wallet matching its
+// presentation definition.
+type proposePresentationResp struct {
+	ThreadID               string                     `json:"threadID"`
+	PresentationDefinition json.RawMessage            `json:"presentationDefinition"`
+	Challenge              string                     `json:"challenge,omitempty"`
+	Domain                 string                     `json:"domain,omitempty"`
+	Results                []*verifiable.Presentation `json:"results"`
+	Diagnostic             *queryDiagnostic           `json:"diagnostic"`
+}
+
+type presentProofReq struct {
+	walletAuth
+	ThreadID      string               `json:"threadID"`
+	CredentialIDs []string             `json:"credentialIDs,omitempty"`
+	ProofOptions  *wallet.ProofOptions `json:"proofOptions,omitempty"`
+	Presentation  json.RawMessage      `json:"presentation,omitempty"`
+	Timeout       time.Duration        `json:"timeout,omitempty"`
+}
+
+type presentProofResp struct {
+	Status      string `json:"status"`
+	RedirectURL string `json:"redirectURL,omitempty"`
+}
+
+type declinePresentationReq struct {
+	walletAuth
+	ThreadID string `json:"threadID"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+type authorizationRequestReq struct {
+	walletAuth
+	Request string `json:"request"`
+}
+
+// authorizationRequestResp is the OIDC4VP authorization request of a verifier, and the credentials of the wallet
+// matching its presentation definition.
+type authorizationRequestResp struct {
+	ClientID               string                     `json:"clientID"`
+	RedirectURI            string                     `json:"redirectURI"`
+	State                  string                     `json:"state"`
+	Nonce                  string                     `json:"nonce"`
+	ResponseMode           string                     `json:"responseMode"`
+	PresentationDefinition json.RawMessage            `json:"presentationDefinition"`
+	Results                []*verifiable.Presentation `json:"results"`
+	Diagnostic             *queryDiagnostic           `json:"diagnostic"`
+}
+
+type authorizeReq struct {
+	walletAuth
+	Request       string               `json:"request"`
+	CredentialIDs []string             `json:"credentialIDs"`
+	ProofOptions  *wallet.ProofOptions `json:"proofOptions"`
+}
+
+// authorizeResp is the URL the user agent follows to return an authorization response to the verifier in the query
+// response mode.
+type authorizeResp struct {
+	RedirectURL string `json:"redirectURL"`
+}
+
+// idTokenClaims are the claims of a self-issued ID token, with the submission of the presentation in the VP token.
+type idTokenClaims struct {
+	Issuer    string        `json:"iss"`
+	Subject   string        `json:"sub"`
+	Audience  string        `json:"aud"`
+	Nonce     string        `json:"nonce"`
+	IssuedAt  int64         `json:"iat"`
+	ExpiresAt int64         `json:"exp"`
+	VPToken   *vpTokenClaim `json:"_vp_token"`
+}
+
+type vpTokenClaim struct {
+	PresentationSubmission interface{} `json:"presentation_submission"`
+}
+
+// selfIssuedKey records the key of the agent KMS signing the self-issued ID tokens of a user.
+type selfIssuedKey struct {
+	KeyID string `json:"keyID"`
+}
+
+type initiateIssuanceReq struct {
+	walletAuth
+	Request     string `json:"request"`
+	ClientID    string `json:"clientID"`
+	RedirectURI string `json:"redirectURI,omitempty"`
+}
+
+type initiateIssuanceResp struct {
+	AuthorizationURL string `json:"authorizationURL"`
+}
+
+type issuanceCallbackReq struct {
+	walletAuth
+	Response string `json:"response"`
+}
+
+type issuanceCallbackResp struct {
+	CredentialIDs []string `json:"credentialIDs"`
+}
+
+// pendingIssuance is an OIDC credential issuance waiting for the user to authorize it at the issuer.
+type pendingIssuance struct {
+	UserID             string            `json:"userID"`
+	ClientID           string            `json:"clientID"`
+	RedirectURI        string            `json:"redirectURI"`
+	CodeVerifier       string            `json:"codeVerifier"`
+	TokenEndpoint      string            `json:"tokenEndpoint"`
+	CredentialEndpoint string            `json:"credentialEndpoint"`
+	CredentialTypes    []string          `json:"credentialTypes"`
+	ExpiresAt          time.Time         `json:"expiresAt"`
+	Origin             *credentialOrigin `json:"origin"`
+	Renews             string            `json:"renews,omitempty"`
+}
+
+// issuerConfiguration is the OIDC configuration of a credential issuer.
+type issuerConfiguration struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	CredentialEndpoint    string `json:"credential_endpoint"`
+	CredentialManifests   []struct {
+		ID                string `json:"id"`
+		OutputDescriptors []struct {
+			Schema string `json:"schema"`
+		} `json:"output_descriptors"`
+	} `json:"credential_manifests"`
+}
+
+type issuerTokenResp struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+}
+
+type issuerCredentialResp struct {
+	Format     string          `json:"format"`
+	Credential json.RawMessage `json:"credential"`
+}
+
+type credentialStatusReq struct {
+	walletAuth
+	CredentialID string          `json:"credentialID,omitempty"`
+	Credential   json.RawMessage `json:"credential,omitempty"`
+}
+
+type credentialStatusResp struct {
+	Statuses []*credentialStatus `json:"statuses"`
+}
+
+// credentialStatus is the last known status of a credential.
+type credentialStatus struct {
+	CredentialID string        `json:"credentialID"`
+	Issuer       string        `json:"issuer"`
+	Entry        *status.Entry `json:"entry"`
+	Status       string        `json:"status"`
+	Error        string        `json:"error,omitempty"`
+	CheckedAt    *time.Time    `json:"checkedAt,omitempty"`
+}
+
+// statusRecord is the status of a credential stored in the wallet of a user, tracked by the status sweep.
+type statusRecord struct {
+	UserID string `json:"userID"`
+	credentialStatus
+}
+
+// statusEvent is published on the notifier when the status sweep finds that a stored credential was revoked or
+// suspended, or that a suspended credential is active again.
+type statusEvent struct {
+	UserID       string `json:"userID"`
+	CredentialID string `json:"credentialID"`
+	Status       string `json:"status"`
+	Purpose      string `json:"purpose"`
+}
+
+type createDIDReq struct {
+	walletAuth
+	Method       string        `json:"method"`
+	Domain       string        `json:"domain,omitempty"`
+	Path         string        `json:"path,omitempty"`
+	AnchorOrigin string        `json:"anchorOrigin,omitempty"`
+	Services     []did.Service `json:"services,omitempty"`
+}
+
+type didReq struct {
+	walletAuth
+	DID string `json:"did"`
+}
+
+type updateDIDReq struct {
+	walletAuth
+	DID                      string        `json:"did"`
+	AddVerificationMethod    bool          `json:"addVerificationMethod,omitempty"`
+	RotateVerificationMethod string        `json:"rotateVerificationMethod,omitempty"`
+	Services                 []did.Service `json:"services,omitempty"`
+	RemoveServices           []string      `json:"removeServices,omitempty"`
+}
+
+type didResp struct {
+	DID        string          `json:"did"`
+	Resolution json.RawMessage `json:"resolution"`
+}
+
+type listDIDsResp struct {
+	DIDs []json.RawMessage `json:"dids"`
+}
+
+// keyContent is a private key imported into the keystore of a wallet.
+type keyContent struct {
+	Context          []string `json:"@context"`
+	ID               string   `json:"id"`
+	Controller       string   `json:"controller"`
+	Type             string   `json:"type"`
+	PrivateKeyBase58 string   `json:"privateKeyBase58"`
+}
+
+// didKeys records the keys of the agent KMS committed to for the next update and recovery of a did:orb DID, and the
+// user owning the DID.
+type didKeys struct {
+	UserID        string `json:"userID"`
+	DID           string `json:"did"`
+	UpdateKeyID   string `json:"updateKeyID"`
+	RecoveryKeyID string `json:"recoveryKeyID"`
+}
+
+type acceptInvitationReq struct {
+	walletAuth
+	Invitation *wallet.GenericInvitation `json:"invitation"`
+	Label      string                    `json:"label,omitempty"`
+}
+
+type connectionReq struct {
+	walletAuth
+	ConnectionID string `json:"connectionID"`
+}
+
+type renameConnectionReq struct {
+	walletAuth
+	ConnectionID string `json:"connectionID"`
+	Label        string `json:"label"`
+}
+
+// connection is a DIDComm connection of a user. Label is the name given to the connection by the user, or the label
+// of the other party if the user did not rename the connection.
+type connection struct {
+	ConnectionID   string `json:"connectionID"`
+	State          string `json:"state"`
+	Label          string `json:"label,omitempty"`
+	TheirLabel     string `json:"theirLabel,omitempty"`
+	TheirDID       string `json:"theirDID,omitempty"`
+	MyDID          string `json:"myDID,omitempty"`
+	InvitationID   string `json:"invitationID,omitempty"`
+	DIDCommVersion string `json:"didCommVersion,omitempty"`
+}
+
+type listConnectionsResp struct {
+	Connections []*connection `json:"connections"`
+}
+
+// connectionRecord records the user owning a DIDComm connection of the agent, and the name the user gave it.
+type connectionRecord struct {
+	UserID       string `json:"userID"`
+	ConnectionID string `json:"connectionID"`
+	Label        string `json:"label,omitempty"`
+}
+
+// connectionEvent is published on the notifier when the state of a DIDComm connection of a user changes.
+type connectionEvent struct {
+	UserID     string      `json:"userID"`
+	Connection *connection `json:"connection"`
+}
+
+// exportBackupReq is a request to export the contents of a wallet, sealed with a passphrase or with the key at KeyURL
+// of the ops keystore of the user. Requests to the keystore are authorized with KMSAuth, as when unlocking the wallet.
+type exportBackupReq struct {
+	walletAuth
+	Passphrase string               `json:"passphrase,omitempty"`
+	KeyURL     string               `json:"keyURL,omitempty"`
+	KMSAuth    *vcwallet.UnlockAuth `json:"kmsAuth,omitempty"`
+}
+
+// importBackupReq is a request to import the contents of an archive into a wallet. In merge mode, the contents already
+// in the wallet are kept, while they are replaced by the contents of the archive in overwrite mode. Archives sealed
+// with a key are only opened with a key of the ops keystore of the user.
+type importBackupReq struct {
+	walletAuth
+	Archive    *backup.Archive      `json:"archive"`
+	Passphrase string               `json:"passphrase,omitempty"`
+	KMSAuth    *vcwallet.UnlockAuth `json:"kmsAuth,omitempty"`
+	Mode       string               `json:"mode,omitempty"`
+}
+
+// importBackupResp lists the IDs of the contents added to the wallet, of the contents of the wallet replaced, and of
+// the contents of the archive skipped as they were already in the wallet.
+type importBackupResp struct {
+	Added    []string `json:"added"`
+	Replaced []string `json:"replaced"`
+	Skipped  []string `json:"skipped"`
+	Excluded []string `json:"excluded,omitempty"`
+}
+
+// walletKeyStore records the URL of the ops keystore of the wallet profile of a user.
+type walletKeyStore struct {
+	URL string `json:"url"`
+}
+
+// waciIssuance is a WACI credential issuance waiting for the user to accept the offer of the issuer, or Requested
+// once accepted and waiting for the credential. Renews is the ID of the credential renewed by the issuance, if any.
+type waciIssuance struct {
+	UserID     string                    `json:"userID"`
+	Invitation *wallet.GenericInvitation `json:"invitation"`
+	Renews     string                    `json:"renews,omitempty"`
+	Requested  bool                      `json:"requested,omitempty"`
+}
+
+// waciShare is a WACI share waiting for the user to present or decline the presentation request of the verifier.
+type waciShare struct {
+	UserID string `json:"userID"`
+}
+
+// credentialOrigin is the issuance a credential stored in a wallet comes from, restarted to renew the credential.
+// OIDC issuances are restarted with the issuer and offer of their initiate issuance request, and WACI issuances with
+// the invitation of the issuer.
+type credentialOrigin struct {
+	Protocol        string                    `json:"protocol"`
+	Issuer          string                    `json:"issuer,omitempty"`
+	ClientID        string                    `json:"clientID,omitempty"`
+	RedirectURI     string                    `json:"redirectURI,omitempty"`
+	CredentialTypes []string                  `json:"credentialTypes,omitempty"`
+	ManifestIDs     []string                  `json:"manifestIDs,omitempty"`
+	Invitation      *wallet.GenericInvitation `json:"invitation,omitempty"`
+}
+
+// expiringCredentialsReq asks for the credentials of a wallet expiring within a number of days, by default the
+// longest lead time of the expiry notifications.
+type expiringCredentialsReq struct {
+	walletAuth
+	Days int `json:"days,omitempty"`
+}
+
+type expiringCredentialsResp struct {
+	Credentials []*credentialExpiry `json:"credentials"`
+}
+
+// credentialExpiry is the validity window of a credential. Renewal is the protocol through which the credential can
+// be renewed, if it was issued through OIDC or WACI.
+type credentialExpiry struct {
+	CredentialID   string     `json:"credentialID"`
+	Issuer         string     `json:"issuer"`
+	IssuanceDate   *time.Time `json:"issuanceDate,omitempty"`
+	ExpirationDate time.Time  `json:"expirationDate"`
+	Renewal        string     `json:"renewal,omitempty"`
+}
+
+// expiryRecord is the validity window of a credential stored in the wallet of a user, tracked by the expiry sweep.
+// NotifiedLeadTime is the lead time of the last expiry notification published, and NotifiedExpired whether the
+// credential was notified as expired.
+type expiryRecord struct {
+	UserID string `json:"userID"`
+	credentialExpiry
+	Origin           *credentialOrigin `json:"origin,omitempty"`
+	NotifiedLeadTime time.Duration     `json:"notifiedLeadTime,omitempty"`
+	NotifiedExpired  bool              `json:"notifiedExpired,omitempty"`
+}
+
+// expiryEvent is published on the notifier when the expiry sweep finds that a stored credential expires within a
+// lead time, or has expired.
+type expiryEvent struct {
+	UserID         string    `json:"userID"`
+	CredentialID   string    `json:"credentialID"`
+	ExpirationDate time.Time `json:"expirationDate"`
+	LeadDays       int       `json:"leadDays"`
+	Expired        bool      `json:"expired,omitempty"`
+	Renewal        string    `json:"renewal,omitempty"`
+}
+
+type renewCredentialReq struct {
+	walletAuth
+	CredentialID string        `json:"credentialID"`
+	Timeout      time.Duration `json:"timeout,omitempty"`
+}
+
+// renewCredentialResp is the restarted issuance of a credential: the authorization URL to which the user is sent for
+// OIDC issuances, or the credential offer of the issuer for WACI issuances.
+type renewCredentialResp struct {
+	Protocol         string                 `json:"protocol"`
+	AuthorizationURL string                 `json:"authorizationURL,omitempty"`
+	Offer            *proposeCredentialResp `json:"offer,omitempty"`
+}
@@ -0,0 +1,477 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+
+	"github.com/trustbloc/wallet/pkg/restapi/common"
+	"github.com/trustbloc/wallet/pkg/restapi/common/oidc"
+	"github.com/trustbloc/wallet/pkg/restapi/common/store"
+)
+
+const (
+	// issuanceStoreName is the name of the store holding the OIDC issuances waiting for the authorization of users.
+	issuanceStoreName = "wallet_oidc_issuances"
+
+	// issuanceLifetime is how long users have to authorize an OIDC issuance at the issuer.
+	issuanceLifetime = 15 * time.Minute
+
+	// issuanceCallbackAppPath is the page of the wallet web app to which issuers redirect users by default.
+	issuanceCallbackAppPath = "/oidc/save"
+
+	issuerConfigurationPath = "/.well-known/openid-configuration"
+	credentialFormatLDP     = "ldp_vc"
+
+	// maxIssuerResponseSize is the maximum size of the responses of issuers, and issuerTimeout the timeout of the
+	// requests to issuers.
+	maxIssuerResponseSize = 1 << 20
+	issuerTimeout         = 30 * time.Second
+)
+
+// errUnknownIssuance is returned for issuance callbacks that do not match a pending issuance of the user.
+var errUnknownIssuance = errors.New("unknown or expired issuance")
+
+// initiateIssuance starts the OIDC issuance of the credentials offered by an issuer, given the issuer, credential_type
+// and manifest_id parameters of the initiate issuance request. Discovers the issuer, and returns the authorization
+// URL to which the user is sent to authorize the issuance with a PKCE bound authorization code.
+func (o *Operation) initiateIssuance(w http.ResponseWriter, r *http.Request) {
+	req := &initiateIssuanceReq{}
+
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "invalid request: %s", err.Error())
+
+		return
+	}
+
+	if req.UserID == "" || req.Auth == "" {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing user ID or auth token")
+
+		return
+	}
+
+	if req.ClientID == "" {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing client ID")
+
+		return
+	}
+
+	if req.RedirectURI == "" && o.walletAppURL != "" {
+		req.RedirectURI = strings.TrimSuffix(o.walletAppURL, "/") + issuanceCallbackAppPath
+	}
+
+	if req.RedirectURI == "" {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing redirect URI")
+
+		return
+	}
+
+	params, err := queryParams(req.Request)
+	if err != nil || params.Get("issuer") == "" {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "invalid initiate issuance request: missing issuer")
+
+		return
+	}
+
+	if len(params["credential_type"]) == 0 && len(params["manifest_id"]) == 0 {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest,
+			"invalid initiate issuance request: missing credential_type or manifest_id")
+
+		return
+	}
+
+	if _, ok := o.openUnlockedWallet(w, req.UserID, req.Auth); !ok {
+		return
+	}
+
+	authURL, ok := o.startIssuance(w, req, params, "")
+	if !ok {
+		return
+	}
+
+	common.WriteResponse(w, logger, &initiateIssuanceResp{AuthorizationURL: authURL})
+}
+
+// startIssuance discovers the issuer of an initiate issuance request and saves the pending issuance, renewing the
+// credential with the given ID if any. Returns the authorization URL of the issuance, or writes the error response.
+func (o *Operation) startIssuance(w http.ResponseWriter, req *initiateIssuanceReq, params url.Values,
+	renews string) (string, bool) {
+	issuer, err := o.discoverIssuer(params.Get("issuer"))
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadGateway, "failed to discover issuer: %s", err.Error())
+
+		return "", false
+	}
+
+	types, err := issuedTypes(issuer, params["credential_type"], params["manifest_id"])
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "invalid initiate issuance request: %s",
+			err.Error())
+
+		return "", false
+	}
+
+	codeVerifier, err := oidc.NewCodeVerifier()
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError,
+			"failed to create PKCE code verifier: %s", err.Error())
+
+		return "", false
+	}
+
+	state := uuid.New().String()
+
+	err = store.Save(o.issuanceStore, state, &pendingIssuance{
+		UserID:             req.UserID,
+		ClientID:           req.ClientID,
+		RedirectURI:        req.RedirectURI,
+		CodeVerifier:       codeVerifier,
+		TokenEndpoint:      issuer.TokenEndpoint,
+		CredentialEndpoint: issuer.CredentialEndpoint,
+		CredentialTypes:    types,
+		ExpiresAt:          time.Now().Add(issuanceLifetime),
+		Origin: &credentialOrigin{
+			Protocol:        issuedByOIDC,
+			Issuer:          params.Get("issuer"),
+			ClientID:        req.ClientID,
+			RedirectURI:     req.RedirectURI,
+			CredentialTypes: params["credential_type"],
+			ManifestIDs:     params["manifest_id"],
+		},
+		Renews: renews,
+	})
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "failed to save issuance: %s",
+			err.Error())
+
+		return "", false
+	}
+
+	authURL, err := authorizationURL(issuer, req, params, state, codeVerifier)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError,
+			"failed to create authorization request: %s", err.Error())
+
+		return "", false
+	}
+
+	return authURL, true
+}
+
+// issuanceCallback completes the OIDC issuance authorized by the user, given the authorization response the issuer
+// redirected the user with. Exchanges the authorization code for an access token, requests the credentials from the
+// issuer, and verifies and saves them in the wallet.
+func (o *Operation) issuanceCallback(w http.ResponseWriter, r *http.Request) {
+	req := &issuanceCallbackReq{}
+
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "invalid request: %s", err.Error())
+
+		return
+	}
+
+	if req.UserID == "" || req.Auth == "" {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing user ID or auth token")
+
+		return
+	}
+
+	params, err := queryParams(req.Response)
+	if err != nil || params.Get("state") == "" {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "invalid authorization response: missing state")
+
+		return
+	}
+
+	vcWallet, ok := o.openUnlockedWallet(w, req.UserID, req.Auth)
+	if !ok {
+		return
+	}
+
+	issuance, err := o.takeIssuance(params.Get("state"), req.UserID)
+	if err != nil {
+		writeWalletError(w, err, "invalid authorization response")
+
+		return
+	}
+
+	if params.Get("error") != "" {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "issuance was not authorized: %s %s",
+			params.Get("error"), params.Get("error_description"))
+
+		return
+	}
+
+	if params.Get("code") == "" {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "invalid authorization response: missing code")
+
+		return
+	}
+
+	credentials, err := o.requestCredentials(issuance, params.Get("code"))
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadGateway, "failed to get credentials from issuer: %s",
+			err.Error())
+
+		return
+	}
+
+	ids, err := o.saveCredentials(vcWallet, req.UserID, req.Auth, credentials, issuance.Origin, issuance.Renews)
+	if err != nil {
+		writeWalletError(w, err, "failed to save issued credentials")
+
+		return
+	}
+
+	common.WriteResponse(w, logger, &issuanceCallbackResp{CredentialIDs: ids})
+}
+
+// discoverIssuer returns the OIDC configuration of an issuer.
+func (o *Operation) discoverIssuer(issuerURL string) (*issuerConfiguration, error) {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet,
+		strings.TrimSuffix(issuerURL, "/")+issuerConfigurationPath, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := o.sendIssuerRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	issuer := &issuerConfiguration{}
+
+	err = json.Unmarshal(resp, issuer)
+	if err != nil {
+		return nil, fmt.Errorf("invalid issuer configuration: %w", err)
+	}
+
+	if strings.TrimSuffix(issuer.Issuer, "/") != strings.TrimSuffix(issuerURL, "/") {
+		return nil, fmt.Errorf("issuer configuration is for issuer %s", issuer.Issuer)
+	}
+
+	if issuer.AuthorizationEndpoint == "" || issuer.TokenEndpoint == "" || issuer.CredentialEndpoint == "" {
+		return nil, errors.New("issuer configuration is missing an endpoint")
+	}
+
+	return issuer, nil
+}
+
+// takeIssuance returns and removes the pending issuance of a user with a state.
+func (o *Operation) takeIssuance(state, userID string) (*pendingIssuance, error) {
+	raw, err := o.issuanceStore.Get(state)
+	if errors.Is(err, storage.ErrDataNotFound) {
+		return nil, fmt.Errorf("%w: %s", errUnknownIssuance, err.Error())
+	} else if err != nil {
+		return nil, err
+	}
+
+	issuance := &pendingIssuance{}
+
+	err = json.Unmarshal(raw, issuance)
+	if err != nil {
+		return nil, err
+	}
+
+	if issuance.UserID != userID {
+		return nil, fmt.Errorf("%w: state of another user", errUnknownIssuance)
+	}
+
+	err = o.issuanceStore.Delete(state)
+	if err != nil {
+		return nil, err
+	}
+
+	if time.Now().After(issuance.ExpiresAt) {
+		return nil, fmt.Errorf("%w: expired at %s", errUnknownIssuance, issuance.ExpiresAt.Format(time.RFC3339))
+	}
+
+	return issuance, nil
+}
+
+// requestCredentials exchanges an authorization code for an access token, and requests the credentials of an
+// issuance with it.
+func (o *Operation) requestCredentials(issuance *pendingIssuance, code string) ([]json.RawMessage, error) {
+	resp, err := o.postForm(issuance.TokenEndpoint, "", url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {issuance.RedirectURI},
+		"client_id":     {issuance.ClientID},
+		"code_verifier": {issuance.CodeVerifier},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("token request: %w", err)
+	}
+
+	token := &issuerTokenResp{}
+
+	err = json.Unmarshal(resp, token)
+	if err != nil || token.AccessToken == "" {
+		return nil, errors.New("token request: invalid token response")
+	}
+
+	if token.TokenType == "" {
+		token.TokenType = "Bearer"
+	}
+
+	credentials := make([]json.RawMessage, len(issuance.CredentialTypes))
+
+	for i, credentialType := range issuance.CredentialTypes {
+		resp, err = o.postForm(issuance.CredentialEndpoint, token.TokenType+" "+token.AccessToken, url.Values{
+			"type":   {credentialType},
+			"format": {credentialFormatLDP},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("credential request %s: %w", credentialType, err)
+		}
+
+		credential := &issuerCredentialResp{}
+
+		err = json.Unmarshal(resp, credential)
+		if err != nil || len(credential.Credential) == 0 {
+			return nil, fmt.Errorf("credential request %s: invalid credential response", credentialType)
+		}
+
+		credentials[i] = credential.Credential
+	}
+
+	return credentials, nil
+}
+
+// postForm posts a form to an endpoint of an issuer, with an authorization header if given.
+func (o *Operation) postForm(endpoint, authorization string, form url.Values) ([]byte, error) {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, endpoint,
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	return o.sendIssuerRequest(req)
+}
+
+// sendIssuerRequest sends a request to an issuer, at a public address, and returns the body of its response.
+func (o *Operation) sendIssuerRequest(req *http.Request) ([]byte, error) {
+	resp, err := o.issuerClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("http request: %w", err)
+	}
+
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			logger.Warnf("failed to close response body: %s", closeErr)
+		}
+	}()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxIssuerResponseSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("http request: failed to read response body: %w", err)
+	}
+
+	if len(body) > maxIssuerResponseSize {
+		return nil, fmt.Errorf("http request: response is larger than %d bytes", maxIssuerResponseSize)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("http request: expected=%d actual=%d body=%s", http.StatusOK, resp.StatusCode, body)
+	}
+
+	return body, nil
+}
+
+// issuedTypes returns the types of the credentials to request from an issuer: the requested credential types, and
+// the schemas of the output descriptors of the requested manifests.
+func issuedTypes(issuer *issuerConfiguration, credentialTypes, manifestIDs []string) ([]string, error) {
+	types := append([]string{}, credentialTypes...)
+
+	for _, manifestID := range manifestIDs {
+		found := false
+
+		for _, manifest := range issuer.CredentialManifests {
+			if manifest.ID != manifestID {
+				continue
+			}
+
+			found = true
+
+			for _, descriptor := range manifest.OutputDescriptors {
+				if descriptor.Schema != "" && !containsAll([]string{descriptor.Schema}, types) {
+					types = append(types, descriptor.Schema)
+				}
+			}
+		}
+
+		// issuers may offer credential types without publishing their manifests.
+		if !found && len(credentialTypes) == 0 {
+			return nil, fmt.Errorf("unknown manifest %s", manifestID)
+		}
+	}
+
+	return types, nil
+}
+
+// authorizationURL returns the URL of the authorization request of an issuance, asking for the credentials or
+// manifests of the initiate issuance request.
+func authorizationURL(issuer *issuerConfiguration, req *initiateIssuanceReq, params url.Values,
+	state, codeVerifier string) (string, error) {
+	var claims []map[string]string
+
+	for _, credentialType := range params["credential_type"] {
+		claims = append(claims, map[string]string{"type": credentialType, "format": credentialFormatLDP})
+	}
+
+	for _, manifestID := range params["manifest_id"] {
+		claims = append(claims, map[string]string{"manifest_id": manifestID, "format": credentialFormatLDP})
+	}
+
+	rawClaims, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	authURL, err := url.Parse(issuer.AuthorizationEndpoint)
+	if err != nil {
+		return "", err
+	}
+
+	query := authURL.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", req.ClientID)
+	query.Set("redirect_uri", req.RedirectURI)
+	query.Set("scope", "openid")
+	query.Set("state", state)
+	query.Set("claims", string(rawClaims))
+	query.Set("code_challenge", oidc.CodeChallenge(codeVerifier))
+	query.Set("code_challenge_method", "S256")
+
+	// issuers that initiated the issuance recognize it with their op_state.
+	if opState := params.Get("op_state"); opState != "" {
+		query.Set("op_state", opState)
+	}
+
+	authURL.RawQuery = query.Encode()
+
+	return authURL.String(), nil
+}
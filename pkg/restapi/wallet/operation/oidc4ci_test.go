@@ -0,0 +1,415 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation // nolint:testpackage // changing to different package requires exposing internal features.
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/verifiable"
+	"github.com/hyperledger/aries-framework-go/pkg/wallet"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/wallet/pkg/restapi/common/oidc"
+)
+
+const (
+	sampleWalletAppURL   = "https://wallet.example.com"
+	sampleClientID       = "wallet"
+	sampleCredentialType = "https://example.com/vocab#UniversityDegreeCredential"
+	sampleManifestID     = "degree-manifest"
+)
+
+func TestOperation_OIDCIssuance(t *testing.T) {
+	t.Run("issue the credential types and manifests offered", func(t *testing.T) {
+		for _, offer := range []url.Values{
+			{"credential_type": {sampleCredentialType}},
+			{"manifest_id": {sampleManifestID}},
+		} {
+			router := newIssuerRouter(t, WithWalletAppURL(sampleWalletAppURL))
+			credential := issueDegreeCredential(t, router, unlock(t, router))
+			issuer := newOIDCIssuer(t, credential)
+			auth := unlock(t, router)
+
+			authURL := initiateIssuance(t, router, auth, issuer.initiateRequest(offer))
+			require.True(t, strings.HasPrefix(authURL.String(), issuer.URL+"/authorize?"))
+			require.Equal(t, "code", authURL.Query().Get("response_type"))
+			require.Equal(t, sampleClientID, authURL.Query().Get("client_id"))
+			require.Equal(t, sampleWalletAppURL+"/oidc/save", authURL.Query().Get("redirect_uri"))
+			require.Equal(t, "S256", authURL.Query().Get("code_challenge_method"))
+			require.Equal(t, "op-state", authURL.Query().Get("op_state"))
+			require.Contains(t, authURL.Query().Get("claims"), `"format":"ldp_vc"`)
+
+			rr := serve(router, http.MethodPost, issuanceCallbackPath, &issuanceCallbackReq{
+				walletAuth: auth,
+				Response:   issuer.authorize(t, authURL),
+			})
+			require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+			resp := &issuanceCallbackResp{}
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), resp))
+			require.Equal(t, []string{credential.ID}, resp.CredentialIDs)
+
+			rr = serve(router, http.MethodPost, getPath, &getContentReq{
+				contentAuth: contentAuth{walletAuth: auth, ContentType: wallet.Credential},
+				ContentID:   credential.ID,
+			})
+			require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		}
+	})
+
+	t.Run("error if the initiate issuance request is invalid", func(t *testing.T) {
+		router := newIssuerRouter(t)
+		auth := unlock(t, router)
+		issuer := newOIDCIssuer(t, nil)
+
+		rr := serve(router, http.MethodPost, initiateIssuancePath, "invalid")
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		requireErrorResponse(t, rr.Body.Bytes(), "invalid request")
+
+		rr = serve(router, http.MethodPost, initiateIssuancePath, &initiateIssuanceReq{
+			walletAuth: walletAuth{UserID: auth.UserID},
+		})
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		requireErrorResponse(t, rr.Body.Bytes(), "missing user ID or auth token")
+
+		rr = serve(router, http.MethodPost, initiateIssuancePath, &initiateIssuanceReq{walletAuth: auth})
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		requireErrorResponse(t, rr.Body.Bytes(), "missing client ID")
+
+		rr = serve(router, http.MethodPost, initiateIssuancePath, &initiateIssuanceReq{
+			walletAuth: auth,
+			ClientID:   sampleClientID,
+		})
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		requireErrorResponse(t, rr.Body.Bytes(), "missing redirect URI")
+
+		for msg, request := range map[string]string{
+			"missing issuer":                      "credential_type=" + sampleCredentialType,
+			"missing credential_type or manifest": "issuer=" + url.QueryEscape(issuer.URL),
+			"unknown manifest unknown":            issuer.initiateRequest(url.Values{"manifest_id": {"unknown"}}),
+		} {
+			rr = serve(router, http.MethodPost, initiateIssuancePath, &initiateIssuanceReq{
+				walletAuth:  auth,
+				Request:     request,
+				ClientID:    sampleClientID,
+				RedirectURI: sampleWalletAppURL + "/callback",
+			})
+			require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+			requireErrorResponse(t, rr.Body.Bytes(), "invalid initiate issuance request: "+msg)
+		}
+	})
+
+	t.Run("error if the issuer cannot be discovered", func(t *testing.T) {
+		router := newIssuerRouter(t, WithWalletAppURL(sampleWalletAppURL))
+		auth := unlock(t, router)
+		issuer := newOIDCIssuer(t, nil)
+
+		for _, issuerURL := range []string{issuer.URL + "/other", "http://localhost:0"} {
+			rr := serve(router, http.MethodPost, initiateIssuancePath, &initiateIssuanceReq{
+				walletAuth: auth,
+				Request:    "?" + url.Values{"issuer": {issuerURL}, "credential_type": {sampleCredentialType}}.Encode(),
+				ClientID:   sampleClientID,
+			})
+			require.Equal(t, http.StatusBadGateway, rr.Code, rr.Body.String())
+			requireErrorResponse(t, rr.Body.Bytes(), "failed to discover issuer")
+		}
+	})
+
+	t.Run("error if the issuer is not at a public address or its response is too large", func(t *testing.T) {
+		router := newRouter(t, newProvider(t), WithWalletAppURL(sampleWalletAppURL))
+		auth := unlock(t, router)
+		issuer := newOIDCIssuer(t, nil)
+
+		rr := serve(router, http.MethodPost, initiateIssuancePath, &initiateIssuanceReq{
+			walletAuth: auth,
+			Request:    issuer.initiateRequest(nil),
+			ClientID:   sampleClientID,
+		})
+		require.Equal(t, http.StatusBadGateway, rr.Code, rr.Body.String())
+		requireErrorResponse(t, rr.Body.Bytes(), "is not allowed")
+
+		large := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, err := w.Write(bytes.Repeat([]byte(" "), maxIssuerResponseSize+1))
+			require.NoError(t, err)
+		}))
+		t.Cleanup(large.Close)
+
+		router = newIssuerRouter(t, WithWalletAppURL(sampleWalletAppURL))
+		auth = unlock(t, router)
+
+		rr = serve(router, http.MethodPost, initiateIssuancePath, &initiateIssuanceReq{
+			walletAuth: auth,
+			Request:    "?" + url.Values{"issuer": {large.URL}, "credential_type": {sampleCredentialType}}.Encode(),
+			ClientID:   sampleClientID,
+		})
+		require.Equal(t, http.StatusBadGateway, rr.Code, rr.Body.String())
+		requireErrorResponse(t, rr.Body.Bytes(), fmt.Sprintf("larger than %d bytes", maxIssuerResponseSize))
+	})
+
+	t.Run("error if the authorization response is invalid", func(t *testing.T) {
+		router := newIssuerRouter(t, WithWalletAppURL(sampleWalletAppURL))
+		credential := issueDegreeCredential(t, router, unlock(t, router))
+		issuer := newOIDCIssuer(t, credential)
+		auth := unlock(t, router)
+
+		rr := serve(router, http.MethodPost, issuanceCallbackPath, &issuanceCallbackReq{walletAuth: auth})
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		requireErrorResponse(t, rr.Body.Bytes(), "invalid authorization response: missing state")
+
+		authURL := initiateIssuance(t, router, auth, issuer.initiateRequest(nil))
+		state := authURL.Query().Get("state")
+
+		rr = serve(router, http.MethodPost, issuanceCallbackPath, &issuanceCallbackReq{
+			walletAuth: unlock(t, router),
+			Response:   "?state=" + state,
+		})
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		requireErrorResponse(t, rr.Body.Bytes(), "unknown or expired issuance: state of another user")
+
+		rr = serve(router, http.MethodPost, issuanceCallbackPath, &issuanceCallbackReq{
+			walletAuth: auth,
+			Response:   "?error=access_denied&state=" + state,
+		})
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		requireErrorResponse(t, rr.Body.Bytes(), "issuance was not authorized: access_denied")
+
+		// the state is consumed by the first response.
+		rr = serve(router, http.MethodPost, issuanceCallbackPath, &issuanceCallbackReq{
+			walletAuth: auth,
+			Response:   issuer.authorize(t, authURL),
+		})
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		requireErrorResponse(t, rr.Body.Bytes(), "unknown or expired issuance")
+
+		authURL = initiateIssuance(t, router, auth, issuer.initiateRequest(nil))
+
+		rr = serve(router, http.MethodPost, issuanceCallbackPath, &issuanceCallbackReq{
+			walletAuth: auth,
+			Response:   "?state=" + authURL.Query().Get("state"),
+		})
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		requireErrorResponse(t, rr.Body.Bytes(), "invalid authorization response: missing code")
+	})
+
+	t.Run("error if the issuer rejects the PKCE code verifier", func(t *testing.T) {
+		router := newIssuerRouter(t, WithWalletAppURL(sampleWalletAppURL))
+		credential := issueDegreeCredential(t, router, unlock(t, router))
+		issuer := newOIDCIssuer(t, credential)
+		auth := unlock(t, router)
+
+		authURL := initiateIssuance(t, router, auth, issuer.initiateRequest(nil))
+		response := issuer.authorize(t, authURL)
+		issuer.challenge = oidc.CodeChallenge("other")
+
+		rr := serve(router, http.MethodPost, issuanceCallbackPath, &issuanceCallbackReq{
+			walletAuth: auth,
+			Response:   response,
+		})
+		require.Equal(t, http.StatusBadGateway, rr.Code, rr.Body.String())
+		requireErrorResponse(t, rr.Body.Bytes(), "failed to get credentials from issuer: token request")
+	})
+
+	t.Run("error if the issued credential is invalid", func(t *testing.T) {
+		router := newIssuerRouter(t, WithWalletAppURL(sampleWalletAppURL))
+		credential := issueDegreeCredential(t, router, unlock(t, router))
+		credential.Subject = "did:example:tampered"
+		issuer := newOIDCIssuer(t, credential)
+		auth := unlock(t, router)
+
+		authURL := initiateIssuance(t, router, auth, issuer.initiateRequest(nil))
+
+		rr := serve(router, http.MethodPost, issuanceCallbackPath, &issuanceCallbackReq{
+			walletAuth: auth,
+			Response:   issuer.authorize(t, authURL),
+		})
+		require.Equal(t, http.StatusInternalServerError, rr.Code, rr.Body.String())
+		requireErrorResponse(t, rr.Body.Bytes(), "failed to save issued credentials")
+
+		rr = serve(router, http.MethodPost, getPath, &getContentReq{
+			contentAuth: contentAuth{walletAuth: auth, ContentType: wallet.Credential},
+			ContentID:   credential.ID,
+		})
+		require.Equal(t, http.StatusNotFound, rr.Code, rr.Body.String())
+	})
+
+	t.Run("error if the auth token is invalid", func(t *testing.T) {
+		router := newIssuerRouter(t, WithWalletAppURL(sampleWalletAppURL))
+		auth := unlock(t, router)
+		auth.Auth = "invalid"
+
+		rr := serve(router, http.MethodPost, initiateIssuancePath, &initiateIssuanceReq{
+			walletAuth: auth,
+			Request:    "?issuer=https://issuer.example.com&credential_type=" + sampleCredentialType,
+			ClientID:   sampleClientID,
+		})
+		require.Equal(t, http.StatusUnauthorized, rr.Code, rr.Body.String())
+
+		rr = serve(router, http.MethodPost, issuanceCallbackPath, &issuanceCallbackReq{
+			walletAuth: auth,
+			Response:   "?code=code&state=state",
+		})
+		require.Equal(t, http.StatusUnauthorized, rr.Code, rr.Body.String())
+	})
+}
+
+// initiateIssuance initiates an issuance and returns the authorization URL to which the user is sent.
+func initiateIssuance(t *testing.T, router *mux.Router, auth walletAuth, request string) *url.URL {
+	t.Helper()
+
+	rr := serve(router, http.MethodPost, initiateIssuancePath, &initiateIssuanceReq{
+		walletAuth: auth,
+		Request:    request,
+		ClientID:   sampleClientID,
+	})
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	resp := &initiateIssuanceResp{}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), resp))
+
+	authURL, err := url.Parse(resp.AuthorizationURL)
+	require.NoError(t, err)
+
+	return authURL
+}
+
+// newIssuerRouter routes requests to the handlers of an operation reaching OIDC issuers at any address, such as the
+// local addresses of test issuers.
+func newIssuerRouter(t *testing.T, opts ...Opt) *mux.Router {
+	t.Helper()
+
+	op, err := New(newProvider(t), nil, nil, opts...)
+	require.NoError(t, err)
+
+	op.issuerClient = guardedHTTPClient(nil, func(net.IP) bool { return true }, issuerTimeout)
+
+	return routerOf(op)
+}
+
+// oidcIssuer is an OIDC credential issuer issuing a credential of the sample credential type.
+type oidcIssuer struct {
+	*httptest.Server
+	credential json.RawMessage
+
+	mutex     sync.Mutex
+	challenge string
+	code      string
+	token     string
+}
+
+func newOIDCIssuer(t *testing.T, credential *verifiable.Credential) *oidcIssuer {
+	t.Helper()
+
+	issuer := &oidcIssuer{code: uuid.New().String(), token: uuid.New().String()}
+
+	if credential != nil {
+		var err error
+
+		issuer.credential, err = credential.MarshalJSON()
+		require.NoError(t, err)
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc(issuerConfigurationPath, issuer.configuration).Methods(http.MethodGet)
+	router.HandleFunc("/token", issuer.tokenEndpoint).Methods(http.MethodPost)
+	router.HandleFunc("/credential", issuer.credentialEndpoint).Methods(http.MethodPost)
+
+	issuer.Server = httptest.NewServer(router)
+	t.Cleanup(issuer.Close)
+
+	return issuer
+}
+
+// initiateRequest returns an initiate issuance request for the offer, or for the sample credential type.
+func (i *oidcIssuer) initiateRequest(offer url.Values) string {
+	query := url.Values{"issuer": {i.URL}, "op_state": {"op-state"}}
+
+	if offer == nil {
+		offer = url.Values{"credential_type": {sampleCredentialType}}
+	}
+
+	for name, values := range offer {
+		query[name] = values
+	}
+
+	return "https://wallet.example.com/oidc/initiate?" + query.Encode()
+}
+
+// authorize authorizes an authorization request as the user would at the issuer, and returns the authorization
+// response the issuer redirects the user with.
+func (i *oidcIssuer) authorize(t *testing.T, authURL *url.URL) string {
+	t.Helper()
+
+	i.mutex.Lock()
+	i.challenge = authURL.Query().Get("code_challenge")
+	i.mutex.Unlock()
+
+	return fmt.Sprintf("%s?code=%s&state=%s", authURL.Query().Get("redirect_uri"), i.code,
+		authURL.Query().Get("state"))
+}
+
+func (i *oidcIssuer) configuration(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{ // nolint:errcheck
+		"issuer":                 i.URL,
+		"authorization_endpoint": i.URL + "/authorize",
+		"token_endpoint":         i.URL + "/token",
+		"credential_endpoint":    i.URL + "/credential",
+		"credential_manifests": []interface{}{map[string]interface{}{
+			"id":                 sampleManifestID,
+			"output_descriptors": []interface{}{map[string]string{"id": "degree", "schema": sampleCredentialType}},
+		}},
+	})
+}
+
+func (i *oidcIssuer) tokenEndpoint(w http.ResponseWriter, r *http.Request) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	if r.FormValue("grant_type") != "authorization_code" || r.FormValue("code") != i.code ||
+		r.FormValue("client_id") != sampleClientID ||
+		oidc.CodeChallenge(r.FormValue("code_verifier")) != i.challenge {
+		http.Error(w, `{"error": "invalid_grant"}`, http.StatusBadRequest)
+
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{ // nolint:errcheck
+		"token_type":   "Bearer",
+		"access_token": i.token,
+		"expires_in":   3600,
+	})
+}
+
+func (i *oidcIssuer) credentialEndpoint(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer "+i.token {
+		http.Error(w, `{"error": "invalid_token"}`, http.StatusUnauthorized)
+
+		return
+	}
+
+	if r.FormValue("type") != sampleCredentialType || r.FormValue("format") != credentialFormatLDP {
+		http.Error(w, `{"error": "unsupported_credential_type"}`, http.StatusBadRequest)
+
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(&issuerCredentialResp{ // nolint:errcheck
+		Format:     credentialFormatLDP,
+		Credential: i.credential,
+	})
+}
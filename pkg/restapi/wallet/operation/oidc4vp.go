@@ -0,0 +1,474 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/pkg/crypto"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/jose"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/jwt"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/presexch"
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+	"github.com/hyperledger/aries-framework-go/pkg/vdr/fingerprint"
+	"github.com/hyperledger/aries-framework-go/pkg/wallet"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+
+	"github.com/trustbloc/wallet/pkg/restapi/common"
+	"github.com/trustbloc/wallet/pkg/restapi/common/store"
+)
+
+// response modes of OIDC4VP authorization responses.
+const (
+	responseModeQuery    = "query"
+	responseModeFormPost = "form_post"
+)
+
+const (
+	// idTokenLifetime is how long the self-issued ID tokens of the wallet are valid.
+	idTokenLifetime = 10 * time.Minute
+
+	// selfIssuedKeyStoreName is the name of the store recording the keys signing the self-issued ID tokens of users.
+	selfIssuedKeyStoreName = "wallet_self_issued_keys"
+
+	// selfIssuedKeyMetadataID is the ID of the wallet metadata in which the key signing the self-issued ID tokens was
+	// recorded before the keys were recorded by the server. Users cannot add metadata with this ID.
+	selfIssuedKeyMetadataID = "urn:trustbloc:wallet:self-issued-key"
+)
+
+// formPostTemplate is the page posting an authorization response to the verifier in the form_post response mode.
+var formPostTemplate = template.Must(template.New("form_post").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Submit This Form</title></head>
+<body onload="javascript:document.forms[0].submit()">
+<form method="post" action="{{.RedirectURI}}">
+{{range $name, $value := .Params}}<input type="hidden" name="{{$name}}" value="{{$value}}"/>
+{{end}}<noscript><button type="submit">Continue</button></noscript>
+</form>
+</body>
+</html>
+`))
+
+// authorizationRequest validates the OIDC4VP authorization request of a verifier. Returns the presentation
+// definition requested by the verifier and the credentials of the wallet matching it, which the user presents with
+// authorize.
+func (o *Operation) authorizationRequest(w http.ResponseWriter, r *http.Request) {
+	req := &authorizationRequestReq{}
+
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "invalid request: %s", err.Error())
+
+		return
+	}
+
+	if req.UserID == "" || req.Auth == "" {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing user ID or auth token")
+
+		return
+	}
+
+	resp, err := parseAuthorizationRequest(req.Request)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "invalid authorization request: %s", err.Error())
+
+		return
+	}
+
+	vcWallet, ok := o.openWallet(w, req.UserID)
+	if !ok {
+		return
+	}
+
+	query := []*wallet.QueryParams{{
+		Type:  wallet.PresentationExchange.Name(),
+		Query: []json.RawMessage{resp.PresentationDefinition},
+	}}
+
+	results, diagnostics, err := o.runQuery(vcWallet, req.Auth, query)
+	if err != nil {
+		writeWalletError(w, err, "failed to query credentials")
+
+		return
+	}
+
+	resp.Results, resp.Diagnostic = results, diagnostics[0]
+
+	common.WriteResponse(w, logger, resp)
+}
+
+// authorize answers the OIDC4VP authorization request of a verifier with a presentation of the stored credentials
+// selected by the user, signed over the nonce of the request, and a self-issued ID token. Returns the URL redirecting
+// to the verifier with the response in the query, or a page posting it to the verifier in the form_post response
+// mode.
+func (o *Operation) authorize(w http.ResponseWriter, r *http.Request) {
+	req := &authorizeReq{}
+
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "invalid request: %s", err.Error())
+
+		return
+	}
+
+	if req.UserID == "" || req.Auth == "" {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing user ID or auth token")
+
+		return
+	}
+
+	if len(req.CredentialIDs) == 0 {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing credentials to present")
+
+		return
+	}
+
+	if req.ProofOptions == nil || req.ProofOptions.Controller == "" {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing proof controller")
+
+		return
+	}
+
+	request, err := parseAuthorizationRequest(req.Request)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "invalid authorization request: %s", err.Error())
+
+		return
+	}
+
+	vcWallet, ok := o.openWallet(w, req.UserID)
+	if !ok {
+		return
+	}
+
+	// the presentation is bound to the request with the nonce as challenge and the client ID as domain.
+	options := *req.ProofOptions
+	options.Challenge = request.Nonce
+	options.Domain = request.ClientID
+
+	vp, err := o.submitCredentials(vcWallet, req.Auth, request.PresentationDefinition, req.CredentialIDs, &options)
+	if err != nil {
+		writeWalletError(w, err, "failed to present credentials")
+
+		return
+	}
+
+	vpToken, err := vp.MarshalJSON()
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "failed to marshal presentation: %s",
+			err.Error())
+
+		return
+	}
+
+	idToken, err := o.selfIssuedIDToken(vcWallet, &req.walletAuth, request, vp.CustomFields["presentation_submission"])
+	if err != nil {
+		writeWalletError(w, err, "failed to sign id token")
+
+		return
+	}
+
+	params := map[string]string{
+		"id_token": idToken,
+		"vp_token": string(vpToken),
+		"state":    request.State,
+	}
+
+	if request.ResponseMode == responseModeFormPost {
+		writeFormPost(w, request.RedirectURI, params)
+
+		return
+	}
+
+	// parseAuthorizationRequest checked the redirect URI.
+	redirectURI, _ := url.Parse(request.RedirectURI) // nolint:errcheck
+	query := redirectURI.Query()
+
+	for name, value := range params {
+		query.Set(name, value)
+	}
+
+	redirectURI.RawQuery = query.Encode()
+
+	common.WriteResponse(w, logger, &authorizeResp{RedirectURL: redirectURI.String()})
+}
+
+// selfIssuedIDToken returns a self-issued ID token for the authorization request, with the submission of the
+// presentation answering it. The token is signed with the self-issued key of the user, and identifies the user with
+// the did:key of that key.
+func (o *Operation) selfIssuedIDToken(vcWallet *wallet.Wallet, auth *walletAuth, request *authorizationRequestResp,
+	submission interface{}) (string, error) {
+	signer, didKey, err := o.selfIssuedSigner(vcWallet, auth)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+
+	token, err := jwt.NewSigned(&idTokenClaims{
+		Issuer:    didKey,
+		Subject:   didKey,
+		Audience:  request.ClientID,
+		Nonce:     request.Nonce,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(idTokenLifetime).Unix(),
+		VPToken:   &vpTokenClaim{PresentationSubmission: submission},
+	}, nil, signer)
+	if err != nil {
+		return "", err
+	}
+
+	return token.Serialize(false)
+}
+
+// selfIssuedSigner returns the signer of the self-issued key of the user, kept in the agent KMS, and the did:key of
+// that key. The key is only used with the token of the wallet the user unlocked.
+func (o *Operation) selfIssuedSigner(vcWallet *wallet.Wallet, auth *walletAuth) (*idTokenSigner, string, error) {
+	err := o.checkWalletToken(vcWallet, auth)
+	if err != nil {
+		return nil, "", err
+	}
+
+	keyID, err := o.selfIssuedKeyID(auth.UserID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	pubKey, _, err := o.ctx.KMS().ExportPubKeyBytes(keyID)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to export self-issued key: %w", err)
+	}
+
+	kh, err := o.ctx.KMS().Get(keyID)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get self-issued key: %w", err)
+	}
+
+	didKey, kid := fingerprint.CreateDIDKey(pubKey)
+
+	return &idTokenSigner{crypto: o.ctx.Crypto(), kh: kh, kid: kid}, didKey, nil
+}
+
+// selfIssuedKeyID returns the ID of the key signing the self-issued ID tokens of the user, creating the key on first
+// use.
+func (o *Operation) selfIssuedKeyID(userID string) (string, error) {
+	o.selfIssuedKeyMutex.Lock()
+	defer o.selfIssuedKeyMutex.Unlock()
+
+	raw, err := o.selfIssuedKeyStore.Get(userID)
+	if err == nil {
+		key := &selfIssuedKey{}
+
+		err = json.Unmarshal(raw, key)
+		if err != nil {
+			return "", fmt.Errorf("invalid self-issued key record: %w", err)
+		}
+
+		return key.KeyID, nil
+	}
+
+	if !errors.Is(err, storage.ErrDataNotFound) {
+		return "", fmt.Errorf("failed to get self-issued key: %w", err)
+	}
+
+	keyID, _, err := o.ctx.KMS().CreateAndExportPubKeyBytes(kms.ED25519Type)
+	if err != nil {
+		return "", fmt.Errorf("failed to create self-issued key: %w", err)
+	}
+
+	err = store.Save(o.selfIssuedKeyStore, userID, &selfIssuedKey{KeyID: keyID})
+	if err != nil {
+		return "", fmt.Errorf("failed to save self-issued key: %w", err)
+	}
+
+	return keyID, nil
+}
+
+// idTokenSigner signs ID tokens, and JWT credentials and presentations, with an Ed25519 key of the agent KMS.
+type idTokenSigner struct {
+	crypto crypto.Crypto
+	kh     interface{}
+	kid    string
+}
+
+func (s *idTokenSigner) Sign(data []byte) ([]byte, error) {
+	return s.crypto.Sign(data, s.kh)
+}
+
+func (s *idTokenSigner) Headers() jose.Headers {
+	return jose.Headers{
+		jose.HeaderAlgorithm: "EdDSA",
+		jose.HeaderKeyID:     s.kid,
+	}
+}
+
+// parseAuthorizationRequest parses and validates an OIDC4VP authorization request, given as the URL the verifier
+// sent the user to or as its query.
+func parseAuthorizationRequest(raw string) (*authorizationRequestResp, error) {
+	if raw == "" {
+		return nil, errors.New("missing request")
+	}
+
+	params, err := queryParams(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if params.Get("request") != "" || params.Get("request_uri") != "" {
+		return nil, errors.New("request objects are not supported")
+	}
+
+	request := &authorizationRequestResp{
+		ClientID:     params.Get("client_id"),
+		RedirectURI:  params.Get("redirect_uri"),
+		State:        params.Get("state"),
+		Nonce:        params.Get("nonce"),
+		ResponseMode: params.Get("response_mode"),
+	}
+
+	for _, name := range []string{"client_id", "redirect_uri", "state", "nonce"} {
+		if params.Get(name) == "" {
+			return nil, fmt.Errorf("missing %s", name)
+		}
+	}
+
+	redirectURI, err := url.Parse(request.RedirectURI)
+	if err != nil || (redirectURI.Scheme != "https" && redirectURI.Scheme != "http") || redirectURI.Host == "" ||
+		redirectURI.Fragment != "" {
+		return nil, fmt.Errorf("invalid redirect_uri %s", request.RedirectURI)
+	}
+
+	// without client metadata, the client ID is the only registration of the redirect URI the wallet can check.
+	if request.RedirectURI != request.ClientID && !sameOrigin(request.ClientID, redirectURI) {
+		return nil, fmt.Errorf("redirect_uri %s does not match client_id %s", request.RedirectURI, request.ClientID)
+	}
+
+	if !containsAll([]string{"openid"}, strings.Fields(params.Get("scope"))) {
+		return nil, errors.New("scope must include openid")
+	}
+
+	if responseType := strings.Fields(params.Get("response_type")); len(responseType) > 0 &&
+		!containsAll(responseType, []string{"id_token", "vp_token"}) {
+		return nil, fmt.Errorf("unsupported response_type %s", params.Get("response_type"))
+	}
+
+	switch request.ResponseMode {
+	case "":
+		request.ResponseMode = responseModeQuery
+	case responseModeQuery, responseModeFormPost:
+	default:
+		return nil, fmt.Errorf("unsupported response_mode %s", request.ResponseMode)
+	}
+
+	request.PresentationDefinition, err = requestedDefinition(params)
+	if err != nil {
+		return nil, err
+	}
+
+	return request, nil
+}
+
+// requestedDefinition returns the presentation definition of an authorization request, given in its vp_token claim
+// or as a parameter.
+func requestedDefinition(params url.Values) (json.RawMessage, error) {
+	raw := json.RawMessage(params.Get("presentation_definition"))
+
+	if claims := params.Get("claims"); claims != "" {
+		parsed := &struct {
+			VPToken struct {
+				Definition json.RawMessage `json:"presentation_definition"`
+			} `json:"vp_token"`
+		}{}
+
+		err := json.Unmarshal([]byte(claims), parsed)
+		if err != nil {
+			return nil, fmt.Errorf("invalid claims: %w", err)
+		}
+
+		raw = parsed.VPToken.Definition
+	}
+
+	if len(raw) == 0 {
+		return nil, errors.New("missing presentation definition")
+	}
+
+	definition := &presexch.PresentationDefinition{}
+
+	err := json.Unmarshal(raw, definition)
+	if err != nil {
+		return nil, fmt.Errorf("invalid presentation definition: %w", err)
+	}
+
+	err = definition.ValidateSchema()
+	if err != nil {
+		return nil, fmt.Errorf("invalid presentation definition: %w", err)
+	}
+
+	return raw, nil
+}
+
+// sameOrigin returns whether a client ID is a URL with the origin of a redirect URI.
+func sameOrigin(clientID string, redirectURI *url.URL) bool {
+	client, err := url.Parse(clientID)
+	if err != nil {
+		return false
+	}
+
+	return strings.EqualFold(client.Scheme, redirectURI.Scheme) && strings.EqualFold(client.Host, redirectURI.Host)
+}
+
+// queryParams returns the parameters in the query of a URL, or in a query given alone.
+func queryParams(raw string) (url.Values, error) {
+	rawQuery := strings.TrimPrefix(raw, "?")
+
+	if u, err := url.Parse(raw); err == nil && u.RawQuery != "" {
+		rawQuery = u.RawQuery
+	}
+
+	return url.ParseQuery(rawQuery)
+}
+
+// writeFormPost writes a page posting the parameters of an authorization response to the redirect URI.
+func writeFormPost(w http.ResponseWriter, redirectURI string, params map[string]string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	err := formPostTemplate.Execute(w, map[string]interface{}{
+		"RedirectURI": redirectURI,
+		"Params":      params,
+	})
+	if err != nil {
+		logger.Errorf("failed to write form post response: %s", err)
+	}
+}
+
+// containsAll returns whether values contain all the wanted values.
+func containsAll(wanted, values []string) bool {
+	for _, want := range wanted {
+		found := false
+
+		for _, value := range values {
+			if value == want {
+				found = true
+
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,330 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation // nolint:testpackage // changing to different package requires exposing internal features.
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/jwt"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/signature/verifier"
+	"github.com/hyperledger/aries-framework-go/pkg/vdr/fingerprint"
+	"github.com/hyperledger/aries-framework-go/pkg/wallet"
+	"github.com/stretchr/testify/require"
+)
+
+// sampleVerifierClientID is the client ID of the verifier of the sample authorization requests, with the origin of
+// their redirect URI.
+const sampleVerifierClientID = "https://verifier.example.com"
+
+func TestOperation_AuthorizationRequest(t *testing.T) {
+	t.Run("match the credentials requested", func(t *testing.T) {
+		router := newRouter(t, newProvider(t))
+		auth := unlock(t, router)
+		degreeID, otherID := addSampleCredentials(t, router, auth)
+
+		rr := serve(router, http.MethodPost, authorizationRequestPath, &authorizationRequestReq{
+			walletAuth: auth,
+			Request:    "https://wallet.example.com/oidc/auth?" + authorizationRequestQuery(t, nil),
+		})
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		resp := &struct {
+			authorizationRequestResp
+			Results []json.RawMessage `json:"results"`
+		}{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), resp))
+		require.Equal(t, sampleVerifierClientID, resp.ClientID)
+		require.Equal(t, "https://verifier.example.com/cb", resp.RedirectURI)
+		require.Equal(t, "state", resp.State)
+		require.Equal(t, "nonce", resp.Nonce)
+		require.Equal(t, responseModeQuery, resp.ResponseMode)
+		require.Contains(t, string(resp.PresentationDefinition), "input_descriptors")
+		require.Len(t, resp.Results, 1)
+		require.Contains(t, string(resp.Results[0]), degreeID)
+		require.Equal(t, []string{degreeID}, resp.Diagnostic.Matched)
+		require.Equal(t, otherID, resp.Diagnostic.Excluded[0].CredentialID)
+	})
+
+	t.Run("accept a redirect URI used as client ID", func(t *testing.T) {
+		router := newRouter(t, newProvider(t))
+		auth := unlock(t, router)
+
+		rr := serve(router, http.MethodPost, authorizationRequestPath, &authorizationRequestReq{
+			walletAuth: auth,
+			Request: authorizationRequestQuery(t, func(q url.Values) {
+				q.Set("client_id", "https://other.example.com/cb")
+				q.Set("redirect_uri", "https://other.example.com/cb")
+			}),
+		})
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	})
+
+	t.Run("error if the authorization request is invalid", func(t *testing.T) {
+		router := newRouter(t, newProvider(t))
+		auth := unlock(t, router)
+
+		rr := serve(router, http.MethodPost, authorizationRequestPath, "invalid")
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		requireErrorResponse(t, rr.Body.Bytes(), "invalid request")
+
+		rr = serve(router, http.MethodPost, authorizationRequestPath, &authorizationRequestReq{
+			walletAuth: walletAuth{UserID: auth.UserID},
+		})
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		requireErrorResponse(t, rr.Body.Bytes(), "missing user ID or auth token")
+
+		for msg, mutate := range map[string]func(url.Values){
+			"missing client_id":    func(q url.Values) { q.Del("client_id") },
+			"missing redirect_uri": func(q url.Values) { q.Del("redirect_uri") },
+			"missing state":        func(q url.Values) { q.Del("state") },
+			"missing nonce":        func(q url.Values) { q.Del("nonce") },
+			"invalid redirect_uri": func(q url.Values) { q.Set("redirect_uri", "/cb") },
+			"redirect_uri https://attacker.example.com/cb does not match": func(q url.Values) {
+				q.Set("redirect_uri", "https://attacker.example.com/cb")
+			},
+			"redirect_uri https://verifier.example.com/cb does not match client_id demo-verifier": func(q url.Values) {
+				q.Set("client_id", "demo-verifier")
+			},
+			"scope must include": func(q url.Values) { q.Set("scope", "profile") },
+			"unsupported response_type": func(q url.Values) {
+				q.Set("response_type", "code")
+			},
+			"unsupported response_mode": func(q url.Values) {
+				q.Set("response_mode", "fragment")
+			},
+			"request objects are not supported": func(q url.Values) {
+				q.Set("request_uri", "https://verifier.example.com/request")
+			},
+			"invalid claims":                  func(q url.Values) { q.Set("claims", "invalid") },
+			"missing presentation definition": func(q url.Values) { q.Set("claims", "{}") },
+			"invalid presentation definition:": func(q url.Values) {
+				q.Set("claims", `{"vp_token": {"presentation_definition": {"id": "invalid"}}}`)
+			},
+		} {
+			rr = serve(router, http.MethodPost, authorizationRequestPath, &authorizationRequestReq{
+				walletAuth: auth,
+				Request:    authorizationRequestQuery(t, mutate),
+			})
+			require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+			requireErrorResponse(t, rr.Body.Bytes(), "invalid authorization request: "+msg)
+		}
+	})
+
+	t.Run("error if the auth token is invalid", func(t *testing.T) {
+		router := newRouter(t, newProvider(t))
+		auth := unlock(t, router)
+		auth.Auth = "invalid"
+
+		rr := serve(router, http.MethodPost, authorizationRequestPath, &authorizationRequestReq{
+			walletAuth: auth,
+			Request:    authorizationRequestQuery(t, nil),
+		})
+		require.Equal(t, http.StatusUnauthorized, rr.Code, rr.Body.String())
+	})
+}
+
+func TestOperation_Authorize(t *testing.T) {
+	t.Run("return the redirect URL with the response in the query", func(t *testing.T) {
+		router := newRouter(t, newProvider(t))
+		auth := unlock(t, router)
+		degreeID, _ := addSampleCredentials(t, router, auth)
+		controller := addEd25519Key(t, router, auth)
+
+		var subject string
+
+		for i := 0; i < 2; i++ {
+			rr := serve(router, http.MethodPost, authorizePath, &authorizeReq{
+				walletAuth:    auth,
+				Request:       authorizationRequestQuery(t, nil),
+				CredentialIDs: []string{degreeID},
+				ProofOptions:  &wallet.ProofOptions{Controller: controller},
+			})
+			require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+			resp := &authorizeResp{}
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), resp))
+
+			location, err := url.Parse(resp.RedirectURL)
+			require.NoError(t, err)
+			require.Equal(t, "verifier.example.com", location.Host)
+			require.Equal(t, "/cb", location.Path)
+			require.Equal(t, "state", location.Query().Get("state"))
+
+			claims := requireIDToken(t, location.Query().Get("id_token"))
+			require.NotEmpty(t, claims.VPToken.PresentationSubmission)
+
+			if subject != "" {
+				require.Equal(t, subject, claims.Subject, "self-issued key changed")
+			}
+
+			subject = claims.Subject
+
+			vp := &struct {
+				Submission struct {
+					DefinitionID string `json:"definition_id"`
+				} `json:"presentation_submission"`
+				Proof struct {
+					Challenge string `json:"challenge"`
+					Domain    string `json:"domain"`
+				} `json:"proof"`
+			}{}
+			require.NoError(t, json.Unmarshal([]byte(location.Query().Get("vp_token")), vp))
+			require.NotEmpty(t, vp.Submission.DefinitionID)
+			require.Equal(t, "nonce", vp.Proof.Challenge)
+			require.Equal(t, sampleVerifierClientID, vp.Proof.Domain)
+		}
+	})
+
+	t.Run("post the response in the form_post mode", func(t *testing.T) {
+		router := newRouter(t, newProvider(t))
+		auth := unlock(t, router)
+		degreeID, _ := addSampleCredentials(t, router, auth)
+
+		rr := serve(router, http.MethodPost, authorizePath, &authorizeReq{
+			walletAuth: auth,
+			Request: authorizationRequestQuery(t, func(q url.Values) {
+				q.Set("response_mode", responseModeFormPost)
+			}),
+			CredentialIDs: []string{degreeID},
+			ProofOptions:  &wallet.ProofOptions{Controller: addEd25519Key(t, router, auth)},
+		})
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		require.Contains(t, rr.Header().Get("Content-Type"), "text/html")
+		require.Contains(t, rr.Body.String(), `action="https://verifier.example.com/cb"`)
+
+		for _, name := range []string{"id_token", "vp_token", "state"} {
+			require.Contains(t, rr.Body.String(), fmt.Sprintf(`name="%s"`, name))
+		}
+	})
+
+	t.Run("error if the request is invalid", func(t *testing.T) {
+		router := newRouter(t, newProvider(t))
+		auth := unlock(t, router)
+
+		rr := serve(router, http.MethodPost, authorizePath, "invalid")
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		requireErrorResponse(t, rr.Body.Bytes(), "invalid request")
+
+		rr = serve(router, http.MethodPost, authorizePath, &authorizeReq{walletAuth: walletAuth{UserID: auth.UserID}})
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		requireErrorResponse(t, rr.Body.Bytes(), "missing user ID or auth token")
+
+		rr = serve(router, http.MethodPost, authorizePath, &authorizeReq{walletAuth: auth})
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		requireErrorResponse(t, rr.Body.Bytes(), "missing credentials to present")
+
+		rr = serve(router, http.MethodPost, authorizePath, &authorizeReq{walletAuth: auth, CredentialIDs: []string{"id"}})
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		requireErrorResponse(t, rr.Body.Bytes(), "missing proof controller")
+
+		rr = serve(router, http.MethodPost, authorizePath, &authorizeReq{
+			walletAuth:    auth,
+			CredentialIDs: []string{"id"},
+			ProofOptions:  &wallet.ProofOptions{Controller: "did:example:holder"},
+		})
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		requireErrorResponse(t, rr.Body.Bytes(), "invalid authorization request: missing request")
+	})
+
+	t.Run("error if the credentials do not match the presentation definition", func(t *testing.T) {
+		router := newRouter(t, newProvider(t))
+		auth := unlock(t, router)
+		_, otherID := addSampleCredentials(t, router, auth)
+
+		rr := serve(router, http.MethodPost, authorizePath, &authorizeReq{
+			walletAuth:    auth,
+			Request:       authorizationRequestQuery(t, nil),
+			CredentialIDs: []string{otherID},
+			ProofOptions:  &wallet.ProofOptions{Controller: addEd25519Key(t, router, auth)},
+		})
+		require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+		requireErrorResponse(t, rr.Body.Bytes(), "failed to present credentials: invalid credential selection")
+
+		rr = serve(router, http.MethodPost, authorizePath, &authorizeReq{
+			walletAuth:    auth,
+			Request:       authorizationRequestQuery(t, nil),
+			CredentialIDs: []string{"urn:uuid:unknown"},
+			ProofOptions:  &wallet.ProofOptions{Controller: "did:example:holder"},
+		})
+		require.Equal(t, http.StatusNotFound, rr.Code, rr.Body.String())
+	})
+
+	t.Run("error if the auth token is invalid", func(t *testing.T) {
+		router := newRouter(t, newProvider(t))
+		auth := unlock(t, router)
+		degreeID, _ := addSampleCredentials(t, router, auth)
+		auth.Auth = "invalid"
+
+		rr := serve(router, http.MethodPost, authorizePath, &authorizeReq{
+			walletAuth:    auth,
+			Request:       authorizationRequestQuery(t, nil),
+			CredentialIDs: []string{degreeID},
+			ProofOptions:  &wallet.ProofOptions{Controller: "did:example:holder"},
+		})
+		require.Equal(t, http.StatusUnauthorized, rr.Code, rr.Body.String())
+	})
+}
+
+// authorizationRequestQuery returns the query of an authorization request for the sample presentation definition,
+// changed by mutate if given.
+func authorizationRequestQuery(t *testing.T, mutate func(url.Values)) string {
+	t.Helper()
+
+	claims, err := json.Marshal(map[string]interface{}{
+		"vp_token": map[string]interface{}{
+			"presentation_definition": json.RawMessage(fmt.Sprintf(samplePresentationDefinition,
+				uuid.New().String())),
+		},
+	})
+	require.NoError(t, err)
+
+	query := url.Values{
+		"client_id":     {sampleVerifierClientID},
+		"redirect_uri":  {"https://verifier.example.com/cb"},
+		"scope":         {"openid"},
+		"response_type": {"id_token"},
+		"state":         {"state"},
+		"nonce":         {"nonce"},
+		"claims":        {string(claims)},
+	}
+
+	if mutate != nil {
+		mutate(query)
+	}
+
+	return query.Encode()
+}
+
+// requireIDToken verifies that a self-issued ID token is signed with the key of its subject DID, and returns its
+// claims.
+func requireIDToken(t *testing.T, idToken string) *idTokenClaims {
+	t.Helper()
+
+	token, err := jwt.Parse(idToken, jwt.WithSignatureVerifier(jwt.NewVerifier(jwt.KeyResolverFunc(
+		func(what, kid string) (*verifier.PublicKey, error) {
+			pubKey, err := fingerprint.PubKeyFromDIDKey(what)
+			if err != nil {
+				return nil, err
+			}
+
+			return &verifier.PublicKey{Type: "Ed25519VerificationKey2018", Value: pubKey}, nil
+		}))))
+	require.NoError(t, err)
+
+	claims := &idTokenClaims{}
+	require.NoError(t, token.DecodeClaims(claims))
+	require.Equal(t, claims.Issuer, claims.Subject)
+	require.Equal(t, sampleVerifierClientID, claims.Audience)
+	require.Equal(t, "nonce", claims.Nonce)
+	require.Greater(t, claims.ExpiresAt, claims.IssuedAt)
+
+	return claims
+}
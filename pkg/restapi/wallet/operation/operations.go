@@ -8,25 +8,739 @@ SPDX-License-Identifier: Apache-2.0
 package operation
 
 import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hyperledger/aries-framework-go/pkg/client/didexchange"
+	"github.com/hyperledger/aries-framework-go/pkg/client/issuecredential"
+	"github.com/hyperledger/aries-framework-go/pkg/client/mediator"
+	"github.com/hyperledger/aries-framework-go/pkg/client/messagepickup"
+	"github.com/hyperledger/aries-framework-go/pkg/client/outofband"
+	"github.com/hyperledger/aries-framework-go/pkg/client/outofbandv2"
+	"github.com/hyperledger/aries-framework-go/pkg/client/presentproof"
 	"github.com/hyperledger/aries-framework-go/pkg/controller/command"
+	"github.com/hyperledger/aries-framework-go/pkg/controller/command/messaging"
+	"github.com/hyperledger/aries-framework-go/pkg/controller/command/vcwallet"
 	"github.com/hyperledger/aries-framework-go/pkg/controller/rest"
+	"github.com/hyperledger/aries-framework-go/pkg/crypto"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/common/service"
+	"github.com/hyperledger/aries-framework-go/pkg/framework/aries/api/vdr"
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/piprate/json-gold/ld"
+	"github.com/trustbloc/edge-core/pkg/log"
+
+	"github.com/trustbloc/wallet/pkg/restapi/common"
+	"github.com/trustbloc/wallet/pkg/restapi/common/store"
+	"github.com/trustbloc/wallet/pkg/restapi/wallet/status"
 )
 
 // constants for endpoints of wallet server wallet  controller.
-const ()
+const (
+	createProfilePath = "/create-profile"
+	updateProfilePath = "/update-profile"
+	profileExistsPath = "/profile/{id}"
+	openPath          = "/open"
+	closePath         = "/close"
+	addPath           = "/add"
+	removePath        = "/remove"
+	getPath           = "/get"
+	getAllPath        = "/getall"
+	queryPath         = "/query"
+	issuePath         = "/issue"
+	provePath         = "/prove"
+	verifyPath        = "/verify"
+	derivePath        = "/derive"
+
+	proposeCredentialPath = "/propose-credential"
+	requestCredentialPath = "/request-credential"
+	declineCredentialPath = "/decline-credential"
+
+	proposePresentationPath = "/propose-presentation"
+	presentProofPath        = "/present-proof"
+	declinePresentationPath = "/decline-presentation"
+
+	authorizationRequestPath = "/oidc4vp/request"
+	authorizePath            = "/oidc4vp/authorize"
+
+	initiateIssuancePath = "/oidc4ci/initiate"
+	issuanceCallbackPath = "/oidc4ci/callback"
+
+	credentialStatusPath = "/credential/status"
+
+	expiringCredentialsPath = "/credentials/expiring"
+	renewCredentialPath     = "/credentials/renew"
+
+	createDIDPath     = "/did/create"
+	listDIDsPath      = "/did/list"
+	resolveDIDPath    = "/did/resolve"
+	updateDIDPath     = "/did/update"
+	deactivateDIDPath = "/did/deactivate"
+
+	acceptInvitationPath = "/connections/accept-invitation"
+	listConnectionsPath  = "/connections/list"
+	getConnectionPath    = "/connections/get"
+	renameConnectionPath = "/connections/rename"
+	removeConnectionPath = "/connections/remove"
+
+	exportBackupPath = "/backup/export"
+	importBackupPath = "/backup/import"
+
+	registerServicePath   = "/message/register-service"
+	unregisterServicePath = "/message/unregister-service"
+	servicesPath          = "/message/services"
+
+	collectionIDPathParam = "collectionID"
+	collectionPath        = "/collections/{" + collectionIDPathParam + "}"
+)
+
+var logger = log.New("wallet/operation")
 
 // Operation is REST service operation controller for wallet  features.
-type Operation struct{}
+type Operation struct {
+	ctx       Provider
+	command   *vcwallet.Command
+	messaging *messaging.Command
+	handlers  []rest.Handler
+	notifier  command.Notifier
+
+	// serviceHandlers configure the agent for all users.
+	serviceHandlers []rest.Handler
+
+	issueCredentialClient *issuecredential.Client
+	presentProofClient    *presentproof.Client
+	didexchangeClient     *didexchange.Client
+	oobClient             *outofband.Client
+	oobV2Client           *outofbandv2.Client
+	mediatorClient        *mediator.Client
+	pickupClient          *messagepickup.Client
+
+	httpClient    common.HTTPClient
+	walletAppURL  string
+	issuerClient  *http.Client
+	issuanceStore storage.Store
+
+	statusStore   storage.Store
+	statusChecker *status.Checker
+
+	schemas *credentialSchemas
+
+	expiryStore       storage.Store
+	expiryLeadTimes   []time.Duration
+	waciIssuanceStore storage.Store
+	waciShareStore    storage.Store
+
+	connectionStore storage.Store
+
+	routerConnections []string
+
+	authz *authzProvider
+
+	didKeys     *DIDKeys
+	didKeyStore storage.Store
+
+	keyStoreURLStore storage.Store
+
+	selfIssuedKeyStore storage.Store
+	selfIssuedKeyMutex sync.Mutex
+
+	// walletTokens are the tokens of the unlocked wallets, by user ID.
+	walletTokens      map[string]string
+	walletTokensMutex sync.Mutex
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
 
 // Provider describes dependencies for this command.
-type Provider interface{}
+type Provider interface {
+	StorageProvider() storage.Provider
+	VDRegistry() vdr.Registry
+	Crypto() crypto.Crypto
+	JSONLDDocumentLoader() ld.DocumentLoader
+	MediaTypeProfiles() []string
+	KMS() kms.KeyManager
+	ServiceEndpoint() string
+	Messenger() service.Messenger
+	ProtocolStateStorageProvider() storage.Provider
+	Service(id string) (interface{}, error)
+	KeyType() kms.KeyType
+	KeyAgreementType() kms.KeyType
+}
+
+type options struct {
+	httpClient             common.HTTPClient
+	walletAppURL           string
+	statusSweepInterval    time.Duration
+	expirySweepInterval    time.Duration
+	expiryLeadTimes        []time.Duration
+	mediatorURL            string
+	mediatorPickupInterval time.Duration
+	routing                bool
+	didKeys                *DIDKeys
+}
+
+// Opt configures the wallet REST controller.
+type Opt func(opts *options)
+
+// WithHTTPClient sets the client used to sign requests to the remote KMS when a wallet is unlocked with the secret
+// share of its user, and to call credential issuers.
+func WithHTTPClient(client common.HTTPClient) Opt {
+	return func(opts *options) {
+		opts.httpClient = client
+	}
+}
+
+// WithWalletAppURL sets the URL of the wallet web app, to which credential issuers redirect users once they
+// authorized an issuance.
+func WithWalletAppURL(walletAppURL string) Opt {
+	return func(opts *options) {
+		opts.walletAppURL = walletAppURL
+	}
+}
+
+// WithStatusSweepInterval sets the interval at which the statuses of the credentials stored in wallets are checked.
+// Status changes are published on the notifier. Stored credentials are not swept if the interval is zero.
+func WithStatusSweepInterval(interval time.Duration) Opt {
+	return func(opts *options) {
+		opts.statusSweepInterval = interval
+	}
+}
+
+// WithExpiryNotifications sets the interval at which the expiration dates of the credentials stored in wallets are
+// checked, and the lead times before their expiry at which credentials are published on the notifier: 30, 7 and 1
+// days if none is given. Stored credentials are not checked if the interval is zero.
+func WithExpiryNotifications(sweepInterval time.Duration, leadTimes ...time.Duration) Opt {
+	return func(opts *options) {
+		opts.expirySweepInterval = sweepInterval
+		opts.expiryLeadTimes = leadTimes
+	}
+}
+
+// WithMediator sets the URL of the out-of-band invitation of the mediator the agent registers with through route
+// coordination. Messages sent to the DIDs of the connections created afterwards are routed through the mediator, and
+// the messages it queues are picked up at the given interval. Queued messages are not picked up if it is zero.
+func WithMediator(invitationURL string, pickupInterval time.Duration) Opt {
+	return func(opts *options) {
+		opts.mediatorURL = invitationURL
+		opts.mediatorPickupInterval = pickupInterval
+	}
+}
+
+// WithRouting makes the agent act as a mediator for other agents, granting their route coordination requests.
+func WithRouting(routing bool) Opt {
+	return func(opts *options) {
+		opts.routing = routing
+	}
+}
+
+// WithDIDKeys sets the keys given to the orb VDR of the agent to sign the updates and deactivations of the did:orb
+// DIDs of wallets. Updates and deactivations of did:orb DIDs fail if the orb VDR is not created with them.
+func WithDIDKeys(keys *DIDKeys) Opt {
+	return func(opts *options) {
+		opts.didKeys = keys
+	}
+}
+
+// New returns new wallet  REST controller instance. The DIDComm events of the agent are published on the notifier if
+// there is one, and message services can be registered on the message handler if there is one.
+func New(p Provider, notifier command.Notifier, msgHandler command.MessageHandler, opts ...Opt) (*Operation, error) {
+	o := &options{httpClient: &http.Client{}}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	authz := &authzProvider{httpClient: o.httpClient}
+
+	op := &Operation{
+		ctx: p,
+		command: vcwallet.New(p, &vcwallet.Config{
+			EdvAuthzProvider:    authz,
+			WebKMSAuthzProvider: authz,
+		}),
+		notifier:        notifier,
+		httpClient:      o.httpClient,
+		walletAppURL:    o.walletAppURL,
+		expiryLeadTimes: expiryLeadTimes(o.expiryLeadTimes),
+		authz:           authz,
+		didKeys:         o.didKeys,
+		walletTokens:    map[string]string{},
+		done:            make(chan struct{}),
+	}
+
+	if op.didKeys == nil {
+		op.didKeys = NewDIDKeys()
+	}
+
+	var err error
+
+	op.issueCredentialClient, err = issuecredential.New(p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create issue credential client: %w", err)
+	}
+
+	op.presentProofClient, err = presentproof.New(p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create present proof client: %w", err)
+	}
+
+	op.didexchangeClient, err = didexchange.New(p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create DID exchange client: %w", err)
+	}
+
+	op.oobClient, err = outofband.New(p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create out-of-band client: %w", err)
+	}
+
+	op.oobV2Client, err = outofbandv2.New(p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create out-of-band v2 client: %w", err)
+	}
+
+	op.mediatorClient, err = mediator.New(p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create mediator client: %w", err)
+	}
+
+	op.pickupClient, err = messagepickup.New(p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create message pickup client: %w", err)
+	}
+
+	op.issuanceStore, err = p.StorageProvider().OpenStore(issuanceStoreName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open issuance store: %w", err)
+	}
+
+	op.statusStore, err = p.StorageProvider().OpenStore(statusStoreName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open status store: %w", err)
+	}
+
+	err = p.StorageProvider().SetStoreConfig(statusStoreName,
+		storage.StoreConfiguration{TagNames: []string{statusUserTag}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to set status store config: %w", err)
+	}
+
+	op.expiryStore, err = p.StorageProvider().OpenStore(expiryStoreName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open expiry store: %w", err)
+	}
+
+	err = p.StorageProvider().SetStoreConfig(expiryStoreName,
+		storage.StoreConfiguration{TagNames: []string{expiryUserTag}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to set expiry store config: %w", err)
+	}
+
+	op.waciIssuanceStore, err = p.StorageProvider().OpenStore(waciIssuanceStoreName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open WACI issuance store: %w", err)
+	}
+
+	op.waciShareStore, err = p.StorageProvider().OpenStore(waciShareStoreName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open WACI share store: %w", err)
+	}
+
+	op.connectionStore, err = p.StorageProvider().OpenStore(connectionStoreName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open connection store: %w", err)
+	}
+
+	err = p.StorageProvider().SetStoreConfig(connectionStoreName,
+		storage.StoreConfiguration{TagNames: []string{connectionUserTag}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to set connection store config: %w", err)
+	}
+
+	op.keyStoreURLStore, err = p.StorageProvider().OpenStore(keyStoreURLStoreName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ops keystore URL store: %w", err)
+	}
+
+	op.didKeyStore, err = p.StorageProvider().OpenStore(didKeyStoreName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open DID key store: %w", err)
+	}
+
+	op.selfIssuedKeyStore, err = p.StorageProvider().OpenStore(selfIssuedKeyStoreName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open self-issued key store: %w", err)
+	}
+
+	op.statusChecker = status.NewChecker(p.VDRegistry(), p.JSONLDDocumentLoader(), o.httpClient,
+		status.DefaultCacheTTL)
+
+	op.schemas = newCredentialSchemas(o.httpClient, common.IsPublic)
+	op.issuerClient = guardedHTTPClient(o.httpClient, common.IsPublic, issuerTimeout)
+
+	if notifier != nil {
+		err = op.observeConnections()
+		if err != nil {
+			return nil, err
+		}
+
+		err = op.observeEvents()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if msgHandler != nil {
+		op.messaging, err = messaging.New(p, msgHandler, notifier)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create messaging command: %w", err)
+		}
+	}
+
+	if o.routing {
+		err = op.grantRouteRequests()
+		if err != nil {
+			return nil, err
+		}
+	}
 
-// New returns new wallet  REST controller instance.
-func New(p Provider, notifier command.Notifier, msgHandler command.MessageHandler) (*Operation, error) {
-	return &Operation{}, nil
+	if o.mediatorURL != "" {
+		err = op.registerMediator(o.mediatorURL)
+		if err != nil {
+			return nil, err
+		}
+
+		if o.mediatorPickupInterval > 0 {
+			go op.pickupMessagesEvery(o.mediatorPickupInterval)
+		}
+	}
+
+	op.registerHandler()
+
+	if o.statusSweepInterval > 0 {
+		go op.sweepStatusesEvery(o.statusSweepInterval)
+	}
+
+	if o.expirySweepInterval > 0 {
+		go op.sweepExpiriesEvery(o.expirySweepInterval)
+	}
+
+	return op, nil
 }
 
-// GetRESTHandlers get all controller API handler available for this protocol service.
+// GetRESTHandlers get all controller API handler available for this protocol service. Wallet contents are only
+// accessed with the token returned when the user's wallet is unlocked.
 func (o *Operation) GetRESTHandlers() []rest.Handler {
-	return []rest.Handler{}
+	return o.handlers
+}
+
+// Close stops the background sweeps of credential statuses and expiries, and the pickup of the messages queued by
+// mediators.
+func (o *Operation) Close() {
+	o.closeOnce.Do(func() {
+		close(o.done)
+	})
+}
+
+// GetServiceRESTHandlers returns the handlers configuring the agent for all users. They are not bound to a wallet
+// and are meant to be called by other services only.
+func (o *Operation) GetServiceRESTHandlers() []rest.Handler {
+	return o.serviceHandlers
+}
+
+func (o *Operation) registerHandler() {
+	o.handlers = []rest.Handler{
+		common.NewHTTPHandler(createProfilePath, http.MethodPost, o.createProfile),
+		common.NewHTTPHandler(updateProfilePath, http.MethodPost, o.updateProfile),
+		common.NewHTTPHandler(profileExistsPath, http.MethodGet, o.profileExists),
+		common.NewHTTPHandler(openPath, http.MethodPost, o.open),
+		common.NewHTTPHandler(closePath, http.MethodPost, o.close),
+		common.NewHTTPHandler(addPath, http.MethodPost, o.addContent),
+		common.NewHTTPHandler(removePath, http.MethodPost, o.removeContent),
+		common.NewHTTPHandler(getPath, http.MethodPost, o.getContent),
+		common.NewHTTPHandler(getAllPath, http.MethodPost, o.getAllContent),
+		common.NewHTTPHandler(collectionPath+addPath, http.MethodPost, o.addContent),
+		common.NewHTTPHandler(collectionPath+removePath, http.MethodPost, o.removeContent),
+		common.NewHTTPHandler(collectionPath+getPath, http.MethodPost, o.getContent),
+		common.NewHTTPHandler(collectionPath+getAllPath, http.MethodPost, o.getAllContent),
+		common.NewHTTPHandler(queryPath, http.MethodPost, o.query),
+		common.NewHTTPHandler(issuePath, http.MethodPost, o.issue),
+		common.NewHTTPHandler(provePath, http.MethodPost, o.prove),
+		common.NewHTTPHandler(verifyPath, http.MethodPost, o.verify),
+		common.NewHTTPHandler(derivePath, http.MethodPost, o.derive),
+		common.NewHTTPHandler(proposeCredentialPath, http.MethodPost, o.proposeCredential),
+		common.NewHTTPHandler(requestCredentialPath, http.MethodPost, o.requestCredential),
+		common.NewHTTPHandler(declineCredentialPath, http.MethodPost, o.declineCredential),
+		common.NewHTTPHandler(proposePresentationPath, http.MethodPost, o.proposePresentation),
+		common.NewHTTPHandler(presentProofPath, http.MethodPost, o.presentProof),
+		common.NewHTTPHandler(declinePresentationPath, http.MethodPost, o.declinePresentation),
+		common.NewHTTPHandler(authorizationRequestPath, http.MethodPost, o.authorizationRequest),
+		common.NewHTTPHandler(authorizePath, http.MethodPost, o.authorize),
+		common.NewHTTPHandler(initiateIssuancePath, http.MethodPost, o.initiateIssuance),
+		common.NewHTTPHandler(issuanceCallbackPath, http.MethodPost, o.issuanceCallback),
+		common.NewHTTPHandler(credentialStatusPath, http.MethodPost, o.credentialStatus),
+		common.NewHTTPHandler(expiringCredentialsPath, http.MethodPost, o.expiringCredentials),
+		common.NewHTTPHandler(renewCredentialPath, http.MethodPost, o.renewCredential),
+		common.NewHTTPHandler(createDIDPath, http.MethodPost, o.createDID),
+		common.NewHTTPHandler(listDIDsPath, http.MethodPost, o.listDIDs),
+		common.NewHTTPHandler(resolveDIDPath, http.MethodPost, o.resolveDID),
+		common.NewHTTPHandler(updateDIDPath, http.MethodPost, o.updateDID),
+		common.NewHTTPHandler(deactivateDIDPath, http.MethodPost, o.deactivateDID),
+		common.NewHTTPHandler(acceptInvitationPath, http.MethodPost, o.acceptInvitation),
+		common.NewHTTPHandler(listConnectionsPath, http.MethodPost, o.listConnections),
+		common.NewHTTPHandler(getConnectionPath, http.MethodPost, o.getConnection),
+		common.NewHTTPHandler(renameConnectionPath, http.MethodPost, o.renameConnection),
+		common.NewHTTPHandler(removeConnectionPath, http.MethodPost, o.removeConnection),
+		common.NewHTTPHandler(exportBackupPath, http.MethodPost, o.exportBackup),
+		common.NewHTTPHandler(importBackupPath, http.MethodPost, o.importBackup),
+	}
+
+	if o.messaging != nil {
+		o.serviceHandlers = append(o.serviceHandlers,
+			common.NewHTTPHandler(registerServicePath, http.MethodPost, o.registerService),
+			common.NewHTTPHandler(unregisterServicePath, http.MethodPost, o.unregisterService),
+			common.NewHTTPHandler(servicesPath, http.MethodGet, o.services),
+		)
+	}
+}
+
+// createProfile creates a wallet profile. Fails if the user already has a profile. Only the session of the user or an
+// API token authorizes the creation. The URL of the ops keystore of the profile is recorded, to check the keys that
+// seal backups.
+func (o *Operation) createProfile(w http.ResponseWriter, r *http.Request) {
+	body, ok := o.authorizeUser(w, r)
+	if !ok {
+		return
+	}
+
+	request := &vcwallet.CreateOrUpdateProfileRequest{}
+
+	err := json.Unmarshal(body, request)
+	if err != nil {
+		rest.SendHTTPStatusError(w, http.StatusBadRequest, vcwallet.InvalidRequestErrorCode, err)
+
+		return
+	}
+
+	response := &bytes.Buffer{}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	cmdErr := o.command.CreateProfile(response, bytes.NewReader(body))
+	if cmdErr != nil {
+		rest.SendError(w, cmdErr)
+
+		return
+	}
+
+	if request.KeyStoreURL != "" {
+		err = store.Save(o.keyStoreURLStore, request.UserID, &walletKeyStore{URL: request.KeyStoreURL})
+		if err != nil {
+			common.WriteErrorResponsef(w, logger, http.StatusInternalServerError,
+				"failed to save ops keystore of wallet: %s", err.Error())
+
+			return
+		}
+	}
+
+	_, err = w.Write(response.Bytes())
+	if err != nil {
+		logger.Errorf("failed to write response: %s", err.Error())
+	}
+}
+
+// updateProfile updates the wallet profile of a user. Changing the KMS or EDV options of a profile makes the keys or
+// contents held with the previous options inaccessible. The ops keystore of a profile cannot be changed. The session
+// of the user, an API token or the auth token of the unlocked wallet authorizes the update.
+func (o *Operation) updateProfile(w http.ResponseWriter, r *http.Request) {
+	body, ok := o.authorizeUser(w, r)
+	if !ok {
+		return
+	}
+
+	request := &vcwallet.CreateOrUpdateProfileRequest{}
+
+	err := json.Unmarshal(body, request)
+	if err != nil {
+		rest.SendHTTPStatusError(w, http.StatusBadRequest, vcwallet.InvalidRequestErrorCode, err)
+
+		return
+	}
+
+	keyStoreURL, err := o.walletKeyStoreURL(request.UserID)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "failed to update profile: %s",
+			err.Error())
+
+		return
+	}
+
+	if request.KeyStoreURL != keyStoreURL {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "the ops keystore of a wallet cannot be changed")
+
+		return
+	}
+
+	rest.Execute(o.command.UpdateProfile, w, bytes.NewReader(body))
+}
+
+// profileExists fails with an error if the user with the ID in the path has no wallet profile.
+func (o *Operation) profileExists(w http.ResponseWriter, r *http.Request) {
+	bits, err := json.Marshal(&vcwallet.WalletUser{ID: mux.Vars(r)["id"]})
+	if err != nil {
+		rest.SendHTTPStatusError(w, http.StatusInternalServerError, vcwallet.InvalidRequestErrorCode, err)
+
+		return
+	}
+
+	rest.Execute(o.command.ProfileExists, w, bytes.NewReader(bits))
+}
+
+// open unlocks the wallet of a user with a local KMS passphrase, with remote KMS and EDV access tokens, or with the
+// capabilities and secret share handed to the user when onboarded. Returns a token authorizing wallet operations. The
+// expiries of the credentials of the wallet that are not tracked yet are tracked once the wallet is unlocked.
+func (o *Operation) open(w http.ResponseWriter, r *http.Request) {
+	request := &vcwallet.UnlockWalletRequest{}
+
+	body, err := io.ReadAll(r.Body)
+	if err == nil {
+		err = json.Unmarshal(body, request)
+	}
+
+	if err != nil {
+		rest.SendHTTPStatusError(w, http.StatusBadRequest, vcwallet.InvalidRequestErrorCode, err)
+
+		return
+	}
+
+	response := &bytes.Buffer{}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	cmdErr := o.command.Open(response, bytes.NewReader(body))
+	if cmdErr != nil {
+		rest.SendError(w, cmdErr)
+
+		return
+	}
+
+	unlocked := &vcwallet.UnlockWalletResponse{}
+
+	err = json.Unmarshal(response.Bytes(), unlocked)
+	if err == nil {
+		o.walletTokensMutex.Lock()
+		o.walletTokens[request.UserID] = unlocked.Token
+		o.walletTokensMutex.Unlock()
+
+		o.backfillExpiries(request.UserID, unlocked.Token)
+	}
+
+	_, err = w.Write(response.Bytes())
+	if err != nil {
+		logger.Errorf("failed to write response: %s", err.Error())
+	}
+}
+
+// close locks the wallet of a user and expires its token. The session of the user, an API token or the auth token of
+// the wallet authorizes the lock.
+func (o *Operation) close(w http.ResponseWriter, r *http.Request) {
+	body, ok := o.authorizeUser(w, r)
+	if !ok {
+		return
+	}
+
+	request := &vcwallet.LockWalletRequest{}
+
+	err := json.Unmarshal(body, request)
+	if err == nil {
+		o.walletTokensMutex.Lock()
+		delete(o.walletTokens, request.UserID)
+		o.walletTokensMutex.Unlock()
+	}
+
+	rest.Execute(o.command.Close, w, bytes.NewReader(body))
+}
+
+// profileRequest is the user ID of the profile and wallet requests, with the auth token of the unlocked wallet of the
+// user.
+type profileRequest struct {
+	UserID string `json:"userID"`
+	Auth   string `json:"auth,omitempty"`
+}
+
+// authorizeUser returns the body of a profile or wallet request if the request acts for any user, is authenticated by
+// the session of the user, or holds the auth token of the wallet the user unlocked last. Auth tokens are not bound to
+// users by the wallet itself.
+func (o *Operation) authorizeUser(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	request := &profileRequest{}
+
+	body, err := io.ReadAll(r.Body)
+	if err == nil {
+		err = json.Unmarshal(body, request)
+	}
+
+	if err != nil {
+		rest.SendHTTPStatusError(w, http.StatusBadRequest, vcwallet.InvalidRequestErrorCode, err)
+
+		return nil, false
+	}
+
+	if userID, found := common.SessionUser(r.Context()); found {
+		if userID != request.UserID {
+			common.WriteErrorResponsef(w, logger, http.StatusForbidden, "session is not authorized for user %s",
+				request.UserID)
+
+			return nil, false
+		}
+
+		return body, true
+	}
+
+	if common.AnyUser(r.Context()) {
+		return body, true
+	}
+
+	if request.Auth == "" || !o.isWalletToken(request.UserID, request.Auth) {
+		common.WriteErrorResponsef(w, logger, http.StatusUnauthorized, "missing or invalid auth token")
+
+		return nil, false
+	}
+
+	_, ok := o.openUnlockedWallet(w, request.UserID, request.Auth)
+
+	return body, ok
+}
+
+// isWalletToken returns true if the auth token is the token of the wallet the user unlocked last.
+func (o *Operation) isWalletToken(userID, auth string) bool {
+	o.walletTokensMutex.Lock()
+	token, ok := o.walletTokens[userID]
+	o.walletTokensMutex.Unlock()
+
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(auth)) == 1
+}
+
+// guardedHTTPClient returns a copy of the HTTP client, or a new client if it is not an *http.Client, connecting only
+// to the allowed addresses and timing out requests after the timeout.
+func guardedHTTPClient(client common.HTTPClient, allow func(net.IP) bool, timeout time.Duration) *http.Client {
+	httpClient := &http.Client{}
+
+	if c, ok := client.(*http.Client); ok && c != nil {
+		copied := *c
+		httpClient = &copied
+	}
+
+	httpClient = common.GuardDial(httpClient, allow)
+	httpClient.Timeout = timeout
+
+	return httpClient
 }
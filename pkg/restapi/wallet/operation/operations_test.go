@@ -6,16 +6,272 @@ SPDX-License-Identifier: Apache-2.0
 package operation // nolint:testpackage // changing to different package requires exposing internal features.
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/hyperledger/aries-framework-go/component/storageutil/mem"
+	"github.com/hyperledger/aries-framework-go/pkg/controller/command/vcwallet"
+	"github.com/hyperledger/aries-framework-go/pkg/framework/aries"
 	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/wallet/pkg/restapi/common"
 )
 
+const samplePassphrase = "fakepassphrase"
+
 func TestNew(t *testing.T) {
 	t.Run("create new instance - success", func(t *testing.T) {
-		op, err := New(nil, nil, nil)
+		op, err := New(newProvider(t), nil, nil)
+
+		require.NoError(t, err)
+		require.Len(t, op.GetRESTHandlers(), 43)
+		require.Empty(t, op.GetServiceRESTHandlers())
+	})
+}
+
+func TestOperation_Profile(t *testing.T) {
+	t.Run("create, update and check profiles", func(t *testing.T) {
+		router := newRouter(t, newProvider(t))
+		userID := uuid.New().String()
+
+		rr := serve(router, http.MethodGet, "/profile/"+userID, nil)
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+
+		rr = serve(router, http.MethodPost, createProfilePath, &vcwallet.CreateOrUpdateProfileRequest{
+			UserID:             userID,
+			LocalKMSPassphrase: samplePassphrase,
+		})
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		rr = serve(router, http.MethodGet, "/profile/"+userID, nil)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		rr = serve(router, http.MethodPost, createProfilePath, &vcwallet.CreateOrUpdateProfileRequest{
+			UserID:             userID,
+			LocalKMSPassphrase: samplePassphrase,
+		})
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+
+		rr = serve(router, http.MethodPost, updateProfilePath, &vcwallet.CreateOrUpdateProfileRequest{
+			UserID:             userID,
+			LocalKMSPassphrase: samplePassphrase,
+		})
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	})
+
+	t.Run("only the session of the user authorizes changes of its profile", func(t *testing.T) {
+		op, err := New(newProvider(t), nil, nil)
+		require.NoError(t, err)
+
+		router := routerWithContext(op, func(ctx context.Context) context.Context {
+			return common.WithSessionUser(ctx, "user")
+		})
+
+		profile := &vcwallet.CreateOrUpdateProfileRequest{UserID: "other", LocalKMSPassphrase: samplePassphrase}
+
+		rr := serve(router, http.MethodPost, createProfilePath, profile)
+		require.Equal(t, http.StatusForbidden, rr.Code)
+
+		rr = serve(router, http.MethodPost, updateProfilePath, profile)
+		require.Equal(t, http.StatusForbidden, rr.Code)
+
+		rr = serve(router, http.MethodPost, closePath, &vcwallet.LockWalletRequest{UserID: "other"})
+		require.Equal(t, http.StatusForbidden, rr.Code)
+
+		profile.UserID = "user"
+
+		rr = serve(router, http.MethodPost, createProfilePath, profile)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		rr = serve(router, http.MethodPost, updateProfilePath, profile)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	})
+
+	t.Run("error without a session, an API token or an auth token", func(t *testing.T) {
+		op, err := New(newProvider(t), nil, nil)
+		require.NoError(t, err)
+
+		router := routerWithContext(op, func(ctx context.Context) context.Context { return ctx })
+
+		rr := serve(router, http.MethodPost, createProfilePath, &vcwallet.CreateOrUpdateProfileRequest{
+			UserID:             uuid.New().String(),
+			LocalKMSPassphrase: samplePassphrase,
+		})
+		require.Equal(t, http.StatusUnauthorized, rr.Code)
+	})
+
+	t.Run("error if the request is invalid", func(t *testing.T) {
+		router := newRouter(t, newProvider(t))
+
+		rr := serve(router, http.MethodPost, createProfilePath, "invalid")
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+
+		rr = serve(router, http.MethodPost, updateProfilePath, "invalid")
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+}
+
+func TestOperation_OpenClose(t *testing.T) {
+	t.Run("unlock and lock a wallet with a local KMS passphrase", func(t *testing.T) {
+		router := newRouter(t, newProvider(t))
+		userID := uuid.New().String()
+
+		rr := serve(router, http.MethodPost, createProfilePath, &vcwallet.CreateOrUpdateProfileRequest{
+			UserID:             userID,
+			LocalKMSPassphrase: samplePassphrase,
+		})
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		rr = serve(router, http.MethodPost, openPath, &vcwallet.UnlockWalletRequest{
+			UserID:             userID,
+			LocalKMSPassphrase: samplePassphrase,
+		})
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		unlocked := &vcwallet.UnlockWalletResponse{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), unlocked))
+		require.NotEmpty(t, unlocked.Token)
+
+		rr = serve(router, http.MethodPost, closePath, &vcwallet.LockWalletRequest{UserID: userID})
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		locked := &vcwallet.LockWalletResponse{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), locked))
+		require.True(t, locked.Closed)
+	})
+
+	t.Run("error if the passphrase is wrong", func(t *testing.T) {
+		router := newRouter(t, newProvider(t))
+		userID := uuid.New().String()
 
+		rr := serve(router, http.MethodPost, createProfilePath, &vcwallet.CreateOrUpdateProfileRequest{
+			UserID:             userID,
+			LocalKMSPassphrase: samplePassphrase,
+		})
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		rr = serve(router, http.MethodPost, openPath, &vcwallet.UnlockWalletRequest{
+			UserID:             userID,
+			LocalKMSPassphrase: "wrong",
+		})
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+	})
+
+	t.Run("error if the wallet has no profile", func(t *testing.T) {
+		router := newRouter(t, newProvider(t))
+
+		rr := serve(router, http.MethodPost, openPath, &vcwallet.UnlockWalletRequest{
+			UserID:             uuid.New().String(),
+			LocalKMSPassphrase: samplePassphrase,
+		})
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+
+		rr = serve(router, http.MethodPost, closePath, &vcwallet.LockWalletRequest{UserID: uuid.New().String()})
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+	})
+
+	t.Run("lock a wallet with its auth token", func(t *testing.T) {
+		op, err := New(newProvider(t), nil, nil)
 		require.NoError(t, err)
-		require.Len(t, op.GetRESTHandlers(), 0)
+
+		auth := unlock(t, routerOf(op))
+
+		router := routerWithContext(op, func(ctx context.Context) context.Context { return ctx })
+
+		rr := serve(router, http.MethodPost, closePath, &profileRequest{UserID: auth.UserID})
+		require.Equal(t, http.StatusUnauthorized, rr.Code)
+
+		rr = serve(router, http.MethodPost, closePath, &profileRequest{UserID: auth.UserID, Auth: "invalid"})
+		require.Equal(t, http.StatusUnauthorized, rr.Code)
+
+		other := unlock(t, routerOf(op))
+
+		rr = serve(router, http.MethodPost, closePath, &profileRequest{UserID: other.UserID, Auth: auth.Auth})
+		require.Equal(t, http.StatusUnauthorized, rr.Code)
+
+		rr = serve(router, http.MethodPost, closePath, &profileRequest{UserID: auth.UserID, Auth: auth.Auth})
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		locked := &vcwallet.LockWalletResponse{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), locked))
+		require.True(t, locked.Closed)
+
+		rr = serve(router, http.MethodPost, closePath, &profileRequest{UserID: auth.UserID, Auth: auth.Auth})
+		require.Equal(t, http.StatusUnauthorized, rr.Code)
 	})
+
+	t.Run("error if the request is invalid", func(t *testing.T) {
+		router := newRouter(t, newProvider(t))
+
+		rr := serve(router, http.MethodPost, openPath, "invalid")
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+
+		rr = serve(router, http.MethodPost, closePath, "invalid")
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+}
+
+func newProvider(t *testing.T, opts ...aries.Option) Provider {
+	t.Helper()
+
+	framework, err := aries.New(append([]aries.Option{aries.WithStoreProvider(mem.NewProvider()),
+		aries.WithProtocolStateStoreProvider(mem.NewProvider())}, opts...)...)
+	require.NoError(t, err)
+
+	t.Cleanup(func() { require.NoError(t, framework.Close()) })
+
+	ctx, err := framework.Context()
+	require.NoError(t, err)
+
+	return ctx
+}
+
+func newRouter(t *testing.T, p Provider, opts ...Opt) *mux.Router {
+	t.Helper()
+
+	op, err := New(p, nil, nil, opts...)
+	require.NoError(t, err)
+
+	return routerOf(op)
+}
+
+// routerOf routes requests to the handlers of the operation as requests authenticated with an API token.
+func routerOf(op *Operation) *mux.Router {
+	return routerWithContext(op, common.WithAnyUser)
+}
+
+// routerWithContext routes requests to the handlers of the operation with the context returned by withContext.
+func routerWithContext(op *Operation, withContext func(context.Context) context.Context) *mux.Router {
+	router := mux.NewRouter()
+
+	for _, h := range append(op.GetRESTHandlers(), op.GetServiceRESTHandlers()...) {
+		handle := h.Handle()
+
+		router.HandleFunc(h.Path(), func(w http.ResponseWriter, r *http.Request) {
+			handle(w, r.WithContext(withContext(r.Context())))
+		}).Methods(h.Method())
+	}
+
+	return router
+}
+
+func serve(router *mux.Router, method, path string, body interface{}) *httptest.ResponseRecorder {
+	var payload []byte
+
+	if s, ok := body.(string); ok {
+		payload = []byte(s)
+	} else if body != nil {
+		payload, _ = json.Marshal(body) // nolint:errchkjson // test requests
+	}
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(method, path, bytes.NewReader(payload)))
+
+	return rr
 }
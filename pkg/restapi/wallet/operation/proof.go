@@ -0,0 +1,701 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/pkg/doc/did"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/jwt"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/verifiable"
+	"github.com/hyperledger/aries-framework-go/pkg/framework/aries/api/vdr"
+	"github.com/hyperledger/aries-framework-go/pkg/wallet"
+
+	"github.com/trustbloc/wallet/pkg/restapi/common"
+	"github.com/trustbloc/wallet/pkg/restapi/wallet/status"
+)
+
+// proof formats of issued credentials and proved presentations.
+const (
+	ldpFormat = "ldp"
+	jwtFormat = "jwt"
+)
+
+// verification checks and their results.
+const (
+	proofCheck  = "proof"
+	parseCheck  = "parse"
+	expiryCheck = "expiry"
+	statusCheck = "status"
+	schemaCheck = "schema"
+
+	checkPassed  = "passed"
+	checkFailed  = "failed"
+	checkSkipped = "skipped"
+)
+
+const (
+	// jsonSchemaType is the type of the credential schemas credentials are validated against.
+	jsonSchemaType = "JsonSchemaValidator2018"
+
+	// maxSchemaSize is the maximum size of a credential schema, schemaCacheSize the maximum size of the cached
+	// schemas, and schemaCacheTTL how long schemas are used before they are fetched again.
+	maxSchemaSize   = 1 << 20
+	schemaCacheSize = 32 << 20
+	schemaCacheTTL  = time.Hour
+	schemaTimeout   = 30 * time.Second
+)
+
+// errInvalidProofRequest is returned for credentials and presentations that cannot be signed as requested.
+var errInvalidProofRequest = errors.New("invalid proof request")
+
+// issue adds a proof to a credential with a key of an unlocked wallet. In the JWT format, the credential is signed with
+// the self-issued key of the user instead, and is issued by the did:key of that key.
+func (o *Operation) issue(w http.ResponseWriter, r *http.Request) {
+	req := &issueReq{}
+
+	if !decodeProofRequest(w, r, req, &req.proofReq) {
+		return
+	}
+
+	if len(req.Credential) == 0 {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing credential")
+
+		return
+	}
+
+	vcWallet, ok := o.openWallet(w, req.UserID)
+	if !ok {
+		return
+	}
+
+	if req.Format == jwtFormat {
+		jws, err := o.issueJWT(vcWallet, req)
+		if err != nil {
+			writeWalletError(w, err, "failed to issue credential")
+
+			return
+		}
+
+		common.WriteResponse(w, logger, &issueResp{Credential: jws})
+
+		return
+	}
+
+	vc, err := vcWallet.Issue(req.Auth, req.Credential, req.ProofOptions)
+	if err != nil {
+		writeWalletError(w, err, "failed to issue credential")
+
+		return
+	}
+
+	common.WriteResponse(w, logger, &issueResp{Credential: vc})
+}
+
+// prove creates a presentation of stored or given credentials, signed with a key of an unlocked wallet. In the JWT
+// format, the presentation is signed with the self-issued key of the user instead, and is held by the did:key of that
+// key.
+func (o *Operation) prove(w http.ResponseWriter, r *http.Request) {
+	req := &proveReq{}
+
+	if !decodeProofRequest(w, r, req, &req.proofReq) {
+		return
+	}
+
+	vcWallet, ok := o.openWallet(w, req.UserID)
+	if !ok {
+		return
+	}
+
+	if req.Format == jwtFormat {
+		jws, err := o.proveJWT(vcWallet, req)
+		if err != nil {
+			writeWalletError(w, err, "failed to prove credentials")
+
+			return
+		}
+
+		common.WriteResponse(w, logger, &proveResp{Presentation: jws})
+
+		return
+	}
+
+	var opts []wallet.ProveOptions
+
+	if len(req.StoredCredentials) > 0 {
+		opts = append(opts, wallet.WithStoredCredentialsToProve(req.StoredCredentials...))
+	}
+
+	if len(req.RawCredentials) > 0 {
+		opts = append(opts, wallet.WithRawCredentialsToProve(req.RawCredentials...))
+	}
+
+	if len(req.Presentation) > 0 {
+		opts = append(opts, wallet.WithRawPresentationToProve(req.Presentation))
+	}
+
+	vp, err := vcWallet.Prove(req.Auth, req.ProofOptions, opts...)
+	if err != nil {
+		writeWalletError(w, err, "failed to prove credentials")
+
+		return
+	}
+
+	common.WriteResponse(w, logger, &proveResp{Presentation: vp})
+}
+
+// verify checks the proof, expiry, status and schema of a stored credential, a given credential, or each credential
+// of a given presentation. Credentials that cannot be parsed fail the parse check instead, and skip the other checks.
+func (o *Operation) verify(w http.ResponseWriter, r *http.Request) {
+	req := &verifyReq{}
+
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "invalid request: %s", err.Error())
+
+		return
+	}
+
+	if req.UserID == "" || req.Auth == "" {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing user ID or auth token")
+
+		return
+	}
+
+	if req.StoredCredentialID == "" && len(req.RawCredential) == 0 && len(req.Presentation) == 0 {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing credential or presentation to verify")
+
+		return
+	}
+
+	vcWallet, ok := o.openWallet(w, req.UserID)
+	if !ok {
+		return
+	}
+
+	req.RawCredential, req.Presentation = unquoteJWT(req.RawCredential), unquoteJWT(req.Presentation)
+
+	var checks []*verificationCheck
+
+	switch {
+	case req.StoredCredentialID != "":
+		raw, err := vcWallet.Get(req.Auth, wallet.Credential, req.StoredCredentialID)
+		if err != nil {
+			writeWalletError(w, err, "failed to get credential")
+
+			return
+		}
+
+		checks = o.verifyCredential(vcWallet, req.Auth, raw)
+	case len(req.RawCredential) > 0:
+		checks = o.verifyCredential(vcWallet, req.Auth, req.RawCredential)
+	default:
+		checks = o.verifyPresentation(vcWallet, req.Auth, req.Presentation)
+	}
+
+	verified := true
+
+	for _, check := range checks {
+		verified = verified && check.Status != checkFailed
+	}
+
+	common.WriteResponse(w, logger, &verifyResp{Verified: verified, Checks: checks})
+}
+
+// derive derives a credential disclosing only the fields selected by a JSON-LD frame from a credential with a BBS+
+// proof.
+func (o *Operation) derive(w http.ResponseWriter, r *http.Request) {
+	req := &deriveReq{}
+
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "invalid request: %s", err.Error())
+
+		return
+	}
+
+	if req.UserID == "" || req.Auth == "" {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing user ID or auth token")
+
+		return
+	}
+
+	var credential wallet.CredentialToDerive
+
+	switch {
+	case req.StoredCredentialID != "":
+		credential = wallet.FromStoredCredential(req.StoredCredentialID)
+	case len(req.RawCredential) > 0:
+		credential = wallet.FromRawCredential(req.RawCredential)
+	default:
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing credential to derive")
+
+		return
+	}
+
+	if len(req.Frame) == 0 {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing frame")
+
+		return
+	}
+
+	vcWallet, ok := o.openWallet(w, req.UserID)
+	if !ok {
+		return
+	}
+
+	vc, err := vcWallet.Derive(req.Auth, credential, &req.DeriveOptions)
+	if err != nil {
+		writeWalletError(w, err, "failed to derive credential")
+
+		return
+	}
+
+	common.WriteResponse(w, logger, &deriveResp{Credential: vc})
+}
+
+// verifyCredential runs all verification checks of a credential.
+func (o *Operation) verifyCredential(vcWallet *wallet.Wallet, auth string, raw json.RawMessage) []*verificationCheck {
+	checks := []*verificationCheck{{Check: proofCheck, Status: checkPassed}}
+
+	err := o.verifyProof(vcWallet, auth, raw)
+	if err != nil {
+		checks[0].Status, checks[0].Error = checkFailed, err.Error()
+	}
+
+	vc, err := verifiable.ParseCredential(raw, verifiable.WithDisabledProofCheck(), verifiable.WithNoCustomSchemaCheck(),
+		verifiable.WithJSONLDDocumentLoader(o.ctx.JSONLDDocumentLoader()))
+	if err != nil {
+		checks = append(checks, &verificationCheck{Check: parseCheck, Status: checkFailed, Error: err.Error()})
+
+		for _, check := range []string{expiryCheck, statusCheck, schemaCheck} {
+			checks = append(checks, &verificationCheck{Check: check, Status: checkSkipped})
+		}
+
+		return checks
+	}
+
+	checks = append(checks, checkExpiry(vc), o.checkStatus(vc), o.checkSchema(vc, raw))
+
+	for _, check := range checks {
+		check.CredentialID = vc.ID
+	}
+
+	return checks
+}
+
+// verifyProof verifies the proof of a credential with the keys of the DIDs saved in the wallet or resolved with the
+// VDR, as the wallet does. The schema of the credential is not fetched: the wallet would fetch it without restrictions.
+func (o *Operation) verifyProof(vcWallet *wallet.Wallet, auth string, raw json.RawMessage) error {
+	_, err := verifiable.ParseCredential(raw, verifiable.WithNoCustomSchemaCheck(),
+		verifiable.WithPublicKeyFetcher(verifiable.NewVDRKeyResolver(&walletVDR{
+			Registry: o.ctx.VDRegistry(),
+			wallet:   vcWallet,
+			auth:     auth,
+		}).PublicKeyFetcher()),
+		verifiable.WithJSONLDDocumentLoader(o.ctx.JSONLDDocumentLoader()))
+	if err != nil {
+		return fmt.Errorf("credential verification failed: %w", err)
+	}
+
+	return nil
+}
+
+// verifyPresentation checks the proof of a presentation, and runs all verification checks of its credentials.
+func (o *Operation) verifyPresentation(vcWallet *wallet.Wallet, auth string,
+	raw json.RawMessage) []*verificationCheck {
+	check := &verificationCheck{Check: proofCheck, Status: checkPassed}
+
+	vp, err := verifiable.ParsePresentation(raw,
+		verifiable.WithPresPublicKeyFetcher(verifiable.NewVDRKeyResolver(o.ctx.VDRegistry()).PublicKeyFetcher()),
+		verifiable.WithPresJSONLDDocumentLoader(o.ctx.JSONLDDocumentLoader()))
+	if err != nil {
+		check.Status, check.Error = checkFailed, err.Error()
+
+		vp, err = verifiable.ParsePresentation(raw, verifiable.WithPresDisabledProofCheck(),
+			verifiable.WithPresJSONLDDocumentLoader(o.ctx.JSONLDDocumentLoader()))
+		if err != nil {
+			return []*verificationCheck{check}
+		}
+	}
+
+	checks := []*verificationCheck{check}
+
+	for _, credential := range vp.Credentials() {
+		vc, err := json.Marshal(credential)
+		if err != nil {
+			checks = append(checks, &verificationCheck{Check: proofCheck, Status: checkFailed, Error: err.Error()})
+
+			continue
+		}
+
+		checks = append(checks, o.verifyCredential(vcWallet, auth, vc)...)
+	}
+
+	return checks
+}
+
+func checkExpiry(vc *verifiable.Credential) *verificationCheck {
+	check := &verificationCheck{Check: expiryCheck, Status: checkSkipped}
+
+	if vc.Expired == nil {
+		return check
+	}
+
+	check.Status = checkPassed
+
+	if vc.Expired.Before(time.Now()) {
+		check.Status = checkFailed
+		check.Error = fmt.Sprintf("credential expired at %s", vc.Expired.Format(time.RFC3339))
+	}
+
+	return check
+}
+
+// checkStatus checks that the credential was neither revoked nor suspended by its issuer.
+func (o *Operation) checkStatus(vc *verifiable.Credential) *verificationCheck {
+	check := &verificationCheck{Check: statusCheck, Status: checkSkipped}
+
+	checked, err := o.checkCredentialStatus(vc)
+
+	switch {
+	case errors.Is(err, errNoStatus):
+	case errors.Is(err, status.ErrUnsupported):
+		check.Error = err.Error()
+	case err != nil:
+		check.Status = checkFailed
+		check.Error = err.Error()
+	case checked.Status != status.Active:
+		check.Status = checkFailed
+		check.Error = fmt.Sprintf("credential is %s", checked.Status)
+	default:
+		check.Status = checkPassed
+	}
+
+	return check
+}
+
+// checkSchema validates the credential against its JSON schema. The check is skipped if the credential has no JSON
+// schema, or if its schema cannot be fetched.
+func (o *Operation) checkSchema(vc *verifiable.Credential, raw json.RawMessage) *verificationCheck {
+	check := &verificationCheck{Check: schemaCheck, Status: checkSkipped}
+
+	if len(vc.Schemas) == 0 {
+		return check
+	}
+
+	err := o.schemas.fetch(vc.Schemas)
+	if err != nil {
+		check.Error = err.Error()
+
+		return check
+	}
+
+	check.Status = checkPassed
+
+	_, err = verifiable.ParseCredential(raw, verifiable.WithDisabledProofCheck(),
+		verifiable.WithJSONLDDocumentLoader(o.ctx.JSONLDDocumentLoader()),
+		verifiable.WithCredentialSchemaLoader(o.schemas.loader))
+	if err != nil {
+		check.Status, check.Error = checkFailed, err.Error()
+	}
+
+	return check
+}
+
+// walletVDR resolves the DIDs saved in a wallet, and other DIDs with the VDR of the agent, as the wallet does when it
+// verifies credentials.
+type walletVDR struct {
+	vdr.Registry
+	wallet *wallet.Wallet
+	auth   string
+}
+
+func (v *walletVDR) Resolve(didID string, opts ...vdr.DIDMethodOption) (*did.DocResolution, error) {
+	raw, err := v.wallet.Get(v.auth, wallet.DIDResolutionResponse, didID)
+	if err == nil {
+		return did.ParseDocumentResolution(raw)
+	}
+
+	if errors.Is(err, wallet.ErrWalletLocked) || errors.Is(err, wallet.ErrInvalidAuthToken) {
+		return nil, err
+	}
+
+	return v.Registry.Resolve(didID, opts...)
+}
+
+// credentialSchemas fetches the JSON schemas of credentials from public addresses only, and caches them.
+type credentialSchemas struct {
+	client *http.Client
+	cache  verifiable.SchemaCache
+	loader *verifiable.CredentialSchemaLoader
+}
+
+// newCredentialSchemas returns the credential schemas fetched with a copy of the HTTP client.
+func newCredentialSchemas(client common.HTTPClient, allow func(net.IP) bool) *credentialSchemas {
+	s := &credentialSchemas{
+		client: guardedHTTPClient(client, allow, schemaTimeout),
+		cache:  verifiable.NewExpirableSchemaCache(schemaCacheSize, schemaCacheTTL),
+	}
+
+	s.loader = verifiable.NewCredentialSchemaLoaderBuilder().SetSchemaDownloadClient(s.client).SetCache(s.cache).Build()
+
+	return s
+}
+
+// fetch caches the JSON schema that credentials with the given schemas are validated against, unless it is cached
+// already, so that the schema is not fetched by the credential parser, which does not limit its size.
+func (s *credentialSchemas) fetch(schemas []verifiable.TypedID) error {
+	var schemaURL string
+
+	for _, schema := range schemas {
+		if schema.Type == jsonSchemaType {
+			schemaURL = schema.ID
+
+			break
+		}
+	}
+
+	if schemaURL == "" {
+		return errors.New("no supported credential schema")
+	}
+
+	if _, ok := s.cache.Get(schemaURL); ok {
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(context.TODO(), http.MethodGet, schemaURL, nil)
+	if err != nil {
+		return fmt.Errorf("invalid credential schema URL: %w", err)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch credential schema: %w", err)
+	}
+
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			logger.Warnf("failed to close response body: %s", closeErr)
+		}
+	}()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxSchemaSize+1))
+	if err != nil {
+		return fmt.Errorf("failed to fetch credential schema: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch credential schema: status %d", resp.StatusCode)
+	}
+
+	if len(body) > maxSchemaSize {
+		return fmt.Errorf("credential schema is larger than %d bytes", maxSchemaSize)
+	}
+
+	s.cache.Put(schemaURL, body)
+
+	return nil
+}
+
+// decodeProofRequest decodes the request body into req, and validates the wallet authorization, proof options and
+// format decoded into its proofReq.
+func decodeProofRequest(w http.ResponseWriter, r *http.Request, req interface{}, proof *proofReq) bool {
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "invalid request: %s", err.Error())
+
+		return false
+	}
+
+	if proof.UserID == "" || proof.Auth == "" {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing user ID or auth token")
+
+		return false
+	}
+
+	switch proof.Format {
+	case "", ldpFormat:
+	case jwtFormat:
+		// JWTs are signed with the self-issued key of the user: there is no proof type to choose, and the controller
+		// is optional.
+		return true
+	default:
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "unsupported format [%s]", proof.Format)
+
+		return false
+	}
+
+	if proof.ProofOptions == nil || proof.ProofOptions.Controller == "" {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing proof controller")
+
+		return false
+	}
+
+	switch proof.ProofOptions.ProofType {
+	case "", wallet.Ed25519Signature2018, wallet.JSONWebSignature2020:
+	case wallet.BbsBlsSignature2020:
+		// a BBS+ signature is not a JWS.
+		if proof.ProofOptions.ProofRepresentation == nil {
+			representation := verifiable.SignatureProofValue
+			proof.ProofOptions.ProofRepresentation = &representation
+		}
+	default:
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "unsupported proof type [%s]",
+			proof.ProofOptions.ProofType)
+
+		return false
+	}
+
+	return true
+}
+
+// issueJWT signs the credential as a JWT with the self-issued key of the user. The issuer of the credential is the
+// did:key of that key.
+func (o *Operation) issueJWT(vcWallet *wallet.Wallet, req *issueReq) (string, error) {
+	signer, didKey, err := o.jwtSigner(vcWallet, &req.proofReq)
+	if err != nil {
+		return "", err
+	}
+
+	vc, err := verifiable.ParseCredential(req.Credential, verifiable.WithDisabledProofCheck(),
+		verifiable.WithNoCustomSchemaCheck(), verifiable.WithJSONLDDocumentLoader(o.ctx.JSONLDDocumentLoader()))
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid credential: %s", errInvalidProofRequest, err.Error())
+	}
+
+	vc.Issuer.ID = didKey
+
+	claims, err := vc.JWTClaims(false)
+	if err != nil {
+		return "", err
+	}
+
+	return claims.MarshalJWS(verifiable.EdDSA, signer, signer.kid)
+}
+
+// jwtPresentationClaims are the claims of a JWT presentation, with the challenge of the proof options as nonce.
+type jwtPresentationClaims struct {
+	*verifiable.JWTPresClaims
+	Nonce string `json:"nonce,omitempty"`
+}
+
+// proveJWT signs a presentation of the credentials as a JWT with the self-issued key of the user. The holder of the
+// presentation is the did:key of that key, its audience is the domain of the proof options, and its nonce their
+// challenge.
+func (o *Operation) proveJWT(vcWallet *wallet.Wallet, req *proveReq) (string, error) {
+	signer, didKey, err := o.jwtSigner(vcWallet, &req.proofReq)
+	if err != nil {
+		return "", err
+	}
+
+	vp, err := o.presentation(vcWallet, req)
+	if err != nil {
+		return "", err
+	}
+
+	vp.Holder = didKey
+
+	var (
+		audience []string
+		nonce    string
+	)
+
+	if req.ProofOptions != nil {
+		if req.ProofOptions.Domain != "" {
+			audience = []string{req.ProofOptions.Domain}
+		}
+
+		nonce = req.ProofOptions.Challenge
+	}
+
+	claims, err := vp.JWTClaims(audience, false)
+	if err != nil {
+		return "", err
+	}
+
+	token, err := jwt.NewSigned(&jwtPresentationClaims{JWTPresClaims: claims, Nonce: nonce}, nil, signer)
+	if err != nil {
+		return "", err
+	}
+
+	return token.Serialize(false)
+}
+
+// jwtSigner returns the signer of JWT credentials and presentations, and the did:key signing them. A controller in
+// the proof options must be that did:key.
+func (o *Operation) jwtSigner(vcWallet *wallet.Wallet, proof *proofReq) (*idTokenSigner, string, error) {
+	signer, didKey, err := o.selfIssuedSigner(vcWallet, &proof.walletAuth)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if proof.ProofOptions != nil && proof.ProofOptions.Controller != "" && proof.ProofOptions.Controller != didKey {
+		return nil, "", fmt.Errorf("%w: JWTs are signed by the self-issued DID %s of the wallet, not %s",
+			errInvalidProofRequest, didKey, proof.ProofOptions.Controller)
+	}
+
+	return signer, didKey, nil
+}
+
+// presentation returns the presentation of the request, with the stored and raw credentials of the request added.
+func (o *Operation) presentation(vcWallet *wallet.Wallet, req *proveReq) (*verifiable.Presentation, error) {
+	vp, err := verifiable.NewPresentation()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(req.Presentation) > 0 {
+		vp, err = verifiable.ParsePresentation(unquoteJWT(req.Presentation), verifiable.WithPresDisabledProofCheck(),
+			verifiable.WithPresJSONLDDocumentLoader(o.ctx.JSONLDDocumentLoader()))
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid presentation: %s", errInvalidProofRequest, err.Error())
+		}
+	}
+
+	raws := req.RawCredentials
+
+	for _, id := range req.StoredCredentials {
+		raw, err := vcWallet.Get(req.Auth, wallet.Credential, id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get credential %s: %w", id, err)
+		}
+
+		raws = append(raws, raw)
+	}
+
+	for _, raw := range raws {
+		vc, err := verifiable.ParseCredential(unquoteJWT(raw), verifiable.WithDisabledProofCheck(),
+			verifiable.WithNoCustomSchemaCheck(), verifiable.WithJSONLDDocumentLoader(o.ctx.JSONLDDocumentLoader()))
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid credential: %s", errInvalidProofRequest, err.Error())
+		}
+
+		vp.AddCredentials(vc)
+	}
+
+	return vp, nil
+}
+
+// unquoteJWT returns the compact serialization of a JWT given as a JSON string, as issued and proved in the JWT
+// format, and any other raw credential or presentation unchanged.
+func unquoteJWT(raw json.RawMessage) json.RawMessage {
+	var jws string
+
+	if err := json.Unmarshal(raw, &jws); err != nil {
+		return raw
+	}
+
+	return json.RawMessage(jws)
+}
@@ -0,0 +1,557 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation // nolint:testpackage // changing to different package requires exposing internal features.
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcutil/base58"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/hyperledger/aries-framework-go/pkg/crypto/primitive/bbs12381g2pub"
+	"github.com/hyperledger/aries-framework-go/pkg/vdr/fingerprint"
+	"github.com/hyperledger/aries-framework-go/pkg/wallet"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	sampleKey = `{
+		"@context": ["https://w3id.org/wallet/v1"],
+		"id": "%s",
+		"controller": "%s",
+		"type": "%s",
+		"privateKeyBase58": "%s"
+	}`
+	sampleExpiredCredential = `{
+		"@context": ["https://www.w3.org/2018/credentials/v1"],
+		"id": "%s",
+		"type": ["VerifiableCredential"],
+		"issuer": "did:example:76e12ec712ebc6f1c221ebfeb1f",
+		"issuanceDate": "2010-01-01T19:23:24Z",
+		"expirationDate": "2011-01-01T19:23:24Z",
+		"credentialSubject": {"id": "did:example:ebfeb1f712ebc6f1c276e12ec21"}
+	}`
+	sampleFrame = `{
+		"@context": [
+			"https://www.w3.org/2018/credentials/v1",
+			{"@vocab": "https://example.com/vocab#"},
+			"https://w3id.org/security/bbs/v1"
+		],
+		"type": ["VerifiableCredential"],
+		"@explicit": true,
+		"issuer": {},
+		"issuanceDate": {},
+		"credentialSubject": {"@explicit": true}
+	}`
+)
+
+func TestOperation_IssueProveVerify(t *testing.T) {
+	t.Run("issue, prove and verify with an Ed25519 key", func(t *testing.T) {
+		router := newRouter(t, newProvider(t))
+		auth := unlock(t, router)
+		controller := addEd25519Key(t, router, auth)
+
+		vc := issueCredential(t, router, auth, controller, wallet.Ed25519Signature2018,
+			fmt.Sprintf(sampleCredential, uuid.New().URN()))
+
+		checks := requireVerified(t, router, &verifyReq{walletAuth: auth, RawCredential: vc}, true)
+		require.Equal(t, map[string]string{
+			proofCheck: checkPassed, expiryCheck: checkSkipped, statusCheck: checkSkipped, schemaCheck: checkSkipped,
+		}, checks)
+
+		rr := serve(router, http.MethodPost, addPath, &addContentReq{
+			contentAuth: contentAuth{walletAuth: auth, ContentType: wallet.Credential},
+			Content:     vc,
+		})
+		require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+
+		stored := &struct {
+			ID string `json:"id"`
+		}{}
+		require.NoError(t, json.Unmarshal(vc, stored))
+
+		requireVerified(t, router, &verifyReq{walletAuth: auth, StoredCredentialID: stored.ID}, true)
+
+		rr = serve(router, http.MethodPost, provePath, &proveReq{
+			proofReq: proofReq{
+				walletAuth:   auth,
+				ProofOptions: &wallet.ProofOptions{Controller: controller, Challenge: uuid.New().String()},
+			},
+			StoredCredentials: []string{stored.ID},
+		})
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		vp := &struct {
+			Presentation json.RawMessage `json:"presentation"`
+		}{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), vp))
+		require.Contains(t, string(vp.Presentation), stored.ID)
+
+		requireVerified(t, router, &verifyReq{walletAuth: auth, Presentation: vp.Presentation}, true)
+	})
+
+	t.Run("issue with a JSON web signature", func(t *testing.T) {
+		router := newRouter(t, newProvider(t))
+		auth := unlock(t, router)
+		controller := addEd25519Key(t, router, auth)
+
+		vc := issueCredential(t, router, auth, controller, wallet.JSONWebSignature2020,
+			fmt.Sprintf(sampleCredential, uuid.New().URN()))
+		require.Contains(t, string(vc), `"jws"`)
+	})
+
+	t.Run("issue and prove JWTs with the self-issued key", func(t *testing.T) {
+		router := newRouter(t, newProvider(t))
+		auth := unlock(t, router)
+
+		rr := serve(router, http.MethodPost, issuePath, &issueReq{
+			proofReq:   proofReq{walletAuth: auth, Format: jwtFormat},
+			Credential: json.RawMessage(fmt.Sprintf(sampleCredential, uuid.New().URN())),
+		})
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		issued := &struct {
+			Credential string `json:"credential"`
+		}{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), issued))
+
+		claims := jwtPayload(t, issued.Credential)
+		didKey, ok := claims["iss"].(string)
+		require.True(t, ok)
+		require.True(t, strings.HasPrefix(didKey, "did:key:"))
+		require.Contains(t, claims, "vc")
+
+		vc, err := json.Marshal(issued.Credential)
+		require.NoError(t, err)
+
+		checks := requireVerified(t, router, &verifyReq{walletAuth: auth, RawCredential: vc}, true)
+		require.Equal(t, checkPassed, checks[proofCheck])
+
+		rr = serve(router, http.MethodPost, addPath, &addContentReq{
+			contentAuth: contentAuth{walletAuth: auth, ContentType: wallet.Credential},
+			Content:     json.RawMessage(fmt.Sprintf(sampleDegreeCredential, uuid.New().URN())),
+		})
+		require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+
+		stored := &struct {
+			Contents map[string]json.RawMessage `json:"contents"`
+		}{}
+		rr = serve(router, http.MethodPost, getAllPath, &getAllContentReq{
+			contentAuth: contentAuth{walletAuth: auth, ContentType: wallet.Credential},
+		})
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), stored))
+		require.Len(t, stored.Contents, 1)
+
+		var storedID string
+		for id := range stored.Contents {
+			storedID = id
+		}
+
+		challenge := uuid.New().String()
+
+		rr = serve(router, http.MethodPost, provePath, &proveReq{
+			proofReq: proofReq{
+				walletAuth: auth,
+				ProofOptions: &wallet.ProofOptions{
+					Controller: didKey, Challenge: challenge, Domain: "https://verifier.example.com",
+				},
+				Format: jwtFormat,
+			},
+			StoredCredentials: []string{storedID},
+			RawCredentials:    []json.RawMessage{vc},
+		})
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		proved := &struct {
+			Presentation string `json:"presentation"`
+		}{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), proved))
+
+		claims = jwtPayload(t, proved.Presentation)
+		require.Equal(t, didKey, claims["iss"])
+		require.Equal(t, challenge, claims["nonce"])
+		require.Equal(t, "https://verifier.example.com", claims["aud"])
+		require.Contains(t, proved.Presentation, ".")
+
+		vp, err := json.Marshal(proved.Presentation)
+		require.NoError(t, err)
+
+		requireVerified(t, router, &verifyReq{walletAuth: auth, Presentation: vp}, true)
+	})
+
+	t.Run("sign JWTs with the self-issued key of the user of the auth token", func(t *testing.T) {
+		router := newRouter(t, newProvider(t))
+		auths := []walletAuth{unlock(t, router), unlock(t, router)}
+		issuers := map[string]bool{}
+
+		for _, auth := range auths {
+			rr := serve(router, http.MethodPost, issuePath, &issueReq{
+				proofReq:   proofReq{walletAuth: auth, Format: jwtFormat},
+				Credential: json.RawMessage(fmt.Sprintf(sampleCredential, uuid.New().URN())),
+			})
+			require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+			issued := &struct {
+				Credential string `json:"credential"`
+			}{}
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), issued))
+
+			issuer, ok := jwtPayload(t, issued.Credential)["iss"].(string)
+			require.True(t, ok)
+
+			issuers[issuer] = true
+		}
+
+		require.Len(t, issuers, len(auths))
+
+		rr := serve(router, http.MethodPost, issuePath, &issueReq{
+			proofReq: proofReq{
+				walletAuth: walletAuth{UserID: auths[0].UserID, Auth: auths[1].Auth},
+				Format:     jwtFormat,
+			},
+			Credential: json.RawMessage(fmt.Sprintf(sampleCredential, uuid.New().URN())),
+		})
+		require.Equal(t, http.StatusUnauthorized, rr.Code, rr.Body.String())
+	})
+
+	t.Run("error if a JWT controller is not the self-issued DID", func(t *testing.T) {
+		router := newRouter(t, newProvider(t))
+		auth := unlock(t, router)
+
+		rr := serve(router, http.MethodPost, issuePath, &issueReq{
+			proofReq: proofReq{
+				walletAuth:   auth,
+				ProofOptions: &wallet.ProofOptions{Controller: "did:example:1"},
+				Format:       jwtFormat,
+			},
+			Credential: json.RawMessage(fmt.Sprintf(sampleCredential, uuid.New().URN())),
+		})
+		require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+		requireErrorResponse(t, rr.Body.Bytes(), "self-issued DID")
+	})
+
+	t.Run("verification reports failed checks", func(t *testing.T) {
+		router := newRouter(t, newProvider(t))
+		auth := unlock(t, router)
+		controller := addEd25519Key(t, router, auth)
+
+		vc := issueCredential(t, router, auth, controller, wallet.Ed25519Signature2018,
+			fmt.Sprintf(sampleExpiredCredential, uuid.New().URN()))
+
+		checks := requireVerified(t, router, &verifyReq{walletAuth: auth, RawCredential: vc}, false)
+		require.Equal(t, checkPassed, checks[proofCheck])
+		require.Equal(t, checkFailed, checks[expiryCheck])
+
+		tampered := strings.Replace(string(vc), "did:example:ebfeb1f712ebc6f1c276e12ec21", "did:example:other", 1)
+
+		checks = requireVerified(t, router, &verifyReq{walletAuth: auth, RawCredential: json.RawMessage(tampered)},
+			false)
+		require.Equal(t, checkFailed, checks[proofCheck])
+	})
+
+	t.Run("derive from a credential with a BBS+ proof", func(t *testing.T) {
+		router := newRouter(t, newProvider(t))
+		auth := unlock(t, router)
+		controller := addBBSKey(t, router, auth)
+
+		vc := issueCredential(t, router, auth, controller, wallet.BbsBlsSignature2020,
+			fmt.Sprintf(sampleDegreeCredential, uuid.New().URN()))
+
+		frame := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal([]byte(sampleFrame), &frame))
+
+		rr := serve(router, http.MethodPost, derivePath, &deriveReq{
+			walletAuth:    auth,
+			DeriveOptions: wallet.DeriveOptions{Frame: frame, Nonce: uuid.New().String()},
+			RawCredential: vc,
+		})
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		require.Contains(t, rr.Body.String(), "BbsBlsSignatureProof2020")
+		require.NotContains(t, rr.Body.String(), "BachelorDegree")
+	})
+
+	t.Run("error if the request is invalid", func(t *testing.T) {
+		router := newRouter(t, newProvider(t))
+		auth := unlock(t, router)
+
+		for _, path := range []string{issuePath, provePath, verifyPath, derivePath} {
+			rr := serve(router, http.MethodPost, path, "invalid")
+			require.Equal(t, http.StatusBadRequest, rr.Code)
+			requireErrorResponse(t, rr.Body.Bytes(), "invalid request")
+
+			rr = serve(router, http.MethodPost, path, &walletAuth{UserID: auth.UserID})
+			require.Equal(t, http.StatusBadRequest, rr.Code)
+			requireErrorResponse(t, rr.Body.Bytes(), "missing user ID or auth token")
+		}
+
+		for _, path := range []string{issuePath, provePath} {
+			for _, invalid := range []struct {
+				req *proofReq
+				msg string
+			}{
+				{req: &proofReq{walletAuth: auth}, msg: "missing proof controller"},
+				{
+					req: &proofReq{
+						walletAuth:   auth,
+						ProofOptions: &wallet.ProofOptions{Controller: "did:example:1", ProofType: "invalid"},
+					},
+					msg: "unsupported proof type [invalid]",
+				},
+				{
+					req: &proofReq{
+						walletAuth:   auth,
+						ProofOptions: &wallet.ProofOptions{Controller: "did:example:1"},
+						Format:       "invalid",
+					},
+					msg: "unsupported format [invalid]",
+				},
+			} {
+				rr := serve(router, http.MethodPost, path, invalid.req)
+				require.Equal(t, http.StatusBadRequest, rr.Code)
+				requireErrorResponse(t, rr.Body.Bytes(), invalid.msg)
+			}
+		}
+
+		rr := serve(router, http.MethodPost, issuePath, &proofReq{
+			walletAuth:   auth,
+			ProofOptions: &wallet.ProofOptions{Controller: "did:example:1"},
+		})
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		requireErrorResponse(t, rr.Body.Bytes(), "missing credential")
+
+		rr = serve(router, http.MethodPost, verifyPath, &verifyReq{walletAuth: auth})
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		requireErrorResponse(t, rr.Body.Bytes(), "missing credential or presentation to verify")
+
+		rr = serve(router, http.MethodPost, derivePath, &deriveReq{walletAuth: auth})
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		requireErrorResponse(t, rr.Body.Bytes(), "missing credential to derive")
+
+		rr = serve(router, http.MethodPost, derivePath, &deriveReq{walletAuth: auth, StoredCredentialID: "id"})
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		requireErrorResponse(t, rr.Body.Bytes(), "missing frame")
+	})
+
+	t.Run("error if the wallet is locked", func(t *testing.T) {
+		router := newRouter(t, newProvider(t))
+		auth := unlock(t, router)
+		controller := addEd25519Key(t, router, auth)
+		auth.Auth = "invalid"
+
+		rr := serve(router, http.MethodPost, issuePath, &issueReq{
+			proofReq:   proofReq{walletAuth: auth, ProofOptions: &wallet.ProofOptions{Controller: controller}},
+			Credential: json.RawMessage(fmt.Sprintf(sampleCredential, uuid.New().URN())),
+		})
+		require.Equal(t, http.StatusUnauthorized, rr.Code, rr.Body.String())
+
+		rr = serve(router, http.MethodPost, verifyPath, &verifyReq{walletAuth: auth, StoredCredentialID: "id"})
+		require.Equal(t, http.StatusUnauthorized, rr.Code, rr.Body.String())
+	})
+}
+
+// addEd25519Key adds a new Ed25519 key to the wallet, and returns the did:key DID of the key.
+func addEd25519Key(t *testing.T, router *mux.Router, auth walletAuth) string {
+	t.Helper()
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	didKey, keyID := fingerprint.CreateDIDKey(pub)
+
+	addKey(t, router, auth, fmt.Sprintf(sampleKey, keyID, didKey, "Ed25519VerificationKey2018", base58.Encode(priv)))
+
+	return didKey
+}
+
+// addBBSKey adds a new BLS12-381 G2 key to the wallet, and returns the did:key DID of the key.
+func addBBSKey(t *testing.T, router *mux.Router, auth walletAuth) string {
+	t.Helper()
+
+	pub, priv, err := bbs12381g2pub.GenerateKeyPair(sha256.New, nil)
+	require.NoError(t, err)
+
+	pubBytes, err := pub.Marshal()
+	require.NoError(t, err)
+
+	privBytes, err := priv.Marshal()
+	require.NoError(t, err)
+
+	didKey, keyID := fingerprint.CreateDIDKeyByCode(fingerprint.BLS12381g2PubKeyMultiCodec, pubBytes)
+
+	addKey(t, router, auth, fmt.Sprintf(sampleKey, keyID, didKey, "Bls12381G1Key2020", base58.Encode(privBytes)))
+
+	return didKey
+}
+
+func addKey(t *testing.T, router *mux.Router, auth walletAuth, key string) {
+	t.Helper()
+
+	rr := serve(router, http.MethodPost, addPath, &addContentReq{
+		contentAuth: contentAuth{walletAuth: auth, ContentType: wallet.Key},
+		Content:     json.RawMessage(key),
+	})
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+}
+
+func issueCredential(t *testing.T, router *mux.Router, auth walletAuth, controller, proofType,
+	credential string) json.RawMessage {
+	t.Helper()
+
+	rr := serve(router, http.MethodPost, issuePath, &issueReq{
+		proofReq: proofReq{
+			walletAuth:   auth,
+			ProofOptions: &wallet.ProofOptions{Controller: controller, ProofType: proofType},
+		},
+		Credential: json.RawMessage(credential),
+	})
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	issued := &struct {
+		Credential json.RawMessage `json:"credential"`
+	}{}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), issued))
+	require.Contains(t, string(issued.Credential), proofType)
+
+	return issued.Credential
+}
+
+// jwtPayload returns the claims of a JWT without checking its signature.
+func jwtPayload(t *testing.T, token string) map[string]interface{} {
+	t.Helper()
+
+	parts := strings.Split(token, ".")
+	require.Len(t, parts, 3)
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	require.NoError(t, err)
+
+	claims := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(payload, &claims))
+
+	return claims
+}
+
+// requireVerified verifies and returns the status of each check of the last verified credential.
+func requireVerified(t *testing.T, router *mux.Router, req *verifyReq, verified bool) map[string]string {
+	t.Helper()
+
+	rr := serve(router, http.MethodPost, verifyPath, req)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	resp := &verifyResp{}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), resp))
+	require.Equal(t, verified, resp.Verified, rr.Body.String())
+
+	checks := map[string]string{}
+
+	for _, check := range resp.Checks {
+		checks[check.Check] = check.Status
+	}
+
+	return checks
+}
+
+func TestOperation_VerifySchema(t *testing.T) {
+	const (
+		schemaCredential = `{
+			"@context": ["https://www.w3.org/2018/credentials/v1", {"@vocab": "https://example.com/vocab#"}],
+			"id": "%s",
+			"type": ["VerifiableCredential"],
+			"issuer": "did:example:76e12ec712ebc6f1c221ebfeb1f",
+			"issuanceDate": "2010-01-01T19:23:24Z",
+			"credentialSchema": {"id": "%s", "type": "%s"},
+			"credentialSubject": %s
+		}`
+		schema = `{
+			"$schema": "http://json-schema.org/draft-07/schema#",
+			"type": "object",
+			"required": ["credentialSubject"],
+			"properties": {"credentialSubject": {"type": "object", "required": ["name"]}}
+		}`
+	)
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/schema":
+			_, _ = w.Write([]byte(schema)) // nolint:errcheck // test server
+		case "/large":
+			_, _ = w.Write(bytes.Repeat([]byte(" "), maxSchemaSize+1)) // nolint:errcheck // test server
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	op, err := New(newProvider(t), nil, nil)
+	require.NoError(t, err)
+
+	op.schemas = newCredentialSchemas(server.Client(), func(net.IP) bool { return true })
+
+	router := routerOf(op)
+	auth := unlock(t, router)
+
+	verify := func(schemaURL, schemaType, subject string, verified bool) map[string]string {
+		credential := fmt.Sprintf(schemaCredential, uuid.New().URN(), schemaURL, schemaType, subject)
+
+		return requireVerified(t, router, &verifyReq{walletAuth: auth, RawCredential: json.RawMessage(credential)},
+			verified)
+	}
+
+	t.Run("credentials are validated against their JSON schema", func(t *testing.T) {
+		checks := verify(server.URL+"/schema", jsonSchemaType, `{"name": "Jayden Doe"}`, true)
+		require.Equal(t, checkPassed, checks[schemaCheck])
+
+		checks = verify(server.URL+"/schema", jsonSchemaType, `{"id": "did:example:ebfeb1f712ebc6f1c276e12ec21"}`,
+			false)
+		require.Equal(t, checkFailed, checks[schemaCheck])
+	})
+
+	t.Run("skipped if the schema cannot be fetched", func(t *testing.T) {
+		for _, schemaURL := range []string{server.URL + "/unknown", server.URL + "/large"} {
+			checks := verify(schemaURL, jsonSchemaType, `{"name": "Jayden Doe"}`, true)
+			require.Equal(t, checkSkipped, checks[schemaCheck], schemaURL)
+		}
+
+		checks := verify(server.URL+"/schema", "UnknownSchema", `{"name": "Jayden Doe"}`, true)
+		require.Equal(t, checkSkipped, checks[schemaCheck])
+	})
+
+	t.Run("schemas are fetched from public addresses only", func(t *testing.T) {
+		router := newRouter(t, newProvider(t))
+		auth := unlock(t, router)
+
+		rr := serve(router, http.MethodPost, verifyPath, &verifyReq{
+			walletAuth: auth,
+			RawCredential: json.RawMessage(fmt.Sprintf(schemaCredential, uuid.New().URN(), server.URL+"/schema",
+				jsonSchemaType, `{"name": "Jayden Doe"}`)),
+		})
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		require.Contains(t, rr.Body.String(), "is not allowed")
+	})
+
+	t.Run("credentials that cannot be parsed skip the other checks", func(t *testing.T) {
+		checks := requireVerified(t, router, &verifyReq{
+			walletAuth:    auth,
+			RawCredential: json.RawMessage(`{"@context": ["https://www.w3.org/2018/credentials/v1"]}`),
+		}, false)
+		require.Equal(t, map[string]string{
+			proofCheck: checkFailed, parseCheck: checkFailed, expiryCheck: checkSkipped, statusCheck: checkSkipped,
+			schemaCheck: checkSkipped,
+		}, checks)
+	})
+}
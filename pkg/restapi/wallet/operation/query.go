@@ -0,0 +1,490 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/aries-framework-go/pkg/doc/presexch"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/verifiable"
+	"github.com/hyperledger/aries-framework-go/pkg/wallet"
+
+	"github.com/trustbloc/wallet/pkg/restapi/common"
+)
+
+// errInvalidQuery is returned for queries that cannot be run.
+var errInvalidQuery = errors.New("invalid query")
+
+// credentialMatcher returns why the credential does not satisfy a query, or an empty string if it does.
+type credentialMatcher func(vc *verifiable.Credential) string
+
+// query runs credential queries against an unlocked wallet. Along with the presentations of the matching
+// credentials, the response tells for every query which credentials matched it and why the others did not.
+func (o *Operation) query(w http.ResponseWriter, r *http.Request) {
+	req := &queryReq{}
+
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "invalid request: %s", err.Error())
+
+		return
+	}
+
+	if req.UserID == "" || req.Auth == "" {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing user ID or auth token")
+
+		return
+	}
+
+	if len(req.Query) == 0 {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing query")
+
+		return
+	}
+
+	vcWallet, ok := o.openWallet(w, req.UserID)
+	if !ok {
+		return
+	}
+
+	results, diagnostics, err := o.runQuery(vcWallet, req.Auth, req.Query)
+	if err != nil {
+		writeWalletError(w, err, "failed to query credentials")
+
+		return
+	}
+
+	common.WriteResponse(w, logger, &queryResp{Results: results, Diagnostics: diagnostics})
+}
+
+// runQuery runs the queries against the credentials of an unlocked wallet, and tells for every query which
+// credentials matched it and why the others did not. Credentials that cannot be parsed are left out of the results
+// and reported as excluded, instead of failing the whole query.
+func (o *Operation) runQuery(vcWallet *wallet.Wallet, auth string,
+	params []*wallet.QueryParams) ([]*verifiable.Presentation, []*queryDiagnostic, error) {
+	matchers := make([]credentialMatcher, len(params))
+
+	for i, param := range params {
+		matcher, err := o.queryMatcher(param)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w %d: %s", errInvalidQuery, i, err.Error())
+		}
+
+		matchers[i] = matcher
+	}
+
+	raws, err := vcWallet.GetAll(auth, wallet.Credential)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	credentials := o.parseCredentials(raws)
+
+	results, err := o.performQuery(credentials.raws, params...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	diagnostics := make([]*queryDiagnostic, len(params))
+
+	for i, param := range params {
+		diagnostics[i], err = o.diagnose(i, param, matchers[i], credentials)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return results, diagnostics, nil
+}
+
+// queriedCredentials are the credentials of a wallet, sorted by ID. Those that cannot be parsed are only in invalid,
+// with their parse error.
+type queriedCredentials struct {
+	ids     []string
+	vcs     map[string]*verifiable.Credential
+	raws    map[string]json.RawMessage
+	invalid map[string]string
+}
+
+func (o *Operation) parseCredentials(raws map[string]json.RawMessage) *queriedCredentials {
+	credentials := &queriedCredentials{
+		ids:     make([]string, 0, len(raws)),
+		vcs:     make(map[string]*verifiable.Credential, len(raws)),
+		raws:    make(map[string]json.RawMessage, len(raws)),
+		invalid: make(map[string]string),
+	}
+
+	for id := range raws {
+		credentials.ids = append(credentials.ids, id)
+	}
+
+	sort.Strings(credentials.ids)
+
+	for _, id := range credentials.ids {
+		vc, err := verifiable.ParseCredential(raws[id], verifiable.WithDisabledProofCheck(),
+			verifiable.WithNoCustomSchemaCheck(), verifiable.WithJSONLDDocumentLoader(o.ctx.JSONLDDocumentLoader()))
+		if err != nil {
+			credentials.invalid[id] = fmt.Sprintf("invalid credential: %s", err.Error())
+
+			continue
+		}
+
+		credentials.vcs[id] = vc
+		credentials.raws[id] = raws[id]
+	}
+
+	return credentials
+}
+
+// diagnose runs the query at index alone: the credentials it presents are matched, and the matcher tells why the
+// others are excluded.
+func (o *Operation) diagnose(index int, param *wallet.QueryParams, matcher credentialMatcher,
+	credentials *queriedCredentials) (*queryDiagnostic, error) {
+	diagnostic := &queryDiagnostic{Index: index, Type: param.Type, Matched: []string{}}
+
+	if matcher == nil {
+		return diagnostic, nil
+	}
+
+	presentations, err := o.performQuery(credentials.raws, param)
+	if err != nil {
+		return nil, err
+	}
+
+	matched := presentedCredentialIDs(presentations)
+
+	for _, id := range credentials.ids {
+		vc, ok := credentials.vcs[id]
+
+		switch {
+		case !ok:
+			diagnostic.Excluded = append(diagnostic.Excluded,
+				&queryExclusion{CredentialID: id, Reason: credentials.invalid[id]})
+		case matched[id]:
+			diagnostic.Matched = append(diagnostic.Matched, id)
+		default:
+			reason := matcher(vc)
+			if reason == "" {
+				reason = "not selected by the query"
+			}
+
+			diagnostic.Excluded = append(diagnostic.Excluded, &queryExclusion{CredentialID: id, Reason: reason})
+		}
+	}
+
+	return diagnostic, nil
+}
+
+// performQuery runs the queries against the credentials the way the wallet does. Returns no presentations if no
+// credential matched.
+func (o *Operation) performQuery(credentials map[string]json.RawMessage,
+	params ...*wallet.QueryParams) ([]*verifiable.Presentation, error) {
+	query := wallet.NewQuery(verifiable.NewVDRKeyResolver(o.ctx.VDRegistry()).PublicKeyFetcher(),
+		o.ctx.JSONLDDocumentLoader(), params...)
+
+	results, err := query.PerformQuery(credentials)
+	if errors.Is(err, wallet.ErrQueryNoResultFound) {
+		return nil, nil
+	}
+
+	return results, err
+}
+
+// presentedCredentialIDs returns the IDs of the credentials in the presentations.
+func presentedCredentialIDs(presentations []*verifiable.Presentation) map[string]bool {
+	ids := make(map[string]bool)
+
+	for _, vp := range presentations {
+		for _, c := range vp.Credentials() {
+			switch vc := c.(type) {
+			case *verifiable.Credential:
+				ids[vc.ID] = true
+			default:
+				raw, err := json.Marshal(vc)
+				if err != nil {
+					continue
+				}
+
+				credential := &struct {
+					ID string `json:"id"`
+				}{}
+
+				if json.Unmarshal(raw, credential) == nil {
+					ids[credential.ID] = true
+				}
+			}
+		}
+	}
+
+	return ids
+}
+
+// queryMatcher returns the matcher of the credential queries of param. A credential matches if it satisfies any of
+// them. The matcher is nil for queries that do not select credentials.
+func (o *Operation) queryMatcher(param *wallet.QueryParams) (credentialMatcher, error) {
+	queryType, err := wallet.GetQueryType(param.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	if queryType == wallet.DIDAuth {
+		return nil, nil
+	}
+
+	if len(param.Query) == 0 {
+		return nil, errors.New("missing credential query")
+	}
+
+	matchers := make([]credentialMatcher, len(param.Query))
+
+	for i, raw := range param.Query {
+		switch queryType { // nolint:exhaustive // DIDAuth is handled above
+		case wallet.QueryByExample:
+			matchers[i], err = exampleMatcher(raw)
+		case wallet.QueryByFrame:
+			matchers[i], err = o.frameMatcher(raw)
+		case wallet.PresentationExchange:
+			matchers[i], err = o.presentationDefinitionMatcher(raw)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return func(vc *verifiable.Credential) string {
+		reasons := make([]string, len(matchers))
+
+		for i, matcher := range matchers {
+			reasons[i] = matcher(vc)
+			if reasons[i] == "" {
+				return ""
+			}
+
+			if len(matchers) > 1 {
+				reasons[i] = fmt.Sprintf("credential query %d: %s", i, reasons[i])
+			}
+		}
+
+		return strings.Join(reasons, "; ")
+	}, nil
+}
+
+func exampleMatcher(raw json.RawMessage) (credentialMatcher, error) {
+	definition := &wallet.QueryByExampleDefinition{}
+
+	err := json.Unmarshal(raw, definition)
+	if err != nil {
+		return nil, err
+	}
+
+	example := definition.Example
+	if example == nil || len(example.Context) == 0 || example.Type == nil {
+		return nil, errors.New("'example' with '@context' and 'type' is required")
+	}
+
+	types, err := exampleTypes(example.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(vc *verifiable.Credential) string {
+		if missing := missingValues(vc.Context, example.Context); len(missing) > 0 {
+			return fmt.Sprintf("missing context %s", strings.Join(missing, ", "))
+		}
+
+		if missing := missingValues(vc.Types, types); len(missing) > 0 {
+			return fmt.Sprintf("missing type %s", strings.Join(missing, ", "))
+		}
+
+		if !trustedIssuer(vc, example.TrustedIssuer) {
+			return fmt.Sprintf("issuer %s is not trusted", vc.Issuer.ID)
+		}
+
+		if reason := schemaMismatch(vc, example.CredentialSchema); reason != "" {
+			return reason
+		}
+
+		if subjectID, ok := example.CredentialSubject["id"]; ok {
+			id, err := verifiable.SubjectID(vc.Subject)
+			if err != nil || id != subjectID {
+				return fmt.Sprintf("credential subject is not %s", subjectID)
+			}
+		}
+
+		return ""
+	}, nil
+}
+
+func (o *Operation) frameMatcher(raw json.RawMessage) (credentialMatcher, error) {
+	definition := &wallet.QueryByFrameDefinition{}
+
+	err := json.Unmarshal(raw, definition)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(definition.Frame) == 0 {
+		return nil, errors.New("'frame' is required")
+	}
+
+	keyFetcher := verifiable.NewVDRKeyResolver(o.ctx.VDRegistry()).PublicKeyFetcher()
+
+	return func(vc *verifiable.Credential) string {
+		if !trustedIssuer(vc, definition.TrustedIssuer) {
+			return fmt.Sprintf("issuer %s is not trusted", vc.Issuer.ID)
+		}
+
+		hasBBSProof := false
+
+		for _, proof := range vc.Proofs {
+			hasBBSProof = hasBBSProof || proof["type"] == wallet.BbsBlsSignature2020
+		}
+
+		if !hasBBSProof {
+			return fmt.Sprintf("credential has no %s proof", wallet.BbsBlsSignature2020)
+		}
+
+		_, err := vc.GenerateBBSSelectiveDisclosure(definition.Frame, nil,
+			verifiable.WithPublicKeyFetcher(keyFetcher),
+			verifiable.WithJSONLDDocumentLoader(o.ctx.JSONLDDocumentLoader()))
+		if err != nil {
+			return fmt.Sprintf("frame cannot be applied: %s", err.Error())
+		}
+
+		return ""
+	}, nil
+}
+
+// presentationDefinitionMatcher matches credentials satisfying any input descriptor of the presentation definition.
+func (o *Operation) presentationDefinitionMatcher(raw json.RawMessage) (credentialMatcher, error) {
+	definition := &presexch.PresentationDefinition{}
+
+	err := json.Unmarshal(raw, definition)
+	if err != nil {
+		return nil, err
+	}
+
+	err = definition.ValidateSchema()
+	if err != nil {
+		return nil, err
+	}
+
+	return func(vc *verifiable.Credential) string {
+		reasons := make([]string, len(definition.InputDescriptors))
+
+		for i, descriptor := range definition.InputDescriptors {
+			single := &presexch.PresentationDefinition{
+				ID:               definition.ID,
+				Format:           definition.Format,
+				InputDescriptors: []*presexch.InputDescriptor{descriptor},
+			}
+
+			_, err := single.CreateVP([]*verifiable.Credential{vc}, o.ctx.JSONLDDocumentLoader(),
+				verifiable.WithDisabledProofCheck(), verifiable.WithJSONLDDocumentLoader(o.ctx.JSONLDDocumentLoader()))
+			if err == nil {
+				return ""
+			}
+
+			reasons[i] = fmt.Sprintf("input descriptor %s: %s", descriptor.ID, err.Error())
+		}
+
+		return strings.Join(reasons, "; ")
+	}, nil
+}
+
+// exampleTypes returns the types of a QueryByExample, given as a string or a list of strings.
+func exampleTypes(exampleType interface{}) ([]string, error) {
+	switch t := exampleType.(type) {
+	case string:
+		return []string{t}, nil
+	case []interface{}:
+		types := make([]string, len(t))
+
+		for i, v := range t {
+			s, ok := v.(string)
+			if !ok {
+				return nil, errors.New("'type' must be a string or a list of strings")
+			}
+
+			types[i] = s
+		}
+
+		return types, nil
+	default:
+		return nil, errors.New("'type' must be a string or a list of strings")
+	}
+}
+
+// missingValues returns the wanted values not in values.
+func missingValues(values, wanted []string) []string {
+	var missing []string
+
+	for _, w := range wanted {
+		found := false
+
+		for _, v := range values {
+			found = found || v == w
+		}
+
+		if !found {
+			missing = append(missing, w)
+		}
+	}
+
+	return missing
+}
+
+// trustedIssuer returns true if the credential's issuer is trusted. Any issuer is trusted if there are none. Otherwise
+// the issuer must be one of them, and must be the issuer of every required one.
+func trustedIssuer(vc *verifiable.Credential, issuers []wallet.TrustedIssuerDefinition) bool {
+	trusted := len(issuers) == 0
+
+	for _, issuer := range issuers {
+		matched := strings.EqualFold(vc.Issuer.ID, issuer.Issuer)
+		if !matched && issuer.Required {
+			return false
+		}
+
+		trusted = trusted || matched
+	}
+
+	return trusted
+}
+
+func schemaMismatch(vc *verifiable.Credential, schema map[string]string) string {
+	if id, ok := schema["id"]; ok {
+		matched := false
+
+		for _, s := range vc.Schemas {
+			matched = matched || s.ID == id
+		}
+
+		if !matched {
+			return fmt.Sprintf("credential schema is not %s", id)
+		}
+	}
+
+	if schemaType, ok := schema["type"]; ok {
+		matched := false
+
+		for _, s := range vc.Schemas {
+			matched = matched || strings.EqualFold(s.Type, schemaType)
+		}
+
+		if !matched {
+			return fmt.Sprintf("credential schema type is not %s", schemaType)
+		}
+	}
+
+	return ""
+}
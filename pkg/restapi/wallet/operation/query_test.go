@@ -0,0 +1,250 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation // nolint:testpackage // changing to different package requires exposing internal features.
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/hyperledger/aries-framework-go/pkg/wallet"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	sampleDegreeCredential = `{
+		"@context": ["https://www.w3.org/2018/credentials/v1", {"@vocab": "https://example.com/vocab#"}],
+		"id": "%s",
+		"type": ["VerifiableCredential", "UniversityDegreeCredential"],
+		"issuer": "did:example:76e12ec712ebc6f1c221ebfeb1f",
+		"issuanceDate": "2010-01-01T19:23:24Z",
+		"credentialSubject": {
+			"id": "did:example:ebfeb1f712ebc6f1c276e12ec21",
+			"degree": {"type": "BachelorDegree", "name": "Bachelor of Science and Arts"}
+		}
+	}`
+	sampleQueryByExample = `{
+		"reason": "Please present your degree.",
+		"example": {
+			"@context": ["https://www.w3.org/2018/credentials/v1"],
+			"type": ["UniversityDegreeCredential"]
+		}
+	}`
+	samplePresentationDefinition = `{
+		"id": "%s",
+		"input_descriptors": [{
+			"id": "degree",
+			"schema": [{"uri": "https://www.w3.org/2018/credentials#VerifiableCredential"}],
+			"constraints": {
+				"fields": [{"path": ["$.credentialSubject.degree.type"]}]
+			}
+		}]
+	}`
+)
+
+func TestOperation_Query(t *testing.T) {
+	t.Run("query by example", func(t *testing.T) {
+		router := newRouter(t, newProvider(t))
+		auth := unlock(t, router)
+		degreeID, otherID := addSampleCredentials(t, router, auth)
+
+		rr := serve(router, http.MethodPost, queryPath, &queryReq{
+			walletAuth: auth,
+			Query: []*wallet.QueryParams{{
+				Type:  "QueryByExample",
+				Query: []json.RawMessage{json.RawMessage(sampleQueryByExample)},
+			}},
+		})
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		resp := &rawQueryResp{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), resp))
+		require.Len(t, resp.Results, 1)
+		require.Contains(t, string(resp.Results[0]), degreeID)
+		require.NotContains(t, string(resp.Results[0]), otherID)
+		require.Len(t, resp.Diagnostics, 1)
+		require.Equal(t, []string{degreeID}, resp.Diagnostics[0].Matched)
+		require.Len(t, resp.Diagnostics[0].Excluded, 1)
+		require.Equal(t, otherID, resp.Diagnostics[0].Excluded[0].CredentialID)
+		require.Contains(t, resp.Diagnostics[0].Excluded[0].Reason, "missing type UniversityDegreeCredential")
+	})
+
+	t.Run("query by example with a trusted issuer", func(t *testing.T) {
+		router := newRouter(t, newProvider(t))
+		auth := unlock(t, router)
+		addSampleCredentials(t, router, auth)
+
+		rr := serve(router, http.MethodPost, queryPath, &queryReq{
+			walletAuth: auth,
+			Query: []*wallet.QueryParams{{
+				Type: "QueryByExample",
+				Query: []json.RawMessage{json.RawMessage(`{"example": {
+					"@context": ["https://www.w3.org/2018/credentials/v1"],
+					"type": "VerifiableCredential",
+					"trustedIssuer": [{"issuer": "did:example:trusted", "required": true}]
+				}}`)},
+			}},
+		})
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		resp := &rawQueryResp{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), resp))
+		require.Empty(t, resp.Results)
+		require.Empty(t, resp.Diagnostics[0].Matched)
+		require.Len(t, resp.Diagnostics[0].Excluded, 2)
+
+		for _, excluded := range resp.Diagnostics[0].Excluded {
+			require.Equal(t, "issuer did:example:76e12ec712ebc6f1c221ebfeb1f is not trusted", excluded.Reason)
+		}
+	})
+
+	t.Run("presentation exchange and DIDAuth", func(t *testing.T) {
+		router := newRouter(t, newProvider(t))
+		auth := unlock(t, router)
+		degreeID, otherID := addSampleCredentials(t, router, auth)
+
+		rr := serve(router, http.MethodPost, queryPath, &queryReq{
+			walletAuth: auth,
+			Query: []*wallet.QueryParams{
+				{
+					Type: "PresentationExchange",
+					Query: []json.RawMessage{
+						json.RawMessage(fmt.Sprintf(samplePresentationDefinition, uuid.New().String())),
+					},
+				},
+				{Type: "DIDAuth"},
+			},
+		})
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		resp := &rawQueryResp{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), resp))
+		require.Len(t, resp.Results, 2)
+		require.Len(t, resp.Diagnostics, 2)
+		require.Equal(t, []string{degreeID}, resp.Diagnostics[0].Matched)
+		require.Len(t, resp.Diagnostics[0].Excluded, 1)
+		require.Equal(t, otherID, resp.Diagnostics[0].Excluded[0].CredentialID)
+		require.Contains(t, resp.Diagnostics[0].Excluded[0].Reason, "input descriptor degree")
+		require.Equal(t, 1, resp.Diagnostics[1].Index)
+		require.Empty(t, resp.Diagnostics[1].Matched)
+		require.Empty(t, resp.Diagnostics[1].Excluded)
+	})
+
+	t.Run("invalid stored credentials are reported as excluded", func(t *testing.T) {
+		router := newRouter(t, newProvider(t))
+		auth := unlock(t, router)
+		degreeID, otherID := addSampleCredentials(t, router, auth)
+		invalidID := uuid.New().URN()
+
+		rr := serve(router, http.MethodPost, addPath, &addContentReq{
+			contentAuth: contentAuth{walletAuth: auth, ContentType: wallet.Credential},
+			Content: json.RawMessage(fmt.Sprintf(`{
+				"@context": ["https://www.w3.org/2018/credentials/v1"],
+				"id": "%s",
+				"type": ["VerifiableCredential", "UniversityDegreeCredential"]
+			}`, invalidID)),
+		})
+		require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+
+		rr = serve(router, http.MethodPost, queryPath, &queryReq{
+			walletAuth: auth,
+			Query: []*wallet.QueryParams{{
+				Type:  "QueryByExample",
+				Query: []json.RawMessage{json.RawMessage(sampleQueryByExample)},
+			}},
+		})
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		resp := &rawQueryResp{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), resp))
+		require.Len(t, resp.Results, 1)
+		require.Contains(t, string(resp.Results[0]), degreeID)
+		require.Equal(t, []string{degreeID}, resp.Diagnostics[0].Matched)
+
+		reasons := map[string]string{}
+
+		for _, exclusion := range resp.Diagnostics[0].Excluded {
+			reasons[exclusion.CredentialID] = exclusion.Reason
+		}
+
+		require.Len(t, reasons, 2)
+		require.Contains(t, reasons[otherID], "missing type")
+		require.Contains(t, reasons[invalidID], "invalid credential")
+	})
+
+	t.Run("error if the request is invalid", func(t *testing.T) {
+		router := newRouter(t, newProvider(t))
+		auth := unlock(t, router)
+
+		rr := serve(router, http.MethodPost, queryPath, "invalid")
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		requireErrorResponse(t, rr.Body.Bytes(), "invalid request")
+
+		rr = serve(router, http.MethodPost, queryPath, &queryReq{walletAuth: walletAuth{UserID: auth.UserID}})
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		requireErrorResponse(t, rr.Body.Bytes(), "missing user ID or auth token")
+
+		rr = serve(router, http.MethodPost, queryPath, &queryReq{walletAuth: auth})
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		requireErrorResponse(t, rr.Body.Bytes(), "missing query")
+
+		for _, query := range []*wallet.QueryParams{
+			{Type: "invalid"},
+			{Type: "QueryByExample"},
+			{Type: "QueryByExample", Query: []json.RawMessage{json.RawMessage(`{"example": {}}`)}},
+			{Type: "QueryByFrame", Query: []json.RawMessage{json.RawMessage(`{}`)}},
+			{Type: "PresentationExchange", Query: []json.RawMessage{json.RawMessage(`{"id": "invalid"}`)}},
+		} {
+			rr = serve(router, http.MethodPost, queryPath, &queryReq{
+				walletAuth: auth,
+				Query:      []*wallet.QueryParams{query},
+			})
+			require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+			requireErrorResponse(t, rr.Body.Bytes(), "invalid query 0")
+		}
+	})
+
+	t.Run("error if the auth token is invalid", func(t *testing.T) {
+		router := newRouter(t, newProvider(t))
+		auth := unlock(t, router)
+		auth.Auth = "invalid"
+
+		rr := serve(router, http.MethodPost, queryPath, &queryReq{
+			walletAuth: auth,
+			Query:      []*wallet.QueryParams{{Type: "DIDAuth"}},
+		})
+		require.Equal(t, http.StatusUnauthorized, rr.Code, rr.Body.String())
+	})
+}
+
+// rawQueryResp is a queryResp with the presentations left undecoded.
+type rawQueryResp struct {
+	Results     []json.RawMessage  `json:"results"`
+	Diagnostics []*queryDiagnostic `json:"diagnostics"`
+}
+
+// addSampleCredentials adds a degree credential and another credential to the wallet, and returns their IDs.
+func addSampleCredentials(t *testing.T, router *mux.Router, auth walletAuth) (string, string) {
+	t.Helper()
+
+	degreeID, otherID := uuid.New().URN(), uuid.New().URN()
+
+	for _, content := range []string{
+		fmt.Sprintf(sampleDegreeCredential, degreeID),
+		fmt.Sprintf(sampleCredential, otherID),
+	} {
+		rr := serve(router, http.MethodPost, addPath, &addContentReq{
+			contentAuth: contentAuth{walletAuth: auth, ContentType: wallet.Credential},
+			Content:     json.RawMessage(content),
+		})
+		require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	}
+
+	return degreeID, otherID
+}
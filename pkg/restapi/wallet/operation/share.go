@@ -0,0 +1,528 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/common/model"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/common/service"
+	presentproofsvc "github.com/hyperledger/aries-framework-go/pkg/didcomm/protocol/presentproof"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/presexch"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/verifiable"
+	"github.com/hyperledger/aries-framework-go/pkg/wallet"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+
+	"github.com/trustbloc/wallet/pkg/restapi/common"
+	"github.com/trustbloc/wallet/pkg/restapi/common/store"
+)
+
+// states of the present proof protocol ending a WACI share flow.
+const (
+	presentProofDone       = "done"
+	presentProofAbandoning = "abandoning"
+	presentProofAbandoned  = "abandoned"
+)
+
+// waciShareStoreName is the name of the store holding the WACI shares waiting for the users to present or decline
+// the presentation requests of the verifiers.
+const waciShareStoreName = "wallet_waci_shares"
+
+// stateBufferSize is the size of the buffer of the present proof state events waited for by presentProof.
+const stateBufferSize = 10
+
+// errInvalidSelection is returned when the credentials selected by the user cannot be presented.
+var errInvalidSelection = errors.New("invalid credential selection")
+
+// proposePresentation accepts a WACI share invitation and proposes a presentation to the verifier. Returns the
+// presentation definition requested by the verifier and the credentials of the wallet matching it, which the user
+// presents with presentProof or rejects with declinePresentation.
+func (o *Operation) proposePresentation(w http.ResponseWriter, r *http.Request) {
+	req := &proposePresentationReq{}
+
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "invalid request: %s", err.Error())
+
+		return
+	}
+
+	if req.UserID == "" || req.Auth == "" {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing user ID or auth token")
+
+		return
+	}
+
+	if req.Invitation == nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing invitation")
+
+		return
+	}
+
+	vcWallet, ok := o.openUnlockedWallet(w, req.UserID, req.Auth)
+	if !ok {
+		return
+	}
+
+	request, err := vcWallet.ProposePresentation(req.Auth, req.Invitation,
+		wallet.WithFromDID(req.From), wallet.WithInitiateTimeout(req.Timeout),
+		wallet.WithConnectOptions(wallet.WithRouterConnections(o.routerConnections...)))
+	if err != nil {
+		writeWalletError(w, err, "failed to propose presentation")
+
+		return
+	}
+
+	thID, err := request.ThreadID()
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "invalid presentation request: %s",
+			err.Error())
+
+		return
+	}
+
+	resp, err := requestedPresentation(*request)
+	if err != nil {
+		o.declineRequest(thID, err)
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "invalid presentation request: %s",
+			err.Error())
+
+		return
+	}
+
+	// the share binds the thread to the user: only that user may present or decline the request.
+	err = store.Save(o.waciShareStore, thID, &waciShare{UserID: req.UserID})
+	if err != nil {
+		o.declineRequest(thID, err)
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "failed to save WACI share: %s",
+			err.Error())
+
+		return
+	}
+
+	resp.ThreadID = thID
+
+	query := []*wallet.QueryParams{{
+		Type:  wallet.PresentationExchange.Name(),
+		Query: []json.RawMessage{resp.PresentationDefinition},
+	}}
+
+	results, diagnostics, err := o.runQuery(vcWallet, req.Auth, query)
+	if err != nil {
+		o.declineRequest(thID, err)
+		writeWalletError(w, err, "failed to query credentials")
+
+		return
+	}
+
+	resp.Results, resp.Diagnostic = results, diagnostics[0]
+
+	common.WriteResponse(w, logger, resp)
+}
+
+// presentProof sends a presentation to the verifier of a thread. The presentation is either given, or made of the
+// stored credentials selected by the user and signed with a key of the wallet. Returns the web redirect sent by the
+// verifier.
+func (o *Operation) presentProof(w http.ResponseWriter, r *http.Request) {
+	req := &presentProofReq{}
+
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "invalid request: %s", err.Error())
+
+		return
+	}
+
+	if req.UserID == "" || req.Auth == "" {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing user ID or auth token")
+
+		return
+	}
+
+	if req.ThreadID == "" {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing thread ID")
+
+		return
+	}
+
+	if len(req.Presentation) == 0 && len(req.CredentialIDs) == 0 {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing credentials or presentation to present")
+
+		return
+	}
+
+	if len(req.Presentation) == 0 && (req.ProofOptions == nil || req.ProofOptions.Controller == "") {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing proof controller")
+
+		return
+	}
+
+	vcWallet, ok := o.openUnlockedWallet(w, req.UserID, req.Auth)
+	if !ok {
+		return
+	}
+
+	err = o.checkWACIShare(req.ThreadID, req.UserID)
+	if err != nil {
+		writeWalletError(w, err, "failed to present proof")
+
+		return
+	}
+
+	presentation := wallet.FromRawPresentation(req.Presentation)
+
+	if len(req.Presentation) == 0 {
+		vp, err := o.presentCredentials(vcWallet, req.UserID, req.Auth, req.ThreadID, req.CredentialIDs,
+			req.ProofOptions)
+		if err != nil {
+			writeWalletError(w, err, "failed to present credentials")
+
+			return
+		}
+
+		presentation = wallet.FromPresentation(vp)
+	}
+
+	states := make(chan service.StateMsg, stateBufferSize)
+
+	err = o.presentProofClient.RegisterMsgEvent(states)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError,
+			"failed to register present proof events: %s", err.Error())
+
+		return
+	}
+
+	defer func() {
+		e := o.presentProofClient.UnregisterMsgEvent(states)
+		if e != nil {
+			logger.Warnf("failed to unregister present proof events: %s", e)
+		}
+	}()
+
+	_, err = vcWallet.PresentProof(req.Auth, req.ThreadID, presentation)
+	if err != nil {
+		writeWalletError(w, err, "failed to present proof")
+
+		return
+	}
+
+	o.removeWACIShare(req.ThreadID)
+
+	resp, err := o.waitForPresentationAck(states, req.ThreadID, req.Timeout)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusGatewayTimeout, "failed to receive presentation ack: %s",
+			err.Error())
+
+		return
+	}
+
+	common.WriteResponse(w, logger, resp)
+}
+
+// declinePresentation rejects the presentation request of a thread.
+func (o *Operation) declinePresentation(w http.ResponseWriter, r *http.Request) {
+	req := &declinePresentationReq{}
+
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "invalid request: %s", err.Error())
+
+		return
+	}
+
+	if req.UserID == "" || req.Auth == "" {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing user ID or auth token")
+
+		return
+	}
+
+	if req.ThreadID == "" {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing thread ID")
+
+		return
+	}
+
+	if _, ok := o.openUnlockedWallet(w, req.UserID, req.Auth); !ok {
+		return
+	}
+
+	err = o.checkWACIShare(req.ThreadID, req.UserID)
+	if err != nil {
+		writeWalletError(w, err, "failed to decline presentation")
+
+		return
+	}
+
+	err = o.presentProofClient.DeclineRequestPresentation(req.ThreadID, req.Reason)
+	if err != nil {
+		writeWalletError(w, err, "failed to decline presentation")
+
+		return
+	}
+
+	o.removeWACIShare(req.ThreadID)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// declineRequest rejects a presentation request the wallet cannot show to the user.
+func (o *Operation) declineRequest(thID string, reason error) {
+	err := o.presentProofClient.DeclineRequestPresentation(thID, reason.Error())
+	if err != nil {
+		logger.Warnf("failed to decline presentation request: %s", err)
+	}
+}
+
+// checkWACIShare returns a not found error unless the presentation request of a thread was proposed to the user.
+// The threads of other users are reported as unknown, so as not to disclose them.
+func (o *Operation) checkWACIShare(thID, userID string) error {
+	raw, err := o.waciShareStore.Get(thID)
+	if err != nil {
+		if errors.Is(err, storage.ErrDataNotFound) {
+			return fmt.Errorf("no presentation request in thread %s: %w", thID, storage.ErrDataNotFound)
+		}
+
+		return fmt.Errorf("failed to get WACI share: %w", err)
+	}
+
+	share := &waciShare{}
+
+	err = json.Unmarshal(raw, share)
+	if err != nil {
+		return fmt.Errorf("invalid WACI share: %w", err)
+	}
+
+	if share.UserID != userID {
+		return fmt.Errorf("no presentation request in thread %s: %w", thID, storage.ErrDataNotFound)
+	}
+
+	return nil
+}
+
+// removeWACIShare removes the WACI share of a thread once its request is presented or declined.
+func (o *Operation) removeWACIShare(thID string) {
+	err := o.waciShareStore.Delete(thID)
+	if err != nil {
+		logger.Warnf("failed to remove WACI share %s: %s", thID, err.Error())
+	}
+}
+
+// presentCredentials returns a presentation of the stored credentials of a user submitted to the presentation
+// definition requested in a thread, signed with the challenge and domain of the request unless the proof options give
+// others.
+func (o *Operation) presentCredentials(vcWallet *wallet.Wallet, userID, auth, thID string, ids []string,
+	proofOptions *wallet.ProofOptions) (*verifiable.Presentation, error) {
+	err := o.checkWACIShare(thID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	actions, err := o.presentProofClient.Actions()
+	if err != nil {
+		return nil, err
+	}
+
+	var request *proposePresentationResp
+
+	for i := range actions {
+		if actions[i].PIID == thID {
+			request, err = requestedPresentation(actions[i].Msg)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if request == nil {
+		return nil, fmt.Errorf("no presentation request in thread %s: %w", thID, storage.ErrDataNotFound)
+	}
+
+	options := *proofOptions
+
+	if options.Challenge == "" {
+		options.Challenge = request.Challenge
+	}
+
+	if options.Domain == "" {
+		options.Domain = request.Domain
+	}
+
+	return o.submitCredentials(vcWallet, auth, request.PresentationDefinition, ids, &options)
+}
+
+// submitCredentials returns a presentation of the stored credentials submitted to a presentation definition, signed
+// with the proof options.
+func (o *Operation) submitCredentials(vcWallet *wallet.Wallet, auth string, rawDefinition json.RawMessage,
+	ids []string, proofOptions *wallet.ProofOptions) (*verifiable.Presentation, error) {
+	definition := &presexch.PresentationDefinition{}
+
+	err := json.Unmarshal(rawDefinition, definition)
+	if err != nil {
+		return nil, fmt.Errorf("invalid presentation definition: %w", err)
+	}
+
+	vcs := make([]*verifiable.Credential, len(ids))
+
+	for i, id := range ids {
+		raw, err := vcWallet.Get(auth, wallet.Credential, id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get credential %s: %w", id, err)
+		}
+
+		vcs[i], err = verifiable.ParseCredential(raw, verifiable.WithDisabledProofCheck(),
+			verifiable.WithNoCustomSchemaCheck(), verifiable.WithJSONLDDocumentLoader(o.ctx.JSONLDDocumentLoader()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse credential %s: %w", id, err)
+		}
+	}
+
+	vp, err := definition.CreateVP(vcs, o.ctx.JSONLDDocumentLoader(), verifiable.WithDisabledProofCheck(),
+		verifiable.WithJSONLDDocumentLoader(o.ctx.JSONLDDocumentLoader()))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errInvalidSelection, err.Error())
+	}
+
+	raw, err := vp.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	return vcWallet.Prove(auth, proofOptions, wallet.WithRawPresentationToProve(raw))
+}
+
+// waitForPresentationAck waits for the present proof flow of a thread to end, with the ack or the problem report of
+// the verifier.
+func (o *Operation) waitForPresentationAck(states <-chan service.StateMsg, thID string,
+	timeout time.Duration) (*presentProofResp, error) {
+	if timeout == 0 {
+		timeout = defaultWACITimeout
+	}
+
+	deadline := time.After(timeout)
+
+	ticker := time.NewTicker(actionPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			o.acceptPresentationProblemReport(thID)
+		case state := <-states:
+			if state.Type != service.PostState || state.Properties == nil {
+				continue
+			}
+
+			properties := state.Properties.All()
+
+			if piID, _ := properties["piid"].(string); piID != thID { // nolint:errcheck
+				continue
+			}
+
+			resp := &presentProofResp{Status: model.AckStatusOK}
+
+			switch state.StateID {
+			case presentProofDone:
+			case presentProofAbandoning, presentProofAbandoned:
+				resp.Status = model.AckStatusFAIL
+			default:
+				continue
+			}
+
+			if status, ok := properties["status"].(string); ok && status != "" {
+				resp.Status = status
+			}
+
+			resp.RedirectURL, _ = properties["url"].(string) // nolint:errcheck
+
+			return resp, nil
+		case <-deadline:
+			return nil, errors.New("timeout waiting for presentation ack")
+		}
+	}
+}
+
+// acceptPresentationProblemReport accepts the problem report of a thread if the verifier sent one, which abandons the
+// present proof flow.
+func (o *Operation) acceptPresentationProblemReport(thID string) {
+	actions, err := o.presentProofClient.Actions()
+	if err != nil {
+		logger.Warnf("failed to get present proof actions: %s", err)
+
+		return
+	}
+
+	for _, action := range actions {
+		if action.PIID != thID {
+			continue
+		}
+
+		switch action.Msg.Type() {
+		case presentproofsvc.ProblemReportMsgTypeV2, presentproofsvc.ProblemReportMsgTypeV3:
+			err = o.presentProofClient.AcceptProblemReport(thID)
+			if err != nil {
+				logger.Warnf("failed to accept problem report: %s", err)
+			}
+		}
+	}
+}
+
+// requestedPresentation returns the presentation definition, challenge and domain attached to a request
+// presentation message.
+func requestedPresentation(msg service.DIDCommMsgMap) (*proposePresentationResp, error) {
+	request := &presentproofsvc.RequestPresentationParams{}
+
+	err := request.FromDIDCommMsgMap(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range request.Attachments {
+		raw, err := request.Attachments[i].Data.Fetch()
+		if err != nil {
+			return nil, fmt.Errorf("presentation definition: %w", err)
+		}
+
+		// verifiers give the challenge and domain with the presentation definition, or in its options.
+		attachment := &struct {
+			Definition json.RawMessage `json:"presentation_definition"`
+			Challenge  string          `json:"challenge"`
+			Domain     string          `json:"domain"`
+			Options    struct {
+				Challenge string `json:"challenge"`
+				Domain    string `json:"domain"`
+			} `json:"options"`
+		}{}
+
+		err = json.Unmarshal(raw, attachment)
+		if err != nil || len(attachment.Definition) == 0 {
+			continue
+		}
+
+		resp := &proposePresentationResp{
+			PresentationDefinition: attachment.Definition,
+			Challenge:              attachment.Challenge,
+			Domain:                 attachment.Domain,
+		}
+
+		if attachment.Options.Challenge != "" {
+			resp.Challenge = attachment.Options.Challenge
+		}
+
+		if attachment.Options.Domain != "" {
+			resp.Domain = attachment.Options.Domain
+		}
+
+		return resp, nil
+	}
+
+	return nil, errors.New("missing presentation definition")
+}
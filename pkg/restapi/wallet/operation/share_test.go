@@ -0,0 +1,400 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation // nolint:testpackage // changing to different package requires exposing internal features.
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/hyperledger/aries-framework-go/component/storageutil/mem"
+	presentproofclient "github.com/hyperledger/aries-framework-go/pkg/client/presentproof"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/common/service"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/protocol/decorator"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/protocol/didexchange"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/protocol/presentproof"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/verifiable"
+	"github.com/hyperledger/aries-framework-go/pkg/framework/context"
+	"github.com/hyperledger/aries-framework-go/pkg/wallet"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/wallet/pkg/restapi/common/store"
+)
+
+const sampleShareRedirectURL = "https://verifier.example.com/waci-share"
+
+func TestOperation_WACIShare(t *testing.T) {
+	for _, version := range []service.Version{service.V1, service.V2} {
+		t.Run(fmt.Sprintf("share a credential over DIDComm %s", version), func(t *testing.T) {
+			router := newWACIHolder(t)
+			auth := unlock(t, router)
+			degreeID, otherID := addSampleCredentials(t, router, auth)
+			verifier := newWACIVerifier(t)
+
+			request := proposePresentation(t, router, auth, verifier.invitation(t, version))
+			require.Equal(t, verifier.challenge, request.Challenge)
+			require.Equal(t, verifier.domain, request.Domain)
+			require.Equal(t, []string{degreeID}, request.Diagnostic.Matched)
+			require.Len(t, request.Diagnostic.Excluded, 1)
+			require.Equal(t, otherID, request.Diagnostic.Excluded[0].CredentialID)
+			require.Len(t, request.Results, 1)
+
+			rr := serve(router, http.MethodPost, presentProofPath, &presentProofReq{
+				walletAuth:    auth,
+				ThreadID:      request.ThreadID,
+				CredentialIDs: []string{degreeID},
+				ProofOptions:  &wallet.ProofOptions{Controller: addEd25519Key(t, router, auth)},
+			})
+			require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+			resp := &presentProofResp{}
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), resp))
+			require.Equal(t, "OK", resp.Status)
+			require.Equal(t, sampleShareRedirectURL, resp.RedirectURL)
+
+			vp := verifier.presentation(t)
+			require.Len(t, vp.Credentials(), 1)
+			require.Len(t, vp.Proofs, 1)
+			require.Equal(t, verifier.challenge, vp.Proofs[0]["challenge"])
+			require.Equal(t, verifier.domain, vp.Proofs[0]["domain"])
+			require.Contains(t, vp.CustomFields, "presentation_submission")
+		})
+	}
+
+	t.Run("reject credentials not satisfying the presentation definition", func(t *testing.T) {
+		router := newWACIHolder(t)
+		auth := unlock(t, router)
+		_, otherID := addSampleCredentials(t, router, auth)
+		verifier := newWACIVerifier(t)
+
+		request := proposePresentation(t, router, auth, verifier.invitation(t, service.V1))
+
+		rr := serve(router, http.MethodPost, presentProofPath, &presentProofReq{
+			walletAuth:    auth,
+			ThreadID:      request.ThreadID,
+			CredentialIDs: []string{otherID},
+			ProofOptions:  &wallet.ProofOptions{Controller: addEd25519Key(t, router, auth)},
+		})
+		require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+		requireErrorResponse(t, rr.Body.Bytes(), "invalid credential selection")
+
+		rr = serve(router, http.MethodPost, presentProofPath, &presentProofReq{
+			walletAuth:    auth,
+			ThreadID:      request.ThreadID,
+			CredentialIDs: []string{uuid.New().URN()},
+			ProofOptions:  &wallet.ProofOptions{Controller: addEd25519Key(t, router, auth)},
+		})
+		require.Equal(t, http.StatusNotFound, rr.Code, rr.Body.String())
+	})
+
+	t.Run("decline a presentation request", func(t *testing.T) {
+		router := newWACIHolder(t)
+		auth := unlock(t, router)
+		degreeID, _ := addSampleCredentials(t, router, auth)
+		verifier := newWACIVerifier(t)
+
+		request := proposePresentation(t, router, auth, verifier.invitation(t, service.V1))
+
+		rr := serve(router, http.MethodPost, declinePresentationPath, &declinePresentationReq{
+			walletAuth: auth,
+			ThreadID:   request.ThreadID,
+			Reason:     "not interested",
+		})
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		rr = serve(router, http.MethodPost, presentProofPath, &presentProofReq{
+			walletAuth:    auth,
+			ThreadID:      request.ThreadID,
+			CredentialIDs: []string{degreeID},
+			ProofOptions:  &wallet.ProofOptions{Controller: addEd25519Key(t, router, auth)},
+		})
+		require.Equal(t, http.StatusNotFound, rr.Code, rr.Body.String())
+	})
+
+	t.Run("requests of other users are not found", func(t *testing.T) {
+		router := newWACIHolder(t)
+		auth := unlock(t, router)
+		other := unlock(t, router)
+		degreeID, _ := addSampleCredentials(t, router, auth)
+		verifier := newWACIVerifier(t)
+
+		request := proposePresentation(t, router, auth, verifier.invitation(t, service.V1))
+
+		rr := serve(router, http.MethodPost, presentProofPath, &presentProofReq{
+			walletAuth:    other,
+			ThreadID:      request.ThreadID,
+			CredentialIDs: []string{degreeID},
+			ProofOptions:  &wallet.ProofOptions{Controller: addEd25519Key(t, router, other)},
+		})
+		require.Equal(t, http.StatusNotFound, rr.Code, rr.Body.String())
+		requireErrorResponse(t, rr.Body.Bytes(), "no presentation request in thread")
+
+		rr = serve(router, http.MethodPost, presentProofPath, &presentProofReq{
+			walletAuth:   other,
+			ThreadID:     request.ThreadID,
+			Presentation: json.RawMessage(`{}`),
+		})
+		require.Equal(t, http.StatusNotFound, rr.Code, rr.Body.String())
+
+		rr = serve(router, http.MethodPost, declinePresentationPath, &declinePresentationReq{
+			walletAuth: other,
+			ThreadID:   request.ThreadID,
+		})
+		require.Equal(t, http.StatusNotFound, rr.Code, rr.Body.String())
+
+		shares, err := mem.NewProvider().OpenStore(waciShareStoreName)
+		require.NoError(t, err)
+		require.NoError(t, store.Save(shares, request.ThreadID, &waciShare{UserID: auth.UserID}))
+
+		_, err = (&Operation{waciShareStore: shares}).presentCredentials(nil, other.UserID, other.Auth,
+			request.ThreadID, []string{degreeID}, &wallet.ProofOptions{})
+		require.ErrorIs(t, err, storage.ErrDataNotFound)
+
+		rr = serve(router, http.MethodPost, presentProofPath, &presentProofReq{
+			walletAuth:    auth,
+			ThreadID:      request.ThreadID,
+			CredentialIDs: []string{degreeID},
+			ProofOptions:  &wallet.ProofOptions{Controller: addEd25519Key(t, router, auth)},
+		})
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	})
+
+	t.Run("report a verifier rejecting the presentation", func(t *testing.T) {
+		router := newWACIHolder(t)
+		auth := unlock(t, router)
+		degreeID, _ := addSampleCredentials(t, router, auth)
+		verifier := newWACIVerifier(t)
+		verifier.fail = true
+
+		request := proposePresentation(t, router, auth, verifier.invitation(t, service.V1))
+
+		rr := serve(router, http.MethodPost, presentProofPath, &presentProofReq{
+			walletAuth:    auth,
+			ThreadID:      request.ThreadID,
+			CredentialIDs: []string{degreeID},
+			ProofOptions:  &wallet.ProofOptions{Controller: addEd25519Key(t, router, auth)},
+		})
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		resp := &presentProofResp{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), resp))
+		require.Equal(t, "FAIL", resp.Status)
+		require.Equal(t, sampleShareRedirectURL, resp.RedirectURL)
+	})
+
+	t.Run("invalid requests", func(t *testing.T) {
+		router := newWACIHolder(t)
+		auth := unlock(t, router)
+
+		for _, path := range []string{proposePresentationPath, presentProofPath, declinePresentationPath} {
+			rr := serve(router, http.MethodPost, path, "invalid")
+			require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+
+			rr = serve(router, http.MethodPost, path, &walletAuth{UserID: auth.UserID})
+			require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+			requireErrorResponse(t, rr.Body.Bytes(), "missing user ID or auth token")
+		}
+
+		rr := serve(router, http.MethodPost, proposePresentationPath, &proposePresentationReq{walletAuth: auth})
+		require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+		requireErrorResponse(t, rr.Body.Bytes(), "missing invitation")
+
+		for _, path := range []string{presentProofPath, declinePresentationPath} {
+			rr = serve(router, http.MethodPost, path, &declinePresentationReq{walletAuth: auth})
+			require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+			requireErrorResponse(t, rr.Body.Bytes(), "missing thread ID")
+		}
+
+		rr = serve(router, http.MethodPost, presentProofPath, &presentProofReq{walletAuth: auth, ThreadID: "thread"})
+		require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+		requireErrorResponse(t, rr.Body.Bytes(), "missing credentials or presentation to present")
+
+		rr = serve(router, http.MethodPost, presentProofPath, &presentProofReq{
+			walletAuth:    auth,
+			ThreadID:      "thread",
+			CredentialIDs: []string{uuid.New().URN()},
+		})
+		require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+		requireErrorResponse(t, rr.Body.Bytes(), "missing proof controller")
+
+		rr = serve(router, http.MethodPost, presentProofPath, &presentProofReq{
+			walletAuth:    walletAuth{UserID: auth.UserID, Auth: "invalid"},
+			ThreadID:      "thread",
+			CredentialIDs: []string{uuid.New().URN()},
+			ProofOptions:  &wallet.ProofOptions{Controller: "did:example:holder"},
+		})
+		require.Equal(t, http.StatusUnauthorized, rr.Code, rr.Body.String())
+
+		rr = serve(router, http.MethodPost, presentProofPath, &presentProofReq{
+			walletAuth:    auth,
+			ThreadID:      uuid.New().String(),
+			CredentialIDs: []string{uuid.New().URN()},
+			ProofOptions:  &wallet.ProofOptions{Controller: "did:example:holder"},
+		})
+		require.Equal(t, http.StatusNotFound, rr.Code, rr.Body.String())
+
+		rr = serve(router, http.MethodPost, declinePresentationPath, &declinePresentationReq{
+			walletAuth: auth,
+			ThreadID:   uuid.New().String(),
+		})
+		require.Equal(t, http.StatusNotFound, rr.Code, rr.Body.String())
+	})
+
+	t.Run("requests without presentation definition", func(t *testing.T) {
+		_, err := requestedPresentation(service.NewDIDCommMsgMap(&presentproof.RequestPresentationV2{
+			Type: presentproof.RequestPresentationMsgTypeV2,
+			RequestPresentationsAttach: []decorator.Attachment{{
+				ID:   uuid.New().String(),
+				Data: decorator.AttachmentData{JSON: map[string]interface{}{"challenge": "challenge"}},
+			}},
+		}))
+		require.EqualError(t, err, "missing presentation definition")
+	})
+}
+
+func proposePresentation(t *testing.T, router *mux.Router, auth walletAuth,
+	invitation *wallet.GenericInvitation) *proposePresentationResp {
+	t.Helper()
+
+	rr := serve(router, http.MethodPost, proposePresentationPath, &proposePresentationReq{
+		walletAuth: auth,
+		Invitation: invitation,
+		Timeout:    10 * time.Second,
+	})
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	request := &proposePresentationResp{}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), request))
+	require.NotEmpty(t, request.ThreadID)
+	require.NotEmpty(t, request.PresentationDefinition)
+
+	return request
+}
+
+// waciVerifier is an agent requesting a degree credential like the WACI verifier of the mock adapter.
+type waciVerifier struct {
+	ctx           *context.Provider
+	client        *presentproofclient.Client
+	challenge     string
+	domain        string
+	fail          bool
+	presentations chan service.DIDCommMsgMap
+}
+
+func newWACIVerifier(t *testing.T) *waciVerifier {
+	t.Helper()
+
+	verifier := &waciVerifier{
+		ctx:           newAgent(t),
+		challenge:     uuid.New().String(),
+		domain:        uuid.New().String(),
+		presentations: make(chan service.DIDCommMsgMap, 1),
+	}
+
+	var err error
+
+	verifier.client, err = presentproofclient.New(verifier.ctx)
+	require.NoError(t, err)
+
+	for name, handle := range map[string]func(service.DIDCommAction){
+		didexchange.DIDExchange: func(action service.DIDCommAction) { action.Continue(nil) },
+		presentproof.Name:       verifier.verify,
+	} {
+		svc, err := verifier.ctx.Service(name)
+		require.NoError(t, err)
+
+		actions := make(chan service.DIDCommAction)
+		require.NoError(t, svc.(service.Event).RegisterActionEvent(actions))
+
+		go func(handle func(service.DIDCommAction)) {
+			for action := range actions {
+				handle(action)
+			}
+		}(handle)
+	}
+
+	return verifier
+}
+
+// invitation returns a WACI share invitation of the verifier.
+func (v *waciVerifier) invitation(t *testing.T, version service.Version) *wallet.GenericInvitation {
+	t.Helper()
+
+	return newInvitation(t, v.ctx, version, "share-vp", "streamlined-vp")
+}
+
+// presentation returns the presentation received by the verifier.
+func (v *waciVerifier) presentation(t *testing.T) *verifiable.Presentation {
+	t.Helper()
+
+	var msg service.DIDCommMsgMap
+
+	select {
+	case msg = <-v.presentations:
+	case <-time.After(time.Second):
+		require.Fail(t, "no presentation received")
+	}
+
+	presentation := &presentproof.PresentationParams{}
+	require.NoError(t, presentation.FromDIDCommMsgMap(msg))
+	require.Len(t, presentation.Attachments, 1)
+
+	raw, err := presentation.Attachments[0].Data.Fetch()
+	require.NoError(t, err)
+
+	vp, err := verifiable.ParsePresentation(raw, verifiable.WithPresDisabledProofCheck(),
+		verifiable.WithPresJSONLDDocumentLoader(v.ctx.JSONLDDocumentLoader()))
+	require.NoError(t, err)
+
+	return vp
+}
+
+// verify requests a degree credential when the holder proposes a presentation, and accepts or rejects the
+// presentation of the holder.
+func (v *waciVerifier) verify(action service.DIDCommAction) {
+	switch action.Message.Type() {
+	case presentproof.ProposePresentationMsgTypeV2, presentproof.ProposePresentationMsgTypeV3:
+		definition := json.RawMessage(fmt.Sprintf(samplePresentationDefinition, uuid.New().String()))
+
+		action.Continue(presentproofclient.WithRequestPresentation(&presentproofclient.RequestPresentation{
+			WillConfirm: true,
+			Attachments: []decorator.GenericAttachment{{
+				ID:        uuid.New().String(),
+				MediaType: "application/json",
+				Data: decorator.AttachmentData{JSON: map[string]interface{}{
+					"challenge":               v.challenge,
+					"domain":                  v.domain,
+					"presentation_definition": definition,
+				}},
+			}},
+		}))
+	case presentproof.PresentationMsgTypeV2, presentproof.PresentationMsgTypeV3:
+		v.presentations <- action.Message.(service.DIDCommMsgMap)
+
+		if v.fail {
+			piID, _ := action.Properties.All()["piid"].(string) // nolint:errcheck
+
+			err := v.client.DeclinePresentation(piID, presentproofclient.DeclineReason("invalid presentation"),
+				presentproofclient.DeclineRedirect(sampleShareRedirectURL))
+			if err != nil {
+				action.Stop(err)
+			}
+
+			return
+		}
+
+		action.Continue(presentproof.WithProperties(map[string]interface{}{
+			"~web-redirect": &decorator.WebRedirect{Status: "OK", URL: sampleShareRedirectURL},
+		}))
+	default:
+		action.Continue(nil)
+	}
+}
@@ -0,0 +1,323 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/pkg/doc/verifiable"
+	"github.com/hyperledger/aries-framework-go/pkg/wallet"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+
+	"github.com/trustbloc/wallet/pkg/restapi/common"
+	"github.com/trustbloc/wallet/pkg/restapi/wallet/status"
+)
+
+const (
+	// statusStoreName is the name of the store tracking the statuses of the credentials stored in wallets.
+	statusStoreName = "wallet_credential_statuses"
+	statusUserTag   = "user"
+
+	// statusTopic is the notifier topic of the status changes found by the status sweep.
+	statusTopic = "credential_status"
+)
+
+var errNoStatus = errors.New("credential has no status")
+
+// credentialStatus checks the status of a stored or given credential, or lists the last known statuses of the
+// credentials stored in an unlocked wallet if neither is given.
+func (o *Operation) credentialStatus(w http.ResponseWriter, r *http.Request) {
+	req := &credentialStatusReq{}
+
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "invalid request: %s", err.Error())
+
+		return
+	}
+
+	if req.UserID == "" || req.Auth == "" {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing user ID or auth token")
+
+		return
+	}
+
+	vcWallet, ok := o.openUnlockedWallet(w, req.UserID, req.Auth)
+	if !ok {
+		return
+	}
+
+	if req.CredentialID == "" && len(req.Credential) == 0 {
+		statuses, err := o.trackedStatuses(req.UserID)
+		if err != nil {
+			common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "failed to list statuses: %s",
+				err.Error())
+
+			return
+		}
+
+		common.WriteResponse(w, logger, &credentialStatusResp{Statuses: statuses})
+
+		return
+	}
+
+	raw := req.Credential
+
+	if req.CredentialID != "" {
+		raw, err = vcWallet.Get(req.Auth, wallet.Credential, req.CredentialID)
+		if err != nil {
+			writeWalletError(w, err, "failed to get credential")
+
+			return
+		}
+	}
+
+	vc, err := verifiable.ParseCredential(raw, verifiable.WithDisabledProofCheck(),
+		verifiable.WithNoCustomSchemaCheck(), verifiable.WithJSONLDDocumentLoader(o.ctx.JSONLDDocumentLoader()))
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "invalid credential: %s", err.Error())
+
+		return
+	}
+
+	checked, err := o.checkCredentialStatus(vc)
+	if errors.Is(err, errNoStatus) || errors.Is(err, status.ErrUnsupported) {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "failed to check status: %s", err.Error())
+
+		return
+	} else if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadGateway, "failed to check status: %s", err.Error())
+
+		return
+	}
+
+	if req.CredentialID != "" {
+		err = o.saveStatus(&statusRecord{UserID: req.UserID, credentialStatus: *checked})
+		if err != nil {
+			logger.Warnf("failed to save status of credential %s: %s", vc.ID, err.Error())
+		}
+	}
+
+	common.WriteResponse(w, logger, &credentialStatusResp{Statuses: []*credentialStatus{checked}})
+}
+
+// checkCredentialStatus checks the status of a credential against the status list of its issuer.
+func (o *Operation) checkCredentialStatus(vc *verifiable.Credential) (*credentialStatus, error) {
+	if vc.Status == nil {
+		return nil, errNoStatus
+	}
+
+	entry, err := status.ParseEntry(vc.Status)
+	if err != nil {
+		return nil, err
+	}
+
+	checked, err := o.statusChecker.Check(entry, vc.Issuer.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+
+	return &credentialStatus{
+		CredentialID: vc.ID,
+		Issuer:       vc.Issuer.ID,
+		Entry:        entry,
+		Status:       checked,
+		CheckedAt:    &now,
+	}, nil
+}
+
+// trackStatus starts tracking the status of a credential stored in the wallet of a user, if it has a supported
+// status. Failing to track a status does not fail storing the credential.
+func (o *Operation) trackStatus(userID string, vc *verifiable.Credential) {
+	if vc.Status == nil {
+		return
+	}
+
+	entry, err := status.ParseEntry(vc.Status)
+	if err != nil {
+		logger.Infof("not tracking status of credential %s: %s", vc.ID, err.Error())
+
+		return
+	}
+
+	err = o.saveStatus(&statusRecord{UserID: userID, credentialStatus: credentialStatus{
+		CredentialID: vc.ID,
+		Issuer:       vc.Issuer.ID,
+		Entry:        entry,
+		Status:       status.Active,
+	}})
+	if err != nil {
+		logger.Warnf("failed to track status of credential %s: %s", vc.ID, err.Error())
+	}
+}
+
+// untrackStatus stops tracking the status of a credential removed from the wallet of a user.
+func (o *Operation) untrackStatus(userID, credentialID string) {
+	err := o.statusStore.Delete(credentialKey(userID, credentialID))
+	if err != nil && !errors.Is(err, storage.ErrDataNotFound) {
+		logger.Warnf("failed to untrack status of credential %s: %s", credentialID, err.Error())
+	}
+}
+
+// trackedStatuses returns the last known statuses of the credentials stored in the wallet of a user.
+func (o *Operation) trackedStatuses(userID string) ([]*credentialStatus, error) {
+	records, err := o.statusRecords(statusUserTag + ":" + userTag(userID))
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]*credentialStatus, len(records))
+
+	for i := range records {
+		statuses[i] = &records[i].credentialStatus
+	}
+
+	return statuses, nil
+}
+
+// sweepStatuses checks the statuses of all the tracked credentials, and publishes the status changes on the notifier.
+func (o *Operation) sweepStatuses() {
+	records, err := o.statusRecords(statusUserTag)
+	if err != nil {
+		logger.Errorf("status sweep: failed to list tracked credentials: %s", err.Error())
+
+		return
+	}
+
+	for _, record := range records {
+		previous := record.Status
+
+		checked, err := o.statusChecker.Check(record.Entry, record.Issuer)
+		if err != nil {
+			record.Error = err.Error()
+		} else {
+			record.Status, record.Error = checked, ""
+		}
+
+		now := time.Now().UTC()
+		record.CheckedAt = &now
+
+		err = o.saveStatus(record)
+		if err != nil {
+			logger.Errorf("status sweep: failed to save status of credential %s: %s", record.CredentialID,
+				err.Error())
+		}
+
+		if record.Status != previous {
+			o.notifyStatus(record)
+		}
+	}
+}
+
+// sweepStatusesEvery runs the status sweep periodically, until the operation is closed.
+func (o *Operation) sweepStatusesEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-o.done:
+			return
+		case <-ticker.C:
+			o.sweepStatuses()
+		}
+	}
+}
+
+func (o *Operation) notifyStatus(record *statusRecord) {
+	if o.notifier == nil {
+		return
+	}
+
+	msg, err := json.Marshal(&statusEvent{
+		UserID:       record.UserID,
+		CredentialID: record.CredentialID,
+		Status:       record.Status,
+		Purpose:      record.Entry.Purpose,
+	})
+	if err != nil {
+		logger.Errorf("failed to marshal status event: %s", err.Error())
+
+		return
+	}
+
+	err = o.notifier.Notify(statusTopic, msg)
+	if err != nil {
+		logger.Warnf("failed to publish status of credential %s: %s", record.CredentialID, err.Error())
+	}
+}
+
+func (o *Operation) saveStatus(record *statusRecord) error {
+	bits, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	return o.statusStore.Put(credentialKey(record.UserID, record.CredentialID), bits,
+		storage.Tag{Name: statusUserTag, Value: userTag(record.UserID)})
+}
+
+func (o *Operation) statusRecords(query string) ([]*statusRecord, error) {
+	iter, err := o.statusStore.Query(query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if closeErr := iter.Close(); closeErr != nil {
+			logger.Warnf("failed to close statuses iterator: %s", closeErr.Error())
+		}
+	}()
+
+	var records []*statusRecord
+
+	for {
+		more, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		if !more {
+			return records, nil
+		}
+
+		bits, err := iter.Value()
+		if err != nil {
+			return nil, err
+		}
+
+		record := &statusRecord{}
+
+		err = json.Unmarshal(bits, record)
+		if err != nil {
+			return nil, fmt.Errorf("invalid status record: %w", err)
+		}
+
+		records = append(records, record)
+	}
+}
+
+// userTag returns the value of the user tag of status, expiry and connection records. User IDs are hashed, as tag
+// values may not hold some of their characters.
+func userTag(userID string) string {
+	hash := sha256.Sum256([]byte(userID))
+
+	return hex.EncodeToString(hash[:])
+}
+
+// credentialKey returns the key of the records tracking a credential stored in the wallet of a user.
+func credentialKey(userID, credentialID string) string {
+	return userTag(userID) + "_" + credentialID
+}
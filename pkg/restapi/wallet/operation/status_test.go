@@ -0,0 +1,322 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation // nolint:testpackage // changing to different package requires exposing internal features.
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/hyperledger/aries-framework-go/pkg/wallet"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/wallet/pkg/restapi/internal/mocks"
+	"github.com/trustbloc/wallet/pkg/restapi/wallet/status"
+)
+
+const (
+	sampleStatusCredential = `{
+		"@context": ["https://www.w3.org/2018/credentials/v1", "https://w3id.org/vc/status-list/2021/v1"],
+		"id": "%s",
+		"type": ["VerifiableCredential"],
+		"issuer": "%s",
+		"issuanceDate": "2010-01-01T19:23:24Z",
+		"credentialStatus": {
+			"id": "%[3]s#%[4]d",
+			"type": "StatusList2021Entry",
+			"statusPurpose": "%[5]s",
+			"statusListIndex": "%[4]d",
+			"statusListCredential": "%[3]s"
+		},
+		"credentialSubject": {"id": "did:example:ebfeb1f712ebc6f1c276e12ec21"}
+	}`
+	sampleStatusList = `{
+		"@context": ["https://www.w3.org/2018/credentials/v1", "https://w3id.org/vc/status-list/2021/v1"],
+		"id": "%s",
+		"type": ["VerifiableCredential", "StatusList2021Credential"],
+		"issuer": "%s",
+		"issuanceDate": "2010-01-01T19:23:24Z",
+		"credentialSubject": {
+			"id": "%[1]s#list",
+			"type": "StatusList2021",
+			"statusPurpose": "%[3]s",
+			"encodedList": "%[4]s"
+		}
+	}`
+	sampleStatusIndex = 5
+)
+
+func TestOperation_CredentialStatus(t *testing.T) {
+	t.Run("check, track and sweep the status of a stored credential", func(t *testing.T) {
+		events := make(chan *statusEvent, 1)
+		notifier := &mocks.Notifier{NotifyFunc: func(topic string, msg []byte) error {
+			require.Equal(t, statusTopic, topic)
+
+			event := &statusEvent{}
+			require.NoError(t, json.Unmarshal(msg, event))
+			events <- event
+
+			return nil
+		}}
+
+		p := newProvider(t)
+
+		op, err := New(p, notifier, nil)
+		require.NoError(t, err)
+
+		router := routerOf(op)
+		auth := unlock(t, router)
+		issuer := newStatusListIssuer(t, router, auth, status.PurposeRevocation)
+		op.statusChecker = issuer.checker(p)
+
+		vc := issuer.issue(t, status.PurposeRevocation)
+
+		rr := serve(router, http.MethodPost, addPath, &addContentReq{
+			contentAuth: contentAuth{walletAuth: auth, ContentType: wallet.Credential},
+			Content:     vc,
+		})
+		require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+
+		id := credentialID(t, vc)
+
+		require.Equal(t, status.Active, requireStatus(t, router, &credentialStatusReq{
+			walletAuth: auth, CredentialID: id,
+		}).Status)
+
+		checks := requireVerified(t, router, &verifyReq{walletAuth: auth, StoredCredentialID: id}, true)
+		require.Equal(t, checkPassed, checks[statusCheck])
+
+		op.sweepStatuses()
+		require.Empty(t, events)
+
+		issuer.setStatus(t, sampleStatusIndex)
+		op.sweepStatuses()
+
+		require.Equal(t, &statusEvent{
+			UserID: auth.UserID, CredentialID: id, Status: status.Revoked, Purpose: status.PurposeRevocation,
+		}, <-events)
+
+		rr = serve(router, http.MethodPost, credentialStatusPath, &credentialStatusReq{walletAuth: auth})
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		resp := &credentialStatusResp{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), resp))
+		require.Len(t, resp.Statuses, 1)
+		require.Equal(t, id, resp.Statuses[0].CredentialID)
+		require.Equal(t, status.Revoked, resp.Statuses[0].Status)
+		require.NotNil(t, resp.Statuses[0].CheckedAt)
+
+		checks = requireVerified(t, router, &verifyReq{walletAuth: auth, StoredCredentialID: id}, false)
+		require.Equal(t, checkFailed, checks[statusCheck])
+
+		rr = serve(router, http.MethodPost, removePath, &removeContentReq{
+			contentAuth: contentAuth{walletAuth: auth, ContentType: wallet.Credential},
+			ContentID:   id,
+		})
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		rr = serve(router, http.MethodPost, credentialStatusPath, &credentialStatusReq{walletAuth: auth})
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		require.JSONEq(t, `{"statuses": []}`, rr.Body.String())
+	})
+
+	t.Run("check the suspension of a given credential", func(t *testing.T) {
+		p := newProvider(t)
+
+		op, err := New(p, nil, nil)
+		require.NoError(t, err)
+
+		router := routerOf(op)
+		auth := unlock(t, router)
+		issuer := newStatusListIssuer(t, router, auth, status.PurposeSuspension)
+		op.statusChecker = issuer.checker(p)
+		issuer.setStatus(t, sampleStatusIndex)
+
+		require.Equal(t, status.Suspended, requireStatus(t, router, &credentialStatusReq{
+			walletAuth: auth, Credential: issuer.issue(t, status.PurposeSuspension),
+		}).Status)
+
+		rr := serve(router, http.MethodPost, credentialStatusPath, &credentialStatusReq{
+			walletAuth: auth, Credential: issuer.issue(t, status.PurposeRevocation),
+		})
+		require.Equal(t, http.StatusBadGateway, rr.Code, rr.Body.String())
+		requireErrorResponse(t, rr.Body.Bytes(), "status list credential has purpose suspension, not revocation")
+	})
+
+	t.Run("stop sweeping statuses once closed", func(t *testing.T) {
+		op, err := New(newProvider(t), nil, nil)
+		require.NoError(t, err)
+
+		stopped := make(chan struct{})
+
+		go func() {
+			op.sweepStatusesEvery(time.Millisecond)
+			close(stopped)
+		}()
+
+		op.Close()
+		op.Close()
+
+		select {
+		case <-stopped:
+		case <-time.After(time.Second):
+			require.Fail(t, "status sweep not stopped")
+		}
+	})
+
+	t.Run("invalid requests", func(t *testing.T) {
+		router := newRouter(t, newProvider(t))
+		auth := unlock(t, router)
+
+		rr := serve(router, http.MethodPost, credentialStatusPath, "invalid")
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+
+		rr = serve(router, http.MethodPost, credentialStatusPath, &credentialStatusReq{})
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		requireErrorResponse(t, rr.Body.Bytes(), "missing user ID or auth token")
+
+		rr = serve(router, http.MethodPost, credentialStatusPath, &credentialStatusReq{
+			walletAuth: walletAuth{UserID: auth.UserID, Auth: "invalid"},
+		})
+		require.Equal(t, http.StatusUnauthorized, rr.Code, rr.Body.String())
+
+		rr = serve(router, http.MethodPost, credentialStatusPath, &credentialStatusReq{
+			walletAuth: auth, CredentialID: "unknown",
+		})
+		require.Equal(t, http.StatusNotFound, rr.Code, rr.Body.String())
+
+		rr = serve(router, http.MethodPost, credentialStatusPath, &credentialStatusReq{
+			walletAuth: auth, Credential: json.RawMessage(`{}`),
+		})
+		require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+		requireErrorResponse(t, rr.Body.Bytes(), "invalid credential")
+
+		rr = serve(router, http.MethodPost, credentialStatusPath, &credentialStatusReq{
+			walletAuth: auth, Credential: json.RawMessage(fmt.Sprintf(sampleCredential, uuid.New().URN())),
+		})
+		require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+		requireErrorResponse(t, rr.Body.Bytes(), "credential has no status")
+
+		rr = serve(router, http.MethodPost, credentialStatusPath, &credentialStatusReq{
+			walletAuth: auth,
+			Credential: json.RawMessage(fmt.Sprintf(sampleStatusCredential, uuid.New().URN(),
+				"did:example:76e12ec712ebc6f1c221ebfeb1f", "http://localhost:1/status", sampleStatusIndex,
+				status.PurposeRevocation)),
+		})
+		require.Equal(t, http.StatusBadGateway, rr.Code, rr.Body.String())
+		requireErrorResponse(t, rr.Body.Bytes(), "status list credential http://localhost:1/status")
+	})
+}
+
+// statusListIssuer issues credentials with a status in a status list it publishes, signed with a key of a wallet.
+type statusListIssuer struct {
+	server     *httptest.Server
+	router     *mux.Router
+	auth       walletAuth
+	controller string
+	purpose    string
+
+	mutex sync.Mutex
+	list  json.RawMessage
+}
+
+func newStatusListIssuer(t *testing.T, router *mux.Router, auth walletAuth, purpose string) *statusListIssuer {
+	t.Helper()
+
+	issuer := &statusListIssuer{
+		router:     router,
+		auth:       auth,
+		controller: addEd25519Key(t, router, auth),
+		purpose:    purpose,
+	}
+
+	issuer.server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		issuer.mutex.Lock()
+		defer issuer.mutex.Unlock()
+
+		_, _ = w.Write(issuer.list) // nolint:errcheck // test server
+	}))
+
+	t.Cleanup(issuer.server.Close)
+
+	issuer.setStatus(t)
+
+	return issuer
+}
+
+// checker returns a status checker fetching the status lists of the issuer from its test server.
+func (i *statusListIssuer) checker(p Provider) *status.Checker {
+	return status.NewChecker(p.VDRegistry(), p.JSONLDDocumentLoader(), i.server.Client(), 0,
+		status.WithAddressFilter(func(net.IP) bool { return true }))
+}
+
+// setStatus publishes a status list with the bits set at the given indexes.
+func (i *statusListIssuer) setStatus(t *testing.T, indexes ...int) {
+	t.Helper()
+
+	bits := make([]byte, 16)
+
+	for _, index := range indexes {
+		bits[index/8] |= 1 << (7 - index%8)
+	}
+
+	var compressed bytes.Buffer
+
+	writer := gzip.NewWriter(&compressed)
+	_, err := writer.Write(bits)
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	list := issueCredential(t, i.router, i.auth, i.controller, wallet.Ed25519Signature2018,
+		fmt.Sprintf(sampleStatusList, i.server.URL, i.controller, i.purpose,
+			base64.RawURLEncoding.EncodeToString(compressed.Bytes())))
+
+	i.mutex.Lock()
+	i.list = list
+	i.mutex.Unlock()
+}
+
+func (i *statusListIssuer) issue(t *testing.T, purpose string) json.RawMessage {
+	t.Helper()
+
+	return issueCredential(t, i.router, i.auth, i.controller, wallet.Ed25519Signature2018,
+		fmt.Sprintf(sampleStatusCredential, uuid.New().URN(), i.controller, i.server.URL, sampleStatusIndex,
+			purpose))
+}
+
+func credentialID(t *testing.T, raw json.RawMessage) string {
+	t.Helper()
+
+	vc := &struct {
+		ID string `json:"id"`
+	}{}
+	require.NoError(t, json.Unmarshal(raw, vc))
+
+	return vc.ID
+}
+
+func requireStatus(t *testing.T, router *mux.Router, req *credentialStatusReq) *credentialStatus {
+	t.Helper()
+
+	rr := serve(router, http.MethodPost, credentialStatusPath, req)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	resp := &credentialStatusResp{}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), resp))
+	require.Len(t, resp.Statuses, 1)
+
+	return resp.Statuses[0]
+}
@@ -0,0 +1,628 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/pkg/client/issuecredential"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/common/model"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/common/service"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/protocol/decorator"
+	issuecredentialsvc "github.com/hyperledger/aries-framework-go/pkg/didcomm/protocol/issuecredential"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/verifiable"
+	"github.com/hyperledger/aries-framework-go/pkg/wallet"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+
+	"github.com/trustbloc/wallet/pkg/restapi/common"
+	"github.com/trustbloc/wallet/pkg/restapi/common/store"
+)
+
+// formats of the credential manifest and credential fulfillment attachments of WACI issuance messages.
+const (
+	manifestFormat    = "dif/credential-manifest/manifest@v1.0"
+	fulfillmentFormat = "dif/credential-manifest/fulfillment@v1.0"
+)
+
+const (
+	// waciIssuanceStoreName is the name of the store holding the WACI issuances waiting for the users to accept or
+	// decline the offers of the issuers.
+	waciIssuanceStoreName = "wallet_waci_issuances"
+
+	defaultWACITimeout = 2 * time.Minute
+	actionPollInterval = 200 * time.Millisecond
+)
+
+// proposeCredential accepts a WACI issuance invitation and proposes a credential to the issuer. Returns the credential
+// manifest and the fulfillment preview offered by the issuer, which the user confirms with requestCredential or
+// rejects with declineCredential.
+func (o *Operation) proposeCredential(w http.ResponseWriter, r *http.Request) {
+	req := &proposeCredentialReq{}
+
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "invalid request: %s", err.Error())
+
+		return
+	}
+
+	if req.UserID == "" || req.Auth == "" {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing user ID or auth token")
+
+		return
+	}
+
+	if req.Invitation == nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing invitation")
+
+		return
+	}
+
+	vcWallet, ok := o.openUnlockedWallet(w, req.UserID, req.Auth)
+	if !ok {
+		return
+	}
+
+	resp, ok := o.offerCredential(w, vcWallet, req, "")
+	if !ok {
+		return
+	}
+
+	common.WriteResponse(w, logger, resp)
+}
+
+// offerCredential proposes a credential to the issuer of a WACI issuance invitation, and saves the issuance renewing
+// the credential with the given ID if any until the user accepts or declines the offer. Returns the offer of the
+// issuer, or writes the error response.
+func (o *Operation) offerCredential(w http.ResponseWriter, vcWallet *wallet.Wallet, req *proposeCredentialReq,
+	renews string) (*proposeCredentialResp, bool) {
+	offer, err := vcWallet.ProposeCredential(req.Auth, req.Invitation,
+		wallet.WithFromDID(req.From), wallet.WithInitiateTimeout(req.Timeout),
+		wallet.WithConnectOptions(wallet.WithRouterConnections(o.routerConnections...)))
+	if err != nil {
+		writeWalletError(w, err, "failed to propose credential")
+
+		return nil, false
+	}
+
+	thID, err := protocolInstanceID(*offer)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "invalid credential offer: %s",
+			err.Error())
+
+		return nil, false
+	}
+
+	resp, err := offeredManifest(*offer)
+	if err != nil {
+		o.declineOffer(thID, err)
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "invalid credential offer: %s",
+			err.Error())
+
+		return nil, false
+	}
+
+	resp.ThreadID = thID
+
+	if len(resp.Fulfillment) > 0 {
+		resp.Descriptors, err = vcWallet.ResolveCredentialManifest(req.Auth, resp.Manifest,
+			wallet.ResolveRawFulfillment(resp.Fulfillment))
+		if err != nil {
+			o.declineOffer(thID, err)
+			writeWalletError(w, err, "failed to resolve credential manifest")
+
+			return nil, false
+		}
+	}
+
+	// the issuance binds the thread to the user: only that user may accept or decline the offer.
+	err = store.Save(o.waciIssuanceStore, thID, &waciIssuance{
+		UserID:     req.UserID,
+		Invitation: req.Invitation,
+		Renews:     renews,
+	})
+	if err != nil {
+		o.declineOffer(thID, err)
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "failed to save WACI issuance: %s",
+			err.Error())
+
+		return nil, false
+	}
+
+	return resp, true
+}
+
+// requestCredential accepts the credential offer of a thread, and saves the credentials issued in response in the
+// wallet. Returns the web redirect sent by the issuer. Credentials received after the timeout are declined.
+func (o *Operation) requestCredential(w http.ResponseWriter, r *http.Request) {
+	req := &requestCredentialReq{}
+
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "invalid request: %s", err.Error())
+
+		return
+	}
+
+	if req.UserID == "" || req.Auth == "" {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing user ID or auth token")
+
+		return
+	}
+
+	if req.ThreadID == "" {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing thread ID")
+
+		return
+	}
+
+	vcWallet, ok := o.openUnlockedWallet(w, req.UserID, req.Auth)
+	if !ok {
+		return
+	}
+
+	issuance, ok := o.userWACIIssuance(w, req.ThreadID, req.UserID)
+	if !ok {
+		return
+	}
+
+	if issuance.Requested {
+		common.WriteErrorResponsef(w, logger, http.StatusConflict, "credential already requested for thread [%s]",
+			req.ThreadID)
+
+		return
+	}
+
+	var opts []wallet.ConcludeInteractionOptions
+
+	if len(req.Presentation) > 0 {
+		opts = append(opts, wallet.FromRawPresentation(req.Presentation))
+	}
+
+	_, err = vcWallet.RequestCredential(req.Auth, req.ThreadID, opts...)
+	if err != nil {
+		writeWalletError(w, err, "failed to request credential")
+
+		return
+	}
+
+	// the issuance is kept until the credential is accepted or declined, or the problem report of the issuer accepted.
+	issuance.Requested = true
+
+	err = store.Save(o.waciIssuanceStore, req.ThreadID, issuance)
+	if err != nil {
+		logger.Warnf("failed to save WACI issuance %s: %s", req.ThreadID, err.Error())
+	}
+
+	origin := &credentialOrigin{Protocol: issuedByWACI, Invitation: issuance.Invitation}
+
+	action, err := o.waitForIssuance(req.ThreadID, req.Timeout)
+	if err != nil {
+		go o.declineLateIssuance(req.ThreadID)
+
+		common.WriteErrorResponsef(w, logger, http.StatusGatewayTimeout, "failed to receive credential: %s",
+			err.Error())
+
+		return
+	}
+
+	resp := &requestCredentialResp{Status: model.AckStatusOK, CredentialIDs: []string{}}
+
+	if redirect := webRedirect(action.Msg); redirect != nil {
+		resp.Status, resp.RedirectURL = redirect.Status, redirect.URL
+	}
+
+	switch action.Msg.Type() {
+	case issuecredentialsvc.ProblemReportMsgTypeV2, issuecredentialsvc.ProblemReportMsgTypeV3:
+		err = o.issueCredentialClient.AcceptProblemReport(action.PIID)
+
+		o.removeWACIIssuance(req.ThreadID)
+
+		if err != nil {
+			common.WriteErrorResponsef(w, logger, http.StatusInternalServerError,
+				"failed to accept problem report: %s", err.Error())
+
+			return
+		}
+
+		if resp.Status == model.AckStatusOK || resp.Status == "" {
+			resp.Status = model.AckStatusFAIL
+		}
+
+		common.WriteResponse(w, logger, resp)
+
+		return
+	}
+
+	resp.CredentialIDs, err = o.saveIssuedCredentials(vcWallet, req.UserID, req.Auth, action.Msg, origin,
+		issuance.Renews)
+	if err != nil {
+		e := o.issueCredentialClient.DeclineCredential(action.PIID, err.Error())
+		if e != nil {
+			logger.Warnf("failed to decline credential: %s", e)
+		}
+
+		o.removeWACIIssuance(req.ThreadID)
+
+		writeWalletError(w, err, "failed to save credentials")
+
+		return
+	}
+
+	// the credentials are saved in the wallet, not in the verifiable store of the agent.
+	err = o.issueCredentialClient.AcceptCredential(action.PIID, issuecredential.AcceptBySkippingStorage())
+
+	o.removeWACIIssuance(req.ThreadID)
+
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "failed to accept credential: %s",
+			err.Error())
+
+		return
+	}
+
+	common.WriteResponse(w, logger, resp)
+}
+
+// declineCredential rejects the credential offer of a thread.
+func (o *Operation) declineCredential(w http.ResponseWriter, r *http.Request) {
+	req := &declineCredentialReq{}
+
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "invalid request: %s", err.Error())
+
+		return
+	}
+
+	if req.UserID == "" || req.Auth == "" {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing user ID or auth token")
+
+		return
+	}
+
+	if req.ThreadID == "" {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing thread ID")
+
+		return
+	}
+
+	if _, ok := o.openUnlockedWallet(w, req.UserID, req.Auth); !ok {
+		return
+	}
+
+	issuance, ok := o.userWACIIssuance(w, req.ThreadID, req.UserID)
+	if !ok {
+		return
+	}
+
+	// a requested credential is declined when it is received, see declineLateIssuance.
+	if issuance.Requested {
+		common.WriteErrorResponsef(w, logger, http.StatusConflict, "credential already requested for thread [%s]",
+			req.ThreadID)
+
+		return
+	}
+
+	err = o.issueCredentialClient.DeclineOffer(req.ThreadID, req.Reason)
+	if err != nil {
+		writeWalletError(w, err, "failed to decline credential")
+
+		return
+	}
+
+	o.removeWACIIssuance(req.ThreadID)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// declineOffer rejects an offer the wallet cannot show to the user.
+func (o *Operation) declineOffer(thID string, reason error) {
+	err := o.issueCredentialClient.DeclineOffer(thID, reason.Error())
+	if err != nil {
+		logger.Warnf("failed to decline credential offer: %s", err)
+	}
+}
+
+// userWACIIssuance returns the WACI issuance of a thread, or writes a not found error if the thread has no issuance
+// offered to the user.
+func (o *Operation) userWACIIssuance(w http.ResponseWriter, thID, userID string) (*waciIssuance, bool) {
+	raw, err := o.waciIssuanceStore.Get(thID)
+	if err != nil && !errors.Is(err, storage.ErrDataNotFound) {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "failed to get WACI issuance: %s",
+			err.Error())
+
+		return nil, false
+	}
+
+	issuance := &waciIssuance{}
+
+	if err == nil {
+		err = json.Unmarshal(raw, issuance)
+		if err != nil {
+			common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "invalid WACI issuance: %s",
+				err.Error())
+
+			return nil, false
+		}
+	}
+
+	// an issuance of another user is reported as unknown, so as not to disclose the threads of other users.
+	if issuance.UserID == "" || issuance.UserID != userID {
+		common.WriteErrorResponsef(w, logger, http.StatusNotFound, "no credential offer for thread [%s]", thID)
+
+		return nil, false
+	}
+
+	return issuance, true
+}
+
+// removeWACIIssuance removes the WACI issuance of a thread once its offer is declined, or once its credential is
+// accepted or declined or the problem report of the issuer accepted.
+func (o *Operation) removeWACIIssuance(thID string) {
+	err := o.waciIssuanceStore.Delete(thID)
+	if err != nil {
+		logger.Warnf("failed to remove WACI issuance %s: %s", thID, err.Error())
+	}
+}
+
+// waitForIssuance waits for the issue credential or problem report action of a thread.
+func (o *Operation) waitForIssuance(thID string, timeout time.Duration) (*issuecredential.Action, error) {
+	if timeout == 0 {
+		timeout = defaultWACITimeout
+	}
+
+	deadline := time.Now().Add(timeout)
+
+	for {
+		actions, err := o.issueCredentialClient.Actions()
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range actions {
+			if actions[i].PIID != thID {
+				continue
+			}
+
+			switch actions[i].Msg.Type() {
+			case issuecredentialsvc.IssueCredentialMsgTypeV2, issuecredentialsvc.IssueCredentialMsgTypeV3,
+				issuecredentialsvc.ProblemReportMsgTypeV2, issuecredentialsvc.ProblemReportMsgTypeV3:
+				return &actions[i], nil
+			}
+		}
+
+		if time.Now().After(deadline) {
+			return nil, errors.New("timeout waiting for issue credential message")
+		}
+
+		select {
+		case <-time.After(actionPollInterval):
+		case <-o.done:
+			return nil, errors.New("wallet operations closed")
+		}
+	}
+}
+
+// declineLateIssuance declines the credential of a thread the user stopped waiting for, or accepts the problem report
+// of the issuer, once received. The issuance is removed then, or if nothing is received within the WACI timeout.
+func (o *Operation) declineLateIssuance(thID string) {
+	defer o.removeWACIIssuance(thID)
+
+	action, err := o.waitForIssuance(thID, defaultWACITimeout)
+	if err != nil {
+		logger.Warnf("no credential received for thread %s: %s", thID, err.Error())
+
+		return
+	}
+
+	switch action.Msg.Type() {
+	case issuecredentialsvc.ProblemReportMsgTypeV2, issuecredentialsvc.ProblemReportMsgTypeV3:
+		err = o.issueCredentialClient.AcceptProblemReport(action.PIID)
+	default:
+		err = o.issueCredentialClient.DeclineCredential(action.PIID, "timeout waiting for issue credential message")
+	}
+
+	if err != nil {
+		logger.Warnf("failed to conclude issuance of thread %s: %s", thID, err.Error())
+	}
+}
+
+// saveIssuedCredentials verifies and saves in the wallet the credentials attached to an issue credential message,
+// or attached in the credential fulfillment of the message. Returns the IDs of the saved credentials.
+func (o *Operation) saveIssuedCredentials(vcWallet *wallet.Wallet, userID, auth string, msg service.DIDCommMsgMap,
+	origin *credentialOrigin, renews string) ([]string, error) {
+	issued := &issuecredentialsvc.IssueCredentialParams{}
+
+	err := issued.FromDIDCommMsgMap(msg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid issue credential message: %w", err)
+	}
+
+	var raws []json.RawMessage
+
+	for i := range issued.Attachments {
+		attachment := &issued.Attachments[i]
+
+		raw, err := attachment.Data.Fetch()
+		if err != nil {
+			return nil, fmt.Errorf("attachment %d: %w", i, err)
+		}
+
+		if attachmentFormat(attachment, issued.Formats) != fulfillmentFormat {
+			raws = append(raws, raw)
+
+			continue
+		}
+
+		credentials, err := o.fulfilledCredentials(raw)
+		if err != nil {
+			return nil, fmt.Errorf("attachment %d: %w", i, err)
+		}
+
+		raws = append(raws, credentials...)
+	}
+
+	if len(raws) == 0 {
+		return nil, errors.New("no credentials were issued")
+	}
+
+	return o.saveCredentials(vcWallet, userID, auth, raws, origin, renews)
+}
+
+// saveCredentials verifies credentials and saves them in the wallet of a user if they are all valid, tracking their
+// statuses and expiries. A credential issued with the ID of the credential it renews replaces it. Returns the IDs of
+// the saved credentials.
+func (o *Operation) saveCredentials(vcWallet *wallet.Wallet, userID, auth string, raws []json.RawMessage,
+	origin *credentialOrigin, renews string) ([]string, error) {
+	ids := make([]string, len(raws))
+
+	for i, raw := range raws {
+		err := o.verifyProof(vcWallet, auth, raw)
+		if err != nil {
+			return nil, fmt.Errorf("credential %d: %w", i, err)
+		}
+
+		vc, err := verifiable.ParseCredential(raw, verifiable.WithDisabledProofCheck(),
+			verifiable.WithNoCustomSchemaCheck(), verifiable.WithJSONLDDocumentLoader(o.ctx.JSONLDDocumentLoader()))
+		if err != nil {
+			return nil, fmt.Errorf("credential %d: %w", i, err)
+		}
+
+		ids[i] = vc.ID
+	}
+
+	for i, raw := range raws {
+		if renews != "" && ids[i] == renews {
+			err := vcWallet.Remove(auth, wallet.Credential, renews)
+			if err != nil && !errors.Is(err, storage.ErrDataNotFound) {
+				return nil, err
+			}
+		}
+
+		err := vcWallet.Add(auth, wallet.Credential, raw)
+		if err != nil {
+			return nil, err
+		}
+
+		o.trackCredential(userID, raw, origin)
+	}
+
+	return ids, nil
+}
+
+// fulfilledCredentials returns the credentials of a credential fulfillment.
+func (o *Operation) fulfilledCredentials(raw json.RawMessage) ([]json.RawMessage, error) {
+	vp, err := verifiable.ParsePresentation(raw,
+		verifiable.WithPresPublicKeyFetcher(verifiable.NewVDRKeyResolver(o.ctx.VDRegistry()).PublicKeyFetcher()),
+		verifiable.WithPresJSONLDDocumentLoader(o.ctx.JSONLDDocumentLoader()))
+	if err != nil {
+		return nil, fmt.Errorf("invalid credential fulfillment: %w", err)
+	}
+
+	credentials := make([]json.RawMessage, len(vp.Credentials()))
+
+	for i, credential := range vp.Credentials() {
+		credentials[i], err = json.Marshal(credential)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return credentials, nil
+}
+
+// offeredManifest returns the credential manifest and the fulfillment preview attached to a credential offer.
+func offeredManifest(msg service.DIDCommMsgMap) (*proposeCredentialResp, error) {
+	offer := &issuecredentialsvc.OfferCredentialParams{}
+
+	err := offer.FromDIDCommMsgMap(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &proposeCredentialResp{}
+
+	for i := range offer.Attachments {
+		attachment := &offer.Attachments[i]
+
+		switch attachmentFormat(attachment, offer.Formats) {
+		case manifestFormat:
+			raw, err := attachment.Data.Fetch()
+			if err != nil {
+				return nil, fmt.Errorf("credential manifest: %w", err)
+			}
+
+			wrapper := &struct {
+				Manifest json.RawMessage `json:"credential_manifest"`
+			}{}
+
+			err = json.Unmarshal(raw, wrapper)
+			if err != nil {
+				return nil, fmt.Errorf("credential manifest: %w", err)
+			}
+
+			resp.Manifest = wrapper.Manifest
+		case fulfillmentFormat:
+			resp.Fulfillment, err = attachment.Data.Fetch()
+			if err != nil {
+				return nil, fmt.Errorf("credential fulfillment: %w", err)
+			}
+		}
+	}
+
+	if len(resp.Manifest) == 0 {
+		return nil, errors.New("missing credential manifest")
+	}
+
+	return resp, nil
+}
+
+// attachmentFormat returns the format of an attachment, given by the attachment itself in DIDComm V2 messages and by
+// the formats of the message in DIDComm V1 messages.
+func attachmentFormat(attachment *decorator.GenericAttachment, formats []issuecredentialsvc.Format) string {
+	if attachment.Format != "" {
+		return attachment.Format
+	}
+
+	for _, format := range formats {
+		if format.AttachID == attachment.ID {
+			return format.Format
+		}
+	}
+
+	return ""
+}
+
+// protocolInstanceID returns the ID of the issue credential protocol instance of a message. Like the issue credential
+// service, it identifies the flows started by DIDComm V2 invitations by the invitation.
+func protocolInstanceID(msg service.DIDCommMsgMap) (string, error) {
+	if pthID := msg.ParentThreadID(); pthID != "" {
+		return pthID, nil
+	}
+
+	return msg.ThreadID()
+}
+
+// webRedirect returns the web redirect decorator of a DIDComm V1 or V2 message.
+func webRedirect(msg service.DIDCommMsgMap) *decorator.WebRedirect {
+	decorated := &struct {
+		V1 *decorator.WebRedirect `json:"~web-redirect"`
+		V2 *decorator.WebRedirect `json:"web_redirect"`
+	}{}
+
+	err := msg.Decode(decorated)
+	if err != nil || decorated.V1 == nil {
+		return decorated.V2
+	}
+
+	return decorated.V1
+}
@@ -0,0 +1,548 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation // nolint:testpackage // changing to different package requires exposing internal features.
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/hyperledger/aries-framework-go/component/storageutil/mem"
+	issuecredentialclient "github.com/hyperledger/aries-framework-go/pkg/client/issuecredential"
+	"github.com/hyperledger/aries-framework-go/pkg/client/outofband"
+	"github.com/hyperledger/aries-framework-go/pkg/client/outofbandv2"
+	"github.com/hyperledger/aries-framework-go/pkg/common/model"
+	"github.com/hyperledger/aries-framework-go/pkg/crypto"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/common/service"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/protocol/decorator"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/protocol/didexchange"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/protocol/issuecredential"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/transport"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/cm"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/did"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/verifiable"
+	"github.com/hyperledger/aries-framework-go/pkg/framework/aries"
+	vdrapi "github.com/hyperledger/aries-framework-go/pkg/framework/aries/api/vdr"
+	"github.com/hyperledger/aries-framework-go/pkg/framework/aries/defaults"
+	"github.com/hyperledger/aries-framework-go/pkg/framework/context"
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+	mockvdr "github.com/hyperledger/aries-framework-go/pkg/mock/vdr"
+	"github.com/hyperledger/aries-framework-go/pkg/wallet"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/wallet/pkg/restapi/internal/mocks"
+)
+
+const (
+	sampleManifest = `{
+		"id": "university_degree",
+		"version": "0.1.0",
+		"issuer": {"id": "did:example:76e12ec712ebc6f1c221ebfeb1f", "name": "Example University"},
+		"output_descriptors": [{
+			"id": "bachelors_degree",
+			"schema": "https://example.com/vocab#UniversityDegreeCredential",
+			"display": {
+				"title": {"path": ["$.credentialSubject.degree.name"], "schema": {"type": "string"}},
+				"description": {"text": "Awarded for completing a four year program at Example University."}
+			}
+		}]
+	}`
+	sampleRedirectURL = "https://issuer.example.com/waci-issuance"
+)
+
+func TestOperation_WACIIssuance(t *testing.T) {
+	for _, version := range []service.Version{service.V1, service.V2} {
+		t.Run(fmt.Sprintf("issue a credential over DIDComm %s", version), func(t *testing.T) {
+			router := newWACIHolder(t)
+			auth := unlock(t, router)
+			issuer := newWACIIssuer(t, issueDegreeCredential(t, router, auth))
+
+			offer := proposeCredential(t, router, auth, issuer.invitation(t, version))
+			require.Len(t, offer.Descriptors, 1)
+			require.Equal(t, "Bachelor of Science and Arts", offer.Descriptors[0].Title)
+
+			rr := serve(router, http.MethodPost, requestCredentialPath, &requestCredentialReq{
+				walletAuth: auth,
+				ThreadID:   offer.ThreadID,
+			})
+			require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+			resp := &requestCredentialResp{}
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), resp))
+			require.Equal(t, "OK", resp.Status)
+			require.Equal(t, sampleRedirectURL, resp.RedirectURL)
+			require.Equal(t, []string{issuer.credential.ID}, resp.CredentialIDs)
+
+			rr = serve(router, http.MethodPost, getPath, &getContentReq{
+				contentAuth: contentAuth{walletAuth: auth, ContentType: wallet.Credential},
+				ContentID:   issuer.credential.ID,
+			})
+			require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		})
+	}
+
+	t.Run("decline a credential offer", func(t *testing.T) {
+		router := newWACIHolder(t)
+		auth := unlock(t, router)
+		issuer := newWACIIssuer(t, issueDegreeCredential(t, router, auth))
+
+		offer := proposeCredential(t, router, auth, issuer.invitation(t, service.V1))
+
+		rr := serve(router, http.MethodPost, declineCredentialPath, &declineCredentialReq{
+			walletAuth: auth,
+			ThreadID:   offer.ThreadID,
+			Reason:     "not interested",
+		})
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		rr = serve(router, http.MethodPost, requestCredentialPath, &requestCredentialReq{
+			walletAuth: auth,
+			ThreadID:   offer.ThreadID,
+		})
+		require.Equal(t, http.StatusNotFound, rr.Code, rr.Body.String())
+	})
+
+	t.Run("offers of other users are not found", func(t *testing.T) {
+		router := newWACIHolder(t)
+		auth := unlock(t, router)
+		other := unlock(t, router)
+		issuer := newWACIIssuer(t, issueDegreeCredential(t, router, auth))
+
+		offer := proposeCredential(t, router, auth, issuer.invitation(t, service.V1))
+
+		rr := serve(router, http.MethodPost, requestCredentialPath, &requestCredentialReq{
+			walletAuth: other,
+			ThreadID:   offer.ThreadID,
+		})
+		require.Equal(t, http.StatusNotFound, rr.Code, rr.Body.String())
+		requireErrorResponse(t, rr.Body.Bytes(), "no credential offer for thread")
+
+		rr = serve(router, http.MethodPost, declineCredentialPath, &declineCredentialReq{
+			walletAuth: other,
+			ThreadID:   offer.ThreadID,
+		})
+		require.Equal(t, http.StatusNotFound, rr.Code, rr.Body.String())
+
+		rr = serve(router, http.MethodPost, requestCredentialPath, &requestCredentialReq{
+			walletAuth: auth,
+			ThreadID:   offer.ThreadID,
+		})
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	})
+
+	t.Run("report an issuer failing to issue the credential", func(t *testing.T) {
+		router := newWACIHolder(t)
+		auth := unlock(t, router)
+		issuer := newWACIIssuer(t, issueDegreeCredential(t, router, auth))
+		issuer.fail = true
+
+		offer := proposeCredential(t, router, auth, issuer.invitation(t, service.V1))
+
+		rr := serve(router, http.MethodPost, requestCredentialPath, &requestCredentialReq{
+			walletAuth: auth,
+			ThreadID:   offer.ThreadID,
+		})
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		resp := &requestCredentialResp{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), resp))
+		require.Equal(t, "FAIL", resp.Status)
+		require.Equal(t, sampleRedirectURL, resp.RedirectURL)
+		require.Empty(t, resp.CredentialIDs)
+	})
+
+	t.Run("decline a credential received after the timeout", func(t *testing.T) {
+		router := newWACIHolder(t)
+		auth := unlock(t, router)
+		issuer := newWACIIssuer(t, issueDegreeCredential(t, router, auth))
+		issuer.delay = time.Second
+
+		offer := proposeCredential(t, router, auth, issuer.invitation(t, service.V1))
+
+		req := &requestCredentialReq{walletAuth: auth, ThreadID: offer.ThreadID, Timeout: 100 * time.Millisecond}
+
+		rr := serve(router, http.MethodPost, requestCredentialPath, req)
+		require.Equal(t, http.StatusGatewayTimeout, rr.Code, rr.Body.String())
+
+		rr = serve(router, http.MethodPost, requestCredentialPath, req)
+		require.Equal(t, http.StatusConflict, rr.Code, rr.Body.String())
+
+		rr = serve(router, http.MethodPost, declineCredentialPath, &declineCredentialReq{
+			walletAuth: auth,
+			ThreadID:   offer.ThreadID,
+		})
+		require.Equal(t, http.StatusConflict, rr.Code, rr.Body.String())
+
+		require.Eventually(t, func() bool {
+			return serve(router, http.MethodPost, requestCredentialPath, req).Code == http.StatusNotFound
+		}, 10*time.Second, 100*time.Millisecond)
+
+		rr = serve(router, http.MethodPost, getPath, &getContentReq{
+			contentAuth: contentAuth{walletAuth: auth, ContentType: wallet.Credential},
+			ContentID:   issuer.credential.ID,
+		})
+		require.Equal(t, http.StatusNotFound, rr.Code, rr.Body.String())
+	})
+
+	t.Run("invalid requests", func(t *testing.T) {
+		router := newWACIHolder(t)
+		auth := unlock(t, router)
+
+		for _, path := range []string{proposeCredentialPath, requestCredentialPath, declineCredentialPath} {
+			rr := serve(router, http.MethodPost, path, "invalid")
+			require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+
+			rr = serve(router, http.MethodPost, path, &walletAuth{UserID: auth.UserID})
+			require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+			requireErrorResponse(t, rr.Body.Bytes(), "missing user ID or auth token")
+		}
+
+		rr := serve(router, http.MethodPost, proposeCredentialPath, &proposeCredentialReq{walletAuth: auth})
+		require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+		requireErrorResponse(t, rr.Body.Bytes(), "missing invitation")
+
+		rr = serve(router, http.MethodPost, requestCredentialPath, &requestCredentialReq{walletAuth: auth})
+		require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+		requireErrorResponse(t, rr.Body.Bytes(), "missing thread ID")
+
+		rr = serve(router, http.MethodPost, declineCredentialPath, &declineCredentialReq{walletAuth: auth})
+		require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+		requireErrorResponse(t, rr.Body.Bytes(), "missing thread ID")
+
+		rr = serve(router, http.MethodPost, requestCredentialPath, &requestCredentialReq{
+			walletAuth: walletAuth{UserID: auth.UserID, Auth: "invalid"},
+			ThreadID:   uuid.New().String(),
+		})
+		require.Equal(t, http.StatusUnauthorized, rr.Code, rr.Body.String())
+
+		rr = serve(router, http.MethodPost, proposeCredentialPath, &proposeCredentialReq{
+			walletAuth: walletAuth{UserID: uuid.New().String(), Auth: auth.Auth},
+			Invitation: &wallet.GenericInvitation{},
+		})
+		require.Equal(t, http.StatusNotFound, rr.Code, rr.Body.String())
+
+		rr = serve(router, http.MethodPost, declineCredentialPath, &declineCredentialReq{
+			walletAuth: auth,
+			ThreadID:   uuid.New().String(),
+		})
+		require.Equal(t, http.StatusNotFound, rr.Code, rr.Body.String())
+	})
+
+	t.Run("offers without credential manifest", func(t *testing.T) {
+		_, err := offeredManifest(service.NewDIDCommMsgMap(&issuecredential.OfferCredentialV2{
+			Type: issuecredential.OfferCredentialMsgTypeV2,
+		}))
+		require.EqualError(t, err, "missing credential manifest")
+	})
+}
+
+// newWACIHolder returns the router of the wallet operations of a new agent.
+func newWACIHolder(t *testing.T) *mux.Router {
+	t.Helper()
+
+	op, err := New(newAgent(t), mocks.NewMockNotifier(), nil)
+	require.NoError(t, err)
+
+	return routerOf(op)
+}
+
+func issueDegreeCredential(t *testing.T, router *mux.Router, auth walletAuth) *verifiable.Credential {
+	t.Helper()
+
+	raw := issueCredential(t, router, auth, addEd25519Key(t, router, auth), wallet.Ed25519Signature2018,
+		fmt.Sprintf(sampleDegreeCredential, "http://example.gov/credentials/"+uuid.New().String()))
+
+	vc, err := verifiable.ParseCredential(raw, verifiable.WithDisabledProofCheck(),
+		verifiable.WithJSONLDDocumentLoader(newProvider(t).JSONLDDocumentLoader()))
+	require.NoError(t, err)
+
+	return vc
+}
+
+func proposeCredential(t *testing.T, router *mux.Router, auth walletAuth,
+	invitation *wallet.GenericInvitation) *proposeCredentialResp {
+	t.Helper()
+
+	rr := serve(router, http.MethodPost, proposeCredentialPath, &proposeCredentialReq{
+		walletAuth: auth,
+		Invitation: invitation,
+		Timeout:    10 * time.Second,
+	})
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	offer := &proposeCredentialResp{}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), offer))
+	require.NotEmpty(t, offer.ThreadID)
+
+	manifest := &cm.CredentialManifest{}
+	require.NoError(t, manifest.UnmarshalJSON(offer.Manifest))
+	require.Equal(t, "university_degree", manifest.ID)
+
+	return offer
+}
+
+// waciIssuer is an agent issuing a credential like the WACI issuer of the mock adapter.
+type waciIssuer struct {
+	ctx        *context.Provider
+	client     *issuecredentialclient.Client
+	manifest   *cm.CredentialManifest
+	credential *verifiable.Credential
+	fail       bool
+	delay      time.Duration
+}
+
+func newWACIIssuer(t *testing.T, credential *verifiable.Credential) *waciIssuer {
+	t.Helper()
+
+	issuer := &waciIssuer{ctx: newAgent(t), manifest: &cm.CredentialManifest{}, credential: credential}
+	require.NoError(t, issuer.manifest.UnmarshalJSON([]byte(sampleManifest)))
+
+	var err error
+
+	issuer.client, err = issuecredentialclient.New(issuer.ctx)
+	require.NoError(t, err)
+
+	for name, handle := range map[string]func(service.DIDCommAction){
+		didexchange.DIDExchange: func(action service.DIDCommAction) { action.Continue(nil) },
+		issuecredential.Name:    issuer.issue,
+	} {
+		svc, err := issuer.ctx.Service(name)
+		require.NoError(t, err)
+
+		actions := make(chan service.DIDCommAction)
+		require.NoError(t, svc.(service.Event).RegisterActionEvent(actions))
+
+		go func(handle func(service.DIDCommAction)) {
+			for action := range actions {
+				handle(action)
+			}
+		}(handle)
+	}
+
+	return issuer
+}
+
+// invitation returns a WACI issuance invitation of the issuer.
+func (i *waciIssuer) invitation(t *testing.T, version service.Version) *wallet.GenericInvitation {
+	t.Helper()
+
+	return newInvitation(t, i.ctx, version, "issue-vc", "streamlined-vc")
+}
+
+// newInvitation returns an out-of-band invitation of an agent, with a DIDComm V2 public DID for V2 invitations.
+func newInvitation(t *testing.T, ctx *context.Provider, version service.Version,
+	goal, goalCode string) *wallet.GenericInvitation {
+	t.Helper()
+
+	var invitation interface{}
+
+	if version == service.V1 {
+		client, err := outofband.New(ctx)
+		require.NoError(t, err)
+
+		invitation, err = client.CreateInvitation(nil, outofband.WithGoal(goal, goalCode),
+			outofband.WithAccept(transport.MediaTypeAIP2RFC0019Profile, transport.MediaTypeProfileDIDCommAIP1))
+		require.NoError(t, err)
+	} else {
+		client, err := outofbandv2.New(ctx)
+		require.NoError(t, err)
+
+		invitation, err = client.CreateInvitation(outofbandv2.WithFrom(publicDID(t, ctx)),
+			outofbandv2.WithGoal(goal, goalCode),
+			outofbandv2.WithAccept(transport.MediaTypeDIDCommV2Profile))
+		require.NoError(t, err)
+	}
+
+	raw, err := json.Marshal(invitation)
+	require.NoError(t, err)
+
+	generic := &wallet.GenericInvitation{}
+	require.NoError(t, json.Unmarshal(raw, generic))
+
+	return generic
+}
+
+// publicDID publishes a DIDComm V2 DID of an agent on the public DIDs of the test agents.
+func publicDID(t *testing.T, ctx *context.Provider) string {
+	t.Helper()
+
+	_, authKey, err := ctx.KMS().CreateAndExportPubKeyBytes(kms.ED25519Type)
+	require.NoError(t, err)
+
+	_, agreementKey, err := ctx.KMS().CreateAndExportPubKeyBytes(kms.X25519ECDHKWType)
+	require.NoError(t, err)
+
+	x25519 := &crypto.PublicKey{}
+	require.NoError(t, json.Unmarshal(agreementKey, x25519))
+
+	id := "did:" + publicDIDMethod + ":" + uuid.New().String()
+
+	authVM := did.NewVerificationMethodFromBytes(id+"#key-1", "Ed25519VerificationKey2018", id, authKey)
+	agreementVM := did.NewVerificationMethodFromBytes(id+"#key-2", "X25519KeyAgreementKey2019", id, x25519.X)
+
+	doc := &did.Doc{
+		Context:            []string{did.ContextV1},
+		ID:                 id,
+		VerificationMethod: []did.VerificationMethod{*authVM},
+		Authentication:     []did.Verification{*did.NewReferencedVerification(authVM, did.Authentication)},
+		KeyAgreement:       []did.Verification{*did.NewReferencedVerification(agreementVM, did.KeyAgreement)},
+		Service: []did.Service{{
+			ID:              id + "#didcomm",
+			Type:            vdrapi.DIDCommV2ServiceType,
+			ServiceEndpoint: model.NewDIDCommV2Endpoint([]model.DIDCommV2Endpoint{{URI: ctx.ServiceEndpoint()}}),
+		}},
+	}
+
+	publicDIDs.Store(id, doc)
+
+	return id
+}
+
+// issue offers the credential when the holder proposes it, and issues it when the holder requests it.
+func (i *waciIssuer) issue(action service.DIDCommAction) {
+	fulfillment, err := cm.PresentCredentialFulfillment(i.manifest)
+	if err != nil {
+		action.Stop(err)
+
+		return
+	}
+
+	fulfillment.AddCredentials(i.credential)
+
+	redirect := &decorator.WebRedirect{Status: "OK", URL: sampleRedirectURL}
+
+	offerType, issueType := issuecredential.OfferCredentialMsgTypeV2, issuecredential.IssueCredentialMsgTypeV2
+
+	switch action.Message.Type() {
+	case issuecredential.ProposeCredentialMsgTypeV3, issuecredential.RequestCredentialMsgTypeV3:
+		offerType, issueType = issuecredential.OfferCredentialMsgTypeV3, issuecredential.IssueCredentialMsgTypeV3
+	}
+
+	switch action.Message.Type() {
+	case issuecredential.ProposeCredentialMsgTypeV2, issuecredential.ProposeCredentialMsgTypeV3:
+		action.Continue(issuecredential.WithOfferCredential(&issuecredential.OfferCredentialParams{
+			Type: offerType,
+			Formats: []issuecredential.Format{
+				{AttachID: "manifest", Format: manifestFormat},
+				{AttachID: "fulfillment", Format: fulfillmentFormat},
+			},
+			Attachments: []decorator.GenericAttachment{{
+				ID:        "manifest",
+				MediaType: "application/json",
+				Format:    manifestFormat,
+				Data: decorator.AttachmentData{JSON: map[string]interface{}{
+					"credential_manifest": i.manifest,
+				}},
+			}, {
+				ID:        "fulfillment",
+				MediaType: "application/json",
+				Format:    fulfillmentFormat,
+				Data:      decorator.AttachmentData{JSON: fulfillment},
+			}},
+		}))
+	case issuecredential.RequestCredentialMsgTypeV2, issuecredential.RequestCredentialMsgTypeV3:
+		time.Sleep(i.delay)
+
+		if i.fail {
+			thID, err := action.Message.ThreadID()
+			if err != nil {
+				action.Stop(err)
+
+				return
+			}
+
+			err = i.client.DeclineRequest(thID, "failed to issue credential",
+				issuecredentialclient.RequestRedirect(sampleRedirectURL))
+			if err != nil {
+				action.Stop(err)
+			}
+
+			return
+		}
+
+		action.Continue(issuecredential.WithIssueCredential(&issuecredential.IssueCredentialParams{
+			Type:    issueType,
+			Formats: []issuecredential.Format{{AttachID: "fulfillment", Format: fulfillmentFormat}},
+			Attachments: []decorator.GenericAttachment{{
+				ID:        "fulfillment",
+				MediaType: "application/ld+json",
+				Format:    fulfillmentFormat,
+				Data:      decorator.AttachmentData{JSON: fulfillment},
+			}},
+			WebRedirect: redirect,
+		}))
+	default:
+		action.Continue(nil)
+	}
+}
+
+// publicDIDMethod is the method of the DIDs published on publicDIDs.
+const publicDIDMethod = "waci"
+
+// publicDIDs are the DID documents resolved by the test agents with the publicDIDMethod.
+// nolint:gochecknoglobals
+var publicDIDs sync.Map
+
+// publicVDR resolves the DIDs published on publicDIDs.
+type publicVDR struct {
+	mockvdr.MockVDR
+}
+
+func (v *publicVDR) Accept(method string) bool {
+	return method == publicDIDMethod
+}
+
+func (v *publicVDR) Read(id string, _ ...vdrapi.DIDMethodOption) (*did.DocResolution, error) {
+	doc, ok := publicDIDs.Load(id)
+	if !ok {
+		return nil, vdrapi.ErrNotFound
+	}
+
+	return &did.DocResolution{DIDDocument: doc.(*did.Doc)}, nil
+}
+
+// newAgent returns the context of a new agent, receiving DIDComm messages over HTTP on a free local port.
+func newAgent(t *testing.T) *context.Provider {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	addr := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	framework, err := aries.New(aries.WithStoreProvider(mem.NewProvider()),
+		aries.WithProtocolStateStoreProvider(mem.NewProvider()),
+		defaults.WithInboundHTTPAddr(addr, "http://"+addr, "", ""),
+		aries.WithVDR(&publicVDR{}),
+		aries.WithMediaTypeProfiles([]string{
+			transport.MediaTypeDIDCommV2Profile, transport.MediaTypeAIP2RFC0587Profile,
+			transport.MediaTypeAIP2RFC0019Profile, transport.MediaTypeProfileDIDCommAIP1,
+		}))
+	require.NoError(t, err)
+
+	t.Cleanup(func() { require.NoError(t, framework.Close()) })
+
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			return false
+		}
+
+		return conn.Close() == nil
+	}, time.Second, 10*time.Millisecond)
+
+	ctx, err := framework.Context()
+	require.NoError(t, err)
+
+	return ctx
+}
@@ -0,0 +1,416 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package status checks the status of credentials against the StatusList2021 and RevocationList2020 status lists
+// published by their issuers.
+package status
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/pkg/doc/verifiable"
+	vdrapi "github.com/hyperledger/aries-framework-go/pkg/framework/aries/api/vdr"
+	"github.com/piprate/json-gold/ld"
+	"github.com/trustbloc/edge-core/pkg/log"
+
+	"github.com/trustbloc/wallet/pkg/restapi/common"
+)
+
+// credential status types supported.
+const (
+	StatusList2021Entry      = "StatusList2021Entry"
+	RevocationList2020Status = "RevocationList2020Status"
+)
+
+// purposes of status list entries.
+const (
+	PurposeRevocation = "revocation"
+	PurposeSuspension = "suspension"
+)
+
+// statuses of credentials.
+const (
+	Active    = "active"
+	Revoked   = "revoked"
+	Suspended = "suspended"
+)
+
+// DefaultCacheTTL is how long fetched status list credentials are used before they are fetched again by default.
+const DefaultCacheTTL = 5 * time.Minute
+
+const (
+	// maxListCredentialSize is the maximum size of a status list credential, and maxListSize the maximum size of its
+	// decoded list: 16 MiB, 128 times the minimum size of a StatusList2021.
+	maxListCredentialSize = 1 << 20
+	maxListSize           = 16 << 20
+
+	// maxCachedLists is the maximum number of status lists cached by a checker.
+	maxCachedLists = 1000
+)
+
+var logger = log.New("wallet/status")
+
+// ErrUnsupported is returned for credential statuses of unsupported types.
+var ErrUnsupported = errors.New("unsupported credential status type")
+
+// Entry is the status of a credential: the bit of a status list credential of the issuer giving the status of the
+// credential for a purpose.
+type Entry struct {
+	Type           string `json:"type"`
+	Purpose        string `json:"purpose"`
+	ListCredential string `json:"listCredential"`
+	Index          int    `json:"index"`
+}
+
+// ParseEntry returns the status entry of the credentialStatus of a credential.
+func ParseEntry(status *verifiable.TypedID) (*Entry, error) {
+	entry := &Entry{Type: status.Type}
+
+	var index interface{}
+
+	switch status.Type {
+	case StatusList2021Entry:
+		entry.Purpose, _ = status.CustomFields["statusPurpose"].(string)               // nolint:errcheck
+		entry.ListCredential, _ = status.CustomFields["statusListCredential"].(string) // nolint:errcheck
+		index = status.CustomFields["statusListIndex"]
+	case RevocationList2020Status:
+		entry.Purpose = PurposeRevocation
+		entry.ListCredential, _ = status.CustomFields["revocationListCredential"].(string) // nolint:errcheck
+		index = status.CustomFields["revocationListIndex"]
+	default:
+		return nil, fmt.Errorf("%w [%s]", ErrUnsupported, status.Type)
+	}
+
+	if entry.Purpose != PurposeRevocation && entry.Purpose != PurposeSuspension {
+		return nil, fmt.Errorf("unsupported status purpose [%s]", entry.Purpose)
+	}
+
+	if entry.ListCredential == "" {
+		return nil, errors.New("missing status list credential")
+	}
+
+	var err error
+
+	switch i := index.(type) {
+	case string:
+		entry.Index, err = strconv.Atoi(i)
+	case float64:
+		entry.Index = int(i)
+	default:
+		err = errors.New("missing index")
+	}
+
+	if err != nil || entry.Index < 0 {
+		return nil, fmt.Errorf("invalid status list index: %v", index)
+	}
+
+	return entry, nil
+}
+
+// Checker checks the status of credentials. Status list credentials are fetched over HTTPS from public addresses
+// only, are verified, and are cached for a while after they are fetched.
+type Checker struct {
+	vdr        vdrapi.Registry
+	loader     ld.DocumentLoader
+	httpClient common.HTTPClient
+	cacheTTL   time.Duration
+	allowIP    func(net.IP) bool
+	lookupIP   func(ctx context.Context, host string) ([]net.IP, error)
+
+	mutex sync.Mutex
+	cache map[string]*cachedList
+}
+
+// Option configures a status checker.
+type Option func(c *Checker)
+
+// WithAddressFilter sets the filter of the IP addresses status list credentials are fetched from, instead of allowing
+// public addresses only.
+func WithAddressFilter(allow func(net.IP) bool) Option {
+	return func(c *Checker) {
+		c.allowIP = allow
+	}
+}
+
+type cachedList struct {
+	issuer    string
+	purpose   string
+	bits      []byte
+	expiresAt time.Time
+}
+
+// NewChecker returns a new status checker, resolving the keys of the issuers of status list credentials with the VDR.
+func NewChecker(vdr vdrapi.Registry, loader ld.DocumentLoader, httpClient common.HTTPClient,
+	cacheTTL time.Duration, opts ...Option) *Checker {
+	c := &Checker{
+		vdr:      vdr,
+		loader:   loader,
+		cacheTTL: cacheTTL,
+		allowIP:  common.IsPublic,
+		lookupIP: func(ctx context.Context, host string) ([]net.IP, error) {
+			return net.DefaultResolver.LookupIP(ctx, "ip", host)
+		},
+		cache: map[string]*cachedList{},
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	c.httpClient = httpClient
+
+	if client, ok := httpClient.(*http.Client); ok && client != nil {
+		c.httpClient = common.GuardDial(client, c.allowIP)
+	}
+
+	return c
+}
+
+// Check returns the status of a credential of an issuer: Active, or Revoked or Suspended depending on the purpose of
+// its entry in the status list credential of the issuer.
+func (c *Checker) Check(entry *Entry, issuer string) (string, error) {
+	list, err := c.list(entry.ListCredential)
+	if err != nil {
+		return "", err
+	}
+
+	if list.issuer != issuer {
+		return "", fmt.Errorf("status list credential issued by %s, not by the credential issuer %s", list.issuer,
+			issuer)
+	}
+
+	if list.purpose != "" && list.purpose != entry.Purpose {
+		return "", fmt.Errorf("status list credential has purpose %s, not %s", list.purpose, entry.Purpose)
+	}
+
+	if entry.Index >= len(list.bits)*8 {
+		return "", fmt.Errorf("status list index %d is out of the list", entry.Index)
+	}
+
+	// bits are numbered from the most significant bit of the first byte of the list.
+	if list.bits[entry.Index/8]&(1<<(7-uint(entry.Index%8))) == 0 {
+		return Active, nil
+	}
+
+	if entry.Purpose == PurposeSuspension {
+		return Suspended, nil
+	}
+
+	return Revoked, nil
+}
+
+// list returns the cached status list at a URL, fetching it if it is not cached or expired.
+func (c *Checker) list(listURL string) (*cachedList, error) {
+	c.mutex.Lock()
+	list, ok := c.cache[listURL]
+	c.mutex.Unlock()
+
+	if ok && time.Now().Before(list.expiresAt) {
+		return list, nil
+	}
+
+	list, err := c.fetch(listURL)
+	if err != nil {
+		return nil, fmt.Errorf("status list credential %s: %w", listURL, err)
+	}
+
+	c.mutex.Lock()
+	c.cacheList(listURL, list)
+	c.mutex.Unlock()
+
+	return list, nil
+}
+
+// cacheList caches a status list, evicting the expired lists, or the list expiring first, if the cache is full.
+// Must be called with the mutex locked.
+func (c *Checker) cacheList(listURL string, list *cachedList) {
+	if _, ok := c.cache[listURL]; !ok && len(c.cache) >= maxCachedLists {
+		now := time.Now()
+
+		var first string
+
+		for u, cached := range c.cache {
+			if !now.Before(cached.expiresAt) {
+				delete(c.cache, u)
+
+				continue
+			}
+
+			if first == "" || cached.expiresAt.Before(c.cache[first].expiresAt) {
+				first = u
+			}
+		}
+
+		if len(c.cache) >= maxCachedLists {
+			delete(c.cache, first)
+		}
+	}
+
+	c.cache[listURL] = list
+}
+
+// fetch fetches and verifies the status list credential at a URL.
+func (c *Checker) fetch(listURL string) (*cachedList, error) {
+	err := c.checkURL(context.Background(), listURL)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, listURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", "application/ld+json, application/json")
+
+	raw, err := c.get(req)
+	if err != nil {
+		return nil, err
+	}
+
+	vc, err := verifiable.ParseCredential(raw, verifiable.WithNoCustomSchemaCheck(),
+		verifiable.WithPublicKeyFetcher(verifiable.NewVDRKeyResolver(c.vdr).PublicKeyFetcher()),
+		verifiable.WithJSONLDDocumentLoader(c.loader))
+	if err != nil {
+		return nil, fmt.Errorf("invalid credential: %w", err)
+	}
+
+	// JWT credentials are verified when parsed, JSON-LD credentials only if they have proofs.
+	if len(vc.Proofs) == 0 && bytes.HasPrefix(bytes.TrimSpace(raw), []byte("{")) {
+		return nil, errors.New("credential is not signed")
+	}
+
+	subjects, ok := vc.Subject.([]verifiable.Subject)
+	if !ok || len(subjects) != 1 {
+		return nil, errors.New("invalid credential subject")
+	}
+
+	list := &cachedList{issuer: vc.Issuer.ID, expiresAt: time.Now().Add(c.cacheTTL)}
+
+	list.purpose, _ = subjects[0].CustomFields["statusPurpose"].(string) // nolint:errcheck
+	encoded, _ := subjects[0].CustomFields["encodedList"].(string)       // nolint:errcheck
+
+	list.bits, err = decodeList(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// checkURL returns an error unless a URL is an HTTPS URL whose host resolves to allowed addresses only.
+func (c *Checker) checkURL(ctx context.Context, listURL string) error {
+	u, err := url.Parse(listURL)
+	if err != nil {
+		return fmt.Errorf("invalid URL: %w", err)
+	}
+
+	if u.Scheme != "https" || u.Hostname() == "" {
+		return errors.New("status list credentials are only fetched over HTTPS")
+	}
+
+	ips := []net.IP{net.ParseIP(u.Hostname())}
+
+	if ips[0] == nil {
+		ips, err = c.lookupIP(ctx, u.Hostname())
+		if err != nil {
+			return fmt.Errorf("failed to resolve %s: %w", u.Hostname(), err)
+		}
+	}
+
+	for _, ip := range ips {
+		if !c.allowIP(ip) {
+			return fmt.Errorf("address %s of %s is not allowed", ip, u.Hostname())
+		}
+	}
+
+	return nil
+}
+
+// get sends a request, and returns the body of its response, which must be OK and at most maxListCredentialSize
+// bytes.
+func (c *Checker) get(req *http.Request) ([]byte, error) {
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("http request : %w", err)
+	}
+
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			logger.Warnf("failed to close response body: %s", closeErr)
+		}
+	}()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxListCredentialSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("http request: failed to read resp body %d : %w", resp.StatusCode, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("http request: expected=%d actual=%d", http.StatusOK, resp.StatusCode)
+	}
+
+	if len(body) > maxListCredentialSize {
+		return nil, fmt.Errorf("credential is larger than %d bytes", maxListCredentialSize)
+	}
+
+	return body, nil
+}
+
+// decodeList returns the bits of a GZIP-compressed, base64 encoded status list.
+func decodeList(encoded string) ([]byte, error) {
+	if encoded == "" {
+		return nil, errors.New("missing encoded list")
+	}
+
+	var (
+		compressed []byte
+		err        error
+	)
+
+	// issuers encode lists with or without padding, with either base64 alphabet.
+	for _, encoding := range []*base64.Encoding{
+		base64.RawURLEncoding, base64.URLEncoding, base64.RawStdEncoding, base64.StdEncoding,
+	} {
+		compressed, err = encoding.DecodeString(encoded)
+		if err == nil {
+			break
+		}
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid encoded list: %w", err)
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, fmt.Errorf("invalid encoded list: %w", err)
+	}
+
+	bits, err := ioutil.ReadAll(io.LimitReader(reader, maxListSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("invalid encoded list: %w", err)
+	}
+
+	if len(bits) > maxListSize {
+		return nil, fmt.Errorf("decoded list is larger than %d bytes", maxListSize)
+	}
+
+	return bits, nil
+}
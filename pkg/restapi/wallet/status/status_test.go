@@ -0,0 +1,299 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package status // nolint:testpackage // changing to different package requires exposing internal features.
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/pkg/doc/ld"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/verifiable"
+	mockldstore "github.com/hyperledger/aries-framework-go/pkg/mock/ld"
+	vdrmock "github.com/hyperledger/aries-framework-go/pkg/mock/vdr"
+	ldstore "github.com/hyperledger/aries-framework-go/pkg/store/ld"
+	"github.com/stretchr/testify/require"
+)
+
+const unsignedList = `{
+	"@context": ["https://www.w3.org/2018/credentials/v1", "https://w3id.org/vc/status-list/2021/v1"],
+	"id": "https://example.com/status/1",
+	"type": ["VerifiableCredential", "StatusList2021Credential"],
+	"issuer": "did:example:issuer",
+	"issuanceDate": "2010-01-01T19:23:24Z",
+	"credentialSubject": {
+		"id": "https://example.com/status/1#list",
+		"type": "StatusList2021",
+		"statusPurpose": "revocation",
+		"encodedList": "H4sIAAAAAAAA_2IAAQAA__-N7wLSAQAAAA"
+	}
+}`
+
+func TestParseEntry(t *testing.T) {
+	t.Run("parse status list entries", func(t *testing.T) {
+		entry, err := ParseEntry(&verifiable.TypedID{Type: StatusList2021Entry, CustomFields: map[string]interface{}{
+			"statusPurpose":        PurposeSuspension,
+			"statusListIndex":      "94567",
+			"statusListCredential": "https://example.com/status/3",
+		}})
+		require.NoError(t, err)
+		require.Equal(t, &Entry{
+			Type: StatusList2021Entry, Purpose: PurposeSuspension, ListCredential: "https://example.com/status/3",
+			Index: 94567,
+		}, entry)
+
+		entry, err = ParseEntry(&verifiable.TypedID{Type: RevocationList2020Status, CustomFields: map[string]interface{}{
+			"revocationListIndex":      float64(12),
+			"revocationListCredential": "https://example.com/revocation/1",
+		}})
+		require.NoError(t, err)
+		require.Equal(t, &Entry{
+			Type: RevocationList2020Status, Purpose: PurposeRevocation,
+			ListCredential: "https://example.com/revocation/1", Index: 12,
+		}, entry)
+	})
+
+	t.Run("invalid entries", func(t *testing.T) {
+		_, err := ParseEntry(&verifiable.TypedID{Type: "CredentialStatusList2017"})
+		require.True(t, errors.Is(err, ErrUnsupported))
+
+		_, err = ParseEntry(&verifiable.TypedID{Type: StatusList2021Entry, CustomFields: map[string]interface{}{
+			"statusPurpose": "other",
+		}})
+		require.EqualError(t, err, "unsupported status purpose [other]")
+
+		_, err = ParseEntry(&verifiable.TypedID{Type: RevocationList2020Status})
+		require.EqualError(t, err, "missing status list credential")
+
+		_, err = ParseEntry(&verifiable.TypedID{Type: RevocationList2020Status, CustomFields: map[string]interface{}{
+			"revocationListIndex":      "-1",
+			"revocationListCredential": "https://example.com/revocation/1",
+		}})
+		require.EqualError(t, err, "invalid status list index: -1")
+	})
+}
+
+func TestChecker_Check(t *testing.T) {
+	t.Run("check the bits of cached lists", func(t *testing.T) {
+		checker := NewChecker(&vdrmock.MockVDRegistry{}, newDocumentLoader(t), nil, DefaultCacheTTL)
+		checker.cache["https://example.com/status/1"] = &cachedList{
+			issuer: "did:example:issuer", bits: []byte{0x00, 0x41}, expiresAt: time.Now().Add(time.Minute),
+		}
+
+		entry := &Entry{Purpose: PurposeSuspension, ListCredential: "https://example.com/status/1", Index: 9}
+
+		status, err := checker.Check(entry, "did:example:issuer")
+		require.NoError(t, err)
+		require.Equal(t, Suspended, status)
+
+		entry.Purpose = PurposeRevocation
+
+		status, err = checker.Check(entry, "did:example:issuer")
+		require.NoError(t, err)
+		require.Equal(t, Revoked, status)
+
+		entry.Index = 8
+
+		status, err = checker.Check(entry, "did:example:issuer")
+		require.NoError(t, err)
+		require.Equal(t, Active, status)
+
+		entry.Index = 16
+
+		_, err = checker.Check(entry, "did:example:issuer")
+		require.EqualError(t, err, "status list index 16 is out of the list")
+
+		_, err = checker.Check(entry, "did:example:other")
+		require.EqualError(t, err, "status list credential issued by did:example:issuer, not by the credential "+
+			"issuer did:example:other")
+	})
+
+	t.Run("reject unsigned lists", func(t *testing.T) {
+		checker := NewChecker(&vdrmock.MockVDRegistry{}, newDocumentLoader(t), &mockHTTPClient{
+			body: unsignedList,
+		}, DefaultCacheTTL)
+		checker.lookupIP = lookupIP("93.184.216.34")
+
+		_, err := checker.Check(&Entry{Purpose: PurposeRevocation, ListCredential: "https://example.com/status/1"},
+			"did:example:issuer")
+		require.EqualError(t, err, "status list credential https://example.com/status/1: credential is not signed")
+	})
+
+	t.Run("only fetch lists over HTTPS from public addresses", func(t *testing.T) {
+		checker := NewChecker(&vdrmock.MockVDRegistry{}, newDocumentLoader(t), &mockHTTPClient{
+			body: unsignedList,
+		}, DefaultCacheTTL)
+		checker.lookupIP = lookupIP("93.184.216.34", "10.0.0.1")
+
+		for listURL, msg := range map[string]string{
+			"http://example.com/status/1":     "status list credentials are only fetched over HTTPS",
+			"file:///etc/passwd":              "status list credentials are only fetched over HTTPS",
+			"https://127.0.0.1/status/1":      "address 127.0.0.1 of 127.0.0.1 is not allowed",
+			"https://169.254.169.254/latest":  "address 169.254.169.254 of 169.254.169.254 is not allowed",
+			"https://[::1]:8443/status/1":     "address ::1 of ::1 is not allowed",
+			"https://internal.example.com/st": "address 10.0.0.1 of internal.example.com is not allowed",
+		} {
+			_, err := checker.Check(&Entry{Purpose: PurposeRevocation, ListCredential: listURL}, "did:example:issuer")
+			require.EqualError(t, err, "status list credential "+listURL+": "+msg)
+		}
+
+		checker.lookupIP = func(context.Context, string) ([]net.IP, error) {
+			return nil, errors.New("no such host")
+		}
+
+		_, err := checker.Check(&Entry{Purpose: PurposeRevocation, ListCredential: "https://example.com/status/1"},
+			"did:example:issuer")
+		require.EqualError(t, err, "status list credential https://example.com/status/1: failed to resolve "+
+			"example.com: no such host")
+	})
+
+	t.Run("do not connect to addresses that are not allowed", func(t *testing.T) {
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte(unsignedList)) // nolint:errcheck // test server
+		}))
+		defer server.Close()
+
+		checker := NewChecker(&vdrmock.MockVDRegistry{}, newDocumentLoader(t), server.Client(), DefaultCacheTTL)
+
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, server.URL, nil)
+		require.NoError(t, err)
+
+		_, err = checker.get(req)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "address 127.0.0.1 is not allowed")
+
+		checker = NewChecker(&vdrmock.MockVDRegistry{}, newDocumentLoader(t), server.Client(), DefaultCacheTTL,
+			WithAddressFilter(func(net.IP) bool { return true }))
+
+		_, err = checker.Check(&Entry{Purpose: PurposeRevocation, ListCredential: server.URL}, "did:example:issuer")
+		require.EqualError(t, err, "status list credential "+server.URL+": credential is not signed")
+	})
+
+	t.Run("reject lists that are too large", func(t *testing.T) {
+		checker := NewChecker(&vdrmock.MockVDRegistry{}, newDocumentLoader(t), &mockHTTPClient{
+			body: strings.Repeat(" ", maxListCredentialSize+1),
+		}, DefaultCacheTTL)
+		checker.lookupIP = lookupIP("93.184.216.34")
+
+		_, err := checker.Check(&Entry{Purpose: PurposeRevocation, ListCredential: "https://example.com/status/1"},
+			"did:example:issuer")
+		require.EqualError(t, err, "status list credential https://example.com/status/1: credential is larger "+
+			"than 1048576 bytes")
+	})
+
+	t.Run("bound the cache", func(t *testing.T) {
+		checker := NewChecker(&vdrmock.MockVDRegistry{}, newDocumentLoader(t), nil, DefaultCacheTTL)
+		now := time.Now()
+
+		for i := 0; i < maxCachedLists; i++ {
+			checker.cacheList(fmt.Sprintf("https://example.com/status/%d", i),
+				&cachedList{expiresAt: now.Add(time.Duration(i+1) * time.Minute)})
+		}
+
+		checker.cache["https://example.com/status/7"].expiresAt = now.Add(-time.Minute)
+
+		checker.cacheList("https://example.com/status/new", &cachedList{expiresAt: now.Add(time.Hour)})
+		require.Len(t, checker.cache, maxCachedLists)
+		require.NotContains(t, checker.cache, "https://example.com/status/7")
+		require.Contains(t, checker.cache, "https://example.com/status/0")
+
+		checker.cacheList("https://example.com/status/other", &cachedList{expiresAt: now.Add(time.Hour)})
+		require.Len(t, checker.cache, maxCachedLists)
+		require.NotContains(t, checker.cache, "https://example.com/status/0")
+	})
+}
+
+func TestDecodeList(t *testing.T) {
+	var compressed bytes.Buffer
+
+	writer := gzip.NewWriter(&compressed)
+	_, err := writer.Write([]byte{0x80, 0x01})
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	for _, encoding := range []*base64.Encoding{base64.RawURLEncoding, base64.StdEncoding} {
+		bits, err := decodeList(encoding.EncodeToString(compressed.Bytes()))
+		require.NoError(t, err)
+		require.Equal(t, []byte{0x80, 0x01}, bits)
+	}
+
+	_, err = decodeList("")
+	require.EqualError(t, err, "missing encoded list")
+
+	_, err = decodeList("!")
+	require.Error(t, err)
+
+	_, err = decodeList(base64.StdEncoding.EncodeToString([]byte("not gzip")))
+	require.Error(t, err)
+
+	compressed.Reset()
+	writer = gzip.NewWriter(&compressed)
+	_, err = writer.Write(make([]byte, maxListSize+1))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	_, err = decodeList(base64.StdEncoding.EncodeToString(compressed.Bytes()))
+	require.EqualError(t, err, "decoded list is larger than 16777216 bytes")
+}
+
+// lookupIP returns a resolver resolving all hosts to the given addresses.
+func lookupIP(addresses ...string) func(context.Context, string) ([]net.IP, error) {
+	return func(context.Context, string) ([]net.IP, error) {
+		ips := make([]net.IP, len(addresses))
+
+		for i, address := range addresses {
+			ips[i] = net.ParseIP(address)
+		}
+
+		return ips, nil
+	}
+}
+
+func newDocumentLoader(t *testing.T) *ld.DocumentLoader {
+	t.Helper()
+
+	loader, err := ld.NewDocumentLoader(&mockLDStoreProvider{
+		ContextStore:        mockldstore.NewMockContextStore(),
+		RemoteProviderStore: mockldstore.NewMockRemoteProviderStore(),
+	})
+	require.NoError(t, err)
+
+	return loader
+}
+
+type mockLDStoreProvider struct {
+	ContextStore        ldstore.ContextStore
+	RemoteProviderStore ldstore.RemoteProviderStore
+}
+
+func (p *mockLDStoreProvider) JSONLDContextStore() ldstore.ContextStore {
+	return p.ContextStore
+}
+
+func (p *mockLDStoreProvider) JSONLDRemoteProviderStore() ldstore.RemoteProviderStore {
+	return p.RemoteProviderStore
+}
+
+type mockHTTPClient struct {
+	body string
+}
+
+func (c *mockHTTPClient) Do(*http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       ioutil.NopCloser(bytes.NewBufferString(c.body)),
+	}, nil
+}
@@ -50,6 +50,10 @@ mkdir -p test/fixtures/keys/session_cookies
 openssl rand -out test/fixtures/keys/session_cookies/auth.key 32
 openssl rand -out test/fixtures/keys/session_cookies/enc.key 32
 
+#create key-encryption key for user data at rest
+mkdir -p test/fixtures/keys/storage
+openssl rand -out test/fixtures/keys/storage/kek-v1.key 32
+
 #create master key for secret lock
 openssl rand 32 | base64 | sed 's/+/-/g; s/\//_/g' > test/fixtures/keys/tls/secret-lock.key
 
@@ -16,5 +16,6 @@ hydra clients create \
     --response-types code,id_token \
     --scope openid,profile,email \
     --skip-tls-verify \
-    --callbacks https://wallet-server.trustbloc.local:8090/oidc/callback,https://wallet-server-2.trustbloc.local:8070/oidc/callback,https://localhost:9099/oidc/callback
+    --callbacks https://wallet-server.trustbloc.local:8090/oidc/callback,https://wallet-server-2.trustbloc.local:8070/oidc/callback,https://localhost:9099/oidc/callback \
+    --post-logout-callbacks https://wallet.trustbloc.local:8091,https://localhost:9098
 echo "Finish creating demo wallet-server clients with hub-auth"